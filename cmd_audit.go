@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/zsprackett/agent-workspace/internal/audit"
+	"github.com/zsprackett/agent-workspace/internal/db"
+)
+
+// runAudit implements "agent-workspace audit": print the audit log, newest
+// first, optionally filtered.
+func runAudit(args []string) error {
+	fs := flag.NewFlagSet("audit", flag.ContinueOnError)
+	actor := fs.String("actor", "", "only actions by this user")
+	action := fs.String("action", "", `only this action, or a prefix such as "session."`)
+	target := fs.String("target", "", "only actions on this session ID (or username, group path)")
+	source := fs.String("source", "", "only actions from web, tui, cli or system")
+	since := fs.String("since", "", `only actions after this RFC 3339 time or duration ago, e.g. "24h"`)
+	until := fs.String("until", "", "only actions before this RFC 3339 time or duration ago")
+	limit := fs.Int("limit", 100, "maximum number of entries")
+	asJSON := fs.Bool("json", false, "print entries as JSON lines")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	f := db.AuditFilter{Actor: *actor, Action: *action, Target: *target, Source: *source, Limit: *limit}
+	var err error
+	if f.Since, err = audit.ParseTime(*since); err != nil {
+		return fmt.Errorf("--since: %w", err)
+	}
+	if f.Until, err = audit.ParseTime(*until); err != nil {
+		return fmt.Errorf("--until: %w", err)
+	}
+
+	store, err := openDB()
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	entries, err := store.QueryAudit(f)
+	if err != nil {
+		return err
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		for _, e := range entries {
+			if err := enc.Encode(e); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "TIME\tACTOR\tSOURCE\tIP\tACTION\tTARGET\tDETAIL\tRESULT")
+	for _, e := range entries {
+		ip := e.IP
+		if ip == "" {
+			ip = "-"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", e.Ts.Format("2006-01-02 15:04:05"),
+			e.Actor, e.Source, ip, e.Action, e.Target, e.Detail, e.Result)
+	}
+	return tw.Flush()
+}
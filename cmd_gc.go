@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/zsprackett/agent-workspace/internal/config"
+	"github.com/zsprackett/agent-workspace/internal/gc"
+)
+
+// runGC implements "agent-workspace gc": report disk usage and prune orphaned
+// worktrees, merged branches and unused bare repos.
+func runGC(args []string) error {
+	fs := flag.NewFlagSet("gc", flag.ContinueOnError)
+	dryRun := fs.Bool("dry-run", false, "report what would be removed without removing anything")
+	yes := fs.Bool("yes", false, "prune without asking for confirmation")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	cfg, err := config.Load(config.DefaultPath())
+	if err != nil {
+		return fmt.Errorf("load config: %w", err)
+	}
+	store, err := openDB()
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	report, err := gc.Scan(store, gc.Options{
+		ReposDir:     cfg.ReposDir,
+		WorktreesDir: cfg.WorktreesDir,
+	})
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "REPO\tSIZE\tREFERENCED")
+	var total int64
+	for _, r := range report.Repos {
+		fmt.Fprintf(tw, "%s\t%s\t%v\n", r.Path, gc.FormatBytes(r.Bytes), r.Referenced)
+		total += r.Bytes
+	}
+	fmt.Fprintln(tw)
+	fmt.Fprintln(tw, "SESSION\tSIZE\tWORKTREE")
+	for _, s := range report.Sessions {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", s.Title, gc.FormatBytes(s.Bytes), s.WorktreePath)
+		total += s.Bytes
+	}
+	tw.Flush()
+	fmt.Printf("\nTotal in use: %s\n\n", gc.FormatBytes(total))
+
+	if len(report.Candidates) == 0 {
+		fmt.Println("Nothing to prune.")
+		return nil
+	}
+
+	tw = tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "KIND\tTARGET\tSIZE\tREASON")
+	for _, c := range report.Candidates {
+		size := "-"
+		if c.Bytes > 0 {
+			size = gc.FormatBytes(c.Bytes)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", c.Kind, c.Label(), size, c.Reason)
+	}
+	tw.Flush()
+	fmt.Printf("\n%d item(s), %s reclaimable\n", len(report.Candidates), gc.FormatBytes(report.ReclaimableBytes()))
+
+	if *dryRun {
+		return nil
+	}
+	if !*yes {
+		fmt.Print("Prune these items? [y/N] ")
+		answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+		if a := strings.ToLower(strings.TrimSpace(answer)); a != "y" && a != "yes" {
+			fmt.Println("Aborted.")
+			return nil
+		}
+	}
+	if err := gc.Prune(report); err != nil {
+		return err
+	}
+	fmt.Println("Pruned.")
+	return nil
+}
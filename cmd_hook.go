@@ -0,0 +1,30 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/zsprackett/agent-workspace/internal/config"
+	"github.com/zsprackett/agent-workspace/internal/hooks"
+)
+
+// runHook implements "agent-workspace hook --session <id> <event>", which
+// the Claude Code hooks agent-workspace installs run. It reads the event's
+// JSON from stdin and reports it to the running agent-workspace. It never
+// fails because agent-workspace is not running: a hook must not get in
+// Claude's way.
+func runHook(args []string) error {
+	fs := flag.NewFlagSet("hook", flag.ContinueOnError)
+	sessionID := fs.String("session", "", "agent-workspace session ID")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *sessionID == "" || fs.NArg() != 1 {
+		return fmt.Errorf("usage: agent-workspace hook --session <id> <event>")
+	}
+	input, _ := io.ReadAll(io.LimitReader(os.Stdin, 1<<20))
+	hooks.Send(config.HookSocketPath(), hooks.ParseInput(*sessionID, fs.Arg(0), input))
+	return nil
+}
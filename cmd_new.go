@@ -0,0 +1,176 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/google/uuid"
+
+	"github.com/zsprackett/agent-workspace/internal/audit"
+	"github.com/zsprackett/agent-workspace/internal/budget"
+	"github.com/zsprackett/agent-workspace/internal/config"
+	"github.com/zsprackett/agent-workspace/internal/db"
+	"github.com/zsprackett/agent-workspace/internal/git"
+	"github.com/zsprackett/agent-workspace/internal/hooks"
+	"github.com/zsprackett/agent-workspace/internal/session"
+	"github.com/zsprackett/agent-workspace/internal/tmux"
+)
+
+// runNew implements "agent-workspace new": create a session without opening
+// the TUI. For groups with a repo URL a worktree is provisioned first.
+func runNew(args []string) error {
+	fs := flag.NewFlagSet("new", flag.ContinueOnError)
+	groupPath := fs.String("group", "", "group path (default: configured default group)")
+	title := fs.String("title", "", "session title (default: generated)")
+	tool := fs.String("tool", "", "tool to run (default: group or configured default)")
+	projectPath := fs.String("path", "", "project path, required for groups without a repo URL")
+	command := fs.String("command", "", "command for the custom tool")
+	baseBranch := fs.String("base", "", "base branch for the new branch (default: group base or repo default)")
+	branch := fs.String("branch", "", "branch name (default: derived from the title)")
+	checkout := fs.Bool("checkout", false, "check out the existing remote branch given by --branch")
+	var extraRepos stringList
+	fs.Var(&extraRepos, "repo", "additional repo URL to give the session a worktree of (repeatable)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if !tmux.IsAvailable() {
+		return errors.New("tmux is required but not found in PATH")
+	}
+	cfg, err := config.Load(config.DefaultPath())
+	if err != nil {
+		return fmt.Errorf("load config: %w", err)
+	}
+	store, err := openDB()
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	// Like the TUI and web API, refuse while usage is over the pause
+	// threshold.
+	if err := budget.Check(budget.Config{
+		WarnAt:     cfg.Budget.WarnAt,
+		PauseAt:    cfg.Budget.PauseAt,
+		ThrottleAt: cfg.Budget.ThrottleAt,
+	}, store); err != nil {
+		return err
+	}
+
+	if *groupPath == "" {
+		*groupPath = cfg.DefaultGroup
+	}
+	groups, err := store.LoadGroups()
+	if err != nil {
+		return err
+	}
+	var group *db.Group
+	for _, g := range groups {
+		if g.Path == *groupPath {
+			group = g
+			break
+		}
+	}
+	if group == nil {
+		// The default group is created by the TUI on first run; allow it
+		// before that has happened.
+		if *groupPath != cfg.DefaultGroup {
+			return fmt.Errorf("group %q not found", *groupPath)
+		}
+		group = &db.Group{Path: *groupPath}
+	}
+
+	opts := session.CreateOptions{
+		Title:       *title,
+		Tool:        db.Tool(*tool),
+		Command:     *command,
+		GroupPath:   group.Path,
+		ProjectPath: *projectPath,
+	}
+	if opts.Tool == "" {
+		opts.Tool = group.DefaultTool
+	}
+	if opts.Tool == "" {
+		opts.Tool = db.Tool(cfg.DefaultTool)
+	}
+	if opts.Title == "" {
+		opts.Title = session.GenerateTitle()
+	}
+
+	if group.RepoURL != "" {
+		plan, err := session.PlanWorktree(session.WorktreeOptions{
+			RepoURL:           group.RepoURL,
+			ExtraRepoURLs:     append(group.ExtraRepoURLs, extraRepos...),
+			ReposDir:          cfg.ReposDir,
+			WorktreesDir:      cfg.WorktreesDir,
+			Branch:            *branch,
+			Checkout:          *checkout,
+			BaseBranch:        *baseBranch,
+			GroupBaseBranch:   group.BaseBranch,
+			DefaultBaseBranch: cfg.Worktree.DefaultBaseBranch,
+		}, opts.Title)
+		if err != nil {
+			return err
+		}
+		if err := plan.Provision(); err != nil {
+			if !errors.Is(err, git.ErrWorktreeExists) {
+				return err
+			}
+			fmt.Printf("Reusing existing worktree(s) under %s\n", plan.Root)
+		}
+		opts.ProjectPath = plan.Root
+		opts.WorktreePath = plan.WorktreePath
+		opts.WorktreeRepo = plan.RepoPath
+		opts.WorktreeBranch = plan.Branch
+		opts.RepoURL = group.RepoURL
+		opts.Worktrees = plan.Worktrees()
+
+		// Claude reports its state through hooks; without them the monitor
+		// reads the pane.
+		if opts.Tool == db.ToolClaude {
+			opts.ID = uuid.NewString()
+			if err := hooks.Install(plan.Root, opts.ID); err != nil {
+				fmt.Fprintf(os.Stderr, "warning: install claude hooks: %v\n", err)
+			}
+		}
+	} else if opts.ProjectPath == "" {
+		return fmt.Errorf("--path is required for groups without a repo URL")
+	} else if *branch != "" || *baseBranch != "" || *checkout || len(extraRepos) > 0 {
+		return fmt.Errorf("--base, --branch, --checkout and --repo require a group with a repo URL")
+	}
+
+	if group.PreLaunchCommand != "" {
+		toolCmd := db.ToolCommand(opts.Tool, opts.Command)
+		var out string
+		if opts.WorktreeRepo != "" {
+			out, err = session.RunPreLaunchCommand(group.PreLaunchCommand, toolCmd, opts.WorktreeRepo, opts.ProjectPath)
+		} else {
+			out, err = session.RunPreLaunchCommand(group.PreLaunchCommand, toolCmd, opts.ProjectPath)
+		}
+		if err != nil {
+			return fmt.Errorf("pre-launch command failed: %w\n%s", err, out)
+		}
+	}
+
+	s, err := session.NewManager(store).Create(opts)
+	if err != nil {
+		audit.Local(store, audit.SourceCLI, audit.SessionCreate, "", opts.Title, err)
+		return err
+	}
+	audit.Local(store, audit.SourceCLI, audit.SessionCreate, s.ID, s.Title, nil)
+	fmt.Printf("Created session %s (%s) in %s\n", s.Title, s.TmuxSession, s.ProjectPath)
+	return nil
+}
+
+// stringList is a flag.Value collecting repeated string flags.
+type stringList []string
+
+func (l *stringList) String() string { return strings.Join(*l, ",") }
+
+func (l *stringList) Set(v string) error {
+	*l = append(*l, v)
+	return nil
+}
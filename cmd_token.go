@@ -0,0 +1,127 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/zsprackett/agent-workspace/internal/audit"
+	"github.com/zsprackett/agent-workspace/internal/db"
+	"github.com/zsprackett/agent-workspace/internal/webserver"
+)
+
+// runToken implements "agent-workspace token create|ls|revoke": manage the
+// long-lived API tokens scripts use instead of logging in.
+func runToken(args []string) error {
+	const usage = "usage: agent-workspace token create <username> <name> [--scope read,sessions:write,terminal] [--expires 720h] | ls [username] | revoke <id>"
+	if len(args) == 0 {
+		return errors.New(usage)
+	}
+	store, err := openDB()
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	switch args[0] {
+	case "create":
+		if len(args) < 3 {
+			return errors.New(usage)
+		}
+		return createToken(store, args[1], args[2], args[3:])
+	case "ls":
+		accountID := ""
+		if len(args) > 1 {
+			acc, err := store.GetAccountByUsername(args[1])
+			if err != nil {
+				return fmt.Errorf("user not found: %s", args[1])
+			}
+			accountID = acc.ID
+		}
+		tokens, err := store.ListAPITokens(accountID)
+		if err != nil {
+			return err
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "ID\tUSER\tNAME\tSCOPES\tCREATED\tLAST USED\tEXPIRES")
+		for _, t := range tokens {
+			scopes := make([]string, len(t.Scopes))
+			for i, s := range t.Scopes {
+				scopes[i] = string(s)
+			}
+			last, expires := "never", "never"
+			if !t.LastUsed.IsZero() {
+				last = t.LastUsed.Format("2006-01-02 15:04")
+			}
+			if !t.ExpiresAt.IsZero() {
+				expires = t.ExpiresAt.Format("2006-01-02 15:04")
+				if time.Now().After(t.ExpiresAt) {
+					expires += " (expired)"
+				}
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", t.ID, t.Username, t.Name, strings.Join(scopes, ","),
+				t.CreatedAt.Format("2006-01-02 15:04"), last, expires)
+		}
+		return tw.Flush()
+	case "revoke":
+		if len(args) != 2 {
+			return errors.New(usage)
+		}
+		err := store.DeleteAPIToken(args[1])
+		audit.Local(store, audit.SourceCLI, audit.TokenRevoke, args[1], "", err)
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("token not found: %s", args[1])
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Token revoked: %s\n", args[1])
+		return nil
+	}
+	return errors.New(usage)
+}
+
+// createToken creates a token for username and prints it. Only its hash is
+// kept, so this is the one time it is shown.
+func createToken(store *db.DB, username, name string, args []string) error {
+	fs := flag.NewFlagSet("token create", flag.ContinueOnError)
+	scope := fs.String("scope", string(db.ScopeRead), "comma-separated scopes: read, sessions:write, terminal")
+	expires := fs.Duration("expires", 0, "expire the token after this long, e.g. 720h; never if 0")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	acc, err := store.GetAccountByUsername(username)
+	if err != nil {
+		return fmt.Errorf("user not found: %s", username)
+	}
+	var scopes []db.Scope
+	for _, s := range strings.Split(*scope, ",") {
+		sc := db.Scope(strings.TrimSpace(s))
+		if !sc.Valid() {
+			return fmt.Errorf("unknown scope %q (want read, sessions:write or terminal)", sc)
+		}
+		scopes = append(scopes, sc)
+	}
+
+	token, hash, err := webserver.GenerateAPIToken()
+	if err != nil {
+		return err
+	}
+	t := &db.APIToken{AccountID: acc.ID, Name: name, Hash: hash, Scopes: scopes}
+	if *expires > 0 {
+		t.ExpiresAt = time.Now().Add(*expires)
+	}
+	err = store.CreateAPIToken(t)
+	audit.Local(store, audit.SourceCLI, audit.TokenCreate, username, name+" "+t.ID, err)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Token %s (%s) for %s, scopes %s:\n\n  %s\n\n", t.ID, name, username, *scope, token)
+	fmt.Println("It is not shown again. Send it as \"Authorization: Bearer <token>\".")
+	return nil
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/zsprackett/agent-workspace/internal/audit"
+	"github.com/zsprackett/agent-workspace/internal/db"
+	"github.com/zsprackett/agent-workspace/internal/totp"
+)
+
+// runTOTP implements "agent-workspace totp <user> [enroll|disable|recovery]":
+// show whether an account uses two-factor login, enroll it, turn it off
+// (for a lost device), or replace its recovery codes.
+func runTOTP(args []string) error {
+	const usage = "usage: agent-workspace totp <username> [enroll | disable | recovery]"
+	if len(args) == 0 || len(args) > 2 {
+		return errors.New(usage)
+	}
+	username := args[0]
+	store, err := openDB()
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	acc, err := store.GetAccountByUsername(username)
+	if err != nil {
+		return fmt.Errorf("user not found: %s", username)
+	}
+
+	cmd := ""
+	if len(args) == 2 {
+		cmd = args[1]
+	}
+	switch cmd {
+	case "":
+		if !acc.TOTPEnabled {
+			fmt.Printf("%s: two-factor login off\n", username)
+			return nil
+		}
+		n, err := store.CountRecoveryCodes(acc.ID)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s: two-factor login on, %d recovery codes left\n", username, n)
+	case "enroll":
+		if acc.TOTPEnabled {
+			return fmt.Errorf("%s already uses two-factor login; disable it first to enroll a new device", username)
+		}
+		err := enrollTOTP(store, acc)
+		audit.Local(store, audit.SourceCLI, audit.TOTPEnable, username, "", err)
+		return err
+	case "disable":
+		err := store.DisableTOTP(acc.ID)
+		audit.Local(store, audit.SourceCLI, audit.TOTPDisable, username, "", err)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Two-factor login disabled: %s\n", username)
+	case "recovery":
+		if !acc.TOTPEnabled {
+			return fmt.Errorf("%s does not use two-factor login", username)
+		}
+		codes, err := totp.RecoveryCodes(10)
+		if err == nil {
+			err = store.ReplaceRecoveryCodes(acc.ID, hashRecoveryCodes(codes))
+		}
+		audit.Local(store, audit.SourceCLI, audit.RecoveryCodes, username, "", err)
+		if err != nil {
+			return err
+		}
+		printRecoveryCodes(codes)
+	default:
+		return errors.New(usage)
+	}
+	return nil
+}
+
+// enrollTOTP shows a new secret, asks for a code from the authenticator app
+// to confirm it and turns on two-factor login.
+func enrollTOTP(store *db.DB, acc *db.Account) error {
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return err
+	}
+	if err := store.SetTOTPSecret(acc.ID, secret); err != nil {
+		return err
+	}
+	fmt.Println("Add this account to your authenticator app, by URI (for a QR code,")
+	fmt.Println("pipe it to `qrencode -t ansiutf8`) or by entering the secret:")
+	fmt.Println()
+	fmt.Println("  " + totp.URI("agent-workspace", acc.Username, secret))
+	fmt.Println("  secret: " + secret)
+	fmt.Println()
+	fmt.Print("Code from the app: ")
+	line, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	step, ok := totp.Verify(secret, strings.TrimSpace(line), time.Now())
+	if !ok {
+		return errors.New("invalid code; two-factor login not enabled")
+	}
+	codes, err := totp.RecoveryCodes(10)
+	if err != nil {
+		return err
+	}
+	if err := store.EnableTOTP(acc.ID, hashRecoveryCodes(codes)); err != nil {
+		return err
+	}
+	store.UseTOTPStep(acc.ID, step)
+	fmt.Printf("Two-factor login enabled: %s\n", acc.Username)
+	printRecoveryCodes(codes)
+	return nil
+}
+
+func hashRecoveryCodes(codes []string) []string {
+	hashes := make([]string, len(codes))
+	for i, c := range codes {
+		hashes[i] = totp.HashRecoveryCode(c)
+	}
+	return hashes
+}
+
+func printRecoveryCodes(codes []string) {
+	fmt.Println("Recovery codes (each works once in place of a code; store them safely):")
+	for _, c := range codes {
+		fmt.Println("  " + c)
+	}
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/term"
+
+	"github.com/zsprackett/agent-workspace/internal/audit"
+	"github.com/zsprackett/agent-workspace/internal/db"
+)
+
+// runAddUser implements "agent-workspace adduser <name> [--role R]". The
+// first account defaults to admin, later ones to operator.
+func runAddUser(args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: agent-workspace adduser <username> [--role admin|operator|viewer]")
+	}
+	username := args[0]
+	fs := flag.NewFlagSet("adduser", flag.ContinueOnError)
+	role := fs.String("role", "", "account role: admin, operator or viewer")
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	store, err := openDB()
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	if *role == "" {
+		*role = string(db.RoleOperator)
+		if has, err := store.HasAnyAccount(); err == nil && !has {
+			*role = string(db.RoleAdmin)
+		}
+	}
+	if !db.Role(*role).Valid() {
+		return fmt.Errorf("unknown role %q (want admin, operator or viewer)", *role)
+	}
+
+	fmt.Printf("Password for %s: ", username)
+	pw, err := term.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Println()
+	if err != nil {
+		return err
+	}
+	hash, err := bcrypt.GenerateFromPassword(pw, bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	_, err = store.CreateAccount(username, string(hash), db.Role(*role))
+	audit.Local(store, audit.SourceCLI, audit.AccountCreate, username, *role, err)
+	if err != nil {
+		return fmt.Errorf("creating account: %w", err)
+	}
+	fmt.Printf("Account created: %s (%s)\n", username, *role)
+	return nil
+}
+
+// runRole implements "agent-workspace role <name> [role] [--group path]":
+// show an account's roles, set its account-wide role, or set its role in one
+// group. "inherit" removes a group's override.
+func runRole(args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: agent-workspace role <username> [admin|operator|viewer|inherit] [--group path]")
+	}
+	username := args[0]
+	fs := flag.NewFlagSet("role", flag.ContinueOnError)
+	group := fs.String("group", "", "set the role in this group only")
+	// The role may come before or after --group.
+	rest, role := args[1:], ""
+	if len(rest) > 0 && !strings.HasPrefix(rest[0], "-") {
+		role, rest = rest[0], rest[1:]
+	}
+	if err := fs.Parse(rest); err != nil {
+		return err
+	}
+	if role == "" {
+		role = fs.Arg(0)
+	}
+
+	store, err := openDB()
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	acc, err := store.GetAccountByUsername(username)
+	if err != nil {
+		return fmt.Errorf("user not found: %s", username)
+	}
+
+	switch {
+	case role == "":
+		perms, err := store.GetGroupPermissions(acc.ID)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s: %s\n", acc.Username, acc.Role)
+		paths := make([]string, 0, len(perms))
+		for p := range perms {
+			paths = append(paths, p)
+		}
+		sort.Strings(paths)
+		for _, p := range paths {
+			fmt.Printf("  %s: %s\n", p, perms[p])
+		}
+		return nil
+	case *group != "":
+		r := db.Role(role)
+		if role == "inherit" {
+			r = ""
+		} else if !r.Valid() {
+			return fmt.Errorf("unknown role %q (want admin, operator, viewer or inherit)", role)
+		}
+		err := store.SetGroupPermission(acc.ID, *group, r)
+		audit.Local(store, audit.SourceCLI, audit.AccountRole, username, *group+": "+role, err)
+		if err != nil {
+			return err
+		}
+	default:
+		if !db.Role(role).Valid() {
+			return fmt.Errorf("unknown role %q (want admin, operator or viewer)", role)
+		}
+		err := store.UpdateAccountRole(acc.ID, db.Role(role))
+		audit.Local(store, audit.SourceCLI, audit.AccountRole, username, role, err)
+		if err != nil {
+			return err
+		}
+	}
+	// Roles are carried in access tokens; revoking refresh tokens makes the
+	// change take effect once the current access token expires.
+	store.DeleteRefreshTokensByAccount(acc.ID)
+	fmt.Printf("Role updated: %s (all sessions invalidated)\n", username)
+	return nil
+}
+
+// runUser implements "agent-workspace user ls|rm|disable|enable|logins|revoke".
+func runUser(args []string) error {
+	const usage = "usage: agent-workspace user ls | rm <username> | disable <username> | enable <username> | logins <username> | revoke <username> [login-id]"
+	if len(args) == 0 {
+		return errors.New(usage)
+	}
+	store, err := openDB()
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	store.DeleteExpiredRefreshTokens()
+
+	if args[0] == "ls" {
+		accounts, err := store.ListAccounts()
+		if err != nil {
+			return err
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "USERNAME\tROLE\tSTATUS\t2FA\tLAST LOGIN\tLOGINS")
+		for _, acc := range accounts {
+			status, twoFactor, last := "active", "off", "never"
+			if acc.Disabled {
+				status = "disabled"
+			}
+			if acc.TOTPEnabled {
+				twoFactor = "on"
+			}
+			if !acc.LastLogin.IsZero() {
+				last = acc.LastLogin.Format("2006-01-02 15:04")
+			}
+			logins, _ := store.ListRefreshTokens(acc.ID)
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%d\n", acc.Username, acc.Role, status, twoFactor, last, len(logins))
+		}
+		return tw.Flush()
+	}
+
+	if len(args) < 2 {
+		return errors.New(usage)
+	}
+	username := args[1]
+	acc, err := store.GetAccountByUsername(username)
+	if err != nil {
+		return fmt.Errorf("user not found: %s", username)
+	}
+	switch args[0] {
+	case "rm":
+		err := store.DeleteAccount(acc.ID)
+		audit.Local(store, audit.SourceCLI, audit.AccountDelete, username, "", err)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Account deleted: %s\n", username)
+	case "disable", "enable":
+		disabled := args[0] == "disable"
+		err := store.SetAccountDisabled(acc.ID, disabled)
+		audit.Local(store, audit.SourceCLI, audit.AccountUpdate, username, fmt.Sprintf("disabled=%v", disabled), err)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Account %sd: %s\n", args[0], username)
+	case "logins":
+		logins, err := store.ListRefreshTokens(acc.ID)
+		if err != nil {
+			return err
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "ID\tLOGGED IN\tLAST USED\tIP\tCLIENT")
+		for _, rt := range logins {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", rt.LoginID, rt.LoginAt.Format("2006-01-02 15:04"),
+				rt.LastUsed.Format("2006-01-02 15:04"), rt.IP, rt.UserAgent)
+		}
+		return tw.Flush()
+	case "revoke":
+		var loginID string
+		if len(args) > 2 {
+			loginID = args[2]
+			err = store.DeleteLogin(acc.ID, loginID)
+		} else {
+			err = store.DeleteRefreshTokensByAccount(acc.ID)
+		}
+		audit.Local(store, audit.SourceCLI, audit.LoginRevoke, username, loginID, err)
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("login not found: %s", loginID)
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Logins revoked: %s\n", username)
+	default:
+		return errors.New(usage)
+	}
+	return nil
+}
@@ -0,0 +1,297 @@
+// Package analytics reports how sessions spend their time, from the status
+// changes the monitor records: how long agents ran, waited for a human and
+// sat idle, how long waiting prompts took to answer, and how many sessions
+// were created and completed each day.
+package analytics
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/zsprackett/agent-workspace/internal/db"
+)
+
+// Stats is the time spent in each state, in seconds, and how quickly
+// waiting prompts were answered.
+type Stats struct {
+	RunningSec float64 `json:"running_sec"`
+	WaitingSec float64 `json:"waiting_sec"`
+	IdleSec    float64 `json:"idle_sec"`
+	// Responses counts waiting prompts answered, that is waiting periods
+	// that ended with the agent running again.
+	Responses      int     `json:"responses"`
+	AvgResponseSec float64 `json:"avg_response_sec"`
+
+	responseSec float64 // total, for the average
+}
+
+// BlockedShare returns the share of the time an agent was working or
+// waiting that it spent waiting, from 0 to 1.
+func (s Stats) BlockedShare() float64 {
+	if active := s.RunningSec + s.WaitingSec; active > 0 {
+		return s.WaitingSec / active
+	}
+	return 0
+}
+
+func (s *Stats) add(o Stats) {
+	s.RunningSec += o.RunningSec
+	s.WaitingSec += o.WaitingSec
+	s.IdleSec += o.IdleSec
+	s.Responses += o.Responses
+	s.responseSec += o.responseSec
+	s.finish()
+}
+
+func (s *Stats) finish() {
+	if s.Responses > 0 {
+		s.AvgResponseSec = s.responseSec / float64(s.Responses)
+	}
+}
+
+func (s Stats) empty() bool {
+	return s.RunningSec == 0 && s.WaitingSec == 0 && s.IdleSec == 0 && s.Responses == 0
+}
+
+type SessionStats struct {
+	ID      string `json:"id"`
+	Title   string `json:"title"`
+	Group   string `json:"group"`
+	Tool    string `json:"tool"`
+	Deleted bool   `json:"deleted,omitempty"`
+	Stats
+}
+
+type GroupStats struct {
+	Group    string `json:"group"`
+	Sessions int    `json:"sessions"`
+	Stats
+}
+
+// Day counts the sessions created and completed (deleted) on a local date.
+type Day struct {
+	Date      string `json:"date"` // YYYY-MM-DD
+	Created   int    `json:"created"`
+	Completed int    `json:"completed"`
+}
+
+// Report covers the period from Since to Until. Sessions and groups are
+// sorted by the time they spent waiting, most first.
+type Report struct {
+	Since    time.Time      `json:"since"`
+	Until    time.Time      `json:"until"`
+	Total    Stats          `json:"total"`
+	Sessions []SessionStats `json:"sessions"`
+	Groups   []GroupStats   `json:"groups"`
+	Days     []Day          `json:"days"`
+}
+
+// statusChanged is the event the monitor records for each status change;
+// the other event types end whatever state a session was in.
+const statusChanged = "status_changed"
+
+var eventTypes = []string{statusChanged, "stopped", "restarted", "deleting", "deleted"}
+
+// Compute builds the report for the days days up to now.
+func Compute(store *db.DB, days int) (*Report, error) {
+	until := time.Now()
+	return ComputeRange(store, until.AddDate(0, 0, -days), until)
+}
+
+// ComputeRange builds the report for the period from since to until.
+func ComputeRange(store *db.DB, since, until time.Time) (*Report, error) {
+	records, err := store.SessionRecords()
+	if err != nil {
+		return nil, err
+	}
+	evts, err := store.SessionEventsOfType(since, until, eventTypes...)
+	if err != nil {
+		return nil, err
+	}
+	return Build(records, evts, since, until), nil
+}
+
+// period is a state a session entered at a time.
+type period struct {
+	status db.SessionStatus
+	start  time.Time
+}
+
+// Build computes the report for the period from since to until from
+// sessions and their events, oldest first. Events before since only set
+// the state each session was in at since.
+func Build(records []db.SessionRecord, evts []db.SessionEvent, since, until time.Time) *Report {
+	byID := make(map[string]db.SessionRecord, len(records))
+	for _, r := range records {
+		byID[r.ID] = r
+	}
+	stats := make(map[string]*Stats)
+	stat := func(id string) *Stats {
+		if stats[id] == nil {
+			stats[id] = &Stats{}
+		}
+		return stats[id]
+	}
+	// credit adds the part of a period inside the report to its state.
+	credit := func(st *Stats, p *period, end time.Time) {
+		start := p.start
+		if start.Before(since) {
+			start = since
+		}
+		if end.After(until) {
+			end = until
+		}
+		if !end.After(start) {
+			return
+		}
+		switch d := end.Sub(start).Seconds(); p.status {
+		case db.StatusRunning:
+			st.RunningSec += d
+		case db.StatusWaiting:
+			st.WaitingSec += d
+		case db.StatusIdle:
+			st.IdleSec += d
+		}
+	}
+
+	current := make(map[string]*period)
+	for _, e := range evts {
+		st := stat(e.SessionID)
+		cur := current[e.SessionID]
+		if cur != nil {
+			credit(st, cur, e.Ts)
+		}
+		delete(current, e.SessionID)
+		if e.EventType != statusChanged {
+			continue
+		}
+		var change struct {
+			To db.SessionStatus `json:"to"`
+		}
+		if json.Unmarshal([]byte(e.Detail), &change) != nil {
+			continue
+		}
+		if cur != nil && cur.status == db.StatusWaiting && change.To == db.StatusRunning &&
+			!e.Ts.Before(since) && !e.Ts.After(until) {
+			st.Responses++
+			st.responseSec += e.Ts.Sub(cur.start).Seconds()
+		}
+		current[e.SessionID] = &period{status: change.To, start: e.Ts}
+	}
+	// A live session still in the state it last changed to is in it now.
+	for id, cur := range current {
+		if r, ok := byID[id]; ok && r.DeletedAt.IsZero() && r.Status == cur.status {
+			credit(stat(id), cur, until)
+		}
+	}
+
+	rep := &Report{Since: since, Until: until, Sessions: []SessionStats{}, Groups: []GroupStats{}}
+	groups := make(map[string]*GroupStats)
+	for id, st := range stats {
+		st.finish()
+		if st.empty() {
+			continue
+		}
+		r := byID[id]
+		rep.Sessions = append(rep.Sessions, SessionStats{
+			ID: id, Title: r.Title, Group: r.GroupPath, Tool: string(r.Tool),
+			Deleted: !r.DeletedAt.IsZero(), Stats: *st,
+		})
+		g := groups[r.GroupPath]
+		if g == nil {
+			g = &GroupStats{Group: r.GroupPath}
+			groups[r.GroupPath] = g
+		}
+		g.Sessions++
+		g.add(*st)
+		rep.Total.add(*st)
+	}
+	for _, g := range groups {
+		rep.Groups = append(rep.Groups, *g)
+	}
+	sort.Slice(rep.Sessions, func(i, j int) bool {
+		a, b := rep.Sessions[i], rep.Sessions[j]
+		return moreBlocked(a.Stats, b.Stats, a.ID, b.ID)
+	})
+	sort.Slice(rep.Groups, func(i, j int) bool {
+		a, b := rep.Groups[i], rep.Groups[j]
+		return moreBlocked(a.Stats, b.Stats, a.Group, b.Group)
+	})
+
+	day := func(t time.Time) string { return t.Local().Format(time.DateOnly) }
+	index := make(map[string]int)
+	for d := since.Local(); ; d = d.AddDate(0, 0, 1) {
+		if day(d) > day(until) {
+			break
+		}
+		index[day(d)] = len(rep.Days)
+		rep.Days = append(rep.Days, Day{Date: day(d)})
+	}
+	for _, r := range records {
+		if i, ok := index[day(r.CreatedAt)]; ok && !r.CreatedAt.Before(since) && !r.CreatedAt.After(until) {
+			rep.Days[i].Created++
+		}
+		if i, ok := index[day(r.DeletedAt)]; ok && !r.DeletedAt.IsZero() && !r.DeletedAt.Before(since) && !r.DeletedAt.After(until) {
+			rep.Days[i].Completed++
+		}
+	}
+	return rep
+}
+
+// moreBlocked orders by waiting time, then running time, then key.
+func moreBlocked(a, b Stats, ka, kb string) bool {
+	if a.WaitingSec != b.WaitingSec {
+		return a.WaitingSec > b.WaitingSec
+	}
+	if a.RunningSec != b.RunningSec {
+		return a.RunningSec > b.RunningSec
+	}
+	return ka < kb
+}
+
+// Tables that WriteCSV can write.
+const (
+	TableSessions = "sessions"
+	TableGroups   = "groups"
+	TableDays     = "days"
+)
+
+var Tables = []string{TableSessions, TableGroups, TableDays}
+
+// WriteCSV writes one table of the report as CSV with a header row.
+// Durations are in seconds.
+func (r *Report) WriteCSV(w io.Writer, table string) error {
+	cw := csv.NewWriter(w)
+	sec := func(v float64) string { return strconv.FormatFloat(v, 'f', 0, 64) }
+	stats := func(s Stats) []string {
+		return []string{sec(s.RunningSec), sec(s.WaitingSec), sec(s.IdleSec),
+			strconv.Itoa(s.Responses), sec(s.AvgResponseSec), strconv.FormatFloat(s.BlockedShare(), 'f', 3, 64)}
+	}
+	statsHeader := []string{"running_sec", "waiting_sec", "idle_sec", "responses", "avg_response_sec", "blocked_share"}
+	switch table {
+	case TableSessions:
+		cw.Write(append([]string{"id", "title", "group", "tool", "deleted"}, statsHeader...))
+		for _, s := range r.Sessions {
+			cw.Write(append([]string{s.ID, s.Title, s.Group, s.Tool, strconv.FormatBool(s.Deleted)}, stats(s.Stats)...))
+		}
+	case TableGroups:
+		cw.Write(append([]string{"group", "sessions"}, statsHeader...))
+		for _, g := range r.Groups {
+			cw.Write(append([]string{g.Group, strconv.Itoa(g.Sessions)}, stats(g.Stats)...))
+		}
+	case TableDays:
+		cw.Write([]string{"date", "created", "completed"})
+		for _, d := range r.Days {
+			cw.Write([]string{d.Date, strconv.Itoa(d.Created), strconv.Itoa(d.Completed)})
+		}
+	default:
+		return fmt.Errorf("unknown table %q", table)
+	}
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,88 @@
+package analytics_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zsprackett/agent-workspace/internal/analytics"
+	"github.com/zsprackett/agent-workspace/internal/db"
+)
+
+func change(id string, ts time.Time, from, to db.SessionStatus) db.SessionEvent {
+	return db.SessionEvent{SessionID: id, Ts: ts, EventType: "status_changed",
+		Detail: `{"from":"` + string(from) + `","to":"` + string(to) + `"}`}
+}
+
+func TestBuild(t *testing.T) {
+	since := time.Date(2026, 3, 2, 0, 0, 0, 0, time.Local)
+	until := since.Add(24 * time.Hour)
+	at := func(h, m int) time.Time { return since.Add(time.Duration(h)*time.Hour + time.Duration(m)*time.Minute) }
+
+	records := []db.SessionRecord{
+		{ID: "a", Title: "swift-fox", GroupPath: "api", Tool: db.ToolClaude, Status: db.StatusWaiting, CreatedAt: at(-2, 0)},
+		{ID: "b", Title: "calm-owl", GroupPath: "api", Tool: db.ToolClaude, CreatedAt: at(1, 0), DeletedAt: at(5, 0)},
+	}
+	evts := []db.SessionEvent{
+		// Running from before the report; only the part inside counts.
+		change("a", at(-1, 0), db.StatusIdle, db.StatusRunning),
+		change("a", at(1, 0), db.StatusRunning, db.StatusWaiting),
+		change("a", at(1, 10), db.StatusWaiting, db.StatusRunning),
+		// Still waiting at the end of the report.
+		change("a", at(23, 0), db.StatusRunning, db.StatusWaiting),
+
+		change("b", at(1, 0), "", db.StatusRunning),
+		change("b", at(2, 0), db.StatusRunning, db.StatusWaiting),
+		change("b", at(2, 30), db.StatusWaiting, db.StatusRunning),
+		change("b", at(3, 0), db.StatusRunning, db.StatusIdle),
+		{SessionID: "b", Ts: at(4, 0), EventType: "deleting"},
+	}
+	rep := analytics.Build(records, evts, since, until)
+
+	if len(rep.Sessions) != 2 || rep.Sessions[0].ID != "a" {
+		t.Fatalf("expected a first, waiting longest, got %+v", rep.Sessions)
+	}
+	a, b := rep.Sessions[0], rep.Sessions[1]
+	if a.RunningSec != (60+21*60+50)*60 || a.WaitingSec != (10+60)*60 || a.Responses != 1 || a.AvgResponseSec != 600 {
+		t.Errorf("unexpected stats for a: %+v", a.Stats)
+	}
+	if b.RunningSec != 90*60 || b.WaitingSec != 30*60 || b.IdleSec != 3600 || !b.Deleted || b.Title != "calm-owl" {
+		t.Errorf("unexpected stats for b: %+v", b)
+	}
+	if len(rep.Groups) != 1 || rep.Groups[0].Sessions != 2 || rep.Groups[0].Responses != 2 || rep.Groups[0].AvgResponseSec != 1200 {
+		t.Errorf("unexpected groups %+v", rep.Groups)
+	}
+	if rep.Total.WaitingSec != a.WaitingSec+b.WaitingSec {
+		t.Errorf("unexpected total %+v", rep.Total)
+	}
+
+	if len(rep.Days) != 2 || rep.Days[0].Date != "2026-03-02" || rep.Days[0].Created != 1 || rep.Days[0].Completed != 1 {
+		t.Errorf("unexpected days %+v", rep.Days)
+	}
+
+	var buf bytes.Buffer
+	if err := rep.WriteCSV(&buf, analytics.TableSessions); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 3 || !strings.HasPrefix(lines[0], "id,title,group,tool,deleted,running_sec") ||
+		!strings.HasPrefix(lines[1], "a,swift-fox,api,claude,false,82200,4200,0,1,600,") {
+		t.Errorf("unexpected CSV:\n%s", buf.String())
+	}
+	if err := rep.WriteCSV(&buf, "nope"); err == nil {
+		t.Error("expected an unknown table to fail")
+	}
+}
+
+func TestBuildStaleState(t *testing.T) {
+	since := time.Now().Add(-time.Hour)
+	records := []db.SessionRecord{{ID: "a", Status: db.StatusIdle, CreatedAt: since}}
+	evts := []db.SessionEvent{change("a", since.Add(30*time.Minute), db.StatusIdle, db.StatusRunning)}
+
+	// The session is idle now, so the running period was not seen to end
+	// and is not counted.
+	if rep := analytics.Build(records, evts, since, since.Add(time.Hour)); len(rep.Sessions) != 0 {
+		t.Errorf("expected no stats, got %+v", rep.Sessions)
+	}
+}
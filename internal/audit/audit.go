@@ -0,0 +1,147 @@
+// Package audit records who did what to which session in the append-only
+// audit_log table.
+package audit
+
+import (
+	"os"
+	"os/user"
+	"time"
+
+	"github.com/zsprackett/agent-workspace/internal/db"
+	"github.com/zsprackett/agent-workspace/internal/events"
+)
+
+// Sources of an action.
+const (
+	SourceWeb = "web"
+	SourceTUI = "tui"
+	SourceCLI = "cli"
+	// SourceSystem marks changes agent-workspace made on its own; the
+	// actor is SystemActor.
+	SourceSystem = "system"
+	SystemActor  = "agent-workspace"
+)
+
+// Actions. Targets are session IDs unless noted.
+const (
+	SessionCreate     = "session.create"
+	SessionFork       = "session.fork"
+	SessionDelete     = "session.delete"
+	SessionStop       = "session.stop"
+	SessionRestart    = "session.restart"
+	SessionEdit       = "session.edit"
+	SessionMove       = "session.move"
+	SessionNotes      = "session.notes"
+	SessionInput      = "session.input" // text sent to the agent, e.g. review comments
+	TerminalOpen      = "terminal.open"
+	ShareCreate       = "share.create" // detail is the link ID and lifetime
+	ShareRevoke       = "share.revoke" // detail is the link ID
+	GitStage          = "git.stage"
+	GitUnstage        = "git.unstage"
+	GitDiscard        = "git.discard"
+	GitCommit         = "git.commit"
+	ReviewComment     = "review.comment"
+	ReviewDelete      = "review.delete"
+	GroupCreate       = "group.create" // target is the group path
+	GroupEdit         = "group.edit"
+	GroupDelete       = "group.delete"
+	CheckpointCreate  = "checkpoint.create"
+	CheckpointRestore = "checkpoint.restore"
+	Login             = "auth.login" // target is the username
+	AccountCreate     = "account.create"
+	AccountRole       = "account.role"
+	AccountPassword   = "account.password"
+	AccountUpdate     = "account.update" // role, groups, password or disabled
+	AccountDelete     = "account.delete"
+	LoginRevoke       = "account.revoke" // detail is the login ID, empty for all
+	TOTPEnable        = "account.totp_enable"
+	TOTPDisable       = "account.totp_disable"
+	RecoveryCodes     = "account.recovery_codes" // recovery codes regenerated
+	TokenCreate       = "token.create"           // target is the username, detail the token name and ID
+	TokenRevoke       = "token.revoke"
+)
+
+// Results other than an error message.
+const (
+	ResultOK     = "ok"
+	ResultDenied = "denied"
+)
+
+// Result maps an action's error to the result recorded for it.
+func Result(err error) string {
+	if err != nil {
+		return err.Error()
+	}
+	return ResultOK
+}
+
+// LocalUser returns the OS user running the TUI or CLI.
+func LocalUser() string {
+	if u, err := user.Current(); err == nil && u.Username != "" {
+		return u.Username
+	}
+	if name := os.Getenv("USER"); name != "" {
+		return name
+	}
+	return "unknown"
+}
+
+// Local records an action taken from the TUI or CLI by the local user.
+// Failing to record is not fatal to the action, so errors are dropped.
+func Local(store *db.DB, source, action, target, detail string, err error) {
+	store.InsertAudit(&db.AuditEntry{
+		Actor:  LocalUser(),
+		Source: source,
+		Action: action,
+		Target: target,
+		Detail: detail,
+		Result: Result(err),
+	})
+}
+
+// System records an action agent-workspace took on its own, such as
+// throttling a session to stay within the usage budget.
+func System(store *db.DB, action, target, detail string, err error) {
+	store.InsertAudit(&db.AuditEntry{
+		Actor:  SystemActor,
+		Source: SourceSystem,
+		Action: action,
+		Target: target,
+		Detail: detail,
+		Result: Result(err),
+	})
+}
+
+// HandleEvent records the changes on the event bus that no user action
+// accounts for: a session stopping because its tmux session ended, and a
+// worktree session failing to provision. Subscribe it to the bus as a
+// durable subscriber.
+func HandleEvent(store *db.DB) func(events.Event) {
+	return func(e events.Event) {
+		entry := &db.AuditEntry{Actor: SystemActor, Source: SourceSystem, Target: e.SessionID, Detail: e.Title}
+		switch {
+		case e.Type == events.StatusChanged && e.Status == db.StatusStopped:
+			entry.Action = SessionStop
+			entry.Result = ResultOK
+			entry.Detail += " (tmux session ended)"
+		case e.Type == events.ProvisioningProgress && e.Step == events.StepFailed:
+			entry.Action = SessionCreate
+			entry.Result = e.Message
+		default:
+			return
+		}
+		store.InsertAudit(entry)
+	}
+}
+
+// ParseTime parses a filter bound given as an RFC 3339 time or as a duration
+// back from now ("24h"). Empty input gives the zero time.
+func ParseTime(v string) (time.Time, error) {
+	if v == "" {
+		return time.Time{}, nil
+	}
+	if d, err := time.ParseDuration(v); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	return time.Parse(time.RFC3339, v)
+}
@@ -0,0 +1,61 @@
+package audit_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/zsprackett/agent-workspace/internal/audit"
+	"github.com/zsprackett/agent-workspace/internal/db"
+	"github.com/zsprackett/agent-workspace/internal/events"
+)
+
+func TestResult(t *testing.T) {
+	if got := audit.Result(nil); got != audit.ResultOK {
+		t.Errorf("nil error: got %q", got)
+	}
+	if got := audit.Result(errors.New("boom")); got != "boom" {
+		t.Errorf("error: got %q", got)
+	}
+}
+
+func TestParseTime(t *testing.T) {
+	if got, err := audit.ParseTime(""); err != nil || !got.IsZero() {
+		t.Errorf("empty: got %v, %v", got, err)
+	}
+	got, err := audit.ParseTime("2h")
+	if err != nil || time.Since(got) < 2*time.Hour || time.Since(got) > 2*time.Hour+time.Minute {
+		t.Errorf("duration: got %v, %v", got, err)
+	}
+	got, err = audit.ParseTime("2026-01-02T03:04:05Z")
+	if err != nil || !got.Equal(time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)) {
+		t.Errorf("RFC 3339: got %v, %v", got, err)
+	}
+	if _, err := audit.ParseTime("yesterday"); err == nil {
+		t.Error("expected an error for an unparseable time")
+	}
+}
+
+func TestHandleEvent(t *testing.T) {
+	store, _ := db.Open(":memory:")
+	store.Migrate()
+	defer store.Close()
+	record := audit.HandleEvent(store)
+
+	record(events.Event{Type: events.StatusChanged, SessionID: "s1", Title: "fox", Status: db.StatusWaiting})
+	record(events.Event{Type: events.StatusChanged, SessionID: "s1", Title: "fox", Status: db.StatusStopped})
+	record(events.Event{Type: events.ProvisioningProgress, SessionID: "s2", Title: "owl", Step: events.StepStarting})
+	record(events.Event{Type: events.ProvisioningProgress, SessionID: "s2", Title: "owl", Step: events.StepFailed, Message: "clone failed"})
+
+	entries, err := store.QueryAudit(db.AuditFilter{})
+	if err != nil || len(entries) != 2 {
+		t.Fatalf("entries: %+v, %v", entries, err)
+	}
+	// Newest first.
+	if e := entries[0]; e.Action != audit.SessionCreate || e.Target != "s2" || e.Result != "clone failed" || e.Source != audit.SourceSystem {
+		t.Errorf("provisioning failure: %+v", e)
+	}
+	if e := entries[1]; e.Action != audit.SessionStop || e.Target != "s1" || e.Result != audit.ResultOK || e.Actor != audit.SystemActor {
+		t.Errorf("stop: %+v", e)
+	}
+}
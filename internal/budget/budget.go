@@ -0,0 +1,352 @@
+// Package budget holds Claude usage to configured thresholds. It projects
+// when each rate-limit window will run out from the trend of usage
+// snapshots, alerts as usage crosses the thresholds, pauses new sessions
+// and, if configured, interrupts or stops running ones.
+package budget
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/zsprackett/agent-workspace/internal/audit"
+	"github.com/zsprackett/agent-workspace/internal/db"
+	"github.com/zsprackett/agent-workspace/internal/events"
+	"github.com/zsprackett/agent-workspace/internal/notify"
+	"github.com/zsprackett/agent-workspace/internal/session"
+	"github.com/zsprackett/agent-workspace/internal/tmux"
+)
+
+// Budget levels, from least to most severe.
+const (
+	LevelOK        = "ok"
+	LevelWarn      = "warn"
+	LevelPaused    = "paused"    // new sessions are refused
+	LevelThrottled = "throttled" // running sessions were throttled too
+)
+
+var levels = []string{LevelOK, LevelWarn, LevelPaused, LevelThrottled}
+
+func rank(level string) int {
+	for i, l := range levels {
+		if l == level {
+			return i
+		}
+	}
+	return 0
+}
+
+// Throttle actions.
+const (
+	ActionEscape = "escape"
+	ActionStop   = "stop"
+)
+
+// ErrPaused is returned by CheckCreate while usage is over the pause
+// threshold.
+var ErrPaused = errors.New("new sessions are paused: Claude usage is over budget")
+
+// historySize is how many snapshots the trend is fitted to; at the default
+// poll interval of 10 minutes, the last 8 hours.
+const historySize = 48
+
+// Config sets the thresholds, in percent of the fuller window. A threshold
+// of 0 is off.
+type Config struct {
+	WarnAt         float64
+	PauseAt        float64
+	ThrottleAt     float64
+	ThrottleAction string // ActionEscape, ActionStop or "" for none
+	KeepRunning    int    // most recently used sessions spared by throttling
+}
+
+// Level returns the level utilization util, in percent, is at.
+func (c Config) Level(util float64) string {
+	switch {
+	case c.ThrottleAt > 0 && util >= c.ThrottleAt:
+		return LevelThrottled
+	case c.PauseAt > 0 && util >= c.PauseAt:
+		return LevelPaused
+	case c.WarnAt > 0 && util >= c.WarnAt:
+		return LevelWarn
+	}
+	return LevelOK
+}
+
+// Forecast projects a window from snapshots, newest first, by fitting a
+// line to those taken since the window last reset.
+func Forecast(history []db.UsageSnapshot, util func(db.UsageSnapshot) float64, resetsAt func(db.UsageSnapshot) int64) db.UsageForecast {
+	if len(history) == 0 {
+		return db.UsageForecast{}
+	}
+	latest := history[0]
+	f := db.UsageForecast{Util: util(latest), ResetsAt: resetsAt(latest)}
+
+	// Least squares over (hours before latest, utilization). Going back in
+	// time utilization only falls, until the snapshot before a reset.
+	var n, sx, sy, sxx, sxy float64
+	for i, s := range history {
+		if i > 0 && util(s) > util(history[i-1]) {
+			break
+		}
+		x := float64(s.TsMs-latest.TsMs) / float64(time.Hour/time.Millisecond)
+		y := util(s)
+		n++
+		sx += x
+		sy += y
+		sxx += x * x
+		sxy += x * y
+	}
+	if d := n*sxx - sx*sx; n >= 2 && d > 0 {
+		f.Rate = (n*sxy - sx*sy) / d
+	}
+
+	switch {
+	case f.Util >= 100:
+		f.LimitAt = latest.TsMs
+	case f.Rate > 0:
+		f.LimitAt = latest.TsMs + int64((100-f.Util)/f.Rate*float64(time.Hour/time.Millisecond))
+		if f.ResetsAt > 0 && f.LimitAt >= f.ResetsAt {
+			f.LimitAt = 0
+		}
+	}
+	return f
+}
+
+// Evaluate returns the budget status for snapshots, newest first.
+func Evaluate(cfg Config, history []db.UsageSnapshot) db.BudgetStatus {
+	st := db.BudgetStatus{
+		FiveHour: Forecast(history,
+			func(s db.UsageSnapshot) float64 { return s.FiveHourUtil },
+			func(s db.UsageSnapshot) int64 { return s.FiveHourResetsAt }),
+		SevenDay: Forecast(history,
+			func(s db.UsageSnapshot) float64 { return s.SevenDayUtil },
+			func(s db.UsageSnapshot) int64 { return s.SevenDayResetsAt }),
+	}
+	st.Level = cfg.Level(max(st.FiveHour.Util, st.SevenDay.Util))
+	if len(history) > 0 {
+		st = expire(cfg, st, history[0].TsMs, time.Now())
+	}
+	return st
+}
+
+// The lengths of the usage windows, for snapshots that do not say when a
+// window resets.
+const (
+	fiveHourWindow = 5 * time.Hour
+	sevenDayWindow = 7 * 24 * time.Hour
+)
+
+// expire counts the windows of st that have reset by now as unused and
+// re-levels st. A window has reset once its ResetsAt has passed or, if that
+// is unknown, once the snapshot taken at ts is older than the window.
+func expire(cfg Config, st db.BudgetStatus, ts int64, now time.Time) db.BudgetStatus {
+	reset := func(f *db.UsageForecast, length time.Duration) {
+		if f.ResetsAt > 0 && f.ResetsAt <= now.UnixMilli() ||
+			f.ResetsAt == 0 && now.Sub(time.UnixMilli(ts)) >= length {
+			*f = db.UsageForecast{}
+		}
+	}
+	reset(&st.FiveHour, fiveHourWindow)
+	reset(&st.SevenDay, sevenDayWindow)
+	st.Level = cfg.Level(max(st.FiveHour.Util, st.SevenDay.Util))
+	return st
+}
+
+// Guard applies the budget as usage snapshots are recorded.
+type Guard struct {
+	cfg         Config
+	store       *db.DB
+	mgr         *session.Manager
+	logger      *slog.Logger
+	notifier    *notify.Notifier
+	broadcaster events.Broadcaster
+
+	mu     sync.Mutex
+	status db.BudgetStatus
+	latest int64 // when the newest snapshot was taken, Unix ms
+}
+
+// New returns a guard at the level of the latest snapshots. Reaching that
+// level at startup raises no alerts and throttles nothing.
+func New(cfg Config, store *db.DB, mgr *session.Manager, logger *slog.Logger) *Guard {
+	g := &Guard{cfg: cfg, store: store, mgr: mgr, logger: logger}
+	history, _ := store.GetUsageSnapshots(historySize)
+	g.status = Evaluate(cfg, history)
+	if len(history) > 0 {
+		g.latest = history[0].TsMs
+	}
+	return g
+}
+
+// SetNotifier sends alerts through n. Must be called before the guard is
+// subscribed.
+func (g *Guard) SetNotifier(n *notify.Notifier) {
+	g.notifier = n
+}
+
+// SetBroadcaster publishes every new status to b. Must be called before the
+// guard is subscribed.
+func (g *Guard) SetBroadcaster(b events.Broadcaster) {
+	g.broadcaster = b
+}
+
+// Status returns where usage stands. A window that has reset since the
+// last snapshot counts as unused.
+func (g *Guard) Status() db.BudgetStatus {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.latest == 0 {
+		return g.status
+	}
+	return expire(g.cfg, g.status, g.latest, time.Now())
+}
+
+// CheckCreate returns ErrPaused, with how much of the budget is used, if
+// new sessions are paused.
+func (g *Guard) CheckCreate() error {
+	return checkCreate(g.Status())
+}
+
+// Check is CheckCreate for callers without a guard, such as the new
+// command: it evaluates the latest snapshots in store against cfg.
+func Check(cfg Config, store *db.DB) error {
+	history, err := store.GetUsageSnapshots(historySize)
+	if err != nil {
+		return err
+	}
+	return checkCreate(Evaluate(cfg, history))
+}
+
+func checkCreate(st db.BudgetStatus) error {
+	if rank(st.Level) >= rank(LevelPaused) {
+		return fmt.Errorf("%w. %s", ErrPaused, describe(st))
+	}
+	return nil
+}
+
+// HandleEvent updates the status on every usage snapshot. Subscribe it to
+// the event bus as a durable subscriber.
+func (g *Guard) HandleEvent(e events.Event) {
+	if e.Type == events.UsageUpdated {
+		g.Update()
+	}
+}
+
+// Update re-evaluates the budget from the recorded snapshots, alerting and
+// throttling when usage crosses a threshold.
+func (g *Guard) Update() {
+	history, err := g.store.GetUsageSnapshots(historySize)
+	if err != nil {
+		g.logger.Warn("budget: load usage failed", "err", err)
+		return
+	}
+	st := Evaluate(g.cfg, history)
+	prev := g.Status().Level
+	g.mu.Lock()
+	g.status = st
+	if len(history) > 0 {
+		g.latest = history[0].TsMs
+	}
+	g.mu.Unlock()
+
+	switch {
+	case rank(st.Level) > rank(prev):
+		msg := describe(st)
+		switch st.Level {
+		case LevelPaused:
+			msg += " New sessions are paused."
+		case LevelThrottled:
+			msg += " New sessions are paused." + g.throttle()
+		}
+		g.logger.Warn("budget: threshold crossed", "level", st.Level, "from", prev)
+		g.alert(msg)
+	case rank(prev) >= rank(LevelPaused) && rank(st.Level) < rank(LevelPaused):
+		g.logger.Info("budget: back under the pause threshold", "level", st.Level)
+		g.alert(describe(st) + " New sessions are allowed again.")
+	}
+	if g.broadcaster != nil {
+		g.broadcaster.Broadcast(events.Event{Type: events.BudgetUpdated, Budget: &st})
+	}
+}
+
+func (g *Guard) alert(msg string) {
+	if g.notifier != nil {
+		g.notifier.Alert("Claude usage", msg)
+	}
+}
+
+// throttle applies the throttle action to every running session but the
+// KeepRunning most recently used, and describes what it did.
+func (g *Guard) throttle() string {
+	if g.cfg.ThrottleAction != ActionEscape && g.cfg.ThrottleAction != ActionStop {
+		return ""
+	}
+	sessions, err := g.store.LoadSessions()
+	if err != nil {
+		g.logger.Warn("budget: load sessions failed", "err", err)
+		return ""
+	}
+	var running []*db.Session
+	for _, s := range sessions {
+		if s.Status == db.StatusRunning && s.TmuxSession != "" {
+			running = append(running, s)
+		}
+	}
+	sort.Slice(running, func(i, j int) bool { return running[i].LastAccessed.After(running[j].LastAccessed) })
+	if len(running) <= g.cfg.KeepRunning {
+		return ""
+	}
+	throttled := 0
+	for _, s := range running[max(g.cfg.KeepRunning, 0):] {
+		var err error
+		if g.cfg.ThrottleAction == ActionEscape {
+			err = tmux.SendEscape(s.TmuxSession)
+			audit.System(g.store, audit.SessionInput, s.ID, s.Title+": Escape (usage budget)", err)
+		} else {
+			err = g.mgr.Stop(s.ID)
+			audit.System(g.store, audit.SessionStop, s.ID, s.Title+" (usage budget)", err)
+		}
+		if err != nil {
+			g.logger.Warn("budget: throttle failed", "session", s.Title, "action", g.cfg.ThrottleAction, "err", err)
+			continue
+		}
+		throttled++
+	}
+	verb := "Interrupted"
+	if g.cfg.ThrottleAction == ActionStop {
+		verb = "Stopped"
+	}
+	return fmt.Sprintf(" %s %d running sessions.", verb, throttled)
+}
+
+// describe summarizes the fuller window and its projection.
+func describe(st db.BudgetStatus) string {
+	name, f := "five-hour", st.FiveHour
+	if st.SevenDay.Util > f.Util {
+		name, f = "seven-day", st.SevenDay
+	}
+	msg := fmt.Sprintf("%.0f%% of the %s window used.", f.Util, name)
+	if f.LimitAt > 0 && f.Util < 100 {
+		msg += fmt.Sprintf(" At this rate the limit is reached in %s.", Until(f.LimitAt))
+	}
+	return msg
+}
+
+// Until formats the time from now until a Unix ms timestamp, such as
+// "1h20m" or "45m"; past times are "now".
+func Until(tsMs int64) string {
+	d := time.Until(time.UnixMilli(tsMs)).Round(time.Minute)
+	switch {
+	case d <= 0:
+		return "now"
+	case d < time.Hour:
+		return fmt.Sprintf("%dm", int(d.Minutes()))
+	case d < 24*time.Hour:
+		return fmt.Sprintf("%dh%02dm", int(d.Hours()), int(d.Minutes())%60)
+	}
+	return fmt.Sprintf("%dd%dh", int(d.Hours())/24, int(d.Hours())%24)
+}
@@ -0,0 +1,231 @@
+package budget_test
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/zsprackett/agent-workspace/internal/budget"
+	"github.com/zsprackett/agent-workspace/internal/db"
+	"github.com/zsprackett/agent-workspace/internal/events"
+	"github.com/zsprackett/agent-workspace/internal/notify"
+	"github.com/zsprackett/agent-workspace/internal/session"
+)
+
+func discardLogger() *slog.Logger {
+	return slog.New(slog.NewTextHandler(io.Discard, nil))
+}
+
+const hourMs = int64(time.Hour / time.Millisecond)
+
+// snapshots returns five-hour snapshots, newest first, taken an hour apart
+// and ending at t0 with the given utilizations, oldest first.
+func snapshots(t0, resetsAt int64, utils ...float64) []db.UsageSnapshot {
+	out := make([]db.UsageSnapshot, len(utils))
+	for i, u := range utils {
+		out[len(utils)-1-i] = db.UsageSnapshot{
+			TsMs:             t0 - int64(len(utils)-1-i)*hourMs,
+			FiveHourUtil:     u,
+			FiveHourResetsAt: resetsAt,
+		}
+	}
+	return out
+}
+
+func fiveHour(h []db.UsageSnapshot) db.UsageForecast {
+	return budget.Forecast(h,
+		func(s db.UsageSnapshot) float64 { return s.FiveHourUtil },
+		func(s db.UsageSnapshot) int64 { return s.FiveHourResetsAt })
+}
+
+func TestForecast(t *testing.T) {
+	t0 := time.Date(2026, 1, 2, 12, 0, 0, 0, time.UTC).UnixMilli()
+
+	// 10% an hour from 40%: the limit is 6 hours out, before the reset.
+	f := fiveHour(snapshots(t0, t0+8*hourMs, 20, 30, 40))
+	if f.Util != 40 || f.Rate < 9.99 || f.Rate > 10.01 {
+		t.Errorf("unexpected forecast %+v", f)
+	}
+	if got := f.LimitAt - t0; got < 6*hourMs-1000 || got > 6*hourMs+1000 {
+		t.Errorf("expected the limit in 6h, got %v", time.Duration(got)*time.Millisecond)
+	}
+
+	// The same trend with the window resetting first.
+	if f := fiveHour(snapshots(t0, t0+2*hourMs, 20, 30, 40)); f.LimitAt != 0 {
+		t.Errorf("expected no limit before the reset, got %+v", f)
+	}
+
+	// Snapshots before a reset are ignored: only 5 -> 15 counts.
+	if f := fiveHour(snapshots(t0, 0, 90, 95, 5, 15)); f.Rate < 9.99 || f.Rate > 10.01 {
+		t.Errorf("expected the trend since the reset, got %+v", f)
+	}
+
+	// Flat usage never reaches the limit; full usage already has.
+	if f := fiveHour(snapshots(t0, 0, 50, 50)); f.LimitAt != 0 {
+		t.Errorf("expected no limit for flat usage, got %+v", f)
+	}
+	if f := fiveHour(snapshots(t0, 0, 100)); f.LimitAt != t0 {
+		t.Errorf("expected the limit now, got %+v", f)
+	}
+	if f := fiveHour(nil); f != (db.UsageForecast{}) {
+		t.Errorf("expected an empty forecast, got %+v", f)
+	}
+}
+
+func TestLevel(t *testing.T) {
+	cfg := budget.Config{WarnAt: 70, PauseAt: 90, ThrottleAt: 95}
+	cases := map[float64]string{
+		10: budget.LevelOK, 70: budget.LevelWarn, 91: budget.LevelPaused, 95: budget.LevelThrottled, 120: budget.LevelThrottled,
+	}
+	for util, want := range cases {
+		if got := cfg.Level(util); got != want {
+			t.Errorf("%v%%: got %s, want %s", util, got, want)
+		}
+	}
+	if got := (budget.Config{}).Level(100); got != budget.LevelOK {
+		t.Errorf("thresholds of 0 should be off, got %s", got)
+	}
+}
+
+type captureBroadcaster struct {
+	mu     sync.Mutex
+	events []events.Event
+}
+
+func (c *captureBroadcaster) Broadcast(e events.Event) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.events = append(c.events, e)
+}
+
+func TestGuard(t *testing.T) {
+	store, _ := db.Open(":memory:")
+	store.Migrate()
+	defer store.Close()
+
+	var mu sync.Mutex
+	var alerts []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var p struct{ Message string }
+		json.NewDecoder(r.Body).Decode(&p)
+		mu.Lock()
+		alerts = append(alerts, p.Message)
+		mu.Unlock()
+	}))
+	defer srv.Close()
+
+	now := time.Now()
+	for i, s := range []*db.Session{
+		{ID: "recent", Title: "recent", Status: db.StatusRunning, TmuxSession: "aw-test-recent", LastAccessed: now},
+		{ID: "stale", Title: "stale", Status: db.StatusRunning, TmuxSession: "aw-test-stale", LastAccessed: now.Add(-time.Hour)},
+		{ID: "idle", Title: "idle", Status: db.StatusIdle, TmuxSession: "aw-test-idle", LastAccessed: now.Add(-2 * time.Hour)},
+	} {
+		s.SortOrder = i
+		s.CreatedAt = now
+		store.SaveSession(s)
+	}
+	snap := func(util float64) {
+		store.InsertUsageSnapshot(db.UsageSnapshot{TsMs: time.Now().UnixMilli(), FiveHourUtil: util})
+		time.Sleep(2 * time.Millisecond) // keep snapshot times distinct
+	}
+
+	// Already over the warning threshold at startup: no alert.
+	snap(72)
+	g := budget.New(budget.Config{WarnAt: 70, PauseAt: 90, ThrottleAt: 95, ThrottleAction: budget.ActionStop, KeepRunning: 1},
+		store, session.NewManager(store), discardLogger())
+	g.SetNotifier(notify.New(notify.Config{Enabled: true, Webhook: srv.URL}, discardLogger()))
+	broadcaster := &captureBroadcaster{}
+	g.SetBroadcaster(broadcaster)
+	if g.Status().Level != budget.LevelWarn || g.CheckCreate() != nil {
+		t.Fatalf("unexpected startup status %+v", g.Status())
+	}
+
+	snap(96)
+	g.HandleEvent(events.Event{Type: events.UsageUpdated})
+	if g.Status().Level != budget.LevelThrottled || !errors.Is(g.CheckCreate(), budget.ErrPaused) {
+		t.Errorf("expected throttled and paused, got %+v", g.Status())
+	}
+	if s, _ := store.GetSession("stale"); s.Status != db.StatusStopped {
+		t.Errorf("expected the least recently used session to be stopped, got %s", s.Status)
+	}
+	for _, id := range []string{"recent", "idle"} {
+		if s, _ := store.GetSession(id); s.Status == db.StatusStopped {
+			t.Errorf("%s should not have been stopped", id)
+		}
+	}
+	entries, _ := store.QueryAudit(db.AuditFilter{})
+	if len(entries) != 1 || entries[0].Target != "stale" || entries[0].Actor != "agent-workspace" {
+		t.Errorf("expected the stop to be audited, got %+v", entries)
+	}
+
+	snap(10)
+	g.Update()
+	if g.CheckCreate() != nil {
+		t.Errorf("expected new sessions allowed again, got %+v", g.Status())
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(alerts) != 2 || !strings.Contains(alerts[0], "Stopped 1 running sessions") || !strings.Contains(alerts[1], "allowed again") {
+		t.Errorf("unexpected alerts %q", alerts)
+	}
+	if len(broadcaster.events) != 2 || broadcaster.events[0].Type != events.BudgetUpdated || broadcaster.events[0].Budget.Level != budget.LevelThrottled {
+		t.Errorf("unexpected events %+v", broadcaster.events)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	store, _ := db.Open(":memory:")
+	store.Migrate()
+	defer store.Close()
+	cfg := budget.Config{PauseAt: 90}
+	if err := budget.Check(cfg, store); err != nil {
+		t.Fatalf("expected no usage to allow sessions, got %v", err)
+	}
+
+	store.InsertUsageSnapshot(db.UsageSnapshot{TsMs: time.Now().UnixMilli(), FiveHourUtil: 40, SevenDayUtil: 93})
+	err := budget.Check(cfg, store)
+	if !errors.Is(err, budget.ErrPaused) || !strings.Contains(err.Error(), "93% of the seven-day window") {
+		t.Errorf("expected paused with the reason, got %v", err)
+	}
+}
+
+func TestResetWindow(t *testing.T) {
+	store, _ := db.Open(":memory:")
+	store.Migrate()
+	defer store.Close()
+	cfg := budget.Config{PauseAt: 90}
+	now := time.Now()
+
+	// The five-hour window was at 95% but reset a minute ago.
+	store.InsertUsageSnapshot(db.UsageSnapshot{TsMs: now.Add(-time.Hour).UnixMilli(),
+		FiveHourUtil: 95, FiveHourResetsAt: now.Add(-time.Minute).UnixMilli(), SevenDayUtil: 30})
+	if err := budget.Check(cfg, store); err != nil {
+		t.Errorf("expected a reset window to allow sessions, got %v", err)
+	}
+	g := budget.New(cfg, store, session.NewManager(store), discardLogger())
+	if st := g.Status(); st.Level != budget.LevelOK || st.FiveHour.Util != 0 || st.SevenDay.Util != 30 {
+		t.Errorf("expected the reset window at 0%%, got %+v", st)
+	}
+
+	// Without a reset time, a snapshot older than the window no longer
+	// counts, and a newer one does.
+	old, _ := db.Open(":memory:")
+	old.Migrate()
+	defer old.Close()
+	old.InsertUsageSnapshot(db.UsageSnapshot{TsMs: now.Add(-6 * time.Hour).UnixMilli(), FiveHourUtil: 95})
+	if err := budget.Check(cfg, old); err != nil {
+		t.Errorf("expected a snapshot older than the window to allow sessions, got %v", err)
+	}
+	old.InsertUsageSnapshot(db.UsageSnapshot{TsMs: now.Add(-5 * time.Minute).UnixMilli(), FiveHourUtil: 92})
+	if err := budget.Check(cfg, old); !errors.Is(err, budget.ErrPaused) {
+		t.Errorf("expected a current window over the limit to pause, got %v", err)
+	}
+}
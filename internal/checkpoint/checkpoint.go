@@ -0,0 +1,311 @@
+// Package checkpoint snapshots the state of a session's worktree under hidden
+// refs, refs/agws/checkpoints/<session>/<n>, so earlier states can be diffed
+// and restored. Snapshots never touch the user's branch, index or stash.
+package checkpoint
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/zsprackett/agent-workspace/internal/gitdiff"
+)
+
+// ErrNotFound is returned when a checkpoint does not exist.
+var ErrNotFound = errors.New("checkpoint not found")
+
+const refPrefix = "refs/agws/checkpoints/"
+
+// emptyTree is the hash of the empty tree, used as the base of checkpoints
+// taken before the branch had any commits.
+const emptyTree = "4b825dc642cb6eb9a060e54bf8d69288fbee4904"
+
+// Checkpoint is one snapshot of a worktree. Base is the commit HEAD pointed
+// at when it was taken, empty on an unborn branch.
+type Checkpoint struct {
+	N       int       `json:"n"`
+	Hash    string    `json:"hash"`
+	Base    string    `json:"base"`
+	Time    time.Time `json:"time"`
+	Message string    `json:"message"`
+}
+
+// Ref returns the ref checkpoint n of sessionID is stored under.
+func Ref(sessionID string, n int) string {
+	return refPrefix + sessionID + "/" + strconv.Itoa(n)
+}
+
+// Create snapshots the worktree at dir, including untracked files that are
+// not ignored, as the next checkpoint of sessionID. It returns nil without
+// creating anything when the worktree is unchanged since the latest
+// checkpoint.
+func Create(dir, sessionID, message string) (*Checkpoint, error) {
+	tree, err := snapshotTree(dir)
+	if err != nil {
+		return nil, err
+	}
+	existing, err := List(dir, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	n := 1
+	if len(existing) > 0 {
+		latest := existing[0]
+		if t, err := run(dir, nil, "rev-parse", latest.Hash+"^{tree}"); err == nil && strings.TrimSpace(t) == tree {
+			return nil, nil
+		}
+		n = latest.N + 1
+	}
+	if message == "" {
+		message = fmt.Sprintf("checkpoint %d", n)
+	}
+
+	args := []string{"commit-tree", tree, "-m", message}
+	base := ""
+	if head, err := run(dir, nil, "rev-parse", "--verify", "-q", "HEAD^{commit}"); err == nil {
+		base = strings.TrimSpace(head)
+		args = append(args, "-p", base)
+	}
+	env := []string{
+		"GIT_AUTHOR_NAME=agent-workspace", "GIT_AUTHOR_EMAIL=agent-workspace@localhost",
+		"GIT_COMMITTER_NAME=agent-workspace", "GIT_COMMITTER_EMAIL=agent-workspace@localhost",
+	}
+	out, err := run(dir, env, args...)
+	if err != nil {
+		return nil, err
+	}
+	hash := strings.TrimSpace(out)
+	// The empty old value makes update-ref fail rather than overwrite a
+	// checkpoint created concurrently under the same number.
+	if _, err := run(dir, nil, "update-ref", "-m", "checkpoint", Ref(sessionID, n), hash, ""); err != nil {
+		return nil, err
+	}
+	return &Checkpoint{N: n, Hash: hash, Base: base, Time: time.Now(), Message: message}, nil
+}
+
+// List returns the checkpoints of sessionID in the repo at dir, newest first.
+func List(dir, sessionID string) ([]Checkpoint, error) {
+	out, err := run(dir, nil, "for-each-ref",
+		"--format=%(refname)%00%(objectname)%00%(parent)%00%(creatordate:iso-strict)%00%(contents:subject)",
+		refPrefix+sessionID+"/")
+	if err != nil {
+		return nil, err
+	}
+	cps := []Checkpoint{}
+	for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+		parts := strings.SplitN(line, "\x00", 5)
+		if len(parts) != 5 {
+			continue
+		}
+		n, err := strconv.Atoi(strings.TrimPrefix(parts[0], refPrefix+sessionID+"/"))
+		if err != nil {
+			continue
+		}
+		t, _ := time.Parse(time.RFC3339, parts[3])
+		cps = append(cps, Checkpoint{
+			N:       n,
+			Hash:    parts[1],
+			Base:    firstField(parts[2]),
+			Time:    t,
+			Message: parts[4],
+		})
+	}
+	sort.Slice(cps, func(i, j int) bool { return cps[i].N > cps[j].N })
+	return cps, nil
+}
+
+// Diff returns the changes between two states of the worktree at dir. Each
+// of from and to is a checkpoint number, a checkpoint number followed by ^
+// for the commit that checkpoint was taken on, or "current" for the
+// worktree as it is now.
+func Diff(dir, sessionID, from, to string) ([]*gitdiff.File, error) {
+	fromTree, err := resolve(dir, sessionID, from)
+	if err != nil {
+		return nil, err
+	}
+	toTree, err := resolve(dir, sessionID, to)
+	if err != nil {
+		return nil, err
+	}
+	out, err := run(dir, nil, "-c", "core.quotepath=off", "diff", "--no-color", "--no-ext-diff", "-M",
+		fromTree, toTree)
+	if err != nil {
+		return nil, err
+	}
+	files, err := gitdiff.Parse(out)
+	if err != nil {
+		return nil, err
+	}
+	if files == nil {
+		files = []*gitdiff.File{}
+	}
+	return files, nil
+}
+
+// Restore makes the worktree at dir match checkpoint n: files are rewritten
+// or removed, HEAD is left alone and the index is reset to HEAD, so the
+// restored state shows up as uncommitted changes. The current state is
+// saved as a new checkpoint first, which is returned (nil if the worktree
+// already matched the latest checkpoint).
+func Restore(dir, sessionID string, n int) (*Checkpoint, error) {
+	target, err := resolve(dir, sessionID, strconv.Itoa(n))
+	if err != nil {
+		return nil, err
+	}
+	backup, err := Create(dir, sessionID, fmt.Sprintf("before restoring checkpoint %d", n))
+	if err != nil {
+		return nil, fmt.Errorf("saving current state: %w", err)
+	}
+	current, err := snapshotTree(dir)
+	if err != nil {
+		return nil, err
+	}
+	top, err := run(dir, nil, "rev-parse", "--show-toplevel")
+	if err != nil {
+		return nil, err
+	}
+	top = strings.TrimSpace(top)
+
+	// Files that only exist now are removed; checkout-index below only
+	// writes the files of the target.
+	added, err := run(dir, nil, "diff", "--name-only", "-z", "--no-renames", "--diff-filter=A", target, current)
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range strings.Split(added, "\x00") {
+		if path == "" {
+			continue
+		}
+		if err := os.Remove(filepath.Join(top, path)); err != nil && !os.IsNotExist(err) {
+			return backup, err
+		}
+	}
+
+	tmp, err := os.MkdirTemp("", "agws-checkpoint-")
+	if err != nil {
+		return backup, err
+	}
+	defer os.RemoveAll(tmp)
+	env := []string{"GIT_INDEX_FILE=" + filepath.Join(tmp, "index")}
+	if _, err := run(dir, env, "read-tree", target); err != nil {
+		return backup, err
+	}
+	if _, err := run(dir, env, "checkout-index", "-a", "-f"); err != nil {
+		return backup, err
+	}
+	if _, err := run(dir, nil, "reset", "-q"); err != nil {
+		return backup, err
+	}
+	return backup, nil
+}
+
+// DeleteAll removes every checkpoint of sessionID from the repo at dir.
+func DeleteAll(dir, sessionID string) error {
+	cps, err := List(dir, sessionID)
+	if err != nil {
+		return err
+	}
+	for _, cp := range cps {
+		if _, err := run(dir, nil, "update-ref", "-d", Ref(sessionID, cp.N)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// resolve returns the tree named by spec; see Diff.
+func resolve(dir, sessionID, spec string) (string, error) {
+	if spec == "current" {
+		return snapshotTree(dir)
+	}
+	parent := strings.HasSuffix(spec, "^")
+	n, err := strconv.Atoi(strings.TrimSuffix(spec, "^"))
+	if err != nil || n < 1 {
+		return "", ErrNotFound
+	}
+	rev := Ref(sessionID, n)
+	if _, err := run(dir, nil, "rev-parse", "--verify", "-q", rev); err != nil {
+		return "", ErrNotFound
+	}
+	if parent {
+		rev += "^"
+		if _, err := run(dir, nil, "rev-parse", "--verify", "-q", rev); err != nil {
+			// Taken on an unborn branch.
+			return emptyTree, nil
+		}
+	}
+	out, err := run(dir, nil, "rev-parse", rev+"^{tree}")
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(out), nil
+}
+
+// snapshotTree writes the worktree at dir to the object database and returns
+// its tree. It works on a copy of the index so the user's staging is left
+// untouched; git stash create is not used because it omits untracked files.
+func snapshotTree(dir string) (string, error) {
+	tmp, err := os.MkdirTemp("", "agws-checkpoint-")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(tmp)
+	index := filepath.Join(tmp, "index")
+	env := []string{"GIT_INDEX_FILE=" + index}
+
+	src, err := run(dir, nil, "rev-parse", "--git-path", "index")
+	if err != nil {
+		return "", err
+	}
+	src = strings.TrimSpace(src)
+	if !filepath.IsAbs(src) {
+		src = filepath.Join(dir, src)
+	}
+	if data, err := os.ReadFile(src); err == nil {
+		if err := os.WriteFile(index, data, 0o600); err != nil {
+			return "", err
+		}
+	} else if _, err := run(dir, nil, "rev-parse", "--verify", "-q", "HEAD"); err == nil {
+		if _, err := run(dir, env, "read-tree", "HEAD"); err != nil {
+			return "", err
+		}
+	}
+	if _, err := run(dir, env, "add", "-A"); err != nil {
+		return "", err
+	}
+	out, err := run(dir, env, "write-tree")
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(out), nil
+}
+
+func firstField(s string) string {
+	if f := strings.Fields(s); len(f) > 0 {
+		return f[0]
+	}
+	return ""
+}
+
+func run(dir string, env []string, args ...string) (string, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	if len(env) > 0 {
+		cmd.Env = append(os.Environ(), env...)
+	}
+	out, err := cmd.Output()
+	if err != nil {
+		var ee *exec.ExitError
+		if errors.As(err, &ee) {
+			return "", fmt.Errorf("git: %s", strings.TrimSpace(string(ee.Stderr)))
+		}
+		return "", err
+	}
+	return string(out), nil
+}
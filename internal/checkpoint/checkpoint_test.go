@@ -0,0 +1,144 @@
+package checkpoint_test
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/zsprackett/agent-workspace/internal/checkpoint"
+	"github.com/zsprackett/agent-workspace/internal/gitdiff"
+)
+
+func git(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GIT_AUTHOR_NAME=test", "GIT_AUTHOR_EMAIL=t@t.com",
+		"GIT_COMMITTER_NAME=test", "GIT_COMMITTER_EMAIL=t@t.com")
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %v: %v\n%s", args, err, out)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+func write(t *testing.T, dir, file, content string) {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(dir, file), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func read(t *testing.T, dir, file string) string {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join(dir, file))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestCreateDiffRestore(t *testing.T) {
+	dir := t.TempDir()
+	git(t, dir, "init", "-q", "-b", "main")
+	write(t, dir, "a.txt", "a\n")
+	git(t, dir, "add", ".")
+	git(t, dir, "commit", "-q", "-m", "init")
+	head := git(t, dir, "rev-parse", "HEAD")
+
+	// First checkpoint: a modified tracked file, a staged new file and an
+	// untracked one.
+	write(t, dir, "a.txt", "a\nb\n")
+	write(t, dir, "staged.txt", "s\n")
+	git(t, dir, "add", "staged.txt")
+	write(t, dir, "untracked.txt", "u\n")
+	cp1, err := checkpoint.Create(dir, "sess", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cp1 == nil || cp1.N != 1 || cp1.Base != head || cp1.Message != "checkpoint 1" {
+		t.Fatalf("first checkpoint: %+v", cp1)
+	}
+	if again, err := checkpoint.Create(dir, "sess", ""); err != nil || again != nil {
+		t.Fatalf("unchanged worktree: got %+v, %v; want nil, nil", again, err)
+	}
+
+	// Snapshotting must leave the branch and the user's staging alone.
+	if got := git(t, dir, "rev-parse", "HEAD"); got != head {
+		t.Errorf("HEAD moved to %s", got)
+	}
+	if got := git(t, dir, "diff", "--cached", "--name-only"); got != "staged.txt" {
+		t.Errorf("staged files: got %q want staged.txt", got)
+	}
+	if got := git(t, dir, "stash", "list"); got != "" {
+		t.Errorf("stash list: %q", got)
+	}
+
+	os.Remove(filepath.Join(dir, "untracked.txt"))
+	write(t, dir, "a.txt", "a\nb\nc\n")
+	write(t, dir, "later.txt", "l\n")
+	cp2, err := checkpoint.Create(dir, "sess", "second")
+	if err != nil || cp2 == nil || cp2.N != 2 {
+		t.Fatalf("second checkpoint: %+v, %v", cp2, err)
+	}
+
+	list, err := checkpoint.List(dir, "sess")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 2 || list[0].N != 2 || list[0].Message != "second" || list[1].Hash != cp1.Hash {
+		t.Fatalf("list: %+v", list)
+	}
+	if other, _ := checkpoint.List(dir, "other"); len(other) != 0 {
+		t.Errorf("other session: %+v", other)
+	}
+
+	files, err := checkpoint.Diff(dir, "sess", "1^", "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	paths := map[string]string{}
+	for _, f := range files {
+		paths[f.Path()] = f.Status
+	}
+	if len(paths) != 3 || paths["a.txt"] != gitdiff.StatusModified || paths["untracked.txt"] != gitdiff.StatusAdded {
+		t.Errorf("diff 1^..1: %v", paths)
+	}
+	files, _ = checkpoint.Diff(dir, "sess", "2", "current")
+	if len(files) != 0 {
+		t.Errorf("diff 2..current: expected no changes, got %d files", len(files))
+	}
+	if _, err := checkpoint.Diff(dir, "sess", "9", "current"); err != checkpoint.ErrNotFound {
+		t.Errorf("missing checkpoint: expected ErrNotFound, got %v", err)
+	}
+
+	write(t, dir, "a.txt", "changed\n")
+	backup, err := checkpoint.Restore(dir, "sess", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if backup == nil || backup.N != 3 {
+		t.Fatalf("backup checkpoint: %+v", backup)
+	}
+	if got := read(t, dir, "a.txt"); got != "a\nb\n" {
+		t.Errorf("a.txt: got %q", got)
+	}
+	if got := read(t, dir, "untracked.txt"); got != "u\n" {
+		t.Errorf("untracked.txt: got %q", got)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "later.txt")); !os.IsNotExist(err) {
+		t.Errorf("later.txt should have been removed: %v", err)
+	}
+	if got := git(t, dir, "rev-parse", "HEAD"); got != head {
+		t.Errorf("restore moved HEAD to %s", got)
+	}
+
+	if err := checkpoint.DeleteAll(dir, "sess"); err != nil {
+		t.Fatal(err)
+	}
+	if list, _ := checkpoint.List(dir, "sess"); len(list) != 0 {
+		t.Errorf("after DeleteAll: %+v", list)
+	}
+}
@@ -0,0 +1,191 @@
+package claudeusage_test
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/zsprackett/agent-workspace/internal/claudeusage"
+)
+
+func discardLogger() *slog.Logger {
+	return slog.New(slog.NewTextHandler(io.Discard, nil))
+}
+
+// stub is an Anthropic API that accepts one access token at a time and
+// rotates tokens on refresh.
+type stub struct {
+	mu        sync.Mutex
+	valid     string
+	refreshed int
+	srv       *httptest.Server
+}
+
+func newStub(t *testing.T, valid string) *stub {
+	s := &stub{valid: valid}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/oauth/usage", func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if r.Header.Get("Authorization") != "Bearer "+s.valid {
+			http.Error(w, `{"error":"invalid token"}`, http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(`{"five_hour":{"utilization":42,"resets_at":"2026-01-02T15:00:00Z"},"seven_day":{"utilization":7}}`))
+	})
+	mux.HandleFunc("POST /token", func(w http.ResponseWriter, r *http.Request) {
+		var req map[string]string
+		json.NewDecoder(r.Body).Decode(&req)
+		if req["grant_type"] != "refresh_token" || req["refresh_token"] != "refresh-1" {
+			http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+			return
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.refreshed++
+		s.valid = "access-2"
+		json.NewEncoder(w).Encode(map[string]any{"access_token": "access-2", "refresh_token": "refresh-2", "expires_in": 3600})
+	})
+	s.srv = httptest.NewServer(mux)
+	t.Cleanup(s.srv.Close)
+	return s
+}
+
+func (s *stub) client(sources ...claudeusage.Source) *claudeusage.Client {
+	return claudeusage.New(claudeusage.Config{BaseURL: s.srv.URL, TokenURL: s.srv.URL + "/token", Sources: sources}, discardLogger())
+}
+
+func writeCredentials(t *testing.T, path, access string, expiresAt int64) {
+	doc := fmt.Sprintf(`{"claudeAiOauth":{"accessToken":%q,"refreshToken":"refresh-1","expiresAt":%d,"subscriptionType":"max"},"mcpOAuth":{"x":1}}`,
+		access, expiresAt)
+	if err := os.WriteFile(path, []byte(doc), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFetchUsageRefreshesExpiredToken(t *testing.T) {
+	s := newStub(t, "access-1")
+	path := filepath.Join(t.TempDir(), ".credentials.json")
+	writeCredentials(t, path, "access-1", time.Now().Add(-time.Minute).UnixMilli())
+
+	usage, err := s.client(claudeusage.FileSource{Path: path}).FetchUsage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if usage.FiveHour.Utilization != 42 || s.refreshed != 1 {
+		t.Errorf("unexpected usage %+v after %d refreshes", usage, s.refreshed)
+	}
+
+	// The new tokens are written back, keeping everything else.
+	var doc struct {
+		ClaudeAiOauth struct {
+			AccessToken, RefreshToken, SubscriptionType string
+			ExpiresAt                                   int64
+		} `json:"claudeAiOauth"`
+		McpOAuth map[string]int `json:"mcpOAuth"`
+	}
+	data, _ := os.ReadFile(path)
+	if err := json.Unmarshal(data, &doc); err != nil {
+		t.Fatal(err)
+	}
+	o := doc.ClaudeAiOauth
+	if o.AccessToken != "access-2" || o.RefreshToken != "refresh-2" || o.SubscriptionType != "max" ||
+		o.ExpiresAt < time.Now().Add(50*time.Minute).UnixMilli() || doc.McpOAuth["x"] != 1 {
+		t.Errorf("unexpected saved credentials %s", data)
+	}
+	if info, _ := os.Stat(path); info.Mode().Perm() != 0o600 {
+		t.Errorf("expected the file to stay private, got %v", info.Mode())
+	}
+}
+
+func TestFetchUsageRetriesRejectedToken(t *testing.T) {
+	s := newStub(t, "access-1")
+	path := filepath.Join(t.TempDir(), ".credentials.json")
+	writeCredentials(t, path, "access-1", time.Now().Add(time.Hour).UnixMilli())
+	c := s.client(claudeusage.FileSource{Path: path})
+	if _, err := c.FetchUsage(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Claude Code logged in again: the cached token is rejected, and the
+	// one now in the file is used without a refresh.
+	s.valid = "access-3"
+	writeCredentials(t, path, "access-3", time.Now().Add(time.Hour).UnixMilli())
+	if _, err := c.FetchUsage(); err != nil || s.refreshed != 0 {
+		t.Fatalf("expected the new token to be used, got %v after %d refreshes", err, s.refreshed)
+	}
+
+	// Revoked with nothing newer: refreshed.
+	s.valid = "access-2"
+	if _, err := c.FetchUsage(); err != nil || s.refreshed != 1 {
+		t.Fatalf("expected a refresh, got %v after %d refreshes", err, s.refreshed)
+	}
+}
+
+func TestSources(t *testing.T) {
+	s := newStub(t, "env-token")
+	path := filepath.Join(t.TempDir(), ".credentials.json")
+	writeCredentials(t, path, "file-token", 0)
+	sources := claudeusage.Sources("printf cmd-token", path)
+
+	t.Setenv(claudeusage.TokenEnv, "env-token")
+	if tok, err := s.client(sources...).Token(); err != nil || tok != "env-token" {
+		t.Errorf("expected the environment first, got %q, %v", tok, err)
+	}
+	t.Setenv(claudeusage.TokenEnv, "")
+	if tok, _ := s.client(sources...).Token(); tok != "cmd-token" {
+		t.Errorf("expected the command next, got %q", tok)
+	}
+	if tok, _ := s.client(sources[2:]...).Token(); tok != "file-token" {
+		t.Errorf("expected the file next, got %q", tok)
+	}
+
+	// A command may print a credentials document instead.
+	cmd := claudeusage.CommandSource{Command: "cat " + path}
+	if tok, _ := s.client(cmd).Token(); tok != "file-token" {
+		t.Errorf("expected the document's token, got %q", tok)
+	}
+
+	// Expired credentials that cannot be saved are not refreshed.
+	writeCredentials(t, path, "old", time.Now().Add(-time.Hour).UnixMilli())
+	if _, err := s.client(cmd).Token(); err == nil || s.refreshed != 0 {
+		t.Errorf("expected an error without a refresh, got %v", err)
+	}
+
+	_, err := s.client(claudeusage.FileSource{Path: filepath.Join(t.TempDir(), "missing.json")}).Token()
+	if err == nil || !strings.Contains(err.Error(), "no Claude credentials") {
+		t.Errorf("expected no credentials, got %v", err)
+	}
+	if _, err := s.client(claudeusage.CommandSource{Command: "echo oops >&2; exit 3"}).Token(); err == nil || !strings.Contains(err.Error(), "oops") {
+		t.Errorf("expected the command's error, got %v", err)
+	}
+}
+
+// readOnly is a file source whose credentials cannot be written back.
+type readOnly struct{ claudeusage.FileSource }
+
+func (readOnly) Save(*claudeusage.Credentials) error { return errors.New("read-only") }
+
+func TestRefreshNotUsedUnlessSaved(t *testing.T) {
+	s := newStub(t, "access-1")
+	path := filepath.Join(t.TempDir(), ".credentials.json")
+	writeCredentials(t, path, "access-1", time.Now().Add(-time.Minute).UnixMilli())
+
+	_, err := s.client(readOnly{claudeusage.FileSource{Path: path}}).Token()
+	if err == nil || !strings.Contains(err.Error(), "read-only") {
+		t.Fatalf("expected the failed save to be an error, got %v", err)
+	}
+	if s.refreshed != 1 {
+		t.Errorf("expected one refresh, got %d", s.refreshed)
+	}
+}
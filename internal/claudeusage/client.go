@@ -1,69 +1,188 @@
 package claudeusage
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"net/http"
-	"os/exec"
 	"strings"
+	"sync"
+	"time"
 )
 
 const (
-	usageURL  = "https://api.anthropic.com/api/oauth/usage"
+	DefaultBaseURL  = "https://api.anthropic.com"
+	DefaultTokenURL = "https://console.anthropic.com/v1/oauth/token"
+
+	usagePath = "/api/oauth/usage"
 	userAgent = "claude-code/2.0.32"
 	betaFlag  = "oauth-2025-04-20"
+	// clientID is Claude Code's public OAuth client.
+	clientID = "9d1c250a-e61b-44d9-88ed-5944d1962f5e"
 )
 
-// GetToken retrieves the Claude Code OAuth access token from macOS Keychain.
-func GetToken() (string, error) {
-	out, err := exec.Command("security", "find-generic-password", "-s", "Claude Code-credentials", "-w").Output()
-	if err != nil {
-		return "", fmt.Errorf("keychain lookup: %w", err)
-	}
-	raw := strings.TrimSpace(string(out))
-	var creds struct {
-		ClaudeAiOauth struct {
-			AccessToken string `json:"accessToken"`
-		} `json:"claudeAiOauth"`
+type Config struct {
+	BaseURL  string   // defaults to DefaultBaseURL
+	TokenURL string   // defaults to DefaultTokenURL
+	Sources  []Source // defaults to Sources("", "")
+}
+
+// Client fetches usage with the first credentials its sources hold,
+// refreshing them when they expire.
+type Client struct {
+	cfg    Config
+	http   *http.Client
+	logger *slog.Logger
+
+	mu sync.Mutex
+	// cached is the last token used, kept until it expires or is rejected
+	// so that sources are not read on every fetch.
+	cached *Credentials
+}
+
+func New(cfg Config, logger *slog.Logger) *Client {
+	if cfg.BaseURL == "" {
+		cfg.BaseURL = DefaultBaseURL
 	}
-	if err := json.Unmarshal([]byte(raw), &creds); err != nil {
-		return "", fmt.Errorf("parse credentials: %w", err)
+	if cfg.TokenURL == "" {
+		cfg.TokenURL = DefaultTokenURL
 	}
-	if creds.ClaudeAiOauth.AccessToken == "" {
-		return "", fmt.Errorf("accessToken not found in credentials")
+	if cfg.Sources == nil {
+		cfg.Sources = Sources("", "")
 	}
-	return creds.ClaudeAiOauth.AccessToken, nil
+	cfg.BaseURL = strings.TrimSuffix(cfg.BaseURL, "/")
+	return &Client{cfg: cfg, http: &http.Client{Timeout: 30 * time.Second}, logger: logger}
 }
 
-// FetchUsage retrieves the current Claude Code usage statistics from the Anthropic API.
-func FetchUsage() (*UsageResponse, error) {
-	token, err := GetToken()
+// Token returns a current access token.
+func (c *Client) Token() (string, error) {
+	return c.token("")
+}
+
+// token returns a current access token other than rejected, refreshing the
+// credentials if they have expired.
+func (c *Client) token(rejected string) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.cached != nil && c.cached.AccessToken != rejected && !c.cached.Expired() {
+		return c.cached.AccessToken, nil
+	}
+	c.cached = nil
+
+	creds, src, err := c.load()
 	if err != nil {
-		return nil, err
+		return "", err
 	}
+	if creds.Expired() || creds.AccessToken == rejected {
+		saver, ok := src.(Saver)
+		if !ok || creds.RefreshToken == "" {
+			return "", fmt.Errorf("%s: access token expired or rejected and cannot be refreshed", src.Name())
+		}
+		if err := c.refresh(creds); err != nil {
+			return "", fmt.Errorf("refresh token from %s: %w", src.Name(), err)
+		}
+		// The old refresh token may no longer work, so Claude Code needs
+		// the new one. If it cannot be saved the new tokens are not used
+		// either: agent-workspace must not keep working on credentials
+		// Claude Code has lost.
+		if err := saver.Save(creds); err != nil {
+			c.logger.Error("claude usage: save refreshed credentials failed; log in to Claude Code again", "source", src.Name(), "err", err)
+			return "", fmt.Errorf("save refreshed credentials to %s: %w", src.Name(), err)
+		}
+	}
+	c.cached = creds
+	return creds.AccessToken, nil
+}
 
-	req, err := http.NewRequest("GET", usageURL, nil)
+// load returns the credentials of the first source that has any.
+func (c *Client) load() (*Credentials, Source, error) {
+	var errs []string
+	for _, src := range c.cfg.Sources {
+		creds, err := src.Load()
+		if err == nil {
+			return creds, src, nil
+		}
+		if !errors.Is(err, errNotFound) {
+			errs = append(errs, fmt.Sprintf("%s: %v", src.Name(), err))
+		}
+	}
+	if len(errs) > 0 {
+		return nil, nil, fmt.Errorf("no Claude credentials: %s", strings.Join(errs, "; "))
+	}
+	return nil, nil, errors.New("no Claude credentials found; log in to Claude Code or set " + TokenEnv)
+}
+
+// refresh exchanges creds' refresh token for a new access token.
+func (c *Client) refresh(creds *Credentials) error {
+	body, _ := json.Marshal(map[string]string{
+		"grant_type":    "refresh_token",
+		"refresh_token": creds.RefreshToken,
+		"client_id":     clientID,
+	})
+	req, err := http.NewRequest("POST", c.cfg.TokenURL, bytes.NewReader(body))
 	if err != nil {
-		return nil, fmt.Errorf("build request: %w", err)
+		return err
 	}
-	req.Header.Set("Authorization", "Bearer "+token)
-	req.Header.Set("anthropic-beta", betaFlag)
+	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("User-Agent", userAgent)
-
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := c.http.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("http request: %w", err)
+		return err
 	}
 	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("token endpoint returned %d: %s", resp.StatusCode, strings.TrimSpace(string(data)))
+	}
+	var tok struct {
+		AccessToken  string `json:"access_token"`
+		RefreshToken string `json:"refresh_token"`
+		ExpiresIn    int64  `json:"expires_in"` // seconds
+	}
+	if err := json.Unmarshal(data, &tok); err != nil {
+		return fmt.Errorf("parse token response: %w", err)
+	}
+	if tok.AccessToken == "" {
+		return errors.New("token response has no access_token")
+	}
+	creds.AccessToken = tok.AccessToken
+	if tok.RefreshToken != "" {
+		creds.RefreshToken = tok.RefreshToken
+	}
+	creds.ExpiresAt = 0
+	if tok.ExpiresIn > 0 {
+		creds.ExpiresAt = time.Now().Add(time.Duration(tok.ExpiresIn) * time.Second).UnixMilli()
+	}
+	return nil
+}
 
-	body, err := io.ReadAll(resp.Body)
+// FetchUsage retrieves the current Claude Code usage statistics from the Anthropic API.
+func (c *Client) FetchUsage() (*UsageResponse, error) {
+	token, err := c.Token()
 	if err != nil {
-		return nil, fmt.Errorf("read response: %w", err)
+		return nil, err
+	}
+	status, body, err := c.get(token)
+	if err == nil && status == http.StatusUnauthorized {
+		// Revoked, or replaced by Claude Code: try again with whatever
+		// the sources hold now, refreshed if need be.
+		if token, err = c.token(token); err != nil {
+			return nil, err
+		}
+		status, body, err = c.get(token)
+	}
+	if err != nil {
+		return nil, err
 	}
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("API returned %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	if status != http.StatusOK {
+		return nil, fmt.Errorf("API returned %d: %s", status, strings.TrimSpace(string(body)))
 	}
 
 	var usage UsageResponse
@@ -72,3 +191,25 @@ func FetchUsage() (*UsageResponse, error) {
 	}
 	return &usage, nil
 }
+
+func (c *Client) get(token string) (int, []byte, error) {
+	req, err := http.NewRequest("GET", c.cfg.BaseURL+usagePath, nil)
+	if err != nil {
+		return 0, nil, fmt.Errorf("build request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("anthropic-beta", betaFlag)
+	req.Header.Set("User-Agent", userAgent)
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return 0, nil, fmt.Errorf("http request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return 0, nil, fmt.Errorf("read response: %w", err)
+	}
+	return resp.StatusCode, body, nil
+}
@@ -0,0 +1,306 @@
+package claudeusage
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// keychainService is the name Claude Code stores its credentials under in
+// the macOS Keychain; agent-workspace uses the same name in the Secret
+// Service.
+const keychainService = "Claude Code-credentials"
+
+// TokenEnv is the environment variable a long-lived OAuth token, such as
+// one from `claude setup-token`, is read from.
+const TokenEnv = "CLAUDE_CODE_OAUTH_TOKEN"
+
+// errNotFound is returned by a source that holds no credentials.
+var errNotFound = errors.New("not found")
+
+// Credentials are a Claude Code OAuth login.
+type Credentials struct {
+	AccessToken  string
+	RefreshToken string // "" if the token cannot be refreshed
+	ExpiresAt    int64  // Unix ms; 0 if unknown
+
+	// raw is the document the credentials were read from, kept so that
+	// saving them preserves the fields agent-workspace does not use.
+	raw []byte
+}
+
+// Expired reports whether the access token has expired, or will within a
+// minute.
+func (c *Credentials) Expired() bool {
+	return c.ExpiresAt > 0 && time.Now().Add(time.Minute).UnixMilli() >= c.ExpiresAt
+}
+
+// oauthKey is the key Claude Code keeps its login under in a credentials
+// document.
+const oauthKey = "claudeAiOauth"
+
+type oauthCredentials struct {
+	AccessToken  string `json:"accessToken"`
+	RefreshToken string `json:"refreshToken"`
+	ExpiresAt    int64  `json:"expiresAt"`
+}
+
+// parseCredentials reads a credentials document as Claude Code writes it:
+// {"claudeAiOauth": {"accessToken": ..., "refreshToken": ..., "expiresAt": ...}}.
+func parseCredentials(data []byte) (*Credentials, error) {
+	var doc map[string]oauthCredentials
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("parse credentials: %w", err)
+	}
+	o := doc[oauthKey]
+	if o.AccessToken == "" {
+		return nil, fmt.Errorf("accessToken not found in credentials")
+	}
+	return &Credentials{AccessToken: o.AccessToken, RefreshToken: o.RefreshToken, ExpiresAt: o.ExpiresAt, raw: data}, nil
+}
+
+// marshal returns the document c was read from with its token fields
+// replaced by c's.
+func (c *Credentials) marshal() ([]byte, error) {
+	doc := map[string]json.RawMessage{}
+	if len(c.raw) > 0 {
+		if err := json.Unmarshal(c.raw, &doc); err != nil {
+			return nil, err
+		}
+	}
+	oauth := map[string]any{}
+	if raw, ok := doc[oauthKey]; ok {
+		if err := json.Unmarshal(raw, &oauth); err != nil {
+			return nil, err
+		}
+	}
+	oauth["accessToken"] = c.AccessToken
+	oauth["refreshToken"] = c.RefreshToken
+	oauth["expiresAt"] = c.ExpiresAt
+	b, err := json.Marshal(oauth)
+	if err != nil {
+		return nil, err
+	}
+	doc[oauthKey] = b
+	return json.Marshal(doc)
+}
+
+// Source is somewhere Claude credentials may be kept.
+type Source interface {
+	Name() string
+	// Load returns the credentials, or an error if there are none.
+	Load() (*Credentials, error)
+}
+
+// Saver is a Source that refreshed credentials can be written back to, so
+// that Claude Code picks up the new refresh token too. Credentials from
+// sources that cannot be saved are never refreshed.
+type Saver interface {
+	Source
+	Save(*Credentials) error
+}
+
+// Sources returns the sources credentials are looked for in, in order: the
+// TokenEnv environment variable, command if it is set, the credentials file
+// at path (or Claude Code's if it is ""), then the system secret store.
+func Sources(command, path string) []Source {
+	sources := []Source{EnvSource{Var: TokenEnv}}
+	if command != "" {
+		sources = append(sources, CommandSource{Command: command})
+	}
+	if path == "" {
+		path = DefaultCredentialsFile()
+	}
+	sources = append(sources, FileSource{Path: path}, SecretServiceSource{}, KeychainSource{})
+	return sources
+}
+
+// DefaultCredentialsFile is where Claude Code keeps its credentials when it
+// has no system keychain: .credentials.json in $CLAUDE_CONFIG_DIR or
+// ~/.claude.
+func DefaultCredentialsFile() string {
+	root := os.Getenv("CLAUDE_CONFIG_DIR")
+	if root == "" {
+		home, _ := os.UserHomeDir()
+		root = filepath.Join(home, ".claude")
+	}
+	return filepath.Join(root, ".credentials.json")
+}
+
+// EnvSource reads a bare access token from an environment variable.
+type EnvSource struct {
+	Var string
+}
+
+func (s EnvSource) Name() string { return "$" + s.Var }
+
+func (s EnvSource) Load() (*Credentials, error) {
+	token := strings.TrimSpace(os.Getenv(s.Var))
+	if token == "" {
+		return nil, errNotFound
+	}
+	return &Credentials{AccessToken: token}, nil
+}
+
+// CommandSource runs a shell command that prints either a bare access token
+// or a credentials document.
+type CommandSource struct {
+	Command string
+}
+
+func (s CommandSource) Name() string { return "command" }
+
+func (s CommandSource) Load() (*Credentials, error) {
+	var stderr bytes.Buffer
+	cmd := exec.Command("sh", "-c", s.Command)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return parseSecret(out)
+}
+
+// parseSecret reads a credentials document, or a bare access token.
+func parseSecret(out []byte) (*Credentials, error) {
+	out = bytes.TrimSpace(out)
+	if len(out) == 0 {
+		return nil, errNotFound
+	}
+	if out[0] == '{' {
+		return parseCredentials(out)
+	}
+	return &Credentials{AccessToken: string(out)}, nil
+}
+
+// FileSource reads a credentials document from a file.
+type FileSource struct {
+	Path string
+}
+
+func (s FileSource) Name() string { return s.Path }
+
+func (s FileSource) Load() (*Credentials, error) {
+	data, err := os.ReadFile(s.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, errNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return parseCredentials(data)
+}
+
+// Save replaces the file atomically, keeping it private.
+func (s FileSource) Save(c *Credentials) error {
+	data, err := c.marshal()
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(s.Path), ".credentials-*.json")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.Path)
+}
+
+// SecretServiceSource reads a credentials document from the freedesktop
+// Secret Service (GNOME Keyring, KWallet) with libsecret's secret-tool,
+// under the attribute service=keychainService.
+type SecretServiceSource struct{}
+
+func (SecretServiceSource) Name() string { return "secret service" }
+
+func (SecretServiceSource) Load() (*Credentials, error) {
+	if _, err := exec.LookPath("secret-tool"); err != nil {
+		return nil, errNotFound
+	}
+	out, err := exec.Command("secret-tool", "lookup", "service", keychainService).Output()
+	if err != nil {
+		// secret-tool exits 1 with no output when nothing matches.
+		return nil, errNotFound
+	}
+	return parseSecret(out)
+}
+
+func (SecretServiceSource) Save(c *Credentials) error {
+	data, err := c.marshal()
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command("secret-tool", "store", "--label", keychainService, "service", keychainService)
+	cmd.Stdin = bytes.NewReader(data)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("secret-tool store: %w: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// KeychainSource reads the credentials Claude Code keeps in the macOS
+// Keychain.
+type KeychainSource struct{}
+
+func (KeychainSource) Name() string { return "keychain" }
+
+func (KeychainSource) Load() (*Credentials, error) {
+	if _, err := exec.LookPath("security"); err != nil {
+		return nil, errNotFound
+	}
+	out, err := exec.Command("security", "find-generic-password", "-s", keychainService, "-w").Output()
+	if err != nil {
+		return nil, fmt.Errorf("keychain lookup: %w", err)
+	}
+	return parseCredentials(bytes.TrimSpace(out))
+}
+
+// securityLineMax is the longest command line security's interactive mode
+// reads; longer lines are cut short.
+const securityLineMax = 4096
+
+func (KeychainSource) Save(c *Credentials) error {
+	data, err := c.marshal()
+	if err != nil {
+		return err
+	}
+	// security -i reads commands from stdin rather than the terminal, so
+	// the tokens stay off the command line where other users could see
+	// them and nothing prompts. -X takes the secret as hex, which needs no
+	// quoting. Reading the item back catches a write that was cut short or
+	// failed without an exit status.
+	account := os.Getenv("USER")
+	if strings.ContainsAny(account, "\"\\\n") {
+		return fmt.Errorf("keychain update: unsupported account name %q", account)
+	}
+	line := fmt.Sprintf("add-generic-password -U -a \"%s\" -s \"%s\" -X %s\n", account, keychainService, hex.EncodeToString(data))
+	if len(line) > securityLineMax {
+		return fmt.Errorf("keychain update: credentials too large (%d bytes)", len(data))
+	}
+	cmd := exec.Command("security", "-i")
+	cmd.Stdin = strings.NewReader(line)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("keychain update: %w: %s", err, strings.TrimSpace(string(out)))
+	}
+	out, err := exec.Command("security", "find-generic-password", "-a", account, "-s", keychainService, "-w").Output()
+	if err != nil {
+		return fmt.Errorf("keychain update: read back: %w", err)
+	}
+	if !bytes.Equal(bytes.TrimSpace(out), data) {
+		return errors.New("keychain update: the saved credentials do not match")
+	}
+	return nil
+}
@@ -0,0 +1,241 @@
+// Package compare compares the branches of sessions working on the same repo,
+// such as parallel attempts at one task or forks of a session. Worktrees of a
+// repo share the refs of its bare clone, so branches are compared locally.
+package compare
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/zsprackett/agent-workspace/internal/db"
+	"github.com/zsprackett/agent-workspace/internal/git"
+	"github.com/zsprackett/agent-workspace/internal/session"
+)
+
+// Check states reported for a side of a comparison.
+const (
+	ChecksPass    = "pass"
+	ChecksFail    = "fail"
+	ChecksPending = "pending"
+	ChecksNone    = "none"
+)
+
+// FileStat is the change to one file between the two branches.
+type FileStat struct {
+	Path    string `json:"path"`
+	Added   int    `json:"added"`
+	Deleted int    `json:"deleted"`
+	Binary  bool   `json:"binary,omitempty"`
+}
+
+// Side summarises one session's branch relative to the merge base it shares
+// with the other session.
+type Side struct {
+	SessionID string `json:"session_id"`
+	Title     string `json:"title"`
+	Branch    string `json:"branch"`
+	// Commits is the number of commits since the merge base.
+	Commits int `json:"commits"`
+	Files   int `json:"files"`
+	Added   int `json:"added"`
+	Deleted int `json:"deleted"`
+	// Dirty is set when the session has uncommitted changes, which the
+	// comparison does not include.
+	Dirty  bool   `json:"dirty"`
+	Checks string `json:"checks"`
+}
+
+// RepoDiff is the comparison of the two branches in one repo.
+type RepoDiff struct {
+	// Repo names the repo for multi-repo sessions and is empty otherwise.
+	Repo      string     `json:"repo,omitempty"`
+	MergeBase string     `json:"merge_base"`
+	Files     []FileStat `json:"files"`
+	Diff      string     `json:"diff"`
+}
+
+// Result is the comparison of session A's branches with session B's. Diffs
+// go from A to B.
+type Result struct {
+	A     Side       `json:"a"`
+	B     Side       `json:"b"`
+	Repos []RepoDiff `json:"repos"`
+}
+
+// ChecksFunc reports the CI check state of the PR for the branch checked out
+// at dir. It is a variable so tests can replace it.
+var ChecksFunc = ghChecks
+
+// Siblings returns the sessions that can be compared with s: those with a
+// worktree of the same primary repo on a different branch.
+func Siblings(store *db.DB, s *db.Session) ([]*db.Session, error) {
+	all, err := store.LoadSessions()
+	if err != nil {
+		return nil, err
+	}
+	var out []*db.Session
+	for _, o := range all {
+		if o.ID == s.ID || o.WorktreeRepo == "" || o.WorktreeBranch == "" {
+			continue
+		}
+		if o.WorktreeRepo == s.WorktreeRepo && o.WorktreeBranch != s.WorktreeBranch {
+			out = append(out, o)
+		}
+	}
+	return out, nil
+}
+
+// Sessions compares the branches of sessions a and b in every repo they both
+// have a worktree of.
+func Sessions(store *db.DB, a, b *db.Session) (*Result, error) {
+	wa, wb := session.Worktrees(store, a), session.Worktrees(store, b)
+	if len(wa) == 0 || len(wb) == 0 {
+		return nil, errors.New("both sessions need a worktree to compare")
+	}
+	res := &Result{
+		A: Side{SessionID: a.ID, Title: a.Title, Branch: a.WorktreeBranch, Dirty: a.HasUncommitted, Checks: ChecksNone},
+		B: Side{SessionID: b.ID, Title: b.Title, Branch: b.WorktreeBranch, Dirty: b.HasUncommitted, Checks: ChecksNone},
+	}
+	byRepo := make(map[string]*db.SessionWorktree, len(wb))
+	for _, wt := range wb {
+		byRepo[wt.RepoPath] = wt
+	}
+	multi := len(wa) > 1 || len(wb) > 1
+	var checksA, checksB []string
+	for _, ta := range wa {
+		tb := byRepo[ta.RepoPath]
+		if tb == nil {
+			continue
+		}
+		rd, err := compareRepo(ta, tb, &res.A, &res.B)
+		if err != nil {
+			return nil, err
+		}
+		if multi {
+			rd.Repo = filepath.Base(ta.WorktreePath)
+		}
+		res.Repos = append(res.Repos, *rd)
+		checksA = append(checksA, ChecksFunc(ta.WorktreePath))
+		checksB = append(checksB, ChecksFunc(tb.WorktreePath))
+	}
+	if len(res.Repos) == 0 {
+		return nil, errors.New("the sessions have no repo in common")
+	}
+	res.A.Checks = worstChecks(checksA)
+	res.B.Checks = worstChecks(checksB)
+	return res, nil
+}
+
+func compareRepo(ta, tb *db.SessionWorktree, a, b *Side) (*RepoDiff, error) {
+	repo := ta.RepoPath
+	refA, refB := "refs/heads/"+ta.Branch, "refs/heads/"+tb.Branch
+	base, err := git.MergeBase(repo, refA, refB)
+	if err != nil {
+		return nil, err
+	}
+	for _, side := range []struct {
+		ref string
+		s   *Side
+	}{{refA, a}, {refB, b}} {
+		n, err := git.CountCommits(repo, base, side.ref)
+		if err != nil {
+			return nil, err
+		}
+		stats, err := git.DiffNumstat(repo, base, side.ref)
+		if err != nil {
+			return nil, err
+		}
+		side.s.Commits += n
+		side.s.Files += len(stats)
+		for _, st := range stats {
+			side.s.Added += st.Added
+			side.s.Deleted += st.Deleted
+		}
+	}
+	stats, err := git.DiffNumstat(repo, refA, refB)
+	if err != nil {
+		return nil, err
+	}
+	diff, err := git.Diff(repo, refA, refB)
+	if err != nil {
+		return nil, err
+	}
+	rd := &RepoDiff{MergeBase: base, Files: make([]FileStat, len(stats)), Diff: diff}
+	for i, st := range stats {
+		rd.Files[i] = FileStat{Path: st.Path, Added: st.Added, Deleted: st.Deleted, Binary: st.Binary}
+	}
+	return rd, nil
+}
+
+// worstChecks combines per-repo check states: any failure fails, then any
+// pending check, then a pass if at least one repo passed.
+func worstChecks(states []string) string {
+	rank := map[string]int{ChecksNone: 0, ChecksPass: 1, ChecksPending: 2, ChecksFail: 3}
+	worst := ChecksNone
+	for _, s := range states {
+		if rank[s] > rank[worst] {
+			worst = s
+		}
+	}
+	return worst
+}
+
+// ghChecks summarises "gh pr checks" for the PR of the branch at dir. It
+// returns ChecksNone when there is no PR, no checks, or gh is unavailable.
+func ghChecks(dir string) string {
+	cmd := exec.Command("gh", "pr", "checks", "--json", "bucket")
+	cmd.Dir = dir
+	// gh exits non-zero when checks fail or are pending but still prints them.
+	out, _ := cmd.Output()
+	var checks []struct {
+		Bucket string `json:"bucket"`
+	}
+	if err := json.Unmarshal(out, &checks); err != nil || len(checks) == 0 {
+		return ChecksNone
+	}
+	states := make([]string, 0, len(checks))
+	for _, c := range checks {
+		switch c.Bucket {
+		case "fail", "cancel":
+			states = append(states, ChecksFail)
+		case "pending":
+			states = append(states, ChecksPending)
+		default: // pass, skipping
+			states = append(states, ChecksPass)
+		}
+	}
+	return worstChecks(states)
+}
+
+// Summary formats r's sides and per-file stats as plain text.
+func Summary(r *Result) string {
+	var out string
+	for _, s := range []Side{r.A, r.B} {
+		dirty := ""
+		if s.Dirty {
+			dirty = ", uncommitted changes"
+		}
+		out += fmt.Sprintf("%s (%s): %d commits, %d files +%d -%d, checks %s%s\n",
+			s.Title, s.Branch, s.Commits, s.Files, s.Added, s.Deleted, s.Checks, dirty)
+	}
+	for _, rd := range r.Repos {
+		out += "\n"
+		if rd.Repo != "" {
+			out += fmt.Sprintf("=== %s ===\n", rd.Repo)
+		}
+		if len(rd.Files) == 0 {
+			out += "No differences.\n"
+		}
+		for _, f := range rd.Files {
+			if f.Binary {
+				out += fmt.Sprintf("  %-8s %s\n", "binary", f.Path)
+				continue
+			}
+			out += fmt.Sprintf("  %-8s %s\n", fmt.Sprintf("+%d -%d", f.Added, f.Deleted), f.Path)
+		}
+	}
+	return out
+}
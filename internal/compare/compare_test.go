@@ -0,0 +1,116 @@
+package compare_test
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zsprackett/agent-workspace/internal/compare"
+	"github.com/zsprackett/agent-workspace/internal/db"
+	"github.com/zsprackett/agent-workspace/internal/git"
+)
+
+func run(t *testing.T, dir string, args ...string) {
+	t.Helper()
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GIT_AUTHOR_NAME=test", "GIT_AUTHOR_EMAIL=t@t.com",
+		"GIT_COMMITTER_NAME=test", "GIT_COMMITTER_EMAIL=t@t.com")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("git %v: %v\n%s", args, err, out)
+	}
+}
+
+func TestSessions(t *testing.T) {
+	store, err := db.Open(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.Migrate()
+	t.Cleanup(func() { store.Close() })
+
+	upstream, reposDir, worktreesDir := t.TempDir(), t.TempDir(), t.TempDir()
+	run(t, upstream, "init", "-b", "main")
+	os.WriteFile(filepath.Join(upstream, "README.md"), []byte("hello\n"), 0644)
+	run(t, upstream, "add", ".")
+	run(t, upstream, "commit", "-m", "init")
+	bare := git.BareRepoPath(reposDir, "github.com", "owner", "myrepo")
+	os.MkdirAll(filepath.Dir(bare), 0755)
+	if err := git.CloneBare(upstream, bare); err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	var sessions []*db.Session
+	for i, branch := range []string{"attempt-a", "attempt-b"} {
+		wt := git.WorktreePath(worktreesDir, "github.com", "owner", "myrepo", branch)
+		os.MkdirAll(filepath.Dir(wt), 0755)
+		if _, err := git.CreateWorktree(bare, branch, wt, "main"); err != nil {
+			t.Fatal(err)
+		}
+		s := &db.Session{
+			ID: branch, Title: branch, GroupPath: "work", Tool: db.ToolClaude, Status: db.StatusIdle,
+			CreatedAt: now, LastAccessed: now, SortOrder: i,
+			ProjectPath: wt, WorktreePath: wt, WorktreeRepo: bare, WorktreeBranch: branch,
+		}
+		store.SaveSession(s)
+		sessions = append(sessions, s)
+	}
+	a, b := sessions[0], sessions[1]
+
+	os.WriteFile(filepath.Join(a.WorktreePath, "a.txt"), []byte("one\ntwo\n"), 0644)
+	run(t, a.WorktreePath, "add", ".")
+	run(t, a.WorktreePath, "commit", "-m", "a")
+	os.WriteFile(filepath.Join(b.WorktreePath, "README.md"), []byte("hello\nworld\n"), 0644)
+	run(t, b.WorktreePath, "commit", "-am", "b1")
+	os.WriteFile(filepath.Join(b.WorktreePath, "b.txt"), []byte("b\n"), 0644)
+	run(t, b.WorktreePath, "add", ".")
+	run(t, b.WorktreePath, "commit", "-m", "b2")
+
+	sib, err := compare.Siblings(store, a)
+	if err != nil || len(sib) != 1 || sib[0].ID != b.ID {
+		t.Fatalf("siblings: got %v, %v", sib, err)
+	}
+
+	orig := compare.ChecksFunc
+	t.Cleanup(func() { compare.ChecksFunc = orig })
+	compare.ChecksFunc = func(dir string) string {
+		if dir == b.WorktreePath {
+			return compare.ChecksPass
+		}
+		return compare.ChecksFail
+	}
+
+	res, err := compare.Sessions(store, a, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.A.Commits != 1 || res.A.Files != 1 || res.A.Added != 2 {
+		t.Errorf("side A: %+v", res.A)
+	}
+	if res.B.Commits != 2 || res.B.Files != 2 || res.B.Added != 2 {
+		t.Errorf("side B: %+v", res.B)
+	}
+	if res.A.Checks != compare.ChecksFail || res.B.Checks != compare.ChecksPass {
+		t.Errorf("checks: A %s, B %s", res.A.Checks, res.B.Checks)
+	}
+	if len(res.Repos) != 1 {
+		t.Fatalf("expected one repo, got %d", len(res.Repos))
+	}
+	files := map[string]compare.FileStat{}
+	for _, f := range res.Repos[0].Files {
+		files[f.Path] = f
+	}
+	if len(files) != 3 || files["a.txt"].Deleted != 2 || files["b.txt"].Added != 1 || files["README.md"].Added != 1 {
+		t.Errorf("files: %+v", res.Repos[0].Files)
+	}
+	if !strings.Contains(res.Repos[0].Diff, "+world") {
+		t.Errorf("diff missing README change:\n%s", res.Repos[0].Diff)
+	}
+	if sum := compare.Summary(res); !strings.Contains(sum, "attempt-b (attempt-b): 2 commits, 2 files +2 -0, checks pass") {
+		t.Errorf("summary:\n%s", sum)
+	}
+}
@@ -17,6 +17,39 @@ type NotificationsConfig struct {
 	Enabled bool   `json:"enabled"`
 	Webhook string `json:"webhook"`
 	NtfyURL string `json:"ntfy"`
+	// EventWebhook receives every event as a JSON POST, or only those in
+	// EventTypes if it is set.
+	EventWebhook string   `json:"eventWebhook"`
+	EventTypes   []string `json:"eventTypes"`
+}
+
+// BudgetConfig sets thresholds on Claude usage, in percent of the fuller of
+// the five-hour and seven-day windows. A threshold of 0 is off.
+type BudgetConfig struct {
+	WarnAt     float64 `json:"warnAt"`     // notify
+	PauseAt    float64 `json:"pauseAt"`    // also refuse new sessions
+	ThrottleAt float64 `json:"throttleAt"` // also apply ThrottleAction
+	// ThrottleAction is what happens to running sessions at ThrottleAt:
+	// "escape" interrupts them, "stop" stops them, and "" leaves them be.
+	// The KeepRunning most recently used are spared.
+	ThrottleAction string `json:"throttleAction"`
+	KeepRunning    int    `json:"keepRunning"`
+}
+
+// UsageConfig sets where Claude usage is fetched from and the credentials
+// it is fetched with.
+type UsageConfig struct {
+	BaseURL         string `json:"baseURL"`         // defaults to https://api.anthropic.com
+	TokenURL        string `json:"tokenURL"`        // OAuth token endpoint expired tokens are refreshed at
+	CredentialsFile string `json:"credentialsFile"` // defaults to ~/.claude/.credentials.json
+	// TokenCommand is a shell command that prints an access token or a
+	// Claude Code credentials document.
+	TokenCommand string `json:"tokenCommand"`
+}
+
+type GCConfig struct {
+	Interval  string `json:"interval"`  // e.g. "24h"; "0" disables the periodic scan
+	AutoPrune bool   `json:"autoPrune"` // prune without confirmation during the periodic scan
 }
 
 type TLSConfig struct {
@@ -30,14 +63,19 @@ type TLSConfig struct {
 type AuthConfig struct {
 	JWTSecret       string `json:"jwtSecret"`
 	RefreshTokenTTL string `json:"refreshTokenTTL"` // e.g. "168h", defaults to 7 days
+	RequireTOTP     bool   `json:"requireTOTP"`     // every account must use two-factor login
 }
 
 type WebserverConfig struct {
-	Enabled bool       `json:"enabled"`
-	Port    int        `json:"port"`
-	Host    string     `json:"host"`
-	TLS     TLSConfig  `json:"tls"`
-	Auth    AuthConfig `json:"auth"`
+	Enabled   bool       `json:"enabled"`
+	Port      int        `json:"port"`
+	Host      string     `json:"host"`
+	PublicURL string     `json:"publicURL"` // how others reach the server, e.g. "https://agents.example.com"; used in share links
+	TLS       TLSConfig  `json:"tls"`
+	Auth      AuthConfig `json:"auth"`
+	// MetricsAddr, e.g. "127.0.0.1:9100", also serves /metrics there without
+	// authentication.
+	MetricsAddr string `json:"metricsAddr"`
 }
 
 type Config struct {
@@ -46,7 +84,10 @@ type Config struct {
 	Worktree      WorktreeConfig      `json:"worktree"`
 	ReposDir      string              `json:"reposDir"`
 	WorktreesDir  string              `json:"worktreesDir"`
+	GC            GCConfig            `json:"gc"`
 	Notifications NotificationsConfig `json:"notifications"`
+	Budget        BudgetConfig        `json:"budget"`
+	Usage         UsageConfig         `json:"usage"`
 	Webserver     WebserverConfig     `json:"webserver"`
 	LogLevel      string              `json:"logLevel"`
 	LogDir        string              `json:"logDir"`
@@ -57,9 +98,10 @@ func Defaults() Config {
 	return Config{
 		DefaultTool:  "claude",
 		DefaultGroup: "my-sessions",
-		Worktree:     WorktreeConfig{DefaultBaseBranch: "main"},
 		ReposDir:     filepath.Join(home, ".agent-workspace", "repos"),
 		WorktreesDir: filepath.Join(home, ".agent-workspace", "worktrees"),
+		GC:           GCConfig{Interval: "24h"},
+		Budget:       BudgetConfig{WarnAt: 70, PauseAt: 90, ThrottleAt: 95, KeepRunning: 1},
 		Webserver: WebserverConfig{
 			Enabled: true,
 			Port:    8080,
@@ -80,6 +122,12 @@ func WorktreesDir() string {
 	return filepath.Join(home, ".agent-workspace", "worktrees")
 }
 
+// ReportsDir is where the TUI exports analytics reports.
+func ReportsDir() string {
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, ".agent-workspace", "reports")
+}
+
 func DefaultPath() string {
 	home, _ := os.UserHomeDir()
 	return filepath.Join(home, ".agent-workspace", "config.json")
@@ -90,6 +138,12 @@ func DBPath() string {
 	return filepath.Join(home, ".agent-workspace", "state.db")
 }
 
+// HookSocketPath is the Unix socket Claude Code hooks report to.
+func HookSocketPath() string {
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, ".agent-workspace", "hooks.sock")
+}
+
 // EnsureJWTSecret generates and saves a JWT secret if one is not already set.
 // It writes the updated config back to path.
 func EnsureJWTSecret(path string, cfg *Config) error {
@@ -93,6 +93,12 @@ func (d *DB) Migrate() error {
 		}
 	}
 
+	if _, alterErr := d.sql.Exec(`ALTER TABLE sessions ADD COLUMN owner TEXT NOT NULL DEFAULT ''`); alterErr != nil {
+		if !isDuplicateColumnError(alterErr) {
+			return fmt.Errorf("alter sessions add owner: %w", alterErr)
+		}
+	}
+
 	_, err = d.sql.Exec(`
 		CREATE TABLE IF NOT EXISTS groups (
 			path               TEXT PRIMARY KEY,
@@ -129,10 +135,32 @@ func (d *DB) Migrate() error {
 		}
 	}
 
+	// Add base_branch column to existing groups tables; ignore "duplicate column" errors.
+	if _, alterErr := d.sql.Exec(`ALTER TABLE groups ADD COLUMN base_branch TEXT NOT NULL DEFAULT ''`); alterErr != nil {
+		if !isDuplicateColumnError(alterErr) {
+			return fmt.Errorf("alter groups add base_branch: %w", alterErr)
+		}
+	}
+
+	// Add extra_repo_urls column (newline-separated) to existing groups tables; ignore "duplicate column" errors.
+	if _, alterErr := d.sql.Exec(`ALTER TABLE groups ADD COLUMN extra_repo_urls TEXT NOT NULL DEFAULT ''`); alterErr != nil {
+		if !isDuplicateColumnError(alterErr) {
+			return fmt.Errorf("alter groups add extra_repo_urls: %w", alterErr)
+		}
+	}
+
+	if _, alterErr := d.sql.Exec(`ALTER TABLE groups ADD COLUMN checkpoints INTEGER NOT NULL DEFAULT 0`); alterErr != nil {
+		if !isDuplicateColumnError(alterErr) {
+			return fmt.Errorf("alter groups add checkpoints: %w", alterErr)
+		}
+	}
+
+	// No foreign key: SaveSession's INSERT OR REPLACE would cascade and drop
+	// the rows, and a deleted session's events are kept for analytics.
 	_, err = d.sql.Exec(`
 		CREATE TABLE IF NOT EXISTS session_events (
 			id         INTEGER PRIMARY KEY,
-			session_id TEXT NOT NULL REFERENCES sessions(id) ON DELETE CASCADE,
+			session_id TEXT NOT NULL,
 			ts         DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
 			event_type TEXT NOT NULL,
 			detail     TEXT NOT NULL DEFAULT ''
@@ -152,6 +180,56 @@ func (d *DB) Migrate() error {
 			return fmt.Errorf("alter session_events add ts_ms: %w", alterErr)
 		}
 	}
+	if err := d.dropSessionEventsForeignKey(); err != nil {
+		return fmt.Errorf("rebuild session_events: %w", err)
+	}
+	// Rows from before ts_ms was added only have ts, in UTC seconds.
+	if _, err := d.sql.Exec(`UPDATE session_events SET ts_ms = CAST(strftime('%s', ts) AS INTEGER) * 1000
+		WHERE ts_ms = 0 AND strftime('%s', ts) IS NOT NULL`); err != nil {
+		return fmt.Errorf("backfill session_events ts_ms: %w", err)
+	}
+	if _, err := d.sql.Exec(`CREATE INDEX IF NOT EXISTS idx_session_events_type ON session_events(event_type, ts_ms)`); err != nil {
+		return fmt.Errorf("index session_events type: %w", err)
+	}
+
+	// No foreign key here: SaveSession's INSERT OR REPLACE would cascade and
+	// drop the rows. DeleteSession removes them explicitly.
+	_, err = d.sql.Exec(`
+		CREATE TABLE IF NOT EXISTS session_worktrees (
+			session_id    TEXT NOT NULL,
+			sort_order    INTEGER NOT NULL DEFAULT 0,
+			repo_url      TEXT NOT NULL DEFAULT '',
+			repo_path     TEXT NOT NULL,
+			worktree_path TEXT NOT NULL,
+			branch        TEXT NOT NULL,
+			PRIMARY KEY (session_id, worktree_path)
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("create session_worktrees: %w", err)
+	}
+
+	// No foreign key for the same reason as session_worktrees.
+	_, err = d.sql.Exec(`
+		CREATE TABLE IF NOT EXISTS review_comments (
+			id         INTEGER PRIMARY KEY AUTOINCREMENT,
+			session_id TEXT NOT NULL,
+			repo       TEXT NOT NULL DEFAULT '',
+			file       TEXT NOT NULL,
+			line_start INTEGER NOT NULL,
+			line_end   INTEGER NOT NULL,
+			commit_sha TEXT NOT NULL DEFAULT '',
+			body       TEXT NOT NULL,
+			created_at INTEGER NOT NULL,
+			sent_at    INTEGER NOT NULL DEFAULT 0
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("create review_comments: %w", err)
+	}
+	if _, err := d.sql.Exec(`CREATE INDEX IF NOT EXISTS idx_review_comments_session ON review_comments(session_id, sent_at)`); err != nil {
+		return fmt.Errorf("index review_comments: %w", err)
+	}
 
 	_, err = d.sql.Exec(`
 		CREATE TABLE IF NOT EXISTS accounts (
@@ -164,6 +242,51 @@ func (d *DB) Migrate() error {
 	if err != nil {
 		return fmt.Errorf("create accounts: %w", err)
 	}
+	// Accounts created before roles existed had full access; keep it.
+	if _, alterErr := d.sql.Exec(`ALTER TABLE accounts ADD COLUMN role TEXT NOT NULL DEFAULT 'admin'`); alterErr != nil {
+		if !isDuplicateColumnError(alterErr) {
+			return fmt.Errorf("alter accounts add role: %w", alterErr)
+		}
+	}
+
+	// group_path has no foreign key: SaveGroups rewrites the groups table.
+	_, err = d.sql.Exec(`
+		CREATE TABLE IF NOT EXISTS group_permissions (
+			account_id TEXT NOT NULL REFERENCES accounts(id) ON DELETE CASCADE,
+			group_path TEXT NOT NULL,
+			role       TEXT NOT NULL,
+			PRIMARY KEY (account_id, group_path)
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("create group_permissions: %w", err)
+	}
+
+	for _, col := range []string{
+		`ALTER TABLE accounts ADD COLUMN disabled INTEGER NOT NULL DEFAULT 0`,
+		`ALTER TABLE accounts ADD COLUMN last_login INTEGER NOT NULL DEFAULT 0`,
+		`ALTER TABLE accounts ADD COLUMN totp_secret TEXT NOT NULL DEFAULT ''`,
+		`ALTER TABLE accounts ADD COLUMN totp_enabled INTEGER NOT NULL DEFAULT 0`,
+		`ALTER TABLE accounts ADD COLUMN totp_last_step INTEGER NOT NULL DEFAULT 0`,
+	} {
+		if _, alterErr := d.sql.Exec(col); alterErr != nil {
+			if !isDuplicateColumnError(alterErr) {
+				return fmt.Errorf("alter accounts: %w", alterErr)
+			}
+		}
+	}
+
+	_, err = d.sql.Exec(`
+		CREATE TABLE IF NOT EXISTS recovery_codes (
+			account_id TEXT NOT NULL REFERENCES accounts(id) ON DELETE CASCADE,
+			code_hash  TEXT NOT NULL,
+			used_at    INTEGER NOT NULL DEFAULT 0,
+			PRIMARY KEY (account_id, code_hash)
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("create recovery_codes: %w", err)
+	}
 
 	_, err = d.sql.Exec(`
 		CREATE TABLE IF NOT EXISTS refresh_tokens (
@@ -176,6 +299,83 @@ func (d *DB) Migrate() error {
 	if err != nil {
 		return fmt.Errorf("create refresh_tokens: %w", err)
 	}
+	for _, col := range []string{
+		`ALTER TABLE refresh_tokens ADD COLUMN login_id TEXT NOT NULL DEFAULT ''`,
+		`ALTER TABLE refresh_tokens ADD COLUMN login_at INTEGER NOT NULL DEFAULT 0`,
+		`ALTER TABLE refresh_tokens ADD COLUMN last_used INTEGER NOT NULL DEFAULT 0`,
+		`ALTER TABLE refresh_tokens ADD COLUMN user_agent TEXT NOT NULL DEFAULT ''`,
+		`ALTER TABLE refresh_tokens ADD COLUMN ip TEXT NOT NULL DEFAULT ''`,
+	} {
+		if _, alterErr := d.sql.Exec(col); alterErr != nil {
+			if !isDuplicateColumnError(alterErr) {
+				return fmt.Errorf("alter refresh_tokens: %w", alterErr)
+			}
+		}
+	}
+	// Tokens issued before logins were tracked become their own login.
+	if _, err := d.sql.Exec(`UPDATE refresh_tokens SET login_id = token, login_at = created_at WHERE login_id = ''`); err != nil {
+		return fmt.Errorf("backfill refresh_tokens: %w", err)
+	}
+
+	_, err = d.sql.Exec(`
+		CREATE TABLE IF NOT EXISTS api_tokens (
+			id         TEXT PRIMARY KEY,
+			account_id TEXT NOT NULL REFERENCES accounts(id) ON DELETE CASCADE,
+			name       TEXT NOT NULL,
+			token_hash TEXT NOT NULL UNIQUE,
+			scopes     TEXT NOT NULL DEFAULT '',
+			created_at INTEGER NOT NULL,
+			last_used  INTEGER NOT NULL DEFAULT 0,
+			expires_at INTEGER NOT NULL DEFAULT 0
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("create api_tokens: %w", err)
+	}
+
+	_, err = d.sql.Exec(`
+		CREATE TABLE IF NOT EXISTS share_links (
+			id         TEXT PRIMARY KEY,
+			session_id TEXT NOT NULL,
+			created_by TEXT NOT NULL,
+			created_at INTEGER NOT NULL,
+			expires_at INTEGER NOT NULL,
+			revoked_at INTEGER NOT NULL DEFAULT 0
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("create share_links: %w", err)
+	}
+
+	// The audit log is append-only: triggers reject updates and deletes.
+	_, err = d.sql.Exec(`
+		CREATE TABLE IF NOT EXISTS audit_log (
+			id     INTEGER PRIMARY KEY AUTOINCREMENT,
+			ts     INTEGER NOT NULL,
+			actor  TEXT NOT NULL,
+			source TEXT NOT NULL,
+			ip     TEXT NOT NULL DEFAULT '',
+			action TEXT NOT NULL,
+			target TEXT NOT NULL DEFAULT '',
+			detail TEXT NOT NULL DEFAULT '',
+			result TEXT NOT NULL
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("create audit_log: %w", err)
+	}
+	for _, stmt := range []string{
+		`CREATE INDEX IF NOT EXISTS idx_audit_log_ts ON audit_log(ts)`,
+		`CREATE INDEX IF NOT EXISTS idx_audit_log_target ON audit_log(target, ts)`,
+		`CREATE TRIGGER IF NOT EXISTS audit_log_no_update BEFORE UPDATE ON audit_log
+			BEGIN SELECT RAISE(ABORT, 'audit_log is append-only'); END`,
+		`CREATE TRIGGER IF NOT EXISTS audit_log_no_delete BEFORE DELETE ON audit_log
+			BEGIN SELECT RAISE(ABORT, 'audit_log is append-only'); END`,
+	} {
+		if _, err := d.sql.Exec(stmt); err != nil {
+			return fmt.Errorf("audit_log schema: %w", err)
+		}
+	}
 
 	_, err = d.sql.Exec(`
 		CREATE TABLE IF NOT EXISTS usage_snapshots (
@@ -195,9 +395,83 @@ func (d *DB) Migrate() error {
 		return fmt.Errorf("create usage_snapshots: %w", err)
 	}
 
+	// No foreign key: usage is kept after its session is deleted.
+	_, err = d.sql.Exec(`
+		CREATE TABLE IF NOT EXISTS session_usage (
+			session_id         TEXT NOT NULL,
+			hour_ms            INTEGER NOT NULL,
+			model              TEXT NOT NULL,
+			title              TEXT NOT NULL DEFAULT '',
+			group_path         TEXT NOT NULL DEFAULT '',
+			messages           INTEGER NOT NULL DEFAULT 0,
+			input_tokens       INTEGER NOT NULL DEFAULT 0,
+			output_tokens      INTEGER NOT NULL DEFAULT 0,
+			cache_write_tokens INTEGER NOT NULL DEFAULT 0,
+			cache_read_tokens  INTEGER NOT NULL DEFAULT 0,
+			cost_usd           REAL NOT NULL DEFAULT 0,
+			PRIMARY KEY (session_id, hour_ms, model)
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("create session_usage: %w", err)
+	}
+	if _, err := d.sql.Exec(`CREATE INDEX IF NOT EXISTS idx_session_usage_hour ON session_usage(hour_ms)`); err != nil {
+		return fmt.Errorf("index session_usage: %w", err)
+	}
+
+	// What DeleteSession removed, so analytics can still name and group
+	// deleted sessions.
+	_, err = d.sql.Exec(`
+		CREATE TABLE IF NOT EXISTS deleted_sessions (
+			id         TEXT PRIMARY KEY,
+			title      TEXT NOT NULL DEFAULT '',
+			group_path TEXT NOT NULL DEFAULT '',
+			tool       TEXT NOT NULL DEFAULT '',
+			created_at INTEGER NOT NULL DEFAULT 0,
+			deleted_at INTEGER NOT NULL
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("create deleted_sessions: %w", err)
+	}
+
 	return nil
 }
 
+// dropSessionEventsForeignKey rebuilds a session_events table created with
+// a foreign key on sessions without it, keeping its rows.
+func (d *DB) dropSessionEventsForeignKey() error {
+	var n int
+	if err := d.sql.QueryRow(`SELECT COUNT(*) FROM pragma_foreign_key_list('session_events')`).Scan(&n); err != nil || n == 0 {
+		return err
+	}
+	tx, err := d.sql.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, stmt := range []string{
+		`CREATE TABLE session_events_new (
+			id         INTEGER PRIMARY KEY,
+			session_id TEXT NOT NULL,
+			ts         DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			event_type TEXT NOT NULL,
+			detail     TEXT NOT NULL DEFAULT '',
+			ts_ms      INTEGER NOT NULL DEFAULT 0
+		)`,
+		`INSERT INTO session_events_new (id, session_id, ts, event_type, detail, ts_ms)
+			SELECT id, session_id, ts, event_type, detail, ts_ms FROM session_events`,
+		`DROP TABLE session_events`,
+		`ALTER TABLE session_events_new RENAME TO session_events`,
+		`CREATE INDEX idx_session_events_session_id ON session_events(session_id, ts DESC)`,
+	} {
+		if _, err := tx.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
 func (d *DB) SaveSession(s *Session) error {
 	_, err := d.sql.Exec(`
 		INSERT OR REPLACE INTO sessions (
@@ -205,13 +479,13 @@ func (d *DB) SaveSession(s *Session) error {
 			command, tool, status, tmux_session,
 			created_at, last_accessed,
 			parent_session_id, worktree_path, worktree_repo, worktree_branch,
-			acknowledged, repo_url, has_uncommitted, notes
-		) VALUES (?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?)`,
+			acknowledged, repo_url, has_uncommitted, notes, owner
+		) VALUES (?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?)`,
 		s.ID, s.Title, s.ProjectPath, s.GroupPath, s.SortOrder,
 		s.Command, string(s.Tool), string(s.Status), s.TmuxSession,
 		s.CreatedAt.UnixMilli(), s.LastAccessed.UnixMilli(),
 		s.ParentSessionID, s.WorktreePath, s.WorktreeRepo, s.WorktreeBranch,
-		boolToInt(s.Acknowledged), s.RepoURL, boolToInt(s.HasUncommitted), s.Notes,
+		boolToInt(s.Acknowledged), s.RepoURL, boolToInt(s.HasUncommitted), s.Notes, s.Owner,
 	)
 	return err
 }
@@ -222,7 +496,7 @@ func (d *DB) GetSession(id string) (*Session, error) {
 			command, tool, status, tmux_session,
 			created_at, last_accessed,
 			parent_session_id, worktree_path, worktree_repo, worktree_branch,
-			acknowledged, repo_url, has_uncommitted, notes
+			acknowledged, repo_url, has_uncommitted, notes, owner
 		FROM sessions WHERE id = ?`, id)
 	return scanSession(row)
 }
@@ -233,7 +507,7 @@ func (d *DB) GetSessionByTmuxName(tmuxSession string) (*Session, error) {
 			command, tool, status, tmux_session,
 			created_at, last_accessed,
 			parent_session_id, worktree_path, worktree_repo, worktree_branch,
-			acknowledged, repo_url, has_uncommitted, notes
+			acknowledged, repo_url, has_uncommitted, notes, owner
 		FROM sessions WHERE tmux_session = ?`, tmuxSession)
 	return scanSession(row)
 }
@@ -244,7 +518,7 @@ func (d *DB) LoadSessions() ([]*Session, error) {
 			command, tool, status, tmux_session,
 			created_at, last_accessed,
 			parent_session_id, worktree_path, worktree_repo, worktree_branch,
-			acknowledged, repo_url, has_uncommitted, notes
+			acknowledged, repo_url, has_uncommitted, notes, owner
 		FROM sessions ORDER BY sort_order`)
 	if err != nil {
 		return nil, err
@@ -267,7 +541,7 @@ func (d *DB) LoadSessionsByGroupPath(groupPath string) ([]*Session, error) {
 			command, tool, status, tmux_session,
 			created_at, last_accessed,
 			parent_session_id, worktree_path, worktree_repo, worktree_branch,
-			acknowledged, repo_url, has_uncommitted, notes
+			acknowledged, repo_url, has_uncommitted, notes, owner
 		FROM sessions WHERE group_path = ? ORDER BY sort_order`, groupPath)
 	if err != nil {
 		return nil, err
@@ -299,7 +573,7 @@ func (d *DB) LoadSessionsByStatus(statuses ...SessionStatus) ([]*Session, error)
 			command, tool, status, tmux_session,
 			created_at, last_accessed,
 			parent_session_id, worktree_path, worktree_repo, worktree_branch,
-			acknowledged, repo_url, has_uncommitted, notes
+			acknowledged, repo_url, has_uncommitted, notes, owner
 		FROM sessions WHERE status IN (%s) ORDER BY sort_order`,
 		strings.Join(placeholders, ","))
 	rows, err := d.sql.Query(query, args...)
@@ -319,10 +593,94 @@ func (d *DB) LoadSessionsByStatus(statuses ...SessionStatus) ([]*Session, error)
 }
 
 func (d *DB) DeleteSession(id string) error {
+	if _, err := d.sql.Exec("DELETE FROM session_worktrees WHERE session_id = ?", id); err != nil {
+		return err
+	}
+	if _, err := d.sql.Exec("DELETE FROM review_comments WHERE session_id = ?", id); err != nil {
+		return err
+	}
+	if _, err := d.sql.Exec("DELETE FROM share_links WHERE session_id = ?", id); err != nil {
+		return err
+	}
+	// Its events are kept; remember what they belonged to.
+	if _, err := d.sql.Exec(`
+		INSERT OR REPLACE INTO deleted_sessions (id, title, group_path, tool, created_at, deleted_at)
+		SELECT id, title, group_path, tool, created_at, ? FROM sessions WHERE id = ?`,
+		time.Now().UnixMilli(), id); err != nil {
+		return err
+	}
 	_, err := d.sql.Exec("DELETE FROM sessions WHERE id = ?", id)
 	return err
 }
 
+// SaveSessionWorktrees replaces the worktrees recorded for a multi-repo session.
+func (d *DB) SaveSessionWorktrees(sessionID string, worktrees []*SessionWorktree) error {
+	tx, err := d.sql.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec("DELETE FROM session_worktrees WHERE session_id = ?", sessionID); err != nil {
+		return err
+	}
+	for i, wt := range worktrees {
+		_, err := tx.Exec(`
+			INSERT INTO session_worktrees (session_id, sort_order, repo_url, repo_path, worktree_path, branch)
+			VALUES (?,?,?,?,?,?)`,
+			sessionID, i, wt.RepoURL, wt.RepoPath, wt.WorktreePath, wt.Branch)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// GetSessionWorktrees returns the worktrees recorded for a multi-repo session,
+// in creation order. Single-repo sessions have none.
+func (d *DB) GetSessionWorktrees(sessionID string) ([]*SessionWorktree, error) {
+	rows, err := d.sql.Query(`
+		SELECT session_id, repo_url, repo_path, worktree_path, branch
+		FROM session_worktrees WHERE session_id = ? ORDER BY sort_order`, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanSessionWorktrees(rows)
+}
+
+// LoadSessionWorktrees returns the worktrees of every multi-repo session keyed
+// by session ID.
+func (d *DB) LoadSessionWorktrees() (map[string][]*SessionWorktree, error) {
+	rows, err := d.sql.Query(`
+		SELECT session_id, repo_url, repo_path, worktree_path, branch
+		FROM session_worktrees ORDER BY session_id, sort_order`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	wts, err := scanSessionWorktrees(rows)
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[string][]*SessionWorktree)
+	for _, wt := range wts {
+		out[wt.SessionID] = append(out[wt.SessionID], wt)
+	}
+	return out, nil
+}
+
+func scanSessionWorktrees(rows *sql.Rows) ([]*SessionWorktree, error) {
+	var wts []*SessionWorktree
+	for rows.Next() {
+		var wt SessionWorktree
+		if err := rows.Scan(&wt.SessionID, &wt.RepoURL, &wt.RepoPath, &wt.WorktreePath, &wt.Branch); err != nil {
+			return nil, err
+		}
+		wts = append(wts, &wt)
+	}
+	return wts, rows.Err()
+}
+
 func (d *DB) WriteStatus(id string, status SessionStatus, tool Tool) error {
 	_, err := d.sql.Exec("UPDATE sessions SET status = ?, tool = ? WHERE id = ?",
 		string(status), string(tool), id)
@@ -381,7 +739,7 @@ func scanSession(row rowScanner) (*Session, error) {
 		&s.Command, &tool, &status, &s.TmuxSession,
 		&createdAt, &lastAccessed,
 		&s.ParentSessionID, &s.WorktreePath, &s.WorktreeRepo, &s.WorktreeBranch,
-		&ack, &s.RepoURL, &hasUncommitted, &notes,
+		&ack, &s.RepoURL, &hasUncommitted, &notes, &s.Owner,
 	)
 	if err != nil {
 		return nil, err
@@ -419,8 +777,9 @@ func (d *DB) SaveGroups(groups []*Group) error {
 	}
 	for _, g := range groups {
 		if _, err := tx.Exec(
-			"INSERT INTO groups (path, name, expanded, sort_order, default_path, repo_url, default_tool, pre_launch_command) VALUES (?,?,?,?,?,?,?,?)",
-			g.Path, g.Name, boolToInt(g.Expanded), g.SortOrder, g.DefaultPath, g.RepoURL, string(g.DefaultTool), g.PreLaunchCommand,
+			"INSERT INTO groups (path, name, expanded, sort_order, default_path, repo_url, default_tool, pre_launch_command, base_branch, extra_repo_urls, checkpoints) VALUES (?,?,?,?,?,?,?,?,?,?,?)",
+			g.Path, g.Name, boolToInt(g.Expanded), g.SortOrder, g.DefaultPath, g.RepoURL, string(g.DefaultTool), g.PreLaunchCommand, g.BaseBranch,
+			strings.Join(g.ExtraRepoURLs, "\n"), boolToInt(g.Checkpoints),
 		); err != nil {
 			return err
 		}
@@ -429,7 +788,7 @@ func (d *DB) SaveGroups(groups []*Group) error {
 }
 
 func (d *DB) LoadGroups() ([]*Group, error) {
-	rows, err := d.sql.Query("SELECT path, name, expanded, sort_order, default_path, repo_url, default_tool, pre_launch_command FROM groups ORDER BY sort_order")
+	rows, err := d.sql.Query("SELECT path, name, expanded, sort_order, default_path, repo_url, default_tool, pre_launch_command, base_branch, extra_repo_urls, checkpoints FROM groups ORDER BY sort_order")
 	if err != nil {
 		return nil, err
 	}
@@ -437,13 +796,17 @@ func (d *DB) LoadGroups() ([]*Group, error) {
 	var groups []*Group
 	for rows.Next() {
 		var g Group
-		var expanded int
-		var defaultTool string
-		if err := rows.Scan(&g.Path, &g.Name, &expanded, &g.SortOrder, &g.DefaultPath, &g.RepoURL, &defaultTool, &g.PreLaunchCommand); err != nil {
+		var expanded, checkpoints int
+		var defaultTool, extraRepos string
+		if err := rows.Scan(&g.Path, &g.Name, &expanded, &g.SortOrder, &g.DefaultPath, &g.RepoURL, &defaultTool, &g.PreLaunchCommand, &g.BaseBranch, &extraRepos, &checkpoints); err != nil {
 			return nil, err
 		}
 		g.Expanded = expanded == 1
+		g.Checkpoints = checkpoints == 1
 		g.DefaultTool = Tool(defaultTool)
+		if extraRepos != "" {
+			g.ExtraRepoURLs = strings.Split(extraRepos, "\n")
+		}
 		groups = append(groups, &g)
 	}
 	return groups, rows.Err()
@@ -488,44 +851,210 @@ func randomID() string {
 	return hex.EncodeToString(b)
 }
 
-func (d *DB) CreateAccount(username, passwordHash string) (*Account, error) {
+func (d *DB) CreateAccount(username, passwordHash string, role Role) (*Account, error) {
 	acc := &Account{
 		ID:           randomID(),
 		Username:     username,
 		PasswordHash: passwordHash,
+		Role:         role,
 		CreatedAt:    time.Now(),
 	}
 	_, err := d.sql.Exec(
-		`INSERT INTO accounts (id, username, password_hash, created_at) VALUES (?,?,?,?)`,
-		acc.ID, acc.Username, acc.PasswordHash, acc.CreatedAt.UnixMilli(),
+		`INSERT INTO accounts (id, username, password_hash, role, created_at) VALUES (?,?,?,?,?)`,
+		acc.ID, acc.Username, acc.PasswordHash, string(acc.Role), acc.CreatedAt.UnixMilli(),
 	)
 	return acc, err
 }
 
+const accountColumns = `id, username, password_hash, role, created_at, disabled, last_login,
+	totp_secret, totp_enabled, totp_last_step`
+
 func (d *DB) GetAccountByUsername(username string) (*Account, error) {
 	row := d.sql.QueryRow(
-		`SELECT id, username, password_hash, created_at FROM accounts WHERE username = ?`, username)
-	var acc Account
-	var createdAt int64
-	if err := row.Scan(&acc.ID, &acc.Username, &acc.PasswordHash, &createdAt); err != nil {
-		return nil, err
-	}
-	acc.CreatedAt = time.UnixMilli(createdAt)
-	return &acc, nil
+		`SELECT `+accountColumns+` FROM accounts WHERE username = ?`, username)
+	return scanAccount(row)
 }
 
 func (d *DB) GetAccountByID(id string) (*Account, error) {
 	row := d.sql.QueryRow(
-		`SELECT id, username, password_hash, created_at FROM accounts WHERE id = ?`, id)
+		`SELECT `+accountColumns+` FROM accounts WHERE id = ?`, id)
+	return scanAccount(row)
+}
+
+// ListAccounts returns all accounts ordered by username.
+func (d *DB) ListAccounts() ([]*Account, error) {
+	rows, err := d.sql.Query(`SELECT ` + accountColumns + ` FROM accounts ORDER BY username`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var accounts []*Account
+	for rows.Next() {
+		acc, err := scanAccount(rows)
+		if err != nil {
+			return nil, err
+		}
+		accounts = append(accounts, acc)
+	}
+	return accounts, rows.Err()
+}
+
+func scanAccount(row rowScanner) (*Account, error) {
 	var acc Account
-	var createdAt int64
-	if err := row.Scan(&acc.ID, &acc.Username, &acc.PasswordHash, &createdAt); err != nil {
+	var role string
+	var createdAt, disabled, lastLogin, totpEnabled int64
+	if err := row.Scan(&acc.ID, &acc.Username, &acc.PasswordHash, &role, &createdAt, &disabled, &lastLogin,
+		&acc.TOTPSecret, &totpEnabled, &acc.TOTPLastStep); err != nil {
 		return nil, err
 	}
+	acc.TOTPEnabled = totpEnabled != 0
+	acc.Role = Role(role)
 	acc.CreatedAt = time.UnixMilli(createdAt)
+	acc.Disabled = disabled != 0
+	if lastLogin != 0 {
+		acc.LastLogin = time.UnixMilli(lastLogin)
+	}
 	return &acc, nil
 }
 
+// DeleteAccount removes an account with its group permissions and refresh
+// tokens.
+func (d *DB) DeleteAccount(id string) error {
+	res, err := d.sql.Exec(`DELETE FROM accounts WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// SetAccountDisabled disables or re-enables an account. Disabling also
+// revokes its refresh tokens.
+func (d *DB) SetAccountDisabled(id string, disabled bool) error {
+	if _, err := d.sql.Exec(`UPDATE accounts SET disabled = ? WHERE id = ?`, boolToInt(disabled), id); err != nil {
+		return err
+	}
+	if disabled {
+		return d.DeleteRefreshTokensByAccount(id)
+	}
+	return nil
+}
+
+// SetTOTPSecret starts TOTP enrollment with a new secret. Two-factor login
+// stays off until EnableTOTP.
+func (d *DB) SetTOTPSecret(id, secret string) error {
+	_, err := d.sql.Exec(`UPDATE accounts SET totp_secret = ?, totp_enabled = 0, totp_last_step = 0 WHERE id = ?`, secret, id)
+	return err
+}
+
+// EnableTOTP turns on two-factor login with the enrolled secret and stores
+// the hashes of a fresh set of recovery codes.
+func (d *DB) EnableTOTP(id string, recoveryHashes []string) error {
+	if _, err := d.sql.Exec(`UPDATE accounts SET totp_enabled = 1 WHERE id = ? AND totp_secret != ''`, id); err != nil {
+		return err
+	}
+	return d.ReplaceRecoveryCodes(id, recoveryHashes)
+}
+
+// DisableTOTP turns off two-factor login and drops the secret and recovery
+// codes.
+func (d *DB) DisableTOTP(id string) error {
+	if _, err := d.sql.Exec(`UPDATE accounts SET totp_secret = '', totp_enabled = 0, totp_last_step = 0 WHERE id = ?`, id); err != nil {
+		return err
+	}
+	_, err := d.sql.Exec(`DELETE FROM recovery_codes WHERE account_id = ?`, id)
+	return err
+}
+
+// UseTOTPStep records step as the last accepted code's time step. It
+// returns false if a code from that step or a later one was already used.
+func (d *DB) UseTOTPStep(id string, step int64) (bool, error) {
+	res, err := d.sql.Exec(`UPDATE accounts SET totp_last_step = ? WHERE id = ? AND totp_last_step < ?`, step, id, step)
+	if err != nil {
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+	return n == 1, nil
+}
+
+// ReplaceRecoveryCodes replaces the account's recovery codes.
+func (d *DB) ReplaceRecoveryCodes(id string, hashes []string) error {
+	if _, err := d.sql.Exec(`DELETE FROM recovery_codes WHERE account_id = ?`, id); err != nil {
+		return err
+	}
+	for _, h := range hashes {
+		if _, err := d.sql.Exec(`INSERT INTO recovery_codes (account_id, code_hash) VALUES (?,?)`, id, h); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// UseRecoveryCode marks an unused recovery code as used. It returns false if
+// the account has no such unused code.
+func (d *DB) UseRecoveryCode(id, hash string) (bool, error) {
+	res, err := d.sql.Exec(`UPDATE recovery_codes SET used_at = ? WHERE account_id = ? AND code_hash = ? AND used_at = 0`,
+		time.Now().UnixMilli(), id, hash)
+	if err != nil {
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+	return n == 1, nil
+}
+
+// CountRecoveryCodes returns how many unused recovery codes the account has.
+func (d *DB) CountRecoveryCodes(id string) (int, error) {
+	var n int
+	err := d.sql.QueryRow(`SELECT COUNT(*) FROM recovery_codes WHERE account_id = ? AND used_at = 0`, id).Scan(&n)
+	return n, err
+}
+
+// RecordLogin sets the account's last login time to now.
+func (d *DB) RecordLogin(id string) error {
+	_, err := d.sql.Exec(`UPDATE accounts SET last_login = ? WHERE id = ?`, time.Now().UnixMilli(), id)
+	return err
+}
+
+func (d *DB) UpdateAccountRole(id string, role Role) error {
+	_, err := d.sql.Exec(`UPDATE accounts SET role = ? WHERE id = ?`, string(role), id)
+	return err
+}
+
+// SetGroupPermission gives the account role in the group at groupPath,
+// overriding its account-wide role there. An empty role removes the
+// override.
+func (d *DB) SetGroupPermission(accountID, groupPath string, role Role) error {
+	if role == "" {
+		_, err := d.sql.Exec(`DELETE FROM group_permissions WHERE account_id = ? AND group_path = ?`, accountID, groupPath)
+		return err
+	}
+	_, err := d.sql.Exec(
+		`INSERT OR REPLACE INTO group_permissions (account_id, group_path, role) VALUES (?,?,?)`,
+		accountID, groupPath, string(role))
+	return err
+}
+
+// GetGroupPermissions returns the account's per-group roles keyed by group
+// path.
+func (d *DB) GetGroupPermissions(accountID string) (map[string]Role, error) {
+	rows, err := d.sql.Query(`SELECT group_path, role FROM group_permissions WHERE account_id = ?`, accountID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	perms := map[string]Role{}
+	for rows.Next() {
+		var path, role string
+		if err := rows.Scan(&path, &role); err != nil {
+			return nil, err
+		}
+		perms[path] = Role(role)
+	}
+	return perms, rows.Err()
+}
+
 func (d *DB) UpdateAccountPassword(id, passwordHash string) error {
 	_, err := d.sql.Exec(`UPDATE accounts SET password_hash = ? WHERE id = ?`, passwordHash, id)
 	return err
@@ -537,27 +1066,94 @@ func (d *DB) HasAnyAccount() (bool, error) {
 	return count > 0, err
 }
 
+// CreateRefreshToken stores token as the first token of a new login.
 func (d *DB) CreateRefreshToken(token, accountID string, expiresAt time.Time) error {
+	return d.SaveRefreshToken(&RefreshToken{Token: token, AccountID: accountID, ExpiresAt: expiresAt})
+}
+
+// SaveRefreshToken stores rt. CreatedAt and LastUsed are set to now; a new
+// LoginID and LoginAt are assigned unless rt continues an existing login.
+func (d *DB) SaveRefreshToken(rt *RefreshToken) error {
+	now := time.Now()
+	rt.CreatedAt, rt.LastUsed = now, now
+	if rt.LoginID == "" {
+		rt.LoginID, rt.LoginAt = randomID(), now
+	}
 	_, err := d.sql.Exec(
-		`INSERT INTO refresh_tokens (token, account_id, expires_at, created_at) VALUES (?,?,?,?)`,
-		token, accountID, expiresAt.UnixMilli(), time.Now().UnixMilli(),
+		`INSERT INTO refresh_tokens (token, account_id, expires_at, created_at, login_id, login_at, last_used, user_agent, ip)
+		 VALUES (?,?,?,?,?,?,?,?,?)`,
+		rt.Token, rt.AccountID, rt.ExpiresAt.UnixMilli(), rt.CreatedAt.UnixMilli(),
+		rt.LoginID, rt.LoginAt.UnixMilli(), rt.LastUsed.UnixMilli(), rt.UserAgent, rt.IP,
 	)
 	return err
 }
 
-func (d *DB) GetRefreshToken(token string) (*RefreshToken, error) {
-	row := d.sql.QueryRow(
-		`SELECT token, account_id, expires_at, created_at FROM refresh_tokens WHERE token = ?`, token)
+const refreshTokenColumns = `token, account_id, expires_at, created_at, login_id, login_at, last_used, user_agent, ip`
+
+func scanRefreshToken(row rowScanner) (*RefreshToken, error) {
 	var rt RefreshToken
-	var expiresAt, createdAt int64
-	if err := row.Scan(&rt.Token, &rt.AccountID, &expiresAt, &createdAt); err != nil {
+	var expiresAt, createdAt, loginAt, lastUsed int64
+	if err := row.Scan(&rt.Token, &rt.AccountID, &expiresAt, &createdAt,
+		&rt.LoginID, &loginAt, &lastUsed, &rt.UserAgent, &rt.IP); err != nil {
 		return nil, err
 	}
 	rt.ExpiresAt = time.UnixMilli(expiresAt)
 	rt.CreatedAt = time.UnixMilli(createdAt)
+	rt.LoginAt = time.UnixMilli(loginAt)
+	rt.LastUsed = time.UnixMilli(lastUsed)
 	return &rt, nil
 }
 
+func (d *DB) GetRefreshToken(token string) (*RefreshToken, error) {
+	row := d.sql.QueryRow(
+		`SELECT `+refreshTokenColumns+` FROM refresh_tokens WHERE token = ?`, token)
+	return scanRefreshToken(row)
+}
+
+// ListRefreshTokens returns the account's unexpired logins, most recently
+// used first.
+func (d *DB) ListRefreshTokens(accountID string) ([]*RefreshToken, error) {
+	rows, err := d.sql.Query(
+		`SELECT `+refreshTokenColumns+` FROM refresh_tokens
+		 WHERE account_id = ? AND expires_at > ? ORDER BY last_used DESC`,
+		accountID, time.Now().UnixMilli())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var tokens []*RefreshToken
+	for rows.Next() {
+		rt, err := scanRefreshToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, rt)
+	}
+	return tokens, rows.Err()
+}
+
+// DeleteLogin revokes one login of an account.
+func (d *DB) DeleteLogin(accountID, loginID string) error {
+	res, err := d.sql.Exec(`DELETE FROM refresh_tokens WHERE account_id = ? AND login_id = ?`, accountID, loginID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// DeleteExpiredRefreshTokens removes expired tokens and returns how many
+// were removed.
+func (d *DB) DeleteExpiredRefreshTokens() (int64, error) {
+	res, err := d.sql.Exec(`DELETE FROM refresh_tokens WHERE expires_at <= ?`, time.Now().UnixMilli())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
 func (d *DB) DeleteRefreshToken(token string) error {
 	_, err := d.sql.Exec(`DELETE FROM refresh_tokens WHERE token = ?`, token)
 	return err
@@ -568,6 +1164,160 @@ func (d *DB) DeleteRefreshTokensByAccount(accountID string) error {
 	return err
 }
 
+// CreateAPIToken stores t with a new ID. CreatedAt is set to now.
+func (d *DB) CreateAPIToken(t *APIToken) error {
+	t.ID, t.CreatedAt = randomID()[:12], time.Now()
+	scopes := make([]string, len(t.Scopes))
+	for i, s := range t.Scopes {
+		scopes[i] = string(s)
+	}
+	var expiresAt int64
+	if !t.ExpiresAt.IsZero() {
+		expiresAt = t.ExpiresAt.UnixMilli()
+	}
+	_, err := d.sql.Exec(
+		`INSERT INTO api_tokens (id, account_id, name, token_hash, scopes, created_at, expires_at)
+		 VALUES (?,?,?,?,?,?,?)`,
+		t.ID, t.AccountID, t.Name, t.Hash, strings.Join(scopes, " "), t.CreatedAt.UnixMilli(), expiresAt,
+	)
+	return err
+}
+
+const apiTokenColumns = `t.id, t.account_id, a.username, t.name, t.token_hash, t.scopes, t.created_at, t.last_used, t.expires_at`
+
+func scanAPIToken(row rowScanner) (*APIToken, error) {
+	var t APIToken
+	var scopes string
+	var createdAt, lastUsed, expiresAt int64
+	if err := row.Scan(&t.ID, &t.AccountID, &t.Username, &t.Name, &t.Hash, &scopes,
+		&createdAt, &lastUsed, &expiresAt); err != nil {
+		return nil, err
+	}
+	for _, s := range strings.Fields(scopes) {
+		t.Scopes = append(t.Scopes, Scope(s))
+	}
+	t.CreatedAt = time.UnixMilli(createdAt)
+	if lastUsed != 0 {
+		t.LastUsed = time.UnixMilli(lastUsed)
+	}
+	if expiresAt != 0 {
+		t.ExpiresAt = time.UnixMilli(expiresAt)
+	}
+	return &t, nil
+}
+
+// GetAPITokenByHash returns the token with the given hash, expired or not.
+func (d *DB) GetAPITokenByHash(hash string) (*APIToken, error) {
+	row := d.sql.QueryRow(`SELECT `+apiTokenColumns+` FROM api_tokens t
+		JOIN accounts a ON a.id = t.account_id WHERE t.token_hash = ?`, hash)
+	return scanAPIToken(row)
+}
+
+// ListAPITokens returns the account's API tokens, or every account's when
+// accountID is empty, oldest first.
+func (d *DB) ListAPITokens(accountID string) ([]*APIToken, error) {
+	rows, err := d.sql.Query(`SELECT `+apiTokenColumns+` FROM api_tokens t
+		JOIN accounts a ON a.id = t.account_id
+		WHERE ? = '' OR t.account_id = ? ORDER BY t.created_at`, accountID, accountID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var tokens []*APIToken
+	for rows.Next() {
+		t, err := scanAPIToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, t)
+	}
+	return tokens, rows.Err()
+}
+
+// TouchAPIToken sets the token's last use to now.
+func (d *DB) TouchAPIToken(id string) error {
+	_, err := d.sql.Exec(`UPDATE api_tokens SET last_used = ? WHERE id = ?`, time.Now().UnixMilli(), id)
+	return err
+}
+
+// DeleteAPIToken revokes a token. It returns sql.ErrNoRows if there is none.
+func (d *DB) DeleteAPIToken(id string) error {
+	res, err := d.sql.Exec(`DELETE FROM api_tokens WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// CreateShareLink records a new share link, filling in its ID and creation
+// time.
+func (d *DB) CreateShareLink(l *ShareLink) error {
+	l.ID, l.CreatedAt = randomID()[:12], time.Now()
+	_, err := d.sql.Exec(
+		`INSERT INTO share_links (id, session_id, created_by, created_at, expires_at) VALUES (?,?,?,?,?)`,
+		l.ID, l.SessionID, l.CreatedBy, l.CreatedAt.UnixMilli(), l.ExpiresAt.UnixMilli(),
+	)
+	return err
+}
+
+const shareLinkColumns = `id, session_id, created_by, created_at, expires_at, revoked_at`
+
+func scanShareLink(row rowScanner) (*ShareLink, error) {
+	var l ShareLink
+	var createdAt, expiresAt, revokedAt int64
+	if err := row.Scan(&l.ID, &l.SessionID, &l.CreatedBy, &createdAt, &expiresAt, &revokedAt); err != nil {
+		return nil, err
+	}
+	l.CreatedAt, l.ExpiresAt = time.UnixMilli(createdAt), time.UnixMilli(expiresAt)
+	if revokedAt != 0 {
+		l.RevokedAt = time.UnixMilli(revokedAt)
+	}
+	return &l, nil
+}
+
+// GetShareLink returns the share link with the given ID, active or not.
+func (d *DB) GetShareLink(id string) (*ShareLink, error) {
+	return scanShareLink(d.sql.QueryRow(`SELECT `+shareLinkColumns+` FROM share_links WHERE id = ?`, id))
+}
+
+// ListShareLinks returns the session's share links that are neither expired
+// nor revoked, newest first.
+func (d *DB) ListShareLinks(sessionID string) ([]*ShareLink, error) {
+	rows, err := d.sql.Query(`SELECT `+shareLinkColumns+` FROM share_links
+		WHERE session_id = ? AND revoked_at = 0 AND expires_at > ?
+		ORDER BY created_at DESC`, sessionID, time.Now().UnixMilli())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var links []*ShareLink
+	for rows.Next() {
+		l, err := scanShareLink(rows)
+		if err != nil {
+			return nil, err
+		}
+		links = append(links, l)
+	}
+	return links, rows.Err()
+}
+
+// RevokeShareLink ends a share link now. It returns sql.ErrNoRows if there
+// is no active link with that ID.
+func (d *DB) RevokeShareLink(id string) error {
+	res, err := d.sql.Exec(`UPDATE share_links SET revoked_at = ? WHERE id = ? AND revoked_at = 0`,
+		time.Now().UnixMilli(), id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
 func (d *DB) IsEmpty() (bool, error) {
 	var count int
 	err := d.sql.QueryRow("SELECT COUNT(*) FROM sessions").Scan(&count)
@@ -633,6 +1383,109 @@ func scanUsageSnapshot(row rowScanner) (*UsageSnapshot, error) {
 	return &s, nil
 }
 
+// ReplaceSessionUsage replaces the hourly usage recorded for a session.
+func (d *DB) ReplaceSessionUsage(sessionID string, usage []SessionUsage) error {
+	tx, err := d.sql.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec("DELETE FROM session_usage WHERE session_id = ?", sessionID); err != nil {
+		return err
+	}
+	for _, u := range usage {
+		_, err := tx.Exec(`
+			INSERT INTO session_usage (
+				session_id, hour_ms, model, title, group_path, messages,
+				input_tokens, output_tokens, cache_write_tokens, cache_read_tokens, cost_usd
+			) VALUES (?,?,?,?,?,?,?,?,?,?,?)`,
+			sessionID, u.HourMs, u.Model, u.Title, u.GroupPath, u.Messages,
+			u.InputTokens, u.OutputTokens, u.CacheWriteTokens, u.CacheReadTokens, u.CostUSD)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// GetSessionUsage returns a session's hourly usage since the given time,
+// oldest first.
+func (d *DB) GetSessionUsage(sessionID string, since time.Time) ([]SessionUsage, error) {
+	rows, err := d.sql.Query(`
+		SELECT session_id, title, group_path, hour_ms, model, messages,
+			input_tokens, output_tokens, cache_write_tokens, cache_read_tokens, cost_usd
+		FROM session_usage WHERE session_id = ? AND hour_ms >= ?
+		ORDER BY hour_ms, model`, sessionID, hourStart(since))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []SessionUsage
+	for rows.Next() {
+		var u SessionUsage
+		err := rows.Scan(&u.SessionID, &u.Title, &u.GroupPath, &u.HourMs, &u.Model, &u.Messages,
+			&u.InputTokens, &u.OutputTokens, &u.CacheWriteTokens, &u.CacheReadTokens, &u.CostUSD)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, u)
+	}
+	return out, rows.Err()
+}
+
+// SessionUsageTotals sums each session's usage since the given time, most
+// expensive first. Sessions that still exist are listed under their current
+// title and group.
+func (d *DB) SessionUsageTotals(since time.Time) ([]UsageTotal, error) {
+	return d.usageTotals(`
+		SELECT u.session_id, COALESCE(s.title, MAX(u.title)), COALESCE(s.group_path, MAX(u.group_path)),
+			SUM(u.messages), SUM(u.input_tokens), SUM(u.output_tokens),
+			SUM(u.cache_write_tokens), SUM(u.cache_read_tokens), SUM(u.cost_usd)
+		FROM session_usage u LEFT JOIN sessions s ON s.id = u.session_id
+		WHERE u.hour_ms >= ?
+		GROUP BY u.session_id
+		ORDER BY SUM(u.cost_usd) DESC, u.session_id`, since)
+}
+
+// GroupUsageTotals sums the usage of each group's sessions since the given
+// time, most expensive first.
+func (d *DB) GroupUsageTotals(since time.Time) ([]UsageTotal, error) {
+	return d.usageTotals(`
+		SELECT '', '', grp, SUM(messages), SUM(input_tokens), SUM(output_tokens),
+			SUM(cache_write_tokens), SUM(cache_read_tokens), SUM(cost_usd)
+		FROM (
+			SELECT COALESCE(s.group_path, u.group_path) AS grp, u.*
+			FROM session_usage u LEFT JOIN sessions s ON s.id = u.session_id
+			WHERE u.hour_ms >= ?
+		)
+		GROUP BY grp
+		ORDER BY SUM(cost_usd) DESC, grp`, since)
+}
+
+func (d *DB) usageTotals(query string, since time.Time) ([]UsageTotal, error) {
+	rows, err := d.sql.Query(query, hourStart(since))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []UsageTotal
+	for rows.Next() {
+		var t UsageTotal
+		err := rows.Scan(&t.SessionID, &t.Title, &t.GroupPath, &t.Messages, &t.InputTokens, &t.OutputTokens,
+			&t.CacheWriteTokens, &t.CacheReadTokens, &t.CostUSD)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, t)
+	}
+	return out, rows.Err()
+}
+
+// hourStart returns the start of the hour t falls in, in Unix ms.
+func hourStart(t time.Time) int64 {
+	return t.Truncate(time.Hour).UnixMilli()
+}
+
 func (d *DB) InsertSessionEvent(sessionID, eventType, detail string) error {
 	_, err := d.sql.Exec(
 		`INSERT INTO session_events (session_id, ts_ms, event_type, detail) VALUES (?, ?, ?, ?)`,
@@ -669,3 +1522,264 @@ func (d *DB) GetSessionEvents(sessionID string, limit int) ([]SessionEvent, erro
 	}
 	return events, rows.Err()
 }
+
+// SessionEventsOfType returns the events of the given types, of live and
+// deleted sessions alike, recorded from since to until, oldest first. Each
+// session's latest such event before since comes first, so callers know
+// what state it was in at since. Events with no time are skipped.
+func (d *DB) SessionEventsOfType(since, until time.Time, types ...string) ([]SessionEvent, error) {
+	if len(types) == 0 {
+		return nil, nil
+	}
+	args := make([]any, 0, len(types)+3)
+	for _, t := range types {
+		args = append(args, t)
+	}
+	args = append(args, until.UnixMilli(), since.UnixMilli(), since.UnixMilli())
+	rows, err := d.sql.Query(
+		`WITH typed AS (
+			SELECT id, session_id, ts_ms, event_type, detail
+			FROM session_events
+			WHERE event_type IN (?`+strings.Repeat(",?", len(types)-1)+`) AND ts_ms > 0 AND ts_ms <= ?
+		), marked AS (
+			SELECT *, ts_ms >= ? AS inside,
+				ROW_NUMBER() OVER (PARTITION BY session_id, ts_ms >= ? ORDER BY ts_ms DESC, id DESC) AS n
+			FROM typed
+		)
+		SELECT id, session_id, ts_ms, event_type, detail
+		FROM marked
+		WHERE inside OR n = 1
+		ORDER BY ts_ms, id`,
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []SessionEvent
+	for rows.Next() {
+		var e SessionEvent
+		var tsMs int64
+		if err := rows.Scan(&e.ID, &e.SessionID, &tsMs, &e.EventType, &e.Detail); err != nil {
+			return nil, err
+		}
+		e.Ts = time.UnixMilli(tsMs)
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
+
+// SessionRecords returns every live session and every deleted one, live
+// first.
+func (d *DB) SessionRecords() ([]SessionRecord, error) {
+	rows, err := d.sql.Query(`
+		SELECT id, title, group_path, tool, status, created_at, 0 FROM sessions
+		UNION ALL
+		SELECT id, title, group_path, tool, '', created_at, deleted_at FROM deleted_sessions
+			WHERE id NOT IN (SELECT id FROM sessions)`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []SessionRecord
+	for rows.Next() {
+		var r SessionRecord
+		var tool, status string
+		var createdMs, deletedMs int64
+		if err := rows.Scan(&r.ID, &r.Title, &r.GroupPath, &tool, &status, &createdMs, &deletedMs); err != nil {
+			return nil, err
+		}
+		r.Tool, r.Status = Tool(tool), SessionStatus(status)
+		r.CreatedAt = time.UnixMilli(createdMs)
+		if deletedMs > 0 {
+			r.DeletedAt = time.UnixMilli(deletedMs)
+		}
+		out = append(out, r)
+	}
+	return out, rows.Err()
+}
+
+// AddReviewComment stores c and sets its ID and CreatedAt.
+func (d *DB) AddReviewComment(c *ReviewComment) error {
+	c.CreatedAt = time.Now()
+	res, err := d.sql.Exec(
+		`INSERT INTO review_comments (session_id, repo, file, line_start, line_end, commit_sha, body, created_at)
+		 VALUES (?,?,?,?,?,?,?,?)`,
+		c.SessionID, c.Repo, c.File, c.LineStart, c.LineEnd, c.Commit, c.Body, c.CreatedAt.UnixMilli(),
+	)
+	if err != nil {
+		return err
+	}
+	c.ID, err = res.LastInsertId()
+	return err
+}
+
+// GetReviewComments returns the comments of a session ordered by file and
+// line. Sent comments are only included if includeSent is set.
+func (d *DB) GetReviewComments(sessionID string, includeSent bool) ([]*ReviewComment, error) {
+	q := `SELECT id, session_id, repo, file, line_start, line_end, commit_sha, body, created_at, sent_at
+		FROM review_comments WHERE session_id = ?`
+	if !includeSent {
+		q += ` AND sent_at = 0`
+	}
+	rows, err := d.sql.Query(q+` ORDER BY repo, commit_sha, file, line_start, id`, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	return scanReviewComments(rows)
+}
+
+func scanReviewComments(rows *sql.Rows) ([]*ReviewComment, error) {
+	defer rows.Close()
+	var comments []*ReviewComment
+	for rows.Next() {
+		var c ReviewComment
+		var createdAt, sentAt int64
+		if err := rows.Scan(&c.ID, &c.SessionID, &c.Repo, &c.File, &c.LineStart, &c.LineEnd,
+			&c.Commit, &c.Body, &createdAt, &sentAt); err != nil {
+			return nil, err
+		}
+		c.CreatedAt = time.UnixMilli(createdAt)
+		if sentAt != 0 {
+			c.SentAt = time.UnixMilli(sentAt)
+		}
+		comments = append(comments, &c)
+	}
+	return comments, rows.Err()
+}
+
+// DeleteReviewComment removes comment id of a session.
+func (d *DB) DeleteReviewComment(sessionID string, id int64) error {
+	res, err := d.sql.Exec(`DELETE FROM review_comments WHERE id = ? AND session_id = ?`, id, sessionID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// ClaimReviewComments marks the open comments of a session sent and returns
+// them, in one transaction, so two senders never both get the same comment.
+// A sender that then fails to deliver them calls UnmarkReviewCommentsSent.
+func (d *DB) ClaimReviewComments(sessionID string) ([]*ReviewComment, error) {
+	tx, err := d.sql.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	rows, err := tx.Query(`SELECT id, session_id, repo, file, line_start, line_end, commit_sha, body, created_at, sent_at
+		FROM review_comments WHERE session_id = ? AND sent_at = 0
+		ORDER BY repo, commit_sha, file, line_start, id`, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	comments, err := scanReviewComments(rows)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	for _, c := range comments {
+		if _, err := tx.Exec(`UPDATE review_comments SET sent_at = ? WHERE id = ?`, now.UnixMilli(), c.ID); err != nil {
+			return nil, err
+		}
+		c.SentAt = now
+	}
+	return comments, tx.Commit()
+}
+
+// UnmarkReviewCommentsSent reopens comments that were claimed but not sent.
+func (d *DB) UnmarkReviewCommentsSent(ids []int64) error {
+	for _, id := range ids {
+		if _, err := d.sql.Exec(`UPDATE review_comments SET sent_at = 0 WHERE id = ?`, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MarkReviewCommentsSent records the comments as sent to the session.
+func (d *DB) MarkReviewCommentsSent(ids []int64) error {
+	now := time.Now().UnixMilli()
+	for _, id := range ids {
+		if _, err := d.sql.Exec(`UPDATE review_comments SET sent_at = ? WHERE id = ?`, now, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// InsertAudit appends e to the audit log, setting its ID and, if unset, Ts.
+func (d *DB) InsertAudit(e *AuditEntry) error {
+	if e.Ts.IsZero() {
+		e.Ts = time.Now()
+	}
+	res, err := d.sql.Exec(
+		`INSERT INTO audit_log (ts, actor, source, ip, action, target, detail, result)
+		 VALUES (?,?,?,?,?,?,?,?)`,
+		e.Ts.UnixMilli(), e.Actor, e.Source, e.IP, e.Action, e.Target, e.Detail, e.Result,
+	)
+	if err != nil {
+		return err
+	}
+	e.ID, err = res.LastInsertId()
+	return err
+}
+
+// QueryAudit returns the audit entries matching f, newest first.
+func (d *DB) QueryAudit(f AuditFilter) ([]AuditEntry, error) {
+	q := `SELECT id, ts, actor, source, ip, action, target, detail, result FROM audit_log WHERE 1=1`
+	var args []any
+	if f.Actor != "" {
+		q += ` AND actor = ?`
+		args = append(args, f.Actor)
+	}
+	if strings.HasSuffix(f.Action, ".") {
+		q += ` AND substr(action, 1, ?) = ?`
+		args = append(args, len(f.Action), f.Action)
+	} else if f.Action != "" {
+		q += ` AND action = ?`
+		args = append(args, f.Action)
+	}
+	if f.Target != "" {
+		q += ` AND target = ?`
+		args = append(args, f.Target)
+	}
+	if f.Source != "" {
+		q += ` AND source = ?`
+		args = append(args, f.Source)
+	}
+	if !f.Since.IsZero() {
+		q += ` AND ts >= ?`
+		args = append(args, f.Since.UnixMilli())
+	}
+	if !f.Until.IsZero() {
+		q += ` AND ts < ?`
+		args = append(args, f.Until.UnixMilli())
+	}
+	if f.Limit <= 0 {
+		f.Limit = 100
+	}
+	q += ` ORDER BY id DESC LIMIT ?`
+	args = append(args, f.Limit)
+
+	rows, err := d.sql.Query(q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var entries []AuditEntry
+	for rows.Next() {
+		var e AuditEntry
+		var ts int64
+		if err := rows.Scan(&e.ID, &ts, &e.Actor, &e.Source, &e.IP, &e.Action, &e.Target, &e.Detail, &e.Result); err != nil {
+			return nil, err
+		}
+		e.Ts = time.UnixMilli(ts)
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
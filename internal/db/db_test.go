@@ -1,6 +1,9 @@
 package db_test
 
 import (
+	"database/sql"
+	"errors"
+	"path/filepath"
 	"testing"
 	"time"
 
@@ -150,6 +153,80 @@ func TestGroupDefaultToolRoundTrip(t *testing.T) {
 	}
 }
 
+func TestGroupBaseBranchRoundTrip(t *testing.T) {
+	store := openTestDB(t)
+	if err := store.SaveGroups([]*db.Group{
+		{Path: "work", Name: "Work", RepoURL: "https://github.com/o/r", BaseBranch: "develop"},
+	}); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	got, err := store.LoadGroups()
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if got[0].BaseBranch != "develop" {
+		t.Errorf("BaseBranch: got %q want %q", got[0].BaseBranch, "develop")
+	}
+}
+
+func TestGroupCheckpointsRoundTrip(t *testing.T) {
+	store := openTestDB(t)
+	if err := store.SaveGroups([]*db.Group{
+		{Path: "on", Name: "On", Checkpoints: true},
+		{Path: "off", Name: "Off", SortOrder: 1},
+	}); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	got, err := store.LoadGroups()
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if !got[0].Checkpoints || got[1].Checkpoints {
+		t.Errorf("Checkpoints: got %v, %v want true, false", got[0].Checkpoints, got[1].Checkpoints)
+	}
+}
+
+func TestSessionWorktrees(t *testing.T) {
+	store := openTestDB(t)
+	now := time.Now()
+	s := &db.Session{ID: "multi", Title: "multi", GroupPath: "g", Tool: db.ToolClaude,
+		Status: db.StatusRunning, CreatedAt: now, LastAccessed: now}
+	if err := store.SaveSession(s); err != nil {
+		t.Fatal(err)
+	}
+	wts := []*db.SessionWorktree{
+		{RepoURL: "https://github.com/o/svc", RepoPath: "/r/svc.git", WorktreePath: "/w/sessions/b/svc", Branch: "b"},
+		{RepoURL: "https://github.com/o/proto", RepoPath: "/r/proto.git", WorktreePath: "/w/sessions/b/proto", Branch: "b"},
+	}
+	if err := store.SaveSessionWorktrees(s.ID, wts); err != nil {
+		t.Fatal(err)
+	}
+
+	// Re-saving the session row must not drop its worktrees.
+	s.Status = db.StatusIdle
+	if err := store.SaveSession(s); err != nil {
+		t.Fatal(err)
+	}
+	got, err := store.GetSessionWorktrees(s.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[0].WorktreePath != "/w/sessions/b/svc" || got[1].RepoPath != "/r/proto.git" {
+		t.Fatalf("unexpected worktrees: %+v", got)
+	}
+	all, _ := store.LoadSessionWorktrees()
+	if len(all[s.ID]) != 2 {
+		t.Errorf("LoadSessionWorktrees: got %d for session, want 2", len(all[s.ID]))
+	}
+
+	if err := store.DeleteSession(s.ID); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := store.GetSessionWorktrees(s.ID); len(got) != 0 {
+		t.Errorf("expected worktrees deleted with session, got %d", len(got))
+	}
+}
+
 func openTestDB(t *testing.T) *db.DB {
 	t.Helper()
 	store, err := db.Open(":memory:")
@@ -204,7 +281,7 @@ func TestAccountCRUD(t *testing.T) {
 	defer store.Close()
 
 	// CreateAccount
-	acc, err := store.CreateAccount("alice", "hashed-pw")
+	acc, err := store.CreateAccount("alice", "hashed-pw", db.RoleAdmin)
 	if err != nil {
 		t.Fatalf("CreateAccount: %v", err)
 	}
@@ -243,12 +320,62 @@ func TestAccountCRUD(t *testing.T) {
 	}
 }
 
+func TestAccountRolesAndGroupPermissions(t *testing.T) {
+	store := openTestDB(t)
+	acc, err := store.CreateAccount("pm", "pw", db.RoleViewer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, _ := store.GetAccountByID(acc.ID)
+	if got.Role != db.RoleViewer {
+		t.Errorf("role: got %q want viewer", got.Role)
+	}
+	if err := store.UpdateAccountRole(acc.ID, db.RoleOperator); err != nil {
+		t.Fatal(err)
+	}
+	got, _ = store.GetAccountByUsername("pm")
+	if got.Role != db.RoleOperator {
+		t.Errorf("updated role: got %q want operator", got.Role)
+	}
+
+	store.SetGroupPermission(acc.ID, "work", db.RoleViewer)
+	store.SetGroupPermission(acc.ID, "ops", db.RoleAdmin)
+	store.SetGroupPermission(acc.ID, "ops", "")
+	perms, err := store.GetGroupPermissions(acc.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(perms) != 1 || perms["work"] != db.RoleViewer {
+		t.Errorf("group permissions: %v", perms)
+	}
+
+	if !db.RoleAdmin.Allows(db.RoleOperator) || db.RoleViewer.Allows(db.RoleOperator) || db.Role("root").Allows(db.RoleViewer) {
+		t.Error("unexpected Allows result")
+	}
+}
+
+func TestSessionOwnerRoundTrip(t *testing.T) {
+	store := openTestDB(t)
+	now := time.Now()
+	if err := store.SaveSession(&db.Session{ID: "owned", Title: "owned", GroupPath: "g", Tool: db.ToolShell,
+		Status: db.StatusIdle, CreatedAt: now, LastAccessed: now, Owner: "alice"}); err != nil {
+		t.Fatal(err)
+	}
+	got, err := store.GetSession("owned")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Owner != "alice" {
+		t.Errorf("Owner: got %q want alice", got.Owner)
+	}
+}
+
 func TestRefreshTokenCRUD(t *testing.T) {
 	store, _ := db.Open(":memory:")
 	store.Migrate()
 	defer store.Close()
 
-	acc, _ := store.CreateAccount("bob", "pw")
+	acc, _ := store.CreateAccount("bob", "pw", db.RoleOperator)
 	exp := time.Now().Add(7 * 24 * time.Hour)
 
 	// CreateRefreshToken
@@ -352,3 +479,461 @@ func TestLoadSessionsByStatus(t *testing.T) {
 		t.Errorf("expected sessions a and c, got %v", ids)
 	}
 }
+
+func TestReviewComments(t *testing.T) {
+	store := openTestDB(t)
+
+	a := &db.ReviewComment{SessionID: "s1", File: "b.go", LineStart: 9, LineEnd: 9, Body: "second"}
+	b := &db.ReviewComment{SessionID: "s1", File: "a.go", LineStart: 1, LineEnd: 3, Body: "first"}
+	other := &db.ReviewComment{SessionID: "s2", File: "a.go", LineStart: 1, LineEnd: 1, Body: "other"}
+	for _, c := range []*db.ReviewComment{a, b, other} {
+		if err := store.AddReviewComment(c); err != nil {
+			t.Fatalf("AddReviewComment: %v", err)
+		}
+	}
+	if a.ID == 0 || a.CreatedAt.IsZero() {
+		t.Errorf("expected ID and CreatedAt to be set: %+v", a)
+	}
+
+	open, err := store.GetReviewComments("s1", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(open) != 2 || open[0].Body != "first" || open[1].Body != "second" {
+		t.Fatalf("expected comments ordered by file, got %+v", open)
+	}
+
+	if err := store.MarkReviewCommentsSent([]int64{b.ID}); err != nil {
+		t.Fatal(err)
+	}
+	open, _ = store.GetReviewComments("s1", false)
+	if len(open) != 1 || open[0].ID != a.ID {
+		t.Errorf("expected only the unsent comment, got %+v", open)
+	}
+	all, _ := store.GetReviewComments("s1", true)
+	if len(all) != 2 || all[0].SentAt.IsZero() {
+		t.Errorf("expected sent comment with SentAt, got %+v", all)
+	}
+
+	claimed, err := store.ClaimReviewComments("s1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(claimed) != 1 || claimed[0].ID != a.ID || claimed[0].SentAt.IsZero() {
+		t.Fatalf("expected the open comment claimed, got %+v", claimed)
+	}
+	if again, _ := store.ClaimReviewComments("s1"); len(again) != 0 {
+		t.Errorf("expected a second claim to get nothing, got %+v", again)
+	}
+	if err := store.UnmarkReviewCommentsSent([]int64{a.ID}); err != nil {
+		t.Fatal(err)
+	}
+	if open, _ = store.GetReviewComments("s1", false); len(open) != 1 || open[0].ID != a.ID {
+		t.Errorf("expected the comment reopened, got %+v", open)
+	}
+
+	if err := store.DeleteReviewComment("s2", a.ID); err == nil {
+		t.Error("expected deleting another session's comment to fail")
+	}
+	if err := store.DeleteReviewComment("s1", a.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.DeleteSession("s2"); err != nil {
+		t.Fatal(err)
+	}
+	if left, _ := store.GetReviewComments("s2", true); len(left) != 0 {
+		t.Errorf("expected comments removed with session, got %d", len(left))
+	}
+}
+
+func TestAuditLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.db")
+	store, err := db.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	if err := store.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+
+	base := time.Now().Add(-time.Hour)
+	entries := []db.AuditEntry{
+		{Ts: base, Actor: "alice", Source: "web", IP: "10.0.0.1", Action: "session.create", Target: "s1", Result: "ok"},
+		{Ts: base.Add(time.Minute), Actor: "bob", Source: "web", Action: "session.delete", Target: "s1", Result: "denied"},
+		{Ts: base.Add(2 * time.Minute), Actor: "zach", Source: "tui", Action: "session.stop", Target: "s2", Result: "ok"},
+		{Ts: base.Add(3 * time.Minute), Actor: "alice", Source: "web", Action: "auth.login", Result: "ok"},
+	}
+	for i := range entries {
+		if err := store.InsertAudit(&entries[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	all, err := store.QueryAudit(db.AuditFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 4 || all[0].Action != "auth.login" || all[3].IP != "10.0.0.1" {
+		t.Fatalf("all entries, newest first: %+v", all)
+	}
+	for _, c := range []struct {
+		name string
+		f    db.AuditFilter
+		want int
+	}{
+		{"actor", db.AuditFilter{Actor: "alice"}, 2},
+		{"action prefix", db.AuditFilter{Action: "session."}, 3},
+		{"exact action", db.AuditFilter{Action: "session.stop"}, 1},
+		{"target", db.AuditFilter{Target: "s1"}, 2},
+		{"source", db.AuditFilter{Source: "tui"}, 1},
+		{"since", db.AuditFilter{Since: base.Add(90 * time.Second)}, 2},
+		{"until", db.AuditFilter{Until: base.Add(90 * time.Second)}, 2},
+		{"limit", db.AuditFilter{Limit: 1}, 1},
+	} {
+		got, err := store.QueryAudit(c.f)
+		if err != nil || len(got) != c.want {
+			t.Errorf("%s: got %d entries (%v), want %d", c.name, len(got), err, c.want)
+		}
+	}
+
+	// The log cannot be rewritten, even bypassing the DB methods.
+	raw, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer raw.Close()
+	if _, err := raw.Exec(`UPDATE audit_log SET actor = 'mallory'`); err == nil {
+		t.Error("UPDATE on audit_log should fail")
+	}
+	if _, err := raw.Exec(`DELETE FROM audit_log`); err == nil {
+		t.Error("DELETE on audit_log should fail")
+	}
+}
+
+func TestAccountManagement(t *testing.T) {
+	store := openTestDB(t)
+	bob, _ := store.CreateAccount("bob", "h", db.RoleOperator)
+	alice, _ := store.CreateAccount("alice", "h", db.RoleAdmin)
+	exp := time.Now().Add(time.Hour)
+
+	accounts, err := store.ListAccounts()
+	if err != nil || len(accounts) != 2 || accounts[0].Username != "alice" {
+		t.Fatalf("ListAccounts: %v, %v", accounts, err)
+	}
+	if !accounts[1].LastLogin.IsZero() || accounts[1].Disabled {
+		t.Errorf("new account: %+v", accounts[1])
+	}
+	if err := store.RecordLogin(bob.ID); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := store.GetAccountByID(bob.ID); time.Since(got.LastLogin) > time.Minute {
+		t.Errorf("last login: %v", got.LastLogin)
+	}
+
+	store.CreateRefreshToken("bob-1", bob.ID, exp)
+	if err := store.SetAccountDisabled(bob.ID, true); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := store.GetAccountByUsername("bob"); !got.Disabled {
+		t.Error("bob should be disabled")
+	}
+	if _, err := store.GetRefreshToken("bob-1"); err == nil {
+		t.Error("disabling should revoke refresh tokens")
+	}
+	store.SetAccountDisabled(bob.ID, false)
+	if got, _ := store.GetAccountByID(bob.ID); got.Disabled {
+		t.Error("bob should be enabled again")
+	}
+
+	store.CreateRefreshToken("bob-2", bob.ID, exp)
+	store.SetGroupPermission(bob.ID, "work", db.RoleAdmin)
+	if err := store.DeleteAccount(bob.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.GetRefreshToken("bob-2"); err == nil {
+		t.Error("deleting an account should delete its refresh tokens")
+	}
+	if perms, _ := store.GetGroupPermissions(bob.ID); len(perms) != 0 {
+		t.Errorf("group permissions left: %v", perms)
+	}
+	if err := store.DeleteAccount(bob.ID); err != sql.ErrNoRows {
+		t.Errorf("deleting a missing account: got %v, want sql.ErrNoRows", err)
+	}
+	if accounts, _ := store.ListAccounts(); len(accounts) != 1 || accounts[0].ID != alice.ID {
+		t.Errorf("accounts after delete: %+v", accounts)
+	}
+}
+
+func TestRefreshTokenLogins(t *testing.T) {
+	store := openTestDB(t)
+	acc, _ := store.CreateAccount("alice", "h", db.RoleAdmin)
+	exp := time.Now().Add(time.Hour)
+
+	first := &db.RefreshToken{Token: "t1", AccountID: acc.ID, ExpiresAt: exp, UserAgent: "phone", IP: "10.0.0.1"}
+	if err := store.SaveRefreshToken(first); err != nil {
+		t.Fatal(err)
+	}
+	if first.LoginID == "" || first.LoginAt.IsZero() {
+		t.Fatalf("login not assigned: %+v", first)
+	}
+	// Rotation keeps the login.
+	store.DeleteRefreshToken("t1")
+	store.SaveRefreshToken(&db.RefreshToken{Token: "t2", AccountID: acc.ID, ExpiresAt: exp,
+		LoginID: first.LoginID, LoginAt: first.LoginAt, UserAgent: first.UserAgent, IP: "10.0.0.2"})
+	store.CreateRefreshToken("laptop", acc.ID, exp)
+	store.CreateRefreshToken("stale", acc.ID, time.Now().Add(-time.Minute))
+
+	logins, err := store.ListRefreshTokens(acc.ID)
+	if err != nil || len(logins) != 2 {
+		t.Fatalf("ListRefreshTokens: %d logins, %v", len(logins), err)
+	}
+	var phone *db.RefreshToken
+	for _, l := range logins {
+		if l.LoginID == first.LoginID {
+			phone = l
+		}
+	}
+	if phone == nil || phone.Token != "t2" || phone.UserAgent != "phone" || phone.IP != "10.0.0.2" ||
+		phone.LoginAt.UnixMilli() != first.LoginAt.UnixMilli() {
+		t.Errorf("rotated login: %+v", phone)
+	}
+
+	if err := store.DeleteLogin(acc.ID, first.LoginID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.GetRefreshToken("t2"); err == nil {
+		t.Error("revoked login's token still exists")
+	}
+	if err := store.DeleteLogin(acc.ID, "nope"); err != sql.ErrNoRows {
+		t.Errorf("unknown login: got %v, want sql.ErrNoRows", err)
+	}
+	if n, err := store.DeleteExpiredRefreshTokens(); err != nil || n != 1 {
+		t.Errorf("DeleteExpiredRefreshTokens: %d, %v", n, err)
+	}
+	if _, err := store.GetRefreshToken("laptop"); err != nil {
+		t.Errorf("unexpired token removed: %v", err)
+	}
+}
+
+func TestAccountTOTP(t *testing.T) {
+	store := openTestDB(t)
+	acc, _ := store.CreateAccount("alice", "h", db.RoleAdmin)
+
+	if err := store.SetTOTPSecret(acc.ID, "SECRET"); err != nil {
+		t.Fatal(err)
+	}
+	got, _ := store.GetAccountByID(acc.ID)
+	if got.TOTPSecret != "SECRET" || got.TOTPEnabled {
+		t.Fatalf("pending enrollment: %+v", got)
+	}
+	if err := store.EnableTOTP(acc.ID, []string{"h1", "h2"}); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := store.GetAccountByUsername("alice"); !got.TOTPEnabled {
+		t.Error("TOTP should be enabled")
+	}
+
+	if ok, _ := store.UseTOTPStep(acc.ID, 100); !ok {
+		t.Error("first use of step 100 refused")
+	}
+	if ok, _ := store.UseTOTPStep(acc.ID, 100); ok {
+		t.Error("step 100 accepted twice")
+	}
+	if ok, _ := store.UseTOTPStep(acc.ID, 99); ok {
+		t.Error("earlier step accepted")
+	}
+
+	if ok, _ := store.UseRecoveryCode(acc.ID, "h1"); !ok {
+		t.Error("recovery code h1 refused")
+	}
+	if ok, _ := store.UseRecoveryCode(acc.ID, "h1"); ok {
+		t.Error("recovery code h1 accepted twice")
+	}
+	if n, _ := store.CountRecoveryCodes(acc.ID); n != 1 {
+		t.Errorf("unused recovery codes: %d", n)
+	}
+
+	if err := store.DisableTOTP(acc.ID); err != nil {
+		t.Fatal(err)
+	}
+	got, _ = store.GetAccountByID(acc.ID)
+	if got.TOTPEnabled || got.TOTPSecret != "" || got.TOTPLastStep != 0 {
+		t.Errorf("after disable: %+v", got)
+	}
+	if n, _ := store.CountRecoveryCodes(acc.ID); n != 0 {
+		t.Errorf("recovery codes after disable: %d", n)
+	}
+}
+
+func TestAPITokens(t *testing.T) {
+	store := openTestDB(t)
+	alice, _ := store.CreateAccount("alice", "h", db.RoleAdmin)
+	bob, _ := store.CreateAccount("bob", "h", db.RoleViewer)
+
+	tok := &db.APIToken{AccountID: alice.ID, Name: "ci", Hash: "hash1", Scopes: []db.Scope{db.ScopeRead, db.ScopeTerminal}}
+	if err := store.CreateAPIToken(tok); err != nil {
+		t.Fatal(err)
+	}
+	store.CreateAPIToken(&db.APIToken{AccountID: bob.ID, Name: "raycast", Hash: "hash2",
+		Scopes: []db.Scope{db.ScopeRead}, ExpiresAt: time.Now().Add(time.Hour)})
+
+	got, err := store.GetAPITokenByHash("hash1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ID != tok.ID || got.Username != "alice" || got.Name != "ci" || !got.ExpiresAt.IsZero() || !got.LastUsed.IsZero() {
+		t.Errorf("token: %+v", got)
+	}
+	if !got.HasScope(db.ScopeTerminal) || got.HasScope(db.ScopeSessionsWrite) {
+		t.Errorf("scopes: %v", got.Scopes)
+	}
+	store.TouchAPIToken(tok.ID)
+	if got, _ := store.GetAPITokenByHash("hash1"); got.LastUsed.IsZero() {
+		t.Error("last use not recorded")
+	}
+
+	if all, _ := store.ListAPITokens(""); len(all) != 2 {
+		t.Errorf("all tokens: %d", len(all))
+	}
+	if mine, _ := store.ListAPITokens(bob.ID); len(mine) != 1 || mine[0].ExpiresAt.IsZero() {
+		t.Errorf("bob's tokens: %+v", mine)
+	}
+
+	if err := store.DeleteAPIToken(tok.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.DeleteAPIToken(tok.ID); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("deleting twice: %v", err)
+	}
+	// Tokens go with their account.
+	store.DeleteAccount(bob.ID)
+	if all, _ := store.ListAPITokens(""); len(all) != 0 {
+		t.Errorf("tokens left: %d", len(all))
+	}
+}
+
+func TestShareLinks(t *testing.T) {
+	store := openTestDB(t)
+	link := &db.ShareLink{SessionID: "s1", CreatedBy: "alice", ExpiresAt: time.Now().Add(time.Hour)}
+	if err := store.CreateShareLink(link); err != nil {
+		t.Fatal(err)
+	}
+	store.CreateShareLink(&db.ShareLink{SessionID: "s1", CreatedBy: "bob", ExpiresAt: time.Now().Add(-time.Minute)})
+
+	got, err := store.GetShareLink(link.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.SessionID != "s1" || got.CreatedBy != "alice" || !got.Active() {
+		t.Errorf("link: %+v", got)
+	}
+	// Expired links are not listed.
+	if links, _ := store.ListShareLinks("s1"); len(links) != 1 || links[0].ID != link.ID {
+		t.Errorf("active links: %+v", links)
+	}
+
+	if err := store.RevokeShareLink(link.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.RevokeShareLink(link.ID); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("revoking twice: %v", err)
+	}
+	if got, _ := store.GetShareLink(link.ID); got.Active() || got.RevokedAt.IsZero() {
+		t.Errorf("revoked link: %+v", got)
+	}
+	if links, _ := store.ListShareLinks("s1"); len(links) != 0 {
+		t.Errorf("links after revoking: %d", len(links))
+	}
+}
+
+func TestSessionEventsKept(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.db")
+	// A table from before the foreign key was dropped.
+	raw, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw.Exec(`CREATE TABLE session_events (
+		id INTEGER PRIMARY KEY,
+		session_id TEXT NOT NULL REFERENCES sessions(id) ON DELETE CASCADE,
+		ts DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		event_type TEXT NOT NULL,
+		detail TEXT NOT NULL DEFAULT '')`)
+	raw.Exec(`INSERT INTO session_events (session_id, ts, event_type) VALUES ('old', '2026-01-02 03:04:05', 'created')`)
+	raw.Close()
+
+	store, err := db.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	if err := store.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+	evts, _ := store.GetSessionEvents("old", 10)
+	if len(evts) != 1 {
+		t.Fatalf("expected the old event kept, got %+v", evts)
+	}
+	if want := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC); !evts[0].Ts.Equal(want) {
+		t.Errorf("expected the old event's time filled in from ts, got %v", evts[0].Ts)
+	}
+
+	created := time.Now().Add(-time.Hour).Truncate(time.Millisecond)
+	s := &db.Session{ID: "s1", Title: "t", GroupPath: "g", Tool: db.ToolClaude, Status: db.StatusIdle, CreatedAt: created}
+	store.SaveSession(s)
+	store.InsertSessionEvent("s1", "created", "")
+	store.SaveSession(s)
+	store.InsertSessionEvent("s1", "deleted", "")
+	store.DeleteSession("s1")
+
+	evts, err = store.SessionEventsOfType(time.Time{}, time.Now(), "created", "deleted")
+	if err != nil || len(evts) != 3 || evts[2].EventType != "deleted" {
+		t.Fatalf("expected events to survive saves and deletion, got %+v (%v)", evts, err)
+	}
+	recs, _ := store.SessionRecords()
+	if len(recs) != 1 || recs[0].Title != "t" || recs[0].GroupPath != "g" || !recs[0].CreatedAt.Equal(created) || recs[0].DeletedAt.IsZero() {
+		t.Errorf("expected the deleted session recorded, got %+v", recs)
+	}
+}
+
+func TestSessionEventsOfTypeWindow(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.db")
+	store, err := db.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	store.Migrate()
+	raw, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer raw.Close()
+	for _, e := range []struct {
+		session, typ string
+		tsMs         int64
+	}{
+		{"a", "status_changed", 1000},
+		{"a", "status_changed", 2000}, // a's state at since
+		{"a", "checkpoint", 2500},
+		{"a", "status_changed", 3000},
+		{"a", "status_changed", 9000}, // after until
+		{"b", "stopped", 1500},        // b's state at since
+		{"c", "status_changed", 0},    // no time
+	} {
+		raw.Exec(`INSERT INTO session_events (session_id, ts_ms, event_type) VALUES (?, ?, ?)`, e.session, e.tsMs, e.typ)
+	}
+
+	evts, err := store.SessionEventsOfType(time.UnixMilli(2800), time.UnixMilli(5000), "status_changed", "stopped")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []int64
+	for _, e := range evts {
+		got = append(got, e.Ts.UnixMilli())
+	}
+	if len(got) != 3 || got[0] != 1500 || got[1] != 2000 || got[2] != 3000 {
+		t.Errorf("expected the events at 1500, 2000 and 3000, got %v", got)
+	}
+}
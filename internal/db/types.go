@@ -65,6 +65,19 @@ type Session struct {
 	RepoURL         string
 	HasUncommitted  bool
 	Notes           string
+	// Owner is the web user who created the session; empty for sessions
+	// created from the TUI or CLI.
+	Owner string
+}
+
+// SessionWorktree is one of the worktrees owned by a multi-repo session. The
+// session's own WorktreePath/WorktreeRepo/WorktreeBranch hold the first of them.
+type SessionWorktree struct {
+	SessionID    string
+	RepoURL      string
+	RepoPath     string
+	WorktreePath string
+	Branch       string
 }
 
 type Group struct {
@@ -76,20 +89,137 @@ type Group struct {
 	RepoURL          string
 	DefaultTool      Tool
 	PreLaunchCommand string
+	BaseBranch       string
+	// ExtraRepoURLs are additional repos whose worktrees are created alongside
+	// RepoURL's, making new sessions in the group multi-repo sessions.
+	ExtraRepoURLs []string
+	// Checkpoints enables automatic worktree snapshots of the group's
+	// sessions whenever an agent stops running.
+	Checkpoints bool
+}
+
+// Role is an account's level of access to the web server. Each role can do
+// everything the roles below it can.
+type Role string
+
+const (
+	// RoleViewer can see sessions, diffs and read-only terminals.
+	RoleViewer Role = "viewer"
+	// RoleOperator can also create, control and delete sessions and type
+	// into their terminals.
+	RoleOperator Role = "operator"
+	// RoleAdmin can also manage accounts.
+	RoleAdmin Role = "admin"
+)
+
+var roleRank = map[Role]int{RoleViewer: 1, RoleOperator: 2, RoleAdmin: 3}
+
+// Valid reports whether r is a known role.
+func (r Role) Valid() bool {
+	return roleRank[r] > 0
+}
+
+// Allows reports whether r grants at least the access of min.
+func (r Role) Allows(min Role) bool {
+	return r.Valid() && roleRank[r] >= roleRank[min]
 }
 
 type Account struct {
 	ID           string
 	Username     string
 	PasswordHash string
+	Role         Role
 	CreatedAt    time.Time
+	Disabled     bool
+	LastLogin    time.Time
+	// TOTPSecret is set while enrolling and once enrolled; TOTPEnabled only
+	// once a code from it was verified. TOTPLastStep is the time step of
+	// the last accepted code, which may not be used again.
+	TOTPSecret   string
+	TOTPEnabled  bool
+	TOTPLastStep int64
 }
 
+// Scope is what an API token may be used for, within its account's role.
+type Scope string
+
+const (
+	// ScopeRead allows reading sessions, diffs, history and events.
+	ScopeRead Scope = "read"
+	// ScopeSessionsWrite allows creating, controlling and changing sessions.
+	ScopeSessionsWrite Scope = "sessions:write"
+	// ScopeTerminal allows opening session terminals.
+	ScopeTerminal Scope = "terminal"
+)
+
+// Scopes lists every API token scope.
+var Scopes = []Scope{ScopeRead, ScopeSessionsWrite, ScopeTerminal}
+
+// Valid reports whether s is a known scope.
+func (s Scope) Valid() bool {
+	for _, v := range Scopes {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
+
+// APIToken is a long-lived personal token for scripts and other clients
+// that cannot use the login flow. Only a hash of the token is stored.
+// ExpiresAt is zero for tokens that do not expire.
+type APIToken struct {
+	ID        string
+	AccountID string
+	Username  string // filled in when listing
+	Name      string
+	Hash      string
+	Scopes    []Scope
+	CreatedAt time.Time
+	LastUsed  time.Time
+	ExpiresAt time.Time
+}
+
+// HasScope reports whether the token was granted scope.
+func (t *APIToken) HasScope(scope Scope) bool {
+	for _, s := range t.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// ShareLink lets anyone with its URL watch a session read-only until it
+// expires or is revoked. The URL carries a signed token naming the link, so
+// only the link itself is stored.
+type ShareLink struct {
+	ID        string
+	SessionID string
+	CreatedBy string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+	RevokedAt time.Time // zero while the link is not revoked
+}
+
+// Active reports whether the link can still be used.
+func (l *ShareLink) Active() bool {
+	return l.RevokedAt.IsZero() && time.Now().Before(l.ExpiresAt)
+}
+
+// RefreshToken is the current token of one login. Tokens rotate on every
+// refresh; LoginID, LoginAt and UserAgent carry over so a login can be listed
+// and revoked as one session. LastUsed and IP are from the latest refresh.
 type RefreshToken struct {
 	Token     string
 	AccountID string
 	ExpiresAt time.Time
 	CreatedAt time.Time
+	LoginID   string
+	LoginAt   time.Time
+	LastUsed  time.Time
+	UserAgent string
+	IP        string
 }
 
 type StatusUpdate struct {
@@ -107,6 +237,60 @@ type SessionEvent struct {
 	Detail    string
 }
 
+// SessionRecord is what analytics needs of a session, live or deleted.
+type SessionRecord struct {
+	ID        string
+	Title     string
+	GroupPath string
+	Tool      Tool
+	Status    SessionStatus // "" once deleted
+	CreatedAt time.Time
+	DeletedAt time.Time // zero while live
+}
+
+// AuditEntry records one mutating action. Actor is the web account or, for
+// the TUI and CLI, the local OS user; Source is "web", "tui", "cli" or
+// "system" for changes no user made; Target is usually a session ID. Result is "ok", "denied" or an error message.
+type AuditEntry struct {
+	ID     int64
+	Ts     time.Time
+	Actor  string
+	Source string
+	IP     string
+	Action string
+	Target string
+	Detail string
+	Result string
+}
+
+// AuditFilter selects audit entries; zero fields match everything. Limit
+// defaults to 100.
+type AuditFilter struct {
+	Actor  string
+	Action string // exact action, or a prefix ending in "." such as "session."
+	Target string
+	Source string
+	Since  time.Time
+	Until  time.Time
+	Limit  int
+}
+
+// ReviewComment is a reviewer's comment on a line range of a file, either in
+// the uncommitted changes of a session (Commit empty) or in one of its
+// commits. SentAt is zero while the comment is open.
+type ReviewComment struct {
+	ID        int64
+	SessionID string
+	Repo      string
+	File      string
+	LineStart int
+	LineEnd   int
+	Commit    string
+	Body      string
+	CreatedAt time.Time
+	SentAt    time.Time
+}
+
 type UsageSnapshot struct {
 	ID                int64
 	TsMs              int64
@@ -119,3 +303,49 @@ type UsageSnapshot struct {
 	ExtraUsedCredits  float64 // cents
 	ExtraUtilization  float64
 }
+
+// UsageForecast projects a rate-limit window from the trend of recent
+// snapshots.
+type UsageForecast struct {
+	Util     float64 // percent used in the latest snapshot
+	Rate     float64 // percent per hour over the recent trend
+	ResetsAt int64   // Unix ms
+	LimitAt  int64   // Unix ms the trend reaches 100%; 0 if not before the reset
+}
+
+// BudgetStatus is where Claude usage stands against the configured budget.
+type BudgetStatus struct {
+	Level    string // "ok", "warn", "paused" or "throttled"
+	FiveHour UsageForecast
+	SevenDay UsageForecast
+}
+
+// SessionUsage is the tokens a session's Claude transcripts record for one
+// model in one hour.
+type SessionUsage struct {
+	SessionID        string
+	Title            string // the session's title and group when recorded,
+	GroupPath        string // kept so usage outlives the session
+	HourMs           int64  // start of the hour, Unix ms
+	Model            string
+	Messages         int64
+	InputTokens      int64
+	OutputTokens     int64
+	CacheWriteTokens int64
+	CacheReadTokens  int64
+	CostUSD          float64 // estimated from list prices
+}
+
+// UsageTotal sums the usage of a session, or of a group when SessionID is
+// empty, over a period.
+type UsageTotal struct {
+	SessionID        string
+	Title            string
+	GroupPath        string
+	Messages         int64
+	InputTokens      int64
+	OutputTokens     int64
+	CacheWriteTokens int64
+	CacheReadTokens  int64
+	CostUSD          float64
+}
@@ -0,0 +1,251 @@
+package events
+
+import (
+	"sync"
+	"time"
+
+	"github.com/zsprackett/agent-workspace/internal/db"
+)
+
+// bestEffortBuffer is how many events a best-effort subscriber may fall
+// behind before the oldest are dropped.
+const bestEffortBuffer = 256
+
+// closeTimeout is how long Close waits for durable subscribers to drain.
+const closeTimeout = 5 * time.Second
+
+// Bus is an in-process publish/subscribe hub. Every state change is
+// published to it, and each subscriber -- the TUI, the web event stream,
+// notifications, the audit log, webhooks -- receives events in order on its
+// own goroutine, so a slow subscriber never holds up publishers or the
+// others.
+//
+// A durable subscriber's queue grows as needed and never loses an event; a
+// best-effort one keeps the latest bestEffortBuffer and counts what it drops.
+type Bus struct {
+	mu   sync.Mutex
+	subs []*Subscription
+}
+
+// NewBus returns a bus with no subscribers.
+func NewBus() *Bus {
+	return &Bus{}
+}
+
+// Broadcast implements Broadcaster. It timestamps e and queues it for every
+// subscriber without waiting for them.
+func (b *Bus) Broadcast(e Event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	b.mu.Lock()
+	subs := b.subs
+	b.mu.Unlock()
+	for _, s := range subs {
+		s.push(e)
+	}
+}
+
+// Subscribe calls handler with every event published from now on, one at a
+// time and in order. Durable subscribers receive every event; others lose
+// the oldest ones when they fall too far behind. The name identifies the
+// subscriber in Stats.
+func (b *Bus) Subscribe(name string, durable bool, handler func(Event)) *Subscription {
+	s := &Subscription{
+		bus:     b,
+		name:    name,
+		durable: durable,
+		handler: handler,
+		wake:    make(chan struct{}, 1),
+		done:    make(chan struct{}),
+	}
+	b.mu.Lock()
+	b.subs = append(b.subs[:len(b.subs):len(b.subs)], s)
+	b.mu.Unlock()
+	go s.run()
+	return s
+}
+
+// Close closes every subscription, waiting up to five seconds for durable
+// subscribers to handle the events already published.
+func (b *Bus) Close() {
+	b.CloseWithin(closeTimeout)
+}
+
+// CloseWithin closes every subscription, waiting up to d for durable
+// subscribers to handle the events already published. Events still queued
+// after d are dropped, and a handler still running is left to finish on its
+// own.
+func (b *Bus) CloseWithin(d time.Duration) {
+	b.mu.Lock()
+	subs := b.subs
+	b.mu.Unlock()
+	for _, s := range subs {
+		s.close()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	expired := false
+	for _, s := range subs {
+		if !expired {
+			select {
+			case <-s.done:
+				continue
+			case <-timer.C:
+				expired = true
+			}
+		}
+		select {
+		case <-s.done:
+		default:
+			s.abandon()
+		}
+	}
+}
+
+// SubscriberStats describes how well a subscriber is keeping up.
+type SubscriberStats struct {
+	Name       string `json:"name"`
+	Durable    bool   `json:"durable"`
+	Delivered  uint64 `json:"delivered"`   // events handled
+	Dropped    uint64 `json:"dropped"`     // events lost; always 0 if durable
+	Pending    int    `json:"pending"`     // events queued now
+	MaxPending int    `json:"max_pending"` // the most ever queued at once
+}
+
+// Stats returns the backpressure counters of every subscriber.
+func (b *Bus) Stats() []SubscriberStats {
+	b.mu.Lock()
+	subs := b.subs
+	b.mu.Unlock()
+	out := make([]SubscriberStats, len(subs))
+	for i, s := range subs {
+		out[i] = s.stats()
+	}
+	return out
+}
+
+// Subscription is a subscriber's queue on a Bus.
+type Subscription struct {
+	bus     *Bus
+	name    string
+	durable bool
+	handler func(Event)
+	wake    chan struct{}
+	done    chan struct{}
+
+	mu         sync.Mutex
+	queue      []Event
+	closed     bool
+	delivered  uint64
+	dropped    uint64
+	maxPending int
+}
+
+func (s *Subscription) push(e Event) {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return
+	}
+	if !s.durable && len(s.queue) >= bestEffortBuffer {
+		s.queue = s.queue[1:]
+		s.dropped++
+	}
+	s.queue = append(s.queue, e)
+	s.maxPending = max(s.maxPending, len(s.queue))
+	s.mu.Unlock()
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (s *Subscription) run() {
+	defer close(s.done)
+	for {
+		s.mu.Lock()
+		if len(s.queue) == 0 {
+			closed := s.closed
+			s.mu.Unlock()
+			if closed {
+				return
+			}
+			<-s.wake
+			continue
+		}
+		e := s.queue[0]
+		s.queue = s.queue[1:]
+		s.mu.Unlock()
+
+		s.handler(e)
+
+		s.mu.Lock()
+		s.delivered++
+		s.mu.Unlock()
+	}
+}
+
+// Close unsubscribes. A durable subscriber first handles the events already
+// queued; a best-effort one drops them. Close waits for the handler to
+// return.
+func (s *Subscription) Close() {
+	s.close()
+	<-s.done
+}
+
+func (s *Subscription) close() {
+	b := s.bus
+	b.mu.Lock()
+	for i, sub := range b.subs {
+		if sub == s {
+			b.subs = append(b.subs[:i:i], b.subs[i+1:]...)
+			break
+		}
+	}
+	b.mu.Unlock()
+
+	s.mu.Lock()
+	if !s.closed {
+		s.closed = true
+		if !s.durable {
+			s.dropped += uint64(len(s.queue))
+			s.queue = nil
+		}
+	}
+	s.mu.Unlock()
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// abandon drops the events a closed subscriber has not handled yet.
+func (s *Subscription) abandon() {
+	s.mu.Lock()
+	s.dropped += uint64(len(s.queue))
+	s.queue = nil
+	s.mu.Unlock()
+}
+
+func (s *Subscription) stats() SubscriberStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return SubscriberStats{
+		Name:       s.name,
+		Durable:    s.durable,
+		Delivered:  s.delivered,
+		Dropped:    s.dropped,
+		Pending:    len(s.queue),
+		MaxPending: s.maxPending,
+	}
+}
+
+// SessionState returns an event with the session's current state, or its
+// deletion if it no longer exists.
+func SessionState(store *db.DB, id string) Event {
+	if sess, err := store.GetSession(id); err == nil && sess != nil {
+		return Event{Type: SessionUpdated, SessionID: id, Session: sess}
+	}
+	return Event{Type: SessionDeleted, SessionID: id}
+}
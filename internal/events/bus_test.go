@@ -0,0 +1,90 @@
+package events_test
+
+import (
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/zsprackett/agent-workspace/internal/events"
+)
+
+func TestBusDelivery(t *testing.T) {
+	bus := events.NewBus()
+
+	// A durable subscriber stuck on its first event still gets every event
+	// in order; a best-effort one keeps the newest and counts the rest.
+	release := make(chan struct{})
+	var durable, bestEffort []string
+	bus.Subscribe("durable", true, func(e events.Event) {
+		<-release
+		durable = append(durable, e.SessionID)
+	})
+	best := bus.Subscribe("best-effort", false, func(e events.Event) {
+		<-release
+		bestEffort = append(bestEffort, e.SessionID)
+	})
+
+	const n = 1000
+	for i := range n {
+		bus.Broadcast(events.Event{Type: events.Refresh, SessionID: fmt.Sprint(i)})
+	}
+	stats := bus.Stats()
+	if len(stats) != 2 || stats[0].Name != "durable" || stats[0].Pending < n-1 || stats[0].MaxPending < n-1 {
+		t.Errorf("durable stats while blocked: %+v", stats)
+	}
+	if stats[1].Dropped == 0 || stats[1].Pending > 256 {
+		t.Errorf("best-effort stats while blocked: %+v", stats[1])
+	}
+
+	close(release)
+	best.Close()
+	if st := bus.Stats(); len(st) != 1 || st[0].Name != "durable" {
+		t.Errorf("stats after closing a subscriber: %+v", st)
+	}
+	bus.Close()
+
+	if len(durable) != n {
+		t.Fatalf("durable got %d events, want %d", len(durable), n)
+	}
+	for i, id := range durable {
+		if id != fmt.Sprint(i) {
+			t.Fatalf("durable event %d is %s", i, id)
+		}
+	}
+	// Closing a best-effort subscriber drops what it has not handled.
+	if len(bestEffort) > 257 {
+		t.Errorf("best-effort got %d events", len(bestEffort))
+	}
+
+	// Closed subscribers are gone and get nothing more.
+	bus.Broadcast(events.Event{Type: events.Refresh})
+	if len(bus.Stats()) != 0 || len(durable) != n {
+		t.Error("events delivered after Close")
+	}
+}
+
+func TestBusCloseTimeout(t *testing.T) {
+	bus := events.NewBus()
+	release := make(chan struct{})
+	var handled atomic.Int32
+	bus.Subscribe("stuck", true, func(events.Event) {
+		handled.Add(1)
+		<-release
+	})
+	for range 3 {
+		bus.Broadcast(events.Event{Type: events.Refresh})
+	}
+
+	start := time.Now()
+	bus.CloseWithin(50 * time.Millisecond)
+	if d := time.Since(start); d > time.Second {
+		t.Fatalf("Close waited %s for a stuck subscriber", d)
+	}
+	// The events still queued were dropped, not handled late.
+	close(release)
+	time.Sleep(50 * time.Millisecond)
+	if n := handled.Load(); n != 1 {
+		t.Errorf("handled %d events after a timed-out close, want 1", n)
+	}
+}
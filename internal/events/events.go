@@ -1,17 +1,67 @@
 package events
 
-import "github.com/zsprackett/agent-workspace/internal/db"
+import (
+	"time"
 
-// Event is a real-time update pushed to web clients.
+	"github.com/zsprackett/agent-workspace/internal/db"
+)
+
+// Event types. The comment after each names the fields it sets besides
+// SessionID.
+const (
+	// Snapshot tells a client to fetch the full state: it connected without
+	// a position to resume from, or missed more events than are kept.
+	Snapshot = "snapshot"
+	// Heartbeat is sent on an idle stream so clients can tell it is alive.
+	Heartbeat = "heartbeat"
+	// Refresh reports a change without a finer event, such as to groups.
+	Refresh              = "refresh"
+	SessionCreated       = "session_created"       // Session
+	SessionUpdated       = "session_updated"       // Session
+	SessionDeleted       = "session_deleted"       //
+	StatusChanged        = "status_changed"        // Status, From, Title, Session
+	GitDirtyChanged      = "git_dirty_changed"     // Dirty
+	NotesUpdated         = "notes_updated"         // Notes
+	UsageUpdated         = "usage_updated"         // Usage; no SessionID
+	BudgetUpdated        = "budget_updated"        // Budget; no SessionID
+	ProvisioningProgress = "provisioning_progress" // Step, Message, Title
+	ToolUse              = "tool_use"              // Tool, Message: a hook reported Claude calling a tool
+)
+
+// Provisioning steps of a new worktree session, in order. A session that
+// fails to provision reports StepFailed with the error as the message and is
+// then deleted.
+const (
+	StepWorktree  = "worktree"   // cloning or fetching and creating worktrees
+	StepPreLaunch = "pre_launch" // running the group's pre-launch command
+	StepStarting  = "starting"   // starting the tmux session
+	StepReady     = "ready"
+	StepFailed    = "failed"
+)
+
+// Event is a state change, published on a Bus and pushed to web clients.
+// Time is set when it is published and ID when it is appended to a Log.
 type Event struct {
-	Type      string          `json:"type"`
-	SessionID string          `json:"session_id,omitempty"`
-	Status    db.SessionStatus `json:"status,omitempty"`
-	Title     string          `json:"title,omitempty"`
+	ID        uint64            `json:"id,omitempty"`
+	Time      time.Time         `json:"time"`
+	Type      string            `json:"type"`
+	SessionID string            `json:"session_id,omitempty"`
+	Status    db.SessionStatus  `json:"status,omitempty"`
+	From      db.SessionStatus  `json:"from,omitempty"`
+	Title     string            `json:"title,omitempty"`
+	Session   *db.Session       `json:"session,omitempty"`
+	Dirty     *bool             `json:"dirty,omitempty"`
+	Notes     *string           `json:"notes,omitempty"`
+	Usage     *db.UsageSnapshot `json:"usage,omitempty"`
+	Budget    *db.BudgetStatus  `json:"budget,omitempty"`
+	Tool      string            `json:"tool,omitempty"`
+	Step      string            `json:"step,omitempty"`
+	Message   string            `json:"message,omitempty"`
 }
 
-// Broadcaster sends events to connected web clients.
-// A nil Broadcaster is safe to use -- Broadcast becomes a no-op.
+// Broadcaster publishes events. *Bus implements it; producers hold a
+// Broadcaster so tests can capture what they publish. Producers treat a nil
+// Broadcaster as publishing nothing.
 type Broadcaster interface {
 	Broadcast(e Event)
 }
@@ -0,0 +1,80 @@
+package events
+
+import (
+	"sync"
+	"time"
+)
+
+// Log keeps the most recent events in a ring buffer and numbers them, so a
+// client that reconnects can ask for what it missed. Numbering starts at the
+// current time in microseconds, so IDs keep increasing across restarts and
+// a client never mistakes an old position for a new one.
+type Log struct {
+	mu      sync.Mutex
+	buf     []Event
+	start   int // index of the oldest event in buf
+	n       int // number of events in buf
+	last    uint64
+	appends chan struct{} // closed on the next append
+}
+
+// NewLog returns a log that keeps the last size events.
+func NewLog(size int) *Log {
+	return &Log{
+		buf:     make([]Event, size),
+		last:    uint64(time.Now().UnixMicro()),
+		appends: make(chan struct{}),
+	}
+}
+
+// Append numbers and timestamps e, stores it and wakes anyone waiting.
+func (l *Log) Append(e Event) Event {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.last++
+	e.ID = l.last
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	if l.n < len(l.buf) {
+		l.buf[(l.start+l.n)%len(l.buf)] = e
+		l.n++
+	} else {
+		l.buf[l.start] = e
+		l.start = (l.start + 1) % len(l.buf)
+	}
+	close(l.appends)
+	l.appends = make(chan struct{})
+	return e
+}
+
+// Last returns the ID of the newest event, the position of a client that
+// has seen everything.
+func (l *Log) Last() uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.last
+}
+
+// Since returns the events after id. ok is false if some of them are no
+// longer kept, or id is not a position in this log; the client must then
+// fetch the full state.
+func (l *Log) Since(id uint64) (evs []Event, ok bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	oldest := l.last - uint64(l.n) + 1
+	if id > l.last || id+1 < oldest {
+		return nil, false
+	}
+	for i := int(id + 1 - oldest); i < l.n; i++ {
+		evs = append(evs, l.buf[(l.start+i)%len(l.buf)])
+	}
+	return evs, true
+}
+
+// Wait returns a channel that is closed when the next event is appended.
+func (l *Log) Wait() <-chan struct{} {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.appends
+}
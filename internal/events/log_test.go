@@ -0,0 +1,44 @@
+package events_test
+
+import (
+	"testing"
+
+	"github.com/zsprackett/agent-workspace/internal/events"
+)
+
+func TestLog(t *testing.T) {
+	l := events.NewLog(3)
+	start := l.Last()
+	if evs, ok := l.Since(start); !ok || len(evs) != 0 {
+		t.Fatalf("empty log: %v %v", evs, ok)
+	}
+
+	wait := l.Wait()
+	first := l.Append(events.Event{Type: events.Refresh})
+	select {
+	case <-wait:
+	default:
+		t.Fatal("Wait channel not closed by Append")
+	}
+	if first.ID != start+1 || first.Time.IsZero() {
+		t.Fatalf("first event: %+v", first)
+	}
+	for i := 0; i < 3; i++ {
+		l.Append(events.Event{Type: events.Refresh})
+	}
+
+	// Only the last three events are kept.
+	evs, ok := l.Since(first.ID)
+	if !ok || len(evs) != 3 || evs[0].ID != first.ID+1 || evs[2].ID != l.Last() {
+		t.Errorf("since first: %v %v", evs, ok)
+	}
+	if _, ok := l.Since(start); ok {
+		t.Error("since a dropped event: expected not ok")
+	}
+	if _, ok := l.Since(l.Last() + 1); ok {
+		t.Error("since a future ID: expected not ok")
+	}
+	if _, ok := l.Since(0); ok {
+		t.Error("since zero: expected not ok")
+	}
+}
@@ -0,0 +1,363 @@
+package gc
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/zsprackett/agent-workspace/internal/db"
+	"github.com/zsprackett/agent-workspace/internal/git"
+)
+
+// Kind identifies what a Candidate refers to.
+type Kind string
+
+const (
+	KindOrphanWorktree Kind = "worktree"
+	KindMergedBranch   Kind = "branch"
+	KindUnusedRepo     Kind = "repo"
+)
+
+// Candidate is a single item that Prune can remove.
+type Candidate struct {
+	Kind   Kind
+	Path   string // worktree or bare repo directory
+	Repo   string // owning bare repo (worktrees and branches)
+	Branch string // branches only
+	Bytes  int64
+	Reason string
+}
+
+// RepoUsage is the disk usage of one bare repo.
+type RepoUsage struct {
+	Path       string
+	Bytes      int64
+	Referenced bool
+}
+
+// SessionUsage is the disk usage of one session's worktree.
+type SessionUsage struct {
+	ID           string
+	Title        string
+	WorktreePath string
+	Bytes        int64
+}
+
+// Report is the result of a Scan.
+type Report struct {
+	Repos      []RepoUsage
+	Sessions   []SessionUsage
+	Candidates []Candidate
+}
+
+// ReclaimableBytes sums the sizes of all candidates.
+func (r *Report) ReclaimableBytes() int64 {
+	var n int64
+	for _, c := range r.Candidates {
+		n += c.Bytes
+	}
+	return n
+}
+
+// Options controls a Scan.
+type Options struct {
+	ReposDir     string
+	WorktreesDir string
+	// MinAge skips worktree directories modified more recently than this, so a
+	// worktree being created right now (whose session row has no path yet) is
+	// never reported as orphaned. Zero means DefaultMinAge; a negative value
+	// disables the grace period.
+	MinAge time.Duration
+}
+
+// DefaultMinAge is the grace period applied when Options.MinAge is zero.
+const DefaultMinAge = time.Hour
+
+// Scan walks ReposDir and WorktreesDir and compares them against the sessions
+// and groups in store. It never modifies anything on disk.
+func Scan(store *db.DB, opts Options) (*Report, error) {
+	if opts.MinAge == 0 {
+		opts.MinAge = DefaultMinAge
+	}
+	sessions, err := store.LoadSessions()
+	if err != nil {
+		return nil, fmt.Errorf("load sessions: %w", err)
+	}
+	groups, err := store.LoadGroups()
+	if err != nil {
+		return nil, fmt.Errorf("load groups: %w", err)
+	}
+
+	multiRepo, err := store.LoadSessionWorktrees()
+	if err != nil {
+		return nil, fmt.Errorf("load session worktrees: %w", err)
+	}
+
+	sessionPaths := make(map[string]bool)
+	sessionBranches := make(map[string]map[string]bool) // repo -> branch
+	referencedRepos := make(map[string]bool)
+	addWorktree := func(path, repoPath, branch string) {
+		if path != "" {
+			sessionPaths[filepath.Clean(path)] = true
+		}
+		if repoPath != "" {
+			repo := filepath.Clean(repoPath)
+			referencedRepos[repo] = true
+			if sessionBranches[repo] == nil {
+				sessionBranches[repo] = make(map[string]bool)
+			}
+			sessionBranches[repo][branch] = true
+		}
+	}
+	for _, s := range sessions {
+		addWorktree(s.WorktreePath, s.WorktreeRepo, s.WorktreeBranch)
+		for _, wt := range multiRepo[s.ID] {
+			addWorktree(wt.WorktreePath, wt.RepoPath, wt.Branch)
+		}
+		if len(multiRepo[s.ID]) > 0 && s.ProjectPath != "" {
+			sessionPaths[filepath.Clean(s.ProjectPath)] = true
+		}
+	}
+	for _, g := range groups {
+		if g.RepoURL == "" {
+			continue
+		}
+		for _, u := range append([]string{g.RepoURL}, g.ExtraRepoURLs...) {
+			host, owner, repo, err := git.ParseRepoURL(u)
+			if err != nil {
+				continue
+			}
+			referencedRepos[filepath.Clean(git.BareRepoPath(opts.ReposDir, host, owner, repo))] = true
+		}
+	}
+
+	report := &Report{}
+
+	for _, s := range sessions {
+		if s.WorktreePath == "" {
+			continue
+		}
+		// A multi-repo session's worktrees all live under its session directory.
+		path := s.WorktreePath
+		if len(multiRepo[s.ID]) > 0 && s.ProjectPath != "" {
+			path = s.ProjectPath
+		}
+		report.Sessions = append(report.Sessions, SessionUsage{
+			ID:           s.ID,
+			Title:        s.Title,
+			WorktreePath: path,
+			Bytes:        DirSize(path),
+		})
+	}
+
+	// Bare repos live at <ReposDir>/<host>/<owner>/<repo>.git.
+	repos, _ := filepath.Glob(filepath.Join(opts.ReposDir, "*", "*", "*.git"))
+	sort.Strings(repos)
+	for _, repoPath := range repos {
+		if !git.IsBareRepo(repoPath) {
+			continue
+		}
+		size := DirSize(repoPath)
+		referenced := referencedRepos[filepath.Clean(repoPath)]
+		report.Repos = append(report.Repos, RepoUsage{Path: repoPath, Bytes: size, Referenced: referenced})
+		if !referenced {
+			report.Candidates = append(report.Candidates, Candidate{
+				Kind:   KindUnusedRepo,
+				Path:   repoPath,
+				Bytes:  size,
+				Reason: "no group or session references this repo",
+			})
+			continue
+		}
+		report.Candidates = append(report.Candidates, mergedBranches(repoPath, sessionBranches[filepath.Clean(repoPath)])...)
+	}
+
+	// Worktrees live at <WorktreesDir>/<host>/<owner>/<repo>/<branch>.
+	worktrees, _ := filepath.Glob(filepath.Join(opts.WorktreesDir, "*", "*", "*", "*"))
+	sort.Strings(worktrees)
+	for _, wtPath := range worktrees {
+		info, err := os.Stat(wtPath)
+		if err != nil || !info.IsDir() {
+			continue
+		}
+		if sessionPaths[filepath.Clean(wtPath)] {
+			continue
+		}
+		if time.Since(info.ModTime()) < opts.MinAge {
+			continue
+		}
+		rel, _ := filepath.Rel(opts.WorktreesDir, wtPath)
+		parts := strings.Split(rel, string(filepath.Separator))
+		if parts[0] == git.SessionsDir {
+			continue
+		}
+		repoPath := git.BareRepoPath(opts.ReposDir, parts[0], parts[1], parts[2])
+		report.Candidates = append(report.Candidates, Candidate{
+			Kind:   KindOrphanWorktree,
+			Path:   wtPath,
+			Repo:   repoPath,
+			Bytes:  DirSize(wtPath),
+			Reason: "no session owns this worktree",
+		})
+	}
+
+	// Multi-repo session directories live at <WorktreesDir>/sessions/<branch>
+	// and hold one worktree per repo. Orphans are removed as a whole.
+	roots, _ := filepath.Glob(filepath.Join(opts.WorktreesDir, git.SessionsDir, "*"))
+	sort.Strings(roots)
+	for _, root := range roots {
+		info, err := os.Stat(root)
+		if err != nil || !info.IsDir() {
+			continue
+		}
+		if sessionPaths[filepath.Clean(root)] || time.Since(info.ModTime()) < opts.MinAge {
+			continue
+		}
+		report.Candidates = append(report.Candidates, Candidate{
+			Kind:   KindOrphanWorktree,
+			Path:   root,
+			Bytes:  DirSize(root),
+			Reason: "no session owns this multi-repo session directory",
+		})
+	}
+
+	return report, nil
+}
+
+// mergedBranches returns branch candidates in repoPath that are merged into
+// the remote default branch and not in use by a session or worktree.
+func mergedBranches(repoPath string, inUse map[string]bool) []Candidate {
+	base, err := git.GetDefaultBranch(repoPath)
+	if err != nil {
+		if base, err = git.HeadBranch(repoPath); err != nil {
+			return nil
+		}
+	}
+	into := "origin/" + base
+	if !git.RefExists(repoPath, into) {
+		into = base
+	}
+	merged, err := git.MergedBranches(repoPath, into)
+	if err != nil {
+		return nil
+	}
+	checkedOut, _ := git.ListWorktreeBranches(repoPath)
+	head, _ := git.HeadBranch(repoPath)
+
+	var out []Candidate
+	for _, b := range merged {
+		if b == base || b == head || inUse[b] {
+			continue
+		}
+		if _, ok := checkedOut[b]; ok {
+			continue
+		}
+		out = append(out, Candidate{
+			Kind:   KindMergedBranch,
+			Repo:   repoPath,
+			Branch: b,
+			Reason: "merged into " + into,
+		})
+	}
+	return out
+}
+
+// Prune removes every candidate in the report. Worktrees are removed first so
+// their branches and repos are no longer in use. Errors are collected and
+// returned together; a failure on one candidate does not stop the rest.
+func Prune(report *Report) error {
+	order := map[Kind]int{KindOrphanWorktree: 0, KindMergedBranch: 1, KindUnusedRepo: 2}
+	cands := append([]Candidate(nil), report.Candidates...)
+	sort.SliceStable(cands, func(i, j int) bool { return order[cands[i].Kind] < order[cands[j].Kind] })
+
+	var errs []error
+	pruned := make(map[string]bool)
+	for _, c := range cands {
+		var err error
+		switch c.Kind {
+		case KindOrphanWorktree:
+			if git.IsBareRepo(c.Repo) {
+				err = git.RemoveWorktree(c.Repo, c.Path, true)
+				pruned[c.Repo] = true
+			} else {
+				// A multi-repo session directory: prune each contained
+				// worktree's registration from its repo once it is gone.
+				for _, repo := range containedRepos(c.Path) {
+					pruned[repo] = true
+				}
+				err = os.RemoveAll(c.Path)
+			}
+		case KindMergedBranch:
+			err = git.DeleteBranch(c.Repo, c.Branch)
+		case KindUnusedRepo:
+			err = os.RemoveAll(c.Path)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s %s: %w", c.Kind, c.Label(), err))
+		}
+	}
+	for repo := range pruned {
+		git.PruneWorktrees(repo)
+	}
+	return errors.Join(errs...)
+}
+
+// containedRepos returns the repositories of the worktrees directly under dir.
+func containedRepos(dir string) []string {
+	entries, _ := os.ReadDir(dir)
+	var repos []string
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		if repo, err := git.CommonDir(filepath.Join(dir, e.Name())); err == nil {
+			repos = append(repos, repo)
+		}
+	}
+	return repos
+}
+
+// Label returns a short human-readable identifier for the candidate.
+func (c Candidate) Label() string {
+	if c.Kind == KindMergedBranch {
+		return c.Branch + " (" + c.Repo + ")"
+	}
+	return c.Path
+}
+
+// DirSize returns the total size in bytes of all regular files under path.
+// Unreadable entries are skipped.
+func DirSize(path string) int64 {
+	var total int64
+	filepath.WalkDir(path, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if d.Type().IsRegular() {
+			if info, err := d.Info(); err == nil {
+				total += info.Size()
+			}
+		}
+		return nil
+	})
+	return total
+}
+
+// FormatBytes renders n as a short human-readable size, e.g. "1.5 GB".
+func FormatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,218 @@
+package gc_test
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/zsprackett/agent-workspace/internal/db"
+	"github.com/zsprackett/agent-workspace/internal/gc"
+	"github.com/zsprackett/agent-workspace/internal/git"
+)
+
+func openDB(t *testing.T) *db.DB {
+	t.Helper()
+	store, err := db.Open(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+func run(t *testing.T, dir string, args ...string) {
+	t.Helper()
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GIT_AUTHOR_NAME=test", "GIT_AUTHOR_EMAIL=t@t.com",
+		"GIT_COMMITTER_NAME=test", "GIT_COMMITTER_EMAIL=t@t.com")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("git %v: %v\n%s", args, err, out)
+	}
+}
+
+// setupRepo creates an upstream repo with one commit on main and a bare clone
+// of it at <reposDir>/github.com/owner/myrepo.git.
+func setupRepo(t *testing.T, reposDir string) string {
+	t.Helper()
+	upstream := t.TempDir()
+	run(t, upstream, "init", "-b", "main")
+	os.WriteFile(filepath.Join(upstream, "README.md"), []byte("hello\n"), 0644)
+	run(t, upstream, "add", ".")
+	run(t, upstream, "commit", "-m", "init")
+
+	bare := git.BareRepoPath(reposDir, "github.com", "owner", "myrepo")
+	os.MkdirAll(filepath.Dir(bare), 0755)
+	if err := git.CloneBare(upstream, bare); err != nil {
+		t.Fatal(err)
+	}
+	if err := git.FetchBare(bare); err != nil {
+		t.Fatal(err)
+	}
+	return bare
+}
+
+func TestScan_FindsOrphanWorktreeAndMergedBranch(t *testing.T) {
+	store := openDB(t)
+	reposDir, worktreesDir := t.TempDir(), t.TempDir()
+	bare := setupRepo(t, reposDir)
+	store.SaveGroups([]*db.Group{
+		{Path: "work", Name: "Work", RepoURL: "https://github.com/owner/myrepo"},
+	})
+
+	owned := git.WorktreePath(worktreesDir, "github.com", "owner", "myrepo", "owned")
+	orphan := git.WorktreePath(worktreesDir, "github.com", "owner", "myrepo", "orphan")
+	for _, p := range []string{owned, orphan} {
+		os.MkdirAll(filepath.Dir(p), 0755)
+		if _, err := git.CreateWorktree(bare, filepath.Base(p), p, "main"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	run(t, bare, "branch", "stale", "origin/main")
+
+	now := time.Now()
+	store.SaveSession(&db.Session{
+		ID: "s1", Title: "owned", GroupPath: "work", Tool: db.ToolClaude, Status: db.StatusIdle,
+		CreatedAt: now, LastAccessed: now,
+		WorktreePath: owned, WorktreeRepo: bare, WorktreeBranch: "owned",
+	})
+
+	report, err := gc.Scan(store, gc.Options{ReposDir: reposDir, WorktreesDir: worktreesDir, MinAge: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := map[string]gc.Candidate{}
+	for _, c := range report.Candidates {
+		got[string(c.Kind)+":"+c.Label()] = c
+	}
+	if _, ok := got["worktree:"+orphan]; !ok {
+		t.Errorf("expected orphan worktree candidate, got %v", report.Candidates)
+	}
+	if _, ok := got["worktree:"+owned]; ok {
+		t.Error("session-owned worktree must not be a candidate")
+	}
+	if _, ok := got["branch:stale ("+bare+")"]; !ok {
+		t.Errorf("expected merged branch 'stale' candidate, got %v", report.Candidates)
+	}
+	for _, c := range report.Candidates {
+		if c.Kind == gc.KindMergedBranch && (c.Branch == "main" || c.Branch == "owned" || c.Branch == "orphan") {
+			t.Errorf("branch %q must not be a candidate", c.Branch)
+		}
+	}
+	if len(report.Sessions) != 1 || report.Sessions[0].Bytes == 0 {
+		t.Errorf("expected disk usage for one session, got %+v", report.Sessions)
+	}
+
+	if err := gc.Prune(report); err != nil {
+		t.Fatalf("prune: %v", err)
+	}
+	if _, err := os.Stat(orphan); !os.IsNotExist(err) {
+		t.Error("orphan worktree should be removed")
+	}
+	if _, err := os.Stat(owned); err != nil {
+		t.Error("owned worktree should remain")
+	}
+	if git.BranchExists(bare, "stale") {
+		t.Error("merged branch should be deleted")
+	}
+}
+
+func TestScan_UnusedRepo(t *testing.T) {
+	store := openDB(t)
+	reposDir := t.TempDir()
+	bare := setupRepo(t, reposDir)
+
+	report, err := gc.Scan(store, gc.Options{ReposDir: reposDir, WorktreesDir: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Candidates) != 1 || report.Candidates[0].Kind != gc.KindUnusedRepo || report.Candidates[0].Path != bare {
+		t.Fatalf("expected unused repo candidate for %s, got %+v", bare, report.Candidates)
+	}
+	if report.Candidates[0].Bytes == 0 {
+		t.Error("expected non-zero size for unused repo")
+	}
+}
+
+func TestScan_SkipsRecentWorktrees(t *testing.T) {
+	store := openDB(t)
+	worktreesDir := t.TempDir()
+	os.MkdirAll(filepath.Join(worktreesDir, "github.com", "owner", "myrepo", "fresh"), 0755)
+
+	report, err := gc.Scan(store, gc.Options{ReposDir: t.TempDir(), WorktreesDir: worktreesDir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Candidates) != 0 {
+		t.Errorf("expected recently modified worktree to be skipped, got %+v", report.Candidates)
+	}
+}
+
+func TestFormatBytes(t *testing.T) {
+	cases := map[int64]string{
+		512:             "512 B",
+		1536:            "1.5 KB",
+		3 * 1024 * 1024: "3.0 MB",
+	}
+	for in, want := range cases {
+		if got := gc.FormatBytes(in); got != want {
+			t.Errorf("FormatBytes(%d) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestScan_MultiRepoSessionDirs(t *testing.T) {
+	store := openDB(t)
+	reposDir, worktreesDir := t.TempDir(), t.TempDir()
+	bare := setupRepo(t, reposDir)
+	store.SaveGroups([]*db.Group{
+		{Path: "work", Name: "Work", RepoURL: "https://github.com/owner/myrepo"},
+	})
+
+	owned := git.SessionRootPath(worktreesDir, "owned")
+	orphan := git.SessionRootPath(worktreesDir, "orphan")
+	for _, root := range []string{owned, orphan} {
+		os.MkdirAll(root, 0755)
+		if _, err := git.CreateWorktree(bare, filepath.Base(root), filepath.Join(root, "myrepo"), "main"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	now := time.Now()
+	wt := filepath.Join(owned, "myrepo")
+	store.SaveSession(&db.Session{
+		ID: "s1", Title: "owned", GroupPath: "work", Tool: db.ToolClaude, Status: db.StatusIdle,
+		CreatedAt: now, LastAccessed: now,
+		ProjectPath: owned, WorktreePath: wt, WorktreeRepo: bare, WorktreeBranch: "owned",
+	})
+	store.SaveSessionWorktrees("s1", []*db.SessionWorktree{
+		{RepoPath: bare, WorktreePath: wt, Branch: "owned"},
+	})
+
+	report, err := gc.Scan(store, gc.Options{ReposDir: reposDir, WorktreesDir: worktreesDir, MinAge: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Candidates) != 1 || report.Candidates[0].Path != orphan {
+		t.Fatalf("expected only the orphan session dir as a candidate, got %+v", report.Candidates)
+	}
+	if len(report.Sessions) != 1 || report.Sessions[0].WorktreePath != owned {
+		t.Errorf("expected usage reported for the session directory, got %+v", report.Sessions)
+	}
+
+	if err := gc.Prune(report); err != nil {
+		t.Fatalf("prune: %v", err)
+	}
+	if _, err := os.Stat(orphan); !os.IsNotExist(err) {
+		t.Error("orphan session dir should be removed")
+	}
+	if branches, _ := git.ListWorktreeBranches(bare); branches["orphan"] != "" {
+		t.Error("orphan worktree registration should be pruned")
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strconv"
 	"strings"
 )
 
@@ -107,7 +108,7 @@ func CreateWorktree(repoDir, branchName, worktreePath, baseBranch string) (strin
 			base = "HEAD"
 		}
 		startPoint := base
-		if base != "HEAD" {
+		if base != "HEAD" && RefExists(repoDir, "origin/"+base) {
 			upstream = "origin/" + base
 			// Use the remote tracking ref as the start point so the new worktree
 			// begins at the latest fetched commit rather than the (potentially
@@ -128,6 +129,74 @@ func CreateWorktree(repoDir, branchName, worktreePath, baseBranch string) (strin
 	return worktreePath, nil
 }
 
+// ErrBranchDiverged is returned by CheckoutWorktree when the local branch
+// and its remote each have commits the other lacks.
+var ErrBranchDiverged = errors.New("local branch has diverged from the remote")
+
+// CheckoutWorktree creates a worktree at worktreePath for the existing remote
+// branch origin/<branch>, with the local branch set to track it. A new local
+// branch starts at the remote tip. An existing one is fast-forwarded to it
+// if it is behind, and used as is if it is ahead; local commits are never
+// discarded, so a branch that has diverged is refused with
+// ErrBranchDiverged.
+func CheckoutWorktree(repoDir, branch, worktreePath string) (string, error) {
+	if err := ValidateBranchName(branch); err != nil {
+		return "", err
+	}
+	remote := "origin/" + branch
+	if !RefExists(repoDir, remote) {
+		return "", fmt.Errorf("remote branch %s not found", remote)
+	}
+	var cmd *exec.Cmd
+	switch {
+	case !BranchExists(repoDir, branch):
+		cmd = exec.Command("git", "-C", repoDir, "worktree", "add", "--track", "-b", branch, worktreePath, remote)
+	case IsAncestor(repoDir, branch, remote):
+		// Behind or level with the remote: resetting loses nothing.
+		cmd = exec.Command("git", "-C", repoDir, "worktree", "add", "--track", "-B", branch, worktreePath, remote)
+	case IsAncestor(repoDir, remote, branch):
+		cmd = exec.Command("git", "-C", repoDir, "worktree", "add", worktreePath, branch)
+	default:
+		return "", fmt.Errorf("%w: %s and %s", ErrBranchDiverged, branch, remote)
+	}
+	if out, err := cmd.CombinedOutput(); err != nil {
+		if strings.Contains(string(out), "already exists") {
+			return "", ErrWorktreeExists
+		}
+		return "", fmt.Errorf("checkout worktree: %s", out)
+	}
+	exec.Command("git", "-C", repoDir, "branch", "--set-upstream-to="+remote, branch).Run()
+	return worktreePath, nil
+}
+
+// IsAncestor reports whether revision a is an ancestor of, or the same
+// commit as, revision b.
+func IsAncestor(repoDir, a, b string) bool {
+	return exec.Command("git", "-C", repoDir, "merge-base", "--is-ancestor", a, b).Run() == nil
+}
+
+// ListRemoteBranches returns the branch names under refs/remotes/origin,
+// excluding the origin/HEAD symbolic ref.
+func ListRemoteBranches(repoDir string) ([]string, error) {
+	out, err := exec.Command("git", "-C", repoDir, "for-each-ref",
+		"--format=%(refname:lstrip=3)", "refs/remotes/origin").Output()
+	if err != nil {
+		return nil, fmt.Errorf("list remote branches: %w", err)
+	}
+	var branches []string
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		if line != "" && line != "HEAD" {
+			branches = append(branches, line)
+		}
+	}
+	return branches, nil
+}
+
+// RefExists reports whether ref resolves to a commit in repoDir.
+func RefExists(repoDir, ref string) bool {
+	return exec.Command("git", "-C", repoDir, "rev-parse", "--verify", "--quiet", ref+"^{commit}").Run() == nil
+}
+
 func RemoveWorktree(repoDir, worktreePath string, force bool) error {
 	args := []string{"-C", repoDir, "worktree", "remove"}
 	if force {
@@ -198,6 +267,12 @@ func GetDefaultBranch(repoDir string) (string, error) {
 			return branch, nil
 		}
 	}
+	// A bare clone's HEAD mirrors the remote's default branch at clone time.
+	if IsBareRepo(repoDir) {
+		if branch, err := HeadBranch(repoDir); err == nil && BranchExists(repoDir, branch) {
+			return branch, nil
+		}
+	}
 	if BranchExists(repoDir, "main") {
 		return "main", nil
 	}
@@ -258,6 +333,17 @@ func WorktreePath(baseDir, host, owner, repo, branch string) string {
 	return filepath.Join(baseDir, host, owner, repo, branch)
 }
 
+// SessionsDir is the subdirectory of the worktrees base dir that holds
+// multi-repo session directories.
+const SessionsDir = "sessions"
+
+// SessionRootPath returns the directory of a multi-repo session, which holds
+// one worktree per repo.
+// e.g. ~/.agent-workspace/worktrees/sessions/swift-fox
+func SessionRootPath(baseDir, branch string) string {
+	return filepath.Join(baseDir, SessionsDir, branch)
+}
+
 // ensureRemoteTrackingRefs ensures the remote tracking refspec is configured on the
 // bare repo so worktrees see remote tracking refs (refs/remotes/origin/main, etc.)
 // for upstream tracking and git status. We intentionally do NOT add a
@@ -291,6 +377,10 @@ func CloneBare(remoteURL, destPath string) error {
 		return fmt.Errorf("clone bare: %s", out)
 	}
 	ensureRemoteTrackingRefs(destPath)
+	// Populate refs/remotes/origin/* now; clone --bare only writes refs/heads/*.
+	if out, err := exec.Command("git", "-C", destPath, "fetch", "--prune").CombinedOutput(); err != nil {
+		return fmt.Errorf("fetch after clone: %s", out)
+	}
 	return nil
 }
 
@@ -323,3 +413,186 @@ func IsWorktreeDirty(path string) (bool, error) {
 	}
 	return len(strings.TrimSpace(string(out))) > 0, nil
 }
+
+// ListWorktreeBranches returns the branches checked out in any worktree of
+// repoDir, keyed by branch name with the worktree path as value.
+func ListWorktreeBranches(repoDir string) (map[string]string, error) {
+	out, err := exec.Command("git", "-C", repoDir, "worktree", "list", "--porcelain").Output()
+	if err != nil {
+		return nil, fmt.Errorf("worktree list: %w", err)
+	}
+	branches := make(map[string]string)
+	var path string
+	for _, line := range strings.Split(string(out), "\n") {
+		switch {
+		case strings.HasPrefix(line, "worktree "):
+			path = strings.TrimPrefix(line, "worktree ")
+		case strings.HasPrefix(line, "branch "):
+			branches[strings.TrimPrefix(line, "branch refs/heads/")] = path
+		}
+	}
+	return branches, nil
+}
+
+// MergedBranches returns the local branches of repoDir that are fully merged
+// into the given ref (e.g. "origin/main"). The result may include the branch
+// HEAD points at; callers decide which branches are safe to delete.
+func MergedBranches(repoDir, into string) ([]string, error) {
+	out, err := exec.Command("git", "-C", repoDir, "for-each-ref",
+		"--merged", into, "--format=%(refname:short)", "refs/heads").Output()
+	if err != nil {
+		return nil, fmt.Errorf("for-each-ref: %w", err)
+	}
+	var branches []string
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		if line != "" {
+			branches = append(branches, line)
+		}
+	}
+	return branches, nil
+}
+
+// HeadBranch returns the branch HEAD points at in repoDir, which for a bare
+// clone is the remote's default branch at clone time.
+func HeadBranch(repoDir string) (string, error) {
+	out, err := exec.Command("git", "-C", repoDir, "symbolic-ref", "--short", "HEAD").Output()
+	if err != nil {
+		return "", fmt.Errorf("symbolic-ref: %w", err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// DeleteBranch force-deletes a local branch in repoDir.
+func DeleteBranch(repoDir, branch string) error {
+	out, err := exec.Command("git", "-C", repoDir, "branch", "-D", branch).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("delete branch %s: %s", branch, out)
+	}
+	return nil
+}
+
+// CommonDir returns the absolute path of the repository that the worktree at
+// path belongs to (for a linked worktree of a bare repo, the bare repo).
+func CommonDir(path string) (string, error) {
+	out, err := exec.Command("git", "-C", path, "rev-parse", "--path-format=absolute", "--git-common-dir").Output()
+	if err != nil {
+		return "", fmt.Errorf("git common dir: %w", err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// PruneWorktrees removes administrative data for worktrees whose directories
+// no longer exist.
+func PruneWorktrees(repoDir string) error {
+	out, err := exec.Command("git", "-C", repoDir, "worktree", "prune").CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("worktree prune: %s", out)
+	}
+	return nil
+}
+
+// HeadCommit returns the full hash of the commit checked out at path.
+func HeadCommit(path string) (string, error) {
+	out, err := exec.Command("git", "-C", path, "rev-parse", "HEAD").Output()
+	if err != nil {
+		return "", fmt.Errorf("rev-parse HEAD: %w", err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// StashCreate records the uncommitted changes to tracked files at path as a
+// stash commit without touching the worktree or the stash list. It returns ""
+// when there is nothing to stash.
+func StashCreate(path string) (string, error) {
+	out, err := exec.Command("git", "-C", path, "stash", "create").CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("stash create: %s", out)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// StashApply applies the stash commit rev to the worktree at path.
+func StashApply(path, rev string) error {
+	out, err := exec.Command("git", "-C", path, "stash", "apply", rev).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("stash apply: %s", out)
+	}
+	return nil
+}
+
+// UntrackedFiles lists the untracked, non-ignored files at path relative to
+// the worktree root.
+func UntrackedFiles(path string) ([]string, error) {
+	out, err := exec.Command("git", "-C", path, "ls-files", "--others", "--exclude-standard", "-z").Output()
+	if err != nil {
+		return nil, fmt.Errorf("ls-files: %w", err)
+	}
+	var files []string
+	for _, f := range strings.Split(string(out), "\x00") {
+		if f != "" {
+			files = append(files, f)
+		}
+	}
+	return files, nil
+}
+
+// FileStat is the number of lines added and deleted in one file between two
+// revisions. Binary files have no line counts.
+type FileStat struct {
+	Path    string
+	Added   int
+	Deleted int
+	Binary  bool
+}
+
+// DiffNumstat returns per-file change counts between revisions from and to
+// of repoDir.
+func DiffNumstat(repoDir, from, to string) ([]FileStat, error) {
+	out, err := exec.Command("git", "-C", repoDir, "diff", "--numstat", from, to, "--").Output()
+	if err != nil {
+		return nil, fmt.Errorf("diff --numstat: %w", err)
+	}
+	var stats []FileStat
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		parts := strings.SplitN(line, "\t", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		st := FileStat{Path: parts[2]}
+		if parts[0] == "-" && parts[1] == "-" {
+			st.Binary = true
+		} else {
+			st.Added, _ = strconv.Atoi(parts[0])
+			st.Deleted, _ = strconv.Atoi(parts[1])
+		}
+		stats = append(stats, st)
+	}
+	return stats, nil
+}
+
+// Diff returns the patch between revisions from and to of repoDir.
+func Diff(repoDir, from, to string) (string, error) {
+	out, err := exec.Command("git", "-C", repoDir, "diff", from, to, "--").Output()
+	if err != nil {
+		return "", fmt.Errorf("diff: %w", err)
+	}
+	return string(out), nil
+}
+
+// MergeBase returns the best common ancestor of revisions a and b.
+func MergeBase(repoDir, a, b string) (string, error) {
+	out, err := exec.Command("git", "-C", repoDir, "merge-base", a, b).Output()
+	if err != nil {
+		return "", fmt.Errorf("merge-base %s %s: %w", a, b, err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// CountCommits returns the number of commits reachable from to but not from.
+func CountCommits(repoDir, from, to string) (int, error) {
+	out, err := exec.Command("git", "-C", repoDir, "rev-list", "--count", from+".."+to).Output()
+	if err != nil {
+		return 0, fmt.Errorf("rev-list: %w", err)
+	}
+	return strconv.Atoi(strings.TrimSpace(string(out)))
+}
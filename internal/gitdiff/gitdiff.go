@@ -0,0 +1,291 @@
+// Package gitdiff parses git's unified diff output into files, hunks and
+// numbered lines, and stages, unstages or discards individual hunks.
+package gitdiff
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Line kinds.
+const (
+	LineContext = "context"
+	LineAdd     = "add"
+	LineDelete  = "delete"
+)
+
+// File statuses.
+const (
+	StatusModified  = "modified"
+	StatusAdded     = "added"
+	StatusDeleted   = "deleted"
+	StatusRenamed   = "renamed"
+	StatusCopied    = "copied"
+	StatusUntracked = "untracked"
+)
+
+// Line is one line of a hunk. OldLine and NewLine are 1-based line numbers
+// in the old and new file; a line only present on one side has 0 for the
+// other.
+type Line struct {
+	Kind    string `json:"kind"`
+	Content string `json:"content"`
+	OldLine int    `json:"old_line,omitempty"`
+	NewLine int    `json:"new_line,omitempty"`
+	// NoNewline is set on the last line of a file without a trailing newline.
+	NoNewline bool `json:"no_newline,omitempty"`
+}
+
+// Hunk is one "@@ -a,b +c,d @@" section of a file's diff.
+type Hunk struct {
+	Header   string `json:"header"`
+	OldStart int    `json:"old_start"`
+	OldLines int    `json:"old_lines"`
+	NewStart int    `json:"new_start"`
+	NewLines int    `json:"new_lines"`
+	Lines    []Line `json:"lines"`
+}
+
+// File is the diff of one file.
+type File struct {
+	OldPath string `json:"old_path"`
+	NewPath string `json:"new_path"`
+	Status  string `json:"status"`
+	Binary  bool   `json:"binary,omitempty"`
+	// Similarity is the rename or copy similarity percentage.
+	Similarity int    `json:"similarity,omitempty"`
+	OldMode    string `json:"old_mode,omitempty"`
+	NewMode    string `json:"new_mode,omitempty"`
+	Added      int    `json:"added"`
+	Deleted    int    `json:"deleted"`
+	Hunks      []Hunk `json:"hunks"`
+
+	// header holds the lines from "diff --git" up to the first hunk, used to
+	// rebuild single-hunk patches.
+	header []string
+}
+
+// Path is the file's current path: the new path, or the old one if the file
+// was deleted.
+func (f *File) Path() string {
+	if f.Status == StatusDeleted {
+		return f.OldPath
+	}
+	return f.NewPath
+}
+
+// Parse parses the output of git diff (without color) into files.
+func Parse(diff string) ([]*File, error) {
+	var files []*File
+	var f *File
+	var h *Hunk
+	oldNo, newNo := 0, 0
+	lines := strings.Split(diff, "\n")
+	if n := len(lines); n > 0 && lines[n-1] == "" {
+		lines = lines[:n-1]
+	}
+	for _, line := range lines {
+		if strings.HasPrefix(line, "diff --git ") {
+			f = &File{Status: StatusModified, Hunks: []Hunk{}}
+			f.OldPath, f.NewPath = parseGitPaths(strings.TrimPrefix(line, "diff --git "))
+			f.header = []string{line}
+			files = append(files, f)
+			h = nil
+			continue
+		}
+		if f == nil {
+			continue
+		}
+		if h == nil && !strings.HasPrefix(line, "@@") {
+			f.header = append(f.header, line)
+			parseHeaderLine(f, line)
+			continue
+		}
+		switch {
+		case strings.HasPrefix(line, "@@"):
+			hunk, err := parseHunkHeader(line)
+			if err != nil {
+				return nil, err
+			}
+			f.Hunks = append(f.Hunks, hunk)
+			h = &f.Hunks[len(f.Hunks)-1]
+			oldNo, newNo = h.OldStart, h.NewStart
+		case strings.HasPrefix(line, "+"):
+			h.Lines = append(h.Lines, Line{Kind: LineAdd, Content: line[1:], NewLine: newNo})
+			newNo++
+			f.Added++
+		case strings.HasPrefix(line, "-"):
+			h.Lines = append(h.Lines, Line{Kind: LineDelete, Content: line[1:], OldLine: oldNo})
+			oldNo++
+			f.Deleted++
+		case strings.HasPrefix(line, " ") || line == "":
+			content := ""
+			if line != "" {
+				content = line[1:]
+			}
+			h.Lines = append(h.Lines, Line{Kind: LineContext, Content: content, OldLine: oldNo, NewLine: newNo})
+			oldNo++
+			newNo++
+		case strings.HasPrefix(line, `\`):
+			if n := len(h.Lines); n > 0 {
+				h.Lines[n-1].NoNewline = true
+			}
+		}
+	}
+	return files, nil
+}
+
+func parseHeaderLine(f *File, line string) {
+	switch {
+	case strings.HasPrefix(line, "new file mode "):
+		f.Status = StatusAdded
+		f.NewMode = strings.TrimPrefix(line, "new file mode ")
+	case strings.HasPrefix(line, "deleted file mode "):
+		f.Status = StatusDeleted
+		f.OldMode = strings.TrimPrefix(line, "deleted file mode ")
+	case strings.HasPrefix(line, "old mode "):
+		f.OldMode = strings.TrimPrefix(line, "old mode ")
+	case strings.HasPrefix(line, "new mode "):
+		f.NewMode = strings.TrimPrefix(line, "new mode ")
+	case strings.HasPrefix(line, "similarity index "):
+		f.Similarity, _ = strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(line, "similarity index "), "%"))
+	case strings.HasPrefix(line, "rename from "):
+		f.Status = StatusRenamed
+		f.OldPath = unquote(strings.TrimPrefix(line, "rename from "))
+	case strings.HasPrefix(line, "rename to "):
+		f.NewPath = unquote(strings.TrimPrefix(line, "rename to "))
+	case strings.HasPrefix(line, "copy from "):
+		f.Status = StatusCopied
+		f.OldPath = unquote(strings.TrimPrefix(line, "copy from "))
+	case strings.HasPrefix(line, "copy to "):
+		f.NewPath = unquote(strings.TrimPrefix(line, "copy to "))
+	case strings.HasPrefix(line, "--- "):
+		if p := strings.TrimPrefix(line, "--- "); p != "/dev/null" {
+			f.OldPath = stripPrefix(unquote(p), "a/")
+		}
+	case strings.HasPrefix(line, "+++ "):
+		if p := strings.TrimPrefix(line, "+++ "); p != "/dev/null" {
+			f.NewPath = stripPrefix(unquote(p), "b/")
+		}
+	case strings.HasPrefix(line, "Binary files ") || line == "GIT binary patch":
+		f.Binary = true
+	}
+}
+
+// parseGitPaths splits the "a/<old> b/<new>" part of a "diff --git" line.
+// Unquoted paths containing " b/" are ambiguous; the split is made where both
+// halves are equal, which holds for everything but renames, whose paths are
+// taken from the rename lines instead.
+func parseGitPaths(s string) (string, string) {
+	if strings.HasPrefix(s, `"`) {
+		if end := closingQuote(s); end > 0 {
+			old := unquote(s[:end+1])
+			return stripPrefix(old, "a/"), stripPrefix(unquote(strings.TrimSpace(s[end+1:])), "b/")
+		}
+	}
+	if strings.HasSuffix(s, `"`) {
+		if i := strings.Index(s, ` "`); i >= 0 {
+			return stripPrefix(s[:i], "a/"), stripPrefix(unquote(s[i+1:]), "b/")
+		}
+	}
+	if n := len(s); n%2 == 1 {
+		half := (n - 1) / 2
+		if s[half] == ' ' && s[2:half] == s[half+3:] {
+			return s[2:half], s[half+3:]
+		}
+	}
+	if i := strings.Index(s, " b/"); i >= 0 {
+		return stripPrefix(s[:i], "a/"), s[i+3:]
+	}
+	return s, s
+}
+
+func closingQuote(s string) int {
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			return i
+		}
+	}
+	return -1
+}
+
+// unquote undoes git's C-style quoting of paths with special characters.
+func unquote(s string) string {
+	if len(s) >= 2 && s[0] == '"' && s[len(s)-1] == '"' {
+		if u, err := strconv.Unquote(s); err == nil {
+			return u
+		}
+	}
+	return s
+}
+
+func stripPrefix(s, prefix string) string {
+	return strings.TrimPrefix(s, prefix)
+}
+
+// parseHunkHeader parses "@@ -a[,b] +c[,d] @@ [section]".
+func parseHunkHeader(line string) (Hunk, error) {
+	h := Hunk{Header: line, Lines: []Line{}}
+	rest := strings.TrimPrefix(line, "@@ ")
+	end := strings.Index(rest, " @@")
+	if end < 0 {
+		return h, fmt.Errorf("malformed hunk header %q", line)
+	}
+	ranges := strings.Fields(rest[:end])
+	if len(ranges) != 2 || !strings.HasPrefix(ranges[0], "-") || !strings.HasPrefix(ranges[1], "+") {
+		return h, fmt.Errorf("malformed hunk header %q", line)
+	}
+	var err error
+	if h.OldStart, h.OldLines, err = parseRange(ranges[0][1:]); err != nil {
+		return h, fmt.Errorf("hunk header %q: %w", line, err)
+	}
+	if h.NewStart, h.NewLines, err = parseRange(ranges[1][1:]); err != nil {
+		return h, fmt.Errorf("hunk header %q: %w", line, err)
+	}
+	return h, nil
+}
+
+func parseRange(s string) (start, count int, err error) {
+	count = 1
+	if i := strings.IndexByte(s, ','); i >= 0 {
+		if count, err = strconv.Atoi(s[i+1:]); err != nil {
+			return 0, 0, err
+		}
+		s = s[:i]
+	}
+	start, err = strconv.Atoi(s)
+	return start, count, err
+}
+
+// Patch rebuilds a patch for f containing only hunk i, suitable for
+// git apply.
+func (f *File) Patch(i int) string {
+	var sb strings.Builder
+	for _, l := range f.header {
+		sb.WriteString(l)
+		sb.WriteByte('\n')
+	}
+	h := f.Hunks[i]
+	sb.WriteString(h.Header)
+	sb.WriteByte('\n')
+	for _, l := range h.Lines {
+		switch l.Kind {
+		case LineAdd:
+			sb.WriteByte('+')
+		case LineDelete:
+			sb.WriteByte('-')
+		default:
+			sb.WriteByte(' ')
+		}
+		sb.WriteString(l.Content)
+		sb.WriteByte('\n')
+		if l.NoNewline {
+			sb.WriteString("\\ No newline at end of file\n")
+		}
+	}
+	return sb.String()
+}
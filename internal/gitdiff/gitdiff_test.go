@@ -0,0 +1,224 @@
+package gitdiff_test
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/zsprackett/agent-workspace/internal/gitdiff"
+)
+
+const sampleDiff = `diff --git a/main.go b/main.go
+index 1111111..2222222 100644
+--- a/main.go
++++ b/main.go
+@@ -1,4 +1,4 @@ package main
+ package main
+-func old() {}
++func new() {}
+
+ // end
+@@ -10,2 +10,3 @@ func tail() {
+ a
++b
+ c
+\ No newline at end of file
+diff --git a/old name.txt b/new name.txt
+similarity index 90%
+rename from old name.txt
+rename to new name.txt
+index 3333333..4444444 100644
+--- a/old name.txt
++++ b/new name.txt
+@@ -1 +1 @@
+-x
++y
+diff --git a/logo.png b/logo.png
+new file mode 100644
+index 0000000..5555555
+Binary files /dev/null and b/logo.png differ
+diff --git a/gone.txt b/gone.txt
+deleted file mode 100644
+index 6666666..0000000
+--- a/gone.txt
++++ /dev/null
+@@ -1 +0,0 @@
+-bye
+diff --git "a/caf\303\251.txt" "b/caf\303\251.txt"
+index 7777777..8888888 100644
+--- "a/caf\303\251.txt"
++++ "b/caf\303\251.txt"
+@@ -1 +1,2 @@
+ a
++b
+`
+
+func TestParse(t *testing.T) {
+	files, err := gitdiff.Parse(sampleDiff)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 5 {
+		t.Fatalf("expected 5 files, got %d", len(files))
+	}
+
+	m := files[0]
+	if m.Path() != "main.go" || m.Status != gitdiff.StatusModified || m.Added != 2 || m.Deleted != 1 {
+		t.Errorf("main.go: %+v", m)
+	}
+	if len(m.Hunks) != 2 {
+		t.Fatalf("main.go: expected 2 hunks, got %d", len(m.Hunks))
+	}
+	h := m.Hunks[0]
+	if h.OldStart != 1 || h.OldLines != 4 || h.NewStart != 1 || h.NewLines != 4 {
+		t.Errorf("hunk range: %+v", h)
+	}
+	if l := h.Lines[1]; l.Kind != gitdiff.LineDelete || l.OldLine != 2 || l.NewLine != 0 || l.Content != "func old() {}" {
+		t.Errorf("deleted line: %+v", l)
+	}
+	if l := h.Lines[2]; l.Kind != gitdiff.LineAdd || l.NewLine != 2 || l.OldLine != 0 {
+		t.Errorf("added line: %+v", l)
+	}
+	if l := h.Lines[3]; l.Kind != gitdiff.LineContext || l.Content != "" || l.OldLine != 3 || l.NewLine != 3 {
+		t.Errorf("blank context line: %+v", l)
+	}
+	tail := m.Hunks[1]
+	if l := tail.Lines[2]; l.Content != "c" || !l.NoNewline || l.OldLine != 11 || l.NewLine != 12 {
+		t.Errorf("last line: %+v", l)
+	}
+	if p := m.Patch(1); !strings.HasPrefix(p, "diff --git a/main.go b/main.go\n") ||
+		!strings.Contains(p, "@@ -10,2 +10,3 @@ func tail() {\n a\n+b\n c\n\\ No newline at end of file\n") ||
+		strings.Contains(p, "func old") {
+		t.Errorf("patch for hunk 1:\n%s", p)
+	}
+
+	r := files[1]
+	if r.Status != gitdiff.StatusRenamed || r.OldPath != "old name.txt" || r.NewPath != "new name.txt" || r.Similarity != 90 {
+		t.Errorf("rename: %+v", r)
+	}
+	b := files[2]
+	if !b.Binary || b.Status != gitdiff.StatusAdded || b.NewPath != "logo.png" || len(b.Hunks) != 0 {
+		t.Errorf("binary: %+v", b)
+	}
+	d := files[3]
+	if d.Status != gitdiff.StatusDeleted || d.Path() != "gone.txt" || d.Deleted != 1 {
+		t.Errorf("deleted: %+v", d)
+	}
+	if q := files[4]; q.Path() != "café.txt" {
+		t.Errorf("quoted path: got %q", q.Path())
+	}
+}
+
+func git(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GIT_AUTHOR_NAME=test", "GIT_AUTHOR_EMAIL=t@t.com",
+		"GIT_COMMITTER_NAME=test", "GIT_COMMITTER_EMAIL=t@t.com")
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %v: %v\n%s", args, err, out)
+	}
+	return string(out)
+}
+
+func TestApplyAndCommit(t *testing.T) {
+	dir := t.TempDir()
+	git(t, dir, "init", "-b", "main")
+	git(t, dir, "config", "user.name", "test")
+	git(t, dir, "config", "user.email", "t@t.com")
+	var lines []string
+	for i := 1; i <= 20; i++ {
+		lines = append(lines, fmt.Sprintf("line %d", i))
+	}
+	os.WriteFile(filepath.Join(dir, "f.txt"), []byte(strings.Join(lines, "\n")+"\n"), 0644)
+	git(t, dir, "add", ".")
+	git(t, dir, "commit", "-m", "init")
+
+	// Two separate hunks: change the first and the last line.
+	lines[0], lines[19] = "first", "last"
+	os.WriteFile(filepath.Join(dir, "f.txt"), []byte(strings.Join(lines, "\n")+"\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "new.txt"), []byte("new\n"), 0644)
+
+	c, err := gitdiff.Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(c.Staged) != 0 || len(c.Unstaged) != 2 {
+		t.Fatalf("expected 2 unstaged files, got %+v", c)
+	}
+	f := c.Unstaged[0]
+	if f.Path() != "f.txt" || len(f.Hunks) != 2 {
+		t.Fatalf("f.txt: %+v", f)
+	}
+	if u := c.Unstaged[1]; u.Status != gitdiff.StatusUntracked || u.Path() != "new.txt" || u.Added != 1 {
+		t.Errorf("untracked: %+v", u)
+	}
+
+	if err := gitdiff.Apply(dir, gitdiff.ActionStage, "f.txt", 0, "@@ stale @@"); err != gitdiff.ErrStale {
+		t.Errorf("expected ErrStale, got %v", err)
+	}
+	if err := gitdiff.Apply(dir, gitdiff.ActionStage, "nope.txt", -1, ""); err != gitdiff.ErrNotFound {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+	if err := gitdiff.Apply(dir, gitdiff.ActionStage, "f.txt", 0, f.Hunks[0].Header); err != nil {
+		t.Fatalf("stage hunk: %v", err)
+	}
+	if got := git(t, dir, "diff", "--cached", "--numstat"); got != "1\t1\tf.txt\n" {
+		t.Errorf("staged after hunk stage: %q", got)
+	}
+
+	// Discard the remaining unstaged hunk; the staged one must survive.
+	c, _ = gitdiff.Load(dir)
+	if err := gitdiff.Apply(dir, gitdiff.ActionDiscard, "f.txt", 0, c.Unstaged[0].Hunks[0].Header); err != nil {
+		t.Fatalf("discard hunk: %v", err)
+	}
+	if b, _ := os.ReadFile(filepath.Join(dir, "f.txt")); !strings.HasPrefix(string(b), "first\n") || !strings.HasSuffix(string(b), "line 20\n") {
+		t.Errorf("after discard: %q", b)
+	}
+
+	// Unstage and restage the hunk, then stage the untracked file.
+	c, _ = gitdiff.Load(dir)
+	if err := gitdiff.Apply(dir, gitdiff.ActionUnstage, "f.txt", 0, c.Staged[0].Hunks[0].Header); err != nil {
+		t.Fatalf("unstage hunk: %v", err)
+	}
+	if ok, _ := gitdiff.HasStaged(dir); ok {
+		t.Error("expected nothing staged after unstage")
+	}
+	if err := gitdiff.Apply(dir, gitdiff.ActionStage, "f.txt", -1, ""); err != nil {
+		t.Fatalf("stage file: %v", err)
+	}
+	c, _ = gitdiff.Load(dir)
+	if len(c.Unstaged) != 1 || c.Unstaged[0].Path() != "new.txt" {
+		t.Fatalf("expected only new.txt unstaged, got %+v", c.Unstaged)
+	}
+	if err := gitdiff.Apply(dir, gitdiff.ActionStage, "new.txt", 0, c.Unstaged[0].Hunks[0].Header); err != nil {
+		t.Fatalf("stage untracked: %v", err)
+	}
+
+	if _, err := gitdiff.Commit(dir, "  "); err == nil {
+		t.Error("expected empty message to be rejected")
+	}
+	hash, err := gitdiff.Commit(dir, "Apply review")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := git(t, dir, "log", "-1", "--format=%h %s"); got != hash+" Apply review\n" {
+		t.Errorf("commit: got %q, hash %q", got, hash)
+	}
+	if c, _ := gitdiff.Load(dir); len(c.Staged)+len(c.Unstaged) != 0 {
+		t.Errorf("expected clean worktree, got %+v", c)
+	}
+
+	// Discarding an untracked file removes it.
+	os.WriteFile(filepath.Join(dir, "junk.txt"), []byte("x\n"), 0644)
+	if err := gitdiff.Apply(dir, gitdiff.ActionDiscard, "junk.txt", -1, ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "junk.txt")); !os.IsNotExist(err) {
+		t.Error("expected untracked file to be removed")
+	}
+}
@@ -0,0 +1,244 @@
+package gitdiff
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Changes are the uncommitted changes of a worktree. Unstaged includes
+// untracked files, with StatusUntracked.
+type Changes struct {
+	Staged   []*File `json:"staged"`
+	Unstaged []*File `json:"unstaged"`
+}
+
+// Actions on a file or hunk.
+const (
+	ActionStage   = "stage"
+	ActionUnstage = "unstage"
+	ActionDiscard = "discard"
+)
+
+var (
+	// ErrNotFound is returned when the file or hunk is not in the diff.
+	ErrNotFound = errors.New("file or hunk not found in diff")
+	// ErrStale is returned when the hunk no longer matches the header the
+	// caller saw, i.e. the diff changed since it was loaded.
+	ErrStale = errors.New("diff has changed; reload and try again")
+)
+
+// diffArgs returns the arguments for a git diff with extra appended: rename
+// detection, no color or external diff drivers, and unquoted non-ASCII paths.
+func diffArgs(extra ...string) []string {
+	return append([]string{"-c", "core.quotepath=off", "diff", "--no-color", "--no-ext-diff", "-M"}, extra...)
+}
+
+func gitOutput(dir string, args ...string) (string, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	out, err := cmd.Output()
+	if err != nil {
+		var ee *exec.ExitError
+		if errors.As(err, &ee) {
+			return "", fmt.Errorf("git: %s", strings.TrimSpace(string(ee.Stderr)))
+		}
+		return "", err
+	}
+	return string(out), nil
+}
+
+// Load returns the staged and unstaged changes of the worktree at dir.
+func Load(dir string) (*Changes, error) {
+	staged, err := gitOutput(dir, diffArgs("--cached")...)
+	if err != nil {
+		return nil, err
+	}
+	unstaged, err := gitOutput(dir, diffArgs()...)
+	if err != nil {
+		return nil, err
+	}
+	c := &Changes{}
+	if c.Staged, err = Parse(staged); err != nil {
+		return nil, err
+	}
+	if c.Unstaged, err = Parse(unstaged); err != nil {
+		return nil, err
+	}
+	untracked, err := untrackedFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range untracked {
+		f, err := untrackedDiff(dir, path)
+		if err != nil {
+			return nil, err
+		}
+		c.Unstaged = append(c.Unstaged, f)
+	}
+	if c.Staged == nil {
+		c.Staged = []*File{}
+	}
+	if c.Unstaged == nil {
+		c.Unstaged = []*File{}
+	}
+	return c, nil
+}
+
+func untrackedFiles(dir string) ([]string, error) {
+	out, err := gitOutput(dir, "ls-files", "--others", "--exclude-standard", "-z")
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, f := range strings.Split(out, "\x00") {
+		if f != "" {
+			files = append(files, f)
+		}
+	}
+	return files, nil
+}
+
+// untrackedDiff diffs an untracked file against /dev/null.
+func untrackedDiff(dir, path string) (*File, error) {
+	cmd := exec.Command("git", diffArgs("--no-index", "--", "/dev/null", path)...)
+	cmd.Dir = dir
+	// --no-index exits 1 when the files differ, which they always do here.
+	out, _ := cmd.Output()
+	files, err := Parse(string(out))
+	if err != nil {
+		return nil, err
+	}
+	f := &File{NewPath: path, Hunks: []Hunk{}}
+	if len(files) > 0 {
+		f = files[0]
+	}
+	f.OldPath, f.NewPath = "", path
+	f.Status = StatusUntracked
+	return f, nil
+}
+
+// Apply stages, unstages or discards a change in the worktree at dir. path
+// names the file (its new path for renames). If hunk is negative the whole
+// file is acted on; otherwise only that hunk, whose header must still equal
+// header. Staging and discarding act on the unstaged changes, unstaging on
+// the staged ones.
+func Apply(dir, action, path string, hunk int, header string) error {
+	c, err := Load(dir)
+	if err != nil {
+		return err
+	}
+	files := c.Unstaged
+	if action == ActionUnstage {
+		files = c.Staged
+	}
+	var f *File
+	for _, cand := range files {
+		if cand.Path() == path {
+			f = cand
+			break
+		}
+	}
+	if f == nil {
+		return ErrNotFound
+	}
+	if hunk >= 0 {
+		if hunk >= len(f.Hunks) {
+			return ErrNotFound
+		}
+		if f.Hunks[hunk].Header != header {
+			return ErrStale
+		}
+	}
+
+	// Untracked and binary files, and whole-file requests, are handled per
+	// path rather than by applying a patch.
+	if hunk < 0 || f.Status == StatusUntracked || f.Binary {
+		return applyFile(dir, action, f)
+	}
+	args := []string{"apply", "--whitespace=nowarn"}
+	switch action {
+	case ActionStage:
+		args = append(args, "--cached")
+	case ActionUnstage:
+		args = append(args, "--cached", "--reverse")
+	case ActionDiscard:
+		args = append(args, "--reverse")
+	default:
+		return fmt.Errorf("unknown action %q", action)
+	}
+	cmd := exec.Command("git", append(args, "-")...)
+	cmd.Dir = dir
+	cmd.Stdin = strings.NewReader(f.Patch(hunk))
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("git apply: %s", strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+func applyFile(dir, action string, f *File) error {
+	paths := []string{f.Path()}
+	if f.OldPath != "" && f.OldPath != f.NewPath && f.Status != StatusCopied {
+		paths = append(paths, f.OldPath)
+	}
+	var args []string
+	switch action {
+	case ActionStage:
+		args = append([]string{"add", "-A", "--"}, paths...)
+	case ActionUnstage:
+		args = append([]string{"reset", "-q", "HEAD", "--"}, paths...)
+	case ActionDiscard:
+		if f.Status == StatusUntracked {
+			return removeUntracked(dir, f.NewPath)
+		}
+		args = append([]string{"checkout", "--"}, paths...)
+	default:
+		return fmt.Errorf("unknown action %q", action)
+	}
+	_, err := gitOutput(dir, args...)
+	return err
+}
+
+// removeUntracked deletes an untracked file, refusing paths that leave dir.
+func removeUntracked(dir, path string) error {
+	full := filepath.Join(dir, path)
+	rel, err := filepath.Rel(dir, full)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return fmt.Errorf("refusing to remove %s", path)
+	}
+	return os.Remove(full)
+}
+
+// Commit commits the staged changes of the worktree at dir with message and
+// returns the new commit's abbreviated hash.
+func Commit(dir, message string) (string, error) {
+	if strings.TrimSpace(message) == "" {
+		return "", errors.New("commit message is required")
+	}
+	cmd := exec.Command("git", "commit", "-q", "-F", "-")
+	cmd.Dir = dir
+	cmd.Stdin = strings.NewReader(message)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return "", fmt.Errorf("git commit: %s", strings.TrimSpace(string(out)))
+	}
+	out, err := gitOutput(dir, "rev-parse", "--short", "HEAD")
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(out), nil
+}
+
+// HasStaged reports whether the worktree at dir has staged changes.
+func HasStaged(dir string) (bool, error) {
+	cmd := exec.Command("git", "diff", "--cached", "--quiet")
+	cmd.Dir = dir
+	err := cmd.Run()
+	var ee *exec.ExitError
+	if errors.As(err, &ee) && ee.ExitCode() == 1 {
+		return true, nil
+	}
+	return false, err
+}
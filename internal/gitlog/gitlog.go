@@ -0,0 +1,191 @@
+// Package gitlog lists the commits of a worktree and loads a single commit's
+// message and parsed diff.
+package gitlog
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/zsprackett/agent-workspace/internal/git"
+	"github.com/zsprackett/agent-workspace/internal/gitdiff"
+)
+
+// ErrNotFound is returned by Show when the revision is not a commit.
+var ErrNotFound = errors.New("commit not found")
+
+// logFormat is the git log --pretty format parsed by ParseLine. The subject
+// comes last so tabs in it survive the split.
+const logFormat = "%H\t%h\t%an\t%aI\t%ar\t%s"
+
+// Commit is one entry of a commit log.
+type Commit struct {
+	Hash    string    `json:"hash"`
+	Short   string    `json:"short"`
+	Author  string    `json:"author"`
+	Date    time.Time `json:"date"`
+	RelDate string    `json:"rel_date"`
+	Subject string    `json:"subject"`
+}
+
+// ParseLine parses a single line of git log output in logFormat.
+func ParseLine(line string) (Commit, bool) {
+	parts := strings.SplitN(line, "\t", 6)
+	if len(parts) != 6 || parts[0] == "" {
+		return Commit{}, false
+	}
+	date, _ := time.Parse(time.RFC3339, parts[3])
+	return Commit{
+		Hash:    parts[0],
+		Short:   parts[1],
+		Author:  parts[2],
+		Date:    date,
+		RelDate: parts[4],
+		Subject: parts[5],
+	}, true
+}
+
+// Options select a page of the log. Base, if set, limits the log to commits
+// not reachable from it. Limit 0 means no limit.
+type Options struct {
+	Base  string
+	Skip  int
+	Limit int
+}
+
+// Log returns the commits of HEAD in the worktree at dir, newest first.
+func Log(dir string, opts Options) ([]Commit, error) {
+	args := []string{"log", "--pretty=format:" + logFormat}
+	if opts.Skip > 0 {
+		args = append(args, "--skip="+strconv.Itoa(opts.Skip))
+	}
+	if opts.Limit > 0 {
+		args = append(args, "-n", strconv.Itoa(opts.Limit))
+	}
+	args = append(args, "--end-of-options")
+	if opts.Base != "" {
+		args = append(args, opts.Base+"..HEAD")
+	} else {
+		args = append(args, "HEAD")
+	}
+	out, err := run(dir, args...)
+	if err != nil {
+		return nil, err
+	}
+	commits := []Commit{}
+	for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+		if c, ok := ParseLine(line); ok {
+			commits = append(commits, c)
+		}
+	}
+	return commits, nil
+}
+
+// DefaultBase returns the ref the history of the worktree at dir is limited
+// to by default: the upstream its branch was created from, else the first of
+// candidates that exists, else the repo's default branch. Remote-tracking
+// refs are preferred since local base branches may be stale. It returns ""
+// when there is no base or HEAD is on the base branch itself.
+func DefaultBase(dir string, candidates ...string) string {
+	branch, _ := run(dir, "rev-parse", "--abbrev-ref", "HEAD")
+	branch = strings.TrimSpace(branch)
+	// New session branches track origin/<base>; once pushed they track
+	// origin/<branch>, which says nothing about the base.
+	if up, err := run(dir, "rev-parse", "--abbrev-ref", "@{upstream}"); err == nil {
+		if up = strings.TrimSpace(up); up != "" && up != "origin/"+branch {
+			return up
+		}
+	}
+	if repo, err := git.CommonDir(dir); err == nil {
+		if b, err := git.GetDefaultBranch(repo); err == nil {
+			candidates = append(candidates, b)
+		}
+	}
+	for _, b := range candidates {
+		if b == "" {
+			continue
+		}
+		if b == branch {
+			return ""
+		}
+		for _, ref := range []string{"origin/" + b, b} {
+			if git.RefExists(dir, ref) {
+				return ref
+			}
+		}
+	}
+	return ""
+}
+
+// Detail is a commit with its full message and parsed diff against its
+// first parent.
+type Detail struct {
+	Commit
+	Parents []string        `json:"parents"`
+	Message string          `json:"message"`
+	Files   []*gitdiff.File `json:"files"`
+}
+
+// Message returns the full message of rev.
+func Message(dir, rev string) (string, error) {
+	out, err := run(dir, "log", "-1", "--pretty=format:%B", "--end-of-options", rev)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(out), nil
+}
+
+// Show returns the commit rev of the worktree at dir with its message and
+// diff. Merge commits are diffed against their first parent.
+func Show(dir, rev string) (*Detail, error) {
+	if rev == "" || strings.HasPrefix(rev, "-") {
+		return nil, ErrNotFound
+	}
+	out, err := run(dir, "show", "-s", "--pretty=format:"+logFormat+"%x00%P%x00%B",
+		"--end-of-options", rev+"^{commit}")
+	if err != nil {
+		return nil, ErrNotFound
+	}
+	parts := strings.SplitN(out, "\x00", 3)
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("unexpected git show output for %s", rev)
+	}
+	c, ok := ParseLine(parts[0])
+	if !ok {
+		return nil, fmt.Errorf("unexpected git show output for %s", rev)
+	}
+	d := &Detail{
+		Commit:  c,
+		Parents: strings.Fields(parts[1]),
+		Message: strings.TrimSpace(parts[2]),
+	}
+	diff, err := run(dir, "-c", "core.quotepath=off", "show", "--pretty=format:", "--no-color",
+		"--no-ext-diff", "-M", "--diff-merges=first-parent", c.Hash)
+	if err != nil {
+		return nil, err
+	}
+	if d.Files, err = gitdiff.Parse(diff); err != nil {
+		return nil, err
+	}
+	if d.Files == nil {
+		d.Files = []*gitdiff.File{}
+	}
+	return d, nil
+}
+
+func run(dir string, args ...string) (string, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	out, err := cmd.Output()
+	if err != nil {
+		var ee *exec.ExitError
+		if errors.As(err, &ee) {
+			return "", fmt.Errorf("git: %s", strings.TrimSpace(string(ee.Stderr)))
+		}
+		return "", err
+	}
+	return string(out), nil
+}
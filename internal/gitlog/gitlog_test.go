@@ -0,0 +1,98 @@
+package gitlog_test
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/zsprackett/agent-workspace/internal/gitdiff"
+	"github.com/zsprackett/agent-workspace/internal/gitlog"
+)
+
+func TestParseLine(t *testing.T) {
+	c, ok := gitlog.ParseLine("abc1234def\tabc1234\tAda\t2024-05-01T10:00:00+02:00\t2 hours ago\tFix auth\tbug")
+	if !ok {
+		t.Fatal("expected ok=true")
+	}
+	if c.Hash != "abc1234def" || c.Short != "abc1234" || c.Author != "Ada" {
+		t.Errorf("hash/author: %+v", c)
+	}
+	if c.Subject != "Fix auth\tbug" {
+		t.Errorf("subject: got %q want %q", c.Subject, "Fix auth\tbug")
+	}
+	if c.RelDate != "2 hours ago" {
+		t.Errorf("reldate: got %q want %q", c.RelDate, "2 hours ago")
+	}
+	if c.Date.IsZero() {
+		t.Error("expected date to be parsed")
+	}
+
+	if _, ok := gitlog.ParseLine("malformed no tabs"); ok {
+		t.Error("expected ok=false for malformed line")
+	}
+	if _, ok := gitlog.ParseLine(""); ok {
+		t.Error("expected ok=false for empty line")
+	}
+}
+
+func git(t *testing.T, dir string, args ...string) {
+	t.Helper()
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GIT_AUTHOR_NAME=test", "GIT_AUTHOR_EMAIL=t@t.com",
+		"GIT_COMMITTER_NAME=test", "GIT_COMMITTER_EMAIL=t@t.com")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("git %v: %v\n%s", args, err, out)
+	}
+}
+
+func TestLogAndShow(t *testing.T) {
+	dir := t.TempDir()
+	git(t, dir, "init", "-b", "main")
+	commit := func(file, content, msg string) {
+		os.WriteFile(filepath.Join(dir, file), []byte(content), 0644)
+		git(t, dir, "add", ".")
+		git(t, dir, "commit", "-m", msg)
+	}
+	commit("a.txt", "a\n", "init")
+	git(t, dir, "checkout", "-q", "-b", "feature")
+	commit("a.txt", "a\nb\n", "Add b\n\nLonger description.")
+	commit("c.txt", "c\n", "Add c")
+
+	all, err := gitlog.Log(dir, gitlog.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 3 || all[0].Subject != "Add c" || all[2].Subject != "init" {
+		t.Fatalf("full log: %+v", all)
+	}
+	base := gitlog.DefaultBase(dir)
+	if base != "main" {
+		t.Fatalf("default base: got %q want main", base)
+	}
+	since, _ := gitlog.Log(dir, gitlog.Options{Base: base})
+	if len(since) != 2 {
+		t.Errorf("expected 2 commits since main, got %d", len(since))
+	}
+	page, _ := gitlog.Log(dir, gitlog.Options{Skip: 1, Limit: 1})
+	if len(page) != 1 || page[0].Subject != "Add b" {
+		t.Errorf("page: %+v", page)
+	}
+
+	d, err := gitlog.Show(dir, page[0].Short)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Hash != page[0].Hash || d.Message != "Add b\n\nLonger description." || len(d.Parents) != 1 {
+		t.Errorf("detail: %+v", d)
+	}
+	if len(d.Files) != 1 || d.Files[0].Path() != "a.txt" || d.Files[0].Status != gitdiff.StatusModified || d.Files[0].Added != 1 {
+		t.Errorf("files: %+v", d.Files)
+	}
+	for _, rev := range []string{"nope", "--all", ""} {
+		if _, err := gitlog.Show(dir, rev); err != gitlog.ErrNotFound {
+			t.Errorf("Show(%q): expected ErrNotFound, got %v", rev, err)
+		}
+	}
+}
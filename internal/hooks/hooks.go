@@ -0,0 +1,171 @@
+// Package hooks connects Claude Code's lifecycle hooks to agent-workspace.
+// Install writes a hooks configuration into a session's worktree that runs
+// "agent-workspace hook" on each event; that subcommand sends a Report over
+// a Unix socket to the running agent-workspace, which learns the session's
+// exact state instead of inferring it from the pane.
+package hooks
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zsprackett/agent-workspace/internal/db"
+)
+
+// Claude Code hook events agent-workspace listens to.
+const (
+	SessionStart     = "SessionStart"
+	UserPromptSubmit = "UserPromptSubmit"
+	PreToolUse       = "PreToolUse"
+	PostToolUse      = "PostToolUse"
+	Notification     = "Notification" // asking permission, or idle at the prompt
+	Stop             = "Stop"         // finished responding
+	SessionEnd       = "SessionEnd"
+)
+
+// Events lists the hook events Install registers.
+var Events = []string{SessionStart, UserPromptSubmit, PreToolUse, PostToolUse, Notification, Stop, SessionEnd}
+
+// sendTimeout bounds how long a hook may hold up Claude when agent-workspace
+// is slow or gone.
+const sendTimeout = time.Second
+
+// maxDetail is the longest tool detail or message kept in a Report.
+const maxDetail = 200
+
+// Report is what a hook tells agent-workspace about a session.
+type Report struct {
+	Session        string `json:"session"` // agent-workspace session ID
+	Event          string `json:"event"`
+	Tool           string `json:"tool,omitempty"`   // for tool events, the tool name
+	Detail         string `json:"detail,omitempty"` // the command, file or notification message
+	TranscriptPath string `json:"transcript_path,omitempty"`
+}
+
+// Status returns the session status the event implies. ok is false for
+// SessionEnd: Claude has exited and the status must be inferred again.
+func (r Report) Status() (status db.SessionStatus, ok bool) {
+	switch r.Event {
+	case SessionStart:
+		return db.StatusIdle, true
+	case UserPromptSubmit, PreToolUse, PostToolUse:
+		return db.StatusRunning, true
+	case Notification, Stop:
+		return db.StatusWaiting, true
+	}
+	return "", false
+}
+
+// hookInput is the part of the JSON Claude Code passes a hook on stdin that
+// agent-workspace uses.
+type hookInput struct {
+	HookEventName  string         `json:"hook_event_name"`
+	TranscriptPath string         `json:"transcript_path"`
+	ToolName       string         `json:"tool_name"`
+	ToolInput      map[string]any `json:"tool_input"`
+	Message        string         `json:"message"`
+}
+
+// ParseInput builds the report for a hook event from the JSON Claude Code
+// passed on stdin. Input that cannot be parsed still yields a report of the
+// event.
+func ParseInput(sessionID, event string, input []byte) Report {
+	r := Report{Session: sessionID, Event: event}
+	var in hookInput
+	if json.Unmarshal(input, &in) != nil {
+		return r
+	}
+	if in.HookEventName != "" {
+		r.Event = in.HookEventName
+	}
+	r.TranscriptPath = in.TranscriptPath
+	r.Tool = in.ToolName
+	r.Detail = in.Message
+	for _, key := range []string{"command", "file_path", "pattern", "url", "description"} {
+		if v, ok := in.ToolInput[key].(string); ok && v != "" {
+			r.Detail = v
+			break
+		}
+	}
+	r.Detail = strings.TrimSpace(r.Detail)
+	if len(r.Detail) > maxDetail {
+		r.Detail = strings.ToValidUTF8(r.Detail[:maxDetail], "") + "…"
+	}
+	return r
+}
+
+// Send delivers r to the agent-workspace listening on socketPath.
+func Send(socketPath string, r Report) error {
+	conn, err := net.DialTimeout("unix", socketPath, sendTimeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(sendTimeout))
+	return json.NewEncoder(conn).Encode(r)
+}
+
+// Listener receives reports on a Unix socket.
+type Listener struct {
+	ln   net.Listener
+	path string
+	wg   sync.WaitGroup
+}
+
+// Listen accepts reports on socketPath and calls handle with each, in the
+// order each connection sends them. It fails if another agent-workspace is
+// already listening there; a stale socket is replaced.
+func Listen(socketPath string, handle func(Report)) (*Listener, error) {
+	if conn, err := net.DialTimeout("unix", socketPath, sendTimeout); err == nil {
+		conn.Close()
+		return nil, fmt.Errorf("%s is in use by another agent-workspace", socketPath)
+	}
+	if err := os.Remove(socketPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	ln, err := net.Listen("unix", socketPath)
+	if err != nil {
+		return nil, err
+	}
+	os.Chmod(socketPath, 0o600)
+	l := &Listener{ln: ln, path: socketPath}
+	l.wg.Add(1)
+	go func() {
+		defer l.wg.Done()
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			l.wg.Add(1)
+			go func() {
+				defer l.wg.Done()
+				defer conn.Close()
+				conn.SetReadDeadline(time.Now().Add(sendTimeout))
+				sc := bufio.NewScanner(conn)
+				for sc.Scan() {
+					var r Report
+					if json.Unmarshal(sc.Bytes(), &r) == nil && r.Session != "" {
+						handle(r)
+					}
+				}
+			}()
+		}
+	}()
+	return l, nil
+}
+
+// Close stops listening, waits for open connections and removes the socket.
+func (l *Listener) Close() error {
+	err := l.ln.Close()
+	l.wg.Wait()
+	os.Remove(l.path)
+	return err
+}
@@ -0,0 +1,140 @@
+package hooks_test
+
+import (
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zsprackett/agent-workspace/internal/db"
+	"github.com/zsprackett/agent-workspace/internal/hooks"
+)
+
+func TestParseInput(t *testing.T) {
+	input := `{"hook_event_name":"PreToolUse","transcript_path":"/tmp/t.jsonl","tool_name":"Bash","tool_input":{"command":"go test ./...","description":"Run tests"}}`
+	r := hooks.ParseInput("s1", "ignored", []byte(input))
+	want := hooks.Report{Session: "s1", Event: hooks.PreToolUse, Tool: "Bash", Detail: "go test ./...", TranscriptPath: "/tmp/t.jsonl"}
+	if r != want {
+		t.Errorf("got %+v, want %+v", r, want)
+	}
+
+	r = hooks.ParseInput("s1", hooks.Notification, []byte(`{"message":"Claude needs your permission to use Bash"}`))
+	if r.Event != hooks.Notification || r.Detail != "Claude needs your permission to use Bash" {
+		t.Errorf("notification: got %+v", r)
+	}
+
+	r = hooks.ParseInput("s1", hooks.Stop, []byte("not json"))
+	if r != (hooks.Report{Session: "s1", Event: hooks.Stop}) {
+		t.Errorf("bad input: got %+v", r)
+	}
+
+	long := strings.Repeat("x", 500)
+	r = hooks.ParseInput("s1", hooks.PreToolUse, []byte(`{"tool_input":{"command":"`+long+`"}}`))
+	if len(r.Detail) > 210 || !strings.HasSuffix(r.Detail, "…") {
+		t.Errorf("long detail not truncated: %d bytes", len(r.Detail))
+	}
+}
+
+func TestReportStatus(t *testing.T) {
+	cases := map[string]db.SessionStatus{
+		hooks.SessionStart:     db.StatusIdle,
+		hooks.UserPromptSubmit: db.StatusRunning,
+		hooks.PreToolUse:       db.StatusRunning,
+		hooks.PostToolUse:      db.StatusRunning,
+		hooks.Notification:     db.StatusWaiting,
+		hooks.Stop:             db.StatusWaiting,
+	}
+	for event, want := range cases {
+		got, ok := hooks.Report{Event: event}.Status()
+		if !ok || got != want {
+			t.Errorf("%s: got %q, %v; want %q", event, got, ok, want)
+		}
+	}
+	if _, ok := (hooks.Report{Event: hooks.SessionEnd}).Status(); ok {
+		t.Error("SessionEnd should not imply a status")
+	}
+}
+
+func TestListenSend(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "hooks.sock")
+	got := make(chan hooks.Report, 1)
+	l, err := hooks.Listen(sock, func(r hooks.Report) { got <- r })
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	if _, err := hooks.Listen(sock, func(hooks.Report) {}); err == nil {
+		t.Error("expected a second listener on the same socket to fail")
+	}
+
+	want := hooks.Report{Session: "s1", Event: hooks.Stop}
+	if err := hooks.Send(sock, want); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case r := <-got:
+		if r != want {
+			t.Errorf("got %+v, want %+v", r, want)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("report not received")
+	}
+}
+
+func TestInstall(t *testing.T) {
+	dir := t.TempDir()
+	if err := exec.Command("git", "init", "-q", dir).Run(); err != nil {
+		t.Skip("git not available")
+	}
+	settings := filepath.Join(dir, ".claude", "settings.local.json")
+	os.MkdirAll(filepath.Dir(settings), 0o755)
+	os.WriteFile(settings, []byte(`{"model":"opus","hooks":{"Stop":[{"hooks":[{"type":"command","command":"say done"}]}]}}`), 0o644)
+
+	// Installing twice must not duplicate the hooks.
+	for range 2 {
+		if err := hooks.Install(dir, "s1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var got struct {
+		Model string `json:"model"`
+		Hooks map[string][]struct {
+			Matcher string `json:"matcher"`
+			Hooks   []struct {
+				Command string `json:"command"`
+			} `json:"hooks"`
+		} `json:"hooks"`
+	}
+	data, _ := os.ReadFile(settings)
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Model != "opus" {
+		t.Errorf("other settings lost: %s", data)
+	}
+	for _, event := range hooks.Events {
+		want := 1
+		if event == hooks.Stop {
+			want = 2 // the user's hook is kept
+		}
+		if len(got.Hooks[event]) != want {
+			t.Errorf("%s: got %d hook groups, want %d", event, len(got.Hooks[event]), want)
+		}
+	}
+	if m := got.Hooks[hooks.PreToolUse][0].Matcher; m != "*" {
+		t.Errorf("PreToolUse matcher = %q, want *", m)
+	}
+	if cmd := got.Hooks[hooks.Stop][1].Hooks[0].Command; !strings.HasSuffix(cmd, " hook --session 's1' Stop") {
+		t.Errorf("Stop command = %q", cmd)
+	}
+
+	exclude, _ := os.ReadFile(filepath.Join(dir, ".git", "info", "exclude"))
+	if strings.Count(string(exclude), "/.claude/settings.local.json") != 1 {
+		t.Errorf("settings file not excluded once from git:\n%s", exclude)
+	}
+}
@@ -0,0 +1,123 @@
+package hooks
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/zsprackett/agent-workspace/internal/git"
+)
+
+// settingsFile is where Claude Code reads settings local to a project and
+// not meant to be committed.
+const settingsFile = ".claude/settings.local.json"
+
+// Command returns the shell command a hook runs to report event for the
+// session.
+func Command(exe, sessionID, event string) string {
+	return shellQuote(exe) + " hook --session " + shellQuote(sessionID) + " " + event
+}
+
+// Install adds hooks that report every event in Events for the session to
+// the Claude Code settings of dir, the directory Claude runs in, keeping
+// any other settings and hooks already there. The hooks run this
+// executable. When dir is in a git repository the settings file is excluded
+// from git so the worktree does not look dirty.
+func Install(dir, sessionID string) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(dir, settingsFile)
+	settings := map[string]any{}
+	data, err := os.ReadFile(path)
+	if err == nil {
+		if err := json.Unmarshal(data, &settings); err != nil {
+			return err
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	hooks, _ := settings["hooks"].(map[string]any)
+	if hooks == nil {
+		hooks = map[string]any{}
+	}
+	for _, event := range Events {
+		// Drop agent-workspace hooks from an earlier install, then add ours.
+		var groups []any
+		existing, _ := hooks[event].([]any)
+		for _, g := range existing {
+			if !isOurs(g) {
+				groups = append(groups, g)
+			}
+		}
+		group := map[string]any{
+			"hooks": []any{map[string]any{"type": "command", "command": Command(exe, sessionID, event)}},
+		}
+		if event == PreToolUse || event == PostToolUse {
+			group["matcher"] = "*"
+		}
+		hooks[event] = append(groups, group)
+	}
+	settings["hooks"] = hooks
+
+	out, err := json.MarshalIndent(settings, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, append(out, '\n'), 0o644); err != nil {
+		return err
+	}
+	return exclude(dir)
+}
+
+// isOurs reports whether a hook group from a settings file runs
+// "agent-workspace hook".
+func isOurs(group any) bool {
+	g, _ := group.(map[string]any)
+	list, _ := g["hooks"].([]any)
+	for _, h := range list {
+		hm, _ := h.(map[string]any)
+		if cmd, _ := hm["command"].(string); strings.Contains(cmd, " hook --session ") {
+			return true
+		}
+	}
+	return false
+}
+
+// exclude adds the settings file to the info/exclude of the repository dir
+// belongs to, if any.
+func exclude(dir string) error {
+	common, err := git.CommonDir(dir)
+	if err != nil {
+		return nil // not a git repository
+	}
+	path := filepath.Join(common, "info", "exclude")
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if strings.TrimSpace(line) == "/"+settingsFile {
+			return nil
+		}
+	}
+	if len(data) > 0 && !strings.HasSuffix(string(data), "\n") {
+		data = append(data, '\n')
+	}
+	data = append(data, "/"+settingsFile+"\n"...)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o644)
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
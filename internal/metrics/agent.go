@@ -0,0 +1,41 @@
+package metrics
+
+import "github.com/zsprackett/agent-workspace/internal/events"
+
+// The counters and histograms agent-workspace keeps. Gauges of current
+// state are read when metrics are scraped.
+var (
+	StatusTransitions = NewCounterVec("agent_workspace_status_transitions_total",
+		"Session status changes, by previous and new status.", "from", "to")
+	Notifications = NewCounterVec("agent_workspace_notifications_total",
+		"Notifications by channel (system, webhook, ntfy, event_webhook) and result (sent, failed).", "channel", "result")
+	FetchFailures = NewCounterVec("agent_workspace_syncer_fetch_failures_total",
+		"Failed background fetches of bare repositories, by repository.", "repo")
+	FetchDuration = NewHistogram("agent_workspace_syncer_fetch_duration_seconds",
+		"How long background fetches of bare repositories take.",
+		0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120)
+	MonitorTick = NewHistogram("agent_workspace_monitor_tick_duration_seconds",
+		"How long each pass of the status monitor over all sessions takes.",
+		0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5)
+)
+
+func init() {
+	Default.Register(StatusTransitions, Notifications, FetchFailures, FetchDuration, MonitorTick)
+}
+
+// Result returns the result label of a notification: "failed" if err is
+// set, otherwise "sent".
+func Result(err error) string {
+	if err != nil {
+		return "failed"
+	}
+	return "sent"
+}
+
+// HandleEvent counts status changes. Subscribe it to the event bus as a
+// durable subscriber.
+func HandleEvent(e events.Event) {
+	if e.Type == events.StatusChanged {
+		StatusTransitions.Inc(string(e.From), string(e.Status))
+	}
+}
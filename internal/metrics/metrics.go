@@ -0,0 +1,233 @@
+// Package metrics keeps counters and histograms in memory and writes them,
+// with gauges read at scrape time, in the Prometheus text exposition
+// format.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Metric is a family of samples that can be written in the text format.
+type Metric interface {
+	Name() string
+	Write(w io.Writer)
+}
+
+// Registry is a set of metrics written together.
+type Registry struct {
+	mu      sync.Mutex
+	metrics []Metric
+}
+
+// Default holds the metrics agent-workspace itself declares.
+var Default = &Registry{}
+
+// Register adds metrics to r.
+func (r *Registry) Register(ms ...Metric) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.metrics = append(r.metrics, ms...)
+}
+
+// Write writes the metrics of r and extra in the text format, by name.
+func (r *Registry) Write(w io.Writer, extra ...Metric) {
+	r.mu.Lock()
+	all := append(append([]Metric(nil), r.metrics...), extra...)
+	r.mu.Unlock()
+	sort.SliceStable(all, func(i, j int) bool { return all[i].Name() < all[j].Name() })
+	for _, m := range all {
+		m.Write(w)
+	}
+}
+
+// ContentType is the media type of the text format.
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+type desc struct {
+	name   string
+	help   string
+	labels []string
+}
+
+func (d desc) Name() string { return d.name }
+
+func (d desc) header(w io.Writer, typ string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", d.name, strings.ReplaceAll(d.help, "\n", " "), d.name, typ)
+}
+
+// labelString formats label pairs as {a="x",b="y"}, or "" if there are
+// none.
+func labelString(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	pairs := make([]string, len(names))
+	for i, n := range names {
+		v := ""
+		if i < len(values) {
+			v = values[i]
+		}
+		pairs[i] = n + `="` + labelEscaper.Replace(v) + `"`
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// key joins label values into a map key.
+func key(values []string) string {
+	return strings.Join(values, "\xff")
+}
+
+// vec holds one float per combination of label values.
+type vec struct {
+	desc
+	mu     sync.Mutex
+	values map[string]float64
+	keys   map[string][]string
+}
+
+func newVec(name, help string, labels []string) vec {
+	return vec{desc: desc{name, help, labels}, values: map[string]float64{}, keys: map[string][]string{}}
+}
+
+func (v *vec) add(delta float64, values []string) {
+	k := key(values)
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if _, ok := v.keys[k]; !ok {
+		v.keys[k] = append([]string(nil), values...)
+	}
+	v.values[k] += delta
+}
+
+func (v *vec) set(value float64, values []string) {
+	k := key(values)
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.keys[k] = append([]string(nil), values...)
+	v.values[k] = value
+}
+
+func (v *vec) write(w io.Writer, typ string) {
+	v.header(w, typ)
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	keys := make([]string, 0, len(v.values))
+	for k := range v.values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(w, "%s%s %s\n", v.name, labelString(v.labels, v.keys[k]), formatValue(v.values[k]))
+	}
+}
+
+// CounterVec is a counter per combination of label values.
+type CounterVec struct{ vec }
+
+// NewCounterVec returns a counter with the given label names. Its name
+// should end in _total.
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+	return &CounterVec{newVec(name, help, labels)}
+}
+
+// Inc adds one to the counter with the given label values.
+func (c *CounterVec) Inc(values ...string) { c.add(1, values) }
+
+// Add adds delta, which must not be negative, to the counter with the given
+// label values.
+func (c *CounterVec) Add(delta float64, values ...string) { c.add(delta, values) }
+
+// Value returns the count with the given label values.
+func (c *CounterVec) Value(values ...string) float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.values[key(values)]
+}
+
+func (c *CounterVec) Write(w io.Writer) { c.write(w, "counter") }
+
+// GaugeVec is a gauge per combination of label values, usually filled in
+// when metrics are scraped.
+type GaugeVec struct{ vec }
+
+func NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	return &GaugeVec{newVec(name, help, labels)}
+}
+
+// Set sets the gauge with the given label values.
+func (g *GaugeVec) Set(value float64, values ...string) { g.set(value, values) }
+
+// Add adds delta to the gauge with the given label values.
+func (g *GaugeVec) Add(delta float64, values ...string) { g.add(delta, values) }
+
+func (g *GaugeVec) Write(w io.Writer) { g.write(w, "gauge") }
+
+// Histogram counts observations, such as durations in seconds, into
+// cumulative buckets.
+type Histogram struct {
+	desc
+	buckets []float64 // upper bounds, ascending
+
+	mu     sync.Mutex
+	counts []uint64 // per bucket, not cumulative; the last is +Inf
+	sum    float64
+	count  uint64
+}
+
+// NewHistogram returns a histogram with the given bucket upper bounds.
+func NewHistogram(name, help string, buckets ...float64) *Histogram {
+	b := append([]float64(nil), buckets...)
+	sort.Float64s(b)
+	return &Histogram{desc: desc{name: name, help: help}, buckets: b, counts: make([]uint64, len(b)+1)}
+}
+
+// Observe records v.
+func (h *Histogram) Observe(v float64) {
+	i := sort.SearchFloat64s(h.buckets, v)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.counts[i]++
+	h.sum += v
+	h.count++
+}
+
+// Count returns how many values were observed.
+func (h *Histogram) Count() uint64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.count
+}
+
+func (h *Histogram) Write(w io.Writer) {
+	h.header(w, "histogram")
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var cum uint64
+	for i, n := range h.counts {
+		le := math.Inf(1)
+		if i < len(h.buckets) {
+			le = h.buckets[i]
+		}
+		cum += n
+		fmt.Fprintf(w, "%s_bucket{le=\"%s\"} %d\n", h.name, formatValue(le), cum)
+	}
+	fmt.Fprintf(w, "%s_sum %s\n%s_count %d\n", h.name, formatValue(h.sum), h.name, h.count)
+}
@@ -0,0 +1,54 @@
+package metrics_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/zsprackett/agent-workspace/internal/db"
+	"github.com/zsprackett/agent-workspace/internal/events"
+	"github.com/zsprackett/agent-workspace/internal/metrics"
+)
+
+func TestWrite(t *testing.T) {
+	r := &metrics.Registry{}
+	c := metrics.NewCounterVec("test_requests_total", "Requests.", "path")
+	c.Inc(`/a"b`)
+	c.Add(2, "/")
+	h := metrics.NewHistogram("test_duration_seconds", "Durations.", 1, 0.1)
+	h.Observe(0.05)
+	h.Observe(0.5)
+	h.Observe(5)
+	r.Register(h, c)
+	g := metrics.NewGaugeVec("test_up", "Up.")
+	g.Set(1)
+
+	var b strings.Builder
+	r.Write(&b, g)
+	want := `# HELP test_duration_seconds Durations.
+# TYPE test_duration_seconds histogram
+test_duration_seconds_bucket{le="0.1"} 1
+test_duration_seconds_bucket{le="1"} 2
+test_duration_seconds_bucket{le="+Inf"} 3
+test_duration_seconds_sum 5.55
+test_duration_seconds_count 3
+# HELP test_requests_total Requests.
+# TYPE test_requests_total counter
+test_requests_total{path="/"} 2
+test_requests_total{path="/a\"b"} 1
+# HELP test_up Up.
+# TYPE test_up gauge
+test_up 1
+`
+	if b.String() != want {
+		t.Errorf("got:\n%s\nwant:\n%s", b.String(), want)
+	}
+}
+
+func TestHandleEvent(t *testing.T) {
+	before := metrics.StatusTransitions.Value("running", "waiting")
+	metrics.HandleEvent(events.Event{Type: events.StatusChanged, From: db.StatusRunning, Status: db.StatusWaiting})
+	metrics.HandleEvent(events.Event{Type: events.SessionUpdated, Status: db.StatusWaiting})
+	if got := metrics.StatusTransitions.Value("running", "waiting"); got != before+1 {
+		t.Errorf("expected one transition counted, got %v", got-before)
+	}
+}
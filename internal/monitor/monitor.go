@@ -6,38 +6,44 @@ import (
 	"sync"
 	"time"
 
+	"github.com/zsprackett/agent-workspace/internal/checkpoint"
 	"github.com/zsprackett/agent-workspace/internal/db"
 	"github.com/zsprackett/agent-workspace/internal/events"
-	"github.com/zsprackett/agent-workspace/internal/notify"
+	"github.com/zsprackett/agent-workspace/internal/hooks"
+	"github.com/zsprackett/agent-workspace/internal/metrics"
+	"github.com/zsprackett/agent-workspace/internal/session"
 	"github.com/zsprackett/agent-workspace/internal/tmux"
 )
 
-type OnUpdate func()
-
+// Monitor polls tmux for the status of every session and publishes a
+// StatusChanged event for each change it commits.
 type Monitor struct {
 	db            *db.DB
-	onUpdate      OnUpdate
-	notifier      *notify.Notifier
 	broadcaster   events.Broadcaster
-	prevStatus    map[string]db.SessionStatus
 	pendingStatus map[string]db.SessionStatus
 	interval      time.Duration
 	stop          chan struct{}
 	wg            sync.WaitGroup
 	logger        *slog.Logger
+
+	cpMu          sync.Mutex
+	checkpointing map[string]bool
+
+	// Status last reported by Claude Code hooks, by session ID.
+	hookMu     sync.Mutex
+	hookStatus map[string]db.SessionStatus
 }
 
-func New(store *db.DB, onUpdate OnUpdate, notifier *notify.Notifier, broadcaster events.Broadcaster, logger *slog.Logger) *Monitor {
+func New(store *db.DB, broadcaster events.Broadcaster, logger *slog.Logger) *Monitor {
 	return &Monitor{
 		db:            store,
-		onUpdate:      onUpdate,
-		notifier:      notifier,
 		broadcaster:   broadcaster,
-		prevStatus:    make(map[string]db.SessionStatus),
 		pendingStatus: make(map[string]db.SessionStatus),
 		interval:      500 * time.Millisecond,
 		stop:          make(chan struct{}),
 		logger:        logger,
+		checkpointing: make(map[string]bool),
+		hookStatus:    make(map[string]db.SessionStatus),
 	}
 }
 
@@ -52,7 +58,9 @@ func (m *Monitor) Start() {
 			case <-m.stop:
 				return
 			case <-ticker.C:
+				start := time.Now()
 				m.refresh()
+				metrics.MonitorTick.Observe(time.Since(start).Seconds())
 			}
 		}
 	}()
@@ -84,39 +92,29 @@ func (m *Monitor) refresh() {
 			continue
 		}
 		if !tmux.SessionExists(s.TmuxSession, tmuxSessions) {
+			m.forgetHook(s.ID)
 			m.db.WriteStatus(s.ID, db.StatusStopped, s.Tool)
 			changed = true
+			if s.Status != db.StatusStopped {
+				detail, _ := json.Marshal(map[string]string{"from": string(s.Status), "to": string(db.StatusStopped)})
+				m.db.InsertSessionEvent(s.ID, "status_changed", string(detail))
+				m.broadcastStatus(s, db.StatusStopped)
+			}
 			continue
 		}
 
-		output, err := tmux.CapturePane(s.TmuxSession, tmux.CaptureOptions{
-			StartLine: -100,
-			Join:      true,
-		})
-		if err != nil {
-			continue
-		}
-
-		status := tmux.ParseToolStatus(output, string(s.Tool))
-		isActive := tmux.IsSessionActive(s.TmuxSession, tmuxSessions, 2)
-		isWaitingForInput := tmux.IsPaneWaitingForInput(s.TmuxSession)
-
-		var newStatus db.SessionStatus
-		switch {
-		case status.IsWaiting || isWaitingForInput:
-			newStatus = db.StatusWaiting
-		case status.IsBusy || isActive:
-			newStatus = db.StatusRunning
-		case status.HasError:
-			newStatus = db.StatusError
-		default:
-			newStatus = db.StatusIdle
+		newStatus, fromHook := m.hookReported(s.ID)
+		if !fromHook {
+			var ok bool
+			if newStatus, ok = scrapeStatus(s, tmuxSessions); !ok {
+				continue
+			}
 		}
 
 		if newStatus != s.Status {
-			if m.pendingStatus[s.ID] == newStatus {
-				// Stable for 2 consecutive ticks - commit the change.
-				prev := m.prevStatus[s.ID]
+			// Hook reports are exact; a scraped status must be stable for 2
+			// consecutive ticks before it is committed.
+			if fromHook || m.pendingStatus[s.ID] == newStatus {
 				m.db.WriteStatus(s.ID, newStatus, s.Tool)
 				m.logger.Debug("monitor: status changed",
 					"session", s.Title,
@@ -126,17 +124,10 @@ func (m *Monitor) refresh() {
 				changed = true
 				detail, _ := json.Marshal(map[string]string{"from": string(s.Status), "to": string(newStatus)})
 				m.db.InsertSessionEvent(s.ID, "status_changed", string(detail))
-				m.broadcast(events.Event{
-					Type:      "status_changed",
-					SessionID: s.ID,
-					Status:    newStatus,
-					Title:     s.Title,
-				})
-				if newStatus == db.StatusWaiting && prev != db.StatusWaiting {
-					s.Status = newStatus
-					m.notifier.Notify(*s)
+				m.broadcastStatus(s, newStatus)
+				if s.Status == db.StatusRunning && (newStatus == db.StatusWaiting || newStatus == db.StatusIdle) {
+					m.checkpoint(s)
 				}
-				m.prevStatus[s.ID] = newStatus
 				delete(m.pendingStatus, s.ID)
 			} else {
 				// First sighting of this new status - wait for confirmation.
@@ -145,20 +136,146 @@ func (m *Monitor) refresh() {
 		} else {
 			// Status is stable - clear any pending candidate.
 			delete(m.pendingStatus, s.ID)
-			m.prevStatus[s.ID] = s.Status
 		}
 	}
 
 	if changed {
 		m.db.Touch()
-		if m.onUpdate != nil {
-			m.onUpdate()
+	}
+}
+
+// scrapeStatus infers the status of s from its pane text and whether the
+// pane reads from the terminal. ok is false if the pane cannot be read.
+func scrapeStatus(s *db.Session, tmuxSessions []tmux.SessionInfo) (db.SessionStatus, bool) {
+	output, err := tmux.CapturePane(s.TmuxSession, tmux.CaptureOptions{
+		StartLine: -100,
+		Join:      true,
+	})
+	if err != nil {
+		return "", false
+	}
+
+	status := tmux.ParseToolStatus(output, string(s.Tool))
+	isActive := tmux.IsSessionActive(s.TmuxSession, tmuxSessions, 2)
+	isWaitingForInput := tmux.IsPaneWaitingForInput(s.TmuxSession)
+
+	switch {
+	case status.IsWaiting || isWaitingForInput:
+		return db.StatusWaiting, true
+	case status.IsBusy || isActive:
+		return db.StatusRunning, true
+	case status.HasError:
+		return db.StatusError, true
+	default:
+		return db.StatusIdle, true
+	}
+}
+
+// ReportHook records the state a Claude Code hook reported for a session.
+// Until Claude exits, the monitor uses it instead of reading the pane. Tool
+// calls are published as ToolUse events.
+func (m *Monitor) ReportHook(r hooks.Report) {
+	m.hookMu.Lock()
+	if status, ok := r.Status(); ok {
+		m.hookStatus[r.Session] = status
+	} else {
+		delete(m.hookStatus, r.Session)
+	}
+	m.hookMu.Unlock()
+	m.logger.Debug("monitor: hook", "session", r.Session, "event", r.Event, "tool", r.Tool)
+	if r.Event == hooks.PreToolUse && m.broadcaster != nil {
+		m.broadcaster.Broadcast(events.Event{Type: events.ToolUse, SessionID: r.Session, Tool: r.Tool, Message: r.Detail})
+	}
+}
+
+// hookReported returns the status hooks last reported for the session, if
+// any.
+func (m *Monitor) hookReported(id string) (db.SessionStatus, bool) {
+	m.hookMu.Lock()
+	defer m.hookMu.Unlock()
+	status, ok := m.hookStatus[id]
+	return status, ok
+}
+
+func (m *Monitor) forgetHook(id string) {
+	m.hookMu.Lock()
+	defer m.hookMu.Unlock()
+	delete(m.hookStatus, id)
+}
+
+// checkpoint snapshots the worktrees of s in the background if its group has
+// checkpoints enabled. A snapshot still in progress for s is not overlapped.
+func (m *Monitor) checkpoint(s *db.Session) {
+	groups, err := m.db.LoadGroups()
+	if err != nil {
+		return
+	}
+	enabled := false
+	for _, g := range groups {
+		if g.Path == s.GroupPath {
+			enabled = g.Checkpoints
 		}
 	}
+	if !enabled {
+		return
+	}
+	var dirs []string
+	for _, wt := range session.Worktrees(m.db, s) {
+		dirs = append(dirs, wt.WorktreePath)
+	}
+	if len(dirs) == 0 && s.ProjectPath != "" {
+		dirs = []string{s.ProjectPath}
+	}
+	if len(dirs) == 0 {
+		return
+	}
+
+	m.cpMu.Lock()
+	if m.checkpointing[s.ID] {
+		m.cpMu.Unlock()
+		return
+	}
+	m.checkpointing[s.ID] = true
+	m.cpMu.Unlock()
+
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		defer func() {
+			m.cpMu.Lock()
+			delete(m.checkpointing, s.ID)
+			m.cpMu.Unlock()
+		}()
+		for _, dir := range dirs {
+			cp, err := checkpoint.Create(dir, s.ID, "")
+			if err != nil {
+				m.logger.Warn("monitor: checkpoint failed", "session", s.Title, "dir", dir, "err", err)
+				continue
+			}
+			if cp == nil {
+				continue
+			}
+			m.logger.Debug("monitor: checkpoint created", "session", s.Title, "dir", dir, "n", cp.N)
+			detail, _ := json.Marshal(map[string]any{"dir": dir, "n": cp.N, "hash": cp.Hash})
+			m.db.InsertSessionEvent(s.ID, "checkpoint", string(detail))
+		}
+	}()
 }
 
-func (m *Monitor) broadcast(e events.Event) {
-	if m.broadcaster != nil {
-		m.broadcaster.Broadcast(e)
+// broadcastStatus publishes the change of s to status, with a copy of s in
+// its new state.
+func (m *Monitor) broadcastStatus(s *db.Session, status db.SessionStatus) {
+	if m.broadcaster == nil {
+		return
 	}
+	sess := *s
+	sess.Status = status
+	m.broadcaster.Broadcast(events.Event{
+		Type:      events.StatusChanged,
+		SessionID: s.ID,
+		Status:    status,
+		From:      s.Status,
+		Title:     s.Title,
+		Session:   &sess,
+	})
 }
@@ -8,8 +8,8 @@ import (
 
 	"github.com/zsprackett/agent-workspace/internal/db"
 	"github.com/zsprackett/agent-workspace/internal/events"
+	"github.com/zsprackett/agent-workspace/internal/hooks"
 	"github.com/zsprackett/agent-workspace/internal/monitor"
-	"github.com/zsprackett/agent-workspace/internal/notify"
 )
 
 func discardLogger() *slog.Logger {
@@ -42,9 +42,8 @@ func TestMonitorSkipsCreatingSession(t *testing.T) {
 	}
 	store.SaveSession(s)
 
-	updateCalled := false
-	notifier := notify.New(notify.Config{}, discardLogger())
-	mon := monitor.New(store, func() { updateCalled = true }, notifier, nil, discardLogger())
+	broadcaster := &captureBroadcaster{}
+	mon := monitor.New(store, broadcaster, discardLogger())
 
 	mon.Start()
 	time.Sleep(600 * time.Millisecond) // one tick
@@ -54,21 +53,39 @@ func TestMonitorSkipsCreatingSession(t *testing.T) {
 	if got.Status != db.StatusCreating {
 		t.Errorf("expected StatusCreating, got %q", got.Status)
 	}
-	if updateCalled {
-		t.Error("expected no update callback for creating session")
+	if len(broadcaster.events) != 0 {
+		t.Errorf("expected no events for creating session, got %+v", broadcaster.events)
 	}
 }
 
 func TestMonitorAcceptsBroadcaster(t *testing.T) {
 	broadcaster := &captureBroadcaster{}
-	notifier := notify.New(notify.Config{}, discardLogger())
 
 	store, _ := db.Open(":memory:")
 	store.Migrate()
 	defer store.Close()
 
-	mon := monitor.New(store, func() {}, notifier, broadcaster, discardLogger())
+	mon := monitor.New(store, broadcaster, discardLogger())
 	if mon == nil {
 		t.Fatal("expected non-nil monitor")
 	}
 }
+
+func TestReportHookPublishesToolUse(t *testing.T) {
+	store, _ := db.Open(":memory:")
+	store.Migrate()
+	defer store.Close()
+
+	broadcaster := &captureBroadcaster{}
+	mon := monitor.New(store, broadcaster, discardLogger())
+	mon.ReportHook(hooks.Report{Session: "s1", Event: hooks.PreToolUse, Tool: "Bash", Detail: "make"})
+	mon.ReportHook(hooks.Report{Session: "s1", Event: hooks.Stop})
+
+	if len(broadcaster.events) != 1 {
+		t.Fatalf("expected 1 event, got %+v", broadcaster.events)
+	}
+	e := broadcaster.events[0]
+	if e.Type != events.ToolUse || e.SessionID != "s1" || e.Tool != "Bash" || e.Message != "make" {
+		t.Errorf("unexpected event %+v", e)
+	}
+}
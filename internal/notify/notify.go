@@ -10,6 +10,8 @@ import (
 	"time"
 
 	"github.com/zsprackett/agent-workspace/internal/db"
+	"github.com/zsprackett/agent-workspace/internal/events"
+	"github.com/zsprackett/agent-workspace/internal/metrics"
 )
 
 // Config holds notification settings.
@@ -48,12 +50,46 @@ func (n *Notifier) Notify(s db.Session) {
 	}
 }
 
+// Alert sends a notification that is not about one session, such as usage
+// nearing its limit, through every configured channel.
+func (n *Notifier) Alert(title, msg string) {
+	if !n.cfg.Enabled {
+		return
+	}
+
+	n.sendSystemNotification(title + ": " + msg)
+
+	if n.cfg.Webhook != "" {
+		n.post("webhook", n.cfg.Webhook, alertPayload{
+			Alert:     title,
+			Message:   msg,
+			Timestamp: time.Now().UTC().Format(time.RFC3339),
+		})
+	}
+	if n.cfg.NtfyURL != "" {
+		n.post("ntfy", n.cfg.NtfyURL, ntfyPayload{
+			Title:    title,
+			Message:  msg,
+			Priority: 4,
+			Tags:     []string{"warning"},
+		})
+	}
+}
+
+// HandleEvent notifies when an event reports a session entering the waiting
+// state. Subscribe it to the event bus.
+func (n *Notifier) HandleEvent(e events.Event) {
+	if e.Type == events.StatusChanged && e.Status == db.StatusWaiting && e.From != db.StatusWaiting && e.Session != nil {
+		n.Notify(*e.Session)
+	}
+}
+
 func (n *Notifier) sendSystemNotification(msg string) {
 	script := fmt.Sprintf(
 		`display notification %q with title "agent-workspace"`,
 		msg,
 	)
-	exec.Command("osascript", "-e", script).Run()
+	metrics.Notifications.Inc("system", metrics.Result(exec.Command("osascript", "-e", script).Run()))
 }
 
 type webhookPayload struct {
@@ -78,6 +114,7 @@ func (n *Notifier) sendWebhook(s db.Session) {
 	}
 	client := &http.Client{Timeout: 5 * time.Second}
 	resp, err := client.Post(n.cfg.Webhook, "application/json", bytes.NewReader(data))
+	metrics.Notifications.Inc("webhook", metrics.Result(err))
 	if err != nil {
 		n.logger.Warn("notify: webhook POST failed", "err", err)
 		return
@@ -85,6 +122,28 @@ func (n *Notifier) sendWebhook(s db.Session) {
 	resp.Body.Close()
 }
 
+type alertPayload struct {
+	Alert     string `json:"alert"`
+	Message   string `json:"message"`
+	Timestamp string `json:"timestamp"`
+}
+
+// post sends v as JSON to url, logging and counting failures under channel.
+func (n *Notifier) post(channel, url string, v any) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return
+	}
+	client := &http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Post(url, "application/json", bytes.NewReader(data))
+	metrics.Notifications.Inc(channel, metrics.Result(err))
+	if err != nil {
+		n.logger.Warn("notify: POST failed", "url", url, "err", err)
+		return
+	}
+	resp.Body.Close()
+}
+
 type ntfyPayload struct {
 	Title    string   `json:"title"`
 	Message  string   `json:"message"`
@@ -105,6 +164,7 @@ func (n *Notifier) sendNtfy(s db.Session) {
 	}
 	client := &http.Client{Timeout: 5 * time.Second}
 	resp, err := client.Post(n.cfg.NtfyURL, "application/json", bytes.NewReader(data))
+	metrics.Notifications.Inc("ntfy", metrics.Result(err))
 	if err != nil {
 		return
 	}
@@ -10,6 +10,7 @@ import (
 	"testing"
 
 	"github.com/zsprackett/agent-workspace/internal/db"
+	"github.com/zsprackett/agent-workspace/internal/events"
 	"github.com/zsprackett/agent-workspace/internal/notify"
 )
 
@@ -64,3 +65,66 @@ func TestNotify_DisabledNoOp(t *testing.T) {
 	// Must not panic.
 	n.Notify(db.Session{Title: "test", Tool: "claude"})
 }
+
+func TestHandleEvent_NotifiesOnWaiting(t *testing.T) {
+	posts := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		posts++
+	}))
+	defer srv.Close()
+	n := notify.New(notify.Config{Enabled: true, NtfyURL: srv.URL}, discardLogger())
+
+	sess := &db.Session{Title: "swift-fox", Tool: db.ToolClaude, Status: db.StatusWaiting}
+	n.HandleEvent(events.Event{Type: events.StatusChanged, Status: db.StatusRunning, From: db.StatusIdle, Session: sess})
+	n.HandleEvent(events.Event{Type: events.StatusChanged, Status: db.StatusWaiting, From: db.StatusRunning, Session: sess})
+	if posts != 1 {
+		t.Errorf("expected one notification, got %d", posts)
+	}
+}
+
+func TestEventWebhook(t *testing.T) {
+	var received []events.Event
+	fail := 1
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fail > 0 {
+			fail--
+			w.WriteHeader(502)
+			return
+		}
+		var e events.Event
+		json.NewDecoder(r.Body).Decode(&e)
+		received = append(received, e)
+	}))
+	defer srv.Close()
+
+	w := notify.NewEventWebhook(srv.URL, []string{events.SessionCreated}, discardLogger())
+	w.HandleEvent(events.Event{Type: events.Heartbeat})
+	w.HandleEvent(events.Event{Type: events.StatusChanged, SessionID: "s1"})
+	// The first POST fails and is retried.
+	w.HandleEvent(events.Event{Type: events.SessionCreated, SessionID: "s1"})
+	if len(received) != 1 || received[0].SessionID != "s1" || received[0].Type != events.SessionCreated {
+		t.Errorf("received %+v", received)
+	}
+}
+
+func TestAlert(t *testing.T) {
+	var webhook, ntfy map[string]any
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/ntfy" {
+			json.NewDecoder(r.Body).Decode(&ntfy)
+		} else {
+			json.NewDecoder(r.Body).Decode(&webhook)
+		}
+	}))
+	defer srv.Close()
+
+	n := notify.New(notify.Config{Enabled: true, Webhook: srv.URL + "/hook", NtfyURL: srv.URL + "/ntfy"}, discardLogger())
+	n.Alert("Usage budget", "five-hour window at 72%")
+
+	if webhook["alert"] != "Usage budget" || webhook["message"] != "five-hour window at 72%" {
+		t.Errorf("unexpected webhook payload %v", webhook)
+	}
+	if ntfy["title"] != "Usage budget" || ntfy["message"] != "five-hour window at 72%" {
+		t.Errorf("unexpected ntfy payload %v", ntfy)
+	}
+}
@@ -0,0 +1,87 @@
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/zsprackett/agent-workspace/internal/events"
+	"github.com/zsprackett/agent-workspace/internal/metrics"
+)
+
+const (
+	// eventWebhookAttempts is how many times an event is POSTed before it
+	// is given up on.
+	eventWebhookAttempts = 3
+	// eventWebhookBackoff is the wait before the first retry; it grows
+	// with each attempt.
+	eventWebhookBackoff = time.Second
+)
+
+// EventWebhook POSTs events from the bus to a URL, one JSON event per
+// request, in the order they were published.
+type EventWebhook struct {
+	url    string
+	types  map[string]bool
+	client *http.Client
+	logger *slog.Logger
+}
+
+// NewEventWebhook returns a dispatcher that POSTs events of the given types
+// to url, or every event but heartbeats if types is empty.
+func NewEventWebhook(url string, types []string, logger *slog.Logger) *EventWebhook {
+	w := &EventWebhook{
+		url:    url,
+		client: &http.Client{Timeout: 5 * time.Second},
+		logger: logger,
+	}
+	if len(types) > 0 {
+		w.types = make(map[string]bool, len(types))
+		for _, t := range types {
+			w.types[t] = true
+		}
+	}
+	return w
+}
+
+// HandleEvent POSTs e if its type is wanted, retrying with backoff on
+// failure. Subscribe it to the event bus as a best-effort subscriber: each
+// failure holds up the queue for seconds, and a down endpoint must not grow
+// it without bound.
+func (w *EventWebhook) HandleEvent(e events.Event) {
+	if e.Type == events.Heartbeat || w.types != nil && !w.types[e.Type] {
+		return
+	}
+	data, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+	for attempt := 1; ; attempt++ {
+		err = w.post(data)
+		if err == nil {
+			metrics.Notifications.Inc("event_webhook", "sent")
+			return
+		}
+		if attempt == eventWebhookAttempts {
+			metrics.Notifications.Inc("event_webhook", "failed")
+			w.logger.Warn("notify: event webhook failed", "type", e.Type, "session", e.SessionID, "err", err)
+			return
+		}
+		time.Sleep(eventWebhookBackoff * time.Duration(attempt))
+	}
+}
+
+func (w *EventWebhook) post(data []byte) error {
+	resp, err := w.client.Post(w.url, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("status %s", resp.Status)
+	}
+	return nil
+}
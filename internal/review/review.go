@@ -0,0 +1,88 @@
+// Package review turns a session's open review comments into a single prompt
+// and delivers it to the session's agent.
+package review
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/zsprackett/agent-workspace/internal/db"
+	"github.com/zsprackett/agent-workspace/internal/tmux"
+)
+
+var (
+	// ErrNoComments is returned by Send when the session has no open comments.
+	ErrNoComments = errors.New("no open review comments")
+	// ErrNotRunning is returned by Send when the session's tmux session is gone.
+	ErrNotRunning = errors.New("session is not running")
+)
+
+// Format renders comments as a prompt asking the agent to address them.
+func Format(comments []*db.ReviewComment) string {
+	var sb strings.Builder
+	sb.WriteString("Please address the following review comments:\n")
+	for i, c := range comments {
+		loc := fmt.Sprintf("%s:%d", c.File, c.LineStart)
+		if c.LineEnd > c.LineStart {
+			loc += fmt.Sprintf("-%d", c.LineEnd)
+		}
+		if c.Repo != "" {
+			loc = c.Repo + "/" + loc
+		}
+		if c.Commit != "" {
+			loc += fmt.Sprintf(" (in commit %.7s)", c.Commit)
+		}
+		fmt.Fprintf(&sb, "\n%d. %s\n", i+1, loc)
+		for _, line := range strings.Split(strings.TrimSpace(c.Body), "\n") {
+			sb.WriteString("   " + line + "\n")
+		}
+	}
+	return sb.String()
+}
+
+// Send types the session's open comments into its tmux session as one
+// prompt and submits it. The comments are marked sent before they are typed,
+// so concurrent sends never type them twice, and reopened if typing fails.
+// It returns the number of comments sent.
+func Send(store *db.DB, s *db.Session) (int, error) {
+	open, err := store.GetReviewComments(s.ID, false)
+	if err != nil {
+		return 0, err
+	}
+	if len(open) == 0 {
+		return 0, ErrNoComments
+	}
+	sessions, _ := tmux.ListSessions()
+	if s.TmuxSession == "" || !tmux.SessionExists(s.TmuxSession, sessions) {
+		return 0, ErrNotRunning
+	}
+	// Another send may have claimed them since.
+	comments, err := store.ClaimReviewComments(s.ID)
+	if err != nil {
+		return 0, err
+	}
+	if len(comments) == 0 {
+		return 0, ErrNoComments
+	}
+	ids := make([]int64, len(comments))
+	for i, c := range comments {
+		ids[i] = c.ID
+	}
+	if err := deliver(s.TmuxSession, Format(comments)); err != nil {
+		_ = store.UnmarkReviewCommentsSent(ids)
+		return 0, fmt.Errorf("send review: %w", err)
+	}
+	_ = store.InsertSessionEvent(s.ID, "review_sent", fmt.Sprintf("%d comments", len(comments)))
+	return len(comments), nil
+}
+
+func deliver(target, text string) error {
+	if err := tmux.SendText(target, text); err != nil {
+		return err
+	}
+	// Give the agent's input a moment to take the text before submitting.
+	time.Sleep(200 * time.Millisecond)
+	return tmux.SendEnter(target)
+}
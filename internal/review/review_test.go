@@ -0,0 +1,39 @@
+package review_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/zsprackett/agent-workspace/internal/db"
+	"github.com/zsprackett/agent-workspace/internal/review"
+)
+
+func TestFormat(t *testing.T) {
+	got := review.Format([]*db.ReviewComment{
+		{File: "main.go", LineStart: 12, LineEnd: 14, Body: "Handle the error.\nDon't ignore it."},
+		{Repo: "api", File: "db.go", LineStart: 3, LineEnd: 3, Commit: "abcdef1234567", Body: "  Typo  "},
+	})
+	want := `Please address the following review comments:
+
+1. main.go:12-14
+   Handle the error.
+   Don't ignore it.
+
+2. api/db.go:3 (in commit abcdef1)
+   Typo
+`
+	if got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestSendWithoutComments(t *testing.T) {
+	store, _ := db.Open(":memory:")
+	store.Migrate()
+	defer store.Close()
+	s := &db.Session{ID: "s1", Title: "s1", CreatedAt: time.Now(), LastAccessed: time.Now()}
+	store.SaveSession(s)
+	if _, err := review.Send(store, s); err != review.ErrNoComments {
+		t.Errorf("expected ErrNoComments, got %v", err)
+	}
+}
@@ -0,0 +1,169 @@
+package session
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/zsprackett/agent-workspace/internal/db"
+	"github.com/zsprackett/agent-workspace/internal/git"
+	"github.com/zsprackett/agent-workspace/internal/tmux"
+)
+
+// ForkOptions describes how to fork an existing session.
+type ForkOptions struct {
+	ReposDir     string
+	WorktreesDir string
+	// Branch is the branch to create for the fork. Derived from the title
+	// when empty.
+	Branch string
+	// CopyChanges carries the parent's uncommitted changes, including
+	// untracked files, over to the fork.
+	CopyChanges bool
+}
+
+// PlanFork plans worktrees for a fork of parent titled title. Each of the
+// parent's repos gets a new branch starting at the commit the parent's
+// worktree currently has checked out. The parent's worktrees are not touched.
+func PlanFork(store *db.DB, parent *db.Session, opts ForkOptions, title string) (*WorktreePlan, error) {
+	wts := Worktrees(store, parent)
+	if len(wts) == 0 {
+		return nil, errors.New("only sessions with a worktree can be forked")
+	}
+	urls := make([]string, len(wts))
+	for i, wt := range wts {
+		if wt.RepoURL == "" {
+			return nil, fmt.Errorf("worktree %s has no repo URL", wt.WorktreePath)
+		}
+		urls[i] = wt.RepoURL
+	}
+	plan, err := PlanWorktree(WorktreeOptions{
+		RepoURL:       urls[0],
+		ExtraRepoURLs: urls[1:],
+		ReposDir:      opts.ReposDir,
+		WorktreesDir:  opts.WorktreesDir,
+		Branch:        opts.Branch,
+	}, title)
+	if err != nil {
+		return nil, err
+	}
+	for i, wt := range wts {
+		if git.BranchExists(wt.RepoPath, plan.Branch) {
+			return nil, fmt.Errorf("branch %q already exists in %s", plan.Branch, wt.RepoURL)
+		}
+		plan.Repos[i].RepoPath = wt.RepoPath
+		plan.Repos[i].ForkFrom = wt.WorktreePath
+	}
+	plan.RepoPath = plan.Repos[0].RepoPath
+	plan.copyChanges = opts.CopyChanges
+	return plan, nil
+}
+
+// DefaultForkTitle returns "<parent title>-fork", numbered if a fork branch
+// of that name already exists in the parent's repo.
+func DefaultForkTitle(parent *db.Session) string {
+	base := parent.Title + "-fork"
+	title := base
+	for n := 2; parent.WorktreeRepo != "" && git.BranchExists(parent.WorktreeRepo, git.SanitizeBranchName(title)); n++ {
+		title = fmt.Sprintf("%s-%d", base, n)
+	}
+	return title
+}
+
+// ForkCreateOptions returns the options for a session forked from parent: it
+// keeps the parent's group, tool, command, tmux environment and notes and
+// records the parent.
+func ForkCreateOptions(parent *db.Session, title string) CreateOptions {
+	var env map[string]string
+	if parent.TmuxSession != "" {
+		env, _ = tmux.SessionEnvironment(parent.TmuxSession)
+	}
+	return CreateOptions{
+		Title:           title,
+		GroupPath:       parent.GroupPath,
+		Tool:            parent.Tool,
+		Command:         parent.Command,
+		Env:             env,
+		Notes:           parent.Notes,
+		ParentSessionID: parent.ID,
+		RepoURL:         parent.RepoURL,
+	}
+}
+
+// forkRepo creates r's worktree at the HEAD of the parent worktree and, if
+// requested, copies the parent's uncommitted changes into it.
+func (p *WorktreePlan) forkRepo(r RepoWorktree) error {
+	head, err := git.HeadCommit(r.ForkFrom)
+	if err != nil {
+		return err
+	}
+	if _, err := git.CreateWorktree(r.RepoPath, p.Branch, r.WorktreePath, head); err != nil {
+		return err
+	}
+	if !p.copyChanges {
+		return nil
+	}
+	if err := copyChanges(r.ForkFrom, r.WorktreePath); err != nil {
+		_ = git.RemoveWorktree(r.RepoPath, r.WorktreePath, true)
+		return fmt.Errorf("copy changes: %w", err)
+	}
+	return nil
+}
+
+// copyChanges applies the uncommitted changes of the worktree at from to the
+// worktree at to: tracked changes via a stash commit (which leaves from
+// untouched), untracked files by copying them.
+func copyChanges(from, to string) error {
+	stash, err := git.StashCreate(from)
+	if err != nil {
+		return err
+	}
+	if stash != "" {
+		if err := git.StashApply(to, stash); err != nil {
+			return err
+		}
+	}
+	files, err := git.UntrackedFiles(from)
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		if err := copyFile(filepath.Join(from, f), filepath.Join(to, f)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func copyFile(src, dst string) error {
+	info, err := os.Lstat(src)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	if info.Mode()&os.ModeSymlink != 0 {
+		target, err := os.Readlink(src)
+		if err != nil {
+			return err
+		}
+		return os.Symlink(target, dst)
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
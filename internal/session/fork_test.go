@@ -0,0 +1,120 @@
+package session_test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zsprackett/agent-workspace/internal/db"
+	"github.com/zsprackett/agent-workspace/internal/session"
+	"github.com/zsprackett/agent-workspace/internal/tmux"
+)
+
+func TestForkSession(t *testing.T) {
+	store := newTestDB(t)
+	reposDir, worktreesDir := t.TempDir(), t.TempDir()
+	setupMasterRepo(t, reposDir)
+
+	plan, err := session.PlanWorktree(session.WorktreeOptions{
+		RepoURL: testRepoURL, ReposDir: reposDir, WorktreesDir: worktreesDir,
+	}, "midpoint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := plan.Provision(); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	parent := &db.Session{
+		ID: "parent", Title: "midpoint", GroupPath: "work", Tool: db.ToolClaude, Command: "claude",
+		Status: db.StatusIdle, CreatedAt: now, LastAccessed: now, Notes: "try both",
+		ProjectPath: plan.Root, WorktreePath: plan.WorktreePath, WorktreeRepo: plan.RepoPath,
+		WorktreeBranch: plan.Branch, RepoURL: testRepoURL,
+	}
+	store.SaveSession(parent)
+
+	// Commit on the parent's branch, then leave tracked and untracked changes.
+	wt := parent.WorktreePath
+	os.WriteFile(filepath.Join(wt, "step1.txt"), []byte("one\n"), 0644)
+	gitRun(t, wt, "add", ".")
+	gitRun(t, wt, "commit", "-m", "step 1")
+	os.WriteFile(filepath.Join(wt, "README.md"), []byte("edited\n"), 0644)
+	os.MkdirAll(filepath.Join(wt, "scratch"), 0755)
+	os.WriteFile(filepath.Join(wt, "scratch", "notes.txt"), []byte("wip\n"), 0644)
+	parentStatus := gitRun(t, wt, "status", "--porcelain")
+
+	if got := session.DefaultForkTitle(parent); got != "midpoint-fork" {
+		t.Errorf("default fork title: got %q", got)
+	}
+	fork, err := session.PlanFork(store, parent, session.ForkOptions{
+		ReposDir: reposDir, WorktreesDir: worktreesDir, CopyChanges: true,
+	}, "midpoint-fork")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fork.Provision(); err != nil {
+		t.Fatalf("provision fork: %v", err)
+	}
+
+	fwt := fork.WorktreePath
+	if got, want := gitRun(t, fwt, "rev-parse", "HEAD"), gitRun(t, wt, "rev-parse", "HEAD"); got != want {
+		t.Errorf("fork HEAD %s, want parent HEAD %s", got, want)
+	}
+	if got := gitRun(t, fwt, "rev-parse", "--abbrev-ref", "HEAD"); got != "midpoint-fork\n" {
+		t.Errorf("fork branch: got %q", got)
+	}
+	if b, _ := os.ReadFile(filepath.Join(fwt, "README.md")); string(b) != "edited\n" {
+		t.Errorf("tracked change not copied: %q", b)
+	}
+	if b, _ := os.ReadFile(filepath.Join(fwt, "scratch", "notes.txt")); string(b) != "wip\n" {
+		t.Errorf("untracked file not copied: %q", b)
+	}
+	if got := gitRun(t, wt, "status", "--porcelain"); got != parentStatus {
+		t.Errorf("parent worktree changed: got %q, want %q", got, parentStatus)
+	}
+	if got := gitRun(t, wt, "stash", "list"); strings.TrimSpace(got) != "" {
+		t.Errorf("fork left a stash entry: %q", got)
+	}
+
+	// The fork's branch now exists, so the next default title is numbered and
+	// reusing the branch is rejected.
+	if got := session.DefaultForkTitle(parent); got != "midpoint-fork-2" {
+		t.Errorf("second default fork title: got %q", got)
+	}
+	if _, err := session.PlanFork(store, parent, session.ForkOptions{
+		ReposDir: reposDir, WorktreesDir: worktreesDir,
+	}, "midpoint-fork"); err == nil {
+		t.Error("expected an existing branch to be rejected")
+	}
+
+	opts := session.ForkCreateOptions(parent, "midpoint-fork")
+	if opts.ParentSessionID != "parent" || opts.Tool != db.ToolClaude || opts.Notes != "try both" || opts.GroupPath != "work" {
+		t.Errorf("fork create options: %+v", opts)
+	}
+
+	plain := &db.Session{ID: "plain", Title: "plain", ProjectPath: t.TempDir()}
+	if _, err := session.PlanFork(store, plain, session.ForkOptions{}, "x"); err == nil {
+		t.Error("expected forking a session without a worktree to fail")
+	}
+}
+
+func TestForkCreateOptionsCopiesEnv(t *testing.T) {
+	if !tmux.IsAvailable() {
+		t.Skip("tmux not available")
+	}
+	name := fmt.Sprintf("agws-test-fork-env-%d", os.Getpid())
+	if err := tmux.CreateSession(tmux.CreateOptions{
+		Name: name, Command: "sleep 60", Cwd: t.TempDir(), Env: map[string]string{"FORK_TEST": "a=b"},
+	}); err != nil {
+		t.Skipf("cannot start tmux: %v", err)
+	}
+	defer tmux.KillSession(name)
+
+	opts := session.ForkCreateOptions(&db.Session{ID: "p", TmuxSession: name}, "f")
+	if opts.Env["FORK_TEST"] != "a=b" {
+		t.Errorf("expected the parent's environment copied, got %v", opts.Env)
+	}
+}
@@ -7,7 +7,7 @@ import (
 
 	"github.com/google/uuid"
 	"github.com/zsprackett/agent-workspace/internal/db"
-	"github.com/zsprackett/agent-workspace/internal/git"
+	"github.com/zsprackett/agent-workspace/internal/events"
 	"github.com/zsprackett/agent-workspace/internal/tmux"
 )
 
@@ -30,6 +30,9 @@ func GenerateTitle() string {
 }
 
 type CreateOptions struct {
+	// ID is the new session's ID; one is generated if it is empty. Set it
+	// to install hooks that report as the session before it starts.
+	ID             string
 	Title          string
 	ProjectPath    string
 	GroupPath      string
@@ -39,16 +42,48 @@ type CreateOptions struct {
 	WorktreeRepo   string
 	WorktreeBranch string
 	RepoURL        string
+	// Worktrees lists every worktree of a multi-repo session; see
+	// WorktreePlan.Worktrees.
+	Worktrees []*db.SessionWorktree
+	// ParentSessionID records the session this one was forked from.
+	ParentSessionID string
+	Notes           string
+	// Owner is the web user creating the session, if any.
+	Owner string
+	// Env is set in the session's tmux environment.
+	Env map[string]string
 }
 
 type Manager struct {
-	db *db.DB
+	db          *db.DB
+	broadcaster events.Broadcaster
 }
 
 func NewManager(store *db.DB) *Manager {
 	return &Manager{db: store}
 }
 
+// SetBroadcaster publishes every change the manager makes to a session to
+// b. Must be called before the manager is used.
+func (m *Manager) SetBroadcaster(b events.Broadcaster) {
+	m.broadcaster = b
+}
+
+func (m *Manager) broadcast(e events.Event) {
+	if m.broadcaster != nil {
+		m.broadcaster.Broadcast(e)
+	}
+}
+
+// touch marks the store changed and publishes the session's new state.
+func (m *Manager) touch(id string) error {
+	err := m.db.Touch()
+	if m.broadcaster != nil {
+		m.broadcast(events.SessionState(m.db, id))
+	}
+	return err
+}
+
 func (m *Manager) Create(opts CreateOptions) (*db.Session, error) {
 	title := opts.Title
 	if title == "" {
@@ -68,35 +103,53 @@ func (m *Manager) Create(opts CreateOptions) (*db.Session, error) {
 		Name:    tmuxName,
 		Command: command,
 		Cwd:     opts.ProjectPath,
+		Env:     opts.Env,
 	}); err != nil {
 		return nil, fmt.Errorf("create tmux session: %w", err)
 	}
 
+	id := opts.ID
+	if id == "" {
+		id = uuid.NewString()
+	}
 	sessions, _ := m.db.LoadSessions()
 	now := time.Now()
 	s := &db.Session{
-		ID:             uuid.NewString(),
-		Title:          title,
-		ProjectPath:    opts.ProjectPath,
-		GroupPath:      groupPath,
-		SortOrder:      len(sessions),
-		Command:        command,
-		Tool:           opts.Tool,
-		Status:         db.StatusRunning,
-		TmuxSession:    tmuxName,
-		CreatedAt:      now,
-		LastAccessed:   now,
-		WorktreePath:   opts.WorktreePath,
-		WorktreeRepo:   opts.WorktreeRepo,
-		WorktreeBranch: opts.WorktreeBranch,
-		RepoURL:        opts.RepoURL,
+		ID:              id,
+		Title:           title,
+		ProjectPath:     opts.ProjectPath,
+		GroupPath:       groupPath,
+		SortOrder:       len(sessions),
+		Command:         command,
+		Tool:            opts.Tool,
+		Status:          db.StatusRunning,
+		TmuxSession:     tmuxName,
+		CreatedAt:       now,
+		LastAccessed:    now,
+		WorktreePath:    opts.WorktreePath,
+		WorktreeRepo:    opts.WorktreeRepo,
+		WorktreeBranch:  opts.WorktreeBranch,
+		RepoURL:         opts.RepoURL,
+		ParentSessionID: opts.ParentSessionID,
+		Notes:           opts.Notes,
+		Owner:           opts.Owner,
 	}
 
 	if err := m.db.SaveSession(s); err != nil {
 		return nil, err
 	}
-	_ = m.db.InsertSessionEvent(s.ID, "created", "")
+	if len(opts.Worktrees) > 0 {
+		if err := m.db.SaveSessionWorktrees(s.ID, opts.Worktrees); err != nil {
+			return nil, err
+		}
+	}
+	if s.ParentSessionID != "" {
+		_ = m.db.InsertSessionEvent(s.ID, "forked", s.ParentSessionID)
+	} else {
+		_ = m.db.InsertSessionEvent(s.ID, "created", "")
+	}
 	m.db.Touch()
+	m.broadcast(events.Event{Type: events.SessionCreated, SessionID: s.ID, Session: s})
 	return s, nil
 }
 
@@ -115,7 +168,7 @@ func (m *Manager) Delete(id string) error {
 	if err := m.db.DeleteSession(id); err != nil {
 		return err
 	}
-	return m.db.Touch()
+	return m.touch(id)
 }
 
 func (m *Manager) Stop(id string) error {
@@ -130,7 +183,7 @@ func (m *Manager) Stop(id string) error {
 		return err
 	}
 	_ = m.db.InsertSessionEvent(id, "stopped", "")
-	return m.db.Touch()
+	return m.touch(id)
 }
 
 func (m *Manager) Restart(id string) error {
@@ -156,14 +209,14 @@ func (m *Manager) Restart(id string) error {
 		return err
 	}
 	_ = m.db.InsertSessionEvent(id, "restarted", "")
-	return m.db.Touch()
+	return m.touch(id)
 }
 
 func (m *Manager) Rename(id, title string) error {
 	if err := m.db.UpdateSessionField(id, "title", title); err != nil {
 		return err
 	}
-	return m.db.Touch()
+	return m.touch(id)
 }
 
 type UpdateOptions struct {
@@ -190,14 +243,14 @@ func (m *Manager) Update(id string, opts UpdateOptions) error {
 	if err := m.db.SaveSession(s); err != nil {
 		return err
 	}
-	return m.db.Touch()
+	return m.touch(id)
 }
 
 func (m *Manager) MoveToGroup(id, groupPath string) error {
 	if err := m.db.UpdateSessionField(id, "group_path", groupPath); err != nil {
 		return err
 	}
-	return m.db.Touch()
+	return m.touch(id)
 }
 
 func (m *Manager) Attach(id string) error {
@@ -224,8 +277,8 @@ func (m *Manager) Attach(id string) error {
 		return err
 	}
 	if s.WorktreePath != "" {
-		if dirty, err := git.IsWorktreeDirty(s.WorktreePath); err == nil {
-			m.db.UpdateSessionDirty(s.ID, dirty)
+		if dirty, changed, err := RefreshDirty(m.db, s); err == nil && changed {
+			m.broadcast(events.Event{Type: events.GitDirtyChanged, SessionID: id, Dirty: &dirty})
 		}
 	}
 	return nil
@@ -243,5 +296,5 @@ func (m *Manager) Acknowledge(id string) error {
 	if err := m.db.SetAcknowledged(id, true); err != nil {
 		return err
 	}
-	return m.db.Touch()
+	return m.touch(id)
 }
@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"github.com/zsprackett/agent-workspace/internal/db"
+	"github.com/zsprackett/agent-workspace/internal/events"
 	"github.com/zsprackett/agent-workspace/internal/session"
 )
 
@@ -113,3 +114,44 @@ func TestGenerateTitle(t *testing.T) {
 		t.Errorf("expected adjective-noun, got %q", title)
 	}
 }
+
+type captureBroadcaster struct {
+	events []events.Event
+}
+
+func (c *captureBroadcaster) Broadcast(e events.Event) {
+	c.events = append(c.events, e)
+}
+
+func TestManagerPublishesChanges(t *testing.T) {
+	store := newTestDB(t)
+	now := time.Now()
+	store.SaveSession(&db.Session{ID: "s1", Title: "calm-owl", GroupPath: "my-sessions",
+		Tool: db.ToolShell, Status: db.StatusIdle, CreatedAt: now, LastAccessed: now})
+
+	b := &captureBroadcaster{}
+	mgr := session.NewManager(store)
+	mgr.SetBroadcaster(b)
+	if err := mgr.MoveToGroup("s1", "work"); err != nil {
+		t.Fatal(err)
+	}
+	if err := mgr.Stop("s1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := mgr.Delete("s1"); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(b.events) != 3 {
+		t.Fatalf("events: %+v", b.events)
+	}
+	if e := b.events[0]; e.Type != events.SessionUpdated || e.Session.GroupPath != "work" {
+		t.Errorf("move: %+v", e)
+	}
+	if e := b.events[1]; e.Type != events.SessionUpdated || e.Session.Status != db.StatusStopped {
+		t.Errorf("stop: %+v", e)
+	}
+	if e := b.events[2]; e.Type != events.SessionDeleted || e.SessionID != "s1" {
+		t.Errorf("delete: %+v", e)
+	}
+}
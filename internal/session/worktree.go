@@ -0,0 +1,339 @@
+package session
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/zsprackett/agent-workspace/internal/checkpoint"
+	"github.com/zsprackett/agent-workspace/internal/db"
+	"github.com/zsprackett/agent-workspace/internal/git"
+)
+
+// WorktreeOptions describes the worktree a new session in a repo-backed group
+// should get.
+type WorktreeOptions struct {
+	RepoURL string
+	// ExtraRepoURLs makes the session multi-repo: each repo gets a worktree on
+	// the same branch, laid out side by side under a session directory.
+	ExtraRepoURLs []string
+	ReposDir      string
+	WorktreesDir  string
+	// Branch is the branch to create (or check out). Derived from the session
+	// title when empty.
+	Branch string
+	// Checkout checks out the existing remote branch origin/<Branch> instead of
+	// creating a new branch.
+	Checkout bool
+	// BaseBranch is the explicit base for a new branch. When empty the group's
+	// base branch is used, then the configured default, then the repo's
+	// detected default branch.
+	BaseBranch        string
+	GroupBaseBranch   string
+	DefaultBaseBranch string
+}
+
+// RepoWorktree is one repo's worktree within a plan.
+type RepoWorktree struct {
+	RepoURL      string
+	RepoPath     string
+	WorktreePath string
+	// ForkFrom is the parent session's worktree for this repo when the plan
+	// forks a session; the new branch starts at its HEAD. See PlanFork.
+	ForkFrom string
+}
+
+// WorktreePlan holds the resolved paths and branch for a session's worktrees.
+// RepoURL, RepoPath and WorktreePath describe the primary repo.
+type WorktreePlan struct {
+	RepoURL      string
+	RepoPath     string
+	WorktreePath string
+	Branch       string
+	// Root is the directory the tool starts in: the primary worktree for a
+	// single-repo session, or the session directory for a multi-repo one.
+	Root string
+	// Repos lists every repo's worktree, primary first.
+	Repos []RepoWorktree
+
+	opts        WorktreeOptions
+	copyChanges bool
+	created     []RepoWorktree
+}
+
+// PlanWorktree resolves the bare repo paths, branch name and worktree paths
+// for a session titled title. It does not touch the filesystem.
+func PlanWorktree(opts WorktreeOptions, title string) (*WorktreePlan, error) {
+	branch := opts.Branch
+	if branch == "" {
+		if opts.Checkout {
+			return nil, fmt.Errorf("a branch name is required to check out an existing branch")
+		}
+		branch = git.SanitizeBranchName(title)
+	}
+	if err := git.ValidateBranchName(branch); err != nil {
+		return nil, err
+	}
+	if opts.BaseBranch != "" {
+		if err := git.ValidateBranchName(opts.BaseBranch); err != nil {
+			return nil, fmt.Errorf("base branch: %w", err)
+		}
+	}
+	// Branch names may contain '/', which would nest the worktree directory.
+	dirName := git.SanitizeBranchName(branch)
+
+	urls := append([]string{opts.RepoURL}, opts.ExtraRepoURLs...)
+	multi := len(urls) > 1
+	plan := &WorktreePlan{Branch: branch, opts: opts}
+	if multi {
+		plan.Root = git.SessionRootPath(opts.WorktreesDir, dirName)
+	}
+	used := make(map[string]bool)
+	for _, u := range urls {
+		host, owner, repo, err := git.ParseRepoURL(u)
+		if err != nil {
+			return nil, fmt.Errorf("invalid repo URL: %w", err)
+		}
+		rw := RepoWorktree{RepoURL: u, RepoPath: git.BareRepoPath(opts.ReposDir, host, owner, repo)}
+		if multi {
+			name := repo
+			if used[name] {
+				name = owner + "-" + repo
+			}
+			if used[name] {
+				return nil, fmt.Errorf("repo %s is listed twice", u)
+			}
+			used[name] = true
+			rw.WorktreePath = filepath.Join(plan.Root, name)
+		} else {
+			rw.WorktreePath = git.WorktreePath(opts.WorktreesDir, host, owner, repo, dirName)
+		}
+		plan.Repos = append(plan.Repos, rw)
+	}
+	primary := plan.Repos[0]
+	plan.RepoURL, plan.RepoPath, plan.WorktreePath = primary.RepoURL, primary.RepoPath, primary.WorktreePath
+	if !multi {
+		plan.Root = primary.WorktreePath
+	}
+	return plan, nil
+}
+
+// MultiRepo reports whether the plan covers more than one repo.
+func (p *WorktreePlan) MultiRepo() bool {
+	return len(p.Repos) > 1
+}
+
+// Worktrees returns the rows to record with db.SaveSessionWorktrees for a
+// multi-repo session, or nil for a single-repo one.
+func (p *WorktreePlan) Worktrees() []*db.SessionWorktree {
+	if !p.MultiRepo() {
+		return nil
+	}
+	out := make([]*db.SessionWorktree, len(p.Repos))
+	for i, r := range p.Repos {
+		out[i] = &db.SessionWorktree{
+			RepoURL:      r.RepoURL,
+			RepoPath:     r.RepoPath,
+			WorktreePath: r.WorktreePath,
+			Branch:       p.Branch,
+		}
+	}
+	return out
+}
+
+// Provision clones or fetches each bare repo and creates its worktree. If any
+// worktree is already present it is left as is and git.ErrWorktreeExists
+// (unwrapped) is returned after the rest are created, so callers can offer to
+// reuse it or call Cleanup.
+func (p *WorktreePlan) Provision() error {
+	exists := false
+	for i, r := range p.Repos {
+		err := p.provisionRepo(r, i == 0)
+		if errors.Is(err, git.ErrWorktreeExists) {
+			exists = true
+			continue
+		}
+		if err != nil {
+			if p.MultiRepo() {
+				return fmt.Errorf("%s: %w", r.RepoURL, err)
+			}
+			return err
+		}
+		p.created = append(p.created, r)
+	}
+	if exists {
+		return git.ErrWorktreeExists
+	}
+	return nil
+}
+
+func (p *WorktreePlan) provisionRepo(r RepoWorktree, primary bool) error {
+	// 1. Ensure bare repo directory exists.
+	if err := os.MkdirAll(filepath.Dir(r.RepoPath), 0755); err != nil {
+		return fmt.Errorf("create repos dir failed: %w", err)
+	}
+
+	// 2. Clone or fetch the bare repo.
+	if !git.IsBareRepo(r.RepoPath) {
+		if err := git.CloneBare(r.RepoURL, r.RepoPath); err != nil {
+			return fmt.Errorf("clone failed: %w", err)
+		}
+	} else {
+		if err := git.FetchBare(r.RepoPath); err != nil {
+			return fmt.Errorf("fetch failed: %w", err)
+		}
+	}
+
+	// 3. Ensure worktree parent directory exists.
+	if err := os.MkdirAll(filepath.Dir(r.WorktreePath), 0755); err != nil {
+		return fmt.Errorf("create worktrees dir failed: %w", err)
+	}
+
+	if r.ForkFrom != "" {
+		return p.forkRepo(r)
+	}
+
+	// 4. Create the worktree. Secondary repos of a multi-repo session may not
+	// have the branch yet; they start it from their own base instead.
+	if p.opts.Checkout && (primary || git.RefExists(r.RepoPath, "origin/"+p.Branch)) {
+		_, err := git.CheckoutWorktree(r.RepoPath, p.Branch, r.WorktreePath)
+		return err
+	}
+	base, err := p.baseBranch(r, primary)
+	if err != nil {
+		return err
+	}
+	_, err = git.CreateWorktree(r.RepoPath, p.Branch, r.WorktreePath, base)
+	return err
+}
+
+// Cleanup force-removes the worktrees created by the last Provision call and,
+// for a multi-repo session, the session directory if nothing else is in it.
+func (p *WorktreePlan) Cleanup() {
+	for _, r := range p.created {
+		_ = git.RemoveWorktree(r.RepoPath, r.WorktreePath, true)
+	}
+	p.created = nil
+	if p.MultiRepo() {
+		os.Remove(p.Root)
+	}
+}
+
+// BaseBranch resolves the branch the primary worktree starts from: the
+// explicit base, the group's base, the configured default, and finally the
+// repo's detected default branch. An explicit or group base must exist; the
+// global configured default is skipped when the repo does not have it, so
+// repos whose default is master or develop still work.
+func (p *WorktreePlan) BaseBranch() (string, error) {
+	return p.baseBranch(p.Repos[0], true)
+}
+
+// baseBranch resolves the base for r. Secondary repos use the same order but
+// skip any base they do not have rather than failing.
+func (p *WorktreePlan) baseBranch(r RepoWorktree, strict bool) (string, error) {
+	for _, b := range []string{p.opts.BaseBranch, p.opts.GroupBaseBranch} {
+		if b == "" {
+			continue
+		}
+		if hasBranch(r.RepoPath, b) {
+			return b, nil
+		}
+		if strict {
+			return "", fmt.Errorf("base branch %q not found in %s", b, r.RepoURL)
+		}
+	}
+	if b := p.opts.DefaultBaseBranch; b != "" && hasBranch(r.RepoPath, b) {
+		return b, nil
+	}
+	base, err := git.GetDefaultBranch(r.RepoPath)
+	if err != nil {
+		return "", fmt.Errorf("detect default branch: %w", err)
+	}
+	return base, nil
+}
+
+func hasBranch(repoPath, b string) bool {
+	return git.RefExists(repoPath, "origin/"+b) || git.RefExists(repoPath, b)
+}
+
+// RemoteBranches lists the remote branches of repoURL's bare clone under
+// reposDir. It returns nil if the repo has not been cloned yet.
+func RemoteBranches(reposDir, repoURL string) ([]string, error) {
+	host, owner, repo, err := git.ParseRepoURL(repoURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid repo URL: %w", err)
+	}
+	repoPath := git.BareRepoPath(reposDir, host, owner, repo)
+	if !git.IsBareRepo(repoPath) {
+		return nil, nil
+	}
+	return git.ListRemoteBranches(repoPath)
+}
+
+// Worktrees returns every worktree owned by s: the recorded worktrees of a
+// multi-repo session, or its single worktree. Sessions without a worktree
+// return nil.
+func Worktrees(store *db.DB, s *db.Session) []*db.SessionWorktree {
+	if wts, err := store.GetSessionWorktrees(s.ID); err == nil && len(wts) > 0 {
+		return wts
+	}
+	if s.WorktreePath == "" || s.WorktreeRepo == "" {
+		return nil
+	}
+	return []*db.SessionWorktree{{
+		SessionID:    s.ID,
+		RepoURL:      s.RepoURL,
+		RepoPath:     s.WorktreeRepo,
+		WorktreePath: s.WorktreePath,
+		Branch:       s.WorktreeBranch,
+	}}
+}
+
+// RemoveWorktrees removes every worktree owned by s and, for a multi-repo
+// session, its session directory. Without force, removal stops at the first
+// worktree git refuses to remove (e.g. because it has uncommitted changes).
+func RemoveWorktrees(store *db.DB, s *db.Session, force bool) error {
+	wts := Worktrees(store, s)
+	for _, wt := range wts {
+		// Checkpoint refs live in the shared repo and would outlive the
+		// worktree; failing to drop them is not worth blocking removal.
+		checkpoint.DeleteAll(wt.RepoPath, s.ID)
+		if err := git.RemoveWorktree(wt.RepoPath, wt.WorktreePath, force); err != nil {
+			return fmt.Errorf("%s: %w", wt.WorktreePath, err)
+		}
+	}
+	if len(wts) > 1 && s.ProjectPath != "" {
+		// Only the (now empty) session directory should remain.
+		if err := os.Remove(s.ProjectPath); err != nil && !os.IsNotExist(err) && force {
+			return os.RemoveAll(s.ProjectPath)
+		}
+	}
+	return nil
+}
+
+// IsDirty reports whether any worktree owned by s has uncommitted changes.
+func IsDirty(store *db.DB, s *db.Session) (bool, error) {
+	for _, wt := range Worktrees(store, s) {
+		dirty, err := git.IsWorktreeDirty(wt.WorktreePath)
+		if err != nil {
+			return false, err
+		}
+		if dirty {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// RefreshDirty rechecks whether s has uncommitted changes and stores the
+// result, reporting whether it differs from s.HasUncommitted.
+func RefreshDirty(store *db.DB, s *db.Session) (dirty, changed bool, err error) {
+	if dirty, err = IsDirty(store, s); err != nil {
+		return false, false, err
+	}
+	if err := store.UpdateSessionDirty(s.ID, dirty); err != nil {
+		return false, false, err
+	}
+	return dirty, dirty != s.HasUncommitted, nil
+}
@@ -0,0 +1,264 @@
+package session_test
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zsprackett/agent-workspace/internal/db"
+	"github.com/zsprackett/agent-workspace/internal/git"
+	"github.com/zsprackett/agent-workspace/internal/session"
+)
+
+const testRepoURL = "https://github.com/owner/myrepo"
+
+func gitRun(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GIT_AUTHOR_NAME=test", "GIT_AUTHOR_EMAIL=t@t.com",
+		"GIT_COMMITTER_NAME=test", "GIT_COMMITTER_EMAIL=t@t.com")
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %v: %v\n%s", args, err, out)
+	}
+	return string(out)
+}
+
+// setupRepo creates an upstream repo named name whose default branch is
+// defaultBranch, with an extra "feature" branch, and bare-clones it to where
+// PlanWorktree expects https://github.com/owner/<name> to live under reposDir.
+func setupRepo(t *testing.T, reposDir, name, defaultBranch string) {
+	t.Helper()
+	upstream := t.TempDir()
+	gitRun(t, upstream, "init", "-b", defaultBranch)
+	os.WriteFile(filepath.Join(upstream, "README.md"), []byte("hello\n"), 0644)
+	gitRun(t, upstream, "add", ".")
+	gitRun(t, upstream, "commit", "-m", "init")
+	gitRun(t, upstream, "checkout", "-b", "feature")
+	os.WriteFile(filepath.Join(upstream, "feature.txt"), []byte("feature\n"), 0644)
+	gitRun(t, upstream, "add", ".")
+	gitRun(t, upstream, "commit", "-m", "feature")
+	gitRun(t, upstream, "checkout", defaultBranch)
+
+	bare := git.BareRepoPath(reposDir, "github.com", "owner", name)
+	os.MkdirAll(filepath.Dir(bare), 0755)
+	if err := git.CloneBare(upstream, bare); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// setupMasterRepo sets up testRepoURL with master as its default branch.
+func setupMasterRepo(t *testing.T, reposDir string) {
+	t.Helper()
+	setupRepo(t, reposDir, "myrepo", "master")
+}
+
+func TestPlanWorktree_BranchFromTitle(t *testing.T) {
+	plan, err := session.PlanWorktree(session.WorktreeOptions{
+		RepoURL: testRepoURL, ReposDir: "/repos", WorktreesDir: "/wt",
+	}, "Fix the bug")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plan.Branch != "Fix-the-bug" {
+		t.Errorf("branch: got %q", plan.Branch)
+	}
+	if plan.WorktreePath != "/wt/github.com/owner/myrepo/Fix-the-bug" {
+		t.Errorf("worktree path: got %q", plan.WorktreePath)
+	}
+}
+
+func TestPlanWorktree_ExplicitBranch(t *testing.T) {
+	plan, err := session.PlanWorktree(session.WorktreeOptions{
+		RepoURL: testRepoURL, ReposDir: "/repos", WorktreesDir: "/wt", Branch: "feat/login",
+	}, "ignored")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plan.Branch != "feat/login" {
+		t.Errorf("branch: got %q", plan.Branch)
+	}
+	if plan.WorktreePath != "/wt/github.com/owner/myrepo/feat-login" {
+		t.Errorf("worktree path: got %q", plan.WorktreePath)
+	}
+
+	if _, err := session.PlanWorktree(session.WorktreeOptions{
+		RepoURL: testRepoURL, Branch: "bad..name",
+	}, "x"); err == nil {
+		t.Error("expected invalid branch name to be rejected")
+	}
+	if _, err := session.PlanWorktree(session.WorktreeOptions{
+		RepoURL: testRepoURL, Checkout: true,
+	}, "x"); err == nil {
+		t.Error("expected checkout without a branch to be rejected")
+	}
+}
+
+func TestProvision_DetectsMasterDefault(t *testing.T) {
+	reposDir, worktreesDir := t.TempDir(), t.TempDir()
+	setupMasterRepo(t, reposDir)
+
+	// A configured default of "main" does not exist here and must not break
+	// creation; the repo's detected default is used instead.
+	plan, err := session.PlanWorktree(session.WorktreeOptions{
+		RepoURL: testRepoURL, ReposDir: reposDir, WorktreesDir: worktreesDir,
+		DefaultBaseBranch: "main",
+	}, "swift-fox")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if base, err := plan.BaseBranch(); err != nil || base != "master" {
+		t.Fatalf("base branch: got %q, %v; want master", base, err)
+	}
+	if err := plan.Provision(); err != nil {
+		t.Fatalf("provision: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(plan.WorktreePath, "README.md")); err != nil {
+		t.Errorf("expected checked-out worktree: %v", err)
+	}
+}
+
+func TestProvision_ExplicitBaseAndCheckout(t *testing.T) {
+	reposDir, worktreesDir := t.TempDir(), t.TempDir()
+	setupMasterRepo(t, reposDir)
+
+	plan, err := session.PlanWorktree(session.WorktreeOptions{
+		RepoURL: testRepoURL, ReposDir: reposDir, WorktreesDir: worktreesDir,
+		BaseBranch: "feature", GroupBaseBranch: "master",
+	}, "on-feature")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := plan.Provision(); err != nil {
+		t.Fatalf("provision: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(plan.WorktreePath, "feature.txt")); err != nil {
+		t.Errorf("expected worktree based on feature: %v", err)
+	}
+
+	co, err := session.PlanWorktree(session.WorktreeOptions{
+		RepoURL: testRepoURL, ReposDir: reposDir, WorktreesDir: worktreesDir,
+		Branch: "feature", Checkout: true,
+	}, "ignored")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := co.Provision(); err != nil {
+		t.Fatalf("checkout: %v", err)
+	}
+	if got := gitRun(t, co.WorktreePath, "rev-parse", "--abbrev-ref", "@{upstream}"); got != "origin/feature\n" {
+		t.Errorf("upstream: got %q", got)
+	}
+
+	// Checking the branch out again keeps commits not yet pushed, and a
+	// branch that has diverged from the remote is refused.
+	os.WriteFile(filepath.Join(co.WorktreePath, "local.txt"), []byte("local\n"), 0644)
+	gitRun(t, co.WorktreePath, "add", ".")
+	gitRun(t, co.WorktreePath, "commit", "-m", "local")
+	local := gitRun(t, co.WorktreePath, "rev-parse", "HEAD")
+	gitRun(t, co.WorktreePath, "worktree", "remove", co.WorktreePath)
+	if err := co.Provision(); err != nil {
+		t.Fatalf("checkout again: %v", err)
+	}
+	if got := gitRun(t, co.WorktreePath, "rev-parse", "HEAD"); got != local {
+		t.Errorf("expected the local commit kept, got %s", got)
+	}
+	gitRun(t, co.WorktreePath, "worktree", "remove", co.WorktreePath)
+	bare := git.BareRepoPath(reposDir, "github.com", "owner", "myrepo")
+	upstream := strings.TrimSpace(gitRun(t, bare, "config", "remote.origin.url"))
+	other := gitRun(t, upstream, "commit-tree", "-p", "master", "-m", "other", "master^{tree}")
+	gitRun(t, upstream, "update-ref", "refs/heads/feature", strings.TrimSpace(other))
+	if err := co.Provision(); !errors.Is(err, git.ErrBranchDiverged) {
+		t.Errorf("expected a diverged branch to be refused, got %v", err)
+	}
+
+	missing, _ := session.PlanWorktree(session.WorktreeOptions{
+		RepoURL: testRepoURL, ReposDir: reposDir, WorktreesDir: worktreesDir,
+		BaseBranch: "nope",
+	}, "missing-base")
+	if err := missing.Provision(); err == nil {
+		t.Error("expected error for unknown base branch")
+	}
+}
+
+func TestRemoteBranches(t *testing.T) {
+	reposDir := t.TempDir()
+	if got, err := session.RemoteBranches(reposDir, testRepoURL); err != nil || got != nil {
+		t.Fatalf("uncloned repo: got %v, %v", got, err)
+	}
+	setupMasterRepo(t, reposDir)
+	got, err := session.RemoteBranches(reposDir, testRepoURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[0] != "feature" || got[1] != "master" {
+		t.Errorf("got %v, want [feature master]", got)
+	}
+}
+
+func TestMultiRepoSession(t *testing.T) {
+	store := newTestDB(t)
+	reposDir, worktreesDir := t.TempDir(), t.TempDir()
+	setupMasterRepo(t, reposDir)
+	setupRepo(t, reposDir, "proto", "main")
+
+	plan, err := session.PlanWorktree(session.WorktreeOptions{
+		RepoURL:       testRepoURL,
+		ExtraRepoURLs: []string{"https://github.com/owner/proto"},
+		ReposDir:      reposDir,
+		WorktreesDir:  worktreesDir,
+	}, "cross-repo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := git.SessionRootPath(worktreesDir, "cross-repo")
+	if !plan.MultiRepo() || plan.Root != root {
+		t.Fatalf("expected multi-repo plan rooted at %s, got %q", root, plan.Root)
+	}
+	if plan.WorktreePath != filepath.Join(root, "myrepo") || plan.Repos[1].WorktreePath != filepath.Join(root, "proto") {
+		t.Fatalf("unexpected layout: %+v", plan.Repos)
+	}
+	if err := plan.Provision(); err != nil {
+		t.Fatalf("provision: %v", err)
+	}
+	for _, r := range plan.Repos {
+		if got := gitRun(t, r.WorktreePath, "rev-parse", "--abbrev-ref", "HEAD"); got != "cross-repo\n" {
+			t.Errorf("%s: branch %q, want cross-repo", r.WorktreePath, got)
+		}
+	}
+
+	now := time.Now()
+	s := &db.Session{
+		ID: "multi", Title: "cross-repo", GroupPath: "work", Tool: db.ToolShell, Status: db.StatusIdle,
+		CreatedAt: now, LastAccessed: now,
+		ProjectPath: plan.Root, WorktreePath: plan.WorktreePath, WorktreeRepo: plan.RepoPath, WorktreeBranch: plan.Branch,
+	}
+	store.SaveSession(s)
+	store.SaveSessionWorktrees(s.ID, plan.Worktrees())
+
+	if wts := session.Worktrees(store, s); len(wts) != 2 {
+		t.Fatalf("expected 2 worktrees, got %d", len(wts))
+	}
+	if dirty, err := session.IsDirty(store, s); err != nil || dirty {
+		t.Fatalf("expected clean session, got %v, %v", dirty, err)
+	}
+	os.WriteFile(filepath.Join(plan.Repos[1].WorktreePath, "new.proto"), []byte("x"), 0644)
+	if dirty, _ := session.IsDirty(store, s); !dirty {
+		t.Error("expected a change in the secondary repo to mark the session dirty")
+	}
+
+	if err := session.RemoveWorktrees(store, s, false); err == nil {
+		t.Error("expected non-forced removal to fail on a dirty worktree")
+	}
+	if err := session.RemoveWorktrees(store, s, true); err != nil {
+		t.Fatalf("force remove: %v", err)
+	}
+	if _, err := os.Stat(root); !os.IsNotExist(err) {
+		t.Error("expected session directory to be removed")
+	}
+}
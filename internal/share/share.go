@@ -0,0 +1,112 @@
+// Package share manages read-only share links, which let someone without an
+// account watch a session's pane until the link expires or is revoked.
+//
+// A link's URL carries a short token: the link ID and an HMAC of the link
+// and its session keyed with the web server's JWT secret. The token is
+// derived from the stored link, so it can be shown again without being
+// stored, and is short enough to copy out of a terminal.
+package share
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/zsprackett/agent-workspace/internal/db"
+)
+
+const (
+	// DefaultTTL is how long a link lasts when no lifetime is given.
+	DefaultTTL = time.Hour
+	// MaxTTL is the longest a link may last.
+	MaxTTL = 7 * 24 * time.Hour
+)
+
+var (
+	// ErrInvalid is returned by Check for tokens that were not issued here.
+	ErrInvalid = errors.New("invalid share link")
+	// ErrInactive is returned by Check for links that expired or were revoked.
+	ErrInactive = errors.New("share link expired or revoked")
+	// ErrNoSecret is returned when there is no secret to sign links with.
+	ErrNoSecret = errors.New("share links need the web server's JWT secret")
+)
+
+// Create records a link to the session lasting ttl (DefaultTTL if zero) and
+// returns it with its token.
+func Create(store *db.DB, secret, sessionID, createdBy string, ttl time.Duration) (*db.ShareLink, string, error) {
+	if secret == "" {
+		return nil, "", ErrNoSecret
+	}
+	if ttl == 0 {
+		ttl = DefaultTTL
+	}
+	if ttl < 0 || ttl > MaxTTL {
+		return nil, "", fmt.Errorf("share link lifetime must be between 0 and %s", MaxTTL)
+	}
+	l := &db.ShareLink{SessionID: sessionID, CreatedBy: createdBy, ExpiresAt: time.Now().Add(ttl)}
+	if err := store.CreateShareLink(l); err != nil {
+		return nil, "", err
+	}
+	event(store, l, "share_created", map[string]any{"by": createdBy, "expires_at": l.ExpiresAt.UTC()})
+	return l, Token(secret, l), nil
+}
+
+// Token returns the signed token for a link.
+func Token(secret string, l *db.ShareLink) string {
+	return l.ID + "." + sign(secret, l.ID, l.SessionID)
+}
+
+func sign(secret, linkID, sessionID string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("share\x00" + linkID + "\x00" + sessionID))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil)[:16])
+}
+
+// Path returns the URL path of the viewer page for a token.
+func Path(token string) string {
+	return "/share/" + token + "/"
+}
+
+// Check verifies a token and returns its link if it is still active.
+func Check(store *db.DB, secret, token string) (*db.ShareLink, error) {
+	if secret == "" {
+		return nil, ErrNoSecret
+	}
+	id, mac, ok := strings.Cut(token, ".")
+	if !ok {
+		return nil, ErrInvalid
+	}
+	l, err := store.GetShareLink(id)
+	if err != nil || !hmac.Equal([]byte(mac), []byte(sign(secret, l.ID, l.SessionID))) {
+		return nil, ErrInvalid
+	}
+	if !l.Active() {
+		return nil, ErrInactive
+	}
+	return l, nil
+}
+
+// Revoke ends a link now.
+func Revoke(store *db.DB, l *db.ShareLink, by string) error {
+	if err := store.RevokeShareLink(l.ID); err != nil {
+		return err
+	}
+	event(store, l, "share_revoked", map[string]any{"by": by})
+	return nil
+}
+
+// Viewed records that someone at ip opened the link.
+func Viewed(store *db.DB, l *db.ShareLink, ip string) {
+	event(store, l, "share_viewed", map[string]any{"ip": ip})
+}
+
+func event(store *db.DB, l *db.ShareLink, typ string, detail map[string]any) {
+	detail["link"] = l.ID
+	b, _ := json.Marshal(detail)
+	store.InsertSessionEvent(l.SessionID, typ, string(b))
+}
@@ -0,0 +1,70 @@
+package share_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zsprackett/agent-workspace/internal/db"
+	"github.com/zsprackett/agent-workspace/internal/share"
+)
+
+func TestShareLinks(t *testing.T) {
+	store, _ := db.Open(":memory:")
+	store.Migrate()
+	t.Cleanup(func() { store.Close() })
+	now := time.Now()
+	store.SaveSession(&db.Session{ID: "s1", Title: "s", GroupPath: "my-sessions", Tool: db.ToolShell,
+		Status: db.StatusRunning, CreatedAt: now, LastAccessed: now})
+
+	if _, _, err := share.Create(store, "", "s1", "alice", 0); !errors.Is(err, share.ErrNoSecret) {
+		t.Errorf("no secret: %v", err)
+	}
+	if _, _, err := share.Create(store, "secret", "s1", "alice", 8*24*time.Hour); err == nil {
+		t.Error("expected an error for a lifetime over the maximum")
+	}
+	l, token, err := share.Create(store, "secret", "s1", "alice", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Until(l.ExpiresAt); d < 59*time.Minute || d > share.DefaultTTL {
+		t.Errorf("default lifetime: %s", d)
+	}
+	if token != share.Token("secret", l) || !strings.HasPrefix(token, l.ID+".") {
+		t.Errorf("token: %q", token)
+	}
+
+	if got, err := share.Check(store, "secret", token); err != nil || got.ID != l.ID {
+		t.Fatalf("check: %v %+v", err, got)
+	}
+	for _, bad := range []string{token + "x", l.ID + ".AAAA", "nodot", ""} {
+		if _, err := share.Check(store, "secret", bad); !errors.Is(err, share.ErrInvalid) {
+			t.Errorf("check %q: %v", bad, err)
+		}
+	}
+	if _, err := share.Check(store, "other-secret", token); !errors.Is(err, share.ErrInvalid) {
+		t.Errorf("other secret: %v", err)
+	}
+
+	expired := &db.ShareLink{SessionID: "s1", CreatedBy: "alice", ExpiresAt: time.Now().Add(-time.Second)}
+	store.CreateShareLink(expired)
+	if _, err := share.Check(store, "secret", share.Token("secret", expired)); !errors.Is(err, share.ErrInactive) {
+		t.Errorf("expired link: %v", err)
+	}
+
+	if err := share.Revoke(store, l, "bob"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := share.Check(store, "secret", token); !errors.Is(err, share.ErrInactive) {
+		t.Errorf("revoked link: %v", err)
+	}
+	events, _ := store.GetSessionEvents("s1", 10)
+	var types []string
+	for _, e := range events {
+		types = append(types, e.EventType)
+	}
+	if got := strings.Join(types, ","); got != "share_revoked,share_created" {
+		t.Errorf("session events: %s", got)
+	}
+}
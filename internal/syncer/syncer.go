@@ -7,17 +7,32 @@ import (
 	"time"
 
 	"github.com/zsprackett/agent-workspace/internal/db"
+	"github.com/zsprackett/agent-workspace/internal/events"
+	"github.com/zsprackett/agent-workspace/internal/gc"
 	"github.com/zsprackett/agent-workspace/internal/git"
+	"github.com/zsprackett/agent-workspace/internal/metrics"
+	"github.com/zsprackett/agent-workspace/internal/session"
 )
 
+// GCConfig controls the periodic garbage-collection scan. A zero Interval
+// disables it.
+type GCConfig struct {
+	WorktreesDir string
+	Interval     time.Duration
+	AutoPrune    bool
+}
+
 type Syncer struct {
 	db       *db.DB
 	reposDir string
 	interval time.Duration
+	gc       GCConfig
 	stop     chan struct{}
 	wg       sync.WaitGroup
 	fetch    func(repoDir string) error
 	logger   *slog.Logger
+	// broadcaster is told when a worktree becomes dirty or clean.
+	broadcaster events.Broadcaster
 }
 
 func New(store *db.DB, reposDir string, logger *slog.Logger) *Syncer {
@@ -38,18 +53,37 @@ func NewWithFetch(store *db.DB, reposDir string, logger *slog.Logger, fetch func
 	return s
 }
 
+// SetBroadcaster reports changes to worktrees' dirty state to b. Must be
+// called before Start.
+func (s *Syncer) SetBroadcaster(b events.Broadcaster) {
+	s.broadcaster = b
+}
+
+// SetGC enables the periodic garbage-collection scan. Must be called before Start.
+func (s *Syncer) SetGC(cfg GCConfig) {
+	s.gc = cfg
+}
+
 func (s *Syncer) Start() {
 	s.wg.Add(1)
 	go func() {
 		defer s.wg.Done()
 		ticker := time.NewTicker(s.interval)
 		defer ticker.Stop()
+		var gcC <-chan time.Time
+		if s.gc.Interval > 0 {
+			gcTicker := time.NewTicker(s.gc.Interval)
+			defer gcTicker.Stop()
+			gcC = gcTicker.C
+		}
 		for {
 			select {
 			case <-s.stop:
 				return
 			case <-ticker.C:
 				s.refresh()
+			case <-gcC:
+				s.collect()
 			}
 		}
 	}()
@@ -65,6 +99,50 @@ func (s *Syncer) RunOnce() {
 	s.refresh()
 }
 
+// RunGCOnce runs a single garbage-collection scan synchronously. Used in tests.
+func (s *Syncer) RunGCOnce() {
+	s.collect()
+}
+
+// collect scans for orphaned worktrees, merged branches and unused repos and
+// logs what it finds. Candidates are only removed when AutoPrune is set.
+func (s *Syncer) collect() {
+	report, err := gc.Scan(s.db, gc.Options{
+		ReposDir:     s.reposDir,
+		WorktreesDir: s.gc.WorktreesDir,
+	})
+	if err != nil {
+		s.logger.Warn("syncer: gc scan failed", "err", err)
+		return
+	}
+	if len(report.Candidates) == 0 {
+		return
+	}
+	for _, c := range report.Candidates {
+		s.logger.Info("syncer: gc candidate",
+			"kind", string(c.Kind),
+			"target", c.Label(),
+			"size", gc.FormatBytes(c.Bytes),
+			"reason", c.Reason,
+		)
+	}
+	if !s.gc.AutoPrune {
+		s.logger.Info("syncer: gc found reclaimable items; run 'agent-workspace gc' to prune",
+			"count", len(report.Candidates),
+			"reclaimable", gc.FormatBytes(report.ReclaimableBytes()),
+		)
+		return
+	}
+	if err := gc.Prune(report); err != nil {
+		s.logger.Warn("syncer: gc prune failed", "err", err)
+		return
+	}
+	s.logger.Info("syncer: gc pruned",
+		"count", len(report.Candidates),
+		"reclaimed", gc.FormatBytes(report.ReclaimableBytes()),
+	)
+}
+
 func (s *Syncer) refresh() {
 	groups, err := s.db.LoadGroups()
 	if err != nil {
@@ -74,16 +152,22 @@ func (s *Syncer) refresh() {
 		if g.RepoURL == "" {
 			continue
 		}
-		host, owner, repo, err := git.ParseRepoURL(g.RepoURL)
-		if err != nil {
-			continue
-		}
-		path := git.BareRepoPath(s.reposDir, host, owner, repo)
-		if _, err := os.Stat(path); err != nil {
-			continue
-		}
-		if err := s.fetch(path); err != nil {
-			s.logger.Warn("syncer: fetch failed", "repo", path, "err", err)
+		for _, u := range append([]string{g.RepoURL}, g.ExtraRepoURLs...) {
+			host, owner, repo, err := git.ParseRepoURL(u)
+			if err != nil {
+				continue
+			}
+			path := git.BareRepoPath(s.reposDir, host, owner, repo)
+			if _, err := os.Stat(path); err != nil {
+				continue
+			}
+			start := time.Now()
+			err = s.fetch(path)
+			metrics.FetchDuration.Observe(time.Since(start).Seconds())
+			if err != nil {
+				metrics.FetchFailures.Inc(host + "/" + owner + "/" + repo)
+				s.logger.Warn("syncer: fetch failed", "repo", path, "err", err)
+			}
 		}
 		s.updateDirtyStatus(g.Path)
 	}
@@ -98,10 +182,10 @@ func (s *Syncer) updateDirtyStatus(groupPath string) {
 		if sess.WorktreePath == "" {
 			continue
 		}
-		dirty, err := git.IsWorktreeDirty(sess.WorktreePath)
-		if err != nil {
+		dirty, changed, err := session.RefreshDirty(s.db, sess)
+		if err != nil || !changed || s.broadcaster == nil {
 			continue
 		}
-		s.db.UpdateSessionDirty(sess.ID, dirty)
+		s.broadcaster.Broadcast(events.Event{Type: events.GitDirtyChanged, SessionID: sess.ID, Dirty: &dirty})
 	}
 }
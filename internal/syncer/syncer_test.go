@@ -5,9 +5,11 @@ import (
 	"io"
 	"log/slog"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/zsprackett/agent-workspace/internal/db"
 	"github.com/zsprackett/agent-workspace/internal/syncer"
@@ -127,3 +129,26 @@ func TestRefresh_LogsWarnOnFetchError(t *testing.T) {
 		t.Errorf("expected warn log with fetch error, got: %q", buf.String())
 	}
 }
+
+func TestRunGCOnce_LogsUnusedRepo(t *testing.T) {
+	store := openDB(t)
+	reposDir := t.TempDir()
+
+	bareRepoPath := filepath.Join(reposDir, "github.com", "owner", "orphaned.git")
+	if err := exec.Command("git", "init", "--bare", bareRepoPath).Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf strings.Builder
+	logger := slog.New(slog.NewTextHandler(&buf, nil))
+	s := syncer.New(store, reposDir, logger)
+	s.SetGC(syncer.GCConfig{WorktreesDir: t.TempDir(), Interval: time.Hour})
+	s.RunGCOnce()
+
+	if !strings.Contains(buf.String(), bareRepoPath) {
+		t.Errorf("expected gc log mentioning %s, got: %q", bareRepoPath, buf.String())
+	}
+	if _, err := os.Stat(bareRepoPath); err != nil {
+		t.Error("repo must not be removed without AutoPrune")
+	}
+}
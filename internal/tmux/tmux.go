@@ -64,6 +64,23 @@ func CreateSession(opts CreateOptions) error {
 	return nil
 }
 
+// SessionEnvironment returns the variables set in a session's own
+// environment, such as those given with -e or set-environment. Variables
+// the session has removed are left out.
+func SessionEnvironment(name string) (map[string]string, error) {
+	out, err := exec.Command("tmux", "show-environment", "-t", name).Output()
+	if err != nil {
+		return nil, fmt.Errorf("tmux show-environment: %w", err)
+	}
+	env := map[string]string{}
+	for _, line := range strings.Split(string(out), "\n") {
+		if k, v, ok := strings.Cut(line, "="); ok && !strings.HasPrefix(k, "-") {
+			env[k] = v
+		}
+	}
+	return env, nil
+}
+
 func KillSession(name string) error {
 	return exec.Command("tmux", "kill-session", "-t", name).Run()
 }
@@ -78,6 +95,17 @@ func SendText(name, text string) error {
 	return exec.Command("tmux", "send-keys", "-t", name, "-l", text).Run()
 }
 
+// SendEnter presses Enter in a tmux pane.
+func SendEnter(name string) error {
+	return exec.Command("tmux", "send-keys", "-t", name, "Enter").Run()
+}
+
+// SendEscape presses Escape in a tmux pane, which interrupts Claude and
+// most other agents mid-response.
+func SendEscape(name string) error {
+	return exec.Command("tmux", "send-keys", "-t", name, "Escape").Run()
+}
+
 // PipePane redirects tmux pane output to a shell command.
 // The -o flag opens the pipe only if not already open.
 func PipePane(name, command string) error {
@@ -0,0 +1,214 @@
+package tokenusage
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zsprackett/agent-workspace/internal/db"
+)
+
+// Collector keeps the hourly usage recorded for every Claude session up to
+// date with its transcripts.
+type Collector struct {
+	store    *db.DB
+	interval time.Duration
+	stop     chan struct{}
+	logger   *slog.Logger
+
+	mu sync.Mutex
+	// files caches parsed transcripts by path; a file is parsed again only
+	// when its size or modification time changes.
+	files map[string]*transcript
+	// collected is what each project directory held when its sessions'
+	// usage was last recorded.
+	collected map[string]string
+}
+
+type transcript struct {
+	size    int64
+	modTime time.Time
+	entries []Entry
+}
+
+func New(store *db.DB, interval time.Duration, logger *slog.Logger) *Collector {
+	return &Collector{
+		store:     store,
+		interval:  interval,
+		stop:      make(chan struct{}),
+		logger:    logger,
+		files:     make(map[string]*transcript),
+		collected: make(map[string]string),
+	}
+}
+
+func (c *Collector) Start() {
+	go func() {
+		c.collect()
+		ticker := time.NewTicker(c.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				c.collect()
+			case <-c.stop:
+				return
+			}
+		}
+	}()
+}
+
+func (c *Collector) Stop() {
+	close(c.stop)
+}
+
+func (c *Collector) collect() {
+	if err := c.Collect(); err != nil {
+		c.logger.Debug("token usage collection failed", "err", err)
+	}
+}
+
+// Collect records the usage of every Claude session whose transcripts
+// changed since the last call.
+//
+// Claude Code keeps transcripts by working directory, so sessions started in
+// the same directory share one. Each transcript is credited to the session
+// created most recently before its first response; transcripts older than
+// every session there belong to none of them.
+func (c *Collector) Collect() error {
+	sessions, err := c.store.LoadSessions()
+	if err != nil {
+		return err
+	}
+	byDir := make(map[string][]*db.Session)
+	for _, s := range sessions {
+		if s.Tool == db.ToolClaude && s.ProjectPath != "" {
+			// Claude names the directory after the resolved path.
+			path := s.ProjectPath
+			if resolved, err := filepath.EvalSymlinks(path); err == nil {
+				path = resolved
+			}
+			dir := ProjectDir(path)
+			byDir[dir] = append(byDir[dir], s)
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	live := make(map[string]bool)
+	for dir, dirSessions := range byDir {
+		if err := c.collectDir(dir, dirSessions, live); err != nil {
+			c.logger.Debug("token usage: collect failed", "dir", dir, "err", err)
+		}
+	}
+	for path := range c.files {
+		if !live[path] {
+			delete(c.files, path)
+		}
+	}
+	for dir := range c.collected {
+		if byDir[dir] == nil {
+			delete(c.collected, dir)
+		}
+	}
+	return nil
+}
+
+func (c *Collector) collectDir(dir string, sessions []*db.Session, live map[string]bool) error {
+	paths, err := Transcripts(dir)
+	if err != nil {
+		return err
+	}
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].CreatedAt.Before(sessions[j].CreatedAt) })
+
+	// Skip the directory if neither its transcripts nor its sessions changed.
+	var state strings.Builder
+	for _, s := range sessions {
+		fmt.Fprintf(&state, "%s %s %s\n", s.ID, s.Title, s.GroupPath)
+	}
+	var files []*transcript
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		live[path] = true
+		fmt.Fprintf(&state, "%s %d %d\n", path, info.Size(), info.ModTime().UnixNano())
+		f := c.files[path]
+		if f == nil || f.size != info.Size() || !f.modTime.Equal(info.ModTime()) {
+			entries, err := ParseFile(path)
+			if err != nil {
+				c.logger.Debug("token usage: read transcript failed", "path", path, "err", err)
+			}
+			f = &transcript{size: info.Size(), modTime: info.ModTime(), entries: entries}
+			c.files[path] = f
+		}
+		if len(f.entries) > 0 {
+			files = append(files, f)
+		}
+	}
+	if c.collected[dir] == state.String() {
+		return nil
+	}
+
+	// Older transcripts first, so a response copied into a resumed
+	// conversation is credited where it was first logged.
+	sort.Slice(files, func(i, j int) bool { return files[i].entries[0].Time.Before(files[j].entries[0].Time) })
+	type bucket struct {
+		hour  int64
+		model string
+	}
+	usage := make(map[string]map[bucket]*db.SessionUsage)
+	logged := make(map[string]bool)
+	for _, f := range files {
+		var owner *db.Session
+		for _, s := range sessions {
+			if !s.CreatedAt.After(f.entries[0].Time) {
+				owner = s
+			}
+		}
+		if owner == nil {
+			continue
+		}
+		if usage[owner.ID] == nil {
+			usage[owner.ID] = make(map[bucket]*db.SessionUsage)
+		}
+		for _, e := range f.entries {
+			if e.Key != "" {
+				if logged[e.Key] {
+					continue
+				}
+				logged[e.Key] = true
+			}
+			k := bucket{e.Time.Truncate(time.Hour).UnixMilli(), e.Model}
+			u := usage[owner.ID][k]
+			if u == nil {
+				u = &db.SessionUsage{SessionID: owner.ID, Title: owner.Title, GroupPath: owner.GroupPath, HourMs: k.hour, Model: k.model}
+				usage[owner.ID][k] = u
+			}
+			u.Messages++
+			u.InputTokens += e.Tokens.Input
+			u.OutputTokens += e.Tokens.Output
+			u.CacheWriteTokens += e.Tokens.CacheWrite
+			u.CacheReadTokens += e.Tokens.CacheRead
+			u.CostUSD += Cost(e.Model, e.Tokens)
+		}
+	}
+
+	for _, s := range sessions {
+		var rows []db.SessionUsage
+		for _, u := range usage[s.ID] {
+			rows = append(rows, *u)
+		}
+		if err := c.store.ReplaceSessionUsage(s.ID, rows); err != nil {
+			return err
+		}
+	}
+	c.collected[dir] = state.String()
+	return nil
+}
@@ -0,0 +1,58 @@
+package tokenusage
+
+import "strings"
+
+// Price is what a model costs in US dollars per million tokens.
+type Price struct {
+	Input      float64
+	Output     float64
+	CacheWrite float64 // five-minute cache writes
+	CacheRead  float64
+}
+
+var (
+	opus       = Price{Input: 5, Output: 25, CacheWrite: 6.25, CacheRead: 0.50}
+	opusLegacy = Price{Input: 15, Output: 75, CacheWrite: 18.75, CacheRead: 1.50}
+	sonnet     = Price{Input: 3, Output: 15, CacheWrite: 3.75, CacheRead: 0.30}
+	haiku      = Price{Input: 1, Output: 5, CacheWrite: 1.25, CacheRead: 0.10}
+	haiku35    = Price{Input: 0.80, Output: 4, CacheWrite: 1, CacheRead: 0.08}
+	haiku3     = Price{Input: 0.25, Output: 1.25, CacheWrite: 0.30, CacheRead: 0.03}
+)
+
+// prices maps model ID fragments to list prices. The first match wins, so
+// older models that share a family name come first.
+var prices = []struct {
+	match string
+	price Price
+}{
+	{"opus-4-2025", opusLegacy}, // claude-opus-4-20250514
+	{"opus-4-0", opusLegacy},
+	{"opus-4-1", opusLegacy},
+	{"3-opus", opusLegacy},
+	{"opus", opus},
+	{"sonnet", sonnet},
+	{"3-5-haiku", haiku35},
+	{"3-haiku", haiku3},
+	{"haiku", haiku},
+}
+
+// PriceOf returns the list price of a model. ok is false for a model it
+// does not know.
+func PriceOf(model string) (p Price, ok bool) {
+	for _, mp := range prices {
+		if strings.Contains(model, mp.match) {
+			return mp.price, true
+		}
+	}
+	return Price{}, false
+}
+
+// Cost estimates what t cost on model at list prices. Unknown models cost
+// nothing.
+func Cost(model string, t Tokens) float64 {
+	p, _ := PriceOf(model)
+	return (float64(t.Input)*p.Input +
+		float64(t.Output)*p.Output +
+		float64(t.CacheWrite)*p.CacheWrite +
+		float64(t.CacheRead)*p.CacheRead) / 1e6
+}
@@ -0,0 +1,161 @@
+package tokenusage_test
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zsprackett/agent-workspace/internal/db"
+	"github.com/zsprackett/agent-workspace/internal/tokenusage"
+)
+
+// assistant returns a transcript line recording an API response.
+func assistant(id, ts, model string, in, out, cacheWrite, cacheRead int) string {
+	return fmt.Sprintf(`{"type":"assistant","requestId":"req_%s","timestamp":%q,"message":{"id":%q,"model":%q,`+
+		`"content":[{"type":"text","text":"hi"}],"usage":{"input_tokens":%d,"output_tokens":%d,`+
+		`"cache_creation_input_tokens":%d,"cache_read_input_tokens":%d}}}`+"\n",
+		id, ts, id, model, in, out, cacheWrite, cacheRead)
+}
+
+func TestParse(t *testing.T) {
+	transcript := `{"type":"user","timestamp":"2026-01-02T10:00:00Z","message":{"role":"user","content":"hello"}}` + "\n" +
+		assistant("msg_1", "2026-01-02T10:00:05Z", "claude-sonnet-4-5-20250929", 10, 20, 30, 40) +
+		`{"type":"assistant","message":{"model":"<synthetic>","usage":{"input_tokens":0,"output_tokens":0}}}` + "\n" +
+		"not json\n" +
+		strings.TrimSuffix(assistant("msg_2", "2026-01-02T10:01:00Z", "claude-opus-4-5-20251101", 1, 2, 3, 4), "\n")
+
+	entries, err := tokenusage.Parse(strings.NewReader(transcript))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 entries, got %+v", entries)
+	}
+	e := entries[0]
+	if e.Key != "msg_1:req_msg_1" || e.Model != "claude-sonnet-4-5-20250929" ||
+		e.Tokens != (tokenusage.Tokens{Input: 10, Output: 20, CacheWrite: 30, CacheRead: 40}) ||
+		!e.Time.Equal(time.Date(2026, 1, 2, 10, 0, 5, 0, time.UTC)) {
+		t.Errorf("unexpected entry %+v", e)
+	}
+	if entries[1].Model != "claude-opus-4-5-20251101" {
+		t.Errorf("last line without a newline not parsed: %+v", entries[1])
+	}
+}
+
+func TestProjectDir(t *testing.T) {
+	t.Setenv("CLAUDE_CONFIG_DIR", "/cfg")
+	got := tokenusage.ProjectDir("/home/me/.agent-workspace/worktrees/my_repo/fix-bug")
+	want := "/cfg/projects/-home-me--agent-workspace-worktrees-my-repo-fix-bug"
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestCost(t *testing.T) {
+	cases := []struct {
+		model string
+		want  float64
+	}{
+		{"claude-sonnet-4-5-20250929", 3 + 15 + 3.75 + 0.30},
+		{"claude-opus-4-5-20251101", 5 + 25 + 6.25 + 0.50},
+		{"claude-opus-4-1-20250805", 15 + 75 + 18.75 + 1.50},
+		{"claude-opus-4-20250514", 15 + 75 + 18.75 + 1.50},
+		{"claude-haiku-4-5-20251001", 1 + 5 + 1.25 + 0.10},
+		{"claude-3-5-haiku-20241022", 0.80 + 4 + 1 + 0.08},
+		{"some-other-model", 0},
+	}
+	million := tokenusage.Tokens{Input: 1e6, Output: 1e6, CacheWrite: 1e6, CacheRead: 1e6}
+	for _, c := range cases {
+		if got := tokenusage.Cost(c.model, million); math.Abs(got-c.want) > 1e-9 {
+			t.Errorf("%s: got %v, want %v", c.model, got, c.want)
+		}
+	}
+}
+
+func TestCollect(t *testing.T) {
+	cfg := t.TempDir()
+	t.Setenv("CLAUDE_CONFIG_DIR", cfg)
+	work := t.TempDir()
+	projectDir := tokenusage.ProjectDir(work)
+	if resolved, err := filepath.EvalSymlinks(work); err == nil {
+		projectDir = tokenusage.ProjectDir(resolved)
+	}
+	os.MkdirAll(filepath.Join(projectDir, "sub", "subagents"), 0o755)
+
+	store, _ := db.Open(":memory:")
+	store.Migrate()
+	defer store.Close()
+
+	t0 := time.Date(2026, 1, 2, 10, 0, 0, 0, time.UTC)
+	first := &db.Session{ID: "first", Title: "first", GroupPath: "g", Tool: db.ToolClaude, ProjectPath: work, CreatedAt: t0}
+	second := &db.Session{ID: "second", Title: "second", GroupPath: "g", Tool: db.ToolClaude, ProjectPath: work, CreatedAt: t0.Add(2 * time.Hour)}
+	store.SaveSession(first)
+	store.SaveSession(second)
+
+	write := func(name, content string) {
+		if err := os.WriteFile(filepath.Join(projectDir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Older than every session: credited to none.
+	write("old.jsonl", assistant("msg_0", "2026-01-01T09:00:00Z", "claude-sonnet-4-5", 1000, 0, 0, 0))
+	// The first session's conversation, logging msg_1 twice, and its subagent.
+	write("a.jsonl", assistant("msg_1", "2026-01-02T10:00:05Z", "claude-sonnet-4-5", 100, 10, 0, 0)+
+		assistant("msg_1", "2026-01-02T10:00:05Z", "claude-sonnet-4-5", 100, 10, 0, 0)+
+		assistant("msg_2", "2026-01-02T11:30:00Z", "claude-sonnet-4-5", 200, 20, 0, 0))
+	write("sub/subagents/agent-1.jsonl", assistant("msg_3", "2026-01-02T10:10:00Z", "claude-haiku-4-5", 1, 1, 0, 0))
+	// The second session resumed it: msg_2 is copied, msg_4 is new.
+	write("b.jsonl", assistant("msg_2", "2026-01-02T12:00:01Z", "claude-sonnet-4-5", 200, 20, 0, 0)+
+		assistant("msg_4", "2026-01-02T12:05:00Z", "claude-opus-4-5", 1000, 100, 0, 0))
+
+	c := tokenusage.New(store, time.Minute, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err := c.Collect(); err != nil {
+		t.Fatal(err)
+	}
+
+	totals, err := store.SessionUsageTotals(t0.Add(-48 * time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]db.UsageTotal{}
+	for _, tot := range totals {
+		got[tot.SessionID] = tot
+	}
+	if len(got) != 2 {
+		t.Fatalf("expected usage for 2 sessions, got %+v", totals)
+	}
+	if f := got["first"]; f.Messages != 3 || f.InputTokens != 301 || f.OutputTokens != 31 || f.Title != "first" {
+		t.Errorf("first: %+v", f)
+	}
+	if s := got["second"]; s.Messages != 1 || s.InputTokens != 1000 || s.OutputTokens != 100 {
+		t.Errorf("second: %+v", s)
+	}
+	if totals[0].SessionID != "second" {
+		t.Errorf("expected the opus session to cost the most, got %+v", totals)
+	}
+
+	series, _ := store.GetSessionUsage("first", t0)
+	if len(series) != 3 { // 10:00 sonnet, 10:00 haiku, 11:00 sonnet
+		t.Errorf("expected 3 hourly buckets, got %+v", series)
+	}
+
+	groups, _ := store.GroupUsageTotals(t0)
+	if len(groups) != 1 || groups[0].GroupPath != "g" || groups[0].Messages != 4 {
+		t.Errorf("unexpected group totals %+v", groups)
+	}
+
+	// A deleted session's usage is kept under its last title.
+	store.DeleteSession("first")
+	store.UpdateSessionField("second", "title", "renamed")
+	c.Collect()
+	totals, _ = store.SessionUsageTotals(t0)
+	if len(totals) != 2 || totals[0].Title != "renamed" || totals[1].Title != "first" || totals[1].Messages != 3 {
+		t.Errorf("unexpected totals after delete %+v", totals)
+	}
+}
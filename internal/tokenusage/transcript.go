@@ -0,0 +1,142 @@
+// Package tokenusage totals the tokens each Claude session uses, and what
+// they cost, from the JSONL transcripts Claude Code writes under
+// ~/.claude/projects.
+package tokenusage
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Tokens counts the tokens of one or more API responses.
+type Tokens struct {
+	Input      int64
+	Output     int64
+	CacheWrite int64
+	CacheRead  int64
+}
+
+// Add adds o to t.
+func (t *Tokens) Add(o Tokens) {
+	t.Input += o.Input
+	t.Output += o.Output
+	t.CacheWrite += o.CacheWrite
+	t.CacheRead += o.CacheRead
+}
+
+// Entry is one API response recorded in a transcript.
+type Entry struct {
+	Key    string // message and request ID, if known; the same response may be logged more than once
+	Time   time.Time
+	Model  string
+	Tokens Tokens
+}
+
+// nonAlnum matches the characters Claude Code replaces with '-' when naming
+// a project's transcript directory after its working directory.
+var nonAlnum = regexp.MustCompile(`[^a-zA-Z0-9]`)
+
+// ProjectDir returns the directory Claude Code keeps the transcripts of
+// sessions started in dir in.
+func ProjectDir(dir string) string {
+	root := os.Getenv("CLAUDE_CONFIG_DIR")
+	if root == "" {
+		home, _ := os.UserHomeDir()
+		root = filepath.Join(home, ".claude")
+	}
+	return filepath.Join(root, "projects", nonAlnum.ReplaceAllString(dir, "-"))
+}
+
+// Transcripts lists the transcript files under a project directory,
+// including those of subagents. A missing directory has none.
+func Transcripts(projectDir string) ([]string, error) {
+	var files []string
+	err := filepath.WalkDir(projectDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if !d.IsDir() && strings.HasSuffix(path, ".jsonl") {
+			files = append(files, path)
+		}
+		return nil
+	})
+	return files, err
+}
+
+// line is the part of a transcript line that records usage.
+type line struct {
+	Type      string    `json:"type"`
+	RequestID string    `json:"requestId"`
+	Timestamp time.Time `json:"timestamp"`
+	Message   struct {
+		ID    string `json:"id"`
+		Model string `json:"model"`
+		Usage *struct {
+			InputTokens              int64 `json:"input_tokens"`
+			OutputTokens             int64 `json:"output_tokens"`
+			CacheCreationInputTokens int64 `json:"cache_creation_input_tokens"`
+			CacheReadInputTokens     int64 `json:"cache_read_input_tokens"`
+		} `json:"usage"`
+	} `json:"message"`
+}
+
+// ParseFile reads the API responses recorded in a transcript file.
+func ParseFile(path string) ([]Entry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Parse(f)
+}
+
+// Parse reads the API responses recorded in a transcript. Lines that are
+// not assistant messages with usage, or cannot be parsed, are skipped.
+func Parse(r io.Reader) ([]Entry, error) {
+	var entries []Entry
+	br := bufio.NewReader(r)
+	for {
+		b, err := br.ReadBytes('\n')
+		// Tool results make some lines very large; only decode the ones
+		// that can hold usage.
+		if bytes.Contains(b, []byte(`"usage"`)) {
+			var l line
+			if json.Unmarshal(b, &l) == nil && l.Type == "assistant" && l.Message.Usage != nil && l.Message.Model != "<synthetic>" {
+				u := l.Message.Usage
+				var key string
+				if l.Message.ID != "" {
+					key = l.Message.ID + ":" + l.RequestID
+				}
+				entries = append(entries, Entry{
+					Key:   key,
+					Time:  l.Timestamp,
+					Model: l.Message.Model,
+					Tokens: Tokens{
+						Input:      u.InputTokens,
+						Output:     u.OutputTokens,
+						CacheWrite: u.CacheCreationInputTokens,
+						CacheRead:  u.CacheReadInputTokens,
+					},
+				})
+			}
+		}
+		if err == io.EOF {
+			return entries, nil
+		}
+		if err != nil {
+			return entries, err
+		}
+	}
+}
@@ -0,0 +1,133 @@
+// Package totp implements time-based one-time passwords (RFC 6238) as used
+// by authenticator apps: HMAC-SHA1, six digits, 30 second steps. It also
+// generates the single-use recovery codes that stand in for a lost device.
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	digits = 6
+	period = 30 * time.Second
+	// skew is how many steps before or after the current one are accepted,
+	// to allow for clock drift and slow typing.
+	skew = 1
+)
+
+var b32 = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// GenerateSecret returns a new random base32-encoded secret.
+func GenerateSecret() (string, error) {
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return b32.EncodeToString(b), nil
+}
+
+// URI returns the otpauth:// provisioning URI for the secret, which
+// authenticator apps accept directly or as a QR code.
+func URI(issuer, account, secret string) string {
+	v := url.Values{}
+	v.Set("secret", secret)
+	v.Set("issuer", issuer)
+	v.Set("algorithm", "SHA1")
+	v.Set("digits", fmt.Sprint(digits))
+	v.Set("period", fmt.Sprint(int(period.Seconds())))
+	label := url.PathEscape(issuer + ":" + account)
+	return "otpauth://totp/" + label + "?" + v.Encode()
+}
+
+// Step returns the time step t falls in.
+func Step(t time.Time) int64 {
+	return t.Unix() / int64(period.Seconds())
+}
+
+// Code returns the code for the secret at time t.
+func Code(secret string, t time.Time) (string, error) {
+	key, err := decodeSecret(secret)
+	if err != nil {
+		return "", err
+	}
+	return otp(key, Step(t)), nil
+}
+
+// Verify checks code against the secret at time t, allowing one step of
+// drift either way. It returns the matching step so callers can refuse a
+// code that was already used.
+func Verify(secret, code string, t time.Time) (int64, bool) {
+	key, err := decodeSecret(secret)
+	if err != nil {
+		return 0, false
+	}
+	code = strings.ReplaceAll(code, " ", "")
+	if len(code) != digits {
+		return 0, false
+	}
+	now := Step(t)
+	for step := now - skew; step <= now+skew; step++ {
+		if subtle.ConstantTimeCompare([]byte(otp(key, step)), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+func otp(key []byte, step int64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+	off := sum[len(sum)-1] & 0x0f
+	n := binary.BigEndian.Uint32(sum[off:off+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", digits, n%1000000)
+}
+
+func decodeSecret(secret string) ([]byte, error) {
+	s := strings.ToUpper(strings.ReplaceAll(secret, " ", ""))
+	return b32.DecodeString(strings.TrimRight(s, "="))
+}
+
+// RecoveryCodes returns n random codes of the form xxxxx-xxxxx.
+func RecoveryCodes(n int) ([]string, error) {
+	codes := make([]string, n)
+	for i := range codes {
+		b := make([]byte, 5)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		s := hex.EncodeToString(b)
+		codes[i] = s[:5] + "-" + s[5:]
+	}
+	return codes, nil
+}
+
+// IsRecoveryCode reports whether code looks like a recovery code rather
+// than a one-time password.
+func IsRecoveryCode(code string) bool {
+	return len(normalizeRecoveryCode(code)) == 10
+}
+
+// HashRecoveryCode returns the form recovery codes are stored in. Codes are
+// random enough that a fast hash suffices.
+func HashRecoveryCode(code string) string {
+	sum := sha256.Sum256([]byte(normalizeRecoveryCode(code)))
+	return hex.EncodeToString(sum[:])
+}
+
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+}
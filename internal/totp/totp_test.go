@@ -0,0 +1,93 @@
+package totp_test
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zsprackett/agent-workspace/internal/totp"
+)
+
+// The RFC 6238 SHA1 test key, base32-encoded.
+var rfcSecret = base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+
+func TestCodeRFC6238Vectors(t *testing.T) {
+	// The RFC lists eight-digit codes; six-digit codes are their last six.
+	for _, c := range []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	} {
+		got, err := totp.Code(rfcSecret, time.Unix(c.unix, 0))
+		if err != nil || got != c.want {
+			t.Errorf("Code at %d: got %q, %v; want %q", c.unix, got, err, c.want)
+		}
+	}
+}
+
+func TestVerify(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	step, ok := totp.Verify(rfcSecret, "050471", now)
+	if !ok || step != totp.Step(now) {
+		t.Fatalf("current code: step %d, ok %v", step, ok)
+	}
+	// One step of drift either way is accepted, two is not.
+	prev, _ := totp.Code(rfcSecret, now.Add(-30*time.Second))
+	if step, ok := totp.Verify(rfcSecret, prev, now); !ok || step != totp.Step(now)-1 {
+		t.Errorf("previous step: %d, %v", step, ok)
+	}
+	old, _ := totp.Code(rfcSecret, now.Add(-90*time.Second))
+	if _, ok := totp.Verify(rfcSecret, old, now); ok {
+		t.Error("code from three steps ago accepted")
+	}
+	for _, bad := range []string{"", "12345", "1234567", "abcdef"} {
+		if _, ok := totp.Verify(rfcSecret, bad, now); ok {
+			t.Errorf("accepted %q", bad)
+		}
+	}
+	if _, ok := totp.Verify("not base32!", "050471", now); ok {
+		t.Error("accepted code for an invalid secret")
+	}
+}
+
+func TestSecretAndURI(t *testing.T) {
+	secret, err := totp.GenerateSecret()
+	if err != nil || len(secret) != 32 {
+		t.Fatalf("GenerateSecret: %q, %v", secret, err)
+	}
+	code, _ := totp.Code(secret, time.Now())
+	if _, ok := totp.Verify(secret, code, time.Now()); !ok {
+		t.Error("generated secret does not verify its own code")
+	}
+	uri := totp.URI("agent-workspace", "alice", secret)
+	if !strings.HasPrefix(uri, "otpauth://totp/agent-workspace:alice?") || !strings.Contains(uri, "secret="+secret) {
+		t.Errorf("URI: %s", uri)
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, err := totp.RecoveryCodes(10)
+	if err != nil || len(codes) != 10 {
+		t.Fatalf("RecoveryCodes: %v, %v", codes, err)
+	}
+	seen := map[string]bool{}
+	for _, c := range codes {
+		if !totp.IsRecoveryCode(c) || seen[c] {
+			t.Errorf("bad or duplicate code %q", c)
+		}
+		seen[c] = true
+	}
+	if totp.IsRecoveryCode("123456") {
+		t.Error("a one-time password is not a recovery code")
+	}
+	c := codes[0]
+	if totp.HashRecoveryCode(c) != totp.HashRecoveryCode(strings.ToUpper(strings.ReplaceAll(c, "-", ""))) {
+		t.Error("hash should ignore case and dashes")
+	}
+}
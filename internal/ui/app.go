@@ -5,22 +5,28 @@ import (
 	"fmt"
 	"log/slog"
 	"os"
-	"path/filepath"
 	"strings"
 	"time"
 
 	"github.com/gdamore/tcell/v2"
 	"github.com/google/uuid"
 	"github.com/rivo/tview"
+	"github.com/zsprackett/agent-workspace/internal/audit"
+	"github.com/zsprackett/agent-workspace/internal/budget"
 	"github.com/zsprackett/agent-workspace/internal/claudeusage"
 	"github.com/zsprackett/agent-workspace/internal/config"
 	"github.com/zsprackett/agent-workspace/internal/db"
+	"github.com/zsprackett/agent-workspace/internal/events"
 	"github.com/zsprackett/agent-workspace/internal/git"
+	"github.com/zsprackett/agent-workspace/internal/hooks"
+	"github.com/zsprackett/agent-workspace/internal/metrics"
 	"github.com/zsprackett/agent-workspace/internal/monitor"
 	"github.com/zsprackett/agent-workspace/internal/notify"
 	"github.com/zsprackett/agent-workspace/internal/session"
+	"github.com/zsprackett/agent-workspace/internal/share"
 	"github.com/zsprackett/agent-workspace/internal/syncer"
 	"github.com/zsprackett/agent-workspace/internal/tmux"
+	"github.com/zsprackett/agent-workspace/internal/tokenusage"
 	"github.com/zsprackett/agent-workspace/internal/ui/dialogs"
 	"github.com/zsprackett/agent-workspace/internal/usagepoller"
 	"github.com/zsprackett/agent-workspace/internal/webserver"
@@ -34,10 +40,14 @@ type App struct {
 	mgr     *session.Manager
 	mon     *monitor.Monitor
 	syn     *syncer.Syncer
+	usage   *claudeusage.Client
 	poller  *usagepoller.Poller
+	tokens  *tokenusage.Collector
+	budget  *budget.Guard
 	cfg     config.Config
 	groups  []*db.Group
 	web     *webserver.Server
+	bus     *events.Bus
 	logger  *slog.Logger
 }
 
@@ -46,8 +56,10 @@ func NewApp(store *db.DB, cfg config.Config, logger *slog.Logger) *App {
 		store:  store,
 		cfg:    cfg,
 		mgr:    session.NewManager(store),
+		bus:    events.NewBus(),
 		logger: logger,
 	}
+	a.mgr.SetBroadcaster(a.bus)
 
 	a.tapp = tview.NewApplication()
 	a.pages = tview.NewPages()
@@ -60,9 +72,10 @@ func NewApp(store *db.DB, cfg config.Config, logger *slog.Logger) *App {
 	}, logger)
 
 	a.web = webserver.New(store, a.mgr, webserver.Config{
-		Enabled: cfg.Webserver.Enabled,
-		Port:    cfg.Webserver.Port,
-		Host:    cfg.Webserver.Host,
+		Enabled:   cfg.Webserver.Enabled,
+		Port:      cfg.Webserver.Port,
+		Host:      cfg.Webserver.Host,
+		PublicURL: cfg.Webserver.PublicURL,
 		TLS: webserver.TLSConfig{
 			Mode:     cfg.Webserver.TLS.Mode,
 			Domain:   cfg.Webserver.TLS.Domain,
@@ -73,20 +86,62 @@ func NewApp(store *db.DB, cfg config.Config, logger *slog.Logger) *App {
 		Auth: webserver.AuthConfig{
 			JWTSecret:       cfg.Webserver.Auth.JWTSecret,
 			RefreshTokenTTL: cfg.Webserver.Auth.RefreshTokenTTL,
+			RequireTOTP:     cfg.Webserver.Auth.RequireTOTP,
 		},
 		ReposDir:          cfg.ReposDir,
 		WorktreesDir:      cfg.WorktreesDir,
 		DefaultBaseBranch: cfg.Worktree.DefaultBaseBranch,
+		MetricsAddr:       cfg.Webserver.MetricsAddr,
 	})
+	a.budget = budget.New(budget.Config{
+		WarnAt:         cfg.Budget.WarnAt,
+		PauseAt:        cfg.Budget.PauseAt,
+		ThrottleAt:     cfg.Budget.ThrottleAt,
+		ThrottleAction: cfg.Budget.ThrottleAction,
+		KeepRunning:    cfg.Budget.KeepRunning,
+	}, store, a.mgr, logger)
+	a.budget.SetNotifier(notifier)
+	a.budget.SetBroadcaster(a.bus)
+	a.web.SetBus(a.bus)
+	a.web.SetBudget(a.budget)
+	a.home.SetBudget(a.budget.Status)
+
+	// The list only needs the latest state, so the TUI may skip events when
+	// it falls behind; notifications and the audit log may not. The event
+	// webhook is best-effort too, so a down endpoint cannot grow its queue
+	// without bound or hold up shutdown.
+	a.bus.Subscribe("tui", false, func(e events.Event) {
+		if e.Type != events.Heartbeat {
+			a.tapp.QueueUpdateDraw(a.refreshHome)
+		}
+	})
+	a.bus.Subscribe("notify", true, notifier.HandleEvent)
+	a.bus.Subscribe("audit", true, audit.HandleEvent(store))
+	a.bus.Subscribe("budget", true, a.budget.HandleEvent)
+	a.bus.Subscribe("metrics", true, metrics.HandleEvent)
+	if url := cfg.Notifications.EventWebhook; url != "" {
+		webhook := notify.NewEventWebhook(url, cfg.Notifications.EventTypes, logger)
+		a.bus.Subscribe("webhook", false, webhook.HandleEvent)
+	}
 
-	a.mon = monitor.New(store, func() {
-		a.tapp.QueueUpdateDraw(func() {
-			a.refreshHome()
-		})
-	}, notifier, a.web, logger)
+	a.mon = monitor.New(store, a.bus, logger)
 
 	a.syn = syncer.New(store, cfg.ReposDir, logger)
-	a.poller = usagepoller.New(store, 10*time.Minute, logger)
+	gcInterval, _ := time.ParseDuration(cfg.GC.Interval)
+	a.syn.SetGC(syncer.GCConfig{
+		WorktreesDir: cfg.WorktreesDir,
+		Interval:     gcInterval,
+		AutoPrune:    cfg.GC.AutoPrune,
+	})
+	a.syn.SetBroadcaster(a.bus)
+	a.usage = claudeusage.New(claudeusage.Config{
+		BaseURL:  cfg.Usage.BaseURL,
+		TokenURL: cfg.Usage.TokenURL,
+		Sources:  claudeusage.Sources(cfg.Usage.TokenCommand, cfg.Usage.CredentialsFile),
+	}, logger)
+	a.poller = usagepoller.New(a.usage, store, 10*time.Minute, logger)
+	a.poller.SetBroadcaster(a.bus)
+	a.tokens = tokenusage.New(store, time.Minute, logger)
 
 	a.pages.AddPage("home", a.home, true, true)
 	a.tapp.SetRoot(a.pages, true).EnableMouse(false)
@@ -108,7 +163,10 @@ func NewApp(store *db.DB, cfg config.Config, logger *slog.Logger) *App {
 		a.onMove,
 		a.onAttach,
 		a.onNotes,
+		a.onFork,
 		a.onUsage,
+		a.onReport,
+		a.onShare,
 		func() { a.tapp.Stop() },
 	)
 
@@ -134,24 +192,35 @@ func (a *App) Run() error {
 	// Clean up sessions left in creating/deleting state from a previous crash.
 	if stale, err := a.store.LoadSessionsByStatus(db.StatusCreating, db.StatusDeleting); err == nil && len(stale) > 0 {
 		for _, s := range stale {
-			if s.Status == db.StatusDeleting && s.WorktreePath != "" && s.WorktreeRepo != "" {
+			if s.Status == db.StatusDeleting {
 				// Best-effort force removal; ignore error.
-				_ = git.RemoveWorktree(s.WorktreeRepo, s.WorktreePath, true)
+				_ = session.RemoveWorktrees(a.store, s, true)
 			}
 			_ = a.store.DeleteSession(s.ID)
 		}
 		_ = a.store.Touch()
 	}
 
+	// Deferred first so it runs last, once nothing publishes any more.
+	defer a.bus.Close()
+
 	a.refreshHome()
 	a.mon.Start()
 	defer a.mon.Stop()
 
+	if l, err := hooks.Listen(config.HookSocketPath(), a.mon.ReportHook); err != nil {
+		a.logger.Warn("claude hooks disabled", "err", err)
+	} else {
+		defer l.Close()
+	}
+
 	a.syn.Start()
 	defer a.syn.Stop()
 
 	a.poller.Start()
 	defer a.poller.Stop()
+	a.tokens.Start()
+	defer a.tokens.Stop()
 
 	return a.tapp.Run()
 }
@@ -188,11 +257,15 @@ func (a *App) showHelp() {
 }
 
 func (a *App) onNew(groupPath string) {
+	if err := a.budget.CheckCreate(); err != nil {
+		a.showError(err.Error())
+		return
+	}
 	if groupPath == "" {
 		groupPath = a.cfg.DefaultGroup
 	}
 	groups, _ := a.store.LoadGroups()
-	form := dialogs.NewSessionDialog(groups, a.cfg.DefaultTool, groupPath,
+	form := dialogs.NewSessionDialog(groups, a.cfg.DefaultTool, groupPath, a.groupBranches,
 		func(result dialogs.NewSessionResult) {
 			a.closeDialog("new-session")
 			opts := session.CreateOptions{
@@ -201,12 +274,16 @@ func (a *App) onNew(groupPath string) {
 				Command:   result.Command,
 				GroupPath: result.GroupPath,
 			}
-			// Look up the selected group's repo URL and pre-launch command.
+			// Look up the selected group's repo URL, base branch and pre-launch command.
 			var groupRepoURL string
+			var groupBaseBranch string
+			var groupExtraRepos []string
 			var preLaunchCmd string
 			for _, g := range groups {
 				if g.Path == result.GroupPath {
 					groupRepoURL = g.RepoURL
+					groupBaseBranch = g.BaseBranch
+					groupExtraRepos = g.ExtraRepoURLs
 					preLaunchCmd = g.PreLaunchCommand
 					break
 				}
@@ -228,23 +305,35 @@ func (a *App) onNew(groupPath string) {
 				}
 				s, err := a.mgr.Create(opts)
 				if err != nil {
+					a.audit(audit.SessionCreate, "", opts.Title, err)
 					a.showError(fmt.Sprintf("Create failed: %v", err))
 					return
 				}
+				a.audit(audit.SessionCreate, s.ID, s.Title, nil)
 				a.refreshHome()
 				a.onAttachSession(s)
 			}
 			if groupRepoURL != "" {
-				host, owner, repo, err := git.ParseRepoURL(groupRepoURL)
-				if err != nil {
-					a.showError(fmt.Sprintf("Invalid group repo URL: %v", err))
-					return
-				}
-				// Resolve title before inserting so branch name matches.
+				// Resolve title before planning so the derived branch name matches.
 				title := result.Title
 				if title == "" {
 					title = session.GenerateTitle()
 				}
+				plan, err := session.PlanWorktree(session.WorktreeOptions{
+					RepoURL:           groupRepoURL,
+					ExtraRepoURLs:     groupExtraRepos,
+					ReposDir:          a.cfg.ReposDir,
+					WorktreesDir:      a.cfg.WorktreesDir,
+					Branch:            result.Branch,
+					Checkout:          result.Checkout,
+					BaseBranch:        result.BaseBranch,
+					GroupBaseBranch:   groupBaseBranch,
+					DefaultBaseBranch: a.cfg.Worktree.DefaultBaseBranch,
+				}, title)
+				if err != nil {
+					a.showError(err.Error())
+					return
+				}
 				opts.Title = title
 				command := opts.Command
 				if command == "" {
@@ -254,9 +343,8 @@ func (a *App) onNew(groupPath string) {
 				// Insert a pending row immediately so the session appears in the list.
 				sessions, _ := a.store.LoadSessions()
 				now := time.Now()
-				sessionID := uuid.NewString()
-				pending := &db.Session{
-					ID:           sessionID,
+				a.createWorktreeSession(&db.Session{
+					ID:           uuid.NewString(),
 					Title:        title,
 					GroupPath:    result.GroupPath,
 					Tool:         result.Tool,
@@ -266,146 +354,235 @@ func (a *App) onNew(groupPath string) {
 					LastAccessed: now,
 					SortOrder:    len(sessions),
 					RepoURL:      groupRepoURL,
-				}
-				if err := a.store.SaveSession(pending); err != nil {
-					a.showError(fmt.Sprintf("Create failed: %v", err))
-					return
-				}
-				_ = a.store.InsertSessionEvent(sessionID, "created", "")
-				a.store.Touch()
-				a.refreshHome()
-
-				bareRepoPath := git.BareRepoPath(a.cfg.ReposDir, host, owner, repo)
-				branch := git.SanitizeBranchName(title)
-				wtPath := git.WorktreePath(a.cfg.WorktreesDir, host, owner, repo, branch)
+				}, plan, preLaunchCmd, nil)
+			} else {
+				// Non-worktree path: unchanged.
+				opts.ProjectPath = result.ProjectPath
+				createSession()
+			}
+		},
+		func() { a.closeDialog("new-session") },
+	)
+	a.showDialog("new-session", form, 60, 25)
+}
 
-				cancelCreate := func(msg string) {
-					a.store.DeleteSession(sessionID)
-					_ = a.store.Touch()
-					a.refreshHome()
-					if msg != "" {
-						a.showError(msg)
-					}
-				}
+// createWorktreeSession inserts pending as a "creating" row so it shows up in
+// the list at once, then provisions plan's worktrees, runs the group's
+// pre-launch command and starts the tmux session, with env set, in the
+// background. The session is attached once it is running.
+func (a *App) createWorktreeSession(pending *db.Session, plan *session.WorktreePlan, preLaunchCmd string, env map[string]string) {
+	sessionID := pending.ID
+	err := a.store.SaveSession(pending)
+	if pending.ParentSessionID != "" {
+		a.audit(audit.SessionFork, pending.ParentSessionID, pending.Title, err)
+	} else {
+		a.audit(audit.SessionCreate, sessionID, pending.Title, err)
+	}
+	if err != nil {
+		a.showError(fmt.Sprintf("Create failed: %v", err))
+		return
+	}
+	if pending.ParentSessionID != "" {
+		_ = a.store.InsertSessionEvent(sessionID, "forked", pending.ParentSessionID)
+	} else {
+		_ = a.store.InsertSessionEvent(sessionID, "created", "")
+	}
+	a.store.Touch()
+	created := *pending
+	a.bus.Broadcast(events.Event{Type: events.SessionCreated, SessionID: sessionID, Session: &created})
+	a.refreshHome()
 
-				go func() {
-					// 1. Ensure bare repo directory exists.
-					if err := os.MkdirAll(filepath.Dir(bareRepoPath), 0755); err != nil {
-						a.tapp.QueueUpdateDraw(func() {
-							cancelCreate(fmt.Sprintf("Create repos dir failed: %v", err))
-						})
-						return
-					}
+	bareRepoPath := plan.RepoPath
+	branch := plan.Branch
+	rootPath := plan.Root
 
-					// 2. Clone or fetch the bare repo.
-					if !git.IsBareRepo(bareRepoPath) {
-						if err := git.CloneBare(groupRepoURL, bareRepoPath); err != nil {
-							a.tapp.QueueUpdateDraw(func() {
-								cancelCreate(fmt.Sprintf("Clone failed: %v", err))
-							})
-							return
-						}
-					} else {
-						if err := git.FetchBare(bareRepoPath); err != nil {
-							a.tapp.QueueUpdateDraw(func() {
-								cancelCreate(fmt.Sprintf("Fetch failed: %v", err))
-							})
-							return
-						}
-					}
+	progress := func(step, msg string) {
+		a.bus.Broadcast(events.Event{Type: events.ProvisioningProgress, SessionID: sessionID, Title: pending.Title, Step: step, Message: msg})
+	}
+	cancelCreate := func(msg string) {
+		if msg != "" {
+			progress(events.StepFailed, msg)
+		}
+		a.store.DeleteSession(sessionID)
+		_ = a.store.Touch()
+		a.bus.Broadcast(events.Event{Type: events.SessionDeleted, SessionID: sessionID})
+		a.refreshHome()
+		if msg != "" {
+			a.showError(msg)
+		}
+	}
 
-					// 3. Ensure worktree parent directory exists.
-					if err := os.MkdirAll(filepath.Dir(wtPath), 0755); err != nil {
-						a.tapp.QueueUpdateDraw(func() {
-							cancelCreate(fmt.Sprintf("Create worktrees dir failed: %v", err))
+	go func() {
+		progress(events.StepWorktree, "")
+		// 1-4. Clone or fetch the bare repo and create the worktree;
+		// handle the "already exists" case via a channel.
+		if err := plan.Provision(); err != nil {
+			if errors.Is(err, git.ErrWorktreeExists) {
+				reuseCh := make(chan bool, 1)
+				a.tapp.QueueUpdateDraw(func() {
+					modal := tview.NewModal().
+						SetText(fmt.Sprintf("Worktree for branch '%s' already exists.\n\nReuse it or cancel?", branch)).
+						AddButtons([]string{"Reuse", "Cancel"}).
+						SetDoneFunc(func(_ int, label string) {
+							a.closeDialog("worktree-exists")
+							reuseCh <- (label == "Reuse")
 						})
-						return
-					}
+					a.pages.AddPage("worktree-exists", modal, true, true)
+				})
+				if !<-reuseCh {
+					plan.Cleanup()
+					a.tapp.QueueUpdateDraw(func() { cancelCreate("") })
+					return
+				}
+				// else: fall through and use the existing worktree
+			} else {
+				plan.Cleanup()
+				a.tapp.QueueUpdateDraw(func() {
+					cancelCreate(fmt.Sprintf("Create worktree failed: %v", err))
+				})
+				return
+			}
+		}
 
-					// 4. Create the worktree; handle the "already exists" case via a channel.
-					if _, err := git.CreateWorktree(bareRepoPath, branch, wtPath, a.cfg.Worktree.DefaultBaseBranch); err != nil {
-						if errors.Is(err, git.ErrWorktreeExists) {
-							reuseCh := make(chan bool, 1)
-							a.tapp.QueueUpdateDraw(func() {
-								modal := tview.NewModal().
-									SetText(fmt.Sprintf("Worktree for branch '%s' already exists.\n\nReuse it or cancel?", branch)).
-									AddButtons([]string{"Reuse", "Cancel"}).
-									SetDoneFunc(func(_ int, label string) {
-										a.closeDialog("worktree-exists")
-										reuseCh <- (label == "Reuse")
-									})
-								a.pages.AddPage("worktree-exists", modal, true, true)
-							})
-							if !<-reuseCh {
-								a.tapp.QueueUpdateDraw(func() { cancelCreate("") })
-								return
-							}
-							// else: fall through and use the existing worktree
-						} else {
-							a.tapp.QueueUpdateDraw(func() {
-								cancelCreate(fmt.Sprintf("Create worktree failed: %v", err))
-							})
-							return
-						}
-					}
+		// Claude reports its state through hooks; without them the monitor
+		// reads the pane.
+		if pending.Tool == db.ToolClaude {
+			if err := hooks.Install(rootPath, sessionID); err != nil {
+				a.logger.Warn("install claude hooks", "session", pending.Title, "err", err)
+			}
+		}
 
-					// 5. Run pre-launch command if set.
-					if preLaunchCmd != "" {
-						toolCmd := db.ToolCommand(opts.Tool, opts.Command)
-						out, err := session.RunPreLaunchCommand(preLaunchCmd, toolCmd, bareRepoPath, wtPath)
-						if err != nil {
-							a.tapp.QueueUpdateDraw(func() {
-								cancelCreate(fmt.Sprintf("Pre-launch command failed: %v\n%s", err, out))
-							})
-							return
-						}
-					}
+		// 5. Run pre-launch command if set.
+		if preLaunchCmd != "" {
+			progress(events.StepPreLaunch, preLaunchCmd)
+			toolCmd := db.ToolCommand(pending.Tool, pending.Command)
+			out, err := session.RunPreLaunchCommand(preLaunchCmd, toolCmd, bareRepoPath, rootPath)
+			if err != nil {
+				a.tapp.QueueUpdateDraw(func() {
+					cancelCreate(fmt.Sprintf("Pre-launch command failed: %v\n%s", err, out))
+				})
+				return
+			}
+		}
 
-					// 6. Create the tmux session.
-					tmuxName := tmux.GenerateSessionName(title)
-					if err := tmux.CreateSession(tmux.CreateOptions{
-						Name:    tmuxName,
-						Command: command,
-						Cwd:     wtPath,
-					}); err != nil {
-						a.tapp.QueueUpdateDraw(func() {
-							cancelCreate(fmt.Sprintf("Create failed: %v", err))
-						})
-						return
-					}
+		// 6. Create the tmux session.
+		progress(events.StepStarting, "")
+		tmuxName := tmux.GenerateSessionName(pending.Title)
+		if err := tmux.CreateSession(tmux.CreateOptions{
+			Name:    tmuxName,
+			Command: pending.Command,
+			Cwd:     rootPath,
+			Env:     env,
+		}); err != nil {
+			a.tapp.QueueUpdateDraw(func() {
+				cancelCreate(fmt.Sprintf("Create failed: %v", err))
+			})
+			return
+		}
 
-					// 7. Update the DB row to running.
-					pending.TmuxSession = tmuxName
-					pending.Status = db.StatusRunning
-					pending.ProjectPath = wtPath
-					pending.WorktreePath = wtPath
-					pending.WorktreeRepo = bareRepoPath
-					pending.WorktreeBranch = branch
-					pending.LastAccessed = time.Now()
-					if err := a.store.SaveSession(pending); err != nil {
-						// tmux session was created; kill it to avoid orphan.
-						tmux.KillSession(tmuxName)
-						a.tapp.QueueUpdateDraw(func() {
-							cancelCreate(fmt.Sprintf("Save failed: %v", err))
-						})
-						return
-					}
-					_ = a.store.Touch()
+		// 7. Update the DB row to running.
+		pending.TmuxSession = tmuxName
+		pending.Status = db.StatusRunning
+		pending.ProjectPath = rootPath
+		pending.WorktreePath = plan.WorktreePath
+		pending.WorktreeRepo = bareRepoPath
+		pending.WorktreeBranch = branch
+		pending.LastAccessed = time.Now()
+		err := a.store.SaveSession(pending)
+		if err == nil && plan.MultiRepo() {
+			err = a.store.SaveSessionWorktrees(sessionID, plan.Worktrees())
+		}
+		if err != nil {
+			// tmux session was created; kill it to avoid orphan.
+			tmux.KillSession(tmuxName)
+			a.tapp.QueueUpdateDraw(func() {
+				cancelCreate(fmt.Sprintf("Save failed: %v", err))
+			})
+			return
+		}
+		_ = a.store.Touch()
+		progress(events.StepReady, "")
+		a.bus.Broadcast(events.SessionState(a.store, sessionID))
 
-					a.tapp.QueueUpdateDraw(func() {
-						a.refreshHome()
-						a.onAttachSession(pending)
-					})
-				}()
-			} else {
-				// Non-worktree path: unchanged.
-				opts.ProjectPath = result.ProjectPath
-				createSession()
+		a.tapp.QueueUpdateDraw(func() {
+			a.refreshHome()
+			a.onAttachSession(pending)
+		})
+	}()
+}
+
+// onFork creates a new session whose worktrees branch from the selected
+// session's current HEAD, optionally carrying over its uncommitted changes.
+func (a *App) onFork(item listItem) {
+	if item.session == nil {
+		return
+	}
+	parent := item.session
+	if parent.WorktreePath == "" {
+		a.showError("Only sessions with a worktree can be forked")
+		return
+	}
+	if err := a.budget.CheckCreate(); err != nil {
+		a.showError(err.Error())
+		return
+	}
+	form := dialogs.ForkDialog(parent.Title, session.DefaultForkTitle(parent), parent.HasUncommitted,
+		func(result dialogs.ForkResult) {
+			a.closeDialog("fork")
+			plan, err := session.PlanFork(a.store, parent, session.ForkOptions{
+				ReposDir:     a.cfg.ReposDir,
+				WorktreesDir: a.cfg.WorktreesDir,
+				Branch:       result.Branch,
+				CopyChanges:  result.CopyChanges,
+			}, result.Title)
+			if err != nil {
+				a.showError(err.Error())
+				return
 			}
+			var preLaunchCmd string
+			for _, g := range a.groups {
+				if g.Path == parent.GroupPath {
+					preLaunchCmd = g.PreLaunchCommand
+					break
+				}
+			}
+			opts := session.ForkCreateOptions(parent, result.Title)
+			sessions, _ := a.store.LoadSessions()
+			now := time.Now()
+			a.createWorktreeSession(&db.Session{
+				ID:              uuid.NewString(),
+				Title:           opts.Title,
+				GroupPath:       opts.GroupPath,
+				Tool:            opts.Tool,
+				Command:         opts.Command,
+				Status:          db.StatusCreating,
+				CreatedAt:       now,
+				LastAccessed:    now,
+				SortOrder:       len(sessions),
+				RepoURL:         opts.RepoURL,
+				ParentSessionID: opts.ParentSessionID,
+				Notes:           opts.Notes,
+			}, plan, preLaunchCmd, opts.Env)
 		},
-		func() { a.closeDialog("new-session") },
+		func() { a.closeDialog("fork") },
 	)
-	a.showDialog("new-session", form, 60, 20)
+	a.showDialog("fork", form, 60, 11)
+}
+
+// groupBranches returns the remote branches of a group's repo for the new
+// session dialog's autocomplete.
+func (a *App) groupBranches(groupPath string) []string {
+	groups, _ := a.store.LoadGroups()
+	for _, g := range groups {
+		if g.Path == groupPath && g.RepoURL != "" {
+			branches, err := session.RemoteBranches(a.cfg.ReposDir, g.RepoURL)
+			if err != nil {
+				a.logger.Warn("list remote branches", "group", groupPath, "err", err)
+			}
+			return branches
+		}
+	}
+	return nil
 }
 
 func (a *App) onDelete(item listItem) {
@@ -421,8 +598,10 @@ func (a *App) onDelete(item listItem) {
 				a.mgr.MoveToGroup(s.ID, "my-sessions")
 			}
 		}
-		a.store.DeleteGroup(item.group.Path)
+		err := a.store.DeleteGroup(item.group.Path)
+		a.audit(audit.GroupDelete, item.group.Path, item.group.Name, err)
 		a.store.Touch()
+		a.bus.Broadcast(events.Event{Type: events.Refresh})
 		a.refreshHome()
 	} else if item.session != nil {
 		doDelete := func() {
@@ -435,7 +614,7 @@ func (a *App) onDelete(item listItem) {
 
 			// Sessions without a worktree have no slow git work; delete synchronously.
 			if s.WorktreePath == "" || s.WorktreeRepo == "" {
-				a.mgr.Delete(s.ID)
+				a.audit(audit.SessionDelete, s.ID, s.Title, a.mgr.Delete(s.ID))
 				a.refreshHome()
 				return
 			}
@@ -446,18 +625,22 @@ func (a *App) onDelete(item listItem) {
 			_ = a.store.WriteStatus(s.ID, db.StatusDeleting, s.Tool)
 			_ = a.store.InsertSessionEvent(s.ID, "deleting", "")
 			a.store.Touch()
+			a.bus.Broadcast(events.SessionState(a.store, s.ID))
 			a.refreshHome()
 
 			finishDelete := func() {
+				a.audit(audit.SessionDelete, s.ID, s.Title, nil)
 				_ = a.store.DeleteSession(s.ID)
 				_ = a.store.InsertSessionEvent(s.ID, "deleted", "")
 				_ = a.store.Touch()
+				a.bus.Broadcast(events.Event{Type: events.SessionDeleted, SessionID: s.ID})
 				a.refreshHome()
 			}
 
 			restoreStatus := func(errMsg string) {
 				_ = a.store.WriteStatus(s.ID, prevStatus, s.Tool)
 				a.store.Touch()
+				a.bus.Broadcast(events.SessionState(a.store, s.ID))
 				a.refreshHome()
 				if errMsg != "" {
 					a.showError(errMsg)
@@ -465,7 +648,7 @@ func (a *App) onDelete(item listItem) {
 			}
 
 			go func() {
-				err := git.RemoveWorktree(s.WorktreeRepo, s.WorktreePath, false)
+				err := session.RemoveWorktrees(a.store, s, false)
 				if err == nil {
 					a.tapp.QueueUpdateDraw(finishDelete)
 					return
@@ -483,8 +666,9 @@ func (a *App) onDelete(item listItem) {
 								return
 							}
 							go func() {
-								if err2 := git.RemoveWorktree(s.WorktreeRepo, s.WorktreePath, true); err2 != nil {
+								if err2 := session.RemoveWorktrees(a.store, s, true); err2 != nil {
 									a.tapp.QueueUpdateDraw(func() {
+										a.audit(audit.SessionDelete, s.ID, s.Title, err2)
 										restoreStatus(fmt.Sprintf("Force delete failed: %v", err2))
 									})
 									return
@@ -512,7 +696,7 @@ func (a *App) onDelete(item listItem) {
 
 func (a *App) onStop(item listItem) {
 	if item.session != nil {
-		a.mgr.Stop(item.session.ID)
+		a.audit(audit.SessionStop, item.session.ID, item.session.Title, a.mgr.Stop(item.session.ID))
 		a.refreshHome()
 	}
 }
@@ -522,7 +706,9 @@ func (a *App) onRestart(item listItem) {
 		return
 	}
 	doRestart := func() {
-		if err := a.mgr.Restart(item.session.ID); err != nil {
+		err := a.mgr.Restart(item.session.ID)
+		a.audit(audit.SessionRestart, item.session.ID, item.session.Title, err)
+		if err != nil {
 			a.showError(fmt.Sprintf("Restart failed: %v", err))
 			return
 		}
@@ -547,7 +733,7 @@ func (a *App) onRestart(item listItem) {
 
 func (a *App) onEdit(item listItem) {
 	if item.isGroup {
-		form := dialogs.GroupDialog("Edit Group", item.group.Name, item.group.RepoURL, string(item.group.DefaultTool), item.group.PreLaunchCommand,
+		form := dialogs.GroupDialog("Edit Group", item.group.Name, item.group.RepoURL, string(item.group.DefaultTool), item.group.PreLaunchCommand, item.group.BaseBranch, item.group.ExtraRepoURLs, item.group.Checkpoints,
 			func(result dialogs.GroupResult) {
 				a.closeDialog("edit")
 				groups, _ := a.store.LoadGroups()
@@ -557,25 +743,31 @@ func (a *App) onEdit(item listItem) {
 						g.RepoURL = result.RepoURL
 						g.DefaultTool = db.Tool(result.DefaultTool)
 						g.PreLaunchCommand = result.PreLaunchCommand
+						g.BaseBranch = result.BaseBranch
+						g.ExtraRepoURLs = result.ExtraRepoURLs
+						g.Checkpoints = result.Checkpoints
 					}
 				}
-				a.store.SaveGroups(groups)
+				a.audit(audit.GroupEdit, item.group.Path, result.Name, a.store.SaveGroups(groups))
 				a.store.Touch()
+				a.bus.Broadcast(events.Event{Type: events.Refresh})
 				a.refreshHome()
 			}, func() { a.closeDialog("edit") })
-		a.showDialog("edit", form, 65, 14)
+		a.showDialog("edit", form, 65, 20)
 	} else if item.session != nil {
 		groups, _ := a.store.LoadGroups()
 		form := dialogs.EditSessionDialog(item.session, groups,
 			func(result dialogs.EditSessionResult) {
 				a.closeDialog("edit")
-				if err := a.mgr.Update(item.session.ID, session.UpdateOptions{
+				err := a.mgr.Update(item.session.ID, session.UpdateOptions{
 					Title:       result.Title,
 					Tool:        result.Tool,
 					Command:     result.Command,
 					ProjectPath: result.ProjectPath,
 					GroupPath:   result.GroupPath,
-				}); err != nil {
+				})
+				a.audit(audit.SessionEdit, item.session.ID, result.Title, err)
+				if err != nil {
 					a.showError(fmt.Sprintf("Edit failed: %v", err))
 					return
 				}
@@ -586,7 +778,7 @@ func (a *App) onEdit(item listItem) {
 }
 
 func (a *App) onNewGroup() {
-	form := dialogs.GroupDialog("New Group", "", "", "", "", func(result dialogs.GroupResult) {
+	form := dialogs.GroupDialog("New Group", "", "", "", "", "", nil, false, func(result dialogs.GroupResult) {
 		a.closeDialog("new-group")
 		path := strings.ToLower(strings.ReplaceAll(result.Name, " ", "-"))
 		groups, _ := a.store.LoadGroups()
@@ -598,12 +790,16 @@ func (a *App) onNewGroup() {
 			RepoURL:          result.RepoURL,
 			DefaultTool:      db.Tool(result.DefaultTool),
 			PreLaunchCommand: result.PreLaunchCommand,
+			BaseBranch:       result.BaseBranch,
+			ExtraRepoURLs:    result.ExtraRepoURLs,
+			Checkpoints:      result.Checkpoints,
 		})
-		a.store.SaveGroups(groups)
+		a.audit(audit.GroupCreate, path, result.Name, a.store.SaveGroups(groups))
 		a.store.Touch()
+		a.bus.Broadcast(events.Event{Type: events.Refresh})
 		a.refreshHome()
 	}, func() { a.closeDialog("new-group") })
-	a.showDialog("new-group", form, 65, 14)
+	a.showDialog("new-group", form, 65, 20)
 }
 
 func (a *App) onNotes(item listItem) {
@@ -614,8 +810,12 @@ func (a *App) onNotes(item listItem) {
 	form := dialogs.NotesDialog(s.Title, s.Notes,
 		func(notes string) {
 			a.closeDialog("notes")
-			a.store.UpdateSessionNotes(s.ID, notes)
+			err := a.store.UpdateSessionNotes(s.ID, notes)
+			a.audit(audit.SessionNotes, s.ID, s.Title, err)
 			a.store.Touch()
+			if err == nil {
+				a.bus.Broadcast(events.Event{Type: events.NotesUpdated, SessionID: s.ID, Notes: &notes})
+			}
 			a.refreshHome()
 		},
 		func() { a.closeDialog("notes") },
@@ -630,7 +830,8 @@ func (a *App) onMove(item listItem) {
 	groups, _ := a.store.LoadGroups()
 	list := dialogs.MoveDialog(groups, func(groupPath string) {
 		a.closeDialog("move")
-		a.mgr.MoveToGroup(item.session.ID, groupPath)
+		a.audit(audit.SessionMove, item.session.ID, item.session.Title+" → "+groupPath,
+			a.mgr.MoveToGroup(item.session.ID, groupPath))
 		a.refreshHome()
 	}, func() { a.closeDialog("move") })
 	a.showDialog("move", list, 40, 15)
@@ -647,12 +848,73 @@ func (a *App) onAttach(item listItem) {
 }
 
 func (a *App) onAttachSession(s *db.Session) {
+	a.audit(audit.TerminalOpen, s.ID, s.Title, nil)
 	a.tapp.Suspend(func() {
 		a.mgr.Attach(s.ID)
 	})
 	a.refreshHome()
 }
 
+// onShare shows the session's read-only share links and lets the user
+// create and revoke them.
+func (a *App) onShare(item listItem) {
+	if item.session == nil {
+		return
+	}
+	if !a.cfg.Webserver.Enabled {
+		a.showError("Share links are served by the web server, which is disabled.")
+		return
+	}
+	s := item.session
+	secret := a.cfg.Webserver.Auth.JWTSecret
+	var dialog *dialogs.ShareDialog
+	reload := func() {
+		links, _ := a.store.ListShareLinks(s.ID)
+		items := make([]dialogs.ShareLink, len(links))
+		for i, l := range links {
+			items[i] = dialogs.ShareLink{
+				ID:        l.ID,
+				URL:       a.web.BaseURL() + share.Path(share.Token(secret, l)),
+				CreatedBy: l.CreatedBy,
+				ExpiresAt: l.ExpiresAt,
+			}
+		}
+		dialog.SetLinks(items)
+	}
+	dialog = dialogs.NewShareDialog(s.Title,
+		func(ttl time.Duration) {
+			l, _, err := share.Create(a.store, secret, s.ID, audit.LocalUser(), ttl)
+			detail := ttl.String()
+			if l != nil {
+				detail = "link " + l.ID + " for " + ttl.String()
+			}
+			a.audit(audit.ShareCreate, s.ID, detail, err)
+			if err != nil {
+				a.closeDialog("share")
+				a.showError(fmt.Sprintf("Share link failed: %v", err))
+				return
+			}
+			reload()
+		},
+		func(id string) {
+			l, err := a.store.GetShareLink(id)
+			if err == nil {
+				err = share.Revoke(a.store, l, audit.LocalUser())
+			}
+			a.audit(audit.ShareRevoke, s.ID, "link "+id, err)
+			reload()
+		},
+		func() { a.closeDialog("share") },
+	)
+	reload()
+	a.showDialog("share", dialog, 100, 24)
+}
+
+// audit records a TUI action by the local user in the audit log.
+func (a *App) audit(action, target, detail string, err error) {
+	audit.Local(a.store, audit.SourceTUI, action, target, detail, err)
+}
+
 func (a *App) showError(msg string) {
 	modal := tview.NewModal().
 		SetText(msg).
@@ -668,7 +930,8 @@ func (a *App) onUsage() {
 	dialog = dialogs.NewUsageDialog(a.store, a.tapp,
 		func() { a.closeDialog("usage") },
 		func() {
-			usage, err := claudeusage.FetchUsage()
+			a.tokens.Collect()
+			usage, err := a.usage.FetchUsage()
 			if err != nil {
 				a.tapp.QueueUpdateDraw(func() {
 					a.closeDialog("usage")
@@ -687,13 +950,20 @@ func (a *App) onUsage() {
 				ExtraUsedCredits:  usage.ExtraUsage.UsedCredits,
 				ExtraUtilization:  usage.ExtraUsage.Utilization,
 			}
-			_ = a.store.InsertUsageSnapshot(snap)
+			if a.store.InsertUsageSnapshot(snap) == nil {
+				a.bus.Broadcast(events.Event{Type: events.UsageUpdated, Usage: &snap})
+			}
 			a.tapp.QueueUpdateDraw(func() {
 				dialog.Reload()
 			})
 		},
 	)
-	a.showDialog("usage", dialog, 60, 24)
+	a.showDialog("usage", dialog, 84, 40)
+}
+
+func (a *App) onReport() {
+	dialog := dialogs.NewAnalyticsDialog(a.store, config.ReportsDir(), func() { a.closeDialog("analytics") })
+	a.showDialog("analytics", dialog, 90, 40)
 }
 
 func parseResetsAt(s string) int64 {
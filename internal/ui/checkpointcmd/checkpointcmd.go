@@ -0,0 +1,289 @@
+package checkpointcmd
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+	"github.com/zsprackett/agent-workspace/internal/audit"
+	"github.com/zsprackett/agent-workspace/internal/checkpoint"
+	"github.com/zsprackett/agent-workspace/internal/config"
+	"github.com/zsprackett/agent-workspace/internal/db"
+	"github.com/zsprackett/agent-workspace/internal/gitdiff"
+	"github.com/zsprackett/agent-workspace/internal/session"
+)
+
+// renderFiles formats parsed diff files for a dynamic-color TextView.
+func renderFiles(files []*gitdiff.File) string {
+	if len(files) == 0 {
+		return "[gray](no changes)[-]\n"
+	}
+	var sb strings.Builder
+	for _, f := range files {
+		name := f.Path()
+		if f.Status == gitdiff.StatusRenamed || f.Status == gitdiff.StatusCopied {
+			name = f.OldPath + " → " + f.NewPath
+		}
+		fmt.Fprintf(&sb, "[::b]%s (%s)[::-]\n", tview.Escape(name), f.Status)
+		if f.Binary {
+			sb.WriteString("[gray]Binary file[-]\n\n")
+			continue
+		}
+		for _, h := range f.Hunks {
+			fmt.Fprintf(&sb, "[aqua]%s[-]\n", tview.Escape(h.Header))
+			for _, l := range h.Lines {
+				esc := tview.Escape(l.Content)
+				switch l.Kind {
+				case gitdiff.LineAdd:
+					fmt.Fprintf(&sb, "[green]+%s[-]\n", esc)
+				case gitdiff.LineDelete:
+					fmt.Fprintf(&sb, "[red]-%s[-]\n", esc)
+				default:
+					sb.WriteString(" " + esc + "\n")
+				}
+			}
+		}
+		sb.WriteString("\n")
+	}
+	return sb.String()
+}
+
+// Run opens a tview popup listing the checkpoints of the session identified
+// by its tmux session name, with the selected checkpoint's diff against the
+// previous one or the current worktree. Intended to be called inside a tmux
+// display-popup.
+func Run(tmuxSession string) error {
+	store, err := db.Open(config.DBPath())
+	if err != nil {
+		return fmt.Errorf("open db: %w", err)
+	}
+	defer store.Close()
+
+	s, err := store.GetSessionByTmuxName(tmuxSession)
+	if err != nil || s == nil {
+		return fmt.Errorf("session not found: %s", tmuxSession)
+	}
+
+	var dirs []string
+	for _, wt := range session.Worktrees(store, s) {
+		dirs = append(dirs, wt.WorktreePath)
+	}
+	if len(dirs) == 0 && s.ProjectPath != "" {
+		dirs = []string{s.ProjectPath}
+	}
+	if len(dirs) == 0 {
+		return fmt.Errorf("session has no working directory")
+	}
+	dirIdx := 0
+	againstCurrent := false
+	var cps []checkpoint.Checkpoint
+
+	app := tview.NewApplication()
+
+	// --- left pane: checkpoint timeline ---
+	table := tview.NewTable()
+	table.SetBorder(true).SetTitleAlign(tview.AlignLeft)
+	table.SetBackgroundColor(tcell.ColorDefault)
+	table.SetSelectable(true, false)
+
+	// --- right pane: diff viewer ---
+	diff := tview.NewTextView()
+	diff.SetDynamicColors(true).
+		SetScrollable(true).
+		SetWrap(false)
+	diff.SetBorder(true).SetTitleAlign(tview.AlignLeft)
+	diff.SetBackgroundColor(tcell.ColorDefault)
+
+	const hintText = "  [::d][Tab][-] switch pane   [::d][↑↓][-] navigate   [::d][j/k][-] scroll diff   " +
+		"[::d][v][-] vs previous/current   [::d][s][-] checkpoint now   [::d][r][-] restore   [::d][Esc][-] close"
+	hint := tview.NewTextView().
+		SetDynamicColors(true).
+		SetText(hintText)
+	hint.SetBackgroundColor(tcell.ColorDefault)
+	setStatus := func(msg string) {
+		hint.SetText(fmt.Sprintf("  [yellow]%s[-] %s", tview.Escape(msg), hintText))
+	}
+
+	showCheckpoint := func(n int) {
+		from, to := strconv.Itoa(n-1), strconv.Itoa(n)
+		title := fmt.Sprintf(" Checkpoint %d → %d ", n-1, n)
+		if n == 1 {
+			from, title = "1^", " Commit → checkpoint 1 "
+		}
+		if againstCurrent {
+			from, to = strconv.Itoa(n), "current"
+			title = fmt.Sprintf(" Checkpoint %d → current worktree ", n)
+		}
+		diff.SetTitle(title)
+		diff.Clear()
+		files, err := checkpoint.Diff(dirs[dirIdx], s.ID, from, to)
+		if err != nil {
+			fmt.Fprintf(diff, "[red]error: %s[-]\n", tview.Escape(err.Error()))
+			return
+		}
+		fmt.Fprint(diff, renderFiles(files))
+		diff.ScrollToBeginning()
+	}
+
+	load := func(selectN int) {
+		title := fmt.Sprintf(" %s ", s.Title)
+		if len(dirs) > 1 {
+			title = fmt.Sprintf(" %s: %s ", s.Title, filepath.Base(dirs[dirIdx]))
+		}
+		table.SetTitle(title)
+		table.Clear()
+		diff.Clear()
+		cps, err = checkpoint.List(dirs[dirIdx], s.ID)
+		if err != nil {
+			setStatus(fmt.Sprintf("error: %v", err))
+			return
+		}
+		if len(cps) == 0 {
+			table.SetCell(0, 0, tview.NewTableCell("No checkpoints").
+				SetTextColor(tcell.ColorGray))
+			return
+		}
+		row := 0
+		for i, cp := range cps {
+			table.SetCell(i, 0, tview.NewTableCell(fmt.Sprintf("#%d ", cp.N)).
+				SetTextColor(tcell.ColorYellow))
+			table.SetCell(i, 1, tview.NewTableCell(cp.Message+" "))
+			table.SetCell(i, 2, tview.NewTableCell(cp.Time.Format("Jan 2 15:04")).
+				SetTextColor(tcell.ColorGray).
+				SetAlign(tview.AlignRight))
+			if cp.N == selectN {
+				row = i
+			}
+		}
+		table.Select(row, 0)
+		showCheckpoint(cps[row].N)
+	}
+
+	table.SetSelectionChangedFunc(func(row, _ int) {
+		if row >= 0 && row < len(cps) {
+			showCheckpoint(cps[row].N)
+		}
+	})
+
+	// --- j/k scrolling in diff pane ---
+	diff.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		row, col := diff.GetScrollOffset()
+		switch event.Rune() {
+		case 'j':
+			diff.ScrollTo(row+1, col)
+			return nil
+		case 'k':
+			if row > 0 {
+				diff.ScrollTo(row-1, col)
+			}
+			return nil
+		}
+		return event
+	})
+
+	panes := tview.NewFlex().
+		AddItem(table, 50, 0, true).
+		AddItem(diff, 0, 1, false)
+	root := tview.NewFlex().SetDirection(tview.FlexRow).
+		AddItem(panes, 0, 1, true).
+		AddItem(hint, 1, 0, false)
+	pages := tview.NewPages().AddPage("main", root, true, true)
+
+	confirming := false
+	restore := func(n int) {
+		modal := tview.NewModal().
+			SetText(fmt.Sprintf("Restore the worktree to checkpoint %d?\nThe current state is saved as a new checkpoint first.", n)).
+			AddButtons([]string{"Restore", "Cancel"}).
+			SetDoneFunc(func(_ int, label string) {
+				confirming = false
+				pages.RemovePage("confirm")
+				app.SetFocus(table)
+				if label != "Restore" {
+					return
+				}
+				_, err := checkpoint.Restore(dirs[dirIdx], s.ID, n)
+				audit.Local(store, audit.SourceTUI, audit.CheckpointRestore, s.ID,
+					fmt.Sprintf("%s #%d", s.Title, n), err)
+				if err != nil {
+					setStatus(fmt.Sprintf("error: %v", err))
+					return
+				}
+				store.InsertSessionEvent(s.ID, "checkpoint_restored",
+					fmt.Sprintf(`{"dir":%q,"n":%d}`, dirs[dirIdx], n))
+				setStatus(fmt.Sprintf("restored checkpoint %d", n))
+				load(n)
+			})
+		confirming = true
+		pages.AddPage("confirm", modal, true, true)
+		app.SetFocus(modal)
+	}
+
+	focusedLeft := true
+	app.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		if confirming {
+			return event
+		}
+		selected := func() (int, bool) {
+			row, _ := table.GetSelection()
+			if row < 0 || row >= len(cps) {
+				return 0, false
+			}
+			return cps[row].N, true
+		}
+		switch event.Rune() {
+		case 'v':
+			againstCurrent = !againstCurrent
+			if n, ok := selected(); ok {
+				showCheckpoint(n)
+			}
+			return nil
+		case 's':
+			cp, err := checkpoint.Create(dirs[dirIdx], s.ID, "")
+			audit.Local(store, audit.SourceTUI, audit.CheckpointCreate, s.ID, s.Title, err)
+			switch {
+			case err != nil:
+				setStatus(fmt.Sprintf("error: %v", err))
+			case cp == nil:
+				setStatus("no changes since the latest checkpoint")
+			default:
+				setStatus(fmt.Sprintf("created checkpoint %d", cp.N))
+				load(cp.N)
+			}
+			return nil
+		case 'r':
+			if n, ok := selected(); ok {
+				restore(n)
+			}
+			return nil
+		case 'R':
+			if len(dirs) > 1 {
+				dirIdx = (dirIdx + 1) % len(dirs)
+				load(0)
+			}
+			return nil
+		}
+		switch event.Key() {
+		case tcell.KeyEscape:
+			app.Stop()
+			return nil
+		case tcell.KeyTab:
+			focusedLeft = !focusedLeft
+			if focusedLeft {
+				app.SetFocus(table)
+			} else {
+				app.SetFocus(diff)
+			}
+			return nil
+		}
+		return event
+	})
+
+	load(0)
+	if len(dirs) > 1 {
+		setStatus("R: next repo")
+	}
+	return app.SetRoot(pages, true).EnableMouse(false).Run()
+}
@@ -0,0 +1,179 @@
+package comparecmd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+	"github.com/zsprackett/agent-workspace/internal/compare"
+	"github.com/zsprackett/agent-workspace/internal/config"
+	"github.com/zsprackett/agent-workspace/internal/db"
+)
+
+// colorDiff escapes diff output for a dynamic-color TextView and colors
+// added, deleted, hunk and header lines.
+func colorDiff(diff string) string {
+	var sb strings.Builder
+	for _, line := range strings.Split(diff, "\n") {
+		esc := tview.Escape(line)
+		switch {
+		case strings.HasPrefix(line, "+++") || strings.HasPrefix(line, "---"),
+			strings.HasPrefix(line, "diff --git"):
+			fmt.Fprintf(&sb, "[::b]%s[::-]\n", esc)
+		case strings.HasPrefix(line, "+"):
+			fmt.Fprintf(&sb, "[green]%s[-]\n", esc)
+		case strings.HasPrefix(line, "-"):
+			fmt.Fprintf(&sb, "[red]%s[-]\n", esc)
+		case strings.HasPrefix(line, "@@"):
+			fmt.Fprintf(&sb, "[aqua]%s[-]\n", esc)
+		default:
+			sb.WriteString(esc + "\n")
+		}
+	}
+	return sb.String()
+}
+
+// Run opens a tview popup comparing the session identified by its tmux
+// session name with a sibling session on the same repo. Intended to be called
+// inside a tmux display-popup.
+func Run(tmuxSession string) error {
+	store, err := db.Open(config.DBPath())
+	if err != nil {
+		return fmt.Errorf("open db: %w", err)
+	}
+	defer store.Close()
+
+	s, err := store.GetSessionByTmuxName(tmuxSession)
+	if err != nil || s == nil {
+		return fmt.Errorf("session not found: %s", tmuxSession)
+	}
+
+	var siblings []*db.Session
+	if s.WorktreeRepo != "" {
+		siblings, _ = compare.Siblings(store, s)
+	}
+
+	app := tview.NewApplication()
+
+	// --- left pane: sibling sessions ---
+	table := tview.NewTable()
+	table.SetBorder(true).
+		SetTitle(fmt.Sprintf(" Compare %s with ", s.Title)).
+		SetTitleAlign(tview.AlignLeft)
+	table.SetBackgroundColor(tcell.ColorDefault)
+	table.SetSelectable(true, false)
+
+	// --- top-right pane: per-side summary and per-file stats ---
+	summary := tview.NewTextView()
+	summary.SetScrollable(true).SetWrap(false)
+	summary.SetBorder(true).
+		SetTitle(" Summary ").
+		SetTitleAlign(tview.AlignLeft)
+	summary.SetBackgroundColor(tcell.ColorDefault)
+
+	// --- bottom-right pane: diff between the branches ---
+	diff := tview.NewTextView()
+	diff.SetDynamicColors(true).
+		SetScrollable(true).
+		SetWrap(false)
+	diff.SetBorder(true).
+		SetTitle(" Diff ").
+		SetTitleAlign(tview.AlignLeft)
+	diff.SetBackgroundColor(tcell.ColorDefault)
+
+	showCompare := func(other *db.Session) {
+		summary.Clear()
+		diff.Clear()
+		res, err := compare.Sessions(store, s, other)
+		if err != nil {
+			fmt.Fprintf(summary, "error: %v\n", err)
+			return
+		}
+		fmt.Fprint(summary, compare.Summary(res))
+		summary.ScrollToBeginning()
+		var sb strings.Builder
+		for _, rd := range res.Repos {
+			if rd.Repo != "" {
+				fmt.Fprintf(&sb, "[::b]=== %s ===[::-]\n", tview.Escape(rd.Repo))
+			}
+			sb.WriteString(colorDiff(rd.Diff))
+		}
+		diff.SetText(sb.String())
+		diff.ScrollToBeginning()
+	}
+
+	if len(siblings) == 0 {
+		table.SetCell(0, 0, tview.NewTableCell("No other sessions on this repo").
+			SetTextColor(tcell.ColorGray))
+	} else {
+		for i, o := range siblings {
+			table.SetCell(i, 0, tview.NewTableCell(o.Title+" ").
+				SetSelectable(true))
+			table.SetCell(i, 1, tview.NewTableCell(o.WorktreeBranch).
+				SetTextColor(tcell.ColorGray).
+				SetSelectable(true))
+		}
+		table.SetSelectionChangedFunc(func(row, _ int) {
+			if row >= 0 && row < len(siblings) {
+				showCompare(siblings[row])
+			}
+		})
+		table.Select(0, 0)
+		showCompare(siblings[0])
+	}
+
+	// --- j/k scrolling in diff pane ---
+	diff.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		row, col := diff.GetScrollOffset()
+		switch event.Rune() {
+		case 'j':
+			diff.ScrollTo(row+1, col)
+			return nil
+		case 'k':
+			if row > 0 {
+				diff.ScrollTo(row-1, col)
+			}
+			return nil
+		}
+		return event
+	})
+
+	// --- layout ---
+	right := tview.NewFlex().SetDirection(tview.FlexRow).
+		AddItem(summary, 0, 1, false).
+		AddItem(diff, 0, 3, false)
+
+	panes := tview.NewFlex().
+		AddItem(table, 40, 0, true).
+		AddItem(right, 0, 1, false)
+
+	hint := tview.NewTextView().
+		SetDynamicColors(true).
+		SetText("  [::d][Tab][-] switch pane   [::d][↑↓][-] choose session   [::d][j/k][-] scroll diff   [::d][Esc][-] close")
+	hint.SetBackgroundColor(tcell.ColorDefault)
+
+	root := tview.NewFlex().SetDirection(tview.FlexRow).
+		AddItem(panes, 0, 1, true).
+		AddItem(hint, 1, 0, false)
+
+	focusedLeft := true
+	app.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		switch event.Key() {
+		case tcell.KeyEscape:
+			app.Stop()
+			return nil
+		case tcell.KeyTab:
+			focusedLeft = !focusedLeft
+			if focusedLeft {
+				app.SetFocus(table)
+			} else {
+				app.SetFocus(diff)
+			}
+			return nil
+		}
+		return event
+	})
+
+	return app.SetRoot(root, true).EnableMouse(false).Run()
+}
@@ -0,0 +1,198 @@
+package dialogs
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+	"github.com/zsprackett/agent-workspace/internal/analytics"
+	"github.com/zsprackett/agent-workspace/internal/db"
+)
+
+// analyticsPeriods are the report lengths, in days, that P cycles through.
+var analyticsPeriods = []int{7, 30, 1}
+
+// AnalyticsDialog shows how long sessions spent running, waiting for a
+// human and idle, how quickly waiting prompts were answered, and how many
+// sessions were created and completed each day.
+type AnalyticsDialog struct {
+	*tview.TextView
+	store      *db.DB
+	reportsDir string
+	period     int // index into analyticsPeriods
+	report     *analytics.Report
+	status     string
+}
+
+// NewAnalyticsDialog creates an analytics dialog that reads session events
+// from the DB. E writes the report as CSV files into reportsDir. onClose is
+// called when the user presses Q or Escape.
+func NewAnalyticsDialog(store *db.DB, reportsDir string, onClose func()) *AnalyticsDialog {
+	d := &AnalyticsDialog{
+		TextView:   tview.NewTextView(),
+		store:      store,
+		reportsDir: reportsDir,
+	}
+	d.SetBorder(true).SetTitle(" Session Analytics ").SetTitleAlign(tview.AlignLeft)
+	d.SetDynamicColors(true)
+	d.SetBackgroundColor(tcell.ColorDefault)
+
+	d.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		switch {
+		case event.Key() == tcell.KeyEscape, event.Rune() == 'q', event.Rune() == 'Q':
+			onClose()
+			return nil
+		case event.Rune() == 'p', event.Rune() == 'P':
+			d.period = (d.period + 1) % len(analyticsPeriods)
+			d.status = ""
+			d.Reload()
+			return nil
+		case event.Rune() == 'e', event.Rune() == 'E':
+			d.status = d.export()
+			d.render()
+			return nil
+		}
+		return event
+	})
+
+	d.Reload()
+	return d
+}
+
+// Reload recomputes the report and redisplays it.
+func (d *AnalyticsDialog) Reload() {
+	rep, err := analytics.Compute(d.store, analyticsPeriods[d.period])
+	if err != nil {
+		d.report = nil
+		d.status = fmt.Sprintf("[red]%s[-]", tview.Escape(err.Error()))
+	} else {
+		d.report = rep
+	}
+	d.render()
+}
+
+func (d *AnalyticsDialog) render() {
+	var sb strings.Builder
+	if rep := d.report; rep != nil {
+		days := analyticsPeriods[d.period]
+		t := rep.Total
+		fmt.Fprintf(&sb, "\n  [yellow]Last %d %s[-]\n", days, plural(days, "day", "days"))
+		fmt.Fprintf(&sb, "  Running %s   Waiting %s   Idle %s\n",
+			formatSpan(t.RunningSec), formatSpan(t.WaitingSec), formatSpan(t.IdleSec))
+		fmt.Fprintf(&sb, "  Blocked on you %s of active time", formatBlocked(t.BlockedShare()))
+		if t.Responses > 0 {
+			fmt.Fprintf(&sb, ", %d %s answered in %s on average",
+				t.Responses, plural(t.Responses, "prompt", "prompts"), formatSpan(t.AvgResponseSec))
+		}
+		sb.WriteString("\n")
+
+		if len(rep.Sessions) == 0 {
+			sb.WriteString("\n  [dim]No status changes recorded in this period.[-]\n")
+		} else {
+			sb.WriteString("\n  [yellow]Sessions, most blocked first[-]\n")
+			fmt.Fprintf(&sb, "  [dim]%-20s %8s %8s %8s %8s %7s[-]\n", "", "running", "waiting", "idle", "avg resp", "blocked")
+			for i, s := range rep.Sessions {
+				if i == topSessions {
+					fmt.Fprintf(&sb, "  [dim]and %d more[-]\n", len(rep.Sessions)-topSessions)
+					break
+				}
+				title := s.Title
+				if title == "" {
+					title = s.ID
+				}
+				if s.Deleted {
+					title += " †"
+				}
+				fmt.Fprintf(&sb, "  %-20s %s\n", tview.Escape(truncate(title, 20)), statsRow(s.Stats))
+			}
+			sb.WriteString("\n  [yellow]Groups[-]\n")
+			for _, g := range rep.Groups {
+				fmt.Fprintf(&sb, "  %-20s %s\n", tview.Escape(truncate(g.Group, 20)), statsRow(g.Stats))
+			}
+		}
+
+		sb.WriteString("\n  [yellow]Created / completed per day[-]\n")
+		for _, day := range rep.Days {
+			date, _ := time.ParseInLocation(time.DateOnly, day.Date, time.Local)
+			fmt.Fprintf(&sb, "  %s  %3d  %3d\n", date.Format("Mon Jan 2"), day.Created, day.Completed)
+		}
+	}
+	if d.status != "" {
+		fmt.Fprintf(&sb, "\n  %s\n", d.status)
+	}
+	sb.WriteString("\n  [green]P[-] period  [green]E[-] export CSV  [green]Q/Esc[-] close\n")
+	d.SetText(sb.String())
+	d.ScrollToBeginning()
+}
+
+// export writes each table of the report to its own CSV file and returns
+// a line saying where.
+func (d *AnalyticsDialog) export() string {
+	if d.report == nil {
+		return "[red]Nothing to export.[-]"
+	}
+	if err := os.MkdirAll(d.reportsDir, 0o755); err != nil {
+		return fmt.Sprintf("[red]Export failed: %s[-]", tview.Escape(err.Error()))
+	}
+	stamp := d.report.Until.Local().Format("20060102-150405")
+	for _, table := range analytics.Tables {
+		path := filepath.Join(d.reportsDir, fmt.Sprintf("analytics-%s-%s.csv", stamp, table))
+		f, err := os.Create(path)
+		if err == nil {
+			err = d.report.WriteCSV(f, table)
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+		}
+		if err != nil {
+			return fmt.Sprintf("[red]Export failed: %s[-]", tview.Escape(err.Error()))
+		}
+	}
+	return fmt.Sprintf("[green]Exported to %s/analytics-%s-*.csv[-]", tview.Escape(d.reportsDir), stamp)
+}
+
+func statsRow(s analytics.Stats) string {
+	resp := "-"
+	if s.Responses > 0 {
+		resp = formatSpan(s.AvgResponseSec)
+	}
+	return fmt.Sprintf("%8s %8s %8s %8s %7s",
+		formatSpan(s.RunningSec), formatSpan(s.WaitingSec), formatSpan(s.IdleSec), resp, formatBlocked(s.BlockedShare()))
+}
+
+// formatSpan abbreviates a duration in seconds: 45s, 12m, 3h05m, 2d4h.
+func formatSpan(sec float64) string {
+	d := time.Duration(sec) * time.Second
+	switch {
+	case d >= 24*time.Hour:
+		return fmt.Sprintf("%dd%dh", int(d.Hours())/24, int(d.Hours())%24)
+	case d >= time.Hour:
+		return fmt.Sprintf("%dh%02dm", int(d.Hours()), int(d.Minutes())%60)
+	case d >= time.Minute:
+		return fmt.Sprintf("%dm", int(d.Minutes()))
+	}
+	return fmt.Sprintf("%ds", int(d.Seconds()))
+}
+
+// formatBlocked formats the share of active time spent waiting, colored
+// like utilization.
+func formatBlocked(share float64) string {
+	color := "green"
+	if share >= 0.5 {
+		color = "red"
+	} else if share >= 0.25 {
+		color = "yellow"
+	}
+	return fmt.Sprintf("[%s]%5.1f%%[-]", color, share*100)
+}
+
+func plural(n int, one, many string) string {
+	if n == 1 {
+		return one
+	}
+	return many
+}
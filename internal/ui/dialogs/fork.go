@@ -0,0 +1,45 @@
+package dialogs
+
+import (
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+)
+
+type ForkResult struct {
+	Title       string
+	Branch      string
+	CopyChanges bool
+}
+
+// ForkDialog asks for the title and branch of a fork of the session titled
+// parentTitle, and whether to carry over its uncommitted changes.
+func ForkDialog(parentTitle, defaultTitle string, dirty bool, onSubmit func(ForkResult), onCancel func()) *tview.Form {
+	form := tview.NewForm()
+	form.SetBorder(true).SetTitle(" Fork " + parentTitle + " ").SetTitleAlign(tview.AlignLeft)
+	form.SetBackgroundColor(tcell.ColorDefault)
+	form.SetFieldBackgroundColor(tcell.ColorDefault)
+
+	form.AddInputField("Title", defaultTitle, 40, nil, nil)
+	form.AddInputField("Branch (optional)", "", 40, nil, nil)
+	form.AddCheckbox("Copy uncommitted changes", dirty, nil)
+	form.AddButton("Fork", func() {
+		title := form.GetFormItemByLabel("Title").(*tview.InputField).GetText()
+		if title == "" {
+			return
+		}
+		onSubmit(ForkResult{
+			Title:       title,
+			Branch:      form.GetFormItemByLabel("Branch (optional)").(*tview.InputField).GetText(),
+			CopyChanges: form.GetFormItemByLabel("Copy uncommitted changes").(*tview.Checkbox).IsChecked(),
+		})
+	})
+	form.AddButton("Cancel", onCancel)
+	form.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		if event.Key() == tcell.KeyEscape {
+			onCancel()
+			return nil
+		}
+		return event
+	})
+	return form
+}
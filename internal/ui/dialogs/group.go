@@ -1,6 +1,8 @@
 package dialogs
 
 import (
+	"strings"
+
 	"github.com/gdamore/tcell/v2"
 	"github.com/rivo/tview"
 )
@@ -10,9 +12,12 @@ type GroupResult struct {
 	RepoURL          string
 	DefaultTool      string
 	PreLaunchCommand string
+	BaseBranch       string
+	ExtraRepoURLs    []string
+	Checkpoints      bool
 }
 
-func GroupDialog(title, currentName, currentRepoURL, currentDefaultTool, currentPreLaunchCommand string, onSubmit func(GroupResult), onCancel func()) *tview.Form {
+func GroupDialog(title, currentName, currentRepoURL, currentDefaultTool, currentPreLaunchCommand, currentBaseBranch string, currentExtraRepoURLs []string, currentCheckpoints bool, onSubmit func(GroupResult), onCancel func()) *tview.Form {
 	form := tview.NewForm()
 	form.SetBorder(true).SetTitle(" " + title + " ").SetTitleAlign(tview.AlignLeft)
 	form.SetBackgroundColor(tcell.ColorDefault)
@@ -30,12 +35,19 @@ func GroupDialog(title, currentName, currentRepoURL, currentDefaultTool, current
 
 	form.AddInputField("Group name", currentName, 40, nil, nil)
 	form.AddInputField("GitHub URL (optional)", currentRepoURL, 50, nil, nil)
+	form.AddInputField("Extra repo URLs (optional)", strings.Join(currentExtraRepoURLs, ", "), 50, nil, nil)
+	form.AddInputField("Base branch (optional)", currentBaseBranch, 30, nil, nil)
 	form.AddDropDown("Default Tool", toolLabels, currentToolIdx, nil)
 	form.AddInputField("Pre-launch command (optional)", currentPreLaunchCommand, 50, nil, nil)
+	form.AddCheckbox("Checkpoints", currentCheckpoints, nil)
 	form.AddButton("OK", func() {
 		name := form.GetFormItemByLabel("Group name").(*tview.InputField).GetText()
 		if name != "" {
 			repoURL := form.GetFormItemByLabel("GitHub URL (optional)").(*tview.InputField).GetText()
+			baseBranch := form.GetFormItemByLabel("Base branch (optional)").(*tview.InputField).GetText()
+			// Extra repos make new sessions multi-repo; accept comma or space separation.
+			extraRepos := strings.Fields(strings.ReplaceAll(
+				form.GetFormItemByLabel("Extra repo URLs (optional)").(*tview.InputField).GetText(), ",", " "))
 			_, toolLabel := form.GetFormItemByLabel("Default Tool").(*tview.DropDown).GetCurrentOption()
 			defaultTool := ""
 			for i, l := range toolLabels {
@@ -45,7 +57,8 @@ func GroupDialog(title, currentName, currentRepoURL, currentDefaultTool, current
 				}
 			}
 			prelaunch := form.GetFormItemByLabel("Pre-launch command (optional)").(*tview.InputField).GetText()
-			onSubmit(GroupResult{Name: name, RepoURL: repoURL, DefaultTool: defaultTool, PreLaunchCommand: prelaunch})
+			checkpoints := form.GetFormItemByLabel("Checkpoints").(*tview.Checkbox).IsChecked()
+			onSubmit(GroupResult{Name: name, RepoURL: repoURL, DefaultTool: defaultTool, PreLaunchCommand: prelaunch, BaseBranch: baseBranch, ExtraRepoURLs: extraRepos, Checkpoints: checkpoints})
 		}
 	})
 	form.AddButton("Cancel", onCancel)
@@ -17,10 +17,13 @@ const helpText = `[yellow]Dashboard Keys[-]
   [green]s[-]        Stop session
   [green]x[-]        Restart session
   [green]e[-]        Edit session or group
+  [green]f[-]        Fork session from its current state
   [green]g[-]        New group
   [green]m[-]        Move session to group
+  [green]v[-]        Share a read-only viewer link
   [green]1-9[-]      Jump to group
   [green]u[-]        Claude usage stats
+  [green]r[-]        Session analytics report
   [green]?[-]        This help
   [green]q[-]        Quit
 
@@ -31,6 +34,9 @@ const helpText = `[yellow]Dashboard Keys[-]
   [yellow]Menu keys[-]
   [green]s[-]         Git status
   [green]d[-]         Git diff
+  [green]h[-]         Git history
+  [green]c[-]         Compare with sibling session
+  [green]k[-]         Checkpoints
   [green]p[-]         Open pull request in browser
   [green]n[-]         Session notes
   [green]t[-]         Open terminal split
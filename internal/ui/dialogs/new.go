@@ -1,6 +1,8 @@
 package dialogs
 
 import (
+	"strings"
+
 	"github.com/gdamore/tcell/v2"
 	"github.com/rivo/tview"
 	"github.com/zsprackett/agent-workspace/internal/db"
@@ -12,6 +14,9 @@ type NewSessionResult struct {
 	Command     string
 	ProjectPath string
 	GroupPath   string
+	BaseBranch  string
+	Branch      string
+	Checkout    bool
 }
 
 // resolveGroupTool returns the tool to pre-select for a given group.
@@ -31,9 +36,27 @@ func resolveGroupTool(groups []*db.Group, groupPath, cfgDefault string) string {
 	return "claude"
 }
 
+// filterBranches returns the branches containing current (case-insensitive),
+// or nil when current is empty so the autocomplete list stays closed.
+func filterBranches(branches []string, current string) []string {
+	if current == "" {
+		return nil
+	}
+	needle := strings.ToLower(current)
+	var out []string
+	for _, b := range branches {
+		if strings.Contains(strings.ToLower(b), needle) {
+			out = append(out, b)
+		}
+	}
+	return out
+}
+
 // NewSessionDialog shows a form to create a new session.
-// onSubmit is called with the result; onCancel on Escape.
+// branchesFor returns the remote branches of a group's repo for autocomplete
+// and may be nil. onSubmit is called with the result; onCancel on Escape.
 func NewSessionDialog(groups []*db.Group, defaultTool string, defaultGroup string,
+	branchesFor func(groupPath string) []string,
 	onSubmit func(NewSessionResult), onCancel func()) *tview.Form {
 
 	form := tview.NewForm()
@@ -69,6 +92,44 @@ func NewSessionDialog(groups []*db.Group, defaultTool string, defaultGroup strin
 		form.AddDropDown("Group", groupNames, defaultGroupIdx, nil)
 	}
 
+	// selectedGroup returns the path of the group currently chosen in the form.
+	selectedGroup := func() string {
+		if len(groups) == 0 {
+			return defaultGroup
+		}
+		_, gName := form.GetFormItemByLabel("Group").(*tview.DropDown).GetCurrentOption()
+		for i, n := range groupNames {
+			if n == gName {
+				return groupPaths[i]
+			}
+		}
+		return defaultGroup
+	}
+
+	// Remote branches are listed once per group and cached for autocomplete.
+	branchCache := map[string][]string{}
+	autocomplete := func(current string) []string {
+		if branchesFor == nil {
+			return nil
+		}
+		g := selectedGroup()
+		branches, ok := branchCache[g]
+		if !ok {
+			branches = branchesFor(g)
+			branchCache[g] = branches
+		}
+		return filterBranches(branches, current)
+	}
+	form.AddFormItem(tview.NewInputField().
+		SetLabel("Base Branch (optional)").
+		SetFieldWidth(30).
+		SetAutocompleteFunc(autocomplete))
+	form.AddFormItem(tview.NewInputField().
+		SetLabel("Branch (optional)").
+		SetFieldWidth(30).
+		SetAutocompleteFunc(autocomplete))
+	form.AddCheckbox("Checkout existing branch", false, nil)
+
 	var commandShown bool
 	currentCmd := ""
 
@@ -125,16 +186,10 @@ func NewSessionDialog(groups []*db.Group, defaultTool string, defaultGroup strin
 		_, toolStr := form.GetFormItemByLabel("Tool").(*tview.DropDown).GetCurrentOption()
 		projectPath := form.GetFormItemByLabel("Project Path").(*tview.InputField).GetText()
 
-		groupPath := defaultGroup
-		if len(groups) > 0 {
-			_, gName := form.GetFormItemByLabel("Group").(*tview.DropDown).GetCurrentOption()
-			for i, n := range groupNames {
-				if n == gName {
-					groupPath = groupPaths[i]
-					break
-				}
-			}
-		}
+		groupPath := selectedGroup()
+		baseBranch := form.GetFormItemByLabel("Base Branch (optional)").(*tview.InputField).GetText()
+		branch := form.GetFormItemByLabel("Branch (optional)").(*tview.InputField).GetText()
+		checkout := form.GetFormItemByLabel("Checkout existing branch").(*tview.Checkbox).IsChecked()
 
 		command := ""
 		if commandShown {
@@ -147,6 +202,9 @@ func NewSessionDialog(groups []*db.Group, defaultTool string, defaultGroup strin
 			Command:     command,
 			ProjectPath: projectPath,
 			GroupPath:   groupPath,
+			BaseBranch:  strings.TrimSpace(baseBranch),
+			Branch:      strings.TrimSpace(branch),
+			Checkout:    checkout,
 		})
 	})
 
@@ -0,0 +1,92 @@
+package dialogs
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+)
+
+// ShareLink is a share link as shown in the share dialog.
+type ShareLink struct {
+	ID        string
+	URL       string
+	CreatedBy string
+	ExpiresAt time.Time
+}
+
+// shareDurations are the lifetimes offered for new links.
+var shareDurations = []struct {
+	label string
+	ttl   time.Duration
+}{
+	{"1 hour", time.Hour},
+	{"1 day", 24 * time.Hour},
+	{"7 days", 7 * 24 * time.Hour},
+}
+
+// ShareDialog shows a session's active read-only share links with their
+// URLs, and lets the user create new ones and revoke them.
+type ShareDialog struct {
+	*tview.Flex
+	urls     *tview.TextView
+	list     *tview.List
+	onCreate func(ttl time.Duration)
+	onRevoke func(id string)
+}
+
+// NewShareDialog creates a share dialog. onCreate is called with the
+// lifetime of a new link and onRevoke with the ID of a link to revoke; both
+// should call SetLinks with the updated links. onClose is called on Escape.
+func NewShareDialog(sessionTitle string, onCreate func(ttl time.Duration), onRevoke func(id string), onClose func()) *ShareDialog {
+	d := &ShareDialog{
+		Flex:     tview.NewFlex().SetDirection(tview.FlexRow),
+		urls:     tview.NewTextView(),
+		list:     tview.NewList(),
+		onCreate: onCreate,
+		onRevoke: onRevoke,
+	}
+	d.SetBorder(true).SetTitle(fmt.Sprintf(" Share: %s ", sessionTitle)).SetTitleAlign(tview.AlignLeft)
+	d.SetBackgroundColor(tcell.ColorDefault)
+	d.urls.SetDynamicColors(true).SetWrap(false)
+	d.urls.SetBackgroundColor(tcell.ColorDefault)
+	d.list.ShowSecondaryText(false)
+	d.list.SetBackgroundColor(tcell.ColorDefault)
+	d.AddItem(d.urls, 0, 1, false).AddItem(d.list, 8, 0, true)
+
+	d.list.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		if event.Key() == tcell.KeyEscape || event.Rune() == 'q' {
+			onClose()
+			return nil
+		}
+		return event
+	})
+	return d
+}
+
+// SetLinks shows links, newest first, and rebuilds the actions.
+func (d *ShareDialog) SetLinks(links []ShareLink) {
+	var sb strings.Builder
+	sb.WriteString("\n  Anyone with a link can watch this session read-only,\n")
+	sb.WriteString("  without an account, until it expires or is revoked.\n\n")
+	if len(links) == 0 {
+		sb.WriteString("  [yellow]No active links.[-]\n")
+	}
+	for _, l := range links {
+		fmt.Fprintf(&sb, "  [green]%s[-]  by %s, expires %s\n  %s\n\n",
+			l.ID, l.CreatedBy, l.ExpiresAt.Local().Format("Jan 2 15:04"), l.URL)
+	}
+	d.urls.SetText(sb.String())
+
+	d.list.Clear()
+	for _, sd := range shareDurations {
+		ttl := sd.ttl
+		d.list.AddItem("New link for "+sd.label, "", 0, func() { d.onCreate(ttl) })
+	}
+	for _, l := range links {
+		id := l.ID
+		d.list.AddItem("Revoke "+id, "", 0, func() { d.onRevoke(id) })
+	}
+}
@@ -12,6 +12,15 @@ import (
 
 const sparkChars = "▁▂▃▄▅▆▇█"
 
+const (
+	// usageWindow is the period session and group usage is totalled over.
+	usageWindow = 7 * 24 * time.Hour
+	// topSessions is how many of the most expensive sessions are listed.
+	topSessions = 10
+	// sessionSparkHours is how many hours each session's sparkline covers.
+	sessionSparkHours = 24
+)
+
 // UsageDialog is a tview.TextView-based dialog showing Claude Code usage stats.
 type UsageDialog struct {
 	*tview.TextView
@@ -58,7 +67,7 @@ func NewUsageDialog(store *db.DB, app *tview.Application, onClose func(), onRefr
 func (d *UsageDialog) Reload() {
 	latest, _ := d.store.GetLatestUsageSnapshot()
 	history, _ := d.store.GetUsageSnapshots(48)
-	d.SetText(d.buildText(latest, history))
+	d.SetText(d.buildText(latest, history) + d.buildSessionText())
 }
 
 func (d *UsageDialog) buildText(latest *db.UsageSnapshot, history []db.UsageSnapshot) string {
@@ -67,7 +76,7 @@ func (d *UsageDialog) buildText(latest *db.UsageSnapshot, history []db.UsageSnap
 	if latest == nil {
 		sb.WriteString("\n  [yellow]No usage data yet.[-]\n\n")
 		sb.WriteString("  Press [green]R[-] to fetch current usage from the API.\n")
-		sb.WriteString("\n  [dim]Press Q or Esc to close.[-]")
+		sb.WriteString("\n  [dim]Press Q or Esc to close.[-]\n")
 		return sb.String()
 	}
 
@@ -122,11 +131,102 @@ func (d *UsageDialog) buildText(latest *db.UsageSnapshot, history []db.UsageSnap
 
 	ts := time.UnixMilli(latest.TsMs).Local()
 	sb.WriteString(fmt.Sprintf("  [dim]Last updated: %s[-]\n", ts.Format("Jan 2 15:04:05")))
-	sb.WriteString("\n  [green]R[-] refresh  [green]Q/Esc[-] close")
+	sb.WriteString("\n  [green]R[-] refresh  [green]Q/Esc[-] close\n")
 
 	return sb.String()
 }
 
+// buildSessionText lists what the most expensive sessions and each group
+// used over the last week, from the sessions' Claude transcripts.
+func (d *UsageDialog) buildSessionText() string {
+	since := time.Now().Add(-usageWindow)
+	sessions, _ := d.store.SessionUsageTotals(since)
+	if len(sessions) == 0 {
+		return ""
+	}
+	groups, _ := d.store.GroupUsageTotals(since)
+
+	var sb strings.Builder
+	sb.WriteString("\n  [yellow]Sessions, last 7 days[-]  [dim](estimated at list prices; last 24h right)[-]\n")
+	for i, t := range sessions {
+		if i == topSessions {
+			fmt.Fprintf(&sb, "  [dim]and %d more[-]\n", len(sessions)-topSessions)
+			break
+		}
+		title := t.Title
+		if title == "" {
+			title = t.SessionID
+		}
+		fmt.Fprintf(&sb, "  %-20s %9s  %6s in %6s out  %s\n",
+			tview.Escape(truncate(title, 20)), formatCost(t.CostUSD),
+			formatTokens(t.InputTokens+t.CacheWriteTokens+t.CacheReadTokens), formatTokens(t.OutputTokens),
+			d.sessionSparkline(t.SessionID))
+	}
+
+	sb.WriteString("\n  [yellow]Groups, last 7 days[-]\n")
+	for _, t := range groups {
+		fmt.Fprintf(&sb, "  %-20s %9s  %6s in %6s out\n",
+			tview.Escape(truncate(t.GroupPath, 20)), formatCost(t.CostUSD),
+			formatTokens(t.InputTokens+t.CacheWriteTokens+t.CacheReadTokens), formatTokens(t.OutputTokens))
+	}
+	return sb.String()
+}
+
+// sessionSparkline shows a session's hourly cost over the last
+// sessionSparkHours hours, scaled to its busiest hour.
+func (d *UsageDialog) sessionSparkline(id string) string {
+	start := time.Now().Truncate(time.Hour).Add(-(sessionSparkHours - 1) * time.Hour)
+	usage, _ := d.store.GetSessionUsage(id, start)
+	costs := make([]float64, sessionSparkHours)
+	var peak float64
+	for _, u := range usage {
+		i := int(time.UnixMilli(u.HourMs).Sub(start) / time.Hour)
+		if i >= 0 && i < len(costs) {
+			costs[i] += u.CostUSD
+			peak = max(peak, costs[i])
+		}
+	}
+	runes := []rune(sparkChars)
+	var sb strings.Builder
+	for _, c := range costs {
+		if c == 0 {
+			sb.WriteRune(' ')
+			continue
+		}
+		sb.WriteRune(runes[int(c/peak*float64(len(runes)-1))])
+	}
+	return "[green]" + sb.String() + "[-]"
+}
+
+// formatCost formats an amount in US dollars.
+func formatCost(usd float64) string {
+	if usd > 0 && usd < 0.01 {
+		return "<$0.01"
+	}
+	return fmt.Sprintf("$%.2f", usd)
+}
+
+// formatTokens abbreviates a token count: 950, 12k, 3.4M.
+func formatTokens(n int64) string {
+	switch {
+	case n >= 1e6:
+		return fmt.Sprintf("%.1fM", float64(n)/1e6)
+	case n >= 1e4:
+		return fmt.Sprintf("%dk", n/1000)
+	case n >= 1e3:
+		return fmt.Sprintf("%.1fk", float64(n)/1e3)
+	}
+	return fmt.Sprintf("%d", n)
+}
+
+func truncate(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	return string(r[:n-1]) + "…"
+}
+
 // formatUtil formats a 0-1 utilization fraction as a colored percentage string.
 // Values > 1.0 (over limit) show as "[red]100%+ (OVER)[-]".
 func formatUtil(util float64) string {
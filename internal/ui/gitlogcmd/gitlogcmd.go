@@ -3,44 +3,34 @@ package gitlogcmd
 import (
 	"fmt"
 	"os/exec"
+	"path/filepath"
+	"strconv"
 	"strings"
 
 	"github.com/gdamore/tcell/v2"
 	"github.com/rivo/tview"
+	"github.com/zsprackett/agent-workspace/internal/audit"
 	"github.com/zsprackett/agent-workspace/internal/config"
 	"github.com/zsprackett/agent-workspace/internal/db"
+	"github.com/zsprackett/agent-workspace/internal/gitlog"
+	"github.com/zsprackett/agent-workspace/internal/review"
+	"github.com/zsprackett/agent-workspace/internal/session"
 )
 
-type commit struct {
-	Hash    string
-	Subject string
-	RelDate string
-}
-
-// parseLogLine parses a single line from:
-//
-//	git log --pretty=format:"%h\t%s\t%ar"
-func parseLogLine(line string) (commit, bool) {
-	parts := strings.SplitN(line, "\t", 3)
-	if len(parts) != 3 {
-		return commit{}, false
+// parseLines parses a line number or range such as "12" or "12-14".
+func parseLines(s string) (start, end int, ok bool) {
+	from, to, isRange := strings.Cut(strings.TrimSpace(s), "-")
+	start, err := strconv.Atoi(strings.TrimSpace(from))
+	if err != nil || start < 1 {
+		return 0, 0, false
 	}
-	return commit{Hash: parts[0], Subject: parts[1], RelDate: parts[2]}, true
-}
-
-func loadCommits(path string) ([]commit, error) {
-	out, err := exec.Command("git", "-C", path, "log",
-		"--pretty=format:%h\t%s\t%ar", "-n", "200").Output()
-	if err != nil {
-		return nil, err
-	}
-	var commits []commit
-	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
-		if c, ok := parseLogLine(line); ok {
-			commits = append(commits, c)
+	end = start
+	if isRange {
+		if end, err = strconv.Atoi(strings.TrimSpace(to)); err != nil || end < start {
+			return 0, 0, false
 		}
 	}
-	return commits, nil
+	return start, end, true
 }
 
 func truncate(s string, n int) string {
@@ -53,7 +43,7 @@ func truncate(s string, n int) string {
 
 // leftPaneWidth computes the minimum width needed to display the commit list
 // without truncating any column: 2 (border) + 8 (hash+space) + 39 (subject+space) + maxRelDate + 2 (buffer).
-func leftPaneWidth(commits []commit) int {
+func leftPaneWidth(commits []gitlog.Commit) int {
 	maxRelDate := 10
 	for _, c := range commits {
 		if l := len(c.RelDate); l > maxRelDate {
@@ -81,8 +71,13 @@ func Run(tmuxSession string) error {
 	if path == "" {
 		path = s.ProjectPath
 	}
+	// Multi-repo sessions label comments with the repo, as the web UI does.
+	var repo string
+	if len(session.Worktrees(store, s)) > 1 {
+		repo = filepath.Base(path)
+	}
 
-	commits, _ := loadCommits(path)
+	commits, _ := gitlog.Log(path, gitlog.Options{Limit: 200})
 
 	app := tview.NewApplication()
 
@@ -107,7 +102,7 @@ func Run(tmuxSession string) error {
 			SetTextColor(tcell.ColorGray))
 	} else {
 		for i, c := range commits {
-			table.SetCell(i, 0, tview.NewTableCell(c.Hash+" ").
+			table.SetCell(i, 0, tview.NewTableCell(c.Short+" ").
 				SetTextColor(tcell.ColorYellow).
 				SetSelectable(true))
 			table.SetCell(i, 1, tview.NewTableCell(truncate(c.Subject, 38)+" ").
@@ -132,8 +127,8 @@ func Run(tmuxSession string) error {
 	showCommit := func(hash string) {
 		// Update commit message pane.
 		msgView.Clear()
-		if msgOut, err := exec.Command("git", "-C", path, "log", "-1", "--pretty=format:%B", hash).Output(); err == nil {
-			fmt.Fprint(msgView, strings.TrimSpace(string(msgOut)))
+		if msg, err := gitlog.Message(path, hash); err == nil {
+			fmt.Fprint(msgView, msg)
 		}
 		msgView.ScrollToBeginning()
 
@@ -185,17 +180,104 @@ func Run(tmuxSession string) error {
 		AddItem(leftCol, leftPaneWidth(commits), 0, true).
 		AddItem(diff, 0, 1, false)
 
+	const hintText = "  [::d][Tab][-] switch pane   [::d][↑↓][-] navigate   [::d][j/k][-] scroll diff   " +
+		"[::d][c][-] comment   [::d][r][-] send review   [::d][Esc][-] close"
 	hint := tview.NewTextView().
 		SetDynamicColors(true).
-		SetText("  [::d][Tab][-] switch pane   [::d][↑↓][-] navigate   [::d][j/k][-] scroll diff   [::d][Esc][-] close")
+		SetText(hintText)
 	hint.SetBackgroundColor(tcell.ColorDefault)
+	setStatus := func(msg string) {
+		hint.SetText(fmt.Sprintf("  [yellow]%s[-] %s", tview.Escape(msg), hintText))
+	}
 
 	root := tview.NewFlex().SetDirection(tview.FlexRow).
 		AddItem(panes, 0, 1, true).
 		AddItem(hint, 1, 0, false)
+	pages := tview.NewPages().AddPage("main", root, true, true)
+
+	// --- review comment form for the selected commit ---
+	commenting := false
+	closeComment := func() {
+		commenting = false
+		pages.RemovePage("comment")
+		app.SetFocus(table)
+	}
+	openComment := func(c gitlog.Commit) {
+		d, err := gitlog.Show(path, c.Hash)
+		if err != nil || len(d.Files) == 0 {
+			setStatus("no changed files to comment on")
+			return
+		}
+		files := make([]string, len(d.Files))
+		for i, f := range d.Files {
+			files[i] = f.Path()
+		}
+		form := tview.NewForm()
+		form.SetBorder(true).
+			SetTitle(fmt.Sprintf(" Comment on %s ", c.Short)).
+			SetTitleAlign(tview.AlignLeft)
+		form.AddDropDown("File", files, 0, nil)
+		form.AddInputField("Lines", "", 12, nil, nil)
+		form.AddTextArea("Comment", "", 56, 6, 0, nil)
+		form.AddButton("Save", func() {
+			_, file := form.GetFormItem(0).(*tview.DropDown).GetCurrentOption()
+			start, end, ok := parseLines(form.GetFormItem(1).(*tview.InputField).GetText())
+			body := strings.TrimSpace(form.GetFormItem(2).(*tview.TextArea).GetText())
+			if !ok || body == "" {
+				setStatus("enter lines (12 or 12-14) and a comment")
+				return
+			}
+			err := store.AddReviewComment(&db.ReviewComment{
+				SessionID: s.ID, Repo: repo, File: file,
+				LineStart: start, LineEnd: end, Commit: c.Hash, Body: body,
+			})
+			closeComment()
+			audit.Local(store, audit.SourceTUI, audit.ReviewComment, s.ID, s.Title, err)
+			if err != nil {
+				setStatus(fmt.Sprintf("error: %v", err))
+				return
+			}
+			setStatus("comment saved")
+		})
+		form.AddButton("Cancel", closeComment)
+
+		modal := tview.NewFlex().SetDirection(tview.FlexRow).
+			AddItem(nil, 0, 1, false).
+			AddItem(tview.NewFlex().
+				AddItem(nil, 0, 1, false).
+				AddItem(form, 72, 0, true).
+				AddItem(nil, 0, 1, false), 15, 0, true).
+			AddItem(nil, 0, 1, false)
+		commenting = true
+		pages.AddPage("comment", modal, true, true)
+		app.SetFocus(form)
+	}
 
 	focusedLeft := true
 	app.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		if commenting {
+			if event.Key() == tcell.KeyEscape {
+				closeComment()
+				return nil
+			}
+			return event
+		}
+		switch event.Rune() {
+		case 'c':
+			if row, _ := table.GetSelection(); row >= 0 && row < len(commits) {
+				openComment(commits[row])
+			}
+			return nil
+		case 'r':
+			n, err := review.Send(store, s)
+			audit.Local(store, audit.SourceTUI, audit.SessionInput, s.ID, s.Title, err)
+			if err != nil {
+				setStatus(fmt.Sprintf("error: %v", err))
+				return nil
+			}
+			setStatus(fmt.Sprintf("sent %d review comments", n))
+			return nil
+		}
 		switch event.Key() {
 		case tcell.KeyEscape:
 			app.Stop()
@@ -212,5 +294,5 @@ func Run(tmuxSession string) error {
 		return event
 	})
 
-	return app.SetRoot(root, true).EnableMouse(false).Run()
+	return app.SetRoot(pages, true).EnableMouse(false).Run()
 }
@@ -2,28 +2,23 @@ package gitlogcmd
 
 import "testing"
 
-func TestParseLogLine(t *testing.T) {
-	c, ok := parseLogLine("abc1234\tFix auth bug\t2 hours ago")
-	if !ok {
-		t.Fatal("expected ok=true")
+func TestParseLines(t *testing.T) {
+	tests := []struct {
+		in         string
+		start, end int
+		ok         bool
+	}{
+		{"12", 12, 12, true},
+		{" 12 - 14 ", 12, 14, true},
+		{"14-12", 0, 0, false},
+		{"0", 0, 0, false},
+		{"", 0, 0, false},
+		{"a-b", 0, 0, false},
 	}
-	if c.Hash != "abc1234" {
-		t.Errorf("hash: got %q want %q", c.Hash, "abc1234")
-	}
-	if c.Subject != "Fix auth bug" {
-		t.Errorf("subject: got %q want %q", c.Subject, "Fix auth bug")
-	}
-	if c.RelDate != "2 hours ago" {
-		t.Errorf("reldate: got %q want %q", c.RelDate, "2 hours ago")
-	}
-
-	_, ok2 := parseLogLine("malformed no tabs")
-	if ok2 {
-		t.Error("expected ok=false for malformed line")
-	}
-
-	_, ok3 := parseLogLine("")
-	if ok3 {
-		t.Error("expected ok=false for empty line")
+	for _, tc := range tests {
+		start, end, ok := parseLines(tc.in)
+		if start != tc.start || end != tc.end || ok != tc.ok {
+			t.Errorf("parseLines(%q) = %d, %d, %v; want %d, %d, %v", tc.in, start, end, ok, tc.start, tc.end, tc.ok)
+		}
 	}
 }
@@ -7,6 +7,7 @@ import (
 
 	"github.com/gdamore/tcell/v2"
 	"github.com/rivo/tview"
+	"github.com/zsprackett/agent-workspace/internal/budget"
 	"github.com/zsprackett/agent-workspace/internal/db"
 	"github.com/zsprackett/agent-workspace/internal/tmux"
 )
@@ -19,6 +20,7 @@ type listItem struct {
 	group      *db.Group
 	session    *db.Session
 	groupIndex int // 1-9 for group hotkey
+	depth      int // nesting level of a forked session under its parent
 }
 
 // Home is the main screen containing the session list and preview pane.
@@ -45,8 +47,14 @@ type Home struct {
 	onMove     func(item listItem)
 	onAttach   func(item listItem)
 	onNotes    func(item listItem)
+	onFork     func(item listItem)
 	onUsage    func()
+	onReport   func()
+	onShare    func(item listItem)
 	onQuit     func()
+
+	// budget returns where Claude usage stands, for the header.
+	budget func() db.BudgetStatus
 }
 
 func NewHome(app *tview.Application, store *db.DB) *Home {
@@ -78,7 +86,7 @@ func NewHome(app *tview.Application, store *db.DB) *Home {
 	h.footer.SetText(
 		"[green]↑↓[-] navigate  [green]←→[-] fold  [green]Enter/a[-] attach  " +
 			"[green]n[-] new/notes  [green]d[-] delete  [green]s[-] stop  [green]x[-] restart  " +
-			"[green]e[-] edit  [green]g[-] group  [green]m[-] move  [green]u[-] usage  [green]?[-] help  [green]q[-] quit")
+			"[green]e[-] edit  [green]f[-] fork  [green]g[-] group  [green]m[-] move  [green]v[-] share  [green]u[-] usage  [green]r[-] report  [green]?[-] help  [green]q[-] quit")
 
 	previewFlex := tview.NewFlex().SetDirection(tview.FlexRow).
 		AddItem(h.preview, 0, 1, false)
@@ -99,6 +107,11 @@ func NewHome(app *tview.Application, store *db.DB) *Home {
 	return h
 }
 
+// SetBudget shows the usage budget returned by status in the header.
+func (h *Home) SetBudget(status func() db.BudgetStatus) {
+	h.budget = status
+}
+
 func (h *Home) SetCallbacks(
 	onNew func(groupPath string),
 	onDelete func(listItem),
@@ -109,7 +122,10 @@ func (h *Home) SetCallbacks(
 	onMove func(listItem),
 	onAttach func(listItem),
 	onNotes func(listItem),
+	onFork func(listItem),
 	onUsage func(),
+	onReport func(),
+	onShare func(listItem),
 	onQuit func(),
 ) {
 	h.onNew = onNew
@@ -121,7 +137,10 @@ func (h *Home) SetCallbacks(
 	h.onMove = onMove
 	h.onAttach = onAttach
 	h.onNotes = onNotes
+	h.onFork = onFork
 	h.onUsage = onUsage
+	h.onReport = onReport
+	h.onShare = onShare
 	h.onQuit = onQuit
 }
 
@@ -144,11 +163,13 @@ func (h *Home) rebuildItems() {
 		}
 		h.items = append(h.items, listItem{isGroup: true, group: g, groupIndex: idx})
 		if g.Expanded {
+			var inGroup []*db.Session
 			for _, s := range h.sessions {
 				if s.GroupPath == g.Path {
-					h.items = append(h.items, listItem{session: s})
+					inGroup = append(inGroup, s)
 				}
 			}
+			h.items = append(h.items, sessionTree(inGroup)...)
 		}
 	}
 	// Sessions not in any group
@@ -156,11 +177,53 @@ func (h *Home) rebuildItems() {
 	for _, g := range h.groups {
 		grouped[g.Path] = true
 	}
+	var ungrouped []*db.Session
 	for _, s := range h.sessions {
 		if !grouped[s.GroupPath] {
-			h.items = append(h.items, listItem{session: s})
+			ungrouped = append(ungrouped, s)
 		}
 	}
+	h.items = append(h.items, sessionTree(ungrouped)...)
+}
+
+// sessionTree orders sessions so that forks follow their parent, nested one
+// level deeper. A fork whose parent is not in sessions is shown at the top
+// level.
+func sessionTree(sessions []*db.Session) []listItem {
+	present := make(map[string]bool, len(sessions))
+	for _, s := range sessions {
+		present[s.ID] = true
+	}
+	children := make(map[string][]*db.Session)
+	var roots []*db.Session
+	for _, s := range sessions {
+		if s.ParentSessionID != "" && s.ParentSessionID != s.ID && present[s.ParentSessionID] {
+			children[s.ParentSessionID] = append(children[s.ParentSessionID], s)
+		} else {
+			roots = append(roots, s)
+		}
+	}
+	var items []listItem
+	visited := make(map[string]bool, len(sessions))
+	var walk func(s *db.Session, depth int)
+	walk = func(s *db.Session, depth int) {
+		if visited[s.ID] {
+			return
+		}
+		visited[s.ID] = true
+		items = append(items, listItem{session: s, depth: depth})
+		for _, c := range children[s.ID] {
+			walk(c, depth+1)
+		}
+	}
+	for _, s := range roots {
+		walk(s, 0)
+	}
+	// Parent cycles have no root; list them flat rather than dropping them.
+	for _, s := range sessions {
+		walk(s, 0)
+	}
+	return items
 }
 
 func (h *Home) renderTable() {
@@ -193,8 +256,11 @@ func (h *Home) renderTable() {
 			s := item.session
 			icon, color := StatusIcon(string(s.Status))
 			title := s.Title
-			if len(title) > 20 {
-				title = title[:18] + ".."
+			if item.depth > 0 {
+				title = strings.Repeat(" ", 2*(item.depth-1)) + "└ " + title
+			}
+			if n := []rune(title); len(n) > 20 {
+				title = string(n[:18]) + ".."
 			}
 			dirtyMark := "  "
 			if s.HasUncommitted {
@@ -247,8 +313,39 @@ func (h *Home) updateHeader() {
 		}
 	}
 	h.header.SetText(fmt.Sprintf(
-		"[blue]AGENT WORKSPACE[-]   [green]● %d running[-]  [yellow]◐ %d waiting[-]  %d total",
-		running, waiting, len(h.sessions)))
+		"[blue]AGENT WORKSPACE[-]   [green]● %d running[-]  [yellow]◐ %d waiting[-]  %d total%s",
+		running, waiting, len(h.sessions), h.budgetText()))
+}
+
+// budgetText describes the fuller usage window and when its trend reaches
+// the limit, colored by budget level.
+func (h *Home) budgetText() string {
+	if h.budget == nil {
+		return ""
+	}
+	st := h.budget()
+	name, f := "5h", st.FiveHour
+	if st.SevenDay.Util > f.Util {
+		name, f = "7d", st.SevenDay
+	}
+	if f.ResetsAt == 0 && f.Util == 0 {
+		return "" // no usage polled yet
+	}
+	color := "green"
+	switch st.Level {
+	case budget.LevelWarn:
+		color = "yellow"
+	case budget.LevelPaused, budget.LevelThrottled:
+		color = "red"
+	}
+	text := fmt.Sprintf("   [%s]%s %.0f%%[-]", color, name, f.Util)
+	if f.LimitAt > 0 {
+		text += fmt.Sprintf(" [%s]limit in %s[-]", color, budget.Until(f.LimitAt))
+	}
+	if st.Level == budget.LevelPaused || st.Level == budget.LevelThrottled {
+		text += " [red]new sessions paused[-]"
+	}
+	return text
 }
 
 func (h *Home) updatePreview() {
@@ -405,6 +502,13 @@ func (h *Home) setupInput() {
 				}
 			}
 			return nil
+		case 'f':
+			if item, ok := h.selectedItem(); ok {
+				if !item.isGroup && !isPending(item) && h.onFork != nil {
+					h.onFork(item)
+				}
+			}
+			return nil
 		case 'g':
 			if h.onNewGroup != nil {
 				h.onNewGroup()
@@ -417,11 +521,23 @@ func (h *Home) setupInput() {
 				}
 			}
 			return nil
+		case 'v':
+			if item, ok := h.selectedItem(); ok {
+				if !item.isGroup && !isPending(item) && h.onShare != nil {
+					h.onShare(item)
+				}
+			}
+			return nil
 		case 'u':
 			if h.onUsage != nil {
 				h.onUsage()
 			}
 			return nil
+		case 'r':
+			if h.onReport != nil {
+				h.onReport()
+			}
+			return nil
 		case 'q':
 			if h.onQuit != nil {
 				h.onQuit()
@@ -4,15 +4,20 @@ import (
 	"fmt"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"strings"
 
 	"github.com/gdamore/tcell/v2"
 	"github.com/rivo/tview"
+	"github.com/zsprackett/agent-workspace/internal/git"
 )
 
 const menuText = `
   [green]s[-]  Git status
   [green]d[-]  Git diff
   [green]h[-]  Git history
+  [green]c[-]  Compare with sibling session
+  [green]k[-]  Checkpoints
   [green]p[-]  Open PR in browser
   [green]n[-]  Session notes
   [green]t[-]  Open terminal split
@@ -50,6 +55,12 @@ func Run(tmuxSession string) error {
 		case 'h':
 			app.Stop()
 			openGitLog(tmuxSession)
+		case 'c':
+			app.Stop()
+			openCompare(tmuxSession)
+		case 'k':
+			app.Stop()
+			openCheckpoints(tmuxSession)
 		case 'p':
 			app.Stop()
 			openPR(panePath, tmuxSession)
@@ -69,21 +80,67 @@ func Run(tmuxSession string) error {
 	return app.SetRoot(tv, true).EnableMouse(false).Run()
 }
 
+// gitDirs returns path if it is inside a git repo. Otherwise it returns the
+// immediate subdirectories of path that are, which covers the session
+// directory of a multi-repo session.
+func gitDirs(path string) []string {
+	if git.IsGitRepo(path) {
+		return []string{path}
+	}
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return nil
+	}
+	var dirs []string
+	for _, e := range entries {
+		sub := filepath.Join(path, e.Name())
+		if e.IsDir() && git.IsGitRepo(sub) {
+			dirs = append(dirs, sub)
+		}
+	}
+	return dirs
+}
+
+// perRepo builds a shell script running cmd in every git dir under path,
+// headed by the repo directory name when there is more than one.
+func perRepo(path, cmd string) string {
+	dirs := gitDirs(path)
+	if len(dirs) <= 1 {
+		return cmd
+	}
+	parts := make([]string, len(dirs))
+	for i, d := range dirs {
+		parts[i] = fmt.Sprintf("printf '=== %%s ===\\n' %q; (cd %q && %s)", filepath.Base(d), d, cmd)
+	}
+	return strings.Join(parts, "; ")
+}
+
 func gitStatus(path string) {
 	exec.Command("tmux", "split-window", "-v", "-l", "15", "-c", path,
-		`git status; printf '\nPress enter to close...'; read`).Run()
+		perRepo(path, "git status")+`; printf '\nPress enter to close...'; read`).Run()
 }
 
 func gitDiff(path string) {
+	diff := perRepo(path, `git diff HEAD --color=always; git ls-files --others --exclude-standard -z | xargs -0 -I{} git diff --no-index --color=always -- /dev/null {} 2>/dev/null`)
 	exec.Command("tmux", "split-window", "-v", "-l", "20", "-c", path,
-		`out=$(git diff HEAD --color=always; git ls-files --others --exclude-standard -z | xargs -0 -I{} git diff --no-index --color=always -- /dev/null {} 2>/dev/null); if [ -n "$out" ]; then printf '%s\n' "$out" | less -RX; else printf 'No changes.\n\nPress enter to close...'; read; fi`).Run()
+		`out=$(`+diff+`); if [ -n "$out" ]; then printf '%s\n' "$out" | less -RX; else printf 'No changes.\n\nPress enter to close...'; read; fi`).Run()
 }
 
+// openPR opens the PR of the branch in the pane's repo, or of every repo of a
+// multi-repo session that has one.
 func openPR(path, tmuxSession string) {
-	script := fmt.Sprintf(
-		`cd %q && url=$(gh pr view --json url --jq .url 2>/dev/null) && [ -n "$url" ] && { open "$url" 2>/dev/null || xdg-open "$url" 2>/dev/null; } || tmux display-message -t %q "No open PR found for this branch"`,
-		path, tmuxSession,
-	)
+	dirs := gitDirs(path)
+	if len(dirs) == 0 {
+		dirs = []string{path}
+	}
+	var opens []string
+	for _, d := range dirs {
+		opens = append(opens, fmt.Sprintf(
+			`{ cd %q && url=$(gh pr view --json url --jq .url 2>/dev/null) && [ -n "$url" ] && { open "$url" 2>/dev/null || xdg-open "$url" 2>/dev/null; found=1; }; }`,
+			d))
+	}
+	script := fmt.Sprintf(`found=; %s; [ -n "$found" ] || tmux display-message -t %q "No open PR found for this branch"`,
+		strings.Join(opens, "; "), tmuxSession)
 	exec.Command("tmux", "run-shell", "-b", "sleep 0.3 && "+script).Run() //nolint:errcheck
 }
 
@@ -114,3 +171,23 @@ func openGitLog(tmuxSession string) {
 	exec.Command("tmux", "run-shell", "-b", popupCmd).Run()
 }
 
+
+func openCompare(tmuxSession string) {
+	exe, err := os.Executable()
+	if err != nil {
+		return
+	}
+	compareCmd := fmt.Sprintf("%q compare %q", exe, tmuxSession)
+	popupCmd := fmt.Sprintf("sleep 0.3 && tmux display-popup -E -t %q -w 90%% -h 90%% %q", tmuxSession, compareCmd)
+	exec.Command("tmux", "run-shell", "-b", popupCmd).Run()
+}
+
+func openCheckpoints(tmuxSession string) {
+	exe, err := os.Executable()
+	if err != nil {
+		return
+	}
+	checkpointsCmd := fmt.Sprintf("%q checkpoints %q", exe, tmuxSession)
+	popupCmd := fmt.Sprintf("sleep 0.3 && tmux display-popup -E -t %q -w 90%% -h 90%% %q", tmuxSession, checkpointsCmd)
+	exec.Command("tmux", "run-shell", "-b", popupCmd).Run()
+}
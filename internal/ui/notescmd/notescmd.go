@@ -5,6 +5,7 @@ import (
 
 	"github.com/gdamore/tcell/v2"
 	"github.com/rivo/tview"
+	"github.com/zsprackett/agent-workspace/internal/audit"
 	"github.com/zsprackett/agent-workspace/internal/config"
 	"github.com/zsprackett/agent-workspace/internal/db"
 )
@@ -36,7 +37,8 @@ func Run(tmuxSession string) error {
 
 	form.AddButton("Save", func() {
 		notes := form.GetFormItem(0).(*tview.TextArea).GetText()
-		store.UpdateSessionNotes(s.ID, notes)
+		err := store.UpdateSessionNotes(s.ID, notes)
+		audit.Local(store, audit.SourceTUI, audit.SessionNotes, s.ID, s.Title, err)
 		store.Touch()
 		app.Stop()
 	})
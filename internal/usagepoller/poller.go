@@ -6,17 +6,22 @@ import (
 
 	"github.com/zsprackett/agent-workspace/internal/claudeusage"
 	"github.com/zsprackett/agent-workspace/internal/db"
+	"github.com/zsprackett/agent-workspace/internal/events"
 )
 
 type Poller struct {
+	client   *claudeusage.Client
 	store    *db.DB
 	interval time.Duration
 	stop     chan struct{}
 	logger   *slog.Logger
+	// broadcaster is sent every new snapshot.
+	broadcaster events.Broadcaster
 }
 
-func New(store *db.DB, interval time.Duration, logger *slog.Logger) *Poller {
+func New(client *claudeusage.Client, store *db.DB, interval time.Duration, logger *slog.Logger) *Poller {
 	return &Poller{
+		client:   client,
 		store:    store,
 		interval: interval,
 		stop:     make(chan struct{}),
@@ -24,6 +29,12 @@ func New(store *db.DB, interval time.Duration, logger *slog.Logger) *Poller {
 	}
 }
 
+// SetBroadcaster sends every new usage snapshot to b. Must be called before
+// Start.
+func (p *Poller) SetBroadcaster(b events.Broadcaster) {
+	p.broadcaster = b
+}
+
 func (p *Poller) Start() {
 	go func() {
 		p.poll()
@@ -45,7 +56,7 @@ func (p *Poller) Stop() {
 }
 
 func (p *Poller) poll() {
-	usage, err := claudeusage.FetchUsage()
+	usage, err := p.client.FetchUsage()
 	if err != nil {
 		p.logger.Debug("usage poll failed", "err", err)
 		return
@@ -68,6 +79,10 @@ func (p *Poller) poll() {
 
 	if err := p.store.InsertUsageSnapshot(snap); err != nil {
 		p.logger.Debug("usage snapshot insert failed", "err", err)
+		return
+	}
+	if p.broadcaster != nil {
+		p.broadcaster.Broadcast(events.Event{Type: events.UsageUpdated, Usage: &snap})
 	}
 }
 
@@ -0,0 +1,327 @@
+package webserver
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/zsprackett/agent-workspace/internal/db"
+)
+
+type accountJSON struct {
+	ID        string             `json:"id"`
+	Username  string             `json:"username"`
+	Role      db.Role            `json:"role"`
+	Groups    map[string]db.Role `json:"groups"`
+	Disabled  bool               `json:"disabled"`
+	TOTP      bool               `json:"totp_enabled"`
+	CreatedAt time.Time          `json:"created_at"`
+	LastLogin *time.Time         `json:"last_login"`
+	Logins    int                `json:"logins"`
+}
+
+type loginJSON struct {
+	ID        string    `json:"id"`
+	LoginAt   time.Time `json:"login_at"`
+	LastUsed  time.Time `json:"last_used"`
+	ExpiresAt time.Time `json:"expires_at"`
+	UserAgent string    `json:"user_agent"`
+	IP        string    `json:"ip"`
+}
+
+func (s *Server) accountJSON(acc *db.Account) (accountJSON, error) {
+	groups, err := s.store.GetGroupPermissions(acc.ID)
+	if err != nil {
+		return accountJSON{}, err
+	}
+	logins, err := s.store.ListRefreshTokens(acc.ID)
+	if err != nil {
+		return accountJSON{}, err
+	}
+	a := accountJSON{
+		ID:        acc.ID,
+		Username:  acc.Username,
+		Role:      acc.Role,
+		Groups:    groups,
+		Disabled:  acc.Disabled,
+		TOTP:      acc.TOTPEnabled,
+		CreatedAt: acc.CreatedAt,
+		Logins:    len(logins),
+	}
+	if !acc.LastLogin.IsZero() {
+		a.LastLogin = &acc.LastLogin
+	}
+	return a, nil
+}
+
+// loadAccount returns the {account} of the request, answering 404 itself if
+// there is none.
+func (s *Server) loadAccount(w http.ResponseWriter, r *http.Request) *db.Account {
+	acc, err := s.store.GetAccountByID(r.PathValue("account"))
+	if err != nil {
+		http.Error(w, "account not found", 404)
+		return nil
+	}
+	return acc
+}
+
+// handleAccounts lists all accounts.
+func (s *Server) handleAccounts(w http.ResponseWriter, r *http.Request) {
+	accounts, err := s.store.ListAccounts()
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	out := make([]accountJSON, 0, len(accounts))
+	for _, acc := range accounts {
+		a, err := s.accountJSON(acc)
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		out = append(out, a)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"accounts": out})
+}
+
+// handleCreateAccount creates an account from a username, password and
+// role (operator if omitted).
+func (s *Server) handleCreateAccount(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Username string  `json:"username"`
+		Password string  `json:"password"`
+		Role     db.Role `json:"role"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "bad request", 400)
+		return
+	}
+	body.Username = strings.TrimSpace(body.Username)
+	setAuditTarget(r, body.Username, string(body.Role))
+	if body.Role == "" {
+		body.Role = db.RoleOperator
+	}
+	if body.Username == "" || body.Password == "" {
+		http.Error(w, "username and password are required", 400)
+		return
+	}
+	if !body.Role.Valid() {
+		http.Error(w, fmt.Sprintf("unknown role %q", body.Role), 400)
+		return
+	}
+	if _, err := s.store.GetAccountByUsername(body.Username); err == nil {
+		http.Error(w, "username already exists", 409)
+		return
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(body.Password), bcrypt.DefaultCost)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	acc, err := s.store.CreateAccount(body.Username, string(hash), body.Role)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	a, _ := s.accountJSON(acc)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(201)
+	json.NewEncoder(w).Encode(a)
+}
+
+// handleUpdateAccount changes an account's role, group roles ("" removes an
+// override), password or disabled state, or resets its two-factor login
+// ("reset_totp") for a lost device. Role and password changes revoke the
+// account's logins. Admins cannot demote or disable themselves.
+func (s *Server) handleUpdateAccount(w http.ResponseWriter, r *http.Request) {
+	acc := s.loadAccount(w, r)
+	if acc == nil {
+		return
+	}
+	var body struct {
+		Role      *db.Role           `json:"role"`
+		Groups    map[string]db.Role `json:"groups"`
+		Password  *string            `json:"password"`
+		Disabled  *bool              `json:"disabled"`
+		ResetTOTP bool               `json:"reset_totp"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "bad request", 400)
+		return
+	}
+	var changes []string
+	if body.Role != nil {
+		changes = append(changes, "role="+string(*body.Role))
+	}
+	paths := make([]string, 0, len(body.Groups))
+	for path := range body.Groups {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		changes = append(changes, fmt.Sprintf("group %s=%s", path, body.Groups[path]))
+	}
+	if body.Password != nil {
+		changes = append(changes, "password")
+	}
+	if body.Disabled != nil {
+		changes = append(changes, fmt.Sprintf("disabled=%v", *body.Disabled))
+	}
+	if body.ResetTOTP {
+		changes = append(changes, "reset_totp")
+	}
+	setAuditTarget(r, acc.Username, strings.Join(changes, " "))
+
+	self := acc.Username == requestUsername(r)
+	if self && ((body.Role != nil && *body.Role != db.RoleAdmin) || (body.Disabled != nil && *body.Disabled)) {
+		http.Error(w, "cannot demote or disable your own account", 400)
+		return
+	}
+	if body.Role != nil && !body.Role.Valid() {
+		http.Error(w, fmt.Sprintf("unknown role %q", *body.Role), 400)
+		return
+	}
+	for _, role := range body.Groups {
+		if role != "" && !role.Valid() {
+			http.Error(w, fmt.Sprintf("unknown role %q", role), 400)
+			return
+		}
+	}
+	if body.Password != nil && *body.Password == "" {
+		http.Error(w, "password must not be empty", 400)
+		return
+	}
+
+	revoke := false
+	if body.Role != nil && *body.Role != acc.Role {
+		if err := s.store.UpdateAccountRole(acc.ID, *body.Role); err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		revoke = true
+	}
+	for path, role := range body.Groups {
+		if err := s.store.SetGroupPermission(acc.ID, path, role); err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		revoke = true
+	}
+	if body.Password != nil {
+		hash, err := bcrypt.GenerateFromPassword([]byte(*body.Password), bcrypt.DefaultCost)
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		if err := s.store.UpdateAccountPassword(acc.ID, string(hash)); err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		revoke = true
+	}
+	if body.Disabled != nil {
+		if err := s.store.SetAccountDisabled(acc.ID, *body.Disabled); err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+	}
+	if body.ResetTOTP {
+		if err := s.store.DisableTOTP(acc.ID); err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+	}
+	// Roles are carried in access tokens; revoking the logins makes the
+	// change apply once the current access token expires. Admins editing
+	// themselves stay signed in.
+	if revoke && !self {
+		s.store.DeleteRefreshTokensByAccount(acc.ID)
+	}
+
+	acc, _ = s.store.GetAccountByID(acc.ID)
+	a, err := s.accountJSON(acc)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(a)
+}
+
+// handleDeleteAccount deletes an account and its logins.
+func (s *Server) handleDeleteAccount(w http.ResponseWriter, r *http.Request) {
+	acc := s.loadAccount(w, r)
+	if acc == nil {
+		return
+	}
+	setAuditTarget(r, acc.Username, "")
+	if acc.Username == requestUsername(r) {
+		http.Error(w, "cannot delete your own account", 400)
+		return
+	}
+	if err := s.store.DeleteAccount(acc.ID); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	w.WriteHeader(204)
+}
+
+// handleAccountLogins lists an account's active logins.
+func (s *Server) handleAccountLogins(w http.ResponseWriter, r *http.Request) {
+	acc := s.loadAccount(w, r)
+	if acc == nil {
+		return
+	}
+	tokens, err := s.store.ListRefreshTokens(acc.ID)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	logins := make([]loginJSON, 0, len(tokens))
+	for _, rt := range tokens {
+		logins = append(logins, loginJSON{
+			ID:        rt.LoginID,
+			LoginAt:   rt.LoginAt,
+			LastUsed:  rt.LastUsed,
+			ExpiresAt: rt.ExpiresAt,
+			UserAgent: rt.UserAgent,
+			IP:        rt.IP,
+		})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"logins": logins})
+}
+
+// handleRevokeLogins revokes one login of an account ({login}) or all of
+// them. Access tokens already issued stay valid until they expire.
+func (s *Server) handleRevokeLogins(w http.ResponseWriter, r *http.Request) {
+	acc := s.loadAccount(w, r)
+	if acc == nil {
+		return
+	}
+	login := r.PathValue("login")
+	setAuditTarget(r, acc.Username, login)
+	var err error
+	if login == "" {
+		err = s.store.DeleteRefreshTokensByAccount(acc.ID)
+	} else {
+		err = s.store.DeleteLogin(acc.ID, login)
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "login not found", 404)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	w.WriteHeader(204)
+}
@@ -0,0 +1,108 @@
+package webserver_test
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zsprackett/agent-workspace/internal/db"
+	"github.com/zsprackett/agent-workspace/internal/webserver"
+)
+
+func TestAccountEndpoints(t *testing.T) {
+	srv, store := newAuthServer(t)
+	alice, _ := store.GetAccountByUsername("alice")
+	admin, _ := webserver.IssueAccessToken("test-secret", webserver.Principal{Username: "alice", Role: db.RoleAdmin}, time.Hour)
+	do := func(token, method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(w, req)
+		return w
+	}
+
+	w := do(admin, "POST", "/api/accounts", `{"username":"bob","password":"pw","role":"viewer"}`)
+	if w.Code != 201 {
+		t.Fatalf("create: expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+	var bob struct{ ID, Username, Role string }
+	json.NewDecoder(w.Body).Decode(&bob)
+	if bob.Username != "bob" || bob.Role != "viewer" {
+		t.Errorf("created: %+v", bob)
+	}
+	if w := do(admin, "POST", "/api/accounts", `{"username":"bob","password":"pw"}`); w.Code != 409 {
+		t.Errorf("duplicate: expected 409, got %d", w.Code)
+	}
+
+	viewer, _ := webserver.IssueAccessToken("test-secret", webserver.Principal{Username: "bob", Role: db.RoleViewer}, time.Hour)
+	if w := do(viewer, "GET", "/api/accounts", ""); w.Code != 403 {
+		t.Errorf("viewer list: expected 403, got %d", w.Code)
+	}
+
+	// Logging in records the login and its client.
+	req := httptest.NewRequest("POST", "/api/auth/login", strings.NewReader(`{"username":"bob","password":"pw"}`))
+	req.Header.Set("User-Agent", "test-phone")
+	lw := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(lw, req)
+	if lw.Code != 200 {
+		t.Fatalf("login: %d", lw.Code)
+	}
+	w = do(admin, "GET", "/api/accounts/"+bob.ID+"/logins", "")
+	var logins struct {
+		Logins []struct {
+			ID        string `json:"id"`
+			UserAgent string `json:"user_agent"`
+		}
+	}
+	json.NewDecoder(w.Body).Decode(&logins)
+	if len(logins.Logins) != 1 || logins.Logins[0].UserAgent != "test-phone" {
+		t.Fatalf("logins: %+v", logins)
+	}
+	if got, _ := store.GetAccountByUsername("bob"); got.LastLogin.IsZero() {
+		t.Error("last login not recorded")
+	}
+	if w := do(admin, "DELETE", "/api/accounts/"+bob.ID+"/logins/"+logins.Logins[0].ID, ""); w.Code != 204 {
+		t.Errorf("revoke: expected 204, got %d", w.Code)
+	}
+	if tokens, _ := store.ListRefreshTokens(bob.ID); len(tokens) != 0 {
+		t.Errorf("tokens after revoke: %d", len(tokens))
+	}
+
+	// A disabled account is locked out at once.
+	if w := do(admin, "PATCH", "/api/accounts/"+bob.ID, `{"disabled":true}`); w.Code != 200 {
+		t.Fatalf("disable: %d %s", w.Code, w.Body.String())
+	}
+	if w := do(viewer, "GET", "/api/sessions", ""); w.Code != 401 {
+		t.Errorf("disabled account's token: expected 401, got %d", w.Code)
+	}
+	req = httptest.NewRequest("POST", "/api/auth/login", strings.NewReader(`{"username":"bob","password":"pw"}`))
+	lw = httptest.NewRecorder()
+	srv.Handler().ServeHTTP(lw, req)
+	if lw.Code != 403 {
+		t.Errorf("disabled login: expected 403, got %d", lw.Code)
+	}
+
+	if w := do(admin, "PATCH", "/api/accounts/"+alice.ID, `{"role":"viewer"}`); w.Code != 400 {
+		t.Errorf("self-demotion: expected 400, got %d", w.Code)
+	}
+	if w := do(admin, "DELETE", "/api/accounts/"+alice.ID, ""); w.Code != 400 {
+		t.Errorf("self-delete: expected 400, got %d", w.Code)
+	}
+	if w := do(admin, "DELETE", "/api/accounts/"+bob.ID, ""); w.Code != 204 {
+		t.Errorf("delete: expected 204, got %d", w.Code)
+	}
+	w = do(admin, "GET", "/api/accounts", "")
+	var list struct {
+		Accounts []struct{ Username string }
+	}
+	json.NewDecoder(w.Body).Decode(&list)
+	if len(list.Accounts) != 1 || list.Accounts[0].Username != "alice" {
+		t.Errorf("accounts: %+v", list)
+	}
+	entries, _ := store.QueryAudit(db.AuditFilter{Action: "account.", Target: "bob"})
+	if len(entries) != 5 {
+		t.Errorf("audit entries for bob: %d", len(entries))
+	}
+}
@@ -0,0 +1,47 @@
+package webserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+
+	"github.com/zsprackett/agent-workspace/internal/analytics"
+)
+
+// handleAnalytics returns time-in-state and throughput for the last ?days=
+// days (default 7, at most 90), as JSON or, with ?format=csv, one ?table=
+// (sessions, groups or days) as CSV.
+func (s *Server) handleAnalytics(w http.ResponseWriter, r *http.Request) {
+	days := 7
+	if v := r.URL.Query().Get("days"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 90 {
+			http.Error(w, "invalid days", 400)
+			return
+		}
+		days = n
+	}
+	format, table := r.URL.Query().Get("format"), r.URL.Query().Get("table")
+	if table == "" {
+		table = analytics.TableSessions
+	}
+	if format == "csv" && !slices.Contains(analytics.Tables, table) {
+		http.Error(w, "invalid table", 400)
+		return
+	}
+	rep, err := analytics.Compute(s.store, days)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if format == "csv" {
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="analytics-%s-%dd.csv"`, table, days))
+		rep.WriteCSV(w, table)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rep)
+}
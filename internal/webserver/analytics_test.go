@@ -0,0 +1,53 @@
+package webserver_test
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zsprackett/agent-workspace/internal/analytics"
+	"github.com/zsprackett/agent-workspace/internal/db"
+	"github.com/zsprackett/agent-workspace/internal/session"
+	"github.com/zsprackett/agent-workspace/internal/webserver"
+)
+
+func TestAnalyticsEndpoint(t *testing.T) {
+	store, _ := db.Open(":memory:")
+	store.Migrate()
+	defer store.Close()
+	store.SaveSession(&db.Session{ID: "s1", Title: "busy", GroupPath: "g", Tool: db.ToolClaude, Status: db.StatusRunning, CreatedAt: time.Now()})
+	store.InsertSessionEvent("s1", "status_changed", `{"from":"idle","to":"running"}`)
+	time.Sleep(20 * time.Millisecond)
+
+	handler := webserver.New(store, session.NewManager(store), webserver.Config{Port: 0, Host: "127.0.0.1", Enabled: true}).Handler()
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/api/analytics?days=1", nil))
+	if w.Code != 200 {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+	var rep analytics.Report
+	json.NewDecoder(w.Body).Decode(&rep)
+	if len(rep.Sessions) != 1 || rep.Sessions[0].Title != "busy" || rep.Sessions[0].RunningSec <= 0 {
+		t.Errorf("expected the running session, got %+v", rep.Sessions)
+	}
+	if len(rep.Days) == 0 || rep.Days[len(rep.Days)-1].Created != 1 {
+		t.Errorf("expected today's session counted, got %+v", rep.Days)
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/api/analytics?format=csv&table=groups", nil))
+	if ct := w.Header().Get("Content-Type"); ct != "text/csv" || !strings.HasPrefix(w.Body.String(), "group,sessions,") {
+		t.Errorf("unexpected CSV %q: %s", ct, w.Body.String())
+	}
+
+	for _, q := range []string{"days=0", "days=91", "format=csv&table=nope"} {
+		w = httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", "/api/analytics?"+q, nil))
+		if w.Code != 400 {
+			t.Errorf("expected 400 for %s, got %d", q, w.Code)
+		}
+	}
+}
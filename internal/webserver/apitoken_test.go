@@ -0,0 +1,77 @@
+package webserver_test
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zsprackett/agent-workspace/internal/db"
+	"github.com/zsprackett/agent-workspace/internal/webserver"
+)
+
+func TestAPITokens(t *testing.T) {
+	srv, store := newAuthServer(t)
+	now := time.Now()
+	store.SaveSession(&db.Session{ID: "s1", Title: "s", GroupPath: "work", Tool: db.ToolShell,
+		Status: db.StatusStopped, CreatedAt: now, LastAccessed: now})
+	alice, _ := store.GetAccountByUsername("alice")
+	newToken := func(scopes []db.Scope, expires time.Time) string {
+		token, hash, err := webserver.GenerateAPIToken()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := store.CreateAPIToken(&db.APIToken{AccountID: alice.ID, Name: "ci", Hash: hash,
+			Scopes: scopes, ExpiresAt: expires}); err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+	do := func(token, method, path, body string) int {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(w, req)
+		return w.Code
+	}
+
+	read := newToken([]db.Scope{db.ScopeRead}, time.Time{})
+	if !strings.HasPrefix(read, webserver.APITokenPrefix) {
+		t.Errorf("token %q lacks prefix", read)
+	}
+	if code := do(read, "GET", "/api/sessions", ""); code != 200 {
+		t.Errorf("read token list: expected 200, got %d", code)
+	}
+	if tokens, _ := store.ListAPITokens(alice.ID); tokens[0].LastUsed.IsZero() {
+		t.Error("last use not recorded")
+	}
+	if code := do(read, "POST", "/api/sessions/s1/notes", `{"notes":"x"}`); code != 403 {
+		t.Errorf("read token write: expected 403, got %d", code)
+	}
+	if code := do(read, "GET", "/terminal/s1/", ""); code != 403 {
+		t.Errorf("read token terminal: expected 403, got %d", code)
+	}
+
+	write := newToken([]db.Scope{db.ScopeRead, db.ScopeSessionsWrite}, time.Time{})
+	if code := do(write, "POST", "/api/sessions/s1/notes", `{"notes":"x"}`); code != 204 {
+		t.Errorf("write token notes: expected 204, got %d", code)
+	}
+	// API tokens cannot manage accounts, whatever the account's role.
+	if code := do(write, "POST", "/api/accounts", `{"username":"x","password":"y"}`); code != 403 {
+		t.Errorf("write token account change: expected 403, got %d", code)
+	}
+	if entries, _ := store.QueryAudit(db.AuditFilter{Action: "session.notes"}); len(entries) != 1 || entries[0].Actor != "alice" {
+		t.Errorf("audit: %+v", entries)
+	}
+
+	if code := do(newToken([]db.Scope{db.ScopeRead}, now.Add(-time.Minute)), "GET", "/api/sessions", ""); code != 401 {
+		t.Errorf("expired token: expected 401, got %d", code)
+	}
+	if code := do(webserver.APITokenPrefix+"unknown", "GET", "/api/sessions", ""); code != 401 {
+		t.Errorf("unknown token: expected 401, got %d", code)
+	}
+	store.SetAccountDisabled(alice.ID, true)
+	if code := do(read, "GET", "/api/sessions", ""); code != 401 {
+		t.Errorf("disabled account's token: expected 401, got %d", code)
+	}
+}
@@ -0,0 +1,154 @@
+package webserver
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/zsprackett/agent-workspace/internal/audit"
+	"github.com/zsprackett/agent-workspace/internal/db"
+)
+
+const auditKey contextKey = "audit"
+
+// auditRecorder captures the status and error text of a response for the
+// audit log.
+type auditRecorder struct {
+	http.ResponseWriter
+	status int
+	errMsg []byte
+}
+
+func (rec *auditRecorder) WriteHeader(code int) {
+	if rec.status == 0 {
+		rec.status = code
+	}
+	rec.ResponseWriter.WriteHeader(code)
+}
+
+func (rec *auditRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	if rec.status >= 400 && len(rec.errMsg) < 200 {
+		rec.errMsg = append(rec.errMsg, b...)
+	}
+	return rec.ResponseWriter.Write(b)
+}
+
+// result turns the response into an audit result.
+func (rec *auditRecorder) result() string {
+	switch {
+	case rec.status < 400:
+		return audit.ResultOK
+	case rec.status == http.StatusUnauthorized || rec.status == http.StatusForbidden ||
+		rec.status == http.StatusTooManyRequests:
+		return audit.ResultDenied
+	}
+	msg := strings.TrimSpace(string(rec.errMsg))
+	if len(msg) > 200 {
+		msg = msg[:200]
+	}
+	return fmt.Sprintf("error %d: %s", rec.status, msg)
+}
+
+// audited wraps h so every call is recorded in the audit log, including
+// calls that authorize turns away. The target defaults to the {id} session
+// with its title as detail; handlers that create the target set it with
+// setAuditTarget.
+func (s *Server) audited(action string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		entry := &db.AuditEntry{Action: action, Target: r.PathValue("id")}
+		if entry.Target != "" {
+			// Look the title up now: the handler may delete the session.
+			if sess, err := s.store.GetSession(entry.Target); err == nil && sess != nil {
+				entry.Detail = sess.Title
+			}
+		}
+		rec := &auditRecorder{ResponseWriter: w}
+		h(rec, r.WithContext(context.WithValue(r.Context(), auditKey, entry)))
+		entry.Result = rec.result()
+		s.recordAudit(r, entry)
+	}
+}
+
+// setAuditTarget names the target of the action being audited when the URL
+// has none, as for session creation. A fork stays recorded against its
+// parent.
+func setAuditTarget(r *http.Request, target, detail string) {
+	if entry, ok := r.Context().Value(auditKey).(*db.AuditEntry); ok && entry.Target == "" {
+		entry.Target, entry.Detail = target, detail
+	}
+}
+
+// setAuditDetail sets the detail of the action being audited, such as why
+// a login failed.
+func setAuditDetail(r *http.Request, detail string) {
+	if entry, ok := r.Context().Value(auditKey).(*db.AuditEntry); ok {
+		entry.Detail = detail
+	}
+}
+
+// recordAudit fills in the caller of r and appends entry to the audit log.
+func (s *Server) recordAudit(r *http.Request, entry *db.AuditEntry) {
+	entry.Source = audit.SourceWeb
+	entry.Actor = requestActor(r)
+	entry.IP = clientIP(r)
+	s.store.InsertAudit(entry)
+}
+
+// requestActor returns the name to record as the caller of r: its username,
+// or "anonymous" when authentication is disabled.
+func requestActor(r *http.Request) string {
+	if name := requestUsername(r); name != "" {
+		return name
+	}
+	return "anonymous"
+}
+
+// clientIP returns the address of the client that sent r.
+func clientIP(r *http.Request) string {
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
+
+// handleAudit returns audit log entries, newest first, filtered by the
+// actor, action, target and source query parameters. since and until take
+// RFC 3339 times or durations back from now ("24h"); limit defaults to 100.
+func (s *Server) handleAudit(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	f := db.AuditFilter{
+		Actor:  q.Get("actor"),
+		Action: q.Get("action"),
+		Target: q.Get("target"),
+		Source: q.Get("source"),
+	}
+	var err error
+	if f.Since, err = audit.ParseTime(q.Get("since")); err != nil {
+		http.Error(w, "invalid since: "+err.Error(), 400)
+		return
+	}
+	if f.Until, err = audit.ParseTime(q.Get("until")); err != nil {
+		http.Error(w, "invalid until: "+err.Error(), 400)
+		return
+	}
+	if v := q.Get("limit"); v != "" {
+		if f.Limit, err = strconv.Atoi(v); err != nil || f.Limit < 0 {
+			http.Error(w, "invalid limit", 400)
+			return
+		}
+	}
+	entries, err := s.store.QueryAudit(f)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"entries": entries})
+}
@@ -0,0 +1,74 @@
+package webserver_test
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zsprackett/agent-workspace/internal/db"
+	"github.com/zsprackett/agent-workspace/internal/webserver"
+)
+
+func TestAuditLog(t *testing.T) {
+	srv, store := newAuthServer(t)
+	now := time.Now()
+	for _, s := range []*db.Session{
+		{ID: "work-id", Title: "w", GroupPath: "work", Tool: db.ToolShell, Status: db.StatusStopped, CreatedAt: now, LastAccessed: now},
+		{ID: "other-id", Title: "o", GroupPath: "other", Tool: db.ToolShell, Status: db.StatusStopped, CreatedAt: now, LastAccessed: now},
+	} {
+		if err := store.SaveSession(s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	store.CreateAccount("bob", "x", db.RoleViewer)
+	store.CreateAccount("root", "x", db.RoleAdmin)
+	bob, _ := webserver.IssueAccessToken("test-secret", webserver.Principal{
+		Username: "bob",
+		Role:     db.RoleViewer,
+		Groups:   map[string]db.Role{"work": db.RoleOperator},
+	}, time.Hour)
+	admin, _ := webserver.IssueAccessToken("test-secret", webserver.Principal{Username: "root", Role: db.RoleAdmin}, time.Hour)
+	do := func(token, method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.RemoteAddr = "192.0.2.7:5555"
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(w, req)
+		return w
+	}
+
+	do(bob, "POST", "/api/sessions/work-id/notes", `{"notes":"x"}`)
+	do(bob, "DELETE", "/api/sessions/other-id", "")
+	do("", "POST", "/api/auth/login", `{"username":"nobody","password":"pw"}`)
+	// Reads are not audited.
+	do(bob, "GET", "/api/sessions", "")
+
+	if w := do(bob, "GET", "/api/audit", ""); w.Code != 403 {
+		t.Errorf("non-admin audit: expected 403, got %d", w.Code)
+	}
+	w := do(admin, "GET", "/api/audit?actor=bob", "")
+	var resp struct{ Entries []db.AuditEntry }
+	json.NewDecoder(w.Body).Decode(&resp)
+	if w.Code != 200 || len(resp.Entries) != 2 {
+		t.Fatalf("audit for bob: %d %+v", w.Code, resp.Entries)
+	}
+	del, notes := resp.Entries[0], resp.Entries[1]
+	if del.Action != "session.delete" || del.Target != "other-id" || del.Detail != "o" || del.Result != "denied" {
+		t.Errorf("denied delete: %+v", del)
+	}
+	if notes.Action != "session.notes" || notes.Result != "ok" || notes.IP != "192.0.2.7" || notes.Source != "web" {
+		t.Errorf("notes: %+v", notes)
+	}
+
+	w = do(admin, "GET", "/api/audit?action=auth.login", "")
+	resp.Entries = nil
+	json.NewDecoder(w.Body).Decode(&resp)
+	if len(resp.Entries) != 1 || resp.Entries[0].Target != "nobody" || resp.Entries[0].Result != "denied" {
+		t.Errorf("failed login: %+v", resp.Entries)
+	}
+	if w := do(admin, "GET", "/api/audit?since=yesterday", ""); w.Code != 400 {
+		t.Errorf("bad since: expected 400, got %d", w.Code)
+	}
+}
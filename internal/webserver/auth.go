@@ -3,6 +3,7 @@ package webserver
 import (
 	"context"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/hex"
 	"errors"
 	"fmt"
@@ -11,35 +12,77 @@ import (
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
+
+	"github.com/zsprackett/agent-workspace/internal/db"
 )
 
-// IssueAccessToken creates a signed HS256 JWT for the given username.
-func IssueAccessToken(secret, username string, ttl time.Duration) (string, error) {
-	claims := jwt.RegisteredClaims{
-		Subject:   username,
-		ExpiresAt: jwt.NewNumericDate(time.Now().Add(ttl)),
-		IssuedAt:  jwt.NewNumericDate(time.Now()),
+// Principal is an authenticated web user and what they may do: Role
+// everywhere except in the groups listed in Groups. Principals from API
+// tokens are further limited to their Scopes; those from access tokens have
+// nil Scopes.
+type Principal struct {
+	Username string
+	Role     db.Role
+	Groups   map[string]db.Role
+	Scopes   []db.Scope
+}
+
+// RoleIn returns the principal's role in the group at groupPath. Admins are
+// admins everywhere.
+func (p *Principal) RoleIn(groupPath string) db.Role {
+	if p.Role == db.RoleAdmin {
+		return p.Role
+	}
+	if r, ok := p.Groups[groupPath]; ok && r.Valid() {
+		return r
+	}
+	return p.Role
+}
+
+// accessClaims are the claims of an access token. The subject is the
+// username.
+type accessClaims struct {
+	jwt.RegisteredClaims
+	Role   db.Role            `json:"role"`
+	Groups map[string]db.Role `json:"groups,omitempty"`
+}
+
+// IssueAccessToken creates a signed HS256 JWT for the given principal.
+func IssueAccessToken(secret string, p Principal, ttl time.Duration) (string, error) {
+	claims := accessClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   p.Username,
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(ttl)),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+		},
+		Role:   p.Role,
+		Groups: p.Groups,
 	}
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 	return token.SignedString([]byte(secret))
 }
 
-// ValidateAccessToken parses and validates a JWT, returning the subject (username).
-func ValidateAccessToken(secret, tokenStr string) (string, error) {
-	token, err := jwt.ParseWithClaims(tokenStr, &jwt.RegisteredClaims{}, func(t *jwt.Token) (any, error) {
+// ValidateAccessToken parses and validates a JWT, returning its principal.
+// Tokens without a valid role, such as those issued before roles existed,
+// are rejected so the client refreshes them.
+func ValidateAccessToken(secret, tokenStr string) (*Principal, error) {
+	token, err := jwt.ParseWithClaims(tokenStr, &accessClaims{}, func(t *jwt.Token) (any, error) {
 		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
 			return nil, fmt.Errorf("unexpected signing method: %v", t.Header["alg"])
 		}
 		return []byte(secret), nil
 	})
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-	claims, ok := token.Claims.(*jwt.RegisteredClaims)
+	claims, ok := token.Claims.(*accessClaims)
 	if !ok || !token.Valid {
-		return "", errors.New("invalid token")
+		return nil, errors.New("invalid token")
+	}
+	if !claims.Role.Valid() {
+		return nil, errors.New("token has no role")
 	}
-	return claims.Subject, nil
+	return &Principal{Username: claims.Subject, Role: claims.Role, Groups: claims.Groups}, nil
 }
 
 // GenerateRefreshToken returns a cryptographically random 32-byte hex string.
@@ -51,16 +94,172 @@ func GenerateRefreshToken() (string, error) {
 	return hex.EncodeToString(b), nil
 }
 
-// contextKey is used to store the authenticated username in request context.
+// APITokenPrefix starts every API token, telling them apart from access
+// tokens.
+const APITokenPrefix = "aw_"
+
+// GenerateAPIToken returns a new API token and the hash to store for it.
+func GenerateAPIToken() (token, hash string, err error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	token = APITokenPrefix + hex.EncodeToString(b)
+	return token, HashAPIToken(token), nil
+}
+
+// HashAPIToken returns the form API tokens are stored and looked up in.
+func HashAPIToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// scopeFor returns the scope an API token needs for r, or "" if API tokens
+// may not make the request at all, as for account changes.
+func scopeFor(r *http.Request) db.Scope {
+	switch {
+	case strings.HasPrefix(r.URL.Path, "/terminal/"):
+		return db.ScopeTerminal
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		return db.ScopeRead
+	case strings.HasPrefix(r.URL.Path, "/api/sessions"):
+		return db.ScopeSessionsWrite
+	}
+	return ""
+}
+
+// allows reports whether the principal's scopes permit r. Its role is
+// checked separately.
+func (p *Principal) allows(r *http.Request) bool {
+	if p.Scopes == nil {
+		return true
+	}
+	need := scopeFor(r)
+	if need == "" {
+		return false
+	}
+	for _, s := range p.Scopes {
+		if s == need {
+			return true
+		}
+	}
+	return false
+}
+
+// contextKey is used to store the authenticated user in request context.
 type contextKey string
 
-const usernameKey contextKey = "username"
+const (
+	usernameKey  contextKey = "username"
+	principalKey contextKey = "principal"
+)
+
+// requestUsername returns the authenticated username, or "" when
+// authentication is disabled.
+func requestUsername(r *http.Request) string {
+	name, _ := r.Context().Value(usernameKey).(string)
+	return name
+}
+
+// requestRole returns the caller's role in the group at groupPath. Without
+// authentication every caller is an admin.
+func requestRole(r *http.Request, groupPath string) db.Role {
+	p, ok := r.Context().Value(principalKey).(*Principal)
+	if !ok {
+		return db.RoleAdmin
+	}
+	return p.RoleIn(groupPath)
+}
+
+// authorize wraps h so it only runs for callers with at least the min role.
+// For routes on a session ({id}) or group ({path}) the caller's role in that
+// group applies. Unknown sessions are passed through so h can answer 404.
+func (s *Server) authorize(min db.Role, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		groupPath := r.PathValue("path")
+		if id := r.PathValue("id"); id != "" {
+			if sess, err := s.store.GetSession(id); err == nil && sess != nil {
+				groupPath = sess.GroupPath
+			}
+		}
+		if !requestRole(r, groupPath).Allows(min) {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		h(w, r)
+	}
+}
+
+// principalFor loads the account's role and group permissions for a new
+// access token.
+func (s *Server) principalFor(acc *db.Account) (Principal, error) {
+	groups, err := s.store.GetGroupPermissions(acc.ID)
+	if err != nil {
+		return Principal{}, err
+	}
+	if len(groups) == 0 {
+		groups = nil
+	}
+	return Principal{Username: acc.Username, Role: acc.Role, Groups: groups}, nil
+}
+
+// accountActive reports whether username is an existing, enabled account.
+func (s *Server) accountActive(username string) bool {
+	acc, err := s.store.GetAccountByUsername(username)
+	return err == nil && !acc.Disabled
+}
+
+// authenticate returns the principal of an access token or API token, or
+// nil if the token is invalid, expired or belongs to an account that was
+// disabled or deleted.
+func (s *Server) authenticate(tokenStr string) *Principal {
+	if strings.HasPrefix(tokenStr, APITokenPrefix) {
+		return s.apiTokenPrincipal(tokenStr)
+	}
+	p, err := ValidateAccessToken(s.cfg.Auth.JWTSecret, tokenStr)
+	if err != nil || !s.accountActive(p.Username) {
+		return nil
+	}
+	return p
+}
+
+// apiTokenPrincipal looks up an API token and records its use. The token
+// acts with its account's current roles, limited to its scopes.
+func (s *Server) apiTokenPrincipal(tokenStr string) *Principal {
+	t, err := s.store.GetAPITokenByHash(HashAPIToken(tokenStr))
+	if err != nil || (!t.ExpiresAt.IsZero() && time.Now().After(t.ExpiresAt)) {
+		return nil
+	}
+	acc, err := s.store.GetAccountByID(t.AccountID)
+	if err != nil || acc.Disabled {
+		return nil
+	}
+	p, err := s.principalFor(acc)
+	if err != nil {
+		return nil
+	}
+	p.Scopes = append([]db.Scope{}, t.Scopes...)
+	s.store.TouchAPIToken(t.ID)
+	return &p
+}
+
+// pruneRefreshTokens deletes expired refresh tokens now and then hourly.
+func (s *Server) pruneRefreshTokens() {
+	for {
+		s.store.DeleteExpiredRefreshTokens()
+		time.Sleep(time.Hour)
+	}
+}
 
 // jwtMiddleware validates the Bearer token in the Authorization header.
 // /api/ routes (excluding /api/auth/), /terminal/, and /events are protected.
 // Static files and the login page are always served without authentication.
-// SSE and terminal connections may pass the token as ?token= query param.
-func jwtMiddleware(secret string, next http.Handler) http.Handler {
+// Access tokens of disabled or deleted accounts are refused at once, and API
+// tokens outside their scopes. The event stream and terminal pages, which
+// cannot send headers, take a single-use ?ticket= instead; a terminal page
+// trades its ticket for a cookie that authenticates the requests and
+// websocket that follow it.
+func (s *Server) jwtMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Auth endpoints are always public.
 		if strings.HasPrefix(r.URL.Path, "/api/auth/") {
@@ -68,33 +267,59 @@ func jwtMiddleware(secret string, next http.Handler) http.Handler {
 			return
 		}
 		// Protect API routes, terminal proxy, and SSE stream.
-		protected := strings.HasPrefix(r.URL.Path, "/api/") ||
-			strings.HasPrefix(r.URL.Path, "/terminal/") ||
-			r.URL.Path == "/events"
+		terminal := strings.HasPrefix(r.URL.Path, "/terminal/")
+		protected := strings.HasPrefix(r.URL.Path, "/api/") || terminal || r.URL.Path == "/events" || r.URL.Path == "/metrics"
 		if !protected {
 			next.ServeHTTP(w, r)
 			return
 		}
 
-		tokenStr := ""
+		var p *Principal
 		if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
-			tokenStr = strings.TrimPrefix(auth, "Bearer ")
-		} else if q := r.URL.Query().Get("token"); q != "" {
-			tokenStr = q
+			p = s.authenticate(strings.TrimPrefix(auth, "Bearer "))
+		} else if terminal || r.URL.Path == "/events" {
+			p = s.ticketPrincipal(w, r, terminal)
 		}
-
-		if tokenStr == "" {
+		if p == nil {
 			http.Error(w, "unauthorized", http.StatusUnauthorized)
 			return
 		}
-
-		username, err := ValidateAccessToken(secret, tokenStr)
-		if err != nil {
-			http.Error(w, "unauthorized", http.StatusUnauthorized)
+		if !p.allows(r) {
+			http.Error(w, "forbidden: outside the API token's scopes", http.StatusForbidden)
 			return
 		}
 
-		ctx := context.WithValue(r.Context(), usernameKey, username)
+		ctx := context.WithValue(r.Context(), usernameKey, p.Username)
+		ctx = context.WithValue(ctx, principalKey, p)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
+
+// ticketPrincipal authenticates r by its ?ticket= or, on a terminal path,
+// its terminal cookie. A ticket redeemed for a terminal sets the cookie.
+func (s *Server) ticketPrincipal(w http.ResponseWriter, r *http.Request, terminal bool) *Principal {
+	var p *Principal
+	if id := r.URL.Query().Get("ticket"); id != "" {
+		p = s.tickets.take(id, r.URL.Path)
+		if p != nil && terminal {
+			prefix := terminalPrefix(r.URL.Path)
+			http.SetCookie(w, &http.Cookie{
+				Name:     terminalCookie,
+				Value:    s.tickets.issue(p, prefix, terminalGrantTTL),
+				Path:     prefix,
+				HttpOnly: true,
+				Secure:   s.cfg.TLS.Mode != "",
+				SameSite: http.SameSiteStrictMode,
+			})
+		}
+	}
+	// A reloaded terminal page repeats its spent ticket, so fall back to the
+	// cookie.
+	if c, err := r.Cookie(terminalCookie); p == nil && terminal && err == nil {
+		p = s.tickets.get(c.Value, r.URL.Path, terminalGrantTTL)
+	}
+	if p == nil || !s.accountActive(p.Username) {
+		return nil
+	}
+	return p
+}
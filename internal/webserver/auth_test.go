@@ -4,12 +4,17 @@ import (
 	"testing"
 	"time"
 
+	"github.com/zsprackett/agent-workspace/internal/db"
 	"github.com/zsprackett/agent-workspace/internal/webserver"
 )
 
 func TestIssueAndValidateAccessToken(t *testing.T) {
 	secret := "test-secret"
-	token, err := webserver.IssueAccessToken(secret, "alice", time.Hour)
+	token, err := webserver.IssueAccessToken(secret, webserver.Principal{
+		Username: "alice",
+		Role:     db.RoleViewer,
+		Groups:   map[string]db.Role{"work": db.RoleOperator},
+	}, time.Hour)
 	if err != nil {
 		t.Fatalf("IssueAccessToken: %v", err)
 	}
@@ -17,18 +22,35 @@ func TestIssueAndValidateAccessToken(t *testing.T) {
 		t.Fatal("expected non-empty token")
 	}
 
-	username, err := webserver.ValidateAccessToken(secret, token)
+	p, err := webserver.ValidateAccessToken(secret, token)
 	if err != nil {
 		t.Fatalf("ValidateAccessToken: %v", err)
 	}
-	if username != "alice" {
-		t.Errorf("expected alice, got %s", username)
+	if p.Username != "alice" {
+		t.Errorf("expected alice, got %s", p.Username)
+	}
+	if p.RoleIn("work") != db.RoleOperator || p.RoleIn("other") != db.RoleViewer {
+		t.Errorf("roles: work=%s other=%s", p.RoleIn("work"), p.RoleIn("other"))
+	}
+}
+
+func TestValidateAccessToken_NoRole(t *testing.T) {
+	token, _ := webserver.IssueAccessToken("secret", webserver.Principal{Username: "alice"}, time.Hour)
+	if _, err := webserver.ValidateAccessToken("secret", token); err == nil {
+		t.Error("expected error for token without a role")
+	}
+}
+
+func TestPrincipalRoleIn_AdminIgnoresGroups(t *testing.T) {
+	p := webserver.Principal{Role: db.RoleAdmin, Groups: map[string]db.Role{"work": db.RoleViewer}}
+	if p.RoleIn("work") != db.RoleAdmin {
+		t.Errorf("expected admin in every group, got %s", p.RoleIn("work"))
 	}
 }
 
 func TestValidateAccessToken_Expired(t *testing.T) {
 	secret := "test-secret"
-	token, _ := webserver.IssueAccessToken(secret, "alice", -time.Second)
+	token, _ := webserver.IssueAccessToken(secret, webserver.Principal{Username: "alice", Role: db.RoleAdmin}, -time.Second)
 	_, err := webserver.ValidateAccessToken(secret, token)
 	if err == nil {
 		t.Error("expected error for expired token")
@@ -36,7 +58,7 @@ func TestValidateAccessToken_Expired(t *testing.T) {
 }
 
 func TestValidateAccessToken_WrongSecret(t *testing.T) {
-	token, _ := webserver.IssueAccessToken("secret-a", "alice", time.Hour)
+	token, _ := webserver.IssueAccessToken("secret-a", webserver.Principal{Username: "alice", Role: db.RoleAdmin}, time.Hour)
 	_, err := webserver.ValidateAccessToken("secret-b", token)
 	if err == nil {
 		t.Error("expected error for wrong secret")
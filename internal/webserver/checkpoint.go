@@ -0,0 +1,122 @@
+package webserver
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/zsprackett/agent-workspace/internal/checkpoint"
+)
+
+// handleCheckpoints lists the checkpoints of one of the session's repos
+// (?repo=), newest first.
+func (s *Server) handleCheckpoints(w http.ResponseWriter, r *http.Request) {
+	sess, dirs := s.loadGitSession(w, r)
+	if sess == nil {
+		return
+	}
+	d, ok := queryGitDir(w, r, dirs)
+	if !ok {
+		return
+	}
+	cps, err := checkpoint.List(d.Path, sess.ID)
+	if err != nil {
+		http.Error(w, err.Error(), 422)
+		return
+	}
+	repos := make([]string, len(dirs))
+	for i, gd := range dirs {
+		repos[i] = gd.Label
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"repo":        d.Label,
+		"repos":       repos,
+		"checkpoints": cps,
+	})
+}
+
+// handleCreateCheckpoint takes a checkpoint of one repo on demand. It
+// responds 200 with null when nothing changed since the latest checkpoint.
+func (s *Server) handleCreateCheckpoint(w http.ResponseWriter, r *http.Request) {
+	sess, dirs := s.loadGitSession(w, r)
+	if sess == nil {
+		return
+	}
+	d, ok := queryGitDir(w, r, dirs)
+	if !ok {
+		return
+	}
+	var body struct {
+		Message string `json:"message"`
+	}
+	json.NewDecoder(r.Body).Decode(&body)
+	cp, err := checkpoint.Create(d.Path, sess.ID, body.Message)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if cp != nil {
+		w.WriteHeader(http.StatusCreated)
+	}
+	json.NewEncoder(w).Encode(cp)
+}
+
+// handleCheckpointDiff diffs two states of a repo given as ?from= and ?to=,
+// each a checkpoint number, a number followed by ^ for the commit it was
+// taken on, or "current".
+func (s *Server) handleCheckpointDiff(w http.ResponseWriter, r *http.Request) {
+	sess, dirs := s.loadGitSession(w, r)
+	if sess == nil {
+		return
+	}
+	d, ok := queryGitDir(w, r, dirs)
+	if !ok {
+		return
+	}
+	q := r.URL.Query()
+	files, err := checkpoint.Diff(d.Path, sess.ID, q.Get("from"), q.Get("to"))
+	if errors.Is(err, checkpoint.ErrNotFound) {
+		http.Error(w, err.Error(), 404)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"repo": d.Label, "files": files})
+}
+
+// handleRestoreCheckpoint restores checkpoint {n} into the worktree and
+// returns the checkpoint the previous state was saved as.
+func (s *Server) handleRestoreCheckpoint(w http.ResponseWriter, r *http.Request) {
+	sess, dirs := s.loadGitSession(w, r)
+	if sess == nil {
+		return
+	}
+	d, ok := queryGitDir(w, r, dirs)
+	if !ok {
+		return
+	}
+	n, err := strconv.Atoi(r.PathValue("n"))
+	if err != nil {
+		http.Error(w, "invalid checkpoint", 400)
+		return
+	}
+	backup, err := checkpoint.Restore(d.Path, sess.ID, n)
+	if errors.Is(err, checkpoint.ErrNotFound) {
+		http.Error(w, err.Error(), 404)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	detail, _ := json.Marshal(map[string]any{"dir": d.Path, "n": n})
+	s.store.InsertSessionEvent(sess.ID, "checkpoint_restored", string(detail))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"backup": backup})
+}
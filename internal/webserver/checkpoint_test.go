@@ -0,0 +1,69 @@
+package webserver_test
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCheckpointEndpoints(t *testing.T) {
+	srv, store := newServer(t)
+	dir := initGitRepo(t)
+	seedSession(t, store, dir)
+
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		w := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(w, req)
+		return w
+	}
+
+	os.WriteFile(filepath.Join(dir, "README.md"), []byte("checkpointed\n"), 0644)
+	w := do("POST", "/api/sessions/git-test-id/checkpoints", `{"message":"manual"}`)
+	if w.Code != 201 {
+		t.Fatalf("create: expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := do("POST", "/api/sessions/git-test-id/checkpoints", ""); w.Code != 200 || strings.TrimSpace(w.Body.String()) != "null" {
+		t.Errorf("unchanged: expected 200 null, got %d %s", w.Code, w.Body.String())
+	}
+
+	w = do("GET", "/api/sessions/git-test-id/checkpoints", "")
+	var list struct {
+		Checkpoints []struct {
+			N       int
+			Message string
+		}
+	}
+	json.NewDecoder(w.Body).Decode(&list)
+	if len(list.Checkpoints) != 1 || list.Checkpoints[0].N != 1 || list.Checkpoints[0].Message != "manual" {
+		t.Fatalf("list: %+v", list)
+	}
+
+	w = do("GET", "/api/sessions/git-test-id/checkpoints/diff?from=1^&to=1", "")
+	var diff struct {
+		Files []struct {
+			NewPath string `json:"new_path"`
+		}
+	}
+	json.NewDecoder(w.Body).Decode(&diff)
+	if w.Code != 200 || len(diff.Files) != 1 || diff.Files[0].NewPath != "README.md" {
+		t.Errorf("diff: %d %+v", w.Code, diff)
+	}
+	if w := do("GET", "/api/sessions/git-test-id/checkpoints/diff?from=7&to=current", ""); w.Code != 404 {
+		t.Errorf("diff of missing checkpoint: expected 404, got %d", w.Code)
+	}
+
+	os.WriteFile(filepath.Join(dir, "README.md"), []byte("overwritten\n"), 0644)
+	if w := do("POST", "/api/sessions/git-test-id/checkpoints/1/restore", ""); w.Code != 200 {
+		t.Fatalf("restore: expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if data, _ := os.ReadFile(filepath.Join(dir, "README.md")); string(data) != "checkpointed\n" {
+		t.Errorf("restored README.md: %q", data)
+	}
+	if w := do("POST", "/api/sessions/git-test-id/checkpoints/9/restore", ""); w.Code != 404 {
+		t.Errorf("restore missing: expected 404, got %d", w.Code)
+	}
+}
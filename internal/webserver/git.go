@@ -6,7 +6,15 @@ import (
 	"html"
 	"net/http"
 	"os/exec"
+	"path/filepath"
+	"strconv"
 	"strings"
+
+	"github.com/zsprackett/agent-workspace/internal/compare"
+	"github.com/zsprackett/agent-workspace/internal/db"
+	"github.com/zsprackett/agent-workspace/internal/gitdiff"
+	"github.com/zsprackett/agent-workspace/internal/gitlog"
+	"github.com/zsprackett/agent-workspace/internal/session"
 )
 
 const gitPageTmpl = `<!DOCTYPE html><html><head><title>%s</title><meta charset="UTF-8">` +
@@ -39,54 +47,79 @@ func ColorDiffLines(output string) string {
 	return sb.String()
 }
 
-func (s *Server) handleGitStatus(w http.ResponseWriter, r *http.Request) {
-	id := r.PathValue("id")
-	sess, err := s.store.GetSession(id)
-	if err != nil || sess == nil {
-		http.Error(w, "session not found", 404)
-		return
+// gitDir is a directory git commands run in for a session. Label names the
+// repo for multi-repo sessions and is empty otherwise.
+type gitDir struct {
+	Label string
+	Path  string
+}
+
+// sessionGitDirs returns the directories to run git in for sess: one per
+// worktree of a multi-repo session, or its single worktree or project path.
+func (s *Server) sessionGitDirs(sess *db.Session) []gitDir {
+	if wts := session.Worktrees(s.store, sess); len(wts) > 1 {
+		dirs := make([]gitDir, len(wts))
+		for i, wt := range wts {
+			dirs[i] = gitDir{Label: filepath.Base(wt.WorktreePath), Path: wt.WorktreePath}
+		}
+		return dirs
 	}
 	path := sess.WorktreePath
 	if path == "" {
 		path = sess.ProjectPath
 	}
 	if path == "" {
-		http.Error(w, "session has no working directory", 422)
-		return
+		return nil
 	}
-
-	cmd := exec.Command("git", "status")
-	cmd.Dir = path
-	out, _ := cmd.CombinedOutput()
-
-	body := fmt.Sprintf(gitPageTmpl,
-		html.EscapeString("git status — "+sess.Title),
-		html.EscapeString(string(out)))
-	w.Header().Set("Content-Type", "text/html; charset=utf-8")
-	w.Write([]byte(body))
+	return []gitDir{{Path: path}}
 }
 
-func (s *Server) handleGitDiff(w http.ResponseWriter, r *http.Request) {
+// loadGitSession resolves the {id} path value to a session and its git
+// directories, writing a 404 or 422 and returning nil if there are none.
+func (s *Server) loadGitSession(w http.ResponseWriter, r *http.Request) (*db.Session, []gitDir) {
 	id := r.PathValue("id")
 	sess, err := s.store.GetSession(id)
 	if err != nil || sess == nil {
 		http.Error(w, "session not found", 404)
-		return
-	}
-	path := sess.WorktreePath
-	if path == "" {
-		path = sess.ProjectPath
+		return nil, nil
 	}
-	if path == "" {
+	dirs := s.sessionGitDirs(sess)
+	if len(dirs) == 0 {
 		http.Error(w, "session has no working directory", 422)
-		return
+		return nil, nil
 	}
+	return sess, dirs
+}
 
+// runPerDir runs fn in each dir and concatenates the output, preceding each
+// labelled dir's output with a "=== label ===" header.
+func runPerDir(dirs []gitDir, fn func(path string) []byte) []byte {
+	var out []byte
+	for i, d := range dirs {
+		if d.Label != "" {
+			if i > 0 {
+				out = append(out, '\n')
+			}
+			out = append(out, fmt.Sprintf("=== %s ===\n", d.Label)...)
+		}
+		out = append(out, fn(d.Path)...)
+	}
+	return out
+}
+
+func gitStatusOutput(path string) []byte {
+	cmd := exec.Command("git", "status")
+	cmd.Dir = path
+	out, _ := cmd.CombinedOutput()
+	return out
+}
+
+// gitDiffOutput returns the diff of path against HEAD, including untracked files.
+func gitDiffOutput(path string) []byte {
 	cmd := exec.Command("git", "diff", "HEAD")
 	cmd.Dir = path
 	out, _ := cmd.CombinedOutput()
 
-	// Also append untracked files.
 	untrackedCmd := exec.Command("git", "ls-files", "--others", "--exclude-standard")
 	untrackedCmd.Dir = path
 	untracked, _ := untrackedCmd.Output()
@@ -101,6 +134,33 @@ func (s *Server) handleGitDiff(w http.ResponseWriter, r *http.Request) {
 			out = append(out, diffOut...)
 		}
 	}
+	return out
+}
+
+func (s *Server) handleGitStatus(w http.ResponseWriter, r *http.Request) {
+	sess, dirs := s.loadGitSession(w, r)
+	if sess == nil {
+		return
+	}
+	out := runPerDir(dirs, gitStatusOutput)
+
+	body := fmt.Sprintf(gitPageTmpl,
+		html.EscapeString("git status — "+sess.Title),
+		html.EscapeString(string(out)))
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte(body))
+}
+
+func (s *Server) handleGitDiff(w http.ResponseWriter, r *http.Request) {
+	sess, dirs := s.loadGitSession(w, r)
+	if sess == nil {
+		return
+	}
+	if r.URL.Query().Get("format") == "json" {
+		s.writeDiffJSON(w, dirs)
+		return
+	}
+	out := runPerDir(dirs, gitDiffOutput)
 
 	body := fmt.Sprintf(gitPageTmpl,
 		html.EscapeString("git diff — "+sess.Title),
@@ -110,93 +170,336 @@ func (s *Server) handleGitDiff(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *Server) handleGitStatusText(w http.ResponseWriter, r *http.Request) {
-	id := r.PathValue("id")
-	sess, err := s.store.GetSession(id)
-	if err != nil || sess == nil {
-		http.Error(w, "session not found", 404)
+	sess, dirs := s.loadGitSession(w, r)
+	if sess == nil {
 		return
 	}
-	path := sess.WorktreePath
-	if path == "" {
-		path = sess.ProjectPath
-	}
-	if path == "" {
-		http.Error(w, "session has no working directory", 422)
+	out := runPerDir(dirs, gitStatusOutput)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"output": string(out)})
+}
+
+func (s *Server) handleGitDiffText(w http.ResponseWriter, r *http.Request) {
+	sess, dirs := s.loadGitSession(w, r)
+	if sess == nil {
 		return
 	}
-
-	cmd := exec.Command("git", "status")
-	cmd.Dir = path
-	out, _ := cmd.CombinedOutput()
+	out := runPerDir(dirs, gitDiffOutput)
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{"output": string(out)})
 }
 
-func (s *Server) handleGitDiffText(w http.ResponseWriter, r *http.Request) {
-	id := r.PathValue("id")
-	sess, err := s.store.GetSession(id)
-	if err != nil || sess == nil {
-		http.Error(w, "session not found", 404)
+// repoChanges are the parsed uncommitted changes of one of a session's repos.
+type repoChanges struct {
+	Repo string `json:"repo"`
+	*gitdiff.Changes
+}
+
+// writeDiffJSON writes the staged and unstaged changes of each dir as files,
+// hunks and numbered lines.
+func (s *Server) writeDiffJSON(w http.ResponseWriter, dirs []gitDir) {
+	repos := make([]repoChanges, 0, len(dirs))
+	for _, d := range dirs {
+		c, err := gitdiff.Load(d.Path)
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		repos = append(repos, repoChanges{Repo: d.Label, Changes: c})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"repos": repos})
+}
+
+// findGitDir returns the dir labelled repo. An empty repo matches the only
+// dir of a single-repo session.
+func findGitDir(dirs []gitDir, repo string) (gitDir, bool) {
+	for _, d := range dirs {
+		if d.Label == repo {
+			return d, true
+		}
+	}
+	if repo == "" && len(dirs) == 1 {
+		return dirs[0], true
+	}
+	return gitDir{}, false
+}
+
+// handleGitApply returns a handler that stages, unstages or discards a file
+// or hunk. A missing hunk, or -1, means the whole file; header must match
+// the hunk the client saw so a changed diff is reported as a conflict
+// instead of acting on the wrong lines.
+func (s *Server) handleGitApply(action string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		sess, dirs := s.loadGitSession(w, r)
+		if sess == nil {
+			return
+		}
+		var req struct {
+			Repo   string `json:"repo"`
+			Path   string `json:"path"`
+			Hunk   *int   `json:"hunk"`
+			Header string `json:"header"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Path == "" {
+			http.Error(w, "path is required", 400)
+			return
+		}
+		d, ok := findGitDir(dirs, req.Repo)
+		if !ok {
+			http.Error(w, "repo not found", 404)
+			return
+		}
+		hunk := -1
+		if req.Hunk != nil {
+			hunk = *req.Hunk
+		}
+		switch err := gitdiff.Apply(d.Path, action, req.Path, hunk, req.Header); err {
+		case nil:
+			s.refreshDirty(sess)
+			w.WriteHeader(http.StatusNoContent)
+		case gitdiff.ErrNotFound:
+			http.Error(w, err.Error(), 404)
+		case gitdiff.ErrStale:
+			http.Error(w, err.Error(), 409)
+		default:
+			http.Error(w, err.Error(), 500)
+		}
+	}
+}
+
+// handleGitCommit commits the staged changes with the given message. With no
+// repo it commits every repo of the session that has staged changes.
+func (s *Server) handleGitCommit(w http.ResponseWriter, r *http.Request) {
+	sess, dirs := s.loadGitSession(w, r)
+	if sess == nil {
 		return
 	}
-	path := sess.WorktreePath
-	if path == "" {
-		path = sess.ProjectPath
+	var req struct {
+		Message string `json:"message"`
+		Repo    string `json:"repo"`
 	}
-	if path == "" {
-		http.Error(w, "session has no working directory", 422)
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || strings.TrimSpace(req.Message) == "" {
+		http.Error(w, "message is required", 400)
 		return
 	}
+	if req.Repo != "" || len(dirs) == 1 {
+		d, ok := findGitDir(dirs, req.Repo)
+		if !ok {
+			http.Error(w, "repo not found", 404)
+			return
+		}
+		dirs = []gitDir{d}
+	}
+	type commit struct {
+		Repo string `json:"repo"`
+		Hash string `json:"hash"`
+	}
+	commits := []commit{}
+	for _, d := range dirs {
+		if staged, err := gitdiff.HasStaged(d.Path); err != nil || !staged {
+			continue
+		}
+		hash, err := gitdiff.Commit(d.Path, req.Message)
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		commits = append(commits, commit{Repo: d.Label, Hash: hash})
+	}
+	if len(commits) == 0 {
+		http.Error(w, "nothing staged to commit", 422)
+		return
+	}
+	s.refreshDirty(sess)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"commits": commits})
+}
 
-	cmd := exec.Command("git", "diff", "HEAD")
-	cmd.Dir = path
-	out, _ := cmd.CombinedOutput()
+// queryGitDir returns the dir named by ?repo=, or the first dir if it is
+// unset, writing a 404 if there is no such repo.
+func queryGitDir(w http.ResponseWriter, r *http.Request, dirs []gitDir) (gitDir, bool) {
+	repo := r.URL.Query().Get("repo")
+	if repo == "" {
+		return dirs[0], true
+	}
+	d, ok := findGitDir(dirs, repo)
+	if !ok {
+		http.Error(w, "repo not found", 404)
+	}
+	return d, ok
+}
 
-	untrackedCmd := exec.Command("git", "ls-files", "--others", "--exclude-standard")
-	untrackedCmd.Dir = path
-	untracked, _ := untrackedCmd.Output()
-	if len(strings.TrimSpace(string(untracked))) > 0 {
-		for _, f := range strings.Split(strings.TrimSpace(string(untracked)), "\n") {
-			if f == "" {
-				continue
-			}
-			diffCmd := exec.Command("git", "diff", "--no-index", "--", "/dev/null", f)
-			diffCmd.Dir = path
-			diffOut, _ := diffCmd.Output()
-			out = append(out, diffOut...)
+// groupBaseBranch returns the base branch configured on the session's group.
+func (s *Server) groupBaseBranch(sess *db.Session) string {
+	groups, _ := s.store.LoadGroups()
+	for _, g := range groups {
+		if g.Path == sess.GroupPath {
+			return g.BaseBranch
 		}
 	}
+	return ""
+}
+
+// handleGitLog returns a page of the commit history of one of the session's
+// repos (?repo=, default the first). By default only commits since the base
+// branch are listed; ?base= overrides the base and ?all=1 lists everything.
+// ?skip= and ?limit= (default 50, at most 500) page through the result.
+func (s *Server) handleGitLog(w http.ResponseWriter, r *http.Request) {
+	sess, dirs := s.loadGitSession(w, r)
+	if sess == nil {
+		return
+	}
+	d, ok := queryGitDir(w, r, dirs)
+	if !ok {
+		return
+	}
+	q := r.URL.Query()
+	opts := gitlog.Options{Limit: 50}
+	if n, err := strconv.Atoi(q.Get("limit")); err == nil && n > 0 {
+		opts.Limit = min(n, 500)
+	}
+	if n, err := strconv.Atoi(q.Get("skip")); err == nil && n > 0 {
+		opts.Skip = n
+	}
+	switch {
+	case q.Get("all") == "1":
+	case q.Has("base"):
+		opts.Base = q.Get("base")
+	default:
+		opts.Base = gitlog.DefaultBase(d.Path, s.groupBaseBranch(sess), s.cfg.DefaultBaseBranch)
+	}
 
+	// Fetch one extra commit to tell whether there is another page.
+	opts.Limit++
+	commits, err := gitlog.Log(d.Path, opts)
+	if err != nil {
+		http.Error(w, err.Error(), 422)
+		return
+	}
+	more := len(commits) == opts.Limit
+	if more {
+		commits = commits[:len(commits)-1]
+	}
+	repos := make([]string, len(dirs))
+	for i, gd := range dirs {
+		repos[i] = gd.Label
+	}
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{"output": string(out)})
+	json.NewEncoder(w).Encode(map[string]any{
+		"repo":    d.Label,
+		"repos":   repos,
+		"base":    opts.Base,
+		"commits": commits,
+		"more":    more,
+	})
 }
 
-func (s *Server) handlePRURL(w http.ResponseWriter, r *http.Request) {
-	id := r.PathValue("id")
-	sess, err := s.store.GetSession(id)
-	if err != nil || sess == nil {
-		http.Error(w, "session not found", 404)
+// handleGitCommitDetail returns the message and parsed diff of commit {sha}
+// in one of the session's repos (?repo=, default the first).
+func (s *Server) handleGitCommitDetail(w http.ResponseWriter, r *http.Request) {
+	sess, dirs := s.loadGitSession(w, r)
+	if sess == nil {
 		return
 	}
-	path := sess.WorktreePath
-	if path == "" {
-		path = sess.ProjectPath
+	d, ok := queryGitDir(w, r, dirs)
+	if !ok {
+		return
 	}
-	if path == "" {
-		http.Error(w, "session has no working directory", 422)
+	detail, err := gitlog.Show(d.Path, r.PathValue("sha"))
+	if err == gitlog.ErrNotFound {
+		http.Error(w, err.Error(), 404)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), 500)
 		return
 	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(detail)
+}
 
-	cmd := exec.Command("gh", "pr", "view", "--json", "url", "--jq", ".url")
-	cmd.Dir = path
-	out, err := cmd.Output()
-	url := strings.TrimSpace(string(out))
-	if err != nil || url == "" {
+// prLink is an open pull request for one of a session's repos.
+type prLink struct {
+	Repo string `json:"repo,omitempty"`
+	URL  string `json:"url"`
+}
+
+// handlePRURL returns the open PR for the session's branch. For multi-repo
+// sessions "prs" lists the PR of every repo that has one and "url" is the
+// first of them.
+func (s *Server) handlePRURL(w http.ResponseWriter, r *http.Request) {
+	sess, dirs := s.loadGitSession(w, r)
+	if sess == nil {
+		return
+	}
+
+	var prs []prLink
+	for _, d := range dirs {
+		cmd := exec.Command("gh", "pr", "view", "--json", "url", "--jq", ".url")
+		cmd.Dir = d.Path
+		out, err := cmd.Output()
+		url := strings.TrimSpace(string(out))
+		if err == nil && url != "" {
+			prs = append(prs, prLink{Repo: d.Label, URL: url})
+		}
+	}
+	if len(prs) == 0 {
 		http.Error(w, "no open PR found for this branch", 404)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	fmt.Fprintf(w, `{"url":%q}`, url)
+	json.NewEncoder(w).Encode(map[string]any{"url": prs[0].URL, "prs": prs})
+}
+
+// handleCompareSiblings lists the sessions the session can be compared with.
+func (s *Server) handleCompareSiblings(w http.ResponseWriter, r *http.Request) {
+	sess, err := s.store.GetSession(r.PathValue("id"))
+	if err != nil || sess == nil {
+		http.Error(w, "session not found", 404)
+		return
+	}
+	type sibling struct {
+		ID     string `json:"id"`
+		Title  string `json:"title"`
+		Branch string `json:"branch"`
+	}
+	siblings := []sibling{}
+	if sess.WorktreeRepo != "" {
+		list, err := compare.Siblings(s.store, sess)
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		for _, o := range list {
+			siblings = append(siblings, sibling{ID: o.ID, Title: o.Title, Branch: o.WorktreeBranch})
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"siblings": siblings})
+}
+
+// handleCompare compares the branches of the session and {other}: the diff
+// between them, per-file stats and each side's check state.
+func (s *Server) handleCompare(w http.ResponseWriter, r *http.Request) {
+	a, err := s.store.GetSession(r.PathValue("id"))
+	if err != nil || a == nil {
+		http.Error(w, "session not found", 404)
+		return
+	}
+	b, err := s.store.GetSession(r.PathValue("other"))
+	if err != nil || b == nil {
+		http.Error(w, "session not found", 404)
+		return
+	}
+	res, err := compare.Sessions(s.store, a, b)
+	if err != nil {
+		http.Error(w, err.Error(), 422)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
 }
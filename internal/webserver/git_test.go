@@ -6,6 +6,7 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
@@ -254,3 +255,129 @@ func TestHandleGitDiffText_WithRepo(t *testing.T) {
 	// Clean repo has no diff — output field must exist but may be empty string.
 	_ = body.Output
 }
+
+func TestHandleGitDiffJSON_StageAndCommit(t *testing.T) {
+	srv, store := newServer(t)
+	repoDir := initGitRepo(t)
+	seedSession(t, store, repoDir)
+	os.WriteFile(filepath.Join(repoDir, "README.md"), []byte("hello world\n"), 0644)
+
+	req := httptest.NewRequest("GET", "/api/sessions/git-test-id/git/diff?format=json", nil)
+	w := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w, req)
+	if w.Code != 200 {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var body struct {
+		Repos []struct {
+			Staged   []json.RawMessage
+			Unstaged []struct {
+				NewPath string `json:"new_path"`
+				Hunks   []struct{ Header string }
+			}
+		}
+	}
+	if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if len(body.Repos) != 1 || len(body.Repos[0].Unstaged) != 1 || body.Repos[0].Unstaged[0].NewPath != "README.md" {
+		t.Fatalf("unexpected diff: %+v", body)
+	}
+	header := body.Repos[0].Unstaged[0].Hunks[0].Header
+
+	post := func(path, payload string) int {
+		req := httptest.NewRequest("POST", path, strings.NewReader(payload))
+		w := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(w, req)
+		return w.Code
+	}
+	if code := post("/api/sessions/git-test-id/git/stage", `{"path":"README.md","hunk":0,"header":"@@ old @@"}`); code != 409 {
+		t.Errorf("stale header: expected 409, got %d", code)
+	}
+	if code := post("/api/sessions/git-test-id/git/stage", `{"path":"nope.md","hunk":-1}`); code != 404 {
+		t.Errorf("unknown file: expected 404, got %d", code)
+	}
+	if code := post("/api/sessions/git-test-id/git/commit", `{"message":"too early"}`); code != 422 {
+		t.Errorf("nothing staged: expected 422, got %d", code)
+	}
+	payload, _ := json.Marshal(map[string]any{"path": "README.md", "hunk": 0, "header": header})
+	if code := post("/api/sessions/git-test-id/git/stage", string(payload)); code != 204 {
+		t.Fatalf("stage: expected 204, got %d", code)
+	}
+	if code := post("/api/sessions/git-test-id/git/commit", `{"message":"Update readme"}`); code != 200 {
+		t.Fatalf("commit: expected 200, got %d", code)
+	}
+	out, _ := exec.Command("git", "-C", repoDir, "log", "-1", "--format=%s").Output()
+	if got := strings.TrimSpace(string(out)); got != "Update readme" {
+		t.Errorf("expected new commit, got %q", got)
+	}
+}
+
+func TestHandleGitLogAndCommit(t *testing.T) {
+	srv, store := newServer(t)
+	repoDir := initGitRepo(t)
+	seedSession(t, store, repoDir)
+
+	req := httptest.NewRequest("GET", "/api/sessions/git-test-id/git/log?all=1", nil)
+	w := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w, req)
+	if w.Code != 200 {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var page struct {
+		Commits []struct{ Hash, Subject string }
+		More    bool
+	}
+	if err := json.NewDecoder(w.Body).Decode(&page); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if len(page.Commits) != 1 || page.Commits[0].Subject != "init" || page.More {
+		t.Fatalf("unexpected log: %+v", page)
+	}
+
+	req = httptest.NewRequest("GET", "/api/sessions/git-test-id/git/commits/"+page.Commits[0].Hash, nil)
+	w = httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w, req)
+	if w.Code != 200 {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var detail struct {
+		Message string
+		Files   []struct {
+			NewPath string `json:"new_path"`
+		}
+	}
+	if err := json.NewDecoder(w.Body).Decode(&detail); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if detail.Message != "init" || len(detail.Files) != 1 || detail.Files[0].NewPath != "README.md" {
+		t.Errorf("unexpected commit: %+v", detail)
+	}
+
+	req = httptest.NewRequest("GET", "/api/sessions/git-test-id/git/commits/deadbeef", nil)
+	w = httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w, req)
+	if w.Code != 404 {
+		t.Errorf("unknown commit: expected 404, got %d", w.Code)
+	}
+}
+
+func TestHandleGitApplyWithoutHunkActsOnFile(t *testing.T) {
+	srv, store := newServer(t)
+	repoDir := initGitRepo(t)
+	seedSession(t, store, repoDir)
+	os.WriteFile(filepath.Join(repoDir, "README.md"), []byte("hello world\n"), 0644)
+
+	// With no hunk the header is not checked, so a stale one does not matter.
+	req := httptest.NewRequest("POST", "/api/sessions/git-test-id/git/stage",
+		strings.NewReader(`{"path":"README.md","header":"@@ old @@"}`))
+	w := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w, req)
+	if w.Code != 204 {
+		t.Fatalf("stage: expected 204, got %d: %s", w.Code, w.Body.String())
+	}
+	out, _ := exec.Command("git", "-C", repoDir, "diff", "--cached", "--name-only").Output()
+	if got := strings.TrimSpace(string(out)); got != "README.md" {
+		t.Errorf("expected README.md staged, got %q", got)
+	}
+}
@@ -0,0 +1,66 @@
+package webserver
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/zsprackett/agent-workspace/internal/metrics"
+)
+
+// handleMetrics serves metrics in the Prometheus text format: the counters
+// kept by the rest of agent-workspace and gauges of the current state.
+func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", metrics.ContentType)
+	metrics.Default.Write(w, s.gauges()...)
+}
+
+// gauges reads the current state of sessions, usage and connections.
+func (s *Server) gauges() []metrics.Metric {
+	sessions := metrics.NewGaugeVec("agent_workspace_sessions",
+		"Sessions by status, tool and group.", "status", "tool", "group")
+	dirty := metrics.NewGaugeVec("agent_workspace_dirty_worktrees",
+		"Sessions whose worktrees have uncommitted changes, by group.", "group")
+	if all, err := s.store.LoadSessions(); err == nil {
+		for _, sess := range all {
+			sessions.Add(1, string(sess.Status), string(sess.Tool), sess.GroupPath)
+			if sess.WorktreePath != "" && sess.HasUncommitted {
+				dirty.Add(1, sess.GroupPath)
+			}
+		}
+	}
+
+	util := metrics.NewGaugeVec("agent_workspace_usage_utilization_percent",
+		"Claude usage of each rate-limit window, from the latest snapshot.", "window")
+	taken := metrics.NewGaugeVec("agent_workspace_usage_snapshot_timestamp_seconds",
+		"When the latest usage snapshot was taken, in Unix seconds.")
+	if snaps, err := s.store.GetUsageSnapshots(1); err == nil && len(snaps) > 0 {
+		snap := snaps[0]
+		util.Set(snap.FiveHourUtil, "five_hour")
+		util.Set(snap.SevenDayUtil, "seven_day")
+		if snap.ExtraEnabled {
+			util.Set(snap.ExtraUtilization, "extra")
+		}
+		taken.Set(float64(snap.TsMs) / 1000)
+	}
+
+	terminals := metrics.NewGaugeVec("agent_workspace_terminal_connections",
+		"Open web terminal connections.")
+	terminals.Set(float64(s.terminals.count()))
+	viewers := metrics.NewGaugeVec("agent_workspace_share_viewers",
+		"Viewers watching a session through a share link.")
+	viewers.Set(float64(s.shareViewers.Load()))
+
+	return []metrics.Metric{sessions, dirty, util, taken, terminals, viewers}
+}
+
+// startMetrics serves /metrics alone, without authentication, on
+// MetricsAddr.
+func (s *Server) startMetrics() {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /metrics", s.handleMetrics)
+	go func() {
+		if err := http.ListenAndServe(s.cfg.MetricsAddr, mux); err != nil && err != http.ErrServerClosed {
+			fmt.Printf("webserver: metrics: %v\n", err)
+		}
+	}()
+}
@@ -0,0 +1,94 @@
+package webserver_test
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zsprackett/agent-workspace/internal/db"
+	"github.com/zsprackett/agent-workspace/internal/session"
+	"github.com/zsprackett/agent-workspace/internal/webserver"
+)
+
+func TestMetricsEndpoint(t *testing.T) {
+	srv, store := newAuthServer(t)
+	now := time.Now()
+	for _, s := range []*db.Session{
+		{ID: "s1", Title: "a", GroupPath: "g", Tool: db.ToolClaude, Status: db.StatusRunning, WorktreePath: "/tmp/a", HasUncommitted: true},
+		{ID: "s2", Title: "b", GroupPath: "g", Tool: db.ToolClaude, Status: db.StatusRunning},
+		{ID: "s3", Title: "c", GroupPath: "h", Tool: db.ToolShell, Status: db.StatusStopped},
+	} {
+		s.CreatedAt, s.LastAccessed = now, now
+		store.SaveSession(s)
+	}
+	store.InsertUsageSnapshot(db.UsageSnapshot{TsMs: 1767000000000, FiveHourUtil: 42.5, SevenDayUtil: 7})
+
+	w := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	if w.Code != 401 {
+		t.Fatalf("expected metrics to need a login, got %d", w.Code)
+	}
+
+	token, hash, _ := webserver.GenerateAPIToken()
+	alice, _ := store.GetAccountByUsername("alice")
+	store.CreateAPIToken(&db.APIToken{AccountID: alice.ID, Name: "prometheus", Hash: hash, Scopes: []db.Scope{db.ScopeRead}})
+	req := httptest.NewRequest("GET", "/metrics", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	w = httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w, req)
+	if w.Code != 200 || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/plain") {
+		t.Fatalf("expected 200 text, got %d %q", w.Code, w.Header().Get("Content-Type"))
+	}
+	body := w.Body.String()
+	for _, want := range []string{
+		`agent_workspace_sessions{status="running",tool="claude",group="g"} 2`,
+		`agent_workspace_sessions{status="stopped",tool="shell",group="h"} 1`,
+		`agent_workspace_dirty_worktrees{group="g"} 1`,
+		`agent_workspace_usage_utilization_percent{window="five_hour"} 42.5`,
+		`agent_workspace_usage_snapshot_timestamp_seconds 1.767e+09`,
+		`agent_workspace_terminal_connections 0`,
+		"# TYPE agent_workspace_monitor_tick_duration_seconds histogram",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("missing %q in:\n%s", want, body)
+		}
+	}
+}
+
+func TestMetricsListener(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	store, _ := db.Open(":memory:")
+	store.Migrate()
+	defer store.Close()
+	// The listener serves metrics without a login even with the web UI off.
+	srv := webserver.New(store, session.NewManager(store), webserver.Config{MetricsAddr: addr})
+	if err := srv.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	var resp *http.Response
+	for i := 0; i < 50; i++ {
+		if resp, err = http.Get("http://" + addr + "/metrics"); err == nil {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != 200 || !strings.Contains(string(body), "agent_workspace_terminal_connections 0") {
+		t.Errorf("unexpected response %d:\n%s", resp.StatusCode, body)
+	}
+}
@@ -0,0 +1,115 @@
+package webserver
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/zsprackett/agent-workspace/internal/db"
+	"github.com/zsprackett/agent-workspace/internal/review"
+)
+
+// handleReviewComments lists the session's open review comments, or all of
+// them with ?all=1.
+func (s *Server) handleReviewComments(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if sess, err := s.store.GetSession(id); err != nil || sess == nil {
+		http.Error(w, "session not found", 404)
+		return
+	}
+	comments, err := s.store.GetReviewComments(id, r.URL.Query().Get("all") == "1")
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if comments == nil {
+		comments = []*db.ReviewComment{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"comments": comments})
+}
+
+// handleAddReviewComment adds a comment on a line range of a file. Commit is
+// empty for comments on uncommitted changes.
+func (s *Server) handleAddReviewComment(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if sess, err := s.store.GetSession(id); err != nil || sess == nil {
+		http.Error(w, "session not found", 404)
+		return
+	}
+	var body struct {
+		Repo      string `json:"repo"`
+		File      string `json:"file"`
+		LineStart int    `json:"line_start"`
+		LineEnd   int    `json:"line_end"`
+		Commit    string `json:"commit"`
+		Body      string `json:"body"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "bad request", 400)
+		return
+	}
+	if body.File == "" || strings.TrimSpace(body.Body) == "" || body.LineStart < 1 {
+		http.Error(w, "file, line_start and body are required", 400)
+		return
+	}
+	if body.LineEnd < body.LineStart {
+		body.LineEnd = body.LineStart
+	}
+	c := &db.ReviewComment{
+		SessionID: id,
+		Repo:      body.Repo,
+		File:      body.File,
+		LineStart: body.LineStart,
+		LineEnd:   body.LineEnd,
+		Commit:    body.Commit,
+		Body:      strings.TrimSpace(body.Body),
+	}
+	if err := s.store.AddReviewComment(c); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(c)
+}
+
+func (s *Server) handleDeleteReviewComment(w http.ResponseWriter, r *http.Request) {
+	cid, err := strconv.ParseInt(r.PathValue("cid"), 10, 64)
+	if err != nil {
+		http.Error(w, "comment not found", 404)
+		return
+	}
+	if err := s.store.DeleteReviewComment(r.PathValue("id"), cid); err != nil {
+		http.Error(w, "comment not found", 404)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleSendReview delivers the open comments to the session's agent as one
+// prompt and marks them sent.
+func (s *Server) handleSendReview(w http.ResponseWriter, r *http.Request) {
+	sess, err := s.store.GetSession(r.PathValue("id"))
+	if err != nil || sess == nil {
+		http.Error(w, "session not found", 404)
+		return
+	}
+	n, err := review.Send(s.store, sess)
+	if errors.Is(err, review.ErrNoComments) {
+		http.Error(w, err.Error(), 422)
+		return
+	}
+	if errors.Is(err, review.ErrNotRunning) {
+		http.Error(w, err.Error(), 409)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int{"sent": n})
+}
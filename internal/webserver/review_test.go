@@ -0,0 +1,57 @@
+package webserver_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestReviewCommentEndpoints(t *testing.T) {
+	srv, store := newServer(t)
+	seedSession(t, store, "")
+
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		w := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(w, req)
+		return w
+	}
+
+	if w := do("POST", "/api/sessions/git-test-id/review/comments", `{"file":"a.go","body":"  "}`); w.Code != 400 {
+		t.Errorf("empty comment: expected 400, got %d", w.Code)
+	}
+	w := do("POST", "/api/sessions/git-test-id/review/comments",
+		`{"file":"a.go","line_start":4,"line_end":2,"commit":"abc","body":"Rename this"}`)
+	if w.Code != 201 {
+		t.Fatalf("add: expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+	var added struct {
+		ID                 int64
+		LineStart, LineEnd int
+	}
+	json.NewDecoder(w.Body).Decode(&added)
+	if added.ID == 0 || added.LineEnd != 4 {
+		t.Errorf("expected line_end clamped to line_start: %+v", added)
+	}
+
+	w = do("GET", "/api/sessions/git-test-id/review", "")
+	var list struct{ Comments []struct{ Body string } }
+	json.NewDecoder(w.Body).Decode(&list)
+	if len(list.Comments) != 1 || list.Comments[0].Body != "Rename this" {
+		t.Errorf("list: %+v", list)
+	}
+
+	// The seeded session has no tmux session to send to.
+	if w := do("POST", "/api/sessions/git-test-id/review/send", ""); w.Code != 409 {
+		t.Errorf("send: expected 409, got %d", w.Code)
+	}
+
+	if w := do("DELETE", fmt.Sprintf("/api/sessions/git-test-id/review/comments/%d", added.ID), ""); w.Code != 204 {
+		t.Errorf("delete: expected 204, got %d", w.Code)
+	}
+	if w := do("POST", "/api/sessions/git-test-id/review/send", ""); w.Code != 422 {
+		t.Errorf("send with no comments: expected 422, got %d", w.Code)
+	}
+}
@@ -0,0 +1,64 @@
+package webserver
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// appCSP is the content security policy of the web UI: its own scripts
+// only, inline styles for the style attributes it sets, and the web font.
+// Terminal and share viewer pages load nothing from elsewhere; xterm.js is
+// served from static/vendor.
+const appCSP = "default-src 'self'; script-src 'self'; " +
+	"style-src 'self' 'unsafe-inline' https://fonts.googleapis.com; font-src 'self' https://fonts.gstatic.com; " +
+	"img-src 'self' data:; connect-src 'self'; frame-src 'self'; frame-ancestors 'self'; " +
+	"base-uri 'self'; form-action 'self'; object-src 'none'"
+
+const terminalCSP = "default-src 'self'; script-src 'self'; " +
+	"style-src 'self' 'unsafe-inline'; connect-src 'self'; frame-ancestors 'self'; " +
+	"base-uri 'self'; form-action 'self'; object-src 'none'"
+
+// securityMiddleware sets the standard security headers, with HSTS when the
+// server uses TLS, and refuses requests that change state or open a
+// websocket from another site's page. Only the UI itself may frame pages,
+// which it does for terminals.
+func securityMiddleware(tls bool, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h := w.Header()
+		h.Set("X-Content-Type-Options", "nosniff")
+		h.Set("X-Frame-Options", "SAMEORIGIN")
+		h.Set("Referrer-Policy", "no-referrer")
+		if strings.HasPrefix(r.URL.Path, "/terminal/") || strings.HasPrefix(r.URL.Path, "/share/") {
+			h.Set("Content-Security-Policy", terminalCSP)
+		} else {
+			h.Set("Content-Security-Policy", appCSP)
+		}
+		if tls {
+			h.Set("Strict-Transport-Security", "max-age=31536000")
+		}
+
+		unsafe := r.Method != http.MethodGet && r.Method != http.MethodHead && r.Method != http.MethodOptions
+		if (unsafe || strings.EqualFold(r.Header.Get("Upgrade"), "websocket")) && !sameOrigin(r) {
+			http.Error(w, "cross-origin request refused", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// sameOrigin reports whether r has no Origin header, as from scripts and
+// non-browser clients, or one naming the host it was sent to. Behind a
+// reverse proxy the forwarded host counts too.
+func sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(u.Host, r.Host) ||
+		(r.Header.Get("X-Forwarded-Host") != "" && strings.EqualFold(u.Host, r.Header.Get("X-Forwarded-Host")))
+}
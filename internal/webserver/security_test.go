@@ -0,0 +1,171 @@
+package webserver_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/zsprackett/agent-workspace/internal/db"
+	"github.com/zsprackett/agent-workspace/internal/webserver"
+)
+
+func TestLoginThrottle(t *testing.T) {
+	srv, store := newAuthServer(t)
+	hash, _ := bcrypt.GenerateFromPassword([]byte("pw"), bcrypt.MinCost)
+	store.CreateAccount("bob", string(hash), db.RoleViewer)
+	login := func(user, password string) *httptest.ResponseRecorder {
+		body := `{"username":"` + user + `","password":"` + password + `"}`
+		w := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(w, httptest.NewRequest("POST", "/api/auth/login", strings.NewReader(body)))
+		return w
+	}
+
+	for i := 0; i < 3; i++ {
+		if w := login("alice", "wrong"); w.Code != 401 {
+			t.Fatalf("failure %d: expected 401, got %d", i+1, w.Code)
+		}
+	}
+	// Even the right password waits once the free failures are used up.
+	w := login("alice", "password")
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" {
+		t.Fatalf("throttled login: %d, Retry-After %q", w.Code, w.Header().Get("Retry-After"))
+	}
+	// Other users from the same address are not held up yet.
+	if w := login("bob", "pw"); w.Code != 200 {
+		t.Errorf("other user: expected 200, got %d", w.Code)
+	}
+
+	entries, _ := store.QueryAudit(db.AuditFilter{Action: "auth.login", Target: "alice"})
+	if len(entries) != 4 || entries[0].Detail != "throttled" || entries[0].Result != "denied" ||
+		entries[1].Detail != "wrong password" || entries[1].Result != "denied" {
+		t.Errorf("audit: %+v", entries)
+	}
+}
+
+func TestTickets(t *testing.T) {
+	srv, store := newAuthServer(t)
+	now := time.Now()
+	store.SaveSession(&db.Session{ID: "s1", Title: "s", GroupPath: "work", Tool: db.ToolShell,
+		Status: db.StatusStopped, CreatedAt: now, LastAccessed: now})
+	token, _ := webserver.IssueAccessToken("test-secret", webserver.Principal{Username: "alice", Role: db.RoleAdmin}, time.Hour)
+	ticket := func() string {
+		req := httptest.NewRequest("POST", "/api/tickets", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(w, req)
+		var res struct{ Ticket string }
+		json.NewDecoder(w.Body).Decode(&res)
+		if res.Ticket == "" {
+			t.Fatalf("ticket: %d %s", w.Code, w.Body.String())
+		}
+		return res.Ticket
+	}
+	get := func(path string, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		req := httptest.NewRequest("GET", path, nil).WithContext(ctx)
+		for _, c := range cookies {
+			req.AddCookie(c)
+		}
+		w := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(w, req)
+		return w
+	}
+
+	// Access tokens are no longer accepted in the query string.
+	if w := get("/events?token=" + token); w.Code != 401 {
+		t.Errorf("token in query: expected 401, got %d", w.Code)
+	}
+	tk := ticket()
+	if w := get("/events?ticket=" + tk); w.Code != 200 {
+		t.Errorf("events with ticket: expected 200, got %d", w.Code)
+	}
+	if w := get("/events?ticket=" + tk); w.Code != 401 {
+		t.Errorf("reused ticket: expected 401, got %d", w.Code)
+	}
+
+	// A terminal page trades its ticket for a cookie scoped to it. The
+	// session has no tmux session, so an authenticated request gets 404.
+	tk = ticket()
+	w := get("/terminal/s1/?ticket=" + tk)
+	if w.Code != 404 {
+		t.Fatalf("terminal with ticket: expected 404, got %d", w.Code)
+	}
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Path != "/terminal/s1/" || !cookies[0].HttpOnly {
+		t.Fatalf("cookies: %+v", cookies)
+	}
+	if w := get("/terminal/s1/ws?ticket="+tk, cookies[0]); w.Code != 404 {
+		t.Errorf("terminal with cookie: expected 404, got %d", w.Code)
+	}
+	if w := get("/terminal/other/ws", cookies[0]); w.Code != 401 {
+		t.Errorf("cookie for another terminal: expected 401, got %d", w.Code)
+	}
+}
+
+func TestSecurityHeaders(t *testing.T) {
+	srv, _ := newAuthServer(t)
+	w := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/login", nil))
+	h := w.Header()
+	if !strings.Contains(h.Get("Content-Security-Policy"), "script-src 'self'") ||
+		!strings.Contains(h.Get("Content-Security-Policy"), "frame-ancestors 'self'") {
+		t.Errorf("CSP: %q", h.Get("Content-Security-Policy"))
+	}
+	if h.Get("X-Content-Type-Options") != "nosniff" || h.Get("X-Frame-Options") != "SAMEORIGIN" {
+		t.Errorf("headers: %v", h)
+	}
+
+	// Terminal pages load everything, xterm.js included, from the server.
+	w = httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/share/nope", nil))
+	if csp := w.Header().Get("Content-Security-Policy"); strings.Contains(csp, "https:") {
+		t.Errorf("terminal CSP allows another origin: %q", csp)
+	}
+	for _, page := range []string{"/terminal.html", "/share.html"} {
+		w = httptest.NewRecorder()
+		srv.Handler().ServeHTTP(w, httptest.NewRequest("GET", page, nil))
+		if strings.Contains(w.Body.String(), "https://") {
+			t.Errorf("%s loads from another origin:\n%s", page, w.Body.String())
+		}
+	}
+	if h.Get("Strict-Transport-Security") != "" {
+		t.Error("HSTS without TLS")
+	}
+
+	req := httptest.NewRequest("POST", "/api/auth/login", strings.NewReader(`{"username":"alice","password":"password"}`))
+	req.Header.Set("Origin", "https://evil.example")
+	w = httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w, req)
+	if w.Code != 403 {
+		t.Errorf("cross-origin login: expected 403, got %d", w.Code)
+	}
+	req = httptest.NewRequest("POST", "/api/auth/login", strings.NewReader(`{"username":"alice","password":"password"}`))
+	req.Header.Set("Origin", "http://"+req.Host)
+	w = httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w, req)
+	if w.Code != 200 {
+		t.Errorf("same-origin login: expected 200, got %d", w.Code)
+	}
+}
+
+func TestVendoredXterm(t *testing.T) {
+	if _, err := os.Stat("static/vendor/xterm/xterm.js"); err != nil {
+		t.Skip("xterm.js is not vendored; run make vendor-xterm")
+	}
+	srv, _ := newServer(t)
+	for _, path := range []string{"/vendor/xterm/xterm.js", "/vendor/xterm/addon-fit.js", "/vendor/xterm/xterm.css"} {
+		w := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		if w.Code != 200 || w.Body.Len() == 0 {
+			t.Errorf("%s: expected it served from the embedded files, got %d", path, w.Code)
+		}
+	}
+}
@@ -0,0 +1,232 @@
+package webserver
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/zsprackett/agent-workspace/internal/db"
+	"github.com/zsprackett/agent-workspace/internal/share"
+	"github.com/zsprackett/agent-workspace/internal/tmux"
+)
+
+const (
+	// shareFrameInterval is how often a share viewer's pane is captured.
+	shareFrameInterval = time.Second
+	// shareKeepalive is how often an unchanged pane is confirmed with a
+	// comment so proxies keep the stream open.
+	shareKeepalive = 15 * time.Second
+)
+
+// BaseURL returns the address others reach the web server at: PublicURL if
+// set, otherwise one derived from the listen address and TLS settings.
+func (s *Server) BaseURL() string {
+	if s.cfg.PublicURL != "" {
+		return strings.TrimRight(s.cfg.PublicURL, "/")
+	}
+	scheme := "http"
+	if s.cfg.TLS.Mode != "" {
+		scheme = "https"
+	}
+	if s.cfg.TLS.Mode == "autocert" {
+		return scheme + "://" + s.cfg.TLS.Domain
+	}
+	host := s.cfg.Host
+	if host == "" || host == "0.0.0.0" || host == "::" {
+		host, _ = os.Hostname()
+	}
+	return fmt.Sprintf("%s://%s", scheme, net.JoinHostPort(host, strconv.Itoa(s.cfg.Port)))
+}
+
+// shareURL returns the viewer URL for a token: absolute when PublicURL is
+// set, otherwise a path for the browser to resolve against its own origin.
+func (s *Server) shareURL(token string) string {
+	return strings.TrimRight(s.cfg.PublicURL, "/") + share.Path(token)
+}
+
+func (s *Server) shareJSON(l *db.ShareLink) map[string]any {
+	token := share.Token(s.cfg.Auth.JWTSecret, l)
+	return map[string]any{
+		"id":         l.ID,
+		"created_by": l.CreatedBy,
+		"created_at": l.CreatedAt,
+		"expires_at": l.ExpiresAt,
+		"url":        s.shareURL(token),
+	}
+}
+
+// handleShares lists a session's active share links.
+func (s *Server) handleShares(w http.ResponseWriter, r *http.Request) {
+	links, err := s.store.ListShareLinks(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	out := make([]map[string]any, len(links))
+	for i, l := range links {
+		out[i] = s.shareJSON(l)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(out)
+}
+
+// handleCreateShare creates a read-only share link for a session. The body
+// may set "expires" to a duration such as "24h"; the default is an hour.
+func (s *Server) handleCreateShare(w http.ResponseWriter, r *http.Request) {
+	sess, err := s.store.GetSession(r.PathValue("id"))
+	if err != nil || sess == nil {
+		http.Error(w, "session not found", 404)
+		return
+	}
+	var body struct {
+		Expires string `json:"expires"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, "bad request", 400)
+			return
+		}
+	}
+	var ttl time.Duration
+	if body.Expires != "" {
+		if ttl, err = time.ParseDuration(body.Expires); err != nil {
+			http.Error(w, "invalid expires: "+err.Error(), 400)
+			return
+		}
+	}
+	l, _, err := share.Create(s.store, s.cfg.Auth.JWTSecret, sess.ID, requestActor(r), ttl)
+	if errors.Is(err, share.ErrNoSecret) {
+		http.Error(w, err.Error(), 503)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	setAuditDetail(r, fmt.Sprintf("%s: link %s until %s", sess.Title, l.ID, l.ExpiresAt.UTC().Format(time.RFC3339)))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(201)
+	json.NewEncoder(w).Encode(s.shareJSON(l))
+}
+
+// handleRevokeShare revokes one of a session's share links. Open viewers
+// are cut off within a second.
+func (s *Server) handleRevokeShare(w http.ResponseWriter, r *http.Request) {
+	l, err := s.store.GetShareLink(r.PathValue("link"))
+	if err != nil || l.SessionID != r.PathValue("id") {
+		http.Error(w, "share link not found", 404)
+		return
+	}
+	setAuditDetail(r, "link "+l.ID)
+	err = share.Revoke(s.store, l, requestActor(r))
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "share link already revoked", 404)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	w.WriteHeader(204)
+}
+
+// shareLink checks the {token} of a share request, writing a 404 if it is
+// not an active link.
+func (s *Server) shareLink(w http.ResponseWriter, r *http.Request) *db.ShareLink {
+	l, err := share.Check(s.store, s.cfg.Auth.JWTSecret, r.PathValue("token"))
+	if err != nil {
+		http.Error(w, err.Error(), 404)
+		return nil
+	}
+	return l
+}
+
+// handleSharePage serves the viewer page of a share link. No account is
+// needed; the link is the credential.
+func (s *Server) handleSharePage(w http.ResponseWriter, r *http.Request) {
+	if s.shareLink(w, r) == nil {
+		return
+	}
+	http.ServeFileFS(w, r, staticFS, "static/share.html")
+}
+
+// handleShareStream streams a shared session's pane to a viewer as server
+// sent events: an "info" event with the session title and link expiry, a
+// frame (cols, rows and the pane content with escape sequences) whenever the
+// pane changes, and an "end" event with the reason once the link expires or
+// is revoked or the session stops.
+func (s *Server) handleShareStream(w http.ResponseWriter, r *http.Request) {
+	l := s.shareLink(w, r)
+	if l == nil {
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", 500)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	share.Viewed(s.store, l, clientIP(r))
+	s.shareViewers.Add(1)
+	defer s.shareViewers.Add(-1)
+
+	send := func(event string, v any) {
+		data, _ := json.Marshal(v)
+		if event != "" {
+			fmt.Fprintf(w, "event: %s\n", event)
+		}
+		fmt.Fprintf(w, "data: %s\n\n", data)
+		flusher.Flush()
+	}
+	title := ""
+	if sess, _ := s.store.GetSession(l.SessionID); sess != nil {
+		title = sess.Title
+	}
+	send("info", map[string]any{"title": title, "expires_at": l.ExpiresAt})
+
+	ticker := time.NewTicker(shareFrameInterval)
+	defer ticker.Stop()
+	var last string
+	lastSent := time.Now()
+	for {
+		// Check the link and session on every frame so revoking a link or
+		// stopping the session cuts viewers off at once.
+		if cur, err := s.store.GetShareLink(l.ID); err != nil || !cur.Active() {
+			send("end", "The link has expired or was revoked.")
+			return
+		}
+		sess, _ := s.store.GetSession(l.SessionID)
+		if sess == nil || sess.TmuxSession == "" {
+			send("end", "The session is not running.")
+			return
+		}
+		content, err := tmux.CapturePane(sess.TmuxSession, tmux.CaptureOptions{EscapeSeq: true})
+		if err != nil {
+			send("end", "The session is not running.")
+			return
+		}
+		if content != last {
+			cols, rows, _ := tmux.PaneSize(sess.TmuxSession)
+			send("", map[string]any{"cols": cols, "rows": rows, "content": content})
+			last, lastSent = content, time.Now()
+		} else if time.Since(lastSent) >= shareKeepalive {
+			fmt.Fprintf(w, ": keepalive\n\n")
+			flusher.Flush()
+			lastSent = time.Now()
+		}
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package webserver_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zsprackett/agent-workspace/internal/db"
+	"github.com/zsprackett/agent-workspace/internal/webserver"
+)
+
+type shareResult struct {
+	ID        string `json:"id"`
+	CreatedBy string `json:"created_by"`
+	URL       string `json:"url"`
+}
+
+func TestShareLinkEndpoints(t *testing.T) {
+	srv, store := newAuthServer(t)
+	now := time.Now()
+	store.SaveSession(&db.Session{ID: "s1", Title: "shared", GroupPath: "work", Tool: db.ToolShell,
+		Status: db.StatusStopped, CreatedAt: now, LastAccessed: now})
+	token, _ := webserver.IssueAccessToken("test-secret", webserver.Principal{Username: "alice", Role: db.RoleAdmin}, time.Hour)
+	do := func(method, path, body string, auth bool) *httptest.ResponseRecorder {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		req := httptest.NewRequest(method, path, strings.NewReader(body)).WithContext(ctx)
+		if auth {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(w, req)
+		return w
+	}
+
+	if w := do("POST", "/api/sessions/s1/shares", `{"expires":"2h"}`, false); w.Code != 401 {
+		t.Errorf("create without login: expected 401, got %d", w.Code)
+	}
+	if w := do("POST", "/api/sessions/s1/shares", `{"expires":"800h"}`, true); w.Code != 400 {
+		t.Errorf("create with too long a lifetime: expected 400, got %d", w.Code)
+	}
+	w := do("POST", "/api/sessions/s1/shares", `{"expires":"2h"}`, true)
+	var link shareResult
+	json.NewDecoder(w.Body).Decode(&link)
+	if w.Code != 201 || link.CreatedBy != "alice" || !strings.HasPrefix(link.URL, "/share/") {
+		t.Fatalf("create: %d %+v", w.Code, link)
+	}
+	var links []shareResult
+	json.NewDecoder(do("GET", "/api/sessions/s1/shares", "", true).Body).Decode(&links)
+	if len(links) != 1 || links[0].URL != link.URL {
+		t.Errorf("list: %+v", links)
+	}
+
+	// The link works without an account.
+	if w := do("GET", link.URL, "", false); w.Code != 200 {
+		t.Errorf("viewer page: expected 200, got %d", w.Code)
+	}
+	if w := do("GET", strings.TrimSuffix(link.URL, "/")+"x/", "", false); w.Code != 404 {
+		t.Errorf("tampered link: expected 404, got %d", w.Code)
+	}
+	// The session is stopped, so the stream ends at once.
+	w = do("GET", link.URL+"stream", "", false)
+	if body := w.Body.String(); !strings.Contains(body, "event: info") || !strings.Contains(body, "event: end") {
+		t.Errorf("stream of a stopped session: %q", body)
+	}
+
+	if w := do("DELETE", "/api/sessions/s1/shares/"+link.ID, "", true); w.Code != 204 {
+		t.Fatalf("revoke: %d %s", w.Code, w.Body.String())
+	}
+	if w := do("DELETE", "/api/sessions/s1/shares/"+link.ID, "", true); w.Code != 404 {
+		t.Errorf("revoke twice: expected 404, got %d", w.Code)
+	}
+	if w := do("GET", link.URL, "", false); w.Code != 404 {
+		t.Errorf("revoked link: expected 404, got %d", w.Code)
+	}
+
+	events, _ := store.GetSessionEvents("s1", 10)
+	var types []string
+	for _, e := range events {
+		types = append(types, e.EventType)
+	}
+	if got := strings.Join(types, ","); got != "share_revoked,share_viewed,share_created" {
+		t.Errorf("session events: %s", got)
+	}
+	entries, _ := store.QueryAudit(db.AuditFilter{Action: "share."})
+	var results []string
+	for _, e := range entries {
+		results = append(results, e.Action+" "+strings.Fields(e.Result)[0])
+	}
+	if got := strings.Join(results, ","); got != "share.revoke error,share.revoke ok,share.create ok,share.create error" {
+		t.Errorf("audit entries: %s", got)
+	}
+}
+
+func TestShareStream(t *testing.T) {
+	if _, err := exec.LookPath("tmux"); err != nil {
+		t.Skip("tmux not installed")
+	}
+	name := fmt.Sprintf("aw-share-%d", time.Now().UnixNano())
+	if out, err := exec.Command("tmux", "new-session", "-d", "-s", name, "sh -c 'echo hello-share; sleep 30'").CombinedOutput(); err != nil {
+		t.Skipf("tmux new-session: %v %s", err, out)
+	}
+	t.Cleanup(func() { exec.Command("tmux", "kill-session", "-t", name).Run() })
+
+	srv, store := newAuthServer(t)
+	now := time.Now()
+	store.SaveSession(&db.Session{ID: "s1", Title: "shared", GroupPath: "work", Tool: db.ToolShell,
+		Status: db.StatusRunning, TmuxSession: name, CreatedAt: now, LastAccessed: now})
+	ts := httptest.NewServer(srv.Handler())
+	defer ts.Close()
+	token, _ := webserver.IssueAccessToken("test-secret", webserver.Principal{Username: "alice", Role: db.RoleAdmin}, time.Hour)
+	req, _ := http.NewRequest("POST", ts.URL+"/api/sessions/s1/shares", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var link shareResult
+	json.NewDecoder(resp.Body).Decode(&link)
+	resp.Body.Close()
+
+	resp, err = http.Get(ts.URL + link.URL + "stream")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	lines := bufio.NewScanner(resp.Body)
+	// waitFor reads the stream until a line contains want.
+	waitFor := func(want string) {
+		t.Helper()
+		for lines.Scan() {
+			if strings.Contains(lines.Text(), want) {
+				return
+			}
+		}
+		t.Fatalf("stream ended before %q: %v", want, lines.Err())
+	}
+	waitFor("hello-share")
+
+	// Revoking the link cuts the viewer off.
+	store.RevokeShareLink(link.ID)
+	waitFor("event: end")
+}
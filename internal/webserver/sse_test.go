@@ -0,0 +1,110 @@
+package webserver_test
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zsprackett/agent-workspace/internal/events"
+)
+
+// sseStream reads events from an open event stream.
+type sseStream struct {
+	resp *http.Response
+	sc   *bufio.Scanner
+}
+
+func openSSE(t *testing.T, url, lastID string) *sseStream {
+	t.Helper()
+	req, _ := http.NewRequest("GET", url+"/events", nil)
+	if lastID != "" {
+		req.Header.Set("Last-Event-ID", lastID)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	return &sseStream{resp: resp, sc: bufio.NewScanner(resp.Body)}
+}
+
+// next returns the next event and its SSE id.
+func (s *sseStream) next(t *testing.T) (string, events.Event) {
+	t.Helper()
+	var id string
+	for s.sc.Scan() {
+		line := s.sc.Text()
+		if v, ok := strings.CutPrefix(line, "id: "); ok {
+			id = v
+		}
+		if v, ok := strings.CutPrefix(line, "data: "); ok {
+			var e events.Event
+			if err := json.Unmarshal([]byte(v), &e); err != nil {
+				t.Fatalf("bad event %q: %v", v, err)
+			}
+			return id, e
+		}
+	}
+	t.Fatalf("stream ended: %v", s.sc.Err())
+	return "", events.Event{}
+}
+
+func TestSSEReplay(t *testing.T) {
+	srv, store := newServer(t)
+	sess := seedSession(t, store, "")
+	ts := httptest.NewServer(srv.Handler())
+	t.Cleanup(ts.Close)
+
+	// A new client starts with a snapshot.
+	s := openSSE(t, ts.URL, "")
+	snapID, e := s.next(t)
+	if e.Type != events.Snapshot || snapID == "" {
+		t.Fatalf("first event: %q %+v", snapID, e)
+	}
+
+	// Saving notes sends their content.
+	resp, err := http.Post(ts.URL+"/api/sessions/"+sess.ID+"/notes", "application/json", strings.NewReader(`{"notes":"hello"}`))
+	if err != nil || resp.StatusCode != 204 {
+		t.Fatalf("save notes: %v %v", err, resp)
+	}
+	notesID, e := s.next(t)
+	if e.Type != events.NotesUpdated || e.SessionID != sess.ID || e.Notes == nil || *e.Notes != "hello" {
+		t.Fatalf("notes event: %+v", e)
+	}
+	s.resp.Body.Close()
+
+	// A client that reconnects gets what it missed, without a snapshot.
+	srv.Broadcast(events.Event{Type: events.SessionDeleted, SessionID: "a"})
+	srv.Broadcast(events.Event{Type: events.SessionDeleted, SessionID: "b"})
+	s = openSSE(t, ts.URL, notesID)
+	for _, want := range []string{"a", "b"} {
+		if _, e := s.next(t); e.Type != events.SessionDeleted || e.SessionID != want {
+			t.Errorf("replayed event: %+v, want deletion of %s", e, want)
+		}
+	}
+	s.resp.Body.Close()
+
+	// One that asks for a position the server does not have gets a snapshot.
+	s = openSSE(t, ts.URL, "1")
+	if _, e := s.next(t); e.Type != events.Snapshot {
+		t.Errorf("unknown position: %+v, want snapshot", e)
+	}
+	done := make(chan struct{})
+	go func() {
+		if _, e := s.next(t); e.Type != events.Refresh {
+			t.Errorf("live event: %+v", e)
+		}
+		close(done)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	srv.Broadcast(events.Event{Type: events.Refresh})
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("live event not delivered")
+	}
+}
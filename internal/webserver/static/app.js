@@ -38,6 +38,14 @@ async function authFetch(url, options = {}) {
   return res;
 }
 
+// fetchTicket returns a single-use ticket for a URL that cannot carry the
+// access token in a header: the event stream and terminal pages.
+async function fetchTicket() {
+  const res = await authFetch('/api/tickets', { method: 'POST' });
+  if (!res || !res.ok) return null;
+  return (await res.json()).ticket;
+}
+
 async function logout() {
   const rt = getRefreshToken();
   if (rt) {
@@ -78,13 +86,33 @@ let tabState = {};              // { [sessionID]: 'terminal'|'git'|'notes'|'acti
 let openCreateForms = new Set();
 let mobileShowDetail = false;
 let sseRetryDelay = 1000;
+// ID of the last event received, so a reconnect replays what was missed.
+let lastEventID = '';
+// Provisioning step of sessions still being created, by session ID.
+const provisioning = {};
 
 // Module-level iframe cache — survives DOM rebuilds so the terminal doesn't reload.
 const savedIframes = {};
 // Tracks what is currently rendered in the detail panel to avoid unnecessary rebuilds.
 let renderedDetailID = null;
 
+// The signed-in user's roles, from /api/me. Without authentication everyone
+// is an admin.
+let me = { username: '', role: 'admin', groups: {} };
+
+// canOperate reports whether the user may change sessions in the group:
+// create, stop, delete, stage, commit and type into terminals.
+function canOperate(groupPath) {
+  const role = me.role === 'admin' ? 'admin' : (me.groups[groupPath] || me.role);
+  return role !== 'viewer';
+}
+
 // --- Data fetching ---
+async function fetchMe() {
+  const res = await authFetch('/api/me');
+  if (res && res.ok) me = await res.json();
+}
+
 async function fetchSessions() {
   const res = await authFetch('/api/sessions');
   if (!res || !res.ok) return;
@@ -147,6 +175,7 @@ function scrollTerminal(dir) { // -1 = up, 1 = down
 // --- Session selection ---
 function selectSession(id) {
   selectedSessionID = id;
+  settingsOpen = false;
   mobileShowDetail = true;
   render();
 }
@@ -156,9 +185,9 @@ function render() {
   renderSidebar();
 
   if (selectedSessionID !== renderedDetailID) {
-    // Selection changed: kill old ttyd and rebuild detail panel.
+    // Selection changed: drop the old terminal, which closes its
+    // connection, and rebuild the detail panel.
     if (renderedDetailID && renderedDetailID !== selectedSessionID) {
-      authFetch(`/api/sessions/${renderedDetailID}/ttyd`, { method: 'DELETE' }).catch(() => {});
       delete savedIframes[renderedDetailID];
     }
     renderedDetailID = selectedSessionID;
@@ -172,7 +201,7 @@ function render() {
   if (window.innerWidth <= 767) {
     const sidebar = document.getElementById('sidebar');
     const panel = document.getElementById('detail-panel');
-    if (mobileShowDetail && selectedSessionID) {
+    if (mobileShowDetail && (selectedSessionID || settingsOpen)) {
       sidebar.classList.add('offscreen');
       panel.classList.add('visible');
     } else {
@@ -228,21 +257,23 @@ function renderSidebar() {
     };
 
     headerRow.appendChild(label);
-    headerRow.appendChild(addBtn);
+    if (canOperate(path)) headerRow.appendChild(addBtn);
     list.appendChild(headerRow);
     list.appendChild(buildCreateForm(path));
 
-    // Session rows
-    sessions.forEach(s => {
+    // Session rows, with forks nested under their parent.
+    sessionTree(sessions).forEach(({ s, depth }) => {
       const icon = STATUS_ICONS[s.Status] || STATUS_ICONS.idle;
       const isActive = s.ID === selectedSessionID;
       const row = document.createElement('div');
       row.setAttribute('data-session-id', s.ID);
       row.className = `session-row${isActive ? ' active status-' + s.Status : ''}`;
+      if (depth > 0) row.style.paddingLeft = `${12 + depth * 14}px`;
       row.innerHTML = `
+        ${depth > 0 ? '<span class="session-row-fork">└</span>' : ''}
         <span class="status-dot ${icon.cls}">${icon.char}</span>
         <span class="session-row-title">${s.HasUncommitted ? '* ' : ''}${s.Title}</span>
-        <span class="session-row-tool">${s.Tool}</span>
+        <span class="session-row-tool">${provisioning[s.ID] || s.Tool}</span>
       `;
       row.onclick = () => selectSession(s.ID);
       list.appendChild(row);
@@ -250,6 +281,1049 @@ function renderSidebar() {
   });
 }
 
+// sessionTree orders sessions so that forks follow their parent, one level
+// deeper. Forks whose parent is not in the list are shown at the top level.
+function sessionTree(sessions) {
+  const ids = new Set(sessions.map(s => s.ID));
+  const children = {};
+  const roots = [];
+  sessions.forEach(s => {
+    const p = s.ParentSessionID;
+    if (p && p !== s.ID && ids.has(p)) (children[p] = children[p] || []).push(s);
+    else roots.push(s);
+  });
+  const out = [];
+  const visited = new Set();
+  const walk = (s, depth) => {
+    if (visited.has(s.ID)) return;
+    visited.add(s.ID);
+    out.push({ s, depth });
+    (children[s.ID] || []).forEach(c => walk(c, depth + 1));
+  };
+  roots.forEach(s => walk(s, 0));
+  // Parent cycles have no root; list them flat rather than dropping them.
+  sessions.forEach(s => walk(s, 0));
+  return out;
+}
+
+async function forkSession(s) {
+  const title = prompt(`Fork "${s.Title}" as:`, `${s.Title}-fork`);
+  if (title === null) return;
+  const copyChanges = s.HasUncommitted && confirm('Copy uncommitted changes into the fork?');
+  const res = await authFetch(`/api/sessions/${s.ID}/fork`, {
+    method: 'POST',
+    headers: { 'Content-Type': 'application/json' },
+    body: JSON.stringify({ title, copy_changes: copyChanges }),
+  });
+  if (res && !res.ok) alert(`Fork failed: ${res.status} ${(await res.text()).trim()}`);
+  else if (res) selectedSessionID = (await res.json()).ID;
+  fetchSessions();
+}
+
+// --- Share links ---
+const SHARE_DURATIONS = [['1h', '1 hour'], ['8h', '8 hours'], ['24h', '1 day'], ['168h', '7 days']];
+
+// renderShares lists the session's read-only share links and lets an
+// operator create, copy and revoke them.
+function renderShares(s, container) {
+  const panel = document.createElement('div');
+  panel.className = 'git-panel';
+
+  const intro = document.createElement('div');
+  intro.className = 'compare-muted';
+  intro.textContent = 'Anyone with a link can watch this session, read-only and without an account, until the link expires or is revoked.';
+  panel.appendChild(intro);
+
+  const actionRow = document.createElement('div');
+  actionRow.className = 'git-action-row';
+  const durationSelect = document.createElement('select');
+  durationSelect.className = 'form-select compare-select';
+  SHARE_DURATIONS.forEach(([value, label]) => {
+    const opt = document.createElement('option');
+    opt.value = value;
+    opt.textContent = `for ${label}`;
+    durationSelect.appendChild(opt);
+  });
+  actionRow.appendChild(durationSelect);
+  actionRow.appendChild(diffActionButton('Create link', async () => {
+    const res = await authFetch(`/api/sessions/${s.ID}/shares`, {
+      method: 'POST',
+      headers: { 'Content-Type': 'application/json' },
+      body: JSON.stringify({ expires: durationSelect.value }),
+    });
+    if (res && !res.ok) alert(`Failed: ${(await res.text()).trim()}`);
+    reload();
+  }));
+  panel.appendChild(actionRow);
+
+  const label = document.createElement('div');
+  label.className = 'git-section-label';
+  label.textContent = 'ACTIVE LINKS';
+  panel.appendChild(label);
+  const list = document.createElement('div');
+  panel.appendChild(list);
+  container.appendChild(panel);
+
+  async function reload() {
+    const res = await authFetch(`/api/sessions/${s.ID}/shares`);
+    if (!res || !res.ok) return;
+    const links = await res.json();
+    list.innerHTML = '';
+    if (!links.length) {
+      list.innerHTML = '<div class="compare-muted">No active links.</div>';
+      return;
+    }
+    links.forEach(l => {
+      const url = new URL(l.url, location.origin).href;
+      const row = document.createElement('div');
+      row.className = 'review-item';
+      const input = document.createElement('input');
+      input.className = 'form-input share-url';
+      input.readOnly = true;
+      input.value = url;
+      input.onclick = () => input.select();
+      row.appendChild(input);
+      row.appendChild(diffActionButton('Copy', () => {
+        if (navigator.clipboard) navigator.clipboard.writeText(url);
+        else { input.select(); document.execCommand('copy'); }
+      }));
+      row.appendChild(diffActionButton('Revoke', async () => {
+        if (!confirm('Revoke this link? Anyone watching is cut off.')) return;
+        const res = await authFetch(`/api/sessions/${s.ID}/shares/${l.id}`, { method: 'DELETE' });
+        if (res && !res.ok) alert(`Failed: ${(await res.text()).trim()}`);
+        reload();
+      }));
+      const meta = document.createElement('span');
+      meta.className = 'compare-muted';
+      meta.textContent = `by ${l.created_by}, expires ${formatDateTime(l.expires_at)}`;
+      row.appendChild(meta);
+      list.appendChild(row);
+    });
+  }
+  reload();
+}
+
+// --- Compare ---
+const CHECK_LABELS = { pass: '✓ passing', fail: '✗ failing', pending: '◐ pending', none: '– no checks' };
+const compareState = {}; // { [sessionID]: other session ID }
+
+// renderCompare shows the diff between the session's branch and a sibling's,
+// per-file stats and each side's checks, to help pick between attempts.
+function renderCompare(s, container) {
+  const panel = document.createElement('div');
+  panel.className = 'git-panel';
+
+  const actionRow = document.createElement('div');
+  actionRow.className = 'git-action-row';
+  const select = document.createElement('select');
+  select.className = 'form-select compare-select';
+  const refreshBtn = document.createElement('button');
+  refreshBtn.className = 'git-btn';
+  refreshBtn.textContent = '↻ Refresh';
+  actionRow.appendChild(select);
+  actionRow.appendChild(refreshBtn);
+  panel.appendChild(actionRow);
+
+  const result = document.createElement('div');
+  result.className = 'compare-result';
+  panel.appendChild(result);
+  container.appendChild(panel);
+
+  const load = async () => {
+    const other = select.value;
+    compareState[s.ID] = other;
+    if (!other) return;
+    result.innerHTML = '<div class="compare-muted">loading...</div>';
+    const res = await authFetch(`/api/sessions/${s.ID}/compare/${other}`);
+    if (!res || !res.ok) {
+      result.innerHTML = '';
+      const msg = document.createElement('div');
+      msg.className = 'compare-muted';
+      msg.textContent = res ? `(error: ${(await res.text()).trim()})` : '(error)';
+      result.appendChild(msg);
+      return;
+    }
+    renderCompareResult(await res.json(), result);
+  };
+  select.onchange = load;
+  refreshBtn.onclick = load;
+
+  authFetch(`/api/sessions/${s.ID}/compare`).then(async res => {
+    const { siblings } = res && res.ok ? await res.json() : { siblings: [] };
+    if (!siblings.length) {
+      result.innerHTML = '<div class="compare-muted">No other sessions on this repo to compare with.</div>';
+      refreshBtn.disabled = true;
+      select.disabled = true;
+      return;
+    }
+    siblings.forEach(o => {
+      const opt = document.createElement('option');
+      opt.value = o.id;
+      opt.textContent = `${o.title} (${o.branch})`;
+      select.appendChild(opt);
+    });
+    if (siblings.some(o => o.id === compareState[s.ID])) select.value = compareState[s.ID];
+    load();
+  });
+}
+
+function renderCompareResult(r, el) {
+  el.innerHTML = '';
+  const sides = document.createElement('table');
+  sides.className = 'compare-table';
+  sides.innerHTML = '<tr><th>Session</th><th>Branch</th><th>Commits</th><th>Files</th><th>Lines</th><th>Checks</th></tr>';
+  [r.a, r.b].forEach(side => {
+    const tr = document.createElement('tr');
+    const cells = [
+      side.title + (side.dirty ? ' *' : ''),
+      side.branch,
+      side.commits,
+      side.files,
+      `+${side.added} −${side.deleted}`,
+      CHECK_LABELS[side.checks] || side.checks,
+    ];
+    cells.forEach((v, i) => {
+      const td = document.createElement('td');
+      td.textContent = v;
+      if (i === 5) td.className = `checks-${side.checks}`;
+      tr.appendChild(td);
+    });
+    sides.appendChild(tr);
+  });
+  el.appendChild(sides);
+  if (r.a.dirty || r.b.dirty) {
+    const notice = document.createElement('div');
+    notice.className = 'dirty-notice';
+    notice.textContent = '* uncommitted changes are not included in the comparison';
+    el.appendChild(notice);
+  }
+
+  (r.repos || []).forEach(repo => {
+    const label = document.createElement('div');
+    label.className = 'git-section-label';
+    label.textContent = repo.repo ? `${repo.repo}: ${r.a.branch} → ${r.b.branch}` : `${r.a.branch} → ${r.b.branch}`;
+    el.appendChild(label);
+
+    if (!repo.files.length) {
+      const same = document.createElement('div');
+      same.className = 'compare-muted';
+      same.textContent = 'No differences.';
+      el.appendChild(same);
+      return;
+    }
+    const files = document.createElement('table');
+    files.className = 'compare-table';
+    repo.files.forEach(f => {
+      const tr = document.createElement('tr');
+      const stat = document.createElement('td');
+      stat.className = 'compare-stat';
+      stat.innerHTML = f.binary ? 'binary'
+        : `<span class="diff-add">+${f.added}</span> <span class="diff-del">−${f.deleted}</span>`;
+      const path = document.createElement('td');
+      path.textContent = f.path;
+      tr.appendChild(stat);
+      tr.appendChild(path);
+      files.appendChild(tr);
+    });
+    el.appendChild(files);
+
+    const pre = document.createElement('pre');
+    pre.className = 'git-output';
+    pre.innerHTML = colorDiffLines(repo.diff);
+    el.appendChild(pre);
+  });
+}
+
+// --- Diff viewer ---
+const DIFF_STATUS = { modified: 'M', added: 'A', deleted: 'D', renamed: 'R', copied: 'C', untracked: 'U' };
+const diffViewState = {}; // { [sessionID]: key of the selected file }
+const commitDrafts = {};  // { [sessionID]: unsent commit message }
+let diffMode = localStorage.getItem('diff_mode') || 'unified'; // 'unified' | 'split'
+
+function escapeHTML(text) {
+  return text.replace(/&/g, '&amp;').replace(/</g, '&lt;').replace(/>/g, '&gt;');
+}
+
+function diffFilePath(f) {
+  return f.status === 'deleted' ? f.old_path : f.new_path;
+}
+
+// wordDiff returns a deleted and an added line as HTML, with the tokens that
+// are not part of their longest common subsequence wrapped in .diff-word.
+function wordDiff(oldText, newText) {
+  const a = oldText.match(/\w+|\s+|[^\w\s]/g) || [];
+  const b = newText.match(/\w+|\s+|[^\w\s]/g) || [];
+  if (a.length * b.length > 40000) return [escapeHTML(oldText), escapeHTML(newText)];
+  // lcs[i][j] is the LCS length of a[i:] and b[j:].
+  const lcs = Array.from({ length: a.length + 1 }, () => new Uint16Array(b.length + 1));
+  for (let i = a.length - 1; i >= 0; i--) {
+    for (let j = b.length - 1; j >= 0; j--) {
+      lcs[i][j] = a[i] === b[j] ? lcs[i + 1][j + 1] + 1 : Math.max(lcs[i + 1][j], lcs[i][j + 1]);
+    }
+  }
+  const mark = t => `<span class="diff-word">${escapeHTML(t)}</span>`;
+  const oldOut = [], newOut = [];
+  let i = 0, j = 0;
+  while (i < a.length && j < b.length) {
+    if (a[i] === b[j]) {
+      oldOut.push(escapeHTML(a[i++]));
+      newOut.push(escapeHTML(b[j++]));
+    } else if (lcs[i + 1][j] >= lcs[i][j + 1]) {
+      oldOut.push(mark(a[i++]));
+    } else {
+      newOut.push(mark(b[j++]));
+    }
+  }
+  while (i < a.length) oldOut.push(mark(a[i++]));
+  while (j < b.length) newOut.push(mark(b[j++]));
+  return [oldOut.join(''), newOut.join('')];
+}
+
+// changeRuns calls fn(del, add, end) for each run of deleted lines followed
+// by added lines in hunk, where del and add are the start indexes of each
+// part and end is the index after the run. Either part may be empty.
+function changeRuns(lines, fn) {
+  for (let i = 0; i < lines.length;) {
+    if (lines[i].kind === 'context') { i++; continue; }
+    let d = i;
+    while (d < lines.length && lines[d].kind === 'delete') d++;
+    let a = d;
+    while (a < lines.length && lines[a].kind === 'add') a++;
+    fn(i, d, a);
+    i = a;
+  }
+}
+
+// highlightHunk returns the HTML of each line of hunk, pairing deleted lines
+// with the added lines that replace them for word-level highlighting.
+function highlightHunk(hunk) {
+  const lines = hunk.lines;
+  const html = lines.map(l => escapeHTML(l.content));
+  changeRuns(lines, (del, add, end) => {
+    for (let k = 0; k < Math.min(add - del, end - add); k++) {
+      [html[del + k], html[add + k]] = wordDiff(lines[del + k].content, lines[add + k].content);
+    }
+  });
+  return html;
+}
+
+function unifiedRows(hunk, html) {
+  return hunk.lines.map((l, i) => {
+    const sign = l.kind === 'add' ? '+' : l.kind === 'delete' ? '-' : ' ';
+    return `<tr class="diff-line-${l.kind}" data-line="${l.new_line || l.old_line}"><td class="diff-ln">${l.old_line || ''}</td>` +
+      `<td class="diff-ln">${l.new_line || ''}</td><td class="diff-code">${sign}${html[i]}</td></tr>`;
+  }).join('');
+}
+
+function splitRows(hunk, html) {
+  const lines = hunk.lines;
+  const empty = '<td class="diff-ln"></td><td class="diff-code diff-empty"></td>';
+  const cell = (i, side) => `<td class="diff-ln">${(side === 'old' ? lines[i].old_line : lines[i].new_line) || ''}</td>` +
+    `<td class="diff-code diff-line-${lines[i].kind}">${html[i]}</td>`;
+  const rows = [];
+  lines.forEach((l, i) => {
+    if (l.kind === 'context') rows[i] = `<tr data-line="${l.new_line}">${cell(i, 'old')}${cell(i, 'new')}</tr>`;
+  });
+  changeRuns(lines, (del, add, end) => {
+    const out = [];
+    for (let k = 0; k < Math.max(add - del, end - add); k++) {
+      const line = add + k < end ? lines[add + k].new_line : lines[del + k].old_line;
+      out.push(`<tr data-line="${line}">${del + k < add ? cell(del + k, 'old') : empty}${add + k < end ? cell(add + k, 'new') : empty}</tr>`);
+    }
+    rows[del] = out.join('');
+  });
+  return rows.filter(Boolean).join('');
+}
+
+async function gitAction(s, e, action, hunk, header, reload) {
+  const path = diffFilePath(e.file);
+  if (action === 'discard' && !confirm(hunk < 0 ? `Discard all changes to ${path}?` : `Discard this hunk of ${path}?`)) return;
+  const res = await authFetch(`/api/sessions/${s.ID}/git/${action}`, {
+    method: 'POST',
+    headers: { 'Content-Type': 'application/json' },
+    body: JSON.stringify({ repo: e.repo, path, hunk, header }),
+  });
+  if (res && !res.ok) alert(`Failed: ${(await res.text()).trim()}`);
+  reload();
+}
+
+function diffActionButton(label, onclick) {
+  const btn = document.createElement('button');
+  btn.className = 'git-btn diff-action-btn';
+  btn.textContent = label;
+  btn.onclick = onclick;
+  return btn;
+}
+
+// renderDiffFile shows one file of the diff viewer: its hunks in the current
+// mode, with file- and hunk-level stage, unstage and discard buttons.
+function renderDiffFile(s, e, view, reload, comments) {
+  const f = e.file;
+  const actions = !canOperate(s.GroupPath) ? []
+    : e.staged ? [['Unstage', 'unstage']] : [['Stage', 'stage'], ['Discard', 'discard']];
+  view.innerHTML = '';
+
+  const head = document.createElement('div');
+  head.className = 'diff-file-head';
+  const title = document.createElement('span');
+  title.className = 'diff-file-path';
+  title.textContent = (f.status === 'renamed' || f.status === 'copied')
+    ? `${f.old_path} → ${f.new_path} (${f.similarity}%)`
+    : diffFilePath(f);
+  head.appendChild(title);
+  actions.forEach(([label, action]) => head.appendChild(
+    diffActionButton(label, () => gitAction(s, e, action, -1, '', reload))));
+  view.appendChild(head);
+
+  const note = text => {
+    const msg = document.createElement('div');
+    msg.className = 'compare-muted';
+    msg.textContent = text;
+    view.appendChild(msg);
+  };
+  if (f.old_mode && f.new_mode && f.old_mode !== f.new_mode) note(`mode ${f.old_mode} → ${f.new_mode}`);
+  if (f.binary) { note('Binary file'); return; }
+  if (!f.hunks.length) { note('(no content changes)'); return; }
+
+  appendHunks(view, f, (hunkHead, h, i) => actions.forEach(([text, action]) => hunkHead.appendChild(
+    diffActionButton(text, () => gitAction(s, e, action, i, h.header, reload)))),
+    canOperate(s.GroupPath) ? { session: s, repo: e.repo, commit: '', comments, onChange: reload } : null);
+}
+
+// appendHunks renders the hunks of f into view in the current diff mode.
+// addButtons, if given, is called with each hunk's header row. rc, if given,
+// shows review comments inline and lets clicking a line number add one.
+function appendHunks(view, f, addButtons, rc) {
+  f.hunks.forEach((h, i) => {
+    const hunkHead = document.createElement('div');
+    hunkHead.className = 'diff-hunk-head';
+    const label = document.createElement('span');
+    label.className = 'diff-hunk';
+    label.textContent = h.header;
+    hunkHead.appendChild(label);
+    if (addButtons) addButtons(hunkHead, h, i);
+    view.appendChild(hunkHead);
+
+    const wrap = document.createElement('div');
+    wrap.className = 'diff-table-wrap';
+    const table = document.createElement('table');
+    table.className = `diff-table diff-${diffMode}`;
+    const html = highlightHunk(h);
+    table.innerHTML = diffMode === 'split' ? splitRows(h, html) : unifiedRows(h, html);
+    if (rc) attachReview(table, diffFilePath(f), rc);
+    wrap.appendChild(table);
+    view.appendChild(wrap);
+  });
+}
+
+// renderDiffViewer loads the session's uncommitted changes into el: a tree of
+// staged and unstaged files, the selected file's hunks and a commit box.
+// reload is called after any change to the worktree.
+async function renderDiffViewer(s, el, reload) {
+  el.innerHTML = '<div class="compare-muted">loading...</div>';
+  const [res, comments] = await Promise.all([
+    authFetch(`/api/sessions/${s.ID}/git/diff?format=json`),
+    fetchReviewComments(s),
+  ]);
+  if (!res || !res.ok) {
+    el.innerHTML = '<div class="compare-muted">(error fetching diff)</div>';
+    return;
+  }
+  const { repos } = await res.json();
+  const entries = [];
+  repos.forEach(r => {
+    r.staged.forEach(file => entries.push({ repo: r.repo, staged: true, file }));
+    r.unstaged.forEach(file => entries.push({ repo: r.repo, staged: false, file }));
+  });
+  el.innerHTML = '';
+  if (!entries.length) {
+    el.innerHTML = '<div class="compare-muted">(no changes)</div>';
+    return;
+  }
+  const key = e => `${e.repo}:${e.staged ? 'staged' : 'unstaged'}:${diffFilePath(e.file)}`;
+
+  // Toolbar: prev/next file (the way around on phones) and the mode toggle.
+  const toolbar = document.createElement('div');
+  toolbar.className = 'git-action-row';
+  const prevBtn = diffActionButton('‹ Prev', () => select(idx - 1));
+  const nextBtn = diffActionButton('Next ›', () => select(idx + 1));
+  const counter = document.createElement('span');
+  counter.className = 'compare-muted';
+  const modeBtn = diffActionButton('', () => {
+    diffMode = diffMode === 'split' ? 'unified' : 'split';
+    localStorage.setItem('diff_mode', diffMode);
+    select(idx);
+  });
+  [prevBtn, counter, nextBtn, modeBtn].forEach(n => toolbar.appendChild(n));
+  el.appendChild(toolbar);
+
+  const body = document.createElement('div');
+  body.className = 'diff-body';
+  const tree = document.createElement('div');
+  tree.className = 'diff-tree';
+  const view = document.createElement('div');
+  view.className = 'diff-file';
+  body.appendChild(tree);
+  body.appendChild(view);
+  el.appendChild(body);
+
+  let lastRepo = null, lastStaged = null;
+  const rows = entries.map((e, i) => {
+    if (repos.length > 1 && e.repo !== lastRepo) {
+      const label = document.createElement('div');
+      label.className = 'diff-tree-repo';
+      label.textContent = e.repo;
+      tree.appendChild(label);
+      lastStaged = null;
+    }
+    if (e.staged !== lastStaged) {
+      const label = document.createElement('div');
+      label.className = 'git-section-label';
+      label.textContent = e.staged ? 'Staged' : 'Changes';
+      tree.appendChild(label);
+    }
+    lastRepo = e.repo;
+    lastStaged = e.staged;
+
+    const f = e.file;
+    const row = document.createElement('div');
+    row.className = 'diff-tree-file';
+    row.title = diffFilePath(f);
+    row.innerHTML = `<span class="diff-status diff-status-${f.status}">${DIFF_STATUS[f.status] || '?'}</span>` +
+      `<span class="diff-tree-path">${escapeHTML(diffFilePath(f))}</span>` +
+      (f.binary ? '' : `<span class="diff-add">+${f.added}</span><span class="diff-del">−${f.deleted}</span>`);
+    row.onclick = () => select(i);
+    tree.appendChild(row);
+    return row;
+  });
+
+  let idx = 0;
+  function select(i) {
+    idx = Math.min(Math.max(i, 0), entries.length - 1);
+    diffViewState[s.ID] = key(entries[idx]);
+    rows.forEach((r, j) => r.classList.toggle('active', j === idx));
+    counter.textContent = `${idx + 1} / ${entries.length}`;
+    prevBtn.disabled = idx === 0;
+    nextBtn.disabled = idx === entries.length - 1;
+    modeBtn.textContent = diffMode === 'split' ? 'Unified' : 'Split';
+    renderDiffFile(s, entries[idx], view, reload, comments);
+  }
+  select(entries.findIndex(e => key(e) === diffViewState[s.ID]));
+
+  if (!canOperate(s.GroupPath)) return;
+
+  // Commit box for the staged changes of every repo.
+  const commitBox = document.createElement('div');
+  commitBox.className = 'diff-commit';
+  const msg = document.createElement('textarea');
+  msg.className = 'notes-area diff-commit-msg';
+  msg.placeholder = 'Commit message...';
+  msg.value = commitDrafts[s.ID] || '';
+  msg.oninput = () => { commitDrafts[s.ID] = msg.value; };
+  const commitBtn = document.createElement('button');
+  commitBtn.className = 'git-btn';
+  commitBtn.textContent = 'Commit staged';
+  commitBtn.disabled = !entries.some(e => e.staged);
+  commitBtn.onclick = async () => {
+    if (!msg.value.trim()) return;
+    const res = await authFetch(`/api/sessions/${s.ID}/git/commit`, {
+      method: 'POST',
+      headers: { 'Content-Type': 'application/json' },
+      body: JSON.stringify({ message: msg.value }),
+    });
+    if (res && !res.ok) {
+      alert(`Failed: ${(await res.text()).trim()}`);
+      return;
+    }
+    delete commitDrafts[s.ID];
+    fetchSessions();
+    reload();
+  };
+  commitBox.appendChild(msg);
+  commitBox.appendChild(commitBtn);
+  el.appendChild(commitBox);
+}
+
+// --- Review ---
+
+async function fetchReviewComments(s) {
+  const res = await authFetch(`/api/sessions/${s.ID}/review`);
+  return res && res.ok ? (await res.json()).comments : [];
+}
+
+function reviewLines(c) {
+  return c.LineEnd > c.LineStart ? `${c.LineStart}–${c.LineEnd}` : `${c.LineStart}`;
+}
+
+async function deleteReviewComment(s, c, onChange) {
+  const res = await authFetch(`/api/sessions/${s.ID}/review/comments/${c.ID}`, { method: 'DELETE' });
+  if (res && !res.ok) alert(`Failed: ${res.status}`);
+  onChange();
+}
+
+// renderReviewPanel lists the session's open review comments in el with a
+// button that sends them to the agent. It is empty when there are none.
+async function renderReviewPanel(s, el, onChange) {
+  const comments = await fetchReviewComments(s);
+  el.innerHTML = '';
+  el.className = comments.length ? 'review-panel' : '';
+  if (!comments.length) return;
+
+  const label = document.createElement('div');
+  label.className = 'git-section-label';
+  label.textContent = `Review · ${comments.length} open`;
+  el.appendChild(label);
+  comments.forEach(c => {
+    const row = document.createElement('div');
+    row.className = 'review-item';
+    const where = document.createElement('div');
+    where.className = 'compare-muted';
+    where.textContent = `${c.Repo ? c.Repo + '/' : ''}${c.File}:${reviewLines(c)}` + (c.Commit ? ` @ ${c.Commit.slice(0, 7)}` : '');
+    const body = document.createElement('div');
+    body.className = 'review-body';
+    body.textContent = c.Body;
+    row.appendChild(where);
+    row.appendChild(body);
+    if (canOperate(s.GroupPath)) row.appendChild(diffActionButton('✕', () => deleteReviewComment(s, c, onChange)));
+    el.appendChild(row);
+  });
+  if (!canOperate(s.GroupPath)) return;
+
+  const sendBtn = document.createElement('button');
+  sendBtn.className = 'git-btn';
+  sendBtn.textContent = 'Send review to agent';
+  sendBtn.onclick = async () => {
+    const res = await authFetch(`/api/sessions/${s.ID}/review/send`, { method: 'POST' });
+    if (res && !res.ok) {
+      alert(`Failed: ${(await res.text()).trim()}`);
+      return;
+    }
+    onChange();
+  };
+  el.appendChild(sendBtn);
+}
+
+// attachReview shows rc's comments on path under the rows they end on and
+// opens a comment form when a line number is clicked. Shift-clicking another
+// line while the form is open extends its range.
+function attachReview(table, path, rc) {
+  const cols = diffMode === 'split' ? 4 : 3;
+  rc.comments
+    .filter(c => c.File === path && c.Repo === rc.repo && c.Commit === rc.commit)
+    .forEach(c => {
+      let at = table.querySelector(`tr[data-line="${c.LineEnd}"]`);
+      if (!at) return;
+      while (at.nextElementSibling && at.nextElementSibling.classList.contains('review-row')) at = at.nextElementSibling;
+      const tr = document.createElement('tr');
+      tr.className = 'review-row';
+      const td = document.createElement('td');
+      td.colSpan = cols;
+      const box = document.createElement('div');
+      box.className = 'review-comment';
+      const body = document.createElement('span');
+      body.className = 'review-body';
+      body.textContent = c.Body;
+      box.innerHTML = `<span class="compare-muted">${reviewLines(c)}</span>`;
+      box.appendChild(body);
+      box.appendChild(diffActionButton('✕', () => deleteReviewComment(rc.session, c, rc.onChange)));
+      td.appendChild(box);
+      tr.appendChild(td);
+      at.after(tr);
+    });
+
+  table.addEventListener('click', ev => {
+    const td = ev.target.closest('td.diff-ln');
+    const row = td && td.closest('tr[data-line]');
+    if (!row) return;
+    const line = +row.dataset.line;
+    const open = table.querySelector('.review-form-row');
+    if (open && ev.shiftKey) {
+      const [start, end] = open.querySelectorAll('input');
+      const lo = Math.min(+start.value, line), hi = Math.max(+end.value, line);
+      start.value = lo;
+      end.value = hi;
+      return;
+    }
+    if (open) open.remove();
+    row.after(reviewFormRow(path, line, cols, rc));
+  });
+}
+
+function reviewFormRow(path, line, cols, rc) {
+  const tr = document.createElement('tr');
+  tr.className = 'review-form-row';
+  const td = document.createElement('td');
+  td.colSpan = cols;
+  const form = document.createElement('div');
+  form.className = 'review-form';
+  const range = document.createElement('div');
+  range.className = 'compare-muted';
+  const start = document.createElement('input');
+  const end = document.createElement('input');
+  [start, end].forEach(inp => { inp.type = 'number'; inp.min = 1; inp.value = line; inp.className = 'review-line'; });
+  range.append('Lines ', start, ' to ', end, ' (shift-click a line to extend)');
+  const text = document.createElement('textarea');
+  text.className = 'notes-area review-input';
+  text.placeholder = 'Comment for the agent...';
+  const buttons = document.createElement('div');
+  buttons.className = 'git-btn-row';
+  buttons.appendChild(diffActionButton('Comment', async () => {
+    if (!text.value.trim()) return;
+    const res = await authFetch(`/api/sessions/${rc.session.ID}/review/comments`, {
+      method: 'POST',
+      headers: { 'Content-Type': 'application/json' },
+      body: JSON.stringify({
+        repo: rc.repo, file: path, commit: rc.commit, body: text.value,
+        line_start: +start.value, line_end: +end.value,
+      }),
+    });
+    if (res && !res.ok) {
+      alert(`Failed: ${(await res.text()).trim()}`);
+      return;
+    }
+    rc.onChange();
+  }));
+  buttons.appendChild(diffActionButton('Cancel', () => tr.remove()));
+  form.appendChild(range);
+  form.appendChild(text);
+  form.appendChild(buttons);
+  td.appendChild(form);
+  tr.appendChild(td);
+  setTimeout(() => text.focus(), 0);
+  return tr;
+}
+
+// --- History ---
+const historyState = {}; // { [sessionID]: { repo, all, sha } }
+
+// renderHistory shows the session's commits (since its base branch unless
+// "all" is ticked) next to the selected commit's message and diff.
+function renderHistory(s, container) {
+  const st = historyState[s.ID] || (historyState[s.ID] = { repo: '', all: false, sha: '' });
+  const panel = document.createElement('div');
+  panel.className = 'git-panel';
+
+  const actionRow = document.createElement('div');
+  actionRow.className = 'git-action-row';
+  const repoSelect = document.createElement('select');
+  repoSelect.className = 'form-select compare-select';
+  repoSelect.style.display = 'none';
+  const allLabel = document.createElement('label');
+  allLabel.className = 'compare-muted history-all';
+  const allBox = document.createElement('input');
+  allBox.type = 'checkbox';
+  allBox.checked = st.all;
+  allLabel.appendChild(allBox);
+  allLabel.appendChild(document.createTextNode(' all history'));
+  const baseNote = document.createElement('span');
+  baseNote.className = 'compare-muted';
+  const modeBtn = diffActionButton('', () => {
+    diffMode = diffMode === 'split' ? 'unified' : 'split';
+    localStorage.setItem('diff_mode', diffMode);
+    modeBtn.textContent = diffMode === 'split' ? 'Unified' : 'Split';
+    if (st.sha) showCommit(st.sha);
+  });
+  modeBtn.textContent = diffMode === 'split' ? 'Unified' : 'Split';
+  const refreshBtn = document.createElement('button');
+  refreshBtn.className = 'git-btn';
+  refreshBtn.textContent = '↻ Refresh';
+  [repoSelect, allLabel, baseNote, modeBtn, refreshBtn].forEach(n => actionRow.appendChild(n));
+  panel.appendChild(actionRow);
+  const reviewEl = document.createElement('div');
+  panel.appendChild(reviewEl);
+
+  const body = document.createElement('div');
+  body.className = 'diff-body';
+  const list = document.createElement('div');
+  list.className = 'diff-tree history-list';
+  const view = document.createElement('div');
+  view.className = 'diff-file';
+  body.appendChild(list);
+  body.appendChild(view);
+  panel.appendChild(body);
+  container.appendChild(panel);
+
+  const repoParam = () => st.repo ? `repo=${encodeURIComponent(st.repo)}` : '';
+
+  async function showCommit(sha) {
+    st.sha = sha;
+    list.querySelectorAll('.history-commit').forEach(r => r.classList.toggle('active', r.dataset.sha === sha));
+    view.innerHTML = '<div class="compare-muted">loading...</div>';
+    const [res, comments] = await Promise.all([
+      authFetch(`/api/sessions/${s.ID}/git/commits/${sha}?${repoParam()}`),
+      fetchReviewComments(s),
+    ]);
+    if (!res || !res.ok) {
+      view.innerHTML = '<div class="compare-muted">(error fetching commit)</div>';
+      return;
+    }
+    const c = await res.json();
+    view.innerHTML = '';
+    const meta = document.createElement('div');
+    meta.className = 'compare-muted';
+    meta.textContent = `${c.short} · ${c.author} · ${new Date(c.date).toLocaleString()}`;
+    const msg = document.createElement('pre');
+    msg.className = 'git-output';
+    msg.textContent = c.message;
+    view.appendChild(meta);
+    view.appendChild(msg);
+    if (!c.files.length) {
+      const none = document.createElement('div');
+      none.className = 'compare-muted';
+      none.textContent = '(no changes)';
+      view.appendChild(none);
+    }
+    c.files.forEach(f => {
+      const head = document.createElement('div');
+      head.className = 'diff-file-head';
+      const title = document.createElement('span');
+      title.className = 'diff-file-path';
+      title.innerHTML = `<span class="diff-status diff-status-${f.status}">${DIFF_STATUS[f.status] || '?'}</span> ` +
+        escapeHTML((f.status === 'renamed' || f.status === 'copied') ? `${f.old_path} → ${f.new_path}` : diffFilePath(f));
+      head.appendChild(title);
+      view.appendChild(head);
+      if (f.binary) {
+        const bin = document.createElement('div');
+        bin.className = 'compare-muted';
+        bin.textContent = 'Binary file';
+        view.appendChild(bin);
+        return;
+      }
+      appendHunks(view, f, null, canOperate(s.GroupPath)
+        ? { session: s, repo: st.repo, commit: c.hash, comments, onChange: refreshReview } : null);
+    });
+  }
+
+  // loadPage appends the page of commits starting at skip to the list.
+  async function loadPage(skip) {
+    const params = [repoParam(), `skip=${skip}`, st.all ? 'all=1' : ''].filter(Boolean).join('&');
+    const res = await authFetch(`/api/sessions/${s.ID}/git/log?${params}`);
+    list.querySelectorAll('.history-more').forEach(b => b.remove());
+    if (!res || !res.ok) {
+      list.innerHTML = `<div class="compare-muted">${res ? escapeHTML((await res.text()).trim()) : '(error)'}</div>`;
+      return;
+    }
+    const page = await res.json();
+    if (skip === 0) {
+      list.innerHTML = '';
+      if (page.repos.length > 1 && !repoSelect.options.length) {
+        page.repos.forEach(r => {
+          const opt = document.createElement('option');
+          opt.value = opt.textContent = r;
+          repoSelect.appendChild(opt);
+        });
+        repoSelect.style.display = '';
+      }
+      repoSelect.value = st.repo = page.repo;
+      baseNote.textContent = page.base ? `since ${page.base}` : '';
+      if (!page.commits.length) {
+        list.innerHTML = '<div class="compare-muted">No commits.</div>';
+        view.innerHTML = '';
+        return;
+      }
+    }
+    page.commits.forEach(c => {
+      const row = document.createElement('div');
+      row.className = 'history-commit';
+      row.dataset.sha = c.hash;
+      row.innerHTML = `<div><span class="history-hash">${c.short}</span> ${escapeHTML(c.subject)}</div>` +
+        `<div class="compare-muted">${escapeHTML(c.author)} · ${escapeHTML(c.rel_date)}</div>`;
+      row.onclick = () => showCommit(c.hash);
+      list.appendChild(row);
+    });
+    if (page.more) {
+      const more = diffActionButton('Load more', () => loadPage(list.querySelectorAll('.history-commit').length));
+      more.classList.add('history-more');
+      list.appendChild(more);
+    }
+    if (skip === 0) {
+      const rows = [...list.querySelectorAll('.history-commit')];
+      showCommit(rows.some(r => r.dataset.sha === st.sha) ? st.sha : rows[0].dataset.sha);
+    }
+  }
+
+  function refreshReview() {
+    renderReviewPanel(s, reviewEl, refreshReview);
+    if (st.sha) showCommit(st.sha);
+  }
+
+  const reload = () => {
+    list.innerHTML = '<div class="compare-muted">loading...</div>';
+    renderReviewPanel(s, reviewEl, refreshReview);
+    loadPage(0);
+  };
+  repoSelect.onchange = () => { st.repo = repoSelect.value; st.sha = ''; reload(); };
+  allBox.onchange = () => { st.all = allBox.checked; reload(); };
+  refreshBtn.onclick = reload;
+  reload();
+}
+
+// --- Checkpoints ---
+const checkpointState = {}; // { [sessionID]: { repo, n, against } }
+
+// renderCheckpoints shows the session's worktree snapshots as a timeline next
+// to the selected checkpoint's diff against the previous one or the current
+// worktree, with a button to restore it.
+function renderCheckpoints(s, container) {
+  const st = checkpointState[s.ID] || (checkpointState[s.ID] = { repo: '', n: 0, against: 'previous' });
+  const panel = document.createElement('div');
+  panel.className = 'git-panel';
+
+  const actionRow = document.createElement('div');
+  actionRow.className = 'git-action-row';
+  const repoSelect = document.createElement('select');
+  repoSelect.className = 'form-select compare-select';
+  repoSelect.style.display = 'none';
+  const againstSelect = document.createElement('select');
+  againstSelect.className = 'form-select compare-select';
+  [['previous', 'vs previous'], ['current', 'vs current worktree']].forEach(([value, label]) => {
+    const opt = document.createElement('option');
+    opt.value = value;
+    opt.textContent = label;
+    againstSelect.appendChild(opt);
+  });
+  againstSelect.value = st.against;
+  const modeBtn = diffActionButton('', () => {
+    diffMode = diffMode === 'split' ? 'unified' : 'split';
+    localStorage.setItem('diff_mode', diffMode);
+    modeBtn.textContent = diffMode === 'split' ? 'Unified' : 'Split';
+    if (st.n) showCheckpoint(st.n);
+  });
+  modeBtn.textContent = diffMode === 'split' ? 'Unified' : 'Split';
+  const createBtn = diffActionButton('Checkpoint now', async () => {
+    const res = await authFetch(`/api/sessions/${s.ID}/checkpoints?${repoParam()}`, { method: 'POST' });
+    if (res && !res.ok) alert(`Failed: ${(await res.text()).trim()}`);
+    else if (res && res.status === 200) alert('No changes since the latest checkpoint.');
+    st.n = 0;
+    reload();
+  });
+  const refreshBtn = document.createElement('button');
+  refreshBtn.className = 'git-btn';
+  refreshBtn.textContent = '↻ Refresh';
+  [repoSelect, againstSelect, modeBtn, createBtn, refreshBtn].forEach(n => actionRow.appendChild(n));
+  if (!canOperate(s.GroupPath)) createBtn.remove();
+  panel.appendChild(actionRow);
+
+  const body = document.createElement('div');
+  body.className = 'diff-body';
+  const list = document.createElement('div');
+  list.className = 'diff-tree history-list';
+  const view = document.createElement('div');
+  view.className = 'diff-file';
+  body.appendChild(list);
+  body.appendChild(view);
+  panel.appendChild(body);
+  container.appendChild(panel);
+
+  const repoParam = () => st.repo ? `repo=${encodeURIComponent(st.repo)}` : '';
+  let checkpoints = [];
+
+  async function showCheckpoint(n) {
+    st.n = n;
+    list.querySelectorAll('.history-commit').forEach(r => r.classList.toggle('active', r.dataset.n === String(n)));
+    const cp = checkpoints.find(c => c.n === n);
+    // Checkpoints are numbered without gaps; the first is compared with the
+    // commit it was taken on.
+    const [from, to] = st.against === 'current' ? [n, 'current'] : [n > 1 ? n - 1 : `${n}^`, n];
+    view.innerHTML = '<div class="compare-muted">loading...</div>';
+    const params = [repoParam(), `from=${encodeURIComponent(from)}`, `to=${to}`].filter(Boolean).join('&');
+    const res = await authFetch(`/api/sessions/${s.ID}/checkpoints/diff?${params}`);
+    if (!res || !res.ok) {
+      view.innerHTML = '<div class="compare-muted">(error fetching diff)</div>';
+      return;
+    }
+    const { files } = await res.json();
+    view.innerHTML = '';
+    const head = document.createElement('div');
+    head.className = 'diff-file-head';
+    const title = document.createElement('span');
+    title.className = 'diff-file-path';
+    title.textContent = st.against === 'current'
+      ? `Checkpoint ${n} → current worktree`
+      : `${n > 1 ? `Checkpoint ${n - 1}` : 'Commit'} → checkpoint ${n}`;
+    head.appendChild(title);
+    if (canOperate(s.GroupPath)) head.appendChild(diffActionButton('Restore', async () => {
+      if (!confirm(`Restore the worktree to checkpoint ${n}? The current state is saved as a new checkpoint first.`)) return;
+      const res = await authFetch(`/api/sessions/${s.ID}/checkpoints/${n}/restore?${repoParam()}`, { method: 'POST' });
+      if (res && !res.ok) alert(`Failed: ${(await res.text()).trim()}`);
+      st.n = n;
+      reload();
+    }));
+    view.appendChild(head);
+    if (cp) {
+      const meta = document.createElement('div');
+      meta.className = 'compare-muted';
+      meta.textContent = `${cp.message} · ${new Date(cp.time).toLocaleString()}` + (cp.base ? ` · on ${cp.base.slice(0, 7)}` : '');
+      view.appendChild(meta);
+    }
+    if (!files.length) {
+      const none = document.createElement('div');
+      none.className = 'compare-muted';
+      none.textContent = '(no changes)';
+      view.appendChild(none);
+    }
+    files.forEach(f => {
+      const fileHead = document.createElement('div');
+      fileHead.className = 'diff-file-head';
+      const path = document.createElement('span');
+      path.className = 'diff-file-path';
+      path.innerHTML = `<span class="diff-status diff-status-${f.status}">${DIFF_STATUS[f.status] || '?'}</span> ` +
+        escapeHTML((f.status === 'renamed' || f.status === 'copied') ? `${f.old_path} → ${f.new_path}` : diffFilePath(f));
+      fileHead.appendChild(path);
+      view.appendChild(fileHead);
+      if (f.binary) {
+        const bin = document.createElement('div');
+        bin.className = 'compare-muted';
+        bin.textContent = 'Binary file';
+        view.appendChild(bin);
+        return;
+      }
+      appendHunks(view, f, null, null);
+    });
+  }
+
+  async function reload() {
+    list.innerHTML = '<div class="compare-muted">loading...</div>';
+    const res = await authFetch(`/api/sessions/${s.ID}/checkpoints?${repoParam()}`);
+    if (!res || !res.ok) {
+      list.innerHTML = `<div class="compare-muted">${res ? escapeHTML((await res.text()).trim()) : '(error)'}</div>`;
+      return;
+    }
+    const data = await res.json();
+    if (data.repos.length > 1 && !repoSelect.options.length) {
+      data.repos.forEach(r => {
+        const opt = document.createElement('option');
+        opt.value = opt.textContent = r;
+        repoSelect.appendChild(opt);
+      });
+      repoSelect.style.display = '';
+    }
+    repoSelect.value = st.repo = data.repo;
+    checkpoints = data.checkpoints;
+    list.innerHTML = '';
+    if (!checkpoints.length) {
+      list.innerHTML = '<div class="compare-muted">No checkpoints. Enable checkpoints on the group to take one whenever the agent stops running.</div>';
+      view.innerHTML = '';
+      return;
+    }
+    checkpoints.forEach(c => {
+      const row = document.createElement('div');
+      row.className = 'history-commit';
+      row.dataset.n = c.n;
+      row.innerHTML = `<div><span class="history-hash">#${c.n}</span> ${escapeHTML(c.message)}</div>` +
+        `<div class="compare-muted">${escapeHTML(new Date(c.time).toLocaleString())}</div>`;
+      row.onclick = () => showCheckpoint(c.n);
+      list.appendChild(row);
+    });
+    showCheckpoint(checkpoints.some(c => c.n === st.n) ? st.n : checkpoints[0].n);
+  }
+
+  repoSelect.onchange = () => { st.repo = repoSelect.value; st.n = 0; reload(); };
+  againstSelect.onchange = () => { st.against = againstSelect.value; if (st.n) showCheckpoint(st.n); };
+  refreshBtn.onclick = reload;
+  reload();
+}
+
 // --- Usage ---
 function usageColorClass(util) {
   if (util >= 0.8) return 'red';
@@ -299,13 +1373,30 @@ function buildUsageRow(label, util, resetsAtMs) {
   return row;
 }
 
+// The latest snapshot and budget status, updated by events between fetches.
+let usageData = null;
+
 async function fetchUsage() {
   const widget = document.getElementById('usage-widget');
   if (!widget) return;
   const res = await authFetch('/api/usage');
   if (!res || !res.ok) return;
-  const data = await res.json();
-  renderUsage(data, widget);
+  usageData = await res.json();
+  renderUsage(usageData, widget);
+}
+
+// buildBudgetLine describes when the fuller window reaches its limit at the
+// current rate, and whether new sessions are paused.
+function buildBudgetLine(budget) {
+  const f = budget.SevenDay.Util > budget.FiveHour.Util ? budget.SevenDay : budget.FiveHour;
+  const parts = [];
+  if (f.LimitAt) parts.push(f.LimitAt <= Date.now() ? 'at limit' : `limit ${formatUsageReset(f.LimitAt)}`);
+  if (budget.Level === 'paused' || budget.Level === 'throttled') parts.push('new sessions paused');
+  if (!parts.length) return null;
+  const line = document.createElement('div');
+  line.className = `usage-budget ${budget.Level}`;
+  line.textContent = parts.join(' · ');
+  return line;
 }
 
 function renderUsage(data, widget) {
@@ -329,6 +1420,9 @@ function renderUsage(data, widget) {
     if (pct) pct.textContent = `$${used}`;
     widget.appendChild(row);
   }
+
+  const line = data.budget && buildBudgetLine(data.budget);
+  if (line) widget.appendChild(line);
 }
 
 // --- Create form (inline in sidebar) ---
@@ -370,6 +1464,40 @@ function buildCreateForm(groupPath) {
     pathInput.type = 'text'; pathInput.className = 'form-input'; pathInput.placeholder = 'required';
   }
 
+  // Repo-backed groups can pick a base branch, a branch name, or an existing
+  // remote branch to check out. Remote branches feed a shared datalist.
+  let baseInput = null, branchInput = null, checkoutInput = null;
+  if (hasRepoURL) {
+    const listID = 'branches-' + groupPath;
+    const datalist = document.createElement('datalist');
+    datalist.id = listID;
+    let branchesLoaded = false;
+    const loadBranches = async () => {
+      if (branchesLoaded) return;
+      branchesLoaded = true;
+      const res = await authFetch(`/api/groups/${encodeURIComponent(groupPath)}/branches`);
+      if (!res || !res.ok) return;
+      const data = await res.json();
+      (data.branches || []).forEach(b => {
+        const opt = document.createElement('option');
+        opt.value = b; datalist.appendChild(opt);
+      });
+    };
+    const mkBranchInput = (placeholder) => {
+      const input = document.createElement('input');
+      input.type = 'text'; input.className = 'form-input'; input.placeholder = placeholder;
+      input.setAttribute('list', listID);
+      input.onfocus = loadBranches;
+      return input;
+    };
+    baseInput = mkBranchInput(group.BaseBranch || 'default');
+    branchInput = mkBranchInput('from title');
+    checkoutInput = document.createElement('input');
+    checkoutInput.type = 'checkbox'; checkoutInput.className = 'form-checkbox';
+    checkoutInput.onchange = () => { baseInput.disabled = checkoutInput.checked; };
+    form.appendChild(datalist);
+  }
+
   submitBtn.onclick = async (e) => {
     e.stopPropagation();
     if (!hasRepoURL && (!pathInput || !pathInput.value.trim())) {
@@ -384,9 +1512,12 @@ function buildCreateForm(groupPath) {
         tool: toolSelect.value,
         group_path: groupPath,
         project_path: pathInput ? pathInput.value.trim() : '',
+        base_branch: baseInput && !checkoutInput.checked ? baseInput.value.trim() : '',
+        branch: branchInput ? branchInput.value.trim() : '',
+        checkout: checkoutInput ? checkoutInput.checked : false,
       }),
     });
-    if (res && !res.ok) alert(`Create failed: ${res.status}`);
+    if (res && !res.ok) alert(`Create failed: ${res.status} ${(await res.text()).trim()}`);
     else openCreateForms.delete(groupPath);
     fetchSessions();
   };
@@ -394,15 +1525,274 @@ function buildCreateForm(groupPath) {
   form.appendChild(mk('Title', titleInput));
   form.appendChild(mk('Tool', toolSelect));
   if (pathInput) form.appendChild(mk('Path', pathInput));
+  if (baseInput) form.appendChild(mk('Base', baseInput));
+  if (branchInput) form.appendChild(mk('Branch', branchInput));
+  if (checkoutInput) form.appendChild(mk('Existing', checkoutInput));
   form.appendChild(submitBtn);
   return form;
 }
 
+// --- Account settings ---
+let settingsOpen = false;
+const loginsOpen = new Set(); // account IDs whose logins are expanded
+
+function openSettings() {
+  settingsOpen = true;
+  mobileShowDetail = true;
+  if (selectedSessionID) { selectedSessionID = null; render(); return; }
+  rebuildDetail();
+  render();
+}
+
+function formatDateTime(tsStr) {
+  if (!tsStr) return 'never';
+  return new Date(tsStr).toLocaleString([], { dateStyle: 'short', timeStyle: 'short' });
+}
+
+// accountRequest sends a change to /api/accounts and alerts on failure.
+async function accountRequest(url, method, body) {
+  const options = { method };
+  if (body) {
+    options.headers = { 'Content-Type': 'application/json' };
+    options.body = JSON.stringify(body);
+  }
+  const res = await authFetch(url, options);
+  if (res && !res.ok) { alert(`Failed: ${(await res.text()).trim()}`); return false; }
+  return !!res;
+}
+
+function roleSelect(value) {
+  const select = document.createElement('select');
+  select.className = 'form-select compare-select';
+  ['viewer', 'operator', 'admin'].forEach(r => {
+    const opt = document.createElement('option');
+    opt.value = r;
+    opt.textContent = r;
+    select.appendChild(opt);
+  });
+  select.value = value;
+  return select;
+}
+
+// renderSettings shows the caller's two-factor login and, for admins, the
+// accounts with their roles, last login and active logins, letting an admin
+// add, disable, delete and sign out users.
+function renderSettings(container) {
+  const panel = document.createElement('div');
+  panel.className = 'git-panel';
+  container.appendChild(panel);
+  const reload = async () => { await fetchMe(); panel.remove(); renderSettings(container); };
+
+  renderTwoFactor(panel, reload);
+  if (me.role !== 'admin') return;
+
+  const title = document.createElement('div');
+  title.className = 'git-section-label';
+  title.textContent = 'ACCOUNTS';
+  panel.appendChild(title);
+
+  // New account form.
+  const addRow = document.createElement('div');
+  addRow.className = 'git-action-row';
+  const userInput = document.createElement('input');
+  userInput.className = 'form-input compare-select';
+  userInput.placeholder = 'username';
+  const pwInput = document.createElement('input');
+  pwInput.className = 'form-input compare-select';
+  pwInput.type = 'password';
+  pwInput.placeholder = 'password';
+  const newRole = roleSelect('operator');
+  const addBtn = diffActionButton('Add user', async () => {
+    const ok = await accountRequest('/api/accounts', 'POST',
+      { username: userInput.value.trim(), password: pwInput.value, role: newRole.value });
+    if (ok) reload();
+  });
+  [userInput, pwInput, newRole, addBtn].forEach(n => addRow.appendChild(n));
+  panel.appendChild(addRow);
+
+  const table = document.createElement('table');
+  table.className = 'compare-table';
+  table.innerHTML = '<tr><th>User</th><th>Role</th><th>Status</th><th>2FA</th><th>Last login</th><th>Logins</th><th></th></tr>';
+  panel.appendChild(table);
+
+  authFetch('/api/accounts').then(async res => {
+    if (!res || !res.ok) {
+      table.innerHTML = `<tr><td class="compare-muted">(error: ${res ? res.status : ''})</td></tr>`;
+      return;
+    }
+    const { accounts } = await res.json();
+    accounts.forEach(a => {
+      const self = a.username === me.username;
+      const url = `/api/accounts/${a.id}`;
+      const tr = document.createElement('tr');
+      const overrides = Object.entries(a.groups || {}).map(([g, r]) => `${g}: ${r}`).join(', ');
+      tr.innerHTML = `<td>${escapeHTML(a.username)}${self ? ' <span class="compare-muted">(you)</span>' : ''}</td>` +
+        '<td></td>' +
+        `<td class="${a.disabled ? 'checks-fail' : 'checks-pass'}">${a.disabled ? 'disabled' : 'active'}</td>` +
+        `<td>${a.totp_enabled ? 'on' : 'off'}</td>` +
+        `<td>${formatDateTime(a.last_login)}</td><td>${a.logins}</td><td></td>`;
+      const role = roleSelect(a.role);
+      role.disabled = self;
+      role.title = overrides ? 'group overrides: ' + overrides : '';
+      role.onchange = async () => {
+        if (await accountRequest(url, 'PATCH', { role: role.value })) reload();
+      };
+      tr.children[1].appendChild(role);
+
+      const actions = tr.children[6];
+      actions.className = 'git-btn-row';
+      actions.appendChild(diffActionButton(loginsOpen.has(a.id) ? 'Hide logins' : 'Logins', () => {
+        if (loginsOpen.has(a.id)) loginsOpen.delete(a.id); else loginsOpen.add(a.id);
+        reload();
+      }));
+      actions.appendChild(diffActionButton('Reset password', async () => {
+        const pw = prompt(`New password for ${a.username}:`);
+        if (pw && await accountRequest(url, 'PATCH', { password: pw })) reload();
+      }));
+      if (a.totp_enabled) {
+        actions.appendChild(diffActionButton('Reset 2FA', async () => {
+          if (!confirm(`Turn off two-factor login for ${a.username}? Use this when they lost their device.`)) return;
+          if (await accountRequest(url, 'PATCH', { reset_totp: true })) reload();
+        }));
+      }
+      if (!self) {
+        actions.appendChild(diffActionButton(a.disabled ? 'Enable' : 'Disable', async () => {
+          if (await accountRequest(url, 'PATCH', { disabled: !a.disabled })) reload();
+        }));
+        actions.appendChild(diffActionButton('Delete', async () => {
+          if (!confirm(`Delete account ${a.username}?`)) return;
+          if (await accountRequest(url, 'DELETE')) reload();
+        }));
+      }
+      table.appendChild(tr);
+      if (loginsOpen.has(a.id)) table.appendChild(loginsRow(a, reload));
+    });
+  });
+}
+
+// renderTwoFactor shows whether the caller signs in with a second factor and
+// walks them through turning it on or off and replacing recovery codes.
+function renderTwoFactor(panel, reload) {
+  const title = document.createElement('div');
+  title.className = 'git-section-label';
+  title.textContent = 'TWO-FACTOR AUTHENTICATION';
+  panel.appendChild(title);
+
+  const status = document.createElement('div');
+  status.className = 'compare-muted';
+  status.textContent = me.totp_enabled
+    ? `On. ${me.recovery_codes} recovery codes left.`
+    : 'Off. Sign in with a code from an authenticator app as well as your password.';
+  panel.appendChild(status);
+
+  const row = document.createElement('div');
+  row.className = 'git-action-row';
+  panel.appendChild(row);
+  const showCodes = codes => {
+    row.innerHTML = '';
+    const list = document.createElement('div');
+    list.className = 'review-item';
+    list.innerHTML = '<span>Save these recovery codes: each signs you in once if you lose your device.</span>' +
+      `<pre>${escapeHTML(codes.join('\n'))}</pre>`;
+    list.appendChild(diffActionButton('Done', reload));
+    row.appendChild(list);
+  };
+  // codeRequest sends a code to one of the /api/me/totp routes and returns
+  // the response body, or null on failure.
+  const codeRequest = async (url, method, code) => {
+    const res = await authFetch(url, {
+      method,
+      headers: { 'Content-Type': 'application/json' },
+      body: JSON.stringify({ code }),
+    });
+    if (!res) return null;
+    if (!res.ok) { alert(`Failed: ${(await res.text()).trim()}`); return null; }
+    return res.status === 204 ? {} : res.json();
+  };
+
+  if (!me.totp_enabled) {
+    row.appendChild(diffActionButton('Set up', async () => {
+      const res = await authFetch('/api/me/totp', { method: 'POST' });
+      if (!res || !res.ok) { alert(`Failed: ${res ? (await res.text()).trim() : ''}`); return; }
+      const { secret, uri } = await res.json();
+      row.innerHTML = '';
+      const info = document.createElement('div');
+      info.className = 'review-item';
+      info.innerHTML = '<span>Add this account to your authenticator app (<a>open in app</a>) ' +
+        `or enter the secret <code>${escapeHTML(secret)}</code>, then type the code it shows.</span>`;
+      info.querySelector('a').href = uri;
+      row.appendChild(info);
+      const codeInput = document.createElement('input');
+      codeInput.className = 'form-input compare-select';
+      codeInput.placeholder = 'code';
+      codeInput.autocomplete = 'one-time-code';
+      codeInput.inputMode = 'numeric';
+      row.appendChild(codeInput);
+      row.appendChild(diffActionButton('Enable', async () => {
+        const data = await codeRequest('/api/me/totp/enable', 'POST', codeInput.value.trim());
+        if (data) showCodes(data.recovery_codes);
+      }));
+      codeInput.focus();
+    }));
+    return;
+  }
+  row.appendChild(diffActionButton('New recovery codes', async () => {
+    const code = prompt('Code from your authenticator app:');
+    if (!code) return;
+    const data = await codeRequest('/api/me/totp/recovery', 'POST', code.trim());
+    if (data) showCodes(data.recovery_codes);
+  }));
+  if (!me.totp_required) {
+    row.appendChild(diffActionButton('Turn off', async () => {
+      const code = prompt('Code from your authenticator app or a recovery code:');
+      if (code && await codeRequest('/api/me/totp', 'DELETE', code.trim())) reload();
+    }));
+  }
+}
+
+// loginsRow lists an account's active logins with buttons to revoke them.
+function loginsRow(a, reload) {
+  const tr = document.createElement('tr');
+  const td = document.createElement('td');
+  td.colSpan = 7;
+  td.className = 'review-panel';
+  tr.appendChild(td);
+  authFetch(`/api/accounts/${a.id}/logins`).then(async res => {
+    const { logins } = res && res.ok ? await res.json() : { logins: [] };
+    if (!logins.length) {
+      td.innerHTML = '<div class="compare-muted">No active logins.</div>';
+      return;
+    }
+    logins.forEach(l => {
+      const item = document.createElement('div');
+      item.className = 'review-item';
+      item.innerHTML = `<span>${escapeHTML(l.user_agent || 'unknown client')}</span>` +
+        `<span class="compare-muted">${escapeHTML(l.ip)} · signed in ${formatDateTime(l.login_at)} · last used ${formatDateTime(l.last_used)}</span>`;
+      item.appendChild(diffActionButton('Revoke', async () => {
+        if (await accountRequest(`/api/accounts/${a.id}/logins/${l.id}`, 'DELETE')) reload();
+      }));
+      td.appendChild(item);
+    });
+    td.appendChild(diffActionButton('Revoke all', async () => {
+      if (await accountRequest(`/api/accounts/${a.id}/logins`, 'DELETE')) reload();
+    }));
+  });
+  return tr;
+}
+
 // --- Detail panel ---
 function rebuildDetail() {
   const emptyEl  = document.getElementById('detail-empty');
   const contentEl = document.getElementById('detail-content');
 
+  if (settingsOpen && !selectedSessionID) {
+    emptyEl.style.display = 'none';
+    contentEl.style.display = 'flex';
+    contentEl.innerHTML = '';
+    renderSettings(contentEl);
+    return;
+  }
+
   if (!selectedSessionID) {
     emptyEl.style.display = '';
     contentEl.style.display = 'none';
@@ -435,8 +1825,11 @@ function rebuildDetail() {
   const tabBar = document.createElement('div');
   tabBar.className = 'tab-bar';
   const tabs = s.TmuxSession
-    ? ['terminal', 'git', 'notes', 'activity']
-    : ['git', 'notes', 'activity'];
+    ? ['terminal', 'git', 'history', 'notes', 'activity']
+    : ['git', 'history', 'notes', 'activity'];
+  if (s.WorktreeBranch) tabs.splice(tabs.indexOf('history') + 1, 0, 'compare');
+  if (s.ProjectPath || s.WorktreePath) tabs.splice(tabs.indexOf('history') + 1, 0, 'checkpoints');
+  if (s.TmuxSession && canOperate(s.GroupPath)) tabs.push('share');
   tabs.forEach(t => {
     const btn = document.createElement('button');
     btn.className = 'tab-btn' + (t === tab ? ' active' : '');
@@ -471,6 +1864,12 @@ function buildDetailHeader(s) {
 
   titleGroup.appendChild(nameEl);
   titleGroup.appendChild(badge);
+  if (s.Owner) {
+    const owner = document.createElement('span');
+    owner.className = 'compare-muted';
+    owner.textContent = `by ${s.Owner}`;
+    titleGroup.appendChild(owner);
+  }
 
   const actions = document.createElement('div');
   actions.className = 'detail-actions';
@@ -483,17 +1882,23 @@ function buildDetailHeader(s) {
     return btn;
   };
 
+  if (!canOperate(s.GroupPath)) {
+    header.appendChild(titleGroup);
+    return header;
+  }
   if (s.Status !== 'stopped') {
     actions.appendChild(mkBtn('Stop', false, () => {
       if (confirm(`Stop "${s.Title}"?`)) apiAction(`/api/sessions/${s.ID}/stop`, 'POST');
     }));
   }
+  if (s.WorktreePath) {
+    actions.appendChild(mkBtn('Fork', false, () => forkSession(s)));
+  }
   actions.appendChild(mkBtn('Restart', false, () => {
     if (confirm(`Restart "${s.Title}"?`)) apiAction(`/api/sessions/${s.ID}/restart`, 'POST');
   }));
   actions.appendChild(mkBtn('Delete', true, () => {
     if (confirm(`Delete "${s.Title}"?`)) {
-      authFetch(`/api/sessions/${s.ID}/ttyd`, { method: 'DELETE' }).catch(() => {});
       delete savedIframes[s.ID];
       selectedSessionID = null;
       renderedDetailID = null;
@@ -522,8 +1927,10 @@ function renderTabContent(s, tab, container) {
       termContainer.appendChild(savedIframes[s.ID]);
     } else {
       const iframe = document.createElement('iframe');
-      const tok = getAccessToken();
-      iframe.src = `/terminal/${s.ID}/` + (tok ? `?token=${encodeURIComponent(tok)}` : '');
+      // The terminal page trades the ticket for a cookie scoped to it.
+      fetchTicket().then(ticket => {
+        iframe.src = `/terminal/${s.ID}/` + (ticket ? `?ticket=${encodeURIComponent(ticket)}` : '');
+      });
       iframe.className = 'terminal-iframe';
       termContainer.appendChild(iframe);
       savedIframes[s.ID] = iframe;
@@ -566,6 +1973,9 @@ function renderTabContent(s, tab, container) {
     panel.appendChild(actionRow);
 
     if (s.ProjectPath || s.WorktreePath) {
+      const reviewEl = document.createElement('div');
+      panel.appendChild(reviewEl);
+
       // Status section
       const statusLabel = document.createElement('div');
       statusLabel.className = 'git-section-label';
@@ -583,17 +1993,16 @@ function renderTabContent(s, tab, container) {
       diffLabel.textContent = 'Diff';
       panel.appendChild(diffLabel);
 
-      const diffPre = document.createElement('pre');
-      diffPre.className = 'git-output';
-      diffPre.textContent = 'loading...';
-      panel.appendChild(diffPre);
+      const diffView = document.createElement('div');
+      diffView.className = 'diff-viewer';
+      panel.appendChild(diffView);
 
       const loadGit = async () => {
         statusPre.textContent = 'loading...';
-        diffPre.textContent = 'loading...';
-        const [statusRes, diffRes, prRes] = await Promise.all([
+        renderReviewPanel(s, reviewEl, loadGit);
+        renderDiffViewer(s, diffView, loadGit);
+        const [statusRes, prRes] = await Promise.all([
           authFetch(`/api/sessions/${s.ID}/git/status/text`),
-          authFetch(`/api/sessions/${s.ID}/git/diff/text`),
           authFetch(`/api/sessions/${s.ID}/pr-url`),
         ]);
         if (statusRes && statusRes.ok) {
@@ -602,24 +2011,18 @@ function renderTabContent(s, tab, container) {
         } else {
           statusPre.textContent = '(error fetching status)';
         }
-        if (diffRes && diffRes.ok) {
-          const { output } = await diffRes.json();
-          diffPre.innerHTML = output ? colorDiffLines(output) : '(no diff)';
-        } else {
-          diffPre.textContent = '(error fetching diff)';
-        }
         // Show View PR button only if a PR exists.
-        const existingPrBtn = actionRow.querySelector('.pr-btn');
-        if (existingPrBtn) existingPrBtn.remove();
+        actionRow.querySelectorAll('.pr-btn').forEach(b => b.remove());
         if (prRes && prRes.ok) {
-          const { url } = await prRes.json();
-          if (url) {
+          // Multi-repo sessions may have one PR per repo.
+          const { url, prs } = await prRes.json();
+          (prs || (url ? [{ url }] : [])).forEach(pr => {
             const prBtn = document.createElement('button');
             prBtn.className = 'git-btn pr-btn';
-            prBtn.textContent = 'View PR';
-            prBtn.onclick = () => window.open(url, '_blank');
+            prBtn.textContent = pr.repo ? `View PR (${pr.repo})` : 'View PR';
+            prBtn.onclick = () => window.open(pr.url, '_blank');
             actionRow.appendChild(prBtn);
-          }
+          });
         }
       };
 
@@ -637,6 +2040,30 @@ function renderTabContent(s, tab, container) {
     return;
   }
 
+  if (tab === 'history') {
+    if (!s.ProjectPath && !s.WorktreePath) {
+      container.innerHTML = '<div style="padding:20px;color:var(--muted);font-size:12px">No git working directory for this session.</div>';
+      return;
+    }
+    renderHistory(s, container);
+    return;
+  }
+
+  if (tab === 'checkpoints') {
+    renderCheckpoints(s, container);
+    return;
+  }
+
+  if (tab === 'compare') {
+    renderCompare(s, container);
+    return;
+  }
+
+  if (tab === 'share') {
+    renderShares(s, container);
+    return;
+  }
+
   if (tab === 'notes') {
     const panel = document.createElement('div');
     panel.className = 'notes-panel';
@@ -644,12 +2071,13 @@ function renderTabContent(s, tab, container) {
     textarea.className = 'notes-area';
     textarea.value = s.Notes || '';
     textarea.placeholder = 'Notes...';
+    textarea.readOnly = !canOperate(s.GroupPath);
     const saveBtn = document.createElement('button');
     saveBtn.className = 'save-btn';
     saveBtn.textContent = 'Save notes';
     saveBtn.onclick = () => saveNotes(s.ID, textarea.value);
     panel.appendChild(textarea);
-    panel.appendChild(saveBtn);
+    if (!textarea.readOnly) panel.appendChild(saveBtn);
     container.appendChild(panel);
     return;
   }
@@ -691,52 +2119,134 @@ function updateDetailStatus() {
 }
 
 // --- SSE ---
-function connectSSE() {
-  const token = getAccessToken();
-  if (!token) { window.location.href = '/login'; return; }
-  const es = new EventSource('/events?token=' + encodeURIComponent(token));
+
+// upsertSession adds or replaces a session in the state.
+function upsertSession(sess) {
+  const i = state.sessions.findIndex(s => s.ID === sess.ID);
+  if (i >= 0) state.sessions[i] = sess;
+  else state.sessions.push(sess);
+}
+
+// applyEvent applies one event from the stream to the state. A snapshot
+// means events were missed and the state is fetched again.
+function applyEvent(evt) {
+  const s = state.sessions.find(s => s.ID === evt.session_id);
+  switch (evt.type) {
+  case 'heartbeat':
+    return;
+  case 'snapshot':
+  case 'refresh':
+    fetchSessions();
+    return;
+  case 'session_created':
+  case 'session_updated':
+    if (evt.session.Status !== 'creating') delete provisioning[evt.session.ID];
+    upsertSession(evt.session);
+    if (s && s.Status !== evt.session.Status) delete savedIframes[s.ID];
+    if (evt.session.ID === selectedSessionID && s && s.Status !== evt.session.Status) {
+      renderedDetailID = null; // rebuild so the terminal matches the new state
+    }
+    render();
+    return;
+  case 'session_deleted':
+    state.sessions = state.sessions.filter(s => s.ID !== evt.session_id);
+    delete provisioning[evt.session_id];
+    delete savedIframes[evt.session_id];
+    if (selectedSessionID === evt.session_id) selectedSessionID = null;
+    render();
+    return;
+  case 'provisioning_progress':
+    if (evt.step === 'ready') delete provisioning[evt.session_id];
+    else provisioning[evt.session_id] = evt.step === 'failed' ? 'failed' : evt.step.replace('_', '-') + '…';
+    if (evt.step === 'failed' && evt.message) console.warn(`Session ${evt.session_id} failed: ${evt.message}`);
+    else if (evt.step === 'worktree' && evt.message) console.warn(`Session ${evt.session_id}: ${evt.message}`);
+    renderSidebar();
+    return;
+  case 'git_dirty_changed':
+    if (!s) return;
+    s.HasUncommitted = evt.dirty;
+    renderSidebar();
+    return;
+  case 'notes_updated': {
+    if (!s) return;
+    s.Notes = evt.notes || '';
+    const area = evt.session_id === selectedSessionID && document.querySelector('.notes-area');
+    if (area && document.activeElement !== area) area.value = s.Notes;
+    return;
+  }
+  case 'usage_updated':
+  case 'budget_updated': {
+    usageData = { ...usageData, ...(evt.usage ? { latest: evt.usage } : { budget: evt.budget }) };
+    const widget = document.getElementById('usage-widget');
+    if (widget) renderUsage(usageData, widget);
+    return;
+  }
+  case 'status_changed':
+    if (!s) { fetchSessions(); return; }
+    s.Status = evt.status;
+    // If session stopped/errored, evict cached iframe so we don't hammer a dead session.
+    if (evt.status === 'stopped' || evt.status === 'error') {
+      delete savedIframes[evt.session_id];
+    }
+    if (evt.session_id !== selectedSessionID) {
+      // Update sidebar dot in-place.
+      const row = document.querySelector(`.session-row[data-session-id="${evt.session_id}"]`);
+      if (row) {
+        const icon = STATUS_ICONS[evt.status] || STATUS_ICONS.idle;
+        const dot = row.querySelector('.status-dot');
+        if (dot) { dot.className = `status-dot ${icon.cls}`; dot.textContent = icon.char; }
+      }
+      return;
+    }
+    // The selected session: update the detail header in-place too.
+    updateDetailStatus();
+    // Rebuild terminal tab if it's currently visible so the stopped message shows.
+    if (evt.status === 'stopped' || evt.status === 'error') {
+      const activeTab = document.querySelector('.tab-btn.active');
+      if (activeTab && activeTab.dataset.tab === 'terminal') rebuildDetail();
+    }
+    return;
+  case 'tool_use': {
+    // Show the tool Claude is calling as the sidebar row's tooltip.
+    const row = document.querySelector(`.session-row[data-session-id="${evt.session_id}"]`);
+    if (row) row.title = evt.message ? `${evt.tool}: ${evt.message}` : evt.tool;
+    return;
+  }
+  default:
+    fetchSessions();
+  }
+}
+async function connectSSE() {
+  const ticket = await fetchTicket();
+  if (!ticket) {
+    setTimeout(connectSSE, sseRetryDelay);
+    sseRetryDelay = Math.min(sseRetryDelay * 2, 30000);
+    return;
+  }
+  let url = '/events?ticket=' + encodeURIComponent(ticket);
+  if (lastEventID) url += '&since=' + encodeURIComponent(lastEventID);
+  const es = new EventSource(url);
   const dot = document.getElementById('connection-status');
 
   es.onopen = () => { dot.className = 'conn-dot connected'; sseRetryDelay = 1000; };
 
+  // The server sends a heartbeat every 15s; treat a quiet minute as a dead
+  // connection even if the browser has not noticed.
+  let watchdog;
+  const alive = () => {
+    clearTimeout(watchdog);
+    watchdog = setTimeout(() => es.onerror(), 60000);
+  };
+  alive();
+
   es.onmessage = (e) => {
-    const evt = JSON.parse(e.data);
-    if (evt.type === 'snapshot' || evt.type === 'refresh') {
-      fetchSessions();
-    } else if (evt.type === 'status_changed') {
-      const s = state.sessions.find(s => s.ID === evt.session_id);
-      if (s) {
-        s.Status = evt.status;
-        // Update sidebar dot in-place.
-        const row = document.querySelector(`.session-row[data-session-id="${evt.session_id}"]`);
-        if (row) {
-          const icon = STATUS_ICONS[evt.status] || STATUS_ICONS.idle;
-          const dot = row.querySelector('.status-dot');
-          if (dot) { dot.className = `status-dot ${icon.cls}`; dot.textContent = icon.char; }
-        }
-        // If session stopped/errored, evict cached iframe so we don't hammer a dead session.
-        if (evt.status === 'stopped' || evt.status === 'error') {
-          authFetch(`/api/sessions/${evt.session_id}/ttyd`, { method: 'DELETE' }).catch(() => {});
-          delete savedIframes[evt.session_id];
-        }
-        // If this is the selected session, update the detail header in-place too.
-        if (evt.session_id === selectedSessionID) {
-          updateDetailStatus();
-          // Rebuild terminal tab if it's currently visible so the stopped message shows.
-          if (evt.status === 'stopped' || evt.status === 'error') {
-            const activeTab = document.querySelector('.tab-btn.active');
-            if (activeTab && activeTab.dataset.tab === 'terminal') rebuildDetail();
-          }
-        }
-      } else {
-        fetchSessions();
-      }
-    } else {
-      fetchSessions();
-    }
+    alive();
+    if (e.lastEventId) lastEventID = e.lastEventId;
+    applyEvent(JSON.parse(e.data));
   };
 
   es.onerror = () => {
+    clearTimeout(watchdog);
     dot.className = 'conn-dot';
     es.close();
     refreshAccessToken().then(ok => {
@@ -761,8 +2271,14 @@ document.addEventListener('DOMContentLoaded', async () => {
   const backBtn = document.getElementById('back-btn');
   if (backBtn) backBtn.onclick = () => { mobileShowDetail = false; render(); };
 
+  await fetchMe();
+  // Account settings need authentication to be on.
+  const settingsBtn = document.getElementById('settings-btn');
+  if (settingsBtn && me.username) {
+    settingsBtn.style.display = '';
+    settingsBtn.onclick = openSettings;
+  }
   fetchSessions();
   connectSSE();
   fetchUsage();
-  setInterval(fetchUsage, 5 * 60 * 1000);
 });
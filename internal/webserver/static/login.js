@@ -0,0 +1,62 @@
+async function login() {
+  const username = document.getElementById('username').value.trim();
+  const password = document.getElementById('password').value;
+  const codeEl = document.getElementById('code');
+  const code = codeEl.value.trim();
+  const errEl = document.getElementById('error');
+  errEl.textContent = '';
+  const res = await fetch('/api/auth/login', {
+    method: 'POST',
+    headers: { 'Content-Type': 'application/json' },
+    body: JSON.stringify({ username, password, code }),
+  });
+  if (!res.ok) {
+    // A second factor is asked for with a JSON error.
+    const data = res.status === 401 ? await res.json().catch(() => ({})) : {};
+    if (data.error === 'totp_required' || data.error === 'totp_setup_required' || data.error === 'invalid_code') {
+      codeEl.style.display = '';
+      if (data.secret) {
+        document.getElementById('setup').style.display = '';
+        document.getElementById('setup-secret').textContent = data.secret;
+        document.getElementById('setup-uri').href = data.uri;
+      }
+      if (data.error === 'invalid_code') errEl.textContent = 'Invalid code.';
+      codeEl.value = '';
+      codeEl.focus();
+      return;
+    }
+    if (res.status === 429) {
+      errEl.textContent = (await res.text()).trim();
+      return;
+    }
+    errEl.textContent = res.status === 401 ? 'Invalid username or password.' : 'Login failed.';
+    return;
+  }
+  const { access_token, refresh_token, recovery_codes } = await res.json();
+  sessionStorage.setItem('access_token', access_token);
+  localStorage.setItem('refresh_token', refresh_token);
+  if (recovery_codes) {
+    // Enrolled as part of this login: show the codes before moving on.
+    document.getElementById('setup').style.display = 'none';
+    document.getElementById('submit').style.display = 'none';
+    codeEl.style.display = 'none';
+    const list = document.getElementById('recovery-codes');
+    list.innerHTML = '';
+    for (const c of recovery_codes) {
+      const div = document.createElement('div');
+      div.textContent = c;
+      list.appendChild(div);
+    }
+    document.getElementById('recovery').style.display = '';
+    return;
+  }
+  window.location.href = '/';
+}
+
+document.getElementById('submit').onclick = login;
+document.getElementById('continue').onclick = () => { window.location.href = '/'; };
+for (const id of ['password', 'code']) {
+  document.getElementById(id).addEventListener('keydown', e => {
+    if (e.key === 'Enter') login();
+  });
+}
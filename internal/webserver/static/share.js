@@ -0,0 +1,58 @@
+// Share viewer page: shows the frames of a shared session's pane from the
+// link's /stream endpoint (see share.go). Viewers cannot type.
+(function () {
+  if (typeof Terminal === 'undefined') {
+    const status = document.getElementById('status');
+    status.textContent = 'xterm.js is missing from this build. Run make vendor-xterm and rebuild.';
+    status.className = 'ended';
+    return;
+  }
+  const term = new Terminal({
+    disableStdin: true,
+    cursorBlink: false,
+    convertEol: true,
+    fontFamily: "'JetBrains Mono', Menlo, Consolas, monospace",
+    fontSize: 13,
+    scrollback: 0,
+    theme: { background: '#0d1117' },
+  });
+  term.open(document.getElementById('terminal'));
+
+  const status = document.getElementById('status');
+  const base = location.pathname.endsWith('/') ? location.pathname : location.pathname + '/';
+  const es = new EventSource(base + 'stream');
+
+  es.addEventListener('info', e => {
+    const info = JSON.parse(e.data);
+    if (info.title) {
+      document.getElementById('title').textContent = info.title;
+      document.title = info.title + ' – agent-workspace';
+    }
+    document.getElementById('expires').textContent =
+      'link expires ' + new Date(info.expires_at).toLocaleString();
+    status.textContent = 'live';
+  });
+  es.onmessage = e => {
+    const frame = JSON.parse(e.data);
+    if (frame.cols > 0 && frame.rows > 0 && (frame.cols !== term.cols || frame.rows !== term.rows)) {
+      term.resize(frame.cols, frame.rows);
+    }
+    // Each frame is the whole visible pane: clear, home, redraw.
+    term.write('\x1b[2J\x1b[H' + frame.content.replace(/\n$/, ''));
+  };
+  es.addEventListener('end', e => {
+    es.close();
+    status.textContent = JSON.parse(e.data);
+    status.className = 'ended';
+  });
+  es.onerror = () => {
+    // EventSource retries dropped connections itself; a link that stopped
+    // working answers 404 and ends the stream for good.
+    if (es.readyState === EventSource.CLOSED) {
+      status.textContent = 'The link has expired or was revoked.';
+      status.className = 'ended';
+    } else {
+      status.textContent = 'reconnecting…';
+    }
+  };
+})();
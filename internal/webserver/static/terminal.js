@@ -0,0 +1,56 @@
+// Terminal page: connects xterm.js to the session's /ws endpoint. Output
+// arrives as binary messages; input and size changes go out as JSON text
+// messages (see terminal.go).
+(function () {
+  if (typeof Terminal === 'undefined') {
+    const el = document.getElementById('terminal');
+    el.style.color = '#f85149';
+    el.textContent = 'xterm.js is missing from this build. Run make vendor-xterm and rebuild.';
+    return;
+  }
+  const term = new Terminal({
+    cursorBlink: true,
+    fontFamily: "'JetBrains Mono', Menlo, Consolas, monospace",
+    fontSize: 13,
+    theme: { background: '#0d1117' },
+  });
+  const fit = new FitAddon.FitAddon();
+  term.loadAddon(fit);
+  term.open(document.getElementById('terminal'));
+  fit.fit();
+
+  const base = location.pathname.endsWith('/') ? location.pathname : location.pathname + '/';
+  const url = `${location.protocol === 'https:' ? 'wss:' : 'ws:'}//${location.host}${base}ws`;
+  let ws = null;
+  let retryDelay = 1000;
+  let failures = 0;
+
+  const send = msg => {
+    if (ws && ws.readyState === WebSocket.OPEN) ws.send(JSON.stringify(msg));
+  };
+  const sendSize = () => send({ type: 'resize', cols: term.cols, rows: term.rows });
+
+  function connect() {
+    ws = new WebSocket(url);
+    ws.binaryType = 'arraybuffer';
+    ws.onopen = () => {
+      retryDelay = 1000;
+      failures = 0;
+      fit.fit();
+      sendSize();
+    };
+    ws.onmessage = e => term.write(new Uint8Array(e.data));
+    ws.onclose = e => {
+      // A normal close means tmux went away; anything else is the network.
+      if (e.code === 1000) { term.write('\r\n[terminal closed]\r\n'); return; }
+      if (++failures > 5) { term.write('\r\n[disconnected]\r\n'); return; }
+      setTimeout(connect, retryDelay);
+      retryDelay = Math.min(retryDelay * 2, 30000);
+    };
+  }
+
+  term.onData(data => send({ type: 'input', data }));
+  term.onResize(sendSize);
+  window.addEventListener('resize', () => fit.fit());
+  connect();
+})();
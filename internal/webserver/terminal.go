@@ -0,0 +1,253 @@
+package webserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os/exec"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/creack/pty"
+	"github.com/gorilla/websocket"
+
+	"github.com/zsprackett/agent-workspace/internal/audit"
+	"github.com/zsprackett/agent-workspace/internal/db"
+)
+
+// The terminal websocket protocol: the server sends terminal output as
+// binary messages; the client sends text messages holding one JSON
+// terminalMessage each. The server closes the socket when tmux detaches or
+// the session ends.
+type terminalMessage struct {
+	Type string `json:"type"` // "input" or "resize"
+	Data string `json:"data,omitempty"`
+	Cols uint16 `json:"cols,omitempty"`
+	Rows uint16 `json:"rows,omitempty"`
+}
+
+const (
+	terminalPingInterval = 30 * time.Second
+	terminalWriteTimeout = 10 * time.Second
+)
+
+var terminalUpgrader = websocket.Upgrader{
+	ReadBufferSize:  4096,
+	WriteBufferSize: 32 * 1024,
+	CheckOrigin:     sameOrigin,
+}
+
+// terminalManager tracks the open terminal connections of each session so
+// they can be closed when the session goes away. Every viewer has its own
+// tmux client, so any number can watch a session at once.
+type terminalManager struct {
+	mu    sync.Mutex
+	conns map[string]map[*websocket.Conn]struct{}
+}
+
+func newTerminalManager() *terminalManager {
+	return &terminalManager{conns: make(map[string]map[*websocket.Conn]struct{})}
+}
+
+func (m *terminalManager) add(sessionID string, conn *websocket.Conn) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.conns[sessionID] == nil {
+		m.conns[sessionID] = make(map[*websocket.Conn]struct{})
+	}
+	m.conns[sessionID][conn] = struct{}{}
+}
+
+func (m *terminalManager) remove(sessionID string, conn *websocket.Conn) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.conns[sessionID], conn)
+	if len(m.conns[sessionID]) == 0 {
+		delete(m.conns, sessionID)
+	}
+}
+
+// count returns how many terminals are connected.
+func (m *terminalManager) count() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n := 0
+	for _, conns := range m.conns {
+		n += len(conns)
+	}
+	return n
+}
+
+// close disconnects every terminal of the session.
+func (m *terminalManager) close(sessionID string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for conn := range m.conns[sessionID] {
+		conn.Close()
+	}
+}
+
+// handleTerminalPage serves the terminal page the UI embeds for a session.
+func (s *Server) handleTerminalPage(w http.ResponseWriter, r *http.Request) {
+	if s.terminalSession(w, r) == nil {
+		return
+	}
+	http.ServeFileFS(w, r, staticFS, "static/terminal.html")
+}
+
+// terminalSession returns the session of a terminal request, or writes a 404
+// and returns nil if it has no tmux session to attach to.
+func (s *Server) terminalSession(w http.ResponseWriter, r *http.Request) *db.Session {
+	sess, err := s.store.GetSession(r.PathValue("id"))
+	if err != nil || sess == nil || sess.TmuxSession == "" {
+		http.Error(w, "session not found", 404)
+		return nil
+	}
+	return sess
+}
+
+// handleTerminalWS attaches a tmux client for the session in a PTY and
+// relays it over a websocket. Viewers attach read-only and their input and
+// resizes are dropped, so they never change what others see.
+func (s *Server) handleTerminalWS(w http.ResponseWriter, r *http.Request) {
+	sess := s.terminalSession(w, r)
+	if sess == nil {
+		return
+	}
+	id := sess.ID
+	if !websocket.IsWebSocketUpgrade(r) {
+		http.Error(w, "websocket required", 400)
+		return
+	}
+	writable := requestRole(r, sess.GroupPath).Allows(db.RoleOperator)
+	detail := sess.Title + " (read-only)"
+	if writable {
+		detail = sess.Title + " (writable)"
+	}
+
+	// Enable mouse mode so scroll works in the web terminal.
+	exec.Command("tmux", "set-option", "-t", sess.TmuxSession, "mouse", "on").Run()
+	args := []string{"attach-session", "-t", sess.TmuxSession}
+	if !writable {
+		args = append(args, "-r", "-f", "ignore-size")
+	}
+	cmd := exec.Command("tmux", args...)
+	cmd.Env = append(cmd.Environ(), "TERM=xterm-256color")
+	size := &pty.Winsize{Cols: 80, Rows: 24}
+	if !writable {
+		size = viewerSize(sess.TmuxSession, size)
+	}
+	ptmx, err := pty.StartWithSize(cmd, size)
+	s.recordAudit(r, &db.AuditEntry{Action: audit.TerminalOpen, Target: id, Detail: detail, Result: audit.Result(err)})
+	if err != nil {
+		http.Error(w, "start terminal: "+err.Error(), 503)
+		return
+	}
+	defer func() {
+		ptmx.Close()
+		cmd.Process.Kill()
+		cmd.Wait()
+	}()
+
+	conn, err := terminalUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	s.terminals.add(id, conn)
+	defer s.terminals.remove(id, conn)
+	defer conn.Close()
+
+	var writeMu sync.Mutex
+	write := func(typ int, data []byte) error {
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		conn.SetWriteDeadline(time.Now().Add(terminalWriteTimeout))
+		return conn.WriteMessage(typ, data)
+	}
+
+	// PTY output to the client; when tmux exits, say why and hang up.
+	go func() {
+		buf := make([]byte, 32*1024)
+		for {
+			n, err := ptmx.Read(buf)
+			if n > 0 && write(websocket.BinaryMessage, buf[:n]) != nil {
+				break
+			}
+			if err != nil {
+				write(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, "terminal closed"))
+				break
+			}
+		}
+		conn.Close()
+	}()
+
+	// Keep idle connections alive through proxies.
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		ticker := time.NewTicker(terminalPingInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if write(websocket.PingMessage, nil) != nil {
+					return
+				}
+			}
+		}
+	}()
+
+	// Client messages to the PTY, until either side hangs up.
+	for {
+		typ, data, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		if typ != websocket.TextMessage {
+			continue
+		}
+		var msg terminalMessage
+		if json.Unmarshal(data, &msg) != nil {
+			continue
+		}
+		switch msg.Type {
+		case "input":
+			if writable {
+				ptmx.Write([]byte(msg.Data))
+			}
+		case "resize":
+			if writable && msg.Cols > 0 && msg.Rows > 0 {
+				pty.Setsize(ptmx, &pty.Winsize{Cols: msg.Cols, Rows: msg.Rows})
+			}
+		}
+	}
+}
+
+// viewerSize returns the terminal size at which a client shows the window of
+// target at its current size, so attaching does not resize it even when no
+// other client is attached and tmux falls back to the viewer's size.
+func viewerSize(target string, fallback *pty.Winsize) *pty.Winsize {
+	out, err := exec.Command("tmux", "display-message", "-p", "-t", target,
+		"#{window_width} #{window_height} #{status}").Output()
+	if err != nil {
+		return fallback
+	}
+	var cols, rows uint16
+	var status string
+	if _, err := fmt.Sscan(string(out), &cols, &rows, &status); err != nil || cols == 0 || rows == 0 {
+		return fallback
+	}
+	// The status option is "off", "on" (one line) or a number of lines.
+	switch status {
+	case "off":
+	case "on":
+		rows++
+	default:
+		n, _ := strconv.Atoi(status)
+		rows += uint16(n)
+	}
+	return &pty.Winsize{Cols: cols, Rows: rows}
+}
@@ -0,0 +1,134 @@
+package webserver_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/zsprackett/agent-workspace/internal/db"
+	"github.com/zsprackett/agent-workspace/internal/webserver"
+)
+
+func TestTerminal(t *testing.T) {
+	if _, err := exec.LookPath("tmux"); err != nil {
+		t.Skip("tmux not installed")
+	}
+	name := fmt.Sprintf("aw-test-%d", time.Now().UnixNano())
+	if out, err := exec.Command("tmux", "new-session", "-d", "-s", name, "-x", "80", "-y", "24", "sh").CombinedOutput(); err != nil {
+		t.Skipf("tmux new-session: %v %s", err, out)
+	}
+	t.Cleanup(func() { exec.Command("tmux", "kill-session", "-t", name).Run() })
+
+	srv, store := newServer(t)
+	now := time.Now()
+	store.SaveSession(&db.Session{ID: "term-id", Title: "term", GroupPath: "my-sessions", Tool: db.ToolShell,
+		Status: db.StatusRunning, TmuxSession: name, CreatedAt: now, LastAccessed: now})
+	store.SaveSession(&db.Session{ID: "no-tmux", Title: "stopped", GroupPath: "my-sessions", Tool: db.ToolShell,
+		Status: db.StatusStopped, CreatedAt: now, LastAccessed: now})
+	ts := httptest.NewServer(srv.Handler())
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/terminal/term-id/")
+	if err != nil || resp.StatusCode != 200 {
+		t.Fatalf("terminal page: %v %v", err, resp)
+	}
+	resp.Body.Close()
+	resp, _ = http.Get(ts.URL + "/terminal/no-tmux/ws")
+	if resp.StatusCode != 404 {
+		t.Errorf("session without tmux: expected 404, got %d", resp.StatusCode)
+	}
+	resp.Body.Close()
+
+	wsURL := "ws" + strings.TrimPrefix(ts.URL, "http") + "/terminal/term-id/ws"
+	dial := func() *websocket.Conn {
+		conn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+		if err != nil {
+			t.Fatalf("dial: %v", err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		conn.WriteJSON(map[string]any{"type": "resize", "cols": 100, "rows": 30})
+		return conn
+	}
+	// waitFor reads output until it contains want.
+	waitFor := func(conn *websocket.Conn, want string) {
+		t.Helper()
+		var out strings.Builder
+		conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+		for !strings.Contains(out.String(), want) {
+			typ, data, err := conn.ReadMessage()
+			if err != nil {
+				t.Fatalf("waiting for %q: %v (got %q)", want, err, out.String())
+			}
+			if typ == websocket.BinaryMessage {
+				out.Write(data)
+			}
+		}
+	}
+
+	// Two viewers at once both see what one of them types.
+	first, second := dial(), dial()
+	first.WriteJSON(map[string]any{"type": "input", "data": "echo hello-$((6*7))\r"})
+	waitFor(first, "hello-42")
+	waitFor(second, "hello-42")
+
+	// Ending the tmux session hangs up its terminals.
+	exec.Command("tmux", "kill-session", "-t", name).Run()
+	first.SetReadDeadline(time.Now().Add(10 * time.Second))
+	for {
+		if _, _, err := first.ReadMessage(); err != nil {
+			if !websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+				t.Errorf("expected a normal close, got %v", err)
+			}
+			break
+		}
+	}
+}
+
+func TestTerminalViewerDoesNotResize(t *testing.T) {
+	if _, err := exec.LookPath("tmux"); err != nil {
+		t.Skip("tmux not installed")
+	}
+	name := fmt.Sprintf("aw-test-%d", time.Now().UnixNano())
+	if out, err := exec.Command("tmux", "new-session", "-d", "-s", name, "-x", "80", "-y", "24", "sh").CombinedOutput(); err != nil {
+		t.Skipf("tmux new-session: %v %s", err, out)
+	}
+	t.Cleanup(func() { exec.Command("tmux", "kill-session", "-t", name).Run() })
+
+	srv, store := newAuthServer(t)
+	now := time.Now()
+	store.SaveSession(&db.Session{ID: "term-id", Title: "term", GroupPath: "my-sessions", Tool: db.ToolShell,
+		Status: db.StatusRunning, TmuxSession: name, CreatedAt: now, LastAccessed: now})
+	ts := httptest.NewServer(srv.Handler())
+	defer ts.Close()
+
+	store.CreateAccount("bob", "x", db.RoleViewer)
+	token, _ := webserver.IssueAccessToken("test-secret", webserver.Principal{Username: "bob", Role: db.RoleViewer}, time.Hour)
+	wsURL := "ws" + strings.TrimPrefix(ts.URL, "http") + "/terminal/term-id/ws"
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL, http.Header{"Authorization": {"Bearer " + token}})
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	conn.WriteJSON(map[string]any{"type": "resize", "cols": 200, "rows": 60})
+
+	// Wait for the viewer's client to attach, then check the window kept its size.
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		out, _ := exec.Command("tmux", "list-clients", "-t", name).Output()
+		if len(out) > 0 || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	time.Sleep(300 * time.Millisecond)
+	out, _ := exec.Command("tmux", "display-message", "-p", "-t", name, "#{window_width}x#{window_height}").Output()
+	if got := strings.TrimSpace(string(out)); got != "80x24" {
+		t.Errorf("viewer resized the window to %s", got)
+	}
+}
@@ -0,0 +1,81 @@
+package webserver
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// loginBackoffBase is the wait after the last free failed login; it
+	// doubles with every further failure up to loginLockout.
+	loginBackoffBase = time.Second
+	// loginLockout is the longest wait, reached after about ten failures
+	// past the free ones.
+	loginLockout = 15 * time.Minute
+	// loginFailureMemory is how long failures are remembered without a new
+	// one.
+	loginFailureMemory = time.Hour
+)
+
+// loginThrottle slows down password guessing: after free failed logins for
+// a key (a username or client IP), each further attempt must wait twice as
+// long as the one before, until the key is locked out for loginLockout.
+type loginThrottle struct {
+	free     int
+	mu       sync.Mutex
+	failures map[string]*loginFailures
+}
+
+type loginFailures struct {
+	count int
+	last  time.Time
+}
+
+func newLoginThrottle(free int) *loginThrottle {
+	return &loginThrottle{free: free, failures: make(map[string]*loginFailures)}
+}
+
+// wait returns how long key must wait before its next attempt, or zero.
+func (t *loginThrottle) wait(key string) time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	f := t.failures[key]
+	if f == nil || f.count < t.free {
+		return 0
+	}
+	return max(time.Until(f.last.Add(backoff(f.count-t.free))), 0)
+}
+
+// backoff returns the wait after n failures past the free ones.
+func backoff(n int) time.Duration {
+	if n >= 20 {
+		return loginLockout
+	}
+	return min(loginBackoffBase<<n, loginLockout)
+}
+
+// fail records a failed login for key.
+func (t *loginThrottle) fail(key string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := time.Now()
+	for k, f := range t.failures {
+		if now.Sub(f.last) > loginFailureMemory {
+			delete(t.failures, k)
+		}
+	}
+	f := t.failures[key]
+	if f == nil {
+		f = &loginFailures{}
+		t.failures[key] = f
+	}
+	f.count++
+	f.last = now
+}
+
+// reset forgets key's failures after a successful login.
+func (t *loginThrottle) reset(key string) {
+	t.mu.Lock()
+	delete(t.failures, key)
+	t.mu.Unlock()
+}
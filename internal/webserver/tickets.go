@@ -0,0 +1,103 @@
+package webserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zsprackett/agent-workspace/internal/db"
+)
+
+const (
+	// ticketTTL is how long a ticket may wait to be used.
+	ticketTTL = 30 * time.Second
+	// terminalGrantTTL is how long a terminal cookie stays valid after its
+	// last use.
+	terminalGrantTTL = time.Hour
+	// terminalCookie holds a terminal grant.
+	terminalCookie = "aw_terminal"
+)
+
+// ticketStore holds short-lived random credentials that stand in for an
+// access token where a URL has to carry one: single-use tickets for the
+// event stream and terminal pages, and the reusable grants a terminal page
+// keeps in a cookie for the requests and websocket that follow it.
+type ticketStore struct {
+	mu      sync.Mutex
+	tickets map[string]*ticket
+}
+
+type ticket struct {
+	principal *Principal
+	prefix    string // URL path the ticket is good for
+	expires   time.Time
+}
+
+func newTicketStore() *ticketStore {
+	return &ticketStore{tickets: make(map[string]*ticket)}
+}
+
+// issue returns a new ticket for p, valid for paths under prefix for ttl.
+func (ts *ticketStore) issue(p *Principal, prefix string, ttl time.Duration) string {
+	id, _ := GenerateRefreshToken()
+	now := time.Now()
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	for k, t := range ts.tickets {
+		if now.After(t.expires) {
+			delete(ts.tickets, k)
+		}
+	}
+	ts.tickets[id] = &ticket{principal: p, prefix: prefix, expires: now.Add(ttl)}
+	return id
+}
+
+// take redeems a single-use ticket for path.
+func (ts *ticketStore) take(id, path string) *Principal {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	t := ts.tickets[id]
+	if t == nil || !strings.HasPrefix(path, t.prefix) {
+		return nil
+	}
+	delete(ts.tickets, id)
+	if time.Now().After(t.expires) {
+		return nil
+	}
+	return t.principal
+}
+
+// get returns the principal of a reusable grant for path and extends the
+// grant by ttl.
+func (ts *ticketStore) get(id, path string, ttl time.Duration) *Principal {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	t := ts.tickets[id]
+	if t == nil || !strings.HasPrefix(path, t.prefix) || time.Now().After(t.expires) {
+		return nil
+	}
+	t.expires = time.Now().Add(ttl)
+	return t.principal
+}
+
+// terminalPrefix returns the /terminal/{id}/ prefix of a terminal path.
+func terminalPrefix(path string) string {
+	id, _, _ := strings.Cut(strings.TrimPrefix(path, "/terminal/"), "/")
+	return "/terminal/" + id + "/"
+}
+
+// handleTicket issues the caller a single-use ticket for opening the event
+// stream or a terminal, where the URL has to carry the credential.
+func (s *Server) handleTicket(w http.ResponseWriter, r *http.Request) {
+	p, ok := r.Context().Value(principalKey).(*Principal)
+	if !ok {
+		p = &Principal{Role: db.RoleAdmin}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"ticket":     s.tickets.issue(p, "", ticketTTL),
+		"expires_in": int(ticketTTL.Seconds()),
+	})
+}
@@ -0,0 +1,289 @@
+package webserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/zsprackett/agent-workspace/internal/db"
+	"github.com/zsprackett/agent-workspace/internal/totp"
+)
+
+const (
+	// totpIssuer names the service in authenticator apps.
+	totpIssuer = "agent-workspace"
+	// recoveryCodeCount is how many recovery codes an account gets.
+	recoveryCodeCount = 10
+)
+
+// totpError answers a login that needs a second factor with a JSON 401 the
+// login page acts on: "totp_required", "totp_setup_required" (with the
+// pending secret and its URI) or "invalid_code".
+func totpError(w http.ResponseWriter, code string, extra map[string]string) {
+	body := map[string]string{"error": code}
+	for k, v := range extra {
+		body[k] = v
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusUnauthorized)
+	json.NewEncoder(w).Encode(body)
+}
+
+// verifySecondFactor checks code against the account's enabled TOTP secret,
+// or uses it up as a recovery code. A one-time password is refused if its
+// time step was already used.
+func (s *Server) verifySecondFactor(acc *db.Account, code string) bool {
+	if totp.IsRecoveryCode(code) {
+		ok, err := s.store.UseRecoveryCode(acc.ID, totp.HashRecoveryCode(code))
+		return err == nil && ok
+	}
+	step, ok := totp.Verify(acc.TOTPSecret, code, time.Now())
+	if !ok {
+		return false
+	}
+	ok, err := s.store.UseTOTPStep(acc.ID, step)
+	return err == nil && ok
+}
+
+// enableTOTP confirms the account's pending secret with code, turns on
+// two-factor login and returns the new recovery codes. It returns nil if
+// the code is wrong.
+func (s *Server) enableTOTP(acc *db.Account, code string) ([]string, error) {
+	step, ok := totp.Verify(acc.TOTPSecret, code, time.Now())
+	if !ok {
+		return nil, nil
+	}
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	if err := s.store.EnableTOTP(acc.ID, hashes); err != nil {
+		return nil, err
+	}
+	s.store.UseTOTPStep(acc.ID, step)
+	return codes, nil
+}
+
+// pendingTOTPSecret returns the secret the account is enrolling with,
+// creating one if needed.
+func (s *Server) pendingTOTPSecret(acc *db.Account) (string, error) {
+	if acc.TOTPSecret != "" {
+		return acc.TOTPSecret, nil
+	}
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return "", err
+	}
+	if err := s.store.SetTOTPSecret(acc.ID, secret); err != nil {
+		return "", err
+	}
+	acc.TOTPSecret = secret
+	return secret, nil
+}
+
+// newRecoveryCodes returns fresh recovery codes and the hashes to store.
+func newRecoveryCodes() (codes, hashes []string, err error) {
+	codes, err = totp.RecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		return nil, nil, err
+	}
+	hashes = make([]string, len(codes))
+	for i, c := range codes {
+		hashes[i] = totp.HashRecoveryCode(c)
+	}
+	return codes, hashes, nil
+}
+
+// checkSecondFactor runs the second step of a login for an account whose
+// password was accepted. It answers the request itself and returns false
+// unless the login may go on; recovery codes are returned when the account
+// enrolled as part of this login.
+func (s *Server) checkSecondFactor(w http.ResponseWriter, acc *db.Account, code string) ([]string, bool) {
+	if acc.TOTPEnabled {
+		if code == "" {
+			totpError(w, "totp_required", nil)
+			return nil, false
+		}
+		if !s.verifySecondFactor(acc, code) {
+			totpError(w, "invalid_code", nil)
+			return nil, false
+		}
+		return nil, true
+	}
+	if !s.cfg.Auth.RequireTOTP {
+		return nil, true
+	}
+	// Two-factor login is required but the account has not enrolled: the
+	// password lets it enroll, and the first valid code completes the login.
+	secret, err := s.pendingTOTPSecret(acc)
+	if err != nil {
+		http.Error(w, "internal error", 500)
+		return nil, false
+	}
+	setup := map[string]string{"secret": secret, "uri": totp.URI(totpIssuer, acc.Username, secret)}
+	if code == "" {
+		totpError(w, "totp_setup_required", setup)
+		return nil, false
+	}
+	codes, err := s.enableTOTP(acc, code)
+	if err != nil {
+		http.Error(w, "internal error", 500)
+		return nil, false
+	}
+	if codes == nil {
+		totpError(w, "invalid_code", setup)
+		return nil, false
+	}
+	return codes, true
+}
+
+// meAccount returns the signed-in caller's account, answering the request
+// itself if there is none.
+func (s *Server) meAccount(w http.ResponseWriter, r *http.Request) *db.Account {
+	name := requestUsername(r)
+	if name == "" {
+		http.Error(w, "not signed in", 400)
+		return nil
+	}
+	acc, err := s.store.GetAccountByUsername(name)
+	if err != nil {
+		http.Error(w, "account not found", 404)
+		return nil
+	}
+	setAuditTarget(r, acc.Username, "")
+	return acc
+}
+
+// decodeCode reads the {"code": ...} body of the /api/me/totp routes.
+func decodeCode(w http.ResponseWriter, r *http.Request) (string, bool) {
+	var body struct {
+		Code string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Code == "" {
+		http.Error(w, "code is required", 400)
+		return "", false
+	}
+	return body.Code, true
+}
+
+// handleTOTPSetup starts TOTP enrollment for the caller and returns the
+// secret and its provisioning URI.
+func (s *Server) handleTOTPSetup(w http.ResponseWriter, r *http.Request) {
+	acc := s.meAccount(w, r)
+	if acc == nil {
+		return
+	}
+	if acc.TOTPEnabled {
+		http.Error(w, "two-factor authentication is already enabled", 409)
+		return
+	}
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if err := s.store.SetTOTPSecret(acc.ID, secret); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"secret": secret,
+		"uri":    totp.URI(totpIssuer, acc.Username, secret),
+	})
+}
+
+// handleTOTPEnable confirms enrollment with a code from the authenticator
+// and returns the recovery codes.
+func (s *Server) handleTOTPEnable(w http.ResponseWriter, r *http.Request) {
+	acc := s.meAccount(w, r)
+	if acc == nil {
+		return
+	}
+	code, ok := decodeCode(w, r)
+	if !ok {
+		return
+	}
+	if acc.TOTPEnabled {
+		http.Error(w, "two-factor authentication is already enabled", 409)
+		return
+	}
+	if acc.TOTPSecret == "" {
+		http.Error(w, "two-factor setup has not been started", 400)
+		return
+	}
+	codes, err := s.enableTOTP(acc, code)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if codes == nil {
+		http.Error(w, "invalid code", 403)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"recovery_codes": codes})
+}
+
+// handleTOTPDisable turns off two-factor login for the caller, given a
+// current code or a recovery code. It is refused when the server requires
+// two-factor login.
+func (s *Server) handleTOTPDisable(w http.ResponseWriter, r *http.Request) {
+	acc := s.meAccount(w, r)
+	if acc == nil {
+		return
+	}
+	code, ok := decodeCode(w, r)
+	if !ok {
+		return
+	}
+	if s.cfg.Auth.RequireTOTP {
+		http.Error(w, "two-factor authentication is required on this server", 403)
+		return
+	}
+	if !acc.TOTPEnabled {
+		http.Error(w, "two-factor authentication is not enabled", 409)
+		return
+	}
+	if !s.verifySecondFactor(acc, code) {
+		http.Error(w, "invalid code", 403)
+		return
+	}
+	if err := s.store.DisableTOTP(acc.ID); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	w.WriteHeader(204)
+}
+
+// handleRecoveryCodes replaces the caller's recovery codes, given a current
+// code, and returns the new ones.
+func (s *Server) handleRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	acc := s.meAccount(w, r)
+	if acc == nil {
+		return
+	}
+	code, ok := decodeCode(w, r)
+	if !ok {
+		return
+	}
+	if !acc.TOTPEnabled {
+		http.Error(w, "two-factor authentication is not enabled", 409)
+		return
+	}
+	if !s.verifySecondFactor(acc, code) {
+		http.Error(w, "invalid code", 403)
+		return
+	}
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if err := s.store.ReplaceRecoveryCodes(acc.ID, hashes); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"recovery_codes": codes})
+}
@@ -0,0 +1,166 @@
+package webserver_test
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/zsprackett/agent-workspace/internal/db"
+	"github.com/zsprackett/agent-workspace/internal/session"
+	"github.com/zsprackett/agent-workspace/internal/totp"
+	"github.com/zsprackett/agent-workspace/internal/webserver"
+)
+
+type loginResult struct {
+	Error         string   `json:"error"`
+	Secret        string   `json:"secret"`
+	URI           string   `json:"uri"`
+	AccessToken   string   `json:"access_token"`
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+func login(t *testing.T, srv *webserver.Server, body string) (int, loginResult) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w, httptest.NewRequest("POST", "/api/auth/login", strings.NewReader(body)))
+	var res loginResult
+	json.NewDecoder(w.Body).Decode(&res)
+	return w.Code, res
+}
+
+func TestTOTPLogin(t *testing.T) {
+	srv, store := newAuthServer(t)
+	token, _ := webserver.IssueAccessToken("test-secret", webserver.Principal{Username: "alice", Role: db.RoleAdmin}, time.Hour)
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(w, req)
+		return w
+	}
+
+	w := do("POST", "/api/me/totp", "")
+	var setup struct{ Secret, URI string }
+	json.NewDecoder(w.Body).Decode(&setup)
+	if w.Code != 200 || setup.Secret == "" || !strings.HasPrefix(setup.URI, "otpauth://totp/") {
+		t.Fatalf("setup: %d %+v", w.Code, setup)
+	}
+	// Until enrollment is confirmed the password alone still works.
+	if code, _ := login(t, srv, `{"username":"alice","password":"password"}`); code != 200 {
+		t.Fatalf("login before enable: %d", code)
+	}
+	if w := do("POST", "/api/me/totp/enable", `{"code":"000000"}`); w.Code != 403 {
+		t.Errorf("enable with wrong code: expected 403, got %d", w.Code)
+	}
+	now, _ := totp.Code(setup.Secret, time.Now())
+	w = do("POST", "/api/me/totp/enable", `{"code":"`+now+`"}`)
+	var enabled struct {
+		RecoveryCodes []string `json:"recovery_codes"`
+	}
+	json.NewDecoder(w.Body).Decode(&enabled)
+	if w.Code != 200 || len(enabled.RecoveryCodes) != 10 {
+		t.Fatalf("enable: %d %+v", w.Code, enabled)
+	}
+
+	if code, res := login(t, srv, `{"username":"alice","password":"password"}`); code != 401 || res.Error != "totp_required" {
+		t.Errorf("login without code: %d %+v", code, res)
+	}
+	if code, res := login(t, srv, `{"username":"alice","password":"password","code":"000000"}`); code != 401 || res.Error != "invalid_code" {
+		t.Errorf("login with wrong code: %d %+v", code, res)
+	}
+	// The code that enabled two-factor login cannot be replayed.
+	if code, _ := login(t, srv, `{"username":"alice","password":"password","code":"`+now+`"}`); code != 401 {
+		t.Errorf("replayed code: expected 401, got %d", code)
+	}
+	next, _ := totp.Code(setup.Secret, time.Now().Add(30*time.Second))
+	if code, res := login(t, srv, `{"username":"alice","password":"password","code":"`+next+`"}`); code != 200 || res.AccessToken == "" {
+		t.Errorf("login with code: %d %+v", code, res)
+	}
+
+	// Recovery codes work once each.
+	body := `{"username":"alice","password":"password","code":"` + enabled.RecoveryCodes[0] + `"}`
+	if code, _ := login(t, srv, body); code != 200 {
+		t.Errorf("login with recovery code: %d", code)
+	}
+	if code, _ := login(t, srv, body); code != 401 {
+		t.Errorf("reused recovery code: expected 401, got %d", code)
+	}
+	w = do("GET", "/api/me", "")
+	var me struct {
+		TOTPEnabled   bool `json:"totp_enabled"`
+		RecoveryCodes int  `json:"recovery_codes"`
+	}
+	json.NewDecoder(w.Body).Decode(&me)
+	if !me.TOTPEnabled || me.RecoveryCodes != 9 {
+		t.Errorf("me: %+v", me)
+	}
+
+	// Disabling needs a second factor.
+	if w := do("DELETE", "/api/me/totp", `{"code":"000000"}`); w.Code != 403 {
+		t.Errorf("disable with wrong code: expected 403, got %d", w.Code)
+	}
+	if w := do("DELETE", "/api/me/totp", `{"code":"`+enabled.RecoveryCodes[1]+`"}`); w.Code != 204 {
+		t.Fatalf("disable: %d %s", w.Code, w.Body.String())
+	}
+	if code, _ := login(t, srv, `{"username":"alice","password":"password"}`); code != 200 {
+		t.Errorf("login after disable: %d", code)
+	}
+	entries, _ := store.QueryAudit(db.AuditFilter{Action: "account."})
+	var results []string
+	for _, e := range entries {
+		results = append(results, e.Action+" "+e.Result)
+	}
+	want := "account.totp_disable ok,account.totp_disable denied,account.totp_enable ok,account.totp_enable denied"
+	if got := strings.Join(results, ","); got != want {
+		t.Errorf("audit entries: %s", got)
+	}
+}
+
+func TestRequireTOTP(t *testing.T) {
+	store, _ := db.Open(":memory:")
+	store.Migrate()
+	t.Cleanup(func() { store.Close() })
+	srv := webserver.New(store, session.NewManager(store), webserver.Config{
+		Enabled: true,
+		Auth:    webserver.AuthConfig{JWTSecret: "test-secret", RequireTOTP: true},
+	})
+	hash, _ := bcrypt.GenerateFromPassword([]byte("password"), bcrypt.DefaultCost)
+	acc, _ := store.CreateAccount("alice", string(hash), db.RoleAdmin)
+	// A login from before two-factor login was required.
+	store.CreateRefreshToken("old-token", acc.ID, time.Now().Add(time.Hour))
+
+	code, res := login(t, srv, `{"username":"alice","password":"password"}`)
+	if code != 401 || res.Error != "totp_setup_required" || res.Secret == "" {
+		t.Fatalf("login: %d %+v", code, res)
+	}
+	// Asking again shows the same secret.
+	if _, again := login(t, srv, `{"username":"alice","password":"password"}`); again.Secret != res.Secret {
+		t.Errorf("secret changed: %q != %q", again.Secret, res.Secret)
+	}
+	w := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w, httptest.NewRequest("POST", "/api/auth/refresh", strings.NewReader(`{"refresh_token":"old-token"}`)))
+	if w.Code != 401 {
+		t.Errorf("refresh without two-factor login: expected 401, got %d", w.Code)
+	}
+
+	otp, _ := totp.Code(res.Secret, time.Now())
+	code, done := login(t, srv, `{"username":"alice","password":"password","code":"`+otp+`"}`)
+	if code != 200 || done.AccessToken == "" || len(done.RecoveryCodes) != 10 {
+		t.Fatalf("enroll at login: %d %+v", code, done)
+	}
+	if got, _ := store.GetAccountByUsername("alice"); !got.TOTPEnabled {
+		t.Error("two-factor login not enabled")
+	}
+
+	req := httptest.NewRequest("DELETE", "/api/me/totp", strings.NewReader(`{"code":"`+done.RecoveryCodes[0]+`"}`))
+	req.Header.Set("Authorization", "Bearer "+done.AccessToken)
+	w = httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w, req)
+	if w.Code != 403 {
+		t.Errorf("disable while required: expected 403, got %d", w.Code)
+	}
+}
@@ -5,20 +5,24 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
-	"net/http/httputil"
-	"net/url"
 	"os"
 	"path/filepath"
-	"sync"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/google/uuid"
 	"golang.org/x/crypto/acme/autocert"
 	"golang.org/x/crypto/bcrypt"
 
+	"github.com/zsprackett/agent-workspace/internal/audit"
+	"github.com/zsprackett/agent-workspace/internal/budget"
 	"github.com/zsprackett/agent-workspace/internal/db"
 	"github.com/zsprackett/agent-workspace/internal/events"
 	"github.com/zsprackett/agent-workspace/internal/git"
+	"github.com/zsprackett/agent-workspace/internal/gitdiff"
+	"github.com/zsprackett/agent-workspace/internal/hooks"
 	"github.com/zsprackett/agent-workspace/internal/session"
 	"github.com/zsprackett/agent-workspace/internal/tmux"
 )
@@ -34,97 +38,199 @@ type TLSConfig struct {
 type AuthConfig struct {
 	JWTSecret       string
 	RefreshTokenTTL string // parsed duration, e.g. "168h"
+	RequireTOTP     bool   // accounts without TOTP must enroll at login
 }
 
 type Config struct {
 	Enabled           bool
 	Port              int
 	Host              string
+	PublicURL         string // base URL of share links; derived from Host and Port if empty
 	TLS               TLSConfig
 	Auth              AuthConfig
 	ReposDir          string
 	WorktreesDir      string
 	DefaultBaseBranch string
+	MetricsAddr       string // if set, /metrics is also served here without authentication
 }
 
+const (
+	// sseLogSize is how many recent events are kept for clients that
+	// reconnect.
+	sseLogSize = 1000
+	// sseHeartbeat is how often an idle event stream gets a heartbeat.
+	sseHeartbeat = 15 * time.Second
+)
+
 type Server struct {
 	store   *db.DB
 	manager *session.Manager
 	cfg     Config
-	mu      sync.Mutex
-	clients map[chan events.Event]struct{}
-	ttyd    *ttydManager
+	// The bus events are published to, and the subscription that copies
+	// them into events, the recent events numbered for replay.
+	bus     *events.Bus
+	sub     *events.Subscription
+	events  *events.Log
+	tickets *ticketStore
+	// Open terminal websockets per session.
+	terminals *terminalManager
+	// Share link viewers streaming now.
+	shareViewers atomic.Int64
+	// Failed logins per username and per client IP.
+	userThrottle *loginThrottle
+	ipThrottle   *loginThrottle
+	// budget, if set, pauses session creation and is reported with usage.
+	budget *budget.Guard
 }
 
+// New returns a server publishing to a bus of its own; call SetBus to share
+// the application's.
 func New(store *db.DB, manager *session.Manager, cfg Config) *Server {
-	return &Server{
-		store:   store,
-		manager: manager,
-		cfg:     cfg,
-		clients: make(map[chan events.Event]struct{}),
-		ttyd:    newTTYDManager(),
+	s := &Server{
+		store:     store,
+		manager:   manager,
+		cfg:       cfg,
+		events:    events.NewLog(sseLogSize),
+		tickets:   newTicketStore(),
+		terminals: newTerminalManager(),
+		// A shared address may see other users' typos too.
+		userThrottle: newLoginThrottle(3),
+		ipThrottle:   newLoginThrottle(10),
+	}
+	s.SetBus(events.NewBus())
+	return s
+}
+
+// SetBus makes the server publish its changes to b and stream everything
+// published on b to web clients. Must be called before Start.
+func (s *Server) SetBus(b *events.Bus) {
+	if s.sub != nil {
+		s.sub.Close()
+	}
+	s.bus = b
+	// Durable, so no event is lost on the way to the log. Streams read the
+	// log at their own pace, and one that falls more than sseLogSize events
+	// behind is told to resync.
+	s.sub = b.Subscribe("sse", true, func(e events.Event) { s.events.Append(e) })
+}
+
+// SetBudget refuses new sessions while g has them paused, and reports g's
+// status with usage. Must be called before Start.
+func (s *Server) SetBudget(g *budget.Guard) {
+	s.budget = g
+}
+
+// checkBudget writes a 429 and returns false if new sessions are paused.
+func (s *Server) checkBudget(w http.ResponseWriter) bool {
+	if s.budget == nil {
+		return true
+	}
+	if err := s.budget.CheckCreate(); err != nil {
+		http.Error(w, err.Error(), http.StatusTooManyRequests)
+		return false
 	}
+	return true
 }
 
-// Broadcast implements events.Broadcaster.
+// Broadcast implements events.Broadcaster by publishing e on the bus.
 func (s *Server) Broadcast(e events.Event) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	for ch := range s.clients {
-		select {
-		case ch <- e:
-		default:
-		}
-	}
+	s.bus.Broadcast(e)
 }
 
-func (s *Server) addClient(ch chan events.Event) {
-	s.mu.Lock()
-	s.clients[ch] = struct{}{}
-	s.mu.Unlock()
+// handleEventStats reports how well each event bus subscriber keeps up.
+func (s *Server) handleEventStats(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.bus.Stats())
 }
 
-func (s *Server) removeClient(ch chan events.Event) {
-	s.mu.Lock()
-	delete(s.clients, ch)
-	s.mu.Unlock()
+// refreshDirty rechecks whether the session has uncommitted changes after a
+// git action and reports a change.
+func (s *Server) refreshDirty(sess *db.Session) {
+	if sess.WorktreePath == "" {
+		return
+	}
+	if dirty, changed, err := session.RefreshDirty(s.store, sess); err == nil && changed {
+		s.Broadcast(events.Event{Type: events.GitDirtyChanged, SessionID: sess.ID, Dirty: &dirty})
+	}
 }
 
 func (s *Server) Handler() http.Handler {
 	mux := http.NewServeMux()
 	mux.HandleFunc("GET /cert", s.handleCert)
-	mux.HandleFunc("POST /api/auth/login", s.handleLogin)
+	mux.HandleFunc("POST /api/auth/login", s.audited(audit.Login, s.handleLogin))
 	mux.HandleFunc("POST /api/auth/refresh", s.handleRefresh)
 	mux.HandleFunc("POST /api/auth/logout", s.handleLogout)
+	// Read-only routes are open to every role; the rest need an operator in
+	// the session's group. Terminals and session creation check the caller's
+	// role themselves. Mutating routes are audited; terminals record their
+	// opening themselves.
+	mux.HandleFunc("GET /api/me", s.handleMe)
+	mux.HandleFunc("POST /api/tickets", s.handleTicket)
+	mux.HandleFunc("POST /api/me/totp", s.handleTOTPSetup)
+	mux.HandleFunc("POST /api/me/totp/enable", s.audited(audit.TOTPEnable, s.handleTOTPEnable))
+	mux.HandleFunc("DELETE /api/me/totp", s.audited(audit.TOTPDisable, s.handleTOTPDisable))
+	mux.HandleFunc("POST /api/me/totp/recovery", s.audited(audit.RecoveryCodes, s.handleRecoveryCodes))
 	mux.HandleFunc("GET /api/sessions", s.handleSessions)
-	mux.HandleFunc("POST /api/sessions", s.handleCreateSession)
-	mux.HandleFunc("POST /api/sessions/{id}/notes", s.handleUpdateNotes)
-	mux.HandleFunc("POST /api/sessions/{id}/stop", s.handleStopSession)
-	mux.HandleFunc("POST /api/sessions/{id}/restart", s.handleRestartSession)
-	mux.HandleFunc("DELETE /api/sessions/{id}", s.handleDeleteSession)
+	mux.HandleFunc("POST /api/sessions", s.audited(audit.SessionCreate, s.handleCreateSession))
+	mux.HandleFunc("GET /api/groups/{path}/branches", s.handleGroupBranches)
+	mux.HandleFunc("POST /api/sessions/{id}/notes", s.audited(audit.SessionNotes, s.authorize(db.RoleOperator, s.handleUpdateNotes)))
+	mux.HandleFunc("POST /api/sessions/{id}/fork", s.audited(audit.SessionFork, s.authorize(db.RoleOperator, s.handleForkSession)))
+	mux.HandleFunc("POST /api/sessions/{id}/stop", s.audited(audit.SessionStop, s.authorize(db.RoleOperator, s.handleStopSession)))
+	mux.HandleFunc("POST /api/sessions/{id}/restart", s.audited(audit.SessionRestart, s.authorize(db.RoleOperator, s.handleRestartSession)))
+	mux.HandleFunc("DELETE /api/sessions/{id}", s.audited(audit.SessionDelete, s.authorize(db.RoleOperator, s.handleDeleteSession)))
 	mux.HandleFunc("GET /api/sessions/{id}/events", s.handleSessionEvents)
-	mux.HandleFunc("DELETE /api/sessions/{id}/ttyd", s.handleKillTTYD)
 	mux.HandleFunc("GET /api/usage", s.handleUsage)
+	mux.HandleFunc("GET /api/sessions/{id}/usage", s.handleSessionUsage)
+	mux.HandleFunc("GET /api/analytics", s.handleAnalytics)
+	mux.HandleFunc("GET /api/audit", s.authorize(db.RoleAdmin, s.handleAudit))
+	mux.HandleFunc("GET /api/events/stats", s.authorize(db.RoleAdmin, s.handleEventStats))
+	mux.HandleFunc("GET /metrics", s.authorize(db.RoleAdmin, s.handleMetrics))
+	mux.HandleFunc("GET /api/accounts", s.authorize(db.RoleAdmin, s.handleAccounts))
+	mux.HandleFunc("POST /api/accounts", s.audited(audit.AccountCreate, s.authorize(db.RoleAdmin, s.handleCreateAccount)))
+	mux.HandleFunc("PATCH /api/accounts/{account}", s.audited(audit.AccountUpdate, s.authorize(db.RoleAdmin, s.handleUpdateAccount)))
+	mux.HandleFunc("DELETE /api/accounts/{account}", s.audited(audit.AccountDelete, s.authorize(db.RoleAdmin, s.handleDeleteAccount)))
+	mux.HandleFunc("GET /api/accounts/{account}/logins", s.authorize(db.RoleAdmin, s.handleAccountLogins))
+	mux.HandleFunc("DELETE /api/accounts/{account}/logins", s.audited(audit.LoginRevoke, s.authorize(db.RoleAdmin, s.handleRevokeLogins)))
+	mux.HandleFunc("DELETE /api/accounts/{account}/logins/{login}", s.audited(audit.LoginRevoke, s.authorize(db.RoleAdmin, s.handleRevokeLogins)))
 	mux.HandleFunc("GET /api/sessions/{id}/git/status", s.handleGitStatus)
 	mux.HandleFunc("GET /api/sessions/{id}/git/diff", s.handleGitDiff)
 	mux.HandleFunc("GET /api/sessions/{id}/git/status/text", s.handleGitStatusText)
 	mux.HandleFunc("GET /api/sessions/{id}/git/diff/text", s.handleGitDiffText)
+	mux.HandleFunc("POST /api/sessions/{id}/git/stage", s.audited(audit.GitStage, s.authorize(db.RoleOperator, s.handleGitApply(gitdiff.ActionStage))))
+	mux.HandleFunc("POST /api/sessions/{id}/git/unstage", s.audited(audit.GitUnstage, s.authorize(db.RoleOperator, s.handleGitApply(gitdiff.ActionUnstage))))
+	mux.HandleFunc("POST /api/sessions/{id}/git/discard", s.audited(audit.GitDiscard, s.authorize(db.RoleOperator, s.handleGitApply(gitdiff.ActionDiscard))))
+	mux.HandleFunc("POST /api/sessions/{id}/git/commit", s.audited(audit.GitCommit, s.authorize(db.RoleOperator, s.handleGitCommit)))
+	mux.HandleFunc("GET /api/sessions/{id}/git/log", s.handleGitLog)
+	mux.HandleFunc("GET /api/sessions/{id}/git/commits/{sha}", s.handleGitCommitDetail)
+	mux.HandleFunc("GET /api/sessions/{id}/review", s.handleReviewComments)
+	mux.HandleFunc("POST /api/sessions/{id}/review/comments", s.audited(audit.ReviewComment, s.authorize(db.RoleOperator, s.handleAddReviewComment)))
+	mux.HandleFunc("DELETE /api/sessions/{id}/review/comments/{cid}", s.audited(audit.ReviewDelete, s.authorize(db.RoleOperator, s.handleDeleteReviewComment)))
+	mux.HandleFunc("POST /api/sessions/{id}/review/send", s.audited(audit.SessionInput, s.authorize(db.RoleOperator, s.handleSendReview)))
+	mux.HandleFunc("GET /api/sessions/{id}/checkpoints", s.handleCheckpoints)
+	mux.HandleFunc("POST /api/sessions/{id}/checkpoints", s.audited(audit.CheckpointCreate, s.authorize(db.RoleOperator, s.handleCreateCheckpoint)))
+	mux.HandleFunc("GET /api/sessions/{id}/checkpoints/diff", s.handleCheckpointDiff)
+	mux.HandleFunc("POST /api/sessions/{id}/checkpoints/{n}/restore", s.audited(audit.CheckpointRestore, s.authorize(db.RoleOperator, s.handleRestoreCheckpoint)))
 	mux.HandleFunc("GET /api/sessions/{id}/pr-url", s.handlePRURL)
-	mux.HandleFunc("GET /terminal/{id}/", s.handleTerminalProxy)
+	mux.HandleFunc("GET /api/sessions/{id}/compare", s.handleCompareSiblings)
+	mux.HandleFunc("GET /api/sessions/{id}/compare/{other}", s.handleCompare)
+	mux.HandleFunc("GET /api/sessions/{id}/shares", s.authorize(db.RoleOperator, s.handleShares))
+	mux.HandleFunc("POST /api/sessions/{id}/shares", s.audited(audit.ShareCreate, s.authorize(db.RoleOperator, s.handleCreateShare)))
+	mux.HandleFunc("DELETE /api/sessions/{id}/shares/{link}", s.audited(audit.ShareRevoke, s.authorize(db.RoleOperator, s.handleRevokeShare)))
+	mux.HandleFunc("GET /share/{token}/{$}", s.handleSharePage)
+	mux.HandleFunc("GET /share/{token}/stream", s.handleShareStream)
+	mux.HandleFunc("GET /terminal/{id}/{$}", s.handleTerminalPage)
+	mux.HandleFunc("GET /terminal/{id}/ws", s.handleTerminalWS)
 	mux.HandleFunc("GET /events", s.handleSSE)
 	mux.HandleFunc("GET /login", func(w http.ResponseWriter, r *http.Request) {
 		http.ServeFileFS(w, r, staticFS, "static/login.html")
 	})
 	mux.Handle("GET /", http.FileServer(staticFiles()))
 
-	if s.cfg.Auth.JWTSecret == "" {
-		return mux
+	var handler http.Handler = mux
+	if has, _ := s.store.HasAnyAccount(); has && s.cfg.Auth.JWTSecret != "" {
+		handler = s.jwtMiddleware(mux)
 	}
-	has, _ := s.store.HasAnyAccount()
-	if !has {
-		return mux
-	}
-	return jwtMiddleware(s.cfg.Auth.JWTSecret, mux)
+	return securityMiddleware(s.cfg.TLS.Mode != "", handler)
 }
 
 
@@ -137,11 +243,15 @@ func (s *Server) tlsCacheDir() string {
 }
 
 func (s *Server) Start() error {
+	if s.cfg.MetricsAddr != "" {
+		s.startMetrics()
+	}
 	if !s.cfg.Enabled {
 		return nil
 	}
 
 	handler := s.Handler()
+	go s.pruneRefreshTokens()
 
 	addr := fmt.Sprintf("%s:%d", s.cfg.Host, s.cfg.Port)
 	srv := &http.Server{Addr: addr, Handler: handler}
@@ -196,21 +306,62 @@ func (s *Server) handleLogin(w http.ResponseWriter, r *http.Request) {
 	var body struct {
 		Username string `json:"username"`
 		Password string `json:"password"`
+		Code     string `json:"code"` // TOTP or recovery code
 	}
 	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
 		http.Error(w, "bad request", 400)
 		return
 	}
+	setAuditTarget(r, body.Username, "")
+	userKey, ipKey := strings.ToLower(body.Username), clientIP(r)
+	if wait := max(s.userThrottle.wait(userKey), s.ipThrottle.wait(ipKey)); wait > 0 {
+		setAuditDetail(r, "throttled")
+		secs := int(wait.Round(time.Second).Seconds()) + 1
+		w.Header().Set("Retry-After", strconv.Itoa(secs))
+		http.Error(w, fmt.Sprintf("too many failed logins; try again in %d seconds", secs), http.StatusTooManyRequests)
+		return
+	}
+	fail := func(reason string) {
+		setAuditDetail(r, reason)
+		s.userThrottle.fail(userKey)
+		s.ipThrottle.fail(ipKey)
+	}
 	acc, err := s.store.GetAccountByUsername(body.Username)
-	if err != nil || bcrypt.CompareHashAndPassword([]byte(acc.PasswordHash), []byte(body.Password)) != nil {
+	if err != nil {
+		fail("unknown user")
+		http.Error(w, "unauthorized", 401)
+		return
+	}
+	if bcrypt.CompareHashAndPassword([]byte(acc.PasswordHash), []byte(body.Password)) != nil {
+		fail("wrong password")
 		http.Error(w, "unauthorized", 401)
 		return
 	}
+	if acc.Disabled {
+		setAuditDetail(r, "account disabled")
+		http.Error(w, "account disabled", 403)
+		return
+	}
+	recoveryCodes, ok := s.checkSecondFactor(w, acc, body.Code)
+	if !ok {
+		if body.Code != "" {
+			fail("invalid code")
+		} else {
+			setAuditDetail(r, "second factor required")
+		}
+		return
+	}
+	s.userThrottle.reset(userKey)
 	ttl, err := time.ParseDuration(s.cfg.Auth.RefreshTokenTTL)
 	if err != nil || ttl == 0 {
 		ttl = 7 * 24 * time.Hour
 	}
-	accessToken, err := IssueAccessToken(s.cfg.Auth.JWTSecret, acc.Username, time.Hour)
+	p, err := s.principalFor(acc)
+	if err != nil {
+		http.Error(w, "internal error", 500)
+		return
+	}
+	accessToken, err := IssueAccessToken(s.cfg.Auth.JWTSecret, p, time.Hour)
 	if err != nil {
 		http.Error(w, "internal error", 500)
 		return
@@ -220,15 +371,26 @@ func (s *Server) handleLogin(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "internal error", 500)
 		return
 	}
-	if err := s.store.CreateRefreshToken(refreshToken, acc.ID, time.Now().Add(ttl)); err != nil {
+	if err := s.store.SaveRefreshToken(&db.RefreshToken{
+		Token:     refreshToken,
+		AccountID: acc.ID,
+		ExpiresAt: time.Now().Add(ttl),
+		UserAgent: r.UserAgent(),
+		IP:        clientIP(r),
+	}); err != nil {
 		http.Error(w, "internal error", 500)
 		return
 	}
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{
+	s.store.RecordLogin(acc.ID)
+	resp := map[string]any{
 		"access_token":  accessToken,
 		"refresh_token": refreshToken,
-	})
+	}
+	if recoveryCodes != nil {
+		resp["recovery_codes"] = recoveryCodes
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
 }
 
 func (s *Server) handleRefresh(w http.ResponseWriter, r *http.Request) {
@@ -245,7 +407,8 @@ func (s *Server) handleRefresh(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	acc, err := s.store.GetAccountByID(rt.AccountID)
-	if err != nil {
+	// Logins from before two-factor login was required must sign in again.
+	if err != nil || acc.Disabled || (s.cfg.Auth.RequireTOTP && !acc.TOTPEnabled) {
 		http.Error(w, "unauthorized", 401)
 		return
 	}
@@ -256,9 +419,22 @@ func (s *Server) handleRefresh(w http.ResponseWriter, r *http.Request) {
 	if err != nil || ttl == 0 {
 		ttl = 7 * 24 * time.Hour
 	}
-	accessToken, _ := IssueAccessToken(s.cfg.Auth.JWTSecret, acc.Username, time.Hour)
+	p, err := s.principalFor(acc)
+	if err != nil {
+		http.Error(w, "internal error", 500)
+		return
+	}
+	accessToken, _ := IssueAccessToken(s.cfg.Auth.JWTSecret, p, time.Hour)
 	newRefreshToken, _ := GenerateRefreshToken()
-	s.store.CreateRefreshToken(newRefreshToken, acc.ID, time.Now().Add(ttl))
+	s.store.SaveRefreshToken(&db.RefreshToken{
+		Token:     newRefreshToken,
+		AccountID: acc.ID,
+		ExpiresAt: time.Now().Add(ttl),
+		LoginID:   rt.LoginID,
+		LoginAt:   rt.LoginAt,
+		UserAgent: rt.UserAgent,
+		IP:        clientIP(r),
+	})
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{
@@ -296,6 +472,39 @@ func (s *Server) handleSessions(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(sessionsResponse{Sessions: sessions, Groups: groups})
 }
 
+// handleGroupBranches lists the remote branches of a group's repo so clients
+// can offer base branch and branch name completion.
+func (s *Server) handleGroupBranches(w http.ResponseWriter, r *http.Request) {
+	path := r.PathValue("path")
+	groups, err := s.store.LoadGroups()
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	var group *db.Group
+	for _, g := range groups {
+		if g.Path == path {
+			group = g
+			break
+		}
+	}
+	if group == nil {
+		http.Error(w, "group not found", 404)
+		return
+	}
+	branches := []string{}
+	if group.RepoURL != "" {
+		list, err := session.RemoteBranches(s.cfg.ReposDir, group.RepoURL)
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		branches = append(branches, list...)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"branches": branches})
+}
+
 func (s *Server) handleUpdateNotes(w http.ResponseWriter, r *http.Request) {
 	id := r.PathValue("id")
 	var body struct {
@@ -309,7 +518,7 @@ func (s *Server) handleUpdateNotes(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), 500)
 		return
 	}
-	s.Broadcast(events.Event{Type: "notes_updated", SessionID: id})
+	s.Broadcast(events.Event{Type: events.NotesUpdated, SessionID: id, Notes: &body.Notes})
 	w.WriteHeader(204)
 }
 
@@ -324,11 +533,15 @@ func (s *Server) handleSessionEvents(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(map[string]any{"events": evts})
 }
 
+// handleSSE streams events. A client resumes after the last event it saw
+// by sending its ID as Last-Event-ID (browsers do this when they reconnect
+// on their own) or ?since=; it gets a snapshot event instead when there is
+// nothing to resume from or it missed too much. Idle streams get a
+// heartbeat every sseHeartbeat.
 func (s *Server) handleSSE(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "text/event-stream")
 	w.Header().Set("Cache-Control", "no-cache")
 	w.Header().Set("Connection", "keep-alive")
-	w.Header().Set("Access-Control-Allow-Origin", "*")
 
 	flusher, ok := w.(http.Flusher)
 	if !ok {
@@ -336,54 +549,84 @@ func (s *Server) handleSSE(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	ch := make(chan events.Event, 16)
-	s.addClient(ch)
-	defer s.removeClient(ch)
-
-	writeSSE(w, flusher, events.Event{Type: "snapshot"})
-
-	ticker := time.NewTicker(30 * time.Second)
-	defer ticker.Stop()
+	since := r.Header.Get("Last-Event-ID")
+	if since == "" {
+		since = r.URL.Query().Get("since")
+	}
+	pos, _ := strconv.ParseUint(since, 10, 64)
 
+	heartbeat := time.NewTicker(sseHeartbeat)
+	defer heartbeat.Stop()
 	for {
+		// Take the wait channel first so no append slips in between.
+		wait := s.events.Wait()
+		evs, ok := s.events.Since(pos)
+		if !ok {
+			pos = s.events.Last()
+			evs, _ = s.events.Since(pos)
+			writeSSE(w, events.Event{ID: pos, Time: time.Now(), Type: events.Snapshot})
+		}
+		for _, e := range evs {
+			writeSSE(w, e)
+			pos = e.ID
+		}
+		flusher.Flush()
+
 		select {
 		case <-r.Context().Done():
 			return
-		case e := <-ch:
-			writeSSE(w, flusher, e)
-		case <-ticker.C:
-			fmt.Fprintf(w, ": keepalive\n\n")
+		case <-wait:
+		case <-heartbeat.C:
+			writeSSE(w, events.Event{Time: time.Now(), Type: events.Heartbeat})
 			flusher.Flush()
 		}
 	}
 }
 
-func writeSSE(w http.ResponseWriter, f http.Flusher, e events.Event) {
+// writeSSE writes e as a server-sent event, with its ID as the event ID so
+// browsers resume after it.
+func writeSSE(w http.ResponseWriter, e events.Event) {
 	data, _ := json.Marshal(e)
+	if e.ID != 0 {
+		fmt.Fprintf(w, "id: %d\n", e.ID)
+	}
 	fmt.Fprintf(w, "data: %s\n\n", data)
-	f.Flush()
 }
 
 func (s *Server) handleCreateSession(w http.ResponseWriter, r *http.Request) {
 	var body struct {
-		Title       string  `json:"title"`
-		Tool        db.Tool `json:"tool"`
-		GroupPath   string  `json:"group_path"`
-		ProjectPath string  `json:"project_path"`
-		Command     string  `json:"command"`
+		Title       string   `json:"title"`
+		Tool        db.Tool  `json:"tool"`
+		GroupPath   string   `json:"group_path"`
+		ProjectPath string   `json:"project_path"`
+		Command     string   `json:"command"`
+		BaseBranch  string   `json:"base_branch"`
+		Branch      string   `json:"branch"`
+		Checkout    bool     `json:"checkout"`
+		ExtraRepos  []string `json:"extra_repos"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
 		http.Error(w, err.Error(), 400)
 		return
 	}
+	if !requestRole(r, body.GroupPath).Allows(db.RoleOperator) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	if !s.checkBudget(w) {
+		return
+	}
 
 	// Look up the group to check for a repo URL (worktree flow).
-	var groupRepoURL, preLaunchCmd string
+	var groupRepoURL, groupBaseBranch, preLaunchCmd string
+	var groupExtraRepos []string
 	if body.GroupPath != "" {
 		groups, _ := s.store.LoadGroups()
 		for _, g := range groups {
 			if g.Path == body.GroupPath {
 				groupRepoURL = g.RepoURL
+				groupBaseBranch = g.BaseBranch
+				groupExtraRepos = g.ExtraRepoURLs
 				preLaunchCmd = g.PreLaunchCommand
 				break
 			}
@@ -391,7 +634,32 @@ func (s *Server) handleCreateSession(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if groupRepoURL != "" {
-		s.handleCreateWorktreeSession(w, body.Title, body.Tool, body.GroupPath, body.Command, groupRepoURL, preLaunchCmd)
+		title := body.Title
+		if title == "" {
+			title = session.GenerateTitle()
+		}
+		plan, err := session.PlanWorktree(session.WorktreeOptions{
+			RepoURL:           groupRepoURL,
+			ExtraRepoURLs:     append(groupExtraRepos, body.ExtraRepos...),
+			ReposDir:          s.cfg.ReposDir,
+			WorktreesDir:      s.cfg.WorktreesDir,
+			Branch:            body.Branch,
+			Checkout:          body.Checkout,
+			BaseBranch:        body.BaseBranch,
+			GroupBaseBranch:   groupBaseBranch,
+			DefaultBaseBranch: s.cfg.DefaultBaseBranch,
+		}, title)
+		if err != nil {
+			http.Error(w, err.Error(), 400)
+			return
+		}
+		s.handleCreateWorktreeSession(w, r, plan, session.CreateOptions{
+			Title:     title,
+			Tool:      body.Tool,
+			GroupPath: body.GroupPath,
+			Command:   body.Command,
+			Owner:     requestUsername(r),
+		}, preLaunchCmd)
 		return
 	}
 
@@ -406,27 +674,20 @@ func (s *Server) handleCreateSession(w http.ResponseWriter, r *http.Request) {
 		GroupPath:   body.GroupPath,
 		ProjectPath: body.ProjectPath,
 		Command:     body.Command,
+		Owner:       requestUsername(r),
 	})
 	if err != nil {
 		http.Error(w, err.Error(), 500)
 		return
 	}
-	s.Broadcast(events.Event{Type: "refresh"})
+	setAuditTarget(r, sess.ID, sess.Title)
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(201)
 	json.NewEncoder(w).Encode(sess)
 }
 
-func (s *Server) handleCreateWorktreeSession(w http.ResponseWriter, title string, tool db.Tool, groupPath, command, repoURL, preLaunchCmd string) {
-	host, owner, repo, err := git.ParseRepoURL(repoURL)
-	if err != nil {
-		http.Error(w, fmt.Sprintf("invalid repo URL: %v", err), 400)
-		return
-	}
-
-	if title == "" {
-		title = session.GenerateTitle()
-	}
+func (s *Server) handleCreateWorktreeSession(w http.ResponseWriter, r *http.Request, plan *session.WorktreePlan, opts session.CreateOptions, preLaunchCmd string) {
+	title, tool, command := opts.Title, opts.Tool, opts.Command
 	if command == "" {
 		command = db.ToolCommand(tool, "")
 	}
@@ -436,79 +697,75 @@ func (s *Server) handleCreateWorktreeSession(w http.ResponseWriter, title string
 	now := time.Now()
 	sessionID := uuid.NewString()
 	pending := &db.Session{
-		ID:           sessionID,
-		Title:        title,
-		GroupPath:    groupPath,
-		Tool:         tool,
-		Command:      command,
-		Status:       db.StatusCreating,
-		CreatedAt:    now,
-		LastAccessed: now,
-		SortOrder:    len(sessions),
-		RepoURL:      repoURL,
+		ID:              sessionID,
+		Title:           title,
+		GroupPath:       opts.GroupPath,
+		Tool:            tool,
+		Command:         command,
+		Status:          db.StatusCreating,
+		CreatedAt:       now,
+		LastAccessed:    now,
+		SortOrder:       len(sessions),
+		RepoURL:         plan.RepoURL,
+		ParentSessionID: opts.ParentSessionID,
+		Notes:           opts.Notes,
+		Owner:           opts.Owner,
 	}
 	if err := s.store.SaveSession(pending); err != nil {
 		http.Error(w, fmt.Sprintf("create failed: %v", err), 500)
 		return
 	}
-	_ = s.store.InsertSessionEvent(sessionID, "created", "")
+	if pending.ParentSessionID != "" {
+		_ = s.store.InsertSessionEvent(sessionID, "forked", pending.ParentSessionID)
+	} else {
+		_ = s.store.InsertSessionEvent(sessionID, "created", "")
+	}
 	s.store.Touch()
-	s.Broadcast(events.Event{Type: "refresh"})
+	setAuditTarget(r, sessionID, title)
+	// The goroutine below keeps updating pending, so broadcast a copy.
+	created := *pending
+	s.Broadcast(events.Event{Type: events.SessionCreated, SessionID: sessionID, Session: &created})
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(202)
 	json.NewEncoder(w).Encode(pending)
 
-	bareRepoPath := git.BareRepoPath(s.cfg.ReposDir, host, owner, repo)
-	branch := git.SanitizeBranchName(title)
-	wtPath := git.WorktreePath(s.cfg.WorktreesDir, host, owner, repo, branch)
+	bareRepoPath := plan.RepoPath
+	branch := plan.Branch
+	rootPath := plan.Root
 
+	progress := func(step, msg string) {
+		s.Broadcast(events.Event{Type: events.ProvisioningProgress, SessionID: sessionID, Title: title, Step: step, Message: msg})
+	}
 	cancelCreate := func(msg string) {
+		progress(events.StepFailed, msg)
 		s.store.DeleteSession(sessionID)
 		_ = s.store.Touch()
-		s.Broadcast(events.Event{Type: "refresh"})
+		s.Broadcast(events.Event{Type: events.SessionDeleted, SessionID: sessionID})
 	}
 
 	go func() {
-		// 1. Ensure bare repo directory exists.
-		if err := os.MkdirAll(filepath.Dir(bareRepoPath), 0755); err != nil {
-			cancelCreate(fmt.Sprintf("create repos dir failed: %v", err))
+		progress(events.StepWorktree, "")
+		// 1-4. Clone or fetch the bare repo and create the worktree; reuse it
+		// if it already exists.
+		if err := plan.Provision(); err != nil && !errors.Is(err, git.ErrWorktreeExists) {
+			plan.Cleanup()
+			cancelCreate(fmt.Sprintf("create worktree failed: %v", err))
 			return
 		}
-
-		// 2. Clone or fetch the bare repo.
-		if !git.IsBareRepo(bareRepoPath) {
-			if err := git.CloneBare(repoURL, bareRepoPath); err != nil {
-				cancelCreate(fmt.Sprintf("clone failed: %v", err))
-				return
-			}
-		} else {
-			if err := git.FetchBare(bareRepoPath); err != nil {
-				cancelCreate(fmt.Sprintf("fetch failed: %v", err))
-				return
+		// Claude reports its state through hooks; without them the monitor
+		// reads the pane.
+		if tool == db.ToolClaude {
+			if err := hooks.Install(rootPath, sessionID); err != nil {
+				progress(events.StepWorktree, fmt.Sprintf("install Claude hooks failed, reading status from the pane: %v", err))
 			}
 		}
 
-		// 3. Ensure worktree parent directory exists.
-		if err := os.MkdirAll(filepath.Dir(wtPath), 0755); err != nil {
-			cancelCreate(fmt.Sprintf("create worktrees dir failed: %v", err))
-			return
-		}
-
-		// 4. Create the worktree; reuse if it already exists.
-		baseBranch := s.cfg.DefaultBaseBranch
-		if baseBranch == "" {
-			baseBranch = "main"
-		}
-		if _, err := git.CreateWorktree(bareRepoPath, branch, wtPath, baseBranch); err != nil && !errors.Is(err, git.ErrWorktreeExists) {
-			cancelCreate(fmt.Sprintf("create worktree failed: %v", err))
-			return
-		}
-
 		// 5. Run pre-launch command if set.
 		if preLaunchCmd != "" {
+			progress(events.StepPreLaunch, preLaunchCmd)
 			toolCmd := db.ToolCommand(tool, "")
-			out, err := session.RunPreLaunchCommand(preLaunchCmd, toolCmd, bareRepoPath, wtPath)
+			out, err := session.RunPreLaunchCommand(preLaunchCmd, toolCmd, bareRepoPath, rootPath)
 			if err != nil {
 				cancelCreate(fmt.Sprintf("pre-launch command failed: %v\n%s", err, out))
 				return
@@ -516,11 +773,13 @@ func (s *Server) handleCreateWorktreeSession(w http.ResponseWriter, title string
 		}
 
 		// 6. Create the tmux session.
+		progress(events.StepStarting, "")
 		tmuxName := tmux.GenerateSessionName(title)
 		if err := tmux.CreateSession(tmux.CreateOptions{
 			Name:    tmuxName,
 			Command: command,
-			Cwd:     wtPath,
+			Cwd:     rootPath,
+			Env:     opts.Env,
 		}); err != nil {
 			cancelCreate(fmt.Sprintf("create tmux session failed: %v", err))
 			return
@@ -529,28 +788,84 @@ func (s *Server) handleCreateWorktreeSession(w http.ResponseWriter, title string
 		// 7. Update the DB row to running.
 		pending.TmuxSession = tmuxName
 		pending.Status = db.StatusRunning
-		pending.ProjectPath = wtPath
-		pending.WorktreePath = wtPath
+		pending.ProjectPath = rootPath
+		pending.WorktreePath = plan.WorktreePath
 		pending.WorktreeRepo = bareRepoPath
 		pending.WorktreeBranch = branch
 		pending.LastAccessed = time.Now()
-		if err := s.store.SaveSession(pending); err != nil {
+		err := s.store.SaveSession(pending)
+		if err == nil && plan.MultiRepo() {
+			err = s.store.SaveSessionWorktrees(sessionID, plan.Worktrees())
+		}
+		if err != nil {
 			tmux.KillSession(tmuxName)
 			cancelCreate(fmt.Sprintf("save failed: %v", err))
 			return
 		}
 		_ = s.store.Touch()
-		s.Broadcast(events.Event{Type: "refresh"})
+		progress(events.StepReady, "")
+		s.Broadcast(events.SessionState(s.store, sessionID))
 	}()
 }
 
+// handleForkSession starts a new session whose worktrees branch from the
+// parent session's current HEAD, optionally with its uncommitted changes.
+func (s *Server) handleForkSession(w http.ResponseWriter, r *http.Request) {
+	parent, err := s.store.GetSession(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if parent == nil {
+		http.Error(w, "session not found", 404)
+		return
+	}
+	if !s.checkBudget(w) {
+		return
+	}
+	var body struct {
+		Title       string `json:"title"`
+		Branch      string `json:"branch"`
+		CopyChanges bool   `json:"copy_changes"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	title := body.Title
+	if title == "" {
+		title = session.DefaultForkTitle(parent)
+	}
+	plan, err := session.PlanFork(s.store, parent, session.ForkOptions{
+		ReposDir:     s.cfg.ReposDir,
+		WorktreesDir: s.cfg.WorktreesDir,
+		Branch:       body.Branch,
+		CopyChanges:  body.CopyChanges,
+	}, title)
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	var preLaunchCmd string
+	groups, _ := s.store.LoadGroups()
+	for _, g := range groups {
+		if g.Path == parent.GroupPath {
+			preLaunchCmd = g.PreLaunchCommand
+			break
+		}
+	}
+	opts := session.ForkCreateOptions(parent, title)
+	opts.Owner = requestUsername(r)
+	s.handleCreateWorktreeSession(w, r, plan, opts, preLaunchCmd)
+}
+
 func (s *Server) handleStopSession(w http.ResponseWriter, r *http.Request) {
 	id := r.PathValue("id")
 	if err := s.manager.Stop(id); err != nil {
 		http.Error(w, err.Error(), 500)
 		return
 	}
-	s.Broadcast(events.Event{Type: "refresh"})
+	s.terminals.close(id)
 	w.WriteHeader(204)
 }
 
@@ -560,27 +875,37 @@ func (s *Server) handleRestartSession(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), 500)
 		return
 	}
-	s.Broadcast(events.Event{Type: "refresh"})
 	w.WriteHeader(204)
 }
 
-// handleTerminalProxy spawns ttyd on demand and reverse-proxies all traffic
-// (HTTP + WebSocket) through our server so remote clients (e.g. iOS) can reach
-// it without needing direct access to 127.0.0.1:<port>.
-func (s *Server) handleTerminalProxy(w http.ResponseWriter, r *http.Request) {
-	id := r.PathValue("id")
-	sess, err := s.store.GetSession(id)
-	if err != nil || sess == nil || sess.TmuxSession == "" {
-		http.Error(w, "session not found", 404)
-		return
+// handleMe returns the caller's username and roles so the UI can hide
+// actions it may not take, and the state of their two-factor login.
+// Without authentication the caller is an admin.
+func (s *Server) handleMe(w http.ResponseWriter, r *http.Request) {
+	p, ok := r.Context().Value(principalKey).(*Principal)
+	if !ok {
+		p = &Principal{Role: db.RoleAdmin}
 	}
-	port, err := s.ttyd.spawn(id, sess.TmuxSession)
-	if err != nil {
-		http.Error(w, err.Error(), 503)
-		return
+	groups := p.Groups
+	if groups == nil {
+		groups = map[string]db.Role{}
+	}
+	totpEnabled, recoveryLeft := false, 0
+	if p.Username != "" {
+		if acc, err := s.store.GetAccountByUsername(p.Username); err == nil {
+			totpEnabled = acc.TOTPEnabled
+			recoveryLeft, _ = s.store.CountRecoveryCodes(acc.ID)
+		}
 	}
-	target, _ := url.Parse(fmt.Sprintf("http://127.0.0.1:%d", port))
-	httputil.NewSingleHostReverseProxy(target).ServeHTTP(w, r)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"username":       p.Username,
+		"role":           p.Role,
+		"groups":         groups,
+		"totp_enabled":   totpEnabled,
+		"totp_required":  s.cfg.Auth.RequireTOTP,
+		"recovery_codes": recoveryLeft,
+	})
 }
 
 // handleCert serves the self-signed certificate as a download so clients
@@ -601,19 +926,70 @@ func (s *Server) handleCert(w http.ResponseWriter, r *http.Request) {
 	w.Write(data)
 }
 
-func (s *Server) handleKillTTYD(w http.ResponseWriter, r *http.Request) {
-	s.ttyd.kill(r.PathValue("id"))
-	w.WriteHeader(204)
-}
-
+// handleUsage returns the account's usage snapshots and budget status, and
+// what each session and group used according to their Claude transcripts
+// over the last ?days= days (default 7).
 func (s *Server) handleUsage(w http.ResponseWriter, r *http.Request) {
+	days := 7
+	if v := r.URL.Query().Get("days"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			http.Error(w, "invalid days", 400)
+			return
+		}
+		days = n
+	}
+	since := time.Now().AddDate(0, 0, -days)
 	latest, _ := s.store.GetLatestUsageSnapshot()
 	history, _ := s.store.GetUsageSnapshots(48)
+	sessions, err := s.store.SessionUsageTotals(since)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	groups, err := s.store.GroupUsageTotals(since)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if sessions == nil {
+		sessions, groups = []db.UsageTotal{}, []db.UsageTotal{}
+	}
+	out := map[string]any{
+		"latest":   latest,
+		"history":  history,
+		"sessions": sessions,
+		"groups":   groups,
+	}
+	if s.budget != nil {
+		out["budget"] = s.budget.Status()
+	}
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]any{
-		"latest":  latest,
-		"history": history,
-	})
+	json.NewEncoder(w).Encode(out)
+}
+
+// handleSessionUsage returns a session's hourly token usage by model over
+// the last ?hours= hours (default 48).
+func (s *Server) handleSessionUsage(w http.ResponseWriter, r *http.Request) {
+	hours := 48
+	if v := r.URL.Query().Get("hours"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			http.Error(w, "invalid hours", 400)
+			return
+		}
+		hours = n
+	}
+	usage, err := s.store.GetSessionUsage(r.PathValue("id"), time.Now().Add(-time.Duration(hours)*time.Hour))
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if usage == nil {
+		usage = []db.SessionUsage{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(usage)
 }
 
 func (s *Server) handleDeleteSession(w http.ResponseWriter, r *http.Request) {
@@ -622,6 +998,6 @@ func (s *Server) handleDeleteSession(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), 500)
 		return
 	}
-	s.Broadcast(events.Event{Type: "refresh"})
+	s.terminals.close(id)
 	w.WriteHeader(204)
 }
@@ -3,6 +3,8 @@ package webserver_test
 import (
 	"encoding/json"
 	"fmt"
+	"io"
+	"log/slog"
 	"net/http/httptest"
 	"strings"
 	"testing"
@@ -10,6 +12,7 @@ import (
 
 	"golang.org/x/crypto/bcrypt"
 
+	"github.com/zsprackett/agent-workspace/internal/budget"
 	"github.com/zsprackett/agent-workspace/internal/db"
 	"github.com/zsprackett/agent-workspace/internal/session"
 	"github.com/zsprackett/agent-workspace/internal/webserver"
@@ -32,7 +35,7 @@ func newAuthServer(t *testing.T) (*webserver.Server, *db.DB) {
 	})
 	// seed an account
 	hash, _ := bcrypt.GenerateFromPassword([]byte("password"), bcrypt.DefaultCost)
-	store.CreateAccount("alice", string(hash))
+	store.CreateAccount("alice", string(hash), db.RoleAdmin)
 	return srv, store
 }
 
@@ -138,7 +141,7 @@ func TestProtectedEndpointRequiresAuth(t *testing.T) {
 
 func TestProtectedEndpointWithValidToken(t *testing.T) {
 	srv, _ := newAuthServer(t)
-	token, _ := webserver.IssueAccessToken("test-secret", "alice", time.Hour)
+	token, _ := webserver.IssueAccessToken("test-secret", webserver.Principal{Username: "alice", Role: db.RoleAdmin}, time.Hour)
 	req := httptest.NewRequest("GET", "/api/sessions", nil)
 	req.Header.Set("Authorization", "Bearer "+token)
 	w := httptest.NewRecorder()
@@ -148,6 +151,86 @@ func TestProtectedEndpointWithValidToken(t *testing.T) {
 	}
 }
 
+func TestRoleEnforcement(t *testing.T) {
+	srv, store := newAuthServer(t)
+	now := time.Now()
+	for _, s := range []*db.Session{
+		{ID: "work-id", Title: "w", GroupPath: "work", Tool: db.ToolShell, Status: db.StatusStopped, CreatedAt: now, LastAccessed: now},
+		{ID: "other-id", Title: "o", GroupPath: "other", Tool: db.ToolShell, Status: db.StatusStopped, CreatedAt: now, LastAccessed: now},
+	} {
+		if err := store.SaveSession(s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// bob views everything and operates in "work".
+	store.CreateAccount("bob", "x", db.RoleViewer)
+	token, _ := webserver.IssueAccessToken("test-secret", webserver.Principal{
+		Username: "bob",
+		Role:     db.RoleViewer,
+		Groups:   map[string]db.Role{"work": db.RoleOperator},
+	}, time.Hour)
+	do := func(method, path, body string) int {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(w, req)
+		return w.Code
+	}
+
+	if code := do("GET", "/api/sessions", ""); code != 200 {
+		t.Errorf("viewer list: expected 200, got %d", code)
+	}
+	if code := do("GET", "/api/sessions/other-id/events", ""); code != 200 {
+		t.Errorf("viewer events: expected 200, got %d", code)
+	}
+	if code := do("DELETE", "/api/sessions/other-id", ""); code != 403 {
+		t.Errorf("viewer delete: expected 403, got %d", code)
+	}
+	if code := do("POST", "/api/sessions/other-id/notes", `{"notes":"x"}`); code != 403 {
+		t.Errorf("viewer notes: expected 403, got %d", code)
+	}
+	if code := do("POST", "/api/sessions", `{"group_path":"other","project_path":"/tmp"}`); code != 403 {
+		t.Errorf("viewer create: expected 403, got %d", code)
+	}
+	if code := do("POST", "/api/sessions/work-id/notes", `{"notes":"x"}`); code != 204 {
+		t.Errorf("operator notes in own group: expected 204, got %d", code)
+	}
+
+	req := httptest.NewRequest("GET", "/api/me", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w, req)
+	var me struct {
+		Username string
+		Role     string
+		Groups   map[string]string
+	}
+	json.NewDecoder(w.Body).Decode(&me)
+	if me.Username != "bob" || me.Role != "viewer" || me.Groups["work"] != "operator" {
+		t.Errorf("me: %+v", me)
+	}
+}
+
+func TestLoginTokenCarriesRole(t *testing.T) {
+	srv, store := newAuthServer(t)
+	hash, _ := bcrypt.GenerateFromPassword([]byte("pw"), bcrypt.MinCost)
+	acc, _ := store.CreateAccount("pm", string(hash), db.RoleViewer)
+	store.SetGroupPermission(acc.ID, "work", db.RoleOperator)
+
+	req := httptest.NewRequest("POST", "/api/auth/login", strings.NewReader(`{"username":"pm","password":"pw"}`))
+	w := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w, req)
+	var resp map[string]string
+	json.NewDecoder(w.Body).Decode(&resp)
+	p, err := webserver.ValidateAccessToken("test-secret", resp["access_token"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Role != db.RoleViewer || p.RoleIn("work") != db.RoleOperator {
+		t.Errorf("principal: %+v", p)
+	}
+}
+
 func TestCertEndpointRemainsPublic(t *testing.T) {
 	srv, _ := newAuthServer(t)
 	req := httptest.NewRequest("GET", "/cert", nil)
@@ -202,6 +285,81 @@ func TestSessionsEndpoint(t *testing.T) {
 	}
 }
 
+func TestUsageEndpoint(t *testing.T) {
+	store, _ := db.Open(":memory:")
+	store.Migrate()
+	defer store.Close()
+
+	now := time.Now()
+	store.SaveSession(&db.Session{ID: "s1", Title: "busy", GroupPath: "g", Tool: db.ToolClaude, CreatedAt: now, LastAccessed: now})
+	store.ReplaceSessionUsage("s1", []db.SessionUsage{
+		{HourMs: now.Truncate(time.Hour).UnixMilli(), Model: "claude-sonnet-4-5", Messages: 2, InputTokens: 100, OutputTokens: 10, CostUSD: 0.5},
+		{HourMs: now.Add(-10 * 24 * time.Hour).Truncate(time.Hour).UnixMilli(), Model: "claude-sonnet-4-5", Messages: 1, CostUSD: 9},
+	})
+
+	mgr := session.NewManager(store)
+	handler := webserver.New(store, mgr, webserver.Config{Port: 0, Host: "127.0.0.1", Enabled: true}).Handler()
+
+	var result struct {
+		Sessions []db.UsageTotal `json:"sessions"`
+		Groups   []db.UsageTotal `json:"groups"`
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/api/usage", nil))
+	if w.Code != 200 {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+	json.NewDecoder(w.Body).Decode(&result)
+	if len(result.Sessions) != 1 || result.Sessions[0].Title != "busy" || result.Sessions[0].CostUSD != 0.5 {
+		t.Errorf("unexpected session totals %+v", result.Sessions)
+	}
+	if len(result.Groups) != 1 || result.Groups[0].GroupPath != "g" {
+		t.Errorf("unexpected group totals %+v", result.Groups)
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/api/usage?days=30", nil))
+	json.NewDecoder(w.Body).Decode(&result)
+	if len(result.Sessions) != 1 || result.Sessions[0].CostUSD != 9.5 {
+		t.Errorf("expected 30 days to include older usage, got %+v", result.Sessions)
+	}
+
+	var series []db.SessionUsage
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/api/sessions/s1/usage", nil))
+	json.NewDecoder(w.Body).Decode(&series)
+	if len(series) != 1 || series[0].InputTokens != 100 {
+		t.Errorf("unexpected hourly usage %+v", series)
+	}
+}
+
+func TestCreateSessionPausedByBudget(t *testing.T) {
+	store, _ := db.Open(":memory:")
+	store.Migrate()
+	defer store.Close()
+	store.InsertUsageSnapshot(db.UsageSnapshot{TsMs: time.Now().UnixMilli(), FiveHourUtil: 93})
+
+	mgr := session.NewManager(store)
+	srv := webserver.New(store, mgr, webserver.Config{Port: 0, Host: "127.0.0.1", Enabled: true})
+	srv.SetBudget(budget.New(budget.Config{PauseAt: 90}, store, mgr, slog.New(slog.NewTextHandler(io.Discard, nil))))
+	handler := srv.Handler()
+
+	req := httptest.NewRequest("POST", "/api/sessions", strings.NewReader(`{"title":"t","tool":"shell","project_path":"/tmp"}`))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != 429 {
+		t.Fatalf("expected 429 while paused, got %d: %s", w.Code, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/api/usage", nil))
+	var usage struct{ Budget db.BudgetStatus }
+	json.NewDecoder(w.Body).Decode(&usage)
+	if usage.Budget.Level != budget.LevelPaused || usage.Budget.FiveHour.Util != 93 {
+		t.Errorf("unexpected budget %+v", usage.Budget)
+	}
+}
+
 func TestStopSessionEndpoint(t *testing.T) {
 	store, _ := db.Open(":memory:")
 	store.Migrate()
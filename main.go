@@ -10,10 +10,13 @@ import (
 	"golang.org/x/term"
 
 	"github.com/zsprackett/agent-workspace/internal/applog"
+	"github.com/zsprackett/agent-workspace/internal/audit"
 	"github.com/zsprackett/agent-workspace/internal/config"
 	"github.com/zsprackett/agent-workspace/internal/db"
 	"github.com/zsprackett/agent-workspace/internal/tmux"
 	"github.com/zsprackett/agent-workspace/internal/ui"
+	"github.com/zsprackett/agent-workspace/internal/ui/checkpointcmd"
+	"github.com/zsprackett/agent-workspace/internal/ui/comparecmd"
 	"github.com/zsprackett/agent-workspace/internal/ui/gitlogcmd"
 	"github.com/zsprackett/agent-workspace/internal/ui/menucmd"
 	"github.com/zsprackett/agent-workspace/internal/ui/notescmd"
@@ -54,6 +57,24 @@ func main() {
 		return
 	}
 
+	// compare subcommand: invoked from within a tmux session via display-popup
+	if len(os.Args) == 3 && os.Args[1] == "compare" {
+		if err := comparecmd.Run(os.Args[2]); err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	// checkpoints subcommand: invoked from within a tmux session via display-popup
+	if len(os.Args) == 3 && os.Args[1] == "checkpoints" {
+		if err := checkpointcmd.Run(os.Args[2]); err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	// menu subcommand: invoked via the Ctrl+\ leader key inside a session.
 	// panePath is not passed as an argument; tmux sets display-popup CWD to the
 	// active pane's directory, so menucmd reads it via os.Getwd().
@@ -65,31 +86,76 @@ func main() {
 		return
 	}
 
-	if len(os.Args) >= 3 && os.Args[1] == "adduser" {
-		username := os.Args[2]
-		fmt.Printf("Password for %s: ", username)
-		pw, err := term.ReadPassword(int(os.Stdin.Fd()))
-		fmt.Println()
-		if err != nil {
+	// hook subcommand: run by the Claude Code hooks installed in worktrees.
+	if len(os.Args) >= 2 && os.Args[1] == "hook" {
+		if err := runHook(os.Args[2:]); err != nil {
 			fmt.Fprintf(os.Stderr, "error: %v\n", err)
 			os.Exit(1)
 		}
-		hash, err := bcrypt.GenerateFromPassword(pw, bcrypt.DefaultCost)
-		if err != nil {
+		return
+	}
+
+	if len(os.Args) >= 2 && os.Args[1] == "gc" {
+		if err := runGC(os.Args[2:]); err != nil {
 			fmt.Fprintf(os.Stderr, "error: %v\n", err)
 			os.Exit(1)
 		}
-		store, err := openDB()
-		if err != nil {
+		return
+	}
+
+	if len(os.Args) >= 2 && os.Args[1] == "new" {
+		if err := runNew(os.Args[2:]); err != nil {
 			fmt.Fprintf(os.Stderr, "error: %v\n", err)
 			os.Exit(1)
 		}
-		defer store.Close()
-		if _, err := store.CreateAccount(username, string(hash)); err != nil {
-			fmt.Fprintf(os.Stderr, "error creating account: %v\n", err)
+		return
+	}
+
+	if len(os.Args) >= 2 && os.Args[1] == "adduser" {
+		if err := runAddUser(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if len(os.Args) >= 2 && os.Args[1] == "audit" {
+		if err := runAudit(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if len(os.Args) >= 2 && os.Args[1] == "user" {
+		if err := runUser(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if len(os.Args) >= 2 && os.Args[1] == "token" {
+		if err := runToken(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if len(os.Args) >= 2 && os.Args[1] == "totp" {
+		if err := runTOTP(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if len(os.Args) >= 2 && os.Args[1] == "role" {
+		if err := runRole(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
 			os.Exit(1)
 		}
-		fmt.Printf("Account created: %s\n", username)
 		return
 	}
 
@@ -118,8 +184,16 @@ func main() {
 			fmt.Fprintf(os.Stderr, "error: user not found: %v\n", err)
 			os.Exit(1)
 		}
-		store.UpdateAccountPassword(acc.ID, string(hash))
-		store.DeleteRefreshTokensByAccount(acc.ID)
+		err = store.UpdateAccountPassword(acc.ID, string(hash))
+		audit.Local(store, audit.SourceCLI, audit.AccountPassword, username, "", err)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(1)
+		}
+		if err := store.DeleteRefreshTokensByAccount(acc.ID); err != nil {
+			fmt.Fprintf(os.Stderr, "error: password updated but sessions not invalidated: %v\n", err)
+			os.Exit(1)
+		}
 		fmt.Printf("Password updated: %s (all sessions invalidated)\n", username)
 		return
 	}